@@ -17,10 +17,10 @@ limitations under the License.
 package etcd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
 
 	"github.com/zoomoid/go-ipfix"
@@ -219,8 +219,7 @@ func (f *FieldCache) initialize(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		kkey := ipfix.FieldKey{}
-		err = kkey.UnmarshalText(e.Key)
+		kkey, err := f.keyOf(e.Key)
 		if err != nil {
 			return err
 		}
@@ -262,9 +261,7 @@ func (f *FieldCache) updateLocalFields(ctx context.Context, events []*clientv3.E
 	for _, e := range events {
 		element := e.Kv
 
-		kkey := strings.TrimPrefix(string(element.Key), f.prefix)
-		key := ipfix.FieldKey{}
-		err := key.Unmarshal(kkey)
+		key, err := f.keyOf(element.Key)
 		if err != nil {
 			return err
 		}
@@ -285,6 +282,16 @@ func (f *FieldCache) updateLocalFields(ctx context.Context, events []*clientv3.E
 	return nil
 }
 
+// keyOf parses the field key from the key of an etcd entry of the cache
+func (f *FieldCache) keyOf(etcdKey []byte) (ipfix.FieldKey, error) {
+	key := ipfix.FieldKey{}
+	err := key.UnmarshalText(bytes.TrimPrefix(etcdKey, []byte(f.prefix)))
+	if err != nil {
+		return key, fmt.Errorf("failed to parse field key %s, %w", string(etcdKey), err)
+	}
+	return key, nil
+}
+
 func (f *FieldCache) put(ctx context.Context, key ipfix.FieldKey, ie *ipfix.InformationElement) (*clientv3.PutResponse, error) {
 	etcdKey := f.prefix + key.String()
 	eei, err := json.Marshal(ie)
@@ -17,10 +17,10 @@ limitations under the License.
 package etcd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
 
 	"github.com/zoomoid/go-ipfix"
@@ -195,8 +195,7 @@ func (t *TemplateCache) Initialize(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		kkey := ipfix.TemplateKey{}
-		err = kkey.UnmarshalText(e.Key)
+		kkey, err := t.keyOf(e.Key)
 		if err != nil {
 			return err
 		}
@@ -277,10 +276,7 @@ func (t *TemplateCache) updateLocalTemplates(ctx context.Context, events []*clie
 	for _, e := range events {
 		element := e.Kv
 
-		// need to remove the key prefix here first...
-		kkey := strings.TrimPrefix(string(element.Key), t.prefix)
-		key := ipfix.TemplateKey{}
-		err := key.Unmarshal(kkey)
+		key, err := t.keyOf(element.Key)
 		if err != nil {
 			return err
 		}
@@ -301,6 +297,16 @@ func (t *TemplateCache) updateLocalTemplates(ctx context.Context, events []*clie
 	return nil
 }
 
+// keyOf parses the template key from the key of an etcd entry of the cache
+func (t *TemplateCache) keyOf(etcdKey []byte) (ipfix.TemplateKey, error) {
+	key := ipfix.TemplateKey{}
+	err := key.UnmarshalText(bytes.TrimPrefix(etcdKey, []byte(t.prefix)))
+	if err != nil {
+		return key, fmt.Errorf("failed to parse template key %s, %w", string(etcdKey), err)
+	}
+	return key, nil
+}
+
 func (t *TemplateCache) put(ctx context.Context, key ipfix.TemplateKey, template *ipfix.Template) (*clientv3.PutResponse, error) {
 	etcdKey := t.prefix + key.String()
 	tmpl, err := json.Marshal(template)
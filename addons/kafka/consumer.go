@@ -0,0 +1,347 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+// DecodedMessage is a message decoded by DecodingConsumer together with the headers of its record
+type DecodedMessage struct {
+	Exporter   string
+	ReceivedAt time.Time
+	Message    *ipfix.Message
+}
+
+// OutputFormat is the format of records written by DecodingConsumer to an output topic
+type OutputFormat int
+
+const (
+	// OutputMessages writes a record per message containing the JSON encoding of ipfix.Message
+	OutputMessages OutputFormat = iota
+	// OutputFlatRecords writes a record per data record containing a flat JSON object, mapping field
+	// names to values as by ipfix.DataRecord.Flatten next to the exporter, receive time, and header of
+	// the message and the template id of the record. Options template and template sets are not written.
+	OutputFlatRecords
+)
+
+// DefaultConsumerIdleTimeout is the duration after which the decoder of an exporter without records
+// is released, if the consumer is not configured with WithIdleTimeout
+const DefaultConsumerIdleTimeout time.Duration = 30 * time.Minute
+
+// DecodingConsumer consumes raw IPFIX messages published by MessageProducer and decodes them with an
+// ipfix.Decoder using caches created by the injected factories. Decoded messages are emitted on
+// Messages, or, if configured with WithOutput, written to another topic.
+//
+// As templates are scoped to the transport session they were received on, the messages of every
+// exporter, i.e., every value of the HeaderExporter header, are decoded with their own decoder and
+// template cache. Decoders of exporters without records for the idle timeout are released.
+//
+// Records are committed after their message was emitted. Messages that fail to decode are logged and
+// committed nevertheless, as they will never succeed to decode.
+type DecodingConsumer struct {
+	reader Reader
+
+	templates func(exporter string) ipfix.TemplateCache
+	fields    func(templates ipfix.TemplateCache) (ipfix.FieldCache, error)
+
+	// decoders are the decoders of exporters, only used by Run
+	decoders    map[string]*exporterDecoder
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	// now is used in tests for controlling the clock of the consumer
+	now func() time.Time
+
+	decoderOpts []ipfix.DecoderOption
+
+	// output and format are set if decoded messages are written to a topic instead of messageCh
+	output Writer
+	format OutputFormat
+
+	messageCh chan *DecodedMessage
+}
+
+// exporterDecoder is the decoder of an exporter's messages together with its template cache
+type exporterDecoder struct {
+	decoder   *ipfix.Decoder
+	templates ipfix.TemplateCache
+	lastSeen  time.Time
+}
+
+type ConsumerOption func(*DecodingConsumer)
+
+// WithOutput makes the consumer write decoded messages to a topic with writer in the given format
+// instead of emitting them on Messages. Output records keep the key of their input record, such that
+// the partitioning of MessageProducer is preserved.
+func WithOutput(writer Writer, format OutputFormat) ConsumerOption {
+	return func(c *DecodingConsumer) {
+		c.output = writer
+		c.format = format
+	}
+}
+
+// WithDecoderOptions sets the options of the decoder, e.g., ipfix.WithSkipUnknownTemplates
+func WithDecoderOptions(opts ...ipfix.DecoderOption) ConsumerOption {
+	return func(c *DecodingConsumer) {
+		c.decoderOpts = append(c.decoderOpts, opts...)
+	}
+}
+
+// WithIdleTimeout sets the duration after which the decoder and template cache of an exporter
+// without records are released. An idleTimeout of zero disables releasing decoders.
+func WithIdleTimeout(idleTimeout time.Duration) ConsumerOption {
+	return func(c *DecodingConsumer) {
+		if idleTimeout >= 0 {
+			c.idleTimeout = idleTimeout
+		}
+	}
+}
+
+// WithMessageBufferSize sets the buffer size of the channel returned by Messages, unbuffered by default
+func WithMessageBufferSize(size int) ConsumerOption {
+	return func(c *DecodingConsumer) {
+		c.messageCh = make(chan *DecodedMessage, size)
+	}
+}
+
+// NewDecodingConsumer creates a consumer reading messages with reader. The messages of each exporter
+// are decoded with a decoder using the template cache created by templates for the exporter and the
+// field cache created by fields from the template cache. If templates is nil, exporters use
+// ipfix.NewDefaultEphemeralCache, if fields is nil, exporters use ipfix.NewIANAFieldCache.
+//
+// Consumers sharing the templates of exporters, e.g., when consuming from a topic partitioned with
+// PartitionRoundRobin, create the template caches of exporters from a shared store, e.g., using
+// addons/redis.
+func NewDecodingConsumer(reader Reader, templates func(exporter string) ipfix.TemplateCache, fields func(templates ipfix.TemplateCache) (ipfix.FieldCache, error), opts ...ConsumerOption) *DecodingConsumer {
+	if templates == nil {
+		templates = func(string) ipfix.TemplateCache {
+			return ipfix.NewDefaultEphemeralCache()
+		}
+	}
+	if fields == nil {
+		fields = func(templates ipfix.TemplateCache) (ipfix.FieldCache, error) {
+			return ipfix.NewIANAFieldCache(templates), nil
+		}
+	}
+	c := &DecodingConsumer{
+		reader:      reader,
+		templates:   templates,
+		fields:      fields,
+		decoders:    make(map[string]*exporterDecoder),
+		idleTimeout: DefaultConsumerIdleTimeout,
+		now:         time.Now,
+		messageCh:   make(chan *DecodedMessage),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Messages returns the channel of decoded messages. It is closed when Run returns and remains empty
+// if the consumer is configured with WithOutput.
+func (c *DecodingConsumer) Messages() <-chan *DecodedMessage {
+	return c.messageCh
+}
+
+// Run consumes records until ctx is cancelled or reading fails. Run returns an error without committing
+// the current record if creating the decoder of its exporter or emitting its decoded message to the
+// output topic fails, such that it is consumed again after restarting.
+func (c *DecodingConsumer) Run(ctx context.Context) error {
+	logger := ipfix.FromContext(ctx)
+	defer close(c.messageCh)
+	defer c.releaseAll(ctx)
+
+	for {
+		record, err := c.reader.FetchRecord(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch record, %w", err)
+		}
+
+		decoded := &DecodedMessage{}
+		if exporter, ok := record.Header(HeaderExporter); ok {
+			decoded.Exporter = string(exporter)
+		}
+		if receivedAt, ok := record.Header(HeaderReceivedAt); ok {
+			decoded.ReceivedAt, _ = time.Parse(time.RFC3339Nano, string(receivedAt))
+		}
+
+		// failing to create a decoder does not depend on the record, hence it is not committed
+		decoder, err := c.decoder(ctx, decoded.Exporter)
+		if err != nil {
+			return fmt.Errorf("failed to create decoder of exporter %s, %w", decoded.Exporter, err)
+		}
+		decoded.Message, err = decoder.Decode(ctx, bytes.NewBuffer(record.Value))
+		if err != nil {
+			logger.Error(err, "failed to decode IPFIX message", "exporter", decoded.Exporter, "partition", record.Partition, "offset", record.Offset)
+		} else {
+			err = c.emit(ctx, record, decoded)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+
+		err = c.reader.CommitRecords(ctx, record)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to commit record, %w", err)
+		}
+	}
+}
+
+// decoder returns the decoder of exporter, creating it if the exporter has none yet, and releases
+// the decoders of idle exporters
+func (c *DecodingConsumer) decoder(ctx context.Context, exporter string) (*ipfix.Decoder, error) {
+	now := c.now()
+	if c.idleTimeout > 0 && now.Sub(c.lastSweep) >= c.idleTimeout {
+		c.lastSweep = now
+		for e, d := range c.decoders {
+			if e != exporter && now.Sub(d.lastSeen) >= c.idleTimeout {
+				c.release(ctx, e, d)
+			}
+		}
+	}
+
+	d, ok := c.decoders[exporter]
+	if !ok {
+		templates := c.templates(exporter)
+		if templates == nil {
+			return nil, errors.New("template cache is nil")
+		}
+		fields, err := c.fields(templates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create field cache, %w", err)
+		}
+		d = &exporterDecoder{
+			decoder:   ipfix.NewDecoderWithOptions(templates, fields, c.decoderOpts...),
+			templates: templates,
+		}
+		c.decoders[exporter] = d
+	}
+	d.lastSeen = now
+	return d.decoder, nil
+}
+
+// release drops the decoder of exporter and closes its template cache if it is stateful. The cache
+// is closed even if ctx is cancelled, e.g., when Run returns.
+func (c *DecodingConsumer) release(ctx context.Context, exporter string, d *exporterDecoder) {
+	logger := ipfix.FromContext(ctx)
+	logger.V(2).Info("releasing decoder of exporter", "exporter", exporter)
+	delete(c.decoders, exporter)
+	if sc, ok := d.templates.(ipfix.StatefulTemplateCache); ok {
+		if err := sc.Close(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(err, "failed to close template cache of exporter", "exporter", exporter)
+		}
+	}
+}
+
+// releaseAll releases the decoders of all exporters
+func (c *DecodingConsumer) releaseAll(ctx context.Context) {
+	for exporter, d := range c.decoders {
+		c.release(ctx, exporter, d)
+	}
+}
+
+// emit passes the decoded message of record to the channel or the output topic
+func (c *DecodingConsumer) emit(ctx context.Context, record Record, decoded *DecodedMessage) error {
+	if c.output == nil {
+		select {
+		case c.messageCh <- decoded:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var values [][]byte
+	switch c.format {
+	case OutputFlatRecords:
+		for _, flat := range flatRecords(decoded) {
+			b, err := json.Marshal(flat)
+			if err != nil {
+				return fmt.Errorf("failed to marshal record, %w", err)
+			}
+			values = append(values, b)
+		}
+	default:
+		b, err := json.Marshal(decoded.Message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message, %w", err)
+		}
+		values = append(values, b)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	records := make([]Record, 0, len(values))
+	for _, v := range values {
+		records = append(records, Record{
+			Key:     record.Key,
+			Value:   v,
+			Headers: record.Headers,
+			Time:    record.Time,
+		})
+	}
+	err := c.output.WriteRecords(ctx, records...)
+	if err != nil {
+		return fmt.Errorf("failed to write decoded message, %w", err)
+	}
+	return nil
+}
+
+// flatRecords converts the data records of a decoded message to flat objects for OutputFlatRecords
+func flatRecords(decoded *DecodedMessage) []map[string]any {
+	msg := decoded.Message
+	flat := make([]map[string]any, 0)
+	for _, set := range msg.Sets {
+		ds, ok := set.Set.(*ipfix.DataSet)
+		if !ok {
+			continue
+		}
+		for _, dr := range ds.Records {
+			m := map[string]any{
+				"exporter":              decoded.Exporter,
+				"received_at":           decoded.ReceivedAt,
+				"export_time":           msg.ExportTime,
+				"sequence_number":       msg.SequenceNumber,
+				"observation_domain_id": msg.ObservationDomainId,
+				"template_id":           dr.TemplateId,
+			}
+			for k, v := range dr.Flatten() {
+				m[k] = v
+			}
+			flat = append(flat, m)
+		}
+	}
+	return flat
+}
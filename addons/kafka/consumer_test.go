@@ -0,0 +1,250 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+func TestDecodingConsumer(t *testing.T) {
+	receivedAt := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	// publish publishes msg to a new topic
+	publish := func(t *testing.T, msgs ...[]byte) *memoryTopic {
+		topic := newMemoryTopic()
+		p := NewMessageProducer(topic, WithExporter("192.0.2.1:4739"))
+		p.now = func() time.Time { return receivedAt }
+		for _, msg := range msgs {
+			if err := p.Publish(context.Background(), p.exporter, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return topic
+	}
+
+	t.Run("messages on channel", func(t *testing.T) {
+		msg := newTestMessages(t, 42, 3)
+		input := publish(t, msg)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := NewDecodingConsumer(input, nil, nil)
+		done := make(chan error)
+		go func() { done <- c.Run(ctx) }()
+
+		decoded := <-c.Messages()
+		if decoded.Exporter != "192.0.2.1:4739" || !decoded.ReceivedAt.Equal(receivedAt) {
+			t.Errorf("expected headers of the record, got %s at %s", decoded.Exporter, decoded.ReceivedAt)
+		}
+		if decoded.Message.ObservationDomainId != 42 {
+			t.Errorf("expected observation domain 42, got %d", decoded.Message.ObservationDomainId)
+		}
+		records := 0
+		for _, s := range decoded.Message.Sets {
+			if s.Kind == "DataSet" {
+				records += s.Set.Length()
+			}
+		}
+		if records != 3 {
+			t.Errorf("expected 3 data records, got %d", records)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := <-c.Messages(); ok {
+			t.Error("expected channel to be closed after Run returned")
+		}
+		if _, committed := input.snapshot(); len(committed) != 1 {
+			t.Errorf("expected record to be committed, got %v", committed)
+		}
+	})
+
+	t.Run("flat records on output topic", func(t *testing.T) {
+		msg := newTestMessages(t, 42, 2)
+		input := publish(t, msg)
+		output := newMemoryTopic()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := NewDecodingConsumer(input, nil, nil, WithOutput(output, OutputFlatRecords))
+		done := make(chan error)
+		go func() { done <- c.Run(ctx) }()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, committed := input.snapshot(); len(committed) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for record to be consumed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		records, _ := output.snapshot()
+		if len(records) != 2 {
+			t.Fatalf("expected a record per data record, got %d", len(records))
+		}
+		if string(records[0].Key) != "192.0.2.1:4739/42" {
+			t.Errorf("expected output records to keep the key, got %q", records[0].Key)
+		}
+		flat := map[string]any{}
+		if err := json.Unmarshal(records[1].Value, &flat); err != nil {
+			t.Fatal(err)
+		}
+		if flat["sourceIPv4Address"] != "192.0.2.1" || flat["octetDeltaCount"] != float64(1001) {
+			t.Errorf("expected field values in flat record, got %v", flat)
+		}
+		if flat["observation_domain_id"] != float64(42) || flat["template_id"] != float64(256) || flat["exporter"] != "192.0.2.1:4739" {
+			t.Errorf("expected message metadata in flat record, got %v", flat)
+		}
+	})
+
+	t.Run("undecodable messages are committed", func(t *testing.T) {
+		msg := newTestMessages(t, 42, 1)
+		// a message header of an unknown version
+		malformed := []byte{0, 9, 0, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 42}
+		input := publish(t, malformed, msg)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := NewDecodingConsumer(input, nil, nil)
+		done := make(chan error)
+		go func() { done <- c.Run(ctx) }()
+
+		decoded := <-c.Messages()
+		if decoded.Message.ObservationDomainId != 42 {
+			t.Errorf("expected the valid message, got %v", decoded.Message)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if _, committed := input.snapshot(); len(committed) != 2 {
+			t.Errorf("expected both records to be committed, got %v", committed)
+		}
+	})
+
+	t.Run("exporters are decoded with their own template cache", func(t *testing.T) {
+		session := newTestSession(t, 42)
+		withTemplate, withoutTemplate := flushTestRecords(t, session, 1), flushTestRecords(t, session, 1)
+
+		// the second exporter uses the template id without defining it in its transport session
+		input := newMemoryTopic()
+		p := NewMessageProducer(input)
+		for _, r := range []struct {
+			exporter string
+			msg      []byte
+		}{
+			{"192.0.2.1:4739", withTemplate},
+			{"192.0.2.2:4739", withoutTemplate},
+			{"192.0.2.1:4739", withoutTemplate},
+		} {
+			if err := p.Publish(context.Background(), r.exporter, r.msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var exporters []string
+		templates := func(exporter string) ipfix.TemplateCache {
+			exporters = append(exporters, exporter)
+			return ipfix.NewDefaultEphemeralCache()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := NewDecodingConsumer(input, templates, nil, WithMessageBufferSize(3))
+		done := make(chan error)
+		go func() { done <- c.Run(ctx) }()
+
+		for i := 0; i < 2; i++ {
+			if decoded := <-c.Messages(); decoded.Exporter != "192.0.2.1:4739" {
+				t.Errorf("expected only messages of the exporter defining the template, got %s", decoded.Exporter)
+			}
+		}
+		waitCommitted(t, input, 3)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if len(exporters) != 2 || exporters[0] != "192.0.2.1:4739" || exporters[1] != "192.0.2.2:4739" {
+			t.Errorf("expected a template cache per exporter, got caches of %v", exporters)
+		}
+	})
+
+	t.Run("decoders of idle exporters are released", func(t *testing.T) {
+		input := newMemoryTopic()
+		p := NewMessageProducer(input)
+		for _, exporter := range []string{"192.0.2.1:4739", "192.0.2.2:4739", "192.0.2.1:4739"} {
+			if err := p.Publish(context.Background(), exporter, newTestMessages(t, 42, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var exporters []string
+		templates := func(exporter string) ipfix.TemplateCache {
+			exporters = append(exporters, exporter)
+			return ipfix.NewDefaultEphemeralCache()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := NewDecodingConsumer(input, templates, nil, WithIdleTimeout(time.Minute), WithMessageBufferSize(3))
+		// each record is consumed 2 minutes after the previous one
+		now := receivedAt
+		c.now = func() time.Time {
+			now = now.Add(2 * time.Minute)
+			return now
+		}
+		done := make(chan error)
+		go func() { done <- c.Run(ctx) }()
+
+		waitCommitted(t, input, 3)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if len(exporters) != 3 || exporters[2] != "192.0.2.1:4739" {
+			t.Errorf("expected the idle exporter to get a new template cache, got caches of %v", exporters)
+		}
+	})
+}
+
+// waitCommitted waits until n records of topic are committed
+func waitCommitted(t *testing.T, topic *memoryTopic, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, committed := topic.snapshot(); len(committed) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d records to be consumed", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+//go:build integration
+
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/kafka"
+)
+
+// TestIntegration publishes and consumes messages through a broker started with testcontainers.
+// Run with go test -tags integration ./... on a host with Docker.
+func TestIntegration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	container, err := kafka.RunContainer(ctx,
+		kafka.WithClusterID("go-ipfix"),
+		testcontainers.WithImage("confluentinc/confluent-local:7.5.0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	brokers, err := container.Brokers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const topic = "ipfix"
+	writer := &kafkago.Writer{
+		Addr:                   kafkago.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafkago.Hash{},
+		AllowAutoTopicCreation: true,
+	}
+	defer writer.Close()
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: "go-ipfix",
+	})
+	defer reader.Close()
+
+	msg := newTestMessages(t, 42, 5)
+	producer := NewMessageProducer(NewWriter(writer), WithExporter("192.0.2.1:4739"))
+	// the topic is created by the first write, which may fail until the leader is elected
+	for {
+		err := producer.Publish(ctx, "192.0.2.1:4739", msg)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	consumer := NewDecodingConsumer(NewReader(reader), nil, nil)
+	consumerCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go consumer.Run(consumerCtx)
+
+	select {
+	case decoded := <-consumer.Messages():
+		if decoded.Exporter != "192.0.2.1:4739" || decoded.Message.ObservationDomainId != 42 {
+			t.Errorf("unexpected decoded message %s from %s", decoded.Message, decoded.Exporter)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for decoded message")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kafka bridges IPFIX collectors and Kafka. MessageProducer publishes raw IPFIX messages
+// received by listeners to a topic, and DecodingConsumer decodes messages from a topic with an
+// ipfix.Decoder and emits them to a channel or another topic.
+//
+// Both components use the minimal Writer and Reader interfaces instead of a specific client, such
+// that they can be tested without a broker. NewWriter and NewReader adapt the clients of
+// github.com/segmentio/kafka-go.
+package kafka
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// HeaderExporter is the header of records carrying the address of the exporter of the message
+	HeaderExporter = "ipfix.exporter"
+	// HeaderReceivedAt is the header of records carrying the time at which the collector received
+	// the message, formatted as RFC 3339 with nanoseconds
+	HeaderReceivedAt = "ipfix.received_at"
+)
+
+// Header is a header of a Kafka record
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Record is a Kafka record written by a Writer or read from a Reader
+type Record struct {
+	Key     []byte
+	Value   []byte
+	Headers []Header
+	Time    time.Time
+
+	// Topic, Partition, and Offset are set by readers and are required for committing records
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+// Header returns the value of the first header with the given key and true, or false if the
+// record does not carry such a header.
+func (r *Record) Header(key string) ([]byte, bool) {
+	for _, h := range r.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Writer writes records to a single topic. Implementations are expected to assign records with
+// the same key to the same partition, such that their order is preserved.
+type Writer interface {
+	WriteRecords(ctx context.Context, records ...Record) error
+}
+
+// Reader reads records from a topic, e.g., as a member of a consumer group
+type Reader interface {
+	// FetchRecord blocks until the next record is available or ctx is cancelled
+	FetchRecord(ctx context.Context) (Record, error)
+	// CommitRecords marks the records as consumed
+	CommitRecords(ctx context.Context, records ...Record) error
+}
+
+// Partitioning determines the key of records published by MessageProducer, and thus, together
+// with a key-hashing Writer, the partition of messages.
+type Partitioning int
+
+const (
+	// PartitionByObservationDomain keys records by exporter and observation domain id, such that
+	// messages of an observation domain, and thus its templates and the data sets using them, stay
+	// in order within a partition. This is the default.
+	PartitionByObservationDomain Partitioning = iota
+	// PartitionByExporter keys records by exporter only, such that all messages of an exporter are
+	// in order within a partition.
+	PartitionByExporter
+	// PartitionRoundRobin does not key records. Template ordering is not preserved, consumers
+	// therefore need to share their template cache, e.g., using addons/redis.
+	PartitionRoundRobin
+)
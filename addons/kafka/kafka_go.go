@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// NewWriter adapts w to Writer. w must be configured with a topic and should use a key-hashing
+// balancer such as kafkago.Hash, such that records of the same key end up in the same partition.
+func NewWriter(w *kafkago.Writer) Writer {
+	return &writer{w: w}
+}
+
+type writer struct {
+	w *kafkago.Writer
+}
+
+func (w *writer) WriteRecords(ctx context.Context, records ...Record) error {
+	msgs := make([]kafkago.Message, 0, len(records))
+	for _, r := range records {
+		headers := make([]kafkago.Header, 0, len(r.Headers))
+		for _, h := range r.Headers {
+			headers = append(headers, kafkago.Header{Key: h.Key, Value: h.Value})
+		}
+		msgs = append(msgs, kafkago.Message{
+			Key:     r.Key,
+			Value:   r.Value,
+			Headers: headers,
+			Time:    r.Time,
+		})
+	}
+	return w.w.WriteMessages(ctx, msgs...)
+}
+
+// NewReader adapts r to Reader. r should be configured with a consumer group, as records are
+// only committed for consumer groups.
+func NewReader(r *kafkago.Reader) Reader {
+	return &reader{r: r}
+}
+
+type reader struct {
+	r *kafkago.Reader
+}
+
+func (r *reader) FetchRecord(ctx context.Context) (Record, error) {
+	msg, err := r.r.FetchMessage(ctx)
+	if err != nil {
+		return Record{}, err
+	}
+	headers := make([]Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return Record{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Time:      msg.Time,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	}, nil
+}
+
+func (r *reader) CommitRecords(ctx context.Context, records ...Record) error {
+	msgs := make([]kafkago.Message, 0, len(records))
+	for _, rec := range records {
+		msgs = append(msgs, kafkago.Message{
+			Topic:     rec.Topic,
+			Partition: rec.Partition,
+			Offset:    rec.Offset,
+		})
+	}
+	return r.r.CommitMessages(ctx, msgs...)
+}
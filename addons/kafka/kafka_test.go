@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+// memoryTopic is a single-partition topic implementing both Writer and Reader for tests
+type memoryTopic struct {
+	mu        sync.Mutex
+	records   []Record
+	committed []int64
+
+	// available is signalled for each written record
+	available chan struct{}
+	next      int
+}
+
+var _ Writer = &memoryTopic{}
+var _ Reader = &memoryTopic{}
+
+func newMemoryTopic() *memoryTopic {
+	return &memoryTopic{
+		available: make(chan struct{}, 1024),
+	}
+}
+
+func (t *memoryTopic) WriteRecords(ctx context.Context, records ...Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range records {
+		r.Topic = "memory"
+		r.Offset = int64(len(t.records))
+		t.records = append(t.records, r)
+		t.available <- struct{}{}
+	}
+	return nil
+}
+
+func (t *memoryTopic) FetchRecord(ctx context.Context) (Record, error) {
+	select {
+	case <-t.available:
+	case <-ctx.Done():
+		return Record{}, ctx.Err()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.records[t.next]
+	t.next++
+	return r, nil
+}
+
+func (t *memoryTopic) CommitRecords(ctx context.Context, records ...Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range records {
+		t.committed = append(t.committed, r.Offset)
+	}
+	return nil
+}
+
+func (t *memoryTopic) snapshot() ([]Record, []int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Record{}, t.records...), append([]int64{}, t.committed...)
+}
+
+// newTestMessages returns a message of template 256 of sourceIPv4Address and octetDeltaCount
+// followed by n data records of the template in the given observation domain
+func newTestMessages(t *testing.T, observationDomainId uint32, n int) []byte {
+	session := newTestSession(t, observationDomainId)
+	return flushTestRecords(t, session, n)
+}
+
+// newTestSession returns an export session defining template 256 of sourceIPv4Address and
+// octetDeltaCount in the given observation domain
+func newTestSession(t *testing.T, observationDomainId uint32) *ipfix.ExportSession {
+	ctx := context.Background()
+	fieldCache := ipfix.NewIANAFieldManager(ipfix.NewDefaultEphemeralCache())
+
+	fields := make([]ipfix.Field, 0, 2)
+	for _, spec := range []struct{ id, length uint16 }{{8, 4}, {1, 8}} {
+		fb, err := fieldCache.GetBuilder(ctx, ipfix.NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+
+	session := ipfix.NewExportSession(observationDomainId)
+	if err := session.DefineTemplate(ctx, 256, fields...); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// flushTestRecords returns a message of n data records of template 256 of session, preceded by the
+// template if it was not exported by session before
+func flushTestRecords(t *testing.T, session *ipfix.ExportSession, n int) []byte {
+	for i := 0; i < n; i++ {
+		if err := session.AddRecordValues(256, "192.0.2.1", uint64(1000+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &bytes.Buffer{}
+	if _, err := session.Flush(b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
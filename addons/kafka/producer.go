@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+// MessageProducer publishes raw IPFIX messages to a topic, e.g., the messages received by an
+// ipfix.UDPListener or ipfix.TCPListener. Each record carries a single message as value, and the
+// exporter and receive time as headers HeaderExporter and HeaderReceivedAt.
+type MessageProducer struct {
+	writer Writer
+
+	// exporter is the address of the exporter used for messages received by Run
+	exporter     string
+	partitioning Partitioning
+
+	// now returns the receive time of messages, overridden in tests
+	now func() time.Time
+}
+
+type ProducerOption func(*MessageProducer)
+
+// WithExporter sets the exporter address of messages published by Run. Listeners do not expose
+// the address of the exporter of each message, so this is best used with a listener per exporter,
+// or set to the address of the listener.
+func WithExporter(addr string) ProducerOption {
+	return func(p *MessageProducer) {
+		p.exporter = addr
+	}
+}
+
+// WithPartitioning sets the partitioning of published messages, PartitionByObservationDomain by default
+func WithPartitioning(partitioning Partitioning) ProducerOption {
+	return func(p *MessageProducer) {
+		p.partitioning = partitioning
+	}
+}
+
+// NewMessageProducer creates a producer publishing messages with writer
+func NewMessageProducer(writer Writer, opts ...ProducerOption) *MessageProducer {
+	p := &MessageProducer{
+		writer:       writer,
+		partitioning: PartitionByObservationDomain,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Run publishes all messages received from messages until the channel is closed or ctx is cancelled.
+// Messages that fail to be published are logged and dropped, such that a slow or unavailable broker
+// does not block the listener indefinitely.
+func (p *MessageProducer) Run(ctx context.Context, messages <-chan []byte) error {
+	logger := ipfix.FromContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			err := p.Publish(ctx, p.exporter, msg)
+			if err != nil {
+				logger.Error(err, "failed to publish IPFIX message", "exporter", p.exporter)
+			}
+		}
+	}
+}
+
+// Publish publishes a single message of the given exporter with the current time as receive time
+func (p *MessageProducer) Publish(ctx context.Context, exporter string, msg []byte) error {
+	// the message header is required for partitioning by observation domain
+	if len(msg) < 16 {
+		return fmt.Errorf("failed to publish message of %d bytes, %w", len(msg), ipfix.ErrInvalidMessageHeader)
+	}
+	receivedAt := p.now()
+
+	record := Record{
+		Key:   p.key(exporter, binary.BigEndian.Uint32(msg[12:16])),
+		Value: msg,
+		Headers: []Header{
+			{Key: HeaderExporter, Value: []byte(exporter)},
+			{Key: HeaderReceivedAt, Value: []byte(receivedAt.Format(time.RFC3339Nano))},
+		},
+		Time: receivedAt,
+	}
+	err := p.writer.WriteRecords(ctx, record)
+	if err != nil {
+		return fmt.Errorf("failed to publish message, %w", err)
+	}
+	return nil
+}
+
+// key returns the record key of messages of the given exporter and observation domain
+func (p *MessageProducer) key(exporter string, observationDomainId uint32) []byte {
+	switch p.partitioning {
+	case PartitionByExporter:
+		return []byte(exporter)
+	case PartitionRoundRobin:
+		return nil
+	default:
+		return []byte(exporter + "/" + strconv.FormatUint(uint64(observationDomainId), 10))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+func TestMessageProducer(t *testing.T) {
+	ctx := context.Background()
+	receivedAt := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("run publishes messages with headers", func(t *testing.T) {
+		msg := newTestMessages(t, 42, 2)
+		topic := newMemoryTopic()
+		p := NewMessageProducer(topic, WithExporter("192.0.2.1:4739"))
+		p.now = func() time.Time { return receivedAt }
+
+		messages := make(chan []byte, 2)
+		messages <- msg
+		messages <- msg
+		close(messages)
+		if err := p.Run(ctx, messages); err != nil {
+			t.Fatal(err)
+		}
+
+		records, _ := topic.snapshot()
+		if len(records) != 2 {
+			t.Fatalf("expected 2 records, got %d", len(records))
+		}
+		r := records[0]
+		if !bytes.Equal(r.Value, msg) {
+			t.Error("expected record to carry the raw message")
+		}
+		if string(r.Key) != "192.0.2.1:4739/42" {
+			t.Errorf("expected key of exporter and observation domain, got %q", r.Key)
+		}
+		if exporter, _ := r.Header(HeaderExporter); string(exporter) != "192.0.2.1:4739" {
+			t.Errorf("expected exporter header, got %q", exporter)
+		}
+		if ts, _ := r.Header(HeaderReceivedAt); string(ts) != "2023-11-01T12:00:00Z" {
+			t.Errorf("expected receive time header, got %q", ts)
+		}
+	})
+
+	t.Run("partitioning", func(t *testing.T) {
+		msg := newTestMessages(t, 42, 1)
+		for _, tc := range []struct {
+			partitioning Partitioning
+			key          []byte
+		}{
+			{PartitionByObservationDomain, []byte("exporter/42")},
+			{PartitionByExporter, []byte("exporter")},
+			{PartitionRoundRobin, nil},
+		} {
+			topic := newMemoryTopic()
+			if err := NewMessageProducer(topic, WithPartitioning(tc.partitioning)).Publish(ctx, "exporter", msg); err != nil {
+				t.Fatal(err)
+			}
+			records, _ := topic.snapshot()
+			if !bytes.Equal(records[0].Key, tc.key) {
+				t.Errorf("expected key %q for partitioning %d, got %q", tc.key, tc.partitioning, records[0].Key)
+			}
+		}
+	})
+
+	t.Run("short message", func(t *testing.T) {
+		err := NewMessageProducer(newMemoryTopic()).Publish(ctx, "exporter", []byte{0, 10, 0, 4})
+		if !errors.Is(err, ipfix.ErrInvalidMessageHeader) {
+			t.Errorf("expected ErrInvalidMessageHeader, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pcap replays IPFIX messages from packet captures, e.g., recorded with tcpdump, such
+// that historical exports can be fed through the decoding pipeline of a collector. Messages are
+// emitted together with the time they were captured at.
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/zoomoid/go-ipfix"
+)
+
+// Transports of the messages in envelopes
+const (
+	TransportUDP string = "udp"
+	TransportTCP string = "tcp"
+)
+
+// Envelope wraps a raw IPFIX message extracted from a capture with the metadata of the packets
+// it was carried in.
+type Envelope struct {
+	// Timestamp is the capture time of the packet containing the last byte of the message.
+	// For messages sent over UDP, this is the capture time of the datagram.
+	Timestamp time.Time
+
+	// Transport is either TransportUDP or TransportTCP
+	Transport string
+
+	// Source is the address of the exporter
+	Source netip.AddrPort
+	// Destination is the address of the collector
+	Destination netip.AddrPort
+
+	// Message contains exactly the bytes of a single IPFIX message
+	Message []byte
+}
+
+// Reader extracts IPFIX messages sent to a collector's port from a packet capture. UDP
+// datagrams are emitted as messages directly, TCP streams are reassembled, such that
+// out-of-order and retransmitted segments do not corrupt the stream, and split into messages
+// by the length field of their message headers, just like in ipfix.TCPListener.
+//
+// Both pcap and pcapng files are supported, the format is detected from the file's magic number.
+//
+//	f, _ := os.Open("capture.pcapng")
+//	r := pcap.NewReader(f, 4739)
+//	go func() {
+//		for envelope := range r.Envelopes() {
+//			msg, err := decoder.Decode(ctx, bytes.NewBuffer(envelope.Message))
+//			// ...
+//		}
+//	}()
+//	if err := r.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+type Reader struct {
+	source io.Reader
+	port   uint16
+
+	envelopeCh chan Envelope
+
+	messageCh    chan []byte
+	messagesOnce sync.Once
+}
+
+// pcapngMagic is the block type of the section header block at the beginning of every pcapng file
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// NewReader creates a new reader for a packet capture in r that extracts IPFIX messages
+// sent to the given (destination) port. It needs to be started using Start(context.Context).
+func NewReader(r io.Reader, port uint16) *Reader {
+	return &Reader{
+		source:     r,
+		port:       port,
+		envelopeCh: make(chan Envelope),
+	}
+}
+
+// Envelopes returns the channel of extracted IPFIX messages and their capture metadata. The
+// channel is closed once the capture was read entirely, or reading failed.
+func (r *Reader) Envelopes() <-chan Envelope {
+	return r.envelopeCh
+}
+
+// Messages returns the channel of extracted raw IPFIX messages, such that the reader can be
+// used in place of ipfix.UDPListener or ipfix.TCPListener. The channel is fed from the channel
+// returned by Envelopes, only consume one of the two.
+func (r *Reader) Messages() <-chan []byte {
+	r.messagesOnce.Do(func() {
+		r.messageCh = make(chan []byte)
+		go func() {
+			defer close(r.messageCh)
+			for envelope := range r.envelopeCh {
+				r.messageCh <- envelope.Message
+			}
+		}()
+	})
+	return r.messageCh
+}
+
+// Start reads the entire capture and blocks until all messages in it were consumed, or ctx is
+// cancelled. Start returns an error if the capture cannot be read. Malformed TCP streams do not
+// fail the capture, their remaining bytes are skipped.
+func (r *Reader) Start(ctx context.Context) error {
+	logger := ipfix.FromContext(ctx)
+	defer close(r.envelopeCh)
+
+	source, err := r.packetSource()
+	if err != nil {
+		return err
+	}
+
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(&streamFactory{
+		ctx:        ctx,
+		envelopeCh: r.envelopeCh,
+	}))
+	// flushing emits messages completed by segments still buffered after gaps, and
+	// completes all streams of which the capture did not contain the end
+	defer assembler.FlushAll()
+
+	for {
+		packet, err := source.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read packet from capture, %w", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		network := packet.NetworkLayer()
+		if network == nil {
+			continue
+		}
+
+		switch transport := packet.TransportLayer().(type) {
+		case *layers.UDP:
+			if uint16(transport.DstPort) != r.port || len(transport.Payload) == 0 {
+				continue
+			}
+			msg := make([]byte, len(transport.Payload))
+			copy(msg, transport.Payload)
+			select {
+			case r.envelopeCh <- Envelope{
+				Timestamp:   packet.Metadata().Timestamp,
+				Transport:   TransportUDP,
+				Source:      addrPort(network.NetworkFlow().Src(), uint16(transport.SrcPort)),
+				Destination: addrPort(network.NetworkFlow().Dst(), uint16(transport.DstPort)),
+				Message:     msg,
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case *layers.TCP:
+			if uint16(transport.DstPort) != r.port {
+				continue
+			}
+			assembler.AssembleWithTimestamp(network.NetworkFlow(), transport, packet.Metadata().Timestamp)
+		default:
+			logger.V(3).Info("pcap: skipping packet without UDP or TCP layer")
+		}
+	}
+}
+
+// packetSource detects the format of the capture and creates a packet source for it
+func (r *Reader) packetSource() (*gopacket.PacketSource, error) {
+	br := bufio.NewReader(r.source)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture header, %w", err)
+	}
+
+	if bytes.Equal(magic, pcapngMagic) {
+		ng, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pcapng header, %w", err)
+		}
+		return gopacket.NewPacketSource(ng, ng.LinkType()), nil
+	}
+
+	p, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcap header, %w", err)
+	}
+	return gopacket.NewPacketSource(p, p.LinkType()), nil
+}
+
+// addrPort converts a network endpoint and a port to a netip.AddrPort
+func addrPort(endpoint gopacket.Endpoint, port uint16) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(endpoint.Raw())
+	return netip.AddrPortFrom(addr.Unmap(), port)
+}
+
+// streamFactory creates a stream for each TCP connection to the collector
+type streamFactory struct {
+	ctx        context.Context
+	envelopeCh chan Envelope
+}
+
+func (f *streamFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	return &stream{
+		ctx:         f.ctx,
+		envelopeCh:  f.envelopeCh,
+		source:      addrPort(netFlow.Src(), binary.BigEndian.Uint16(tcpFlow.Src().Raw())),
+		destination: addrPort(netFlow.Dst(), binary.BigEndian.Uint16(tcpFlow.Dst().Raw())),
+	}
+}
+
+// stream splits a reassembled TCP stream into IPFIX messages. The assembler calls Reassembled
+// synchronously and only with contiguous bytes in order, such that messages are emitted in the
+// order they were sent in.
+type stream struct {
+	ctx        context.Context
+	envelopeCh chan Envelope
+
+	source      netip.AddrPort
+	destination netip.AddrPort
+
+	// buf contains the bytes of the stream not yet emitted as a message
+	buf []byte
+
+	// broken is set once message boundaries are lost, either due to bytes missing from the
+	// capture or an illegal message header, all remaining bytes of the stream are skipped
+	broken bool
+}
+
+func (s *stream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	logger := ipfix.FromContext(s.ctx)
+	for _, r := range reassemblies {
+		if s.broken {
+			return
+		}
+		// Skip is negative for streams of which the capture does not contain the beginning,
+		// assume that the first captured segment starts with a message
+		if r.Skip > 0 {
+			logger.Error(nil, "bytes missing from TCP stream, skipping its remainder", "source", s.source, "destination", s.destination, "missing", r.Skip)
+			s.skip()
+			return
+		}
+		s.buf = append(s.buf, r.Bytes...)
+		if err := s.emit(r.Seen); err != nil {
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				logger.Error(err, "failed to read IPFIX message from TCP stream", "source", s.source, "destination", s.destination)
+			}
+			s.skip()
+			return
+		}
+	}
+}
+
+func (s *stream) ReassemblyComplete() {
+	if len(s.buf) > 0 && !s.broken {
+		ipfix.FromContext(s.ctx).V(3).Info("pcap: TCP stream ended in the middle of a message", "source", s.source, "destination", s.destination, "bytes", len(s.buf))
+	}
+	s.buf = nil
+}
+
+// emit passes all complete messages in the stream's buffer to the envelope channel, using
+// the same framing as the sessions of ipfix.TCPListener
+func (s *stream) emit(seen time.Time) error {
+	for len(s.buf) > 0 {
+		msg, err := ipfix.ReadMessage(bytes.NewReader(s.buf))
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// wait for the remainder of the message
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.buf = append(s.buf[:0], s.buf[msg.Len():]...)
+
+		select {
+		case s.envelopeCh <- Envelope{
+			Timestamp:   seen,
+			Transport:   TransportTCP,
+			Source:      s.source,
+			Destination: s.destination,
+			Message:     msg.Bytes(),
+		}:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *stream) skip() {
+	s.broken = true
+	s.buf = nil
+}
@@ -0,0 +1,163 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+func readCapture(t *testing.T, name string) []Envelope {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r := NewReader(f, 4739)
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Start(ctx)
+	}()
+
+	envelopes := make([]Envelope, 0)
+	for envelope := range r.Envelopes() {
+		envelopes = append(envelopes, envelope)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return envelopes
+}
+
+func newTestTemplate(t *testing.T, fieldCache ipfix.FieldCache, templateId uint16) *ipfix.Template {
+	fields := make([]ipfix.Field, 0, 2)
+	for _, spec := range []struct{ id, length uint16 }{{8, 4}, {7, 2}} {
+		fb, err := fieldCache.GetBuilder(context.Background(), ipfix.NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+	return &ipfix.Template{
+		TemplateMetadata: &ipfix.TemplateMetadata{
+			TemplateId:        templateId,
+			CreationTimestamp: time.Now(),
+		},
+		Record: &ipfix.TemplateRecord{
+			TemplateId: templateId,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		},
+	}
+}
+
+func TestReader(t *testing.T) {
+	// the captures are created by testdata/generate.go
+	start := time.Date(2023, time.October, 1, 12, 0, 0, 0, time.UTC)
+	udpExporter := netip.MustParseAddrPort("192.0.2.1:40000")
+	tcpExporter := netip.MustParseAddrPort("192.0.2.3:40001")
+	collector := netip.MustParseAddrPort("192.0.2.2:4739")
+
+	expected := []struct {
+		transport           string
+		source              netip.AddrPort
+		packet              int
+		length              int
+		observationDomainId uint32
+		records             int
+	}{
+		{TransportUDP, udpExporter, 0, 32, 1, 0},
+		{TransportUDP, udpExporter, 4, 32, 1, 2},
+		// the template message is completed by the segment captured out of order last
+		{TransportTCP, tcpExporter, 6, 32, 2, 0},
+		// the data messages are completed by the segment captured before
+		{TransportTCP, tcpExporter, 5, 26, 2, 1},
+		{TransportTCP, tcpExporter, 5, 38, 2, 3},
+	}
+
+	for _, name := range []string{"testdata/capture.pcap", "testdata/capture.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			envelopes := readCapture(t, name)
+			if len(envelopes) != len(expected) {
+				t.Fatalf("expected %d messages, found %d", len(expected), len(envelopes))
+			}
+			for i, e := range expected {
+				envelope := envelopes[i]
+				if envelope.Transport != e.transport {
+					t.Errorf("message %d: expected transport %s, found %s", i, e.transport, envelope.Transport)
+				}
+				if envelope.Source != e.source || envelope.Destination != collector {
+					t.Errorf("message %d: expected %s -> %s, found %s -> %s", i, e.source, collector, envelope.Source, envelope.Destination)
+				}
+				if ts := start.Add(time.Duration(e.packet) * time.Second); !envelope.Timestamp.Equal(ts) {
+					t.Errorf("message %d: expected timestamp %s, found %s", i, ts, envelope.Timestamp)
+				}
+				if len(envelope.Message) != e.length {
+					t.Errorf("message %d: expected %d bytes, found %d", i, e.length, len(envelope.Message))
+				}
+			}
+		})
+	}
+
+	t.Run("decode", func(t *testing.T) {
+		ctx := context.Background()
+		templateCache := ipfix.NewDefaultEphemeralCache()
+		fieldCache := ipfix.NewIANAFieldManager(templateCache)
+		decoder := ipfix.NewDecoder(templateCache, fieldCache)
+
+		for i, envelope := range readCapture(t, "testdata/capture.pcap") {
+			msg, err := decoder.Decode(ctx, bytes.NewBuffer(envelope.Message))
+			if err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+			if msg.ObservationDomainId != expected[i].observationDomainId {
+				t.Errorf("message %d: expected observation domain %d, found %d", i, expected[i].observationDomainId, msg.ObservationDomainId)
+			}
+			if len(msg.Sets) != 1 {
+				t.Fatalf("message %d: expected 1 set, found %d", i, len(msg.Sets))
+			}
+			if expected[i].records == 0 {
+				continue
+			}
+			if l := msg.Sets[0].Set.Length(); l != expected[i].records {
+				t.Errorf("message %d: expected %d records, found %d", i, expected[i].records, l)
+			}
+		}
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		f, err := os.Open("testdata/capture.pcap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		r := NewReader(f, 4739)
+		done := make(chan error, 1)
+		go func() {
+			done <- r.Start(context.Background())
+		}()
+
+		n := 0
+		for msg := range r.Messages() {
+			if len(msg) != expected[n].length {
+				t.Errorf("message %d: expected %d bytes, found %d", n, expected[n].length, len(msg))
+			}
+			n++
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if n != len(expected) {
+			t.Errorf("expected %d messages, found %d", len(expected), n)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+//go:build ignore
+
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// generate writes the captures used in the tests of the pcap addon. Run it from the addon's
+// directory with
+//
+//	go run testdata/generate.go
+//
+// Both captures contain the same packets: an exporter sending a template message and a data
+// message over UDP, and an exporter sending a template message and two data messages over TCP.
+// The TCP segments are captured out of order, and one of them is retransmitted. Packets to
+// other ports are interleaved.
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	collector   = net.IP{192, 0, 2, 2}
+	udpExporter = net.IP{192, 0, 2, 1}
+	tcpExporter = net.IP{192, 0, 2, 3}
+)
+
+// message creates an IPFIX message of the given observation domain containing a single set
+func message(observationDomainId uint32, sequenceNumber uint32, setId uint16, set []byte) []byte {
+	b := make([]byte, 0, 20+len(set))
+	b = binary.BigEndian.AppendUint16(b, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(20+len(set)))
+	b = binary.BigEndian.AppendUint32(b, 1696161600)
+	b = binary.BigEndian.AppendUint32(b, sequenceNumber)
+	b = binary.BigEndian.AppendUint32(b, observationDomainId)
+	b = binary.BigEndian.AppendUint16(b, setId)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(set)))
+	return append(b, set...)
+}
+
+// templateMessage creates a message defining template 256 with the fields
+// sourceIPv4Address and sourceTransportPort
+func templateMessage(observationDomainId uint32) []byte {
+	return message(observationDomainId, 0, 2, []byte{0x01, 0x00, 0x00, 0x02, 0x00, 0x08, 0x00, 0x04, 0x00, 0x07, 0x00, 0x02})
+}
+
+// dataMessage creates a message containing n records of template 256
+func dataMessage(observationDomainId uint32, sequenceNumber uint32, n int) []byte {
+	set := make([]byte, 0, 6*n)
+	for i := 0; i < n; i++ {
+		set = append(set, 198, 51, 100, byte(i))
+		set = binary.BigEndian.AppendUint16(set, uint16(50000+i))
+	}
+	return message(observationDomainId, sequenceNumber, 256, set)
+}
+
+type packet struct {
+	src       net.IP
+	transport gopacket.SerializableLayer
+	payload   []byte
+}
+
+func packets() []packet {
+	udp := func(dstPort layers.UDPPort, payload []byte) packet {
+		return packet{src: udpExporter, transport: &layers.UDP{SrcPort: 40000, DstPort: dstPort}, payload: payload}
+	}
+	tcp := func(seq uint32, flags func(*layers.TCP), payload []byte) packet {
+		l := &layers.TCP{SrcPort: 40001, DstPort: 4739, Seq: seq, Window: 1024}
+		flags(l)
+		return packet{src: tcpExporter, transport: l, payload: payload}
+	}
+	syn := func(l *layers.TCP) { l.SYN = true }
+	psh := func(l *layers.TCP) { l.ACK, l.PSH = true, true }
+	fin := func(l *layers.TCP) { l.ACK, l.FIN = true, true }
+
+	stream := append(append(templateMessage(2), dataMessage(2, 0, 1)...), dataMessage(2, 1, 3)...)
+	// segments do not align with message boundaries
+	a, b, c := stream[:20], stream[20:45], stream[45:]
+	seq := uint32(1000)
+
+	return []packet{
+		udp(4739, templateMessage(1)),
+		udp(53, []byte{0x00}),
+		tcp(seq, syn, nil),
+		tcp(seq+1, psh, a),
+		udp(4739, dataMessage(1, 0, 2)),
+		// c is captured before b, and b is retransmitted
+		tcp(seq+1+uint32(len(a)+len(b)), psh, c),
+		tcp(seq+1+uint32(len(a)), psh, b),
+		tcp(seq+1+uint32(len(a)), psh, b),
+		tcp(seq+1+uint32(len(stream)), fin, nil),
+	}
+}
+
+func serialize(p packet) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    p.src,
+		DstIP:    collector,
+		Protocol: layers.IPProtocolUDP,
+	}
+	switch l := p.transport.(type) {
+	case *layers.UDP:
+		l.SetNetworkLayerForChecksum(ip)
+	case *layers.TCP:
+		ip.Protocol = layers.IPProtocolTCP
+		l.SetNetworkLayerForChecksum(ip)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, p.transport, gopacket.Payload(p.payload))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+func write(w packetWriter) {
+	ts := time.Date(2023, time.October, 1, 12, 0, 0, 0, time.UTC)
+	for i, p := range packets() {
+		data := serialize(p)
+		err := w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     ts.Add(time.Duration(i) * time.Second),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func main() {
+	f, err := os.Create("testdata/capture.pcap")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		log.Fatal(err)
+	}
+	write(w)
+
+	fng, err := os.Create("testdata/capture.pcapng")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fng.Close()
+	ng, err := pcapgo.NewNgWriter(fng, layers.LinkTypeEthernet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	write(ng)
+	if err := ng.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
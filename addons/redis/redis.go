@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redis implements FieldCache and TemplateCache backed by Redis hashes, such that
+// multiple collectors can share the state of templates and fields.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultSyncInterval is the interval at which caches poll Redis for changes made by other
+// instances, if not configured otherwise. Invalidations published by other instances and keyspace
+// notifications trigger additional syncs.
+const DefaultSyncInterval = 5 * time.Second
+
+// hash mirrors a Redis hash of JSON values into a local cache by tracking the values last
+// seen for each of its fields. The local cache is changed only for fields whose value differs.
+type hash struct {
+	client redis.UniversalClient
+
+	key string
+
+	// channel is the pub/sub channel on which instances publish the fields they changed in the
+	// hash, such that other instances sync immediately
+	channel string
+
+	// versions contains the value of each field of the hash last seen in or written to Redis
+	versions map[string]string
+}
+
+func newHash(client redis.UniversalClient, key string) *hash {
+	return &hash{
+		client:   client,
+		key:      key,
+		channel:  key + ":changes",
+		versions: make(map[string]string),
+	}
+}
+
+// diff fetches the hash from Redis and returns fields whose values changed since the last diff,
+// and fields that were removed from Redis. diff marks the returned changes as seen, callers
+// need to call forget for fields they failed to apply.
+func (h *hash) diff(ctx context.Context) (changed map[string]string, removed []string, err error) {
+	values, err := h.client.HGetAll(ctx, h.key).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	changed = make(map[string]string)
+	for field, value := range values {
+		if h.versions[field] != value {
+			changed[field] = value
+			h.versions[field] = value
+		}
+	}
+	for field := range h.versions {
+		if _, ok := values[field]; !ok {
+			removed = append(removed, field)
+			delete(h.versions, field)
+		}
+	}
+	return changed, removed, nil
+}
+
+// forget removes the last seen value of a field, such that it is returned again by the next diff
+func (h *hash) forget(field string) {
+	delete(h.versions, field)
+}
+
+// publish queues the invalidation of field on p, to be sent in the same transaction as the change
+func (h *hash) publish(ctx context.Context, p redis.Pipeliner, field string) {
+	p.Publish(ctx, h.channel, field)
+}
+
+// subscribe subscribes to the invalidations published by other instances, and to keyspace
+// notifications of the hash, which also cover changes made to the hash by other clients. Redis
+// only publishes keyspace notifications if notify-keyspace-events includes "Kh" (or "KA").
+//
+// subscribe returns once Redis confirmed the subscription, such that caches subscribing before
+// their initial sync do not miss any change made in between.
+func (h *hash) subscribe(ctx context.Context) (*redis.PubSub, error) {
+	ps := h.client.Subscribe(ctx, h.channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s, %w", h.channel, err)
+	}
+	if err := ps.PSubscribe(ctx, "__keyspace@*__:"+h.key); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe to keyspace notifications of %s, %w", h.key, err)
+	}
+	return ps, nil
+}
+
+// run calls sync at every interval, and on every invalidation and keyspace notification received
+// on ps until ctx is cancelled. sync receives the notification that triggered it, or nil for
+// syncs triggered by the interval. run closes ps.
+func run(ctx context.Context, ps *redis.PubSub, interval time.Duration, sync func(context.Context, *redis.Message)) {
+	defer ps.Close()
+	notifications := ps.Channel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sync(ctx, nil)
+		case msg := <-notifications:
+			sync(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zoomoid/go-ipfix"
+)
+
+type FieldCache struct {
+	client redis.UniversalClient
+
+	mu *sync.RWMutex
+
+	templateCache ipfix.TemplateCache
+
+	cache ipfix.FieldCache
+
+	// fields mirrors the Redis hash of fields. Its keys are the string forms of FieldKey and its
+	// values are the JSON encoding of InformationElement
+	fields *hash
+
+	syncInterval time.Duration
+
+	namespace string
+	name      string
+
+	ready bool
+}
+
+var _ ipfix.FieldCache = &FieldCache{}
+
+// FieldCacheOption configures a FieldCache created with NewDefaultFieldCache or NewNamedFieldCache
+type FieldCacheOption func(*FieldCache)
+
+// WithFieldSyncInterval sets the interval at which the cache polls Redis for fields added by
+// other instances. Defaults to DefaultSyncInterval.
+func WithFieldSyncInterval(d time.Duration) FieldCacheOption {
+	return func(f *FieldCache) {
+		f.syncInterval = d
+	}
+}
+
+// NewDefaultFieldCache creates a FieldCache with the name "default". If fieldCache is nil, an
+// empty ipfix.EphemeralFieldCache is used as the local cache.
+func NewDefaultFieldCache(client redis.UniversalClient, fieldCache ipfix.FieldCache, templateCache ipfix.TemplateCache, opts ...FieldCacheOption) *FieldCache {
+	return NewNamedFieldCache("default", client, fieldCache, templateCache, opts...)
+}
+
+// NewNamedFieldCache creates a FieldCache storing fields in the Redis hash "fields/<name>".
+// Instances with the same name share their fields. The client is not closed by the cache.
+func NewNamedFieldCache(name string, client redis.UniversalClient, fieldCache ipfix.FieldCache, templateCache ipfix.TemplateCache, opts ...FieldCacheOption) *FieldCache {
+	ns := "fields"
+
+	if fieldCache == nil {
+		fieldCache = ipfix.NewEphemeralFieldCache(templateCache)
+	}
+
+	cache := &FieldCache{
+		client:        client,
+		templateCache: templateCache,
+		mu:            &sync.RWMutex{},
+		cache:         fieldCache,
+		fields:        newHash(client, ns+"/"+name),
+		syncInterval:  DefaultSyncInterval,
+		ready:         false,
+		namespace:     ns,
+		name:          name,
+	}
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	cache.mu.Lock()
+	return cache
+}
+
+func (f *FieldCache) GetBuilder(ctx context.Context, key ipfix.FieldKey) (*ipfix.FieldBuilder, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.cache.GetBuilder(ctx, key)
+}
+
+func (f *FieldCache) Get(ctx context.Context, key ipfix.FieldKey) (*ipfix.InformationElement, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.cache.Get(ctx, key)
+}
+
+func (f *FieldCache) Add(ctx context.Context, ie ipfix.InformationElement) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := ipfix.FieldKey{
+		EnterpriseId: ie.EnterpriseId,
+		Id:           ie.Id,
+	}
+
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			// rollback internal field addition
+			f.cache.Delete(ctx, key)
+		}
+	}()
+
+	err := f.cache.Add(ctx, ie)
+	if err != nil {
+		return err
+	}
+
+	txErr = f.put(ctx, key, &ie)
+	return txErr
+}
+
+func (f *FieldCache) GetAllBuilders(ctx context.Context) map[ipfix.FieldKey]*ipfix.FieldBuilder {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.cache.GetAllBuilders(ctx)
+}
+
+func (f *FieldCache) GetAll(ctx context.Context) map[ipfix.FieldKey]*ipfix.InformationElement {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.cache.GetAll(ctx)
+}
+
+// Delete removes the field from Redis and from the local cache. Other instances remove the
+// field from their local caches once they receive the invalidation, or on their next sync.
+func (f *FieldCache) Delete(ctx context.Context, key ipfix.FieldKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	field := key.String()
+	_, err := f.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HDel(ctx, f.fields.key, field)
+		f.fields.publish(ctx, p, field)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.fields.forget(field)
+	return f.cache.Delete(ctx, key)
+}
+
+func (f *FieldCache) Name() string {
+	return fmt.Sprintf("%s/%s", f.namespace, f.name)
+}
+
+func (f *FieldCache) Type() string {
+	return fmt.Sprintf("%s/%s", "redis", "field")
+}
+
+func (f *FieldCache) MarshalJSON() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	type ifs struct {
+		Type  string          `json:"type,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Cache json.RawMessage `json:"cache,omitempty"`
+	}
+
+	cc, err := f.cache.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ifs{
+		Type:  f.Type(),
+		Name:  f.Name(),
+		Cache: cc,
+	})
+}
+
+func (f *FieldCache) Start(ctx context.Context) error {
+	logger := ipfix.FromContext(ctx)
+
+	var ps *redis.PubSub
+	err := func() error {
+		// restore from redis
+		defer f.mu.Unlock()
+
+		if f.syncInterval <= 0 {
+			return fmt.Errorf("sync interval must be positive, got %s", f.syncInterval)
+		}
+		var err error
+		ps, err = f.fields.subscribe(ctx)
+		if err != nil {
+			return err
+		}
+		logger.V(2).Info("initializing field cache from redis")
+		err = f.sync(ctx)
+		if err != nil {
+			ps.Close()
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	run(ctx, ps, f.syncInterval, func(ctx context.Context, _ *redis.Message) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		err := f.sync(ctx)
+		if err != nil {
+			logger.Error(err, "failed to update internal field cache from redis")
+		}
+		logger.V(2).Info("completed sync cycle for redis fields")
+	})
+	return nil
+}
+
+// sync applies changes made to the hash of fields to the local cache. The caller needs to hold
+// the lock.
+func (f *FieldCache) sync(ctx context.Context) error {
+	changed, removed, err := f.fields.diff(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, field := range removed {
+		key := ipfix.FieldKey{}
+		err := key.Unmarshal(field)
+		if err == nil {
+			err = f.cache.Delete(ctx, key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for field, value := range changed {
+		ie := ipfix.InformationElement{}
+		err := json.Unmarshal([]byte(value), &ie)
+		if err == nil {
+			err = f.cache.Add(ctx, ie)
+		}
+		if err != nil {
+			// retry the field on the next sync
+			f.fields.forget(field)
+			errs = append(errs, fmt.Errorf("failed to add field %s, %w", field, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *FieldCache) put(ctx context.Context, key ipfix.FieldKey, ie *ipfix.InformationElement) error {
+	field := key.String()
+	eei, err := json.Marshal(ie)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, f.fields.key, field, eei)
+		f.fields.publish(ctx, p, field)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the instance's own writes need not be applied to its local cache again on sync
+	f.fields.versions[field] = string(eei)
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zoomoid/go-ipfix"
+)
+
+func TestFieldCache(t *testing.T) {
+	// newTestCache starts a field cache connected to s that runs until the end of the test
+	newTestCache := func(t *testing.T, s *miniredis.Miniredis) *FieldCache {
+		cache := NewNamedFieldCache("test", newTestClient(t, s), nil, ipfix.NewDefaultEphemeralCache(), WithFieldSyncInterval(syncInterval))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- cache.Start(ctx) }()
+		t.Cleanup(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+		// GetAll blocks until Start released the lock after initialization
+		cache.GetAll(ctx)
+		return cache
+	}
+
+	t.Run("instances see each other's fields", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		a := newTestCache(t, s)
+		b := newTestCache(t, s)
+
+		typ := "unsigned64"
+		ie := ipfix.InformationElement{
+			Id:           1,
+			EnterpriseId: 6871,
+			Name:         "vendorCounter",
+			Type:         &typ,
+			Constructor:  ipfix.NewUnsigned64,
+		}
+		key := ipfix.NewFieldKey(ie.EnterpriseId, ie.Id)
+		err := a.Add(ctx, ie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			found, err := b.Get(ctx, key)
+			return err == nil && found.Name == ie.Name
+		})
+
+		err = b.Delete(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := a.Get(ctx, key)
+			return err != nil
+		})
+	})
+}
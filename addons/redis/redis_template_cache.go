@@ -0,0 +1,457 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zoomoid/go-ipfix"
+)
+
+// expireTemplates atomically removes all templates whose deadline passed from the hash of an
+// observation domain in KEYS[1] and the domain's sorted set of deadlines in KEYS[2], publishes
+// their invalidation on the domain's channel in ARGV[2], and returns their keys. Domains without
+// templates left are removed from the set of domains in KEYS[3], ARGV[3] is the domain's id.
+var expireTemplates = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, key in ipairs(expired) do
+	redis.call('HDEL', KEYS[1], key)
+	redis.call('ZREM', KEYS[2], key)
+	redis.call('PUBLISH', ARGV[2], key)
+end
+if redis.call('HLEN', KEYS[1]) == 0 then
+	redis.call('SREM', KEYS[3], ARGV[3])
+end
+return expired
+`)
+
+type TemplateCache struct {
+	client redis.UniversalClient
+
+	mu *sync.RWMutex
+
+	// fieldCache is required for injecting into TemplateRecords and
+	// subsequently Fields during reconstruction from JSON
+	fieldCache ipfix.FieldCache
+
+	// cache is the in-memory cache used to cache idempotent operations
+	// between Redis and the collector
+	cache ipfix.StatefulTemplateCache
+
+	// key is the prefix of all Redis keys of the cache, i.e., "templates/<name>"
+	key string
+
+	// domains mirrors the Redis hashes of templates, one per observation domain, keyed by the
+	// observation domain id. The keys of a hash are the string forms of TemplateKey and its values
+	// are the JSON encoding of Template, i.e., the same as in the templates of a PersistentCache's file
+	domains map[uint32]*hash
+
+	// domainsKey is the key of the Redis set of observation domain ids with templates, such that
+	// syncs do not need to scan the keyspace for hashes
+	domainsKey string
+
+	syncInterval time.Duration
+	ttl          time.Duration
+
+	namespace string
+	name      string
+
+	ready bool
+}
+
+var _ ipfix.TemplateCache = &TemplateCache{}
+var _ ipfix.TemplateCacheDriver = &TemplateCache{}
+
+// TemplateCacheOption configures a TemplateCache created with NewDefaultTemplateCache or
+// NewNamedTemplateCache
+type TemplateCacheOption func(*TemplateCache)
+
+// WithTemplateSyncInterval sets the interval at which the cache polls Redis for templates added
+// by other instances. Defaults to DefaultSyncInterval.
+func WithTemplateSyncInterval(d time.Duration) TemplateCacheOption {
+	return func(t *TemplateCache) {
+		t.syncInterval = d
+	}
+}
+
+// WithTemplateTTL expires templates that were not (re-)added by any instance for the given
+// duration, both from Redis and from the local cache. If the local cache implements
+// ipfix.TemplateCacheWithTimeout, e.g., ipfix.DecayingEphemeralCache, its timeout is set to
+// the same duration, such that templates expire locally in between syncs as well.
+func WithTemplateTTL(d time.Duration) TemplateCacheOption {
+	return func(t *TemplateCache) {
+		t.ttl = d
+	}
+}
+
+func NewDefaultTemplateCache(client redis.UniversalClient, templateCache ipfix.StatefulTemplateCache, fieldCache ipfix.FieldCache, opts ...TemplateCacheOption) *TemplateCache {
+	return NewNamedTemplateCache("default", client, templateCache, fieldCache, opts...)
+}
+
+// NewNamedTemplateCache creates a TemplateCache storing the templates of each observation domain in
+// the Redis hash "templates/<name>/<observation domain id>". Instances with the same name share their
+// templates. The client is not closed by the cache.
+func NewNamedTemplateCache(name string, client redis.UniversalClient, templateCache ipfix.StatefulTemplateCache, fieldCache ipfix.FieldCache, opts ...TemplateCacheOption) *TemplateCache {
+	ns := "templates"
+	key := ns + "/" + name
+
+	cache := &TemplateCache{
+		client:       client,
+		cache:        templateCache,
+		fieldCache:   fieldCache,
+		mu:           &sync.RWMutex{},
+		key:          key,
+		domains:      make(map[uint32]*hash),
+		domainsKey:   key + ":domains",
+		syncInterval: DefaultSyncInterval,
+		ready:        false,
+
+		namespace: ns,
+		name:      name,
+	}
+	for _, opt := range opts {
+		opt(cache)
+	}
+	if tc, ok := templateCache.(ipfix.TemplateCacheWithTimeout); ok && cache.ttl > 0 {
+		tc.SetTimeout(cache.ttl)
+	}
+	cache.mu.Lock()
+	return cache
+}
+
+func (t *TemplateCache) Add(ctx context.Context, key ipfix.TemplateKey, template *ipfix.Template) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var txErr error
+	defer func() {
+		if txErr != nil {
+			// rollback internal template addition
+			t.cache.Delete(ctx, key)
+		}
+	}()
+
+	err := t.cache.Add(ctx, key, template)
+	if err != nil {
+		return err
+	}
+
+	txErr = t.put(ctx, key, template)
+	return txErr
+}
+
+func (t *TemplateCache) GetAll(ctx context.Context) map[ipfix.TemplateKey]*ipfix.Template {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.cache.GetAll(ctx)
+}
+
+func (t *TemplateCache) Get(ctx context.Context, key ipfix.TemplateKey) (*ipfix.Template, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.cache.Get(ctx, key)
+}
+
+// Delete removes the template from Redis and from the local cache. Other instances remove the
+// template from their local caches once they receive the invalidation, or on their next sync.
+func (t *TemplateCache) Delete(ctx context.Context, key ipfix.TemplateKey) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.domain(key.ObservationDomainId)
+	field := key.String()
+	_, err := t.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HDel(ctx, h.key, field)
+		p.ZRem(ctx, deadlinesKey(h), field)
+		h.publish(ctx, p, field)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.forget(field)
+	return t.cache.Delete(ctx, key)
+}
+
+func (t *TemplateCache) MarshalJSON() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	type its struct {
+		Type  string          `json:"type,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Cache json.RawMessage `json:"cache,omitempty"`
+	}
+
+	cc, err := t.cache.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(its{
+		Type:  t.Type(),
+		Name:  t.Name(),
+		Cache: cc,
+	})
+}
+
+func (t *TemplateCache) Name() string {
+	return fmt.Sprintf("%s/%s", t.namespace, t.name)
+}
+
+func (t *TemplateCache) Type() string {
+	return fmt.Sprintf("%s/%s", "redis", t.cache.Type())
+}
+
+func (t *TemplateCache) Prepare() error {
+	if t.syncInterval <= 0 {
+		return fmt.Errorf("sync interval must be positive, got %s", t.syncInterval)
+	}
+	return nil
+}
+
+// Initialize fetches all templates stored in Redis for a particular template space and
+// reconstructs the internal map of templates
+func (t *TemplateCache) Initialize(ctx context.Context) error {
+	return t.sync(ctx)
+}
+
+// Close closes the underlying cache. The Redis client is owned by the caller and not closed.
+func (t *TemplateCache) Close(ctx context.Context) error {
+	return t.cache.Close(ctx)
+}
+
+func (t *TemplateCache) Start(ctx context.Context) error {
+	logger := ipfix.FromContext(ctx)
+
+	go t.cache.Start(ctx)
+	var ps *redis.PubSub
+	err := func() error {
+		defer t.mu.Unlock()
+
+		err := t.Prepare()
+		if err != nil {
+			return err
+		}
+		ps, err = t.subscribe(ctx)
+		if err != nil {
+			return err
+		}
+		logger.V(2).Info("initializing template cache from redis")
+		err = t.Initialize(ctx)
+		if err != nil {
+			ps.Close()
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	run(ctx, ps, t.syncInterval, func(ctx context.Context, msg *redis.Message) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		var err error
+		if observationDomainId, ok := t.domainOf(msg); ok {
+			// notifications only concern the hash of a single observation domain
+			err = t.syncDomain(ctx, observationDomainId)
+		} else {
+			err = t.sync(ctx)
+		}
+		if err != nil {
+			logger.Error(err, "failed to update internal template cache from redis")
+		}
+		logger.V(2).Info("completed sync cycle for redis templates")
+	})
+	return nil
+}
+
+// domain returns the hash of templates of the observation domain. The caller needs to hold the lock.
+func (t *TemplateCache) domain(observationDomainId uint32) *hash {
+	h, ok := t.domains[observationDomainId]
+	if !ok {
+		h = newHash(t.client, fmt.Sprintf("%s/%d", t.key, observationDomainId))
+		t.domains[observationDomainId] = h
+	}
+	return h
+}
+
+// deadlinesKey returns the key of the sorted set of template deadlines in unix milliseconds of
+// the hash of an observation domain, used if ttl is set
+func deadlinesKey(h *hash) string {
+	return h.key + ":deadlines"
+}
+
+// subscribe subscribes to the invalidations published on the channels of all observation domains,
+// and to keyspace notifications of their hashes, see hash.subscribe.
+func (t *TemplateCache) subscribe(ctx context.Context) (*redis.PubSub, error) {
+	channels := t.key + "/*:changes"
+	ps := t.client.PSubscribe(ctx, channels)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s, %w", channels, err)
+	}
+	if err := ps.PSubscribe(ctx, "__keyspace@*__:"+t.key+"/*"); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("failed to subscribe to keyspace notifications of %s, %w", t.key, err)
+	}
+	return ps, nil
+}
+
+// domainOf returns the observation domain whose hash the notification msg is about. Both
+// invalidations on "templates/<name>/<id>:changes" and keyspace notifications on
+// "__keyspace@<db>__:templates/<name>/<id>" are supported.
+func (t *TemplateCache) domainOf(msg *redis.Message) (uint32, bool) {
+	if msg == nil {
+		return 0, false
+	}
+	_, rest, ok := strings.Cut(msg.Channel, t.key+"/")
+	if !ok {
+		return 0, false
+	}
+	id, _, _ := strings.Cut(rest, ":")
+	v, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// sync syncs the hashes of all observation domains known to Redis or to the instance. The caller
+// needs to hold the lock.
+func (t *TemplateCache) sync(ctx context.Context) error {
+	members, err := t.client.SMembers(ctx, t.domainsKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list observation domains, %w", err)
+	}
+	observationDomainIds := make(map[uint32]struct{}, len(members)+len(t.domains))
+	for _, member := range members {
+		v, err := strconv.ParseUint(member, 10, 32)
+		if err != nil {
+			return fmt.Errorf("observation domain id %q is invalid, %w", member, err)
+		}
+		observationDomainIds[uint32(v)] = struct{}{}
+	}
+	// domains removed from Redis still need to be synced for removing their templates locally
+	for observationDomainId := range t.domains {
+		observationDomainIds[observationDomainId] = struct{}{}
+	}
+
+	var errs []error
+	for observationDomainId := range observationDomainIds {
+		if err := t.syncDomain(ctx, observationDomainId); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncDomain expires templates of an observation domain and applies changes made to its hash
+// to the local cache. The caller needs to hold the lock.
+func (t *TemplateCache) syncDomain(ctx context.Context, observationDomainId uint32) error {
+	h := t.domain(observationDomainId)
+	if t.ttl > 0 {
+		err := expireTemplates.Run(ctx, t.client, []string{h.key, deadlinesKey(h), t.domainsKey}, time.Now().UnixMilli(), h.channel, observationDomainId).Err()
+		if err != nil {
+			return fmt.Errorf("failed to expire templates of observation domain %d, %w", observationDomainId, err)
+		}
+	}
+
+	changed, removed, err := h.diff(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, field := range removed {
+		key := ipfix.TemplateKey{}
+		err := key.Unmarshal(field)
+		if err == nil {
+			err = t.cache.Delete(ctx, key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for field, value := range changed {
+		err := t.apply(ctx, field, value)
+		if err != nil {
+			// retry the field on the next sync
+			h.forget(field)
+			errs = append(errs, err)
+		}
+	}
+	if len(h.versions) == 0 {
+		// the domain is synced again once it is listed in Redis
+		delete(t.domains, observationDomainId)
+	}
+	return errors.Join(errs...)
+}
+
+// apply decodes a template from the hash and adds it to the local cache
+func (t *TemplateCache) apply(ctx context.Context, field string, value string) error {
+	key := ipfix.TemplateKey{}
+	err := key.Unmarshal(field)
+	if err != nil {
+		return err
+	}
+	tmpl := (&ipfix.Template{}).WithFieldCache(t.fieldCache).WithTemplateCache(t.cache)
+	err = json.Unmarshal([]byte(value), tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to decode template %s, %w", field, err)
+	}
+	return t.cache.Add(ctx, key, tmpl)
+}
+
+func (t *TemplateCache) put(ctx context.Context, key ipfix.TemplateKey, template *ipfix.Template) error {
+	h := t.domain(key.ObservationDomainId)
+	field := key.String()
+	tmpl, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, t.domainsKey, key.ObservationDomainId)
+		p.HSet(ctx, h.key, field, tmpl)
+		if t.ttl > 0 {
+			p.ZAdd(ctx, deadlinesKey(h), redis.Z{
+				Score:  float64(time.Now().Add(t.ttl).UnixMilli()),
+				Member: field,
+			})
+		}
+		h.publish(ctx, p, field)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the instance's own writes need not be applied to its local cache again on sync
+	h.versions[field] = string(tmpl)
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zoomoid/go-ipfix"
+)
+
+// syncInterval is the bound within which instances need to see each other's changes in tests
+const syncInterval = 20 * time.Millisecond
+
+// newTestClient returns a client to s that is closed at the end of the test
+func newTestClient(t *testing.T, s *miniredis.Miniredis) redis.UniversalClient {
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// eventually polls cond until it holds or a multiple of the sync interval passed
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(50 * syncInterval)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for caches to sync")
+		}
+		time.Sleep(syncInterval / 4)
+	}
+}
+
+func newTestTemplate(t *testing.T, fieldCache ipfix.FieldCache, templateId uint16) *ipfix.Template {
+	fields := make([]ipfix.Field, 0, 2)
+	for _, spec := range []struct{ id, length uint16 }{{8, 4}, {7, 2}} {
+		fb, err := fieldCache.GetBuilder(context.Background(), ipfix.NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+	return &ipfix.Template{
+		TemplateMetadata: &ipfix.TemplateMetadata{
+			TemplateId:        templateId,
+			CreationTimestamp: time.Now(),
+		},
+		Record: &ipfix.TemplateRecord{
+			TemplateId: templateId,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		},
+	}
+}
+
+func TestTemplateCache(t *testing.T) {
+	// newTestCache starts a template cache connected to s that runs until the end of the test
+	newTestCache := func(t *testing.T, s *miniredis.Miniredis, local ipfix.StatefulTemplateCache, opts ...TemplateCacheOption) (*TemplateCache, ipfix.FieldCache) {
+		fieldCache := ipfix.NewIANAFieldManager(local)
+		opts = append([]TemplateCacheOption{WithTemplateSyncInterval(syncInterval)}, opts...)
+		cache := NewNamedTemplateCache("test", newTestClient(t, s), local, fieldCache, opts...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- cache.Start(ctx) }()
+		t.Cleanup(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+		// GetAll blocks until Start released the lock after initialization
+		cache.GetAll(ctx)
+		return cache, fieldCache
+	}
+
+	t.Run("instances see each other's templates", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		a, fieldCache := newTestCache(t, s, ipfix.NewDefaultEphemeralCache())
+		b, _ := newTestCache(t, s, ipfix.NewDefaultEphemeralCache())
+
+		key := ipfix.NewKey(1, 256)
+		err := a.Add(ctx, key, newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := b.Get(ctx, key)
+			return err == nil
+		})
+
+		err = b.Delete(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := a.Get(ctx, key)
+			return err != nil
+		})
+	})
+
+	t.Run("invalidations are published", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		// polling is effectively disabled, such that only invalidations trigger syncs
+		a, fieldCache := newTestCache(t, s, ipfix.NewDefaultEphemeralCache(), WithTemplateSyncInterval(time.Hour))
+		b, _ := newTestCache(t, s, ipfix.NewDefaultEphemeralCache(), WithTemplateSyncInterval(time.Hour))
+
+		key := ipfix.NewKey(1, 256)
+		err := a.Add(ctx, key, newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := b.Get(ctx, key)
+			return err == nil
+		})
+
+		err = b.Delete(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := a.Get(ctx, key)
+			return err != nil
+		})
+	})
+
+	t.Run("templates are stored per observation domain", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		// polling is effectively disabled, such that only invalidations trigger syncs
+		a, fieldCache := newTestCache(t, s, ipfix.NewDefaultEphemeralCache(), WithTemplateSyncInterval(time.Hour))
+		b, _ := newTestCache(t, s, ipfix.NewDefaultEphemeralCache(), WithTemplateSyncInterval(time.Hour))
+
+		keys := []ipfix.TemplateKey{ipfix.NewKey(1, 256), ipfix.NewKey(2, 256)}
+		for _, key := range keys {
+			err := a.Add(ctx, key, newTestTemplate(t, fieldCache, 256))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, key := range keys {
+			hash := fmt.Sprintf("templates/test/%d", key.ObservationDomainId)
+			fields, err := s.HKeys(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fields) != 1 || fields[0] != key.String() {
+				t.Errorf("expected hash %s to only contain %s, got %v", hash, key.String(), fields)
+			}
+			eventually(t, func() bool {
+				_, err := b.Get(ctx, key)
+				return err == nil
+			})
+		}
+		domains, err := s.Members("templates/test:domains")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(domains) != 2 {
+			t.Errorf("expected 2 observation domains, got %v", domains)
+		}
+	})
+
+	t.Run("restores templates on start", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		a, fieldCache := newTestCache(t, s, ipfix.NewDefaultEphemeralCache())
+		key := ipfix.NewKey(1, 256)
+		err := a.Add(ctx, key, newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := newTestCache(t, s, ipfix.NewDefaultEphemeralCache())
+		template, err := b.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if template.Record.Id() != 256 {
+			t.Errorf("expected template 256, got %d", template.Record.Id())
+		}
+	})
+
+	t.Run("values match persistent cache snapshots", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		a, fieldCache := newTestCache(t, s, ipfix.NewDefaultEphemeralCache())
+		key := ipfix.NewKey(1, 256)
+		template := newTestTemplate(t, fieldCache, 256)
+		err := a.Add(ctx, key, template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// PersistentCache writes the templates of its cache keyed by their TemplateKey
+		snapshot := ipfix.NewDefaultEphemeralCache()
+		err = snapshot.Add(ctx, key, template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := snapshot.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var templates map[string]any
+		err = json.Unmarshal(b, &templates)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value := s.HGet("templates/test/1", key.String())
+		var stored any
+		err = json.Unmarshal([]byte(value), &stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stored, templates[key.String()]) {
+			t.Errorf("expected stored template %v to equal snapshot %v", stored, templates[key.String()])
+		}
+	})
+
+	t.Run("templates expire after ttl", func(t *testing.T) {
+		ctx := context.Background()
+		s := miniredis.RunT(t)
+		ttl := 5 * syncInterval
+		local := ipfix.NewDefaultDecayingEphemeralCache().(ipfix.StatefulTemplateCache)
+		a, fieldCache := newTestCache(t, s, local, WithTemplateTTL(ttl))
+		b, _ := newTestCache(t, s, ipfix.NewDefaultEphemeralCache(), WithTemplateTTL(ttl))
+
+		key := ipfix.NewKey(1, 256)
+		err := a.Add(ctx, key, newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, func() bool {
+			_, err := b.Get(ctx, key)
+			return err == nil
+		})
+
+		time.Sleep(ttl)
+		eventually(t, func() bool {
+			_, err := b.Get(ctx, key)
+			return err != nil && !s.Exists("templates/test/1")
+		})
+		if _, err := a.Get(ctx, key); err == nil {
+			t.Error("expected template to be expired in local decaying cache")
+		}
+	})
+}
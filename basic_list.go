@@ -23,7 +23,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"reflect"
 	"strings"
 )
 
@@ -54,9 +53,10 @@ type BasicList struct {
 
 	pen uint32
 
-	// length is the number of bytes of elements contained in a basic list.
-	// Note that if the data type was created synthetically, i.e., not from decoding IPFIX
-	// packets, the length value may be 0, even though the list contains elements.
+	// length is the number of bytes of the entire basic list, i.e., including the
+	// header of semantic, field id, element length, and, for enterprise-specific
+	// elements, the PEN. Decode uses it to bound the list, SetValue and UnmarshalJSON
+	// keep it in sync with Length()
 	length uint16
 
 	value []Field
@@ -116,36 +116,99 @@ func (t *BasicList) Value() interface{} {
 	return t.value
 }
 
-// NOTE that this allows for various types of list items, as long as they implement
-// the DataType interface; in IPFIX, this is forbidden: basicList elements must all
-// have the same type, encoded by the fieldId read in the "header" bytes of the list.
+// SetValueE accepts either a slice of Fields or a slice of DataTypes. DataTypes are wrapped in
+// fields of the list's field id and PEN.
 //
-// SetValue does not perform additional type checks as it is fine with variable types.
-func (t *BasicList) SetValue(v any) DataType {
-	// TODO(zoomoid): in regular IPFIX, a basicList may only contain elements of the same
-	// type. This is not enforced here, any type implementing the DataType interface may
-	// be passed in, and thus, types with different lengths CAN occur when using this function
-	b, ok := v.([]Field)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
-	}
-
-	firstType := reflect.TypeOf(b[0])
-	for _, value := range b {
-		if reflect.TypeOf(value) != firstType {
-			panic(fmt.Errorf("basicList items are not all of the same type, expected %s, found %T", firstType.String(), value))
+// In IPFIX, basicList elements must all have the same type, encoded by the fieldId read
+// in the "header" bytes of the list. SetValueE returns an error if the elements are not all of
+// the same type.
+func (t *BasicList) SetValueE(v any) (DataType, error) {
+	var b []Field
+	switch vv := v.(type) {
+	case []Field:
+		b = vv
+	case []DataType:
+		b = make([]Field, 0, len(vv))
+		for _, dt := range vv {
+			f, err := t.wrap(dt)
+			if err != nil {
+				return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+			}
+			b = append(b, f)
+		}
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T cannot be asserted to %T", t, ErrInvalidValue, v, t.value)
+	}
+
+	if len(b) > 0 {
+		firstType := b[0].Type()
+		for _, value := range b {
+			if value.Type() != firstType {
+				return nil, fmt.Errorf("cannot set value in %T, %w, basicList items are not all of the same type, expected %s, found %s", t, ErrInvalidValue, firstType, value.Type())
+			}
 		}
 	}
 
 	t.value = b
-	l := uint16(0)
-	for _, e := range b {
-		l += e.Length()
+	t.reconcile()
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *BasicList) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.length = l
 	return t
 }
 
+// reconcile derives the header information of a basic list from its PEN and elements
+// such that lists built programmatically encode the same way decoded lists do
+func (t *BasicList) reconcile() {
+	if len(t.value) > 0 && t.value[0].Reversed() {
+		// reversed elements are announced by their reverse IE, as in templates
+		key := wireKey(t.value[0])
+		t.fieldId, t.pen = key.Id, key.EnterpriseId
+	}
+	if t.pen != 0 {
+		t.isEnterprise = true
+	}
+	if len(t.value) > 0 {
+		if _, ok := t.value[0].(*VariableLengthField); ok {
+			t.elementLength = VariableLength
+		} else {
+			t.elementLength = t.value[0].Length()
+		}
+	}
+	t.length = t.Length()
+}
+
+// wrap creates a new field of the list's field id and PEN with dt as its value. The IE is taken
+// from the list's field cache, lists without a field cache define it from dt.
+func (t *BasicList) wrap(dt DataType) (Field, error) {
+	var fieldBuilder *FieldBuilder
+	if t.fieldManager != nil {
+		fb, err := t.fieldManager.GetBuilder(context.TODO(), NewFieldKey(t.pen, t.fieldId))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get field (%d,%d) from manager in %T, %w", t.pen, t.fieldId, t, err)
+		}
+		fieldBuilder = fb
+	}
+	if fieldBuilder == nil {
+		fieldBuilder = NewFieldBuilder(&InformationElement{
+			Id:          t.fieldId,
+			Constructor: dt.WithLength(dt.Length()),
+		})
+	}
+	return fieldBuilder.
+		SetLength(dt.Length()).
+		SetPEN(t.pen).
+		SetFieldManager(t.fieldManager).
+		Complete().
+		SetValueE(dt)
+}
+
 var (
 	basicListMinimumHeaderLength uint16 = 1 + 2 + 2 // semantics (uint8) + fieldId (uint16) + element length (uint16)
 )
@@ -162,10 +225,15 @@ func (t *BasicList) Length() uint16 {
 	return lh + length
 }
 
+// Clone creates a deep copy of the basic list. Each element is cloned, such that mutating
+// the elements of the clone does not affect the original list. The FieldCache is shared.
 func (t *BasicList) Clone() DataType {
-	dv := make([]Field, 0)
-	for _, el := range t.value {
-		dv = append(dv, el.Clone())
+	var dv []Field
+	if t.value != nil {
+		dv = make([]Field, 0, len(t.value))
+		for _, el := range t.value {
+			dv = append(dv, el.Clone())
+		}
 	}
 	return &BasicList{
 		value:            dv,
@@ -173,6 +241,7 @@ func (t *BasicList) Clone() DataType {
 		semantic:         t.semantic,
 		fieldId:          t.fieldId,
 		isEnterprise:     t.isEnterprise,
+		elementLength:    t.elementLength,
 		length:           t.length,
 		pen:              t.pen,
 		fieldManager:     t.fieldManager,
@@ -213,8 +282,13 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 	// which, in case of enterprise-specific IEs, may also be 9 = 5 + pen (4 bytes)
 	var headerLength uint16 = basicListMinimumHeaderLength
 
+	state, depth := decodeStateOf(r)
+	if err := state.enterList(depth + 1); err != nil {
+		return n, fmt.Errorf("failed to decode %T, %w", t, err)
+	}
+
 	b := make([]byte, 1)
-	m, err := r.Read(b)
+	m, err := io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read list semantic in %T, %w", t, err)
@@ -222,7 +296,7 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 	t.semantic = ListSemantic(uint8(b[0]))
 
 	b = make([]byte, 2)
-	m, err = r.Read(b)
+	m, err = io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read field id in %T, %w", t, err)
@@ -239,7 +313,7 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 	}
 
 	b = make([]byte, 2)
-	m, err = r.Read(b)
+	m, err = io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read element length in %T, %w", t, err)
@@ -248,7 +322,7 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 
 	if t.isEnterprise {
 		b = make([]byte, 4)
-		m, err = r.Read(b)
+		m, err = io.ReadFull(r, b)
 		n += m
 		if err != nil {
 			return n, fmt.Errorf("failed to read pen in %T, %w", t, err)
@@ -257,10 +331,10 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 		enterpriseId = binary.BigEndian.Uint32(b)
 
 		t.pen = enterpriseId
-		if enterpriseId == ReversePEN && reversible(fieldId) {
+		if forward, ok := forwardKey(enterpriseId, fieldId); ok {
 			reverse = true
-			// clear enterprise id, because this would obscure lookup
-			enterpriseId = 0
+			// look up the forward IE, e.g., clear the reverse PEN, because this would obscure lookup
+			enterpriseId, fieldId = forward.EnterpriseId, forward.Id
 		}
 
 		headerLength += 4
@@ -272,7 +346,7 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 	}
 
 	if fieldBuilder == nil {
-		return n, fmt.Errorf("undefined field id (%d,%d)", enterpriseId, fieldId)
+		fieldBuilder = NewUnknownFieldBuilder(enterpriseId, fieldId)
 	}
 
 	field := fieldBuilder.
@@ -282,24 +356,44 @@ func (t *BasicList) Decode(r io.Reader) (n int, err error) {
 		SetReversed(reverse).
 		Complete()
 
+	if t.length < headerLength {
+		return n, malformedMessage(n, "%T list length %d is shorter than its header of %d bytes", t, t.length, headerLength)
+	}
+
 	t.value = make([]Field, 0)
-	// TODO(zoomoid): check if this is semantically equivalent!
-	buf := make([]byte, t.length-headerLength)
-	// buf := make([]byte, t.elementLength)
+	// t.length is the length of the entire list, the elements are what remains after the header
+	contentLength := t.length - headerLength
+	if t.elementLength != VariableLength && t.elementLength > 0 && contentLength%t.elementLength != 0 {
+		return n, malformedMessage(n, "%T content length %d is not a multiple of its element length %d", t, contentLength, t.elementLength)
+	}
+	buf := make([]byte, contentLength)
 
-	m, err = r.Read(buf)
+	m, err = io.ReadFull(r, buf)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read basicList content, %w", err)
 	}
+	// elements are decoded from buf, which is already accounted for in n
 	basicListContent := bytes.NewBuffer(buf)
+	elements := state.nest(basicListContent, depth+1)
 	for i := 0; basicListContent.Len() > 0; i++ {
-		m, err := field.Decode(basicListContent)
-		n += m
-		if err != nil /* && !errors.Is(err, io.EOF) */ {
+		if err := state.addListElement(i + 1); err != nil {
+			return n, fmt.Errorf("failed to decode list element %d in %T, %w", i, t, err)
+		}
+		if err := state.addField(); err != nil {
+			return n, fmt.Errorf("failed to decode list element %d in %T, %w", i, t, err)
+		}
+		// each element needs its own field, otherwise all elements share the same value
+		el := field.Clone()
+		m, err := el.Decode(elements)
+		if err != nil {
 			return n, fmt.Errorf("error while decoding list element %d in %T, %w", i, t, err)
 		}
-		t.value = append(t.value, field)
+		if m == 0 {
+			// zero-length elements would never exhaust the list
+			return n, malformedMessage(n-basicListContent.Len(), "element %d of %T is empty", i, t)
+		}
+		t.value = append(t.value, el)
 	}
 
 	return n, nil
@@ -430,24 +524,33 @@ func (t *BasicList) UnmarshalJSON(in []byte) error {
 	if t.pen != 0 {
 		t.isEnterprise = true
 	}
-	t.length = ff.Metadata.Length + 3 // 3 bytes for semantics + fieldId
-	if t.isEnterprise {
-		t.length += 4 // 4 bytes for PEN
-	}
 	t.semantic = ff.Metadata.Semantic
 
 	fs := make([]Field, 0, len(ff.Elements))
 	for _, el := range ff.Elements {
-		v := NewFieldBuilder(&InformationElement{ // TODO(zoomoid): this defines a new IE *ad-hoc* instead of using fieldCache
-			Constructor: LookupConstructor(el.Type),
-		}).Complete()
-		err := v.UnmarshalJSON(el.Value)
+		cf := &consolidatedField{}
+		if len(el.Value) > 0 {
+			if err := json.Unmarshal(el.Value, cf); err != nil {
+				return err
+			}
+		}
+		if cf.Type == "" {
+			cf.Type = el.Type
+		}
+		if cf.Id == 0 && cf.PEN == 0 {
+			// elements of lists created from values only carry the IE of the list's header
+			cf.Id, cf.PEN = t.fieldId, t.pen
+		}
+		// restore the element like any other field, such that the IE is taken from the list's
+		// field cache, and the key of reversed elements is reset to the forward IE
+		v, err := cf.restore(t.fieldManager, nil)
 		if err != nil {
 			return err
 		}
 		fs = append(fs, v)
 	}
 	t.value = fs
+	t.reconcile()
 
 	return nil
 }
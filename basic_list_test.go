@@ -16,7 +16,13 @@ limitations under the License.
 
 package ipfix
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
 
 func TestBasicList(t *testing.T) {
 
@@ -47,6 +53,23 @@ func TestBasicList(t *testing.T) {
 			},
 		})
 	})
+	t.Run("data types are wrapped in fields of the field cache", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		sourceList := BasicList{
+			semantic:     SemanticOrdered,
+			fieldId:      7,
+			fieldManager: NewIANAFieldCache(templateCache),
+		}
+
+		if _, err := sourceList.SetValueE([]DataType{NewUnsigned16().SetValue(80), NewUnsigned16().SetValue(443)}); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range sourceList.Value().([]Field) {
+			if f.Name() != "sourceTransportPort" || f.Prototype() == nil {
+				t.Errorf("expected element of IE sourceTransportPort, got %s", f.Name())
+			}
+		}
+	})
 	t.Run("MarshalJSON", func(t *testing.T) {
 		sourceList := BasicList{
 			semantic: SemanticOrdered,
@@ -112,4 +135,228 @@ func TestBasicList(t *testing.T) {
 		}
 		t.Log(b.String())
 	})
+	t.Run("Decode creates independent elements", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(5 + 3*2)
+
+		// semantic ordered, field id 7 (sourceTransportPort), element length 2, three elements
+		in := []byte{0x04, 0x00, 0x07, 0x00, 0x02, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}
+		_, err := bl.Decode(bytes.NewBuffer(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		els := bl.(*BasicList).Elements()
+		if len(els) != 3 {
+			t.Fatalf("expected 3 elements, got %d", len(els))
+		}
+		for i, el := range els {
+			if el.Value().Value() != uint16(i+1) {
+				t.Errorf("expected element %d to be %d, got %v", i, i+1, el.Value().Value())
+			}
+		}
+	})
+
+	t.Run("Decode enterprise-specific list", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+		// semantic ordered, reverse sourceTransportPort (29305/7), element length 2, three elements
+		in := []byte{0x04, 0x80, 0x07, 0x00, 0x02, 0x00, 0x00, 0x72, 0x79, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}
+		trailer := []byte{0xca, 0xfe}
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(in))).(*BasicList)
+
+		r := bytes.NewBuffer(append(append([]byte{}, in...), trailer...))
+		n, err := bl.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(in) {
+			t.Errorf("expected %d bytes consumed, got %d", len(in), n)
+		}
+		if !bytes.Equal(r.Bytes(), trailer) {
+			t.Errorf("expected trailing bytes to remain untouched, got %v", r.Bytes())
+		}
+		if !bl.isEnterprise || bl.pen != ReversePEN {
+			t.Errorf("expected list of PEN %d, got %d", ReversePEN, bl.pen)
+		}
+		els := bl.Elements()
+		if len(els) != 3 || !els[0].Reversed() || els[2].Value().Value() != uint16(3) {
+			t.Fatalf("expected 3 reversed elements, got %v", els)
+		}
+		if bl.Length() != uint16(len(in)) {
+			t.Errorf("expected length %d, got %d", len(in), bl.Length())
+		}
+
+		encoded := &bytes.Buffer{}
+		if _, err := bl.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), in) {
+			t.Errorf("expected list to encode to\n%v, got\n%v", in, encoded.Bytes())
+		}
+	})
+
+	t.Run("JSON round trip of reversed and enterprise-specific elements", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		description := "DPI payload"
+		payload := InformationElement{Id: 18, EnterpriseId: 6871, Name: "payload", Description: &description, Constructor: NewOctetArray}
+		if err := fieldCache.Add(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, tc := range map[string]struct {
+			in       []byte
+			name     string
+			reversed bool
+		}{
+			// reverse sourceTransportPort (29305/7), element length 2, three elements
+			"reversed": {
+				in:       []byte{0x04, 0x80, 0x07, 0x00, 0x02, 0x00, 0x00, 0x72, 0x79, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03},
+				name:     "reversedSourceTransportPort",
+				reversed: true,
+			},
+			// payload (6871/18), variable-length elements
+			"enterprise-specific": {
+				in:   []byte{0x04, 0x80, 0x12, 0xff, 0xff, 0x00, 0x00, 0x1a, 0xd7, 0x02, 0xca, 0xfe, 0x01, 0x00},
+				name: "payload",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(tc.in))).(*BasicList)
+				if _, err := bl.Decode(bytes.NewBuffer(tc.in)); err != nil {
+					t.Fatal(err)
+				}
+				b, err := json.Marshal(bl)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				restored := NewBasicList().(*BasicList).WithManager(fieldCache)().(*BasicList)
+				if err := json.Unmarshal(b, restored); err != nil {
+					t.Fatal(err)
+				}
+				for i, el := range restored.Elements() {
+					expected := bl.Elements()[i]
+					if el.Name() != tc.name || el.Reversed() != tc.reversed {
+						t.Errorf("expected element %d to be %s (reversed %t), got %s (reversed %t)", i, tc.name, tc.reversed, el.Name(), el.Reversed())
+					}
+					if el.PEN() != expected.PEN() || el.Id() != expected.Id() {
+						t.Errorf("expected element %d of IE (%d,%d), got (%d,%d)", i, expected.PEN(), expected.Id(), el.PEN(), el.Id())
+					}
+					if d := el.Prototype().Description; d == nil || *d != *expected.Prototype().Description {
+						t.Errorf("expected element %d to be restored with the description of the IE of the field cache, got %v", i, d)
+					}
+				}
+
+				encoded := &bytes.Buffer{}
+				if _, err := restored.Encode(encoded); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(encoded.Bytes(), tc.in) {
+					t.Errorf("expected restored list to encode to\n%v, got\n%v", tc.in, encoded.Bytes())
+				}
+			})
+		}
+	})
+
+	t.Run("Decode variable-length elements", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+		// semantic ordered, interfaceName (82), variable-length elements "eth0" and "lo"
+		in := []byte{0x04, 0x00, 0x52, 0xff, 0xff, 0x04, 'e', 't', 'h', '0', 0x02, 'l', 'o'}
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(in))).(*BasicList)
+
+		n, err := bl.Decode(bytes.NewBuffer(append(append([]byte{}, in...), 0x00)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(in) {
+			t.Errorf("expected %d bytes consumed, got %d", len(in), n)
+		}
+		els := bl.Elements()
+		if len(els) != 2 || els[0].Value().Value() != "eth0" || els[1].Value().Value() != "lo" {
+			t.Fatalf("expected elements eth0 and lo, got %v", els)
+		}
+		if bl.Length() != uint16(len(in)) {
+			t.Errorf("expected length %d, got %d", len(in), bl.Length())
+		}
+	})
+
+	t.Run("Decode rejects trailing bytes", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+		// two elements of sourceTransportPort followed by a single trailing byte
+		in := []byte{0x04, 0x00, 0x07, 0x00, 0x02, 0x00, 0x01, 0x00, 0x02, 0x00}
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(in)))
+
+		_, err := bl.Decode(bytes.NewBuffer(in))
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+	})
+
+	t.Run("Clone of nested records is independent", func(t *testing.T) {
+		octets := NewFieldBuilder(&InformationElement{
+			Id:          313,
+			Name:        "ipHeaderPacketSection",
+			Constructor: NewOctetArray,
+		}).SetLength(VariableLength).Complete().SetValue([]byte{0x01, 0x02, 0x03})
+
+		inner := DataRecord{
+			TemplateId: 300,
+			FieldCount: 1,
+			Fields:     []Field{octets},
+		}
+
+		list := NewFieldBuilder(&InformationElement{
+			Id:          291,
+			Name:        "basicList",
+			Constructor: NewBasicList,
+		}).SetLength(VariableLength).Complete().SetValue(
+			(&BasicList{fieldId: 7, semantic: SemanticOrdered}).SetValue([]DataType{
+				&Unsigned16{value: 1},
+				&Unsigned16{value: 2},
+			}),
+		)
+		stl := NewFieldBuilder(&InformationElement{
+			Id:          292,
+			Name:        "subTemplateList",
+			Constructor: NewDefaultSubTemplateList,
+		}).SetLength(VariableLength).Complete().SetValue(
+			NewDefaultSubTemplateList().SetValue([]DataRecord{inner}),
+		)
+
+		source := DataRecord{
+			TemplateId: 256,
+			FieldCount: 2,
+			Fields:     []Field{list, stl},
+		}
+
+		clone := source.Clone()
+
+		// mutate nested values of the clone...
+		clonedList := clone.Fields[0].Value().(*BasicList)
+		clonedList.Elements()[0].SetValue(99)
+		clonedList.SetValue(append(clonedList.Elements(), clonedList.Elements()[0].Clone()))
+
+		clonedRecords := clone.Fields[1].Value().(*SubTemplateList).Elements()
+		clonedOctets := clonedRecords[0].Fields[0].Value().Value().([]byte)
+		clonedOctets[0] = 0xFF
+		clonedRecords[0].Fields = append(clonedRecords[0].Fields, octets.Clone())
+
+		// ...and assert the source is unchanged
+		sourceList := source.Fields[0].Value().(*BasicList)
+		if len(sourceList.Elements()) != 2 {
+			t.Errorf("expected source list to have 2 elements, got %d", len(sourceList.Elements()))
+		}
+		if v := sourceList.Elements()[0].Value().Value(); v != uint16(1) {
+			t.Errorf("expected source list element to be 1, got %v", v)
+		}
+		sourceRecords := source.Fields[1].Value().(*SubTemplateList).Elements()
+		if len(sourceRecords[0].Fields) != 1 {
+			t.Errorf("expected source sub template record to have 1 field, got %d", len(sourceRecords[0].Fields))
+		}
+		if v := sourceRecords[0].Fields[0].Value().Value().([]byte); !bytes.Equal(v, []byte{0x01, 0x02, 0x03}) {
+			t.Errorf("expected source octets to be unchanged, got %v", v)
+		}
+	})
 }
@@ -45,15 +45,47 @@ func (t *Boolean) Value() interface{} {
 	return t.value
 }
 
-func (t *Boolean) SetValue(v any) DataType {
-	b, ok := v.(bool)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+// SetValueE sets bools as value, as well as integers and whole floating-point numbers in the
+// encoding of RFC 7011, i.e., 1 for true and 2 for false.
+func (t *Boolean) SetValueE(v any) (DataType, error) {
+	if b, ok := v.(bool); ok {
+		t.value = b
+		return t, nil
+	}
+	i, err := signedFromAny(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	b, err := booleanFromNumber(t, i)
+	if err != nil {
+		return nil, err
 	}
 	t.value = b
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *Boolean) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
+	}
 	return t
 }
 
+// booleanFromNumber maps the numeric encoding of booleans in RFC 7011 to bool, i.e.,
+// 1 to true and 2 to false. All other values return an error.
+func booleanFromNumber(t *Boolean, v int64) (bool, error) {
+	switch v {
+	case 1:
+		return true, nil
+	case 2:
+		return false, nil
+	default:
+		return false, fmt.Errorf("cannot set value %d in %T, %w: %w", v, t, ErrInvalidValue, ErrIllegalDataTypeEncoding)
+	}
+}
+
 func (t *Boolean) Length() uint16 {
 	return t.DefaultLength()
 }
@@ -87,8 +119,10 @@ func (*Boolean) IsReducedLength() bool {
 // Decode takes a set of bytes (specifically, SHOULD just one) and decodes it to
 // a boolean information element. If in contains more than one byte, Decode panics
 func (t *Boolean) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -117,8 +151,26 @@ func (t *Boolean) MarshalJSON() ([]byte, error) {
 	return json.Marshal(t.value)
 }
 
+// UnmarshalJSON accepts both JSON booleans and the numeric encoding of RFC 7011,
+// where 1 is true and 2 is false
 func (t *Boolean) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	var v interface{}
+	err := json.Unmarshal(in, &v)
+	if err != nil {
+		return err
+	}
+	switch b := v.(type) {
+	case bool:
+		t.value = b
+	case float64:
+		if b != 1 && b != 2 {
+			return fmt.Errorf("failed to unmarshal %v into %T, %w", b, t, ErrIllegalDataTypeEncoding)
+		}
+		t.value = b == 1
+	default:
+		return fmt.Errorf("cannot unmarshal %T into %T", v, t)
+	}
+	return nil
 }
 
 var _ DataTypeConstructor = NewBoolean
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// BoundedEphemeralCache is an in-memory cache like EphemeralCache holding at most a fixed number
+// of templates. When adding a template to a full cache, the least recently used template is
+// evicted, where both Add and Get count as usage. This bounds the memory used for exporters
+// cycling through large numbers of short-lived template ids.
+type BoundedEphemeralCache struct {
+	// templates maps keys to their elements in recency, whose values are *boundedEntry
+	templates map[TemplateKey]*list.Element
+	// recency orders the templates from most recently to least recently used
+	recency *list.List
+
+	maxEntries int
+
+	evictions atomic.Uint64
+
+	// collectors are the prometheus metrics evictions are counted in. If nil, the cache falls
+	// back to the deprecated package-level collectors
+	collectors *Metrics
+
+	mu *sync.Mutex
+
+	subscribers *cacheSubscribers
+
+	name string
+}
+
+type boundedEntry struct {
+	key      TemplateKey
+	template *Template
+}
+
+var _ StatefulTemplateCache = &BoundedEphemeralCache{}
+var _ TemplateCacheWithStats = &BoundedEphemeralCache{}
+var _ ObservableTemplateCache = &BoundedEphemeralCache{}
+
+// NewBoundedEphemeralCache creates a new in-memory template cache holding at most maxEntries
+// templates. A maxEntries of 0 or less is treated as 1.
+func NewBoundedEphemeralCache(name string, maxEntries int) *BoundedEphemeralCache {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &BoundedEphemeralCache{
+		templates:   make(map[TemplateKey]*list.Element),
+		recency:     list.New(),
+		maxEntries:  maxEntries,
+		mu:          &sync.Mutex{},
+		subscribers: newCacheSubscribers(),
+		name:        name,
+	}
+}
+
+// WithMetrics makes the cache count evictions in the given metrics instead of the deprecated
+// package-level collectors. The cache's name is used as the label value.
+func (ts *BoundedEphemeralCache) WithMetrics(m *Metrics) *BoundedEphemeralCache {
+	ts.collectors = m
+	return ts
+}
+
+// GetAll returns a copy of all templates in the cache. It does not change their recency.
+func (ts *BoundedEphemeralCache) GetAll(ctx context.Context) map[TemplateKey]*Template {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.all()
+}
+
+// all returns a copy of all templates in the cache. The caller needs to hold the lock.
+func (ts *BoundedEphemeralCache) all() map[TemplateKey]*Template {
+	mm := make(map[TemplateKey]*Template, len(ts.templates))
+	for k, e := range ts.templates {
+		mm[k] = e.Value.(*boundedEntry).template
+	}
+	return mm
+}
+
+// Get returns the template of key and marks it as the most recently used one
+func (ts *BoundedEphemeralCache) Get(ctx context.Context, key TemplateKey) (*Template, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	e, ok := ts.templates[key]
+	if !ok {
+		return nil, templateNotFound(key.ObservationDomainId, key.TemplateId)
+	}
+	ts.recency.MoveToFront(e)
+	return e.Value.(*boundedEntry).template, nil
+}
+
+func (ts *BoundedEphemeralCache) Delete(ctx context.Context, key TemplateKey) error {
+	ts.mu.Lock()
+	e, ok := ts.templates[key]
+	if ok {
+		ts.recency.Remove(e)
+		delete(ts.templates, key)
+	}
+	ts.mu.Unlock()
+
+	if ok {
+		ts.subscribers.publish(CacheEvent{Kind: CacheEventDelete, Key: key, Template: e.Value.(*boundedEntry).template})
+	}
+	return nil
+}
+
+// Add adds or replaces the template of key and marks it as the most recently used one. If the
+// cache is full, the least recently used template is evicted.
+func (ts *BoundedEphemeralCache) Add(ctx context.Context, key TemplateKey, template *Template) error {
+	ts.mu.Lock()
+	events := ts.add(key, template)
+	ts.mu.Unlock()
+
+	ts.subscribers.publish(events...)
+	return nil
+}
+
+// add adds the template and returns the events of the mutation, i.e., evictions followed by the
+// addition. The caller needs to hold the lock.
+func (ts *BoundedEphemeralCache) add(key TemplateKey, template *Template) []CacheEvent {
+	added := CacheEvent{Kind: CacheEventAdd, Key: key, Template: template}
+	if e, ok := ts.templates[key]; ok {
+		e.Value.(*boundedEntry).template = template
+		ts.recency.MoveToFront(e)
+		return []CacheEvent{added}
+	}
+
+	events := make([]CacheEvent, 0, 2)
+	for len(ts.templates) >= ts.maxEntries {
+		oldest := ts.recency.Back()
+		ts.recency.Remove(oldest)
+		entry := oldest.Value.(*boundedEntry)
+		delete(ts.templates, entry.key)
+		events = append(events, CacheEvent{Kind: CacheEventDelete, Key: entry.key, Template: entry.template})
+
+		ts.evictions.Add(1)
+		ts.collectors.templateCacheEvictions(ts.name).Inc()
+	}
+	ts.templates[key] = ts.recency.PushFront(&boundedEntry{key: key, template: template})
+	return append(events, added)
+}
+
+// Subscribe registers fn to be called after every Add and Delete, including evictions, see
+// ObservableTemplateCache
+func (ts *BoundedEphemeralCache) Subscribe(fn func(event CacheEvent)) (unsubscribe func()) {
+	return ts.subscribers.subscribe(fn)
+}
+
+// Evictions returns the number of templates evicted from the cache since its creation
+func (ts *BoundedEphemeralCache) Evictions() uint64 {
+	return ts.evictions.Load()
+}
+
+// Stats returns usage statistics of all templates in the cache
+func (ts *BoundedEphemeralCache) Stats(ctx context.Context) []TemplateStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return templateStats(ts.all())
+}
+
+func (ts *BoundedEphemeralCache) Type() string {
+	return "bounded_ephemeral"
+}
+
+func (ts *BoundedEphemeralCache) Name() string {
+	return ts.name
+}
+
+func (ts *BoundedEphemeralCache) MarshalJSON() ([]byte, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	s := make(map[TemplateKey]*Template, len(ts.templates))
+	for k, e := range ts.templates {
+		s[k] = e.Value.(*boundedEntry).template
+	}
+	return marshalSortedJSON(s, templateKeyLess, templateKeyString)
+}
+
+func (ts *BoundedEphemeralCache) Close(context.Context) error {
+	// no-op
+	return nil
+}
+
+func (ts *BoundedEphemeralCache) Initialize(context.Context) error {
+	// no-op
+	return nil
+}
+
+func (ts *BoundedEphemeralCache) Prepare() error {
+	// no-op
+	return nil
+}
+
+func (ts *BoundedEphemeralCache) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBoundedEphemeralCache(t *testing.T) {
+	ctx := context.Background()
+
+	newTestCache := func(t *testing.T, maxEntries int) (*BoundedEphemeralCache, FieldCache, *Metrics) {
+		m := NewMetrics()
+		cache := NewBoundedEphemeralCache("test", maxEntries).WithMetrics(m)
+		return cache, NewIANAFieldManager(cache), m
+	}
+
+	t.Run("evicts least recently added", func(t *testing.T) {
+		cache, fieldCache, m := newTestCache(t, 3)
+		for id := uint16(256); id < 261; id++ {
+			if err := cache.Add(ctx, NewKey(0, id), newTestTemplate(t, fieldCache, id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, id := range []uint16{256, 257} {
+			if _, err := cache.Get(ctx, NewKey(0, id)); !errors.Is(err, ErrTemplateNotFound) {
+				t.Errorf("expected oldest template %d to be evicted, got %v", id, err)
+			}
+		}
+		for _, id := range []uint16{258, 259, 260} {
+			if _, err := cache.Get(ctx, NewKey(0, id)); err != nil {
+				t.Errorf("expected newest template %d to be retained, got %v", id, err)
+			}
+		}
+		if n := len(cache.GetAll(ctx)); n != 3 {
+			t.Errorf("expected 3 templates, got %d", n)
+		}
+		if cache.Evictions() != 2 {
+			t.Errorf("expected 2 evictions, got %d", cache.Evictions())
+		}
+		if v := testutil.ToFloat64(m.TemplateCacheEvictions.WithLabelValues("test")); v != 2 {
+			t.Errorf("expected 2 evictions in metrics, got %v", v)
+		}
+	})
+
+	t.Run("get refreshes recency", func(t *testing.T) {
+		cache, fieldCache, _ := newTestCache(t, 2)
+		for _, id := range []uint16{256, 257} {
+			if err := cache.Add(ctx, NewKey(0, id), newTestTemplate(t, fieldCache, id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 256)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Add(ctx, NewKey(0, 258), newTestTemplate(t, fieldCache, 258)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 256)); err != nil {
+			t.Errorf("expected recently used template to be retained, got %v", err)
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 257)); err == nil {
+			t.Error("expected least recently used template to be evicted")
+		}
+	})
+
+	t.Run("replacing does not evict", func(t *testing.T) {
+		cache, fieldCache, _ := newTestCache(t, 2)
+		for _, id := range []uint16{256, 257, 256} {
+			if err := cache.Add(ctx, NewKey(0, id), newTestTemplate(t, fieldCache, id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if cache.Evictions() != 0 || len(cache.GetAll(ctx)) != 2 {
+			t.Errorf("expected no evictions when replacing a template, got %d", cache.Evictions())
+		}
+
+		if err := cache.Delete(ctx, NewKey(0, 257)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Add(ctx, NewKey(0, 258), newTestTemplate(t, fieldCache, 258)); err != nil {
+			t.Fatal(err)
+		}
+		if cache.Evictions() != 0 {
+			t.Errorf("expected deleted template to free its entry, got %d evictions", cache.Evictions())
+		}
+	})
+}
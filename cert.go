@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CERTPEN is the private enterprise number of CERT, whose registry contains the information elements
+// exported by yaf and other tools of the CERT NetSA suite.
+const CERTPEN uint32 = 6871
+
+// certReverseBit is set in the id of CERT's reverse IEs.
+const certReverseBit uint16 = 0x4000
+
+var (
+	//go:embed hack/cert_ipfix.xml
+	certSpec []byte
+
+	certOnce       sync.Once
+	certIEs        []InformationElement
+	certReversible map[uint16]struct{}
+)
+
+// cert returns the global registry of CERT information elements sorted by id, and the set of
+// reversible ids. The returned elements MUST NOT be mutated, use CERT for obtaining copies instead.
+func cert() ([]InformationElement, map[uint16]struct{}) {
+	certOnce.Do(func() {
+		m, reversible, err := readXML(bytes.NewReader(certSpec))
+		if err != nil {
+			panic(fmt.Errorf("failed to read CERT registry, %w", err))
+		}
+		certIEs = make([]InformationElement, 0, len(m))
+		for _, ie := range m {
+			// reserved and unassigned ids carry no data type
+			if ie.Type == nil {
+				continue
+			}
+			certIEs = append(certIEs, ie)
+		}
+		sort.Slice(certIEs, func(i, j int) bool {
+			return certIEs[i].Id < certIEs[j].Id
+		})
+		certReversible = reversible
+	})
+	return certIEs, certReversible
+}
+
+// CERT returns all information elements of CERT's registry (PEN 6871) sorted by id, e.g., the
+// ones exported by yaf. The elements are copies, such that they may be mutated freely.
+//
+// Use LoadRegistries for adding them to a FieldCache, and register CERTReverseResolver for
+// decoding the reverse IEs of biflows exported by yaf:
+//
+//	ipfix.RegisterReverseResolver(ipfix.CERTPEN, ipfix.CERTReverseResolver())
+//	err := ipfix.LoadRegistries(ctx, fieldCache, ipfix.CERT())
+func CERT() []InformationElement {
+	ies, _ := cert()
+	c := make([]InformationElement, 0, len(ies))
+	for _, ie := range ies {
+		c = append(c, ie.Clone())
+	}
+	return c
+}
+
+// CERTReverseResolver returns a ReverseResolver for CERT's registry, which marks reverse IEs by
+// setting bit 0x4000 in the id of the forward IE. Only IEs marked reversible by the registry are
+// resolved.
+func CERTReverseResolver() ReverseResolver {
+	ies, reversible := cert()
+	nonReversible := make(map[uint16]struct{}, len(ies))
+	for _, ie := range ies {
+		if _, ok := reversible[ie.Id]; !ok {
+			nonReversible[ie.Id] = struct{}{}
+		}
+	}
+	return &ReverseBitResolver{
+		PEN:           CERTPEN,
+		Bit:           certReverseBit,
+		NonReversible: nonReversible,
+	}
+}
+
+// LoadRegistries adds all information elements of the given registries, e.g., CERT(), to the
+// field cache. It stops at the first element that cannot be added.
+func LoadRegistries(ctx context.Context, fc FieldCache, regs ...[]InformationElement) error {
+	for _, reg := range regs {
+		for _, ie := range reg {
+			if err := fc.Add(ctx, ie); err != nil {
+				return fmt.Errorf("failed to add information element %d/%d to field cache, %w", ie.EnterpriseId, ie.Id, err)
+			}
+		}
+	}
+	return nil
+}
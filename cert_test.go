@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+)
+
+func TestCERT(t *testing.T) {
+	t.Run("registry", func(t *testing.T) {
+		ies := CERT()
+		if len(ies) == 0 {
+			t.Fatal("expected CERT registry to contain information elements")
+		}
+		for i, ie := range ies {
+			if ie.EnterpriseId != CERTPEN {
+				t.Errorf("expected %s to have PEN %d, got %d", ie.Name, CERTPEN, ie.EnterpriseId)
+			}
+			if ie.Type == nil || ie.Constructor == nil {
+				t.Errorf("expected %s to have a data type", ie.Name)
+			}
+			if i > 0 && ies[i-1].Id >= ie.Id {
+				t.Errorf("expected registry to be sorted by id, got %d before %d", ies[i-1].Id, ie.Id)
+			}
+			if typ := *ie.Type; (typ == "basicList" || typ == "subTemplateList") && ie.Semantics != semantics.List {
+				t.Errorf("expected %s of type %s to have list semantics, got %s", ie.Name, typ, ie.Semantics)
+			}
+		}
+
+		ie := ies[0]
+		for _, i := range ies {
+			if i.Id == 14 {
+				ie = i
+			}
+		}
+		if ie.Name != "initialTCPFlags" || *ie.Type != "unsigned16" || ie.Semantics != semantics.Flags {
+			t.Errorf("expected initialTCPFlags with flags semantics, got %v", ie)
+		}
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		ies := CERT()
+		ies[0].Name = "mutated"
+		if CERT()[0].Name == "mutated" {
+			t.Error("expected CERT to return copies of the registry")
+		}
+	})
+
+	t.Run("reverse resolver", func(t *testing.T) {
+		r := CERTReverseResolver()
+
+		// initialTCPFlags is reversible, reverseInitialTCPFlags is 14|0x4000
+		if k, ok := r.Reverse(CERTPEN, 14); !ok || k != NewFieldKey(CERTPEN, 14|0x4000) {
+			t.Errorf("expected initialTCPFlags to be reversible, got %v, %v", k, ok)
+		}
+		if k, ok := r.Forward(CERTPEN, 14|0x4000); !ok || k != NewFieldKey(CERTPEN, 14) {
+			t.Errorf("expected reverseInitialTCPFlags to resolve to initialTCPFlags, got %v, %v", k, ok)
+		}
+		// yafFlowKeyHash is not reversible
+		if _, ok := r.Reverse(CERTPEN, 106); ok {
+			t.Error("expected yafFlowKeyHash not to be reversible")
+		}
+		if _, ok := r.Reverse(0, 14); ok {
+			t.Error("expected resolver to ignore other PENs")
+		}
+	})
+
+	t.Run("load registries", func(t *testing.T) {
+		ctx := context.Background()
+		fieldCache := NewEphemeralFieldCache(NewDefaultEphemeralCache())
+		if err := LoadRegistries(ctx, fieldCache, CERT()); err != nil {
+			t.Fatal(err)
+		}
+
+		ie, err := fieldCache.Get(ctx, NewFieldKey(CERTPEN, 106))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ie.Name != "yafFlowKeyHash" {
+			t.Errorf("expected yafFlowKeyHash, got %s", ie.Name)
+		}
+	})
+}
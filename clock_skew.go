@@ -0,0 +1,250 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultClockSkewThreshold is the threshold of observers created without WithClockSkewThreshold
+const DefaultClockSkewThreshold time.Duration = 10 * time.Second
+
+const (
+	// DefaultClockSkewMaxExporters is the maximum number of exporters labeled individually in the
+	// metrics of observers created without WithClockSkewExporterLimits
+	DefaultClockSkewMaxExporters int = 1024
+	// DefaultClockSkewIdleTimeout is the duration after which observers created without
+	// WithClockSkewExporterLimits forget exporters without messages
+	DefaultClockSkewIdleTimeout time.Duration = 30 * time.Minute
+)
+
+// clockSkewOther is the exporter label value of all exporters exceeding the maximum number of
+// exporters of an observer
+const clockSkewOther string = "other"
+
+// ClockSkewEvent is reported by a ClockSkewObserver when the clock of an exporter is skewed by more
+// than the threshold for the configured number of consecutive messages
+type ClockSkewEvent struct {
+	Exporter            string
+	ObservationDomainId uint32
+
+	// Skew is the receive time minus the export time of the message completing the streak. It is
+	// positive for exporters whose clock runs behind, and negative for clocks running ahead.
+	Skew time.Duration
+	// Consecutive is the number of consecutive messages skewed by more than the threshold
+	Consecutive int
+
+	ExportTime time.Time
+	ReceivedAt time.Time
+}
+
+// ClockSkewObserver computes the difference between the local receive time and the export time
+// of messages per exporter, e.g., to alert on exporters whose skewed clocks break the correlation
+// of flows of different exporters. As export times have a resolution of seconds, skews below a
+// second are indistinguishable from the transit delay.
+//
+// Skews are recorded in Metrics.ExporterClockSkewSeconds with WithClockSkewMetrics. If the absolute
+// skew of an exporter exceeds the threshold for a number of consecutive messages, the callback is
+// called once, and again only after a message of the exporter was within the threshold.
+//
+// Exporters without messages for an idle timeout are forgotten, and their label values are deleted
+// from the metrics. Exporters exceeding the maximum number of exporters are recorded in the metrics
+// with the label value "other", such that the label values do not grow unbounded with the number
+// of exporters.
+//
+// Feed an observer with decoded messages using Observe, or let a Collector feed it with
+// CollectorOptions.ClockSkewObserver. A ClockSkewObserver is safe for concurrent use.
+type ClockSkewObserver struct {
+	mu        sync.Mutex
+	exporters map[string]*clockSkewExporter
+	// labeled is the number of exporters with their own label value
+	labeled   int
+	lastSweep time.Time
+
+	threshold   time.Duration
+	consecutive int
+
+	// maxExporters caps the number of label values of exporters, 0 means no limit
+	maxExporters int
+	// idleTimeout is the duration after which exporters without messages are forgotten, 0 means
+	// exporters are never forgotten
+	idleTimeout time.Duration
+
+	callback func(ClockSkewEvent)
+
+	collectors *Metrics
+	listener   string
+}
+
+type clockSkewExporter struct {
+	// streak is the number of consecutive skewed messages
+	streak   int
+	lastSeen time.Time
+	// label is the exporter's label value in the metrics, i.e., the exporter or "other"
+	label string
+	// collapsed is set if the exporter exceeded the maximum number of exporters
+	collapsed bool
+}
+
+// ClockSkewObserverOption configures a ClockSkewObserver created with NewClockSkewObserver
+type ClockSkewObserverOption func(*ClockSkewObserver)
+
+// WithClockSkewThreshold reports exporters whose absolute skew exceeds threshold for n consecutive
+// messages. By default, the threshold is DefaultClockSkewThreshold, and a single message suffices.
+func WithClockSkewThreshold(threshold time.Duration, n int) ClockSkewObserverOption {
+	return func(o *ClockSkewObserver) {
+		o.threshold = threshold
+		if n > 0 {
+			o.consecutive = n
+		}
+	}
+}
+
+// WithClockSkewCallback sets a function called for exporters exceeding the threshold. The function
+// is called synchronously by Observe.
+func WithClockSkewCallback(callback func(ClockSkewEvent)) ClockSkewObserverOption {
+	return func(o *ClockSkewObserver) {
+		o.callback = callback
+	}
+}
+
+// WithClockSkewMetrics records the skew of each message in m's ExporterClockSkewSeconds histogram,
+// labeled with listener and the exporter.
+func WithClockSkewMetrics(m *Metrics, listener string) ClockSkewObserverOption {
+	return func(o *ClockSkewObserver) {
+		o.collectors = m
+		o.listener = listener
+	}
+}
+
+// WithClockSkewExporterLimits forgets exporters from which no message was observed for
+// idleTimeout, an idleTimeout of zero disables forgetting exporters. At most maxExporters exporters
+// are labeled individually in the metrics, further exporters are combined into the label value
+// "other". A maxExporters of zero disables the limit. By default, DefaultClockSkewMaxExporters and
+// DefaultClockSkewIdleTimeout are used.
+func WithClockSkewExporterLimits(maxExporters int, idleTimeout time.Duration) ClockSkewObserverOption {
+	return func(o *ClockSkewObserver) {
+		if maxExporters >= 0 {
+			o.maxExporters = maxExporters
+		}
+		if idleTimeout >= 0 {
+			o.idleTimeout = idleTimeout
+		}
+	}
+}
+
+// NewClockSkewObserver creates an observer without any known exporters
+func NewClockSkewObserver(opts ...ClockSkewObserverOption) *ClockSkewObserver {
+	o := &ClockSkewObserver{
+		exporters:    make(map[string]*clockSkewExporter),
+		threshold:    DefaultClockSkewThreshold,
+		consecutive:  1,
+		maxExporters: DefaultClockSkewMaxExporters,
+		idleTimeout:  DefaultClockSkewIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Observe records the skew of msg received from exporter at receivedAt and returns it. exporter
+// identifies the exporter's clock, e.g., by its address.
+func (o *ClockSkewObserver) Observe(exporter string, msg *Message, receivedAt time.Time) time.Duration {
+	exportTime := time.Unix(int64(msg.ExportTime), 0).UTC()
+	skew := receivedAt.Sub(exportTime)
+
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	o.mu.Lock()
+	if o.idleTimeout > 0 && receivedAt.Sub(o.lastSweep) >= o.idleTimeout {
+		o.sweep(receivedAt)
+	}
+	e := o.exporter(exporter, receivedAt)
+	e.lastSeen = receivedAt
+	if abs > o.threshold {
+		e.streak++
+	} else {
+		e.streak = 0
+	}
+	streak := e.streak
+	// observe while holding the lock, such that a concurrent sweep cannot delete the label values
+	// in between
+	if o.collectors != nil {
+		o.collectors.ExporterClockSkewSeconds.WithLabelValues(o.listener, e.label).Observe(skew.Seconds())
+	}
+	o.mu.Unlock()
+
+	// report outside of the lock, such that callbacks may use the observer
+	if streak == o.consecutive && o.callback != nil {
+		o.callback(ClockSkewEvent{
+			Exporter:            exporter,
+			ObservationDomainId: msg.ObservationDomainId,
+			Skew:                skew,
+			Consecutive:         streak,
+			ExportTime:          exportTime,
+			ReceivedAt:          receivedAt,
+		})
+	}
+	return skew
+}
+
+// exporter returns the state of exporter, creating it if the exporter is not known yet. If the
+// maximum number of exporters is reached, the exporter is labeled "other". o.mu must be held.
+func (o *ClockSkewObserver) exporter(exporter string, now time.Time) *clockSkewExporter {
+	if e, ok := o.exporters[exporter]; ok {
+		return e
+	}
+	e := &clockSkewExporter{label: exporter}
+	if o.maxExporters > 0 && o.labeled >= o.maxExporters {
+		// make room by forgetting idle exporters before collapsing the exporter into "other"
+		o.sweep(now)
+		if o.labeled >= o.maxExporters {
+			e.label, e.collapsed = clockSkewOther, true
+		}
+	}
+	if !e.collapsed {
+		o.labeled++
+	}
+	o.exporters[exporter] = e
+	return e
+}
+
+// sweep forgets all exporters that were idle for the idle timeout and deletes their label values.
+// o.mu must be held.
+func (o *ClockSkewObserver) sweep(now time.Time) {
+	o.lastSweep = now
+	if o.idleTimeout == 0 {
+		return
+	}
+	for exporter, e := range o.exporters {
+		if now.Sub(e.lastSeen) < o.idleTimeout {
+			continue
+		}
+		delete(o.exporters, exporter)
+		if e.collapsed {
+			continue
+		}
+		o.labeled--
+		if o.collectors != nil {
+			o.collectors.ExporterClockSkewSeconds.DeleteLabelValues(o.listener, exporter)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClockSkewObserver(t *testing.T) {
+	ctx := context.Background()
+
+	// decode decodes a message of a template set exported at exportTime in observation domain 1
+	decode := func(t *testing.T, exportTime time.Time) *Message {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache), DecoderOptions{})
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(uint32(exportTime.Unix()), 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	now := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("reports exporters skewed for consecutive messages", func(t *testing.T) {
+		var events []ClockSkewEvent
+		o := NewClockSkewObserver(
+			WithClockSkewThreshold(time.Minute, 3),
+			WithClockSkewCallback(func(e ClockSkewEvent) { events = append(events, e) }),
+		)
+
+		// the exporter's clock runs 5 minutes behind, another exporter's clock is correct
+		for i := 0; i < 5; i++ {
+			receivedAt := now.Add(time.Duration(i) * time.Second)
+			if skew := o.Observe("192.0.2.1:4739", decode(t, receivedAt.Add(-5*time.Minute)), receivedAt); skew != 5*time.Minute {
+				t.Errorf("expected skew of 5m, got %s", skew)
+			}
+			o.Observe("192.0.2.2:4739", decode(t, receivedAt), receivedAt)
+		}
+		if len(events) != 1 {
+			t.Fatalf("expected a single event for the streak, got %v", events)
+		}
+		e := events[0]
+		if e.Exporter != "192.0.2.1:4739" || e.ObservationDomainId != 1 {
+			t.Errorf("expected event of exporter 192.0.2.1:4739 in observation domain 1, got %s in %d", e.Exporter, e.ObservationDomainId)
+		}
+		if e.Skew != 5*time.Minute || e.Consecutive != 3 {
+			t.Errorf("expected skew of 5m for 3 messages, got %s for %d", e.Skew, e.Consecutive)
+		}
+		if !e.ReceivedAt.Equal(now.Add(2*time.Second)) || !e.ExportTime.Equal(now.Add(2*time.Second-5*time.Minute)) {
+			t.Errorf("expected times of the third message, got export time %s received at %s", e.ExportTime, e.ReceivedAt)
+		}
+	})
+
+	t.Run("messages within threshold reset the streak", func(t *testing.T) {
+		var events []ClockSkewEvent
+		o := NewClockSkewObserver(
+			WithClockSkewThreshold(time.Minute, 2),
+			WithClockSkewCallback(func(e ClockSkewEvent) { events = append(events, e) }),
+		)
+		// the exporter's clock runs an hour ahead, except for a single message
+		for _, skewed := range []bool{true, false, true, true, false, true, true} {
+			exportTime := now
+			if skewed {
+				exportTime = now.Add(time.Hour)
+			}
+			o.Observe("exporter", decode(t, exportTime), now)
+		}
+		if len(events) != 2 {
+			t.Fatalf("expected an event per streak, got %v", events)
+		}
+		for _, e := range events {
+			if e.Skew != -time.Hour {
+				t.Errorf("expected negative skew of clocks running ahead, got %s", e.Skew)
+			}
+		}
+	})
+
+	t.Run("skews are recorded in metrics", func(t *testing.T) {
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		o := NewClockSkewObserver(WithClockSkewMetrics(m, "test"))
+		o.Observe("exporter", decode(t, now.Add(-30*time.Second)), now)
+		o.Observe("exporter", decode(t, now), now)
+
+		if c := testutil.CollectAndCount(m.ExporterClockSkewSeconds); c != 1 {
+			t.Errorf("expected a histogram for the exporter, got %d", c)
+		}
+		expected := `
+# HELP exporter_clock_skew_seconds Difference between the receive time and the export time of messages per exporter in seconds
+# TYPE exporter_clock_skew_seconds histogram
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="-3600"} 0
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="-600"} 0
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="-60"} 0
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="-10"} 0
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="-1"} 0
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="0"} 1
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="1"} 1
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="10"} 1
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="60"} 2
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="600"} 2
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="3600"} 2
+exporter_clock_skew_seconds_bucket{exporter="exporter",listener="test",le="+Inf"} 2
+exporter_clock_skew_seconds_sum{exporter="exporter",listener="test"} 30
+exporter_clock_skew_seconds_count{exporter="exporter",listener="test"} 2
+`
+		if err := testutil.CollectAndCompare(m.ExporterClockSkewSeconds, bytes.NewBufferString(expected)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("exporters exceeding the maximum are labeled other", func(t *testing.T) {
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		o := NewClockSkewObserver(WithClockSkewMetrics(m, "test"), WithClockSkewExporterLimits(2, 0))
+		for _, exporter := range []string{"a", "b", "c", "d", "a"} {
+			o.Observe(exporter, decode(t, now), now)
+		}
+
+		if c := testutil.CollectAndCount(m.ExporterClockSkewSeconds); c != 3 {
+			t.Errorf("expected histograms of a, b, and other, got %d", c)
+		}
+		for _, exporter := range []string{"a", "b", "other"} {
+			if !m.ExporterClockSkewSeconds.DeleteLabelValues("test", exporter) {
+				t.Errorf("expected histogram of %s", exporter)
+			}
+		}
+	})
+
+	t.Run("idle exporters are forgotten", func(t *testing.T) {
+		var events []ClockSkewEvent
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		o := NewClockSkewObserver(
+			WithClockSkewThreshold(time.Minute, 2),
+			WithClockSkewCallback(func(e ClockSkewEvent) { events = append(events, e) }),
+			WithClockSkewMetrics(m, "test"),
+			WithClockSkewExporterLimits(1, time.Minute),
+		)
+
+		// the skewed exporter disappears after a single message
+		o.Observe("a", decode(t, now.Add(-time.Hour)), now)
+		later := now.Add(2 * time.Minute)
+		o.Observe("b", decode(t, later), later)
+
+		o.mu.Lock()
+		_, ok := o.exporters["a"]
+		o.mu.Unlock()
+		if ok {
+			t.Error("expected idle exporter to be forgotten")
+		}
+		if c := testutil.CollectAndCount(m.ExporterClockSkewSeconds); c != 1 {
+			t.Errorf("expected only the histogram of b, got %d", c)
+		}
+		if !m.ExporterClockSkewSeconds.DeleteLabelValues("test", "b") {
+			t.Error("expected b to be labeled individually after a was forgotten")
+		}
+
+		// the streak of the forgotten exporter starts over
+		o.Observe("a", decode(t, later.Add(-time.Hour)), later)
+		if len(events) != 0 {
+			t.Errorf("expected streak of forgotten exporter to start over, got %v", events)
+		}
+	})
+
+	t.Run("collector observes messages of exporters", func(t *testing.T) {
+		events := make(chan ClockSkewEvent, 1)
+		observer := NewClockSkewObserver(
+			WithClockSkewThreshold(time.Hour, 2),
+			WithClockSkewCallback(func(e ClockSkewEvent) { events <- e }),
+		)
+		c, addr := startTestCollector(t, CollectorOptions{ClockSkewObserver: observer})
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		exporter := conn.LocalAddr().String()
+
+		// the exporter's clock is stuck in 2023
+		sent := time.Now()
+		if _, err := conn.Write(newTestCollectorMessage(1700000000, 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(newTestCollectorMessage(1700000001, 1, 256, []byte{0x01, 0xbb})); err != nil {
+			t.Fatal(err)
+		}
+
+		envelope := receiveRecords(t, c, 1)[0]
+		if envelope.ReceivedAt.Before(sent) || time.Since(envelope.ReceivedAt) > time.Minute {
+			t.Errorf("expected record to be received after %s, got %s", sent, envelope.ReceivedAt)
+		}
+
+		select {
+		case e := <-events:
+			if e.Exporter != exporter {
+				t.Errorf("expected event of exporter %s, got %s", exporter, e.Exporter)
+			}
+			if expected := envelope.ReceivedAt.Sub(time.Unix(1700000001, 0)); e.Skew != expected {
+				t.Errorf("expected skew %s, got %s", expected, e.Skew)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for clock skew event")
+		}
+	})
+}
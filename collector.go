@@ -0,0 +1,451 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// TransportUDP makes a Collector receive messages with a UDPListener
+	TransportUDP string = "udp"
+	// TransportTCP makes a Collector receive messages with a TCPListener
+	TransportTCP string = "tcp"
+)
+
+const (
+	// DefaultCollectorWorkers is the number of decoder workers of collectors created without
+	// CollectorOptions.Workers
+	DefaultCollectorWorkers int = 1
+
+	// DefaultCollectorBufferSize is the number of records buffered in the channel returned by
+	// Collector.Records if CollectorOptions.BufferSize is not set
+	DefaultCollectorBufferSize int = 1024
+
+	// DefaultCollectorIdleTimeout is the duration after which the decoder of an exporter without
+	// messages is released if CollectorOptions.IdleTimeout is not set
+	DefaultCollectorIdleTimeout time.Duration = 30 * time.Minute
+)
+
+// CollectorOptions configures a Collector created with NewCollector
+type CollectorOptions struct {
+	// Transport is either TransportUDP or TransportTCP. Defaults to TransportUDP.
+	Transport string
+
+	// BindAddr is the address the listener of the collector binds to, e.g., "[::]:4739"
+	BindAddr string
+
+	// TemplateCache creates the template cache of an exporter. As templates are scoped to the
+	// transport session they were received on, every exporter address, i.e., IP address and port,
+	// is decoded with its own template cache. Caches implementing StatefulTemplateCache are started
+	// for the lifetime of the exporter's decoder, see IdleTimeout. Defaults to
+	// NewDefaultEphemeralCache.
+	TemplateCache func(exporter netip.AddrPort) TemplateCache
+
+	// FieldCache creates the field cache used for decoding the messages of an exporter from the
+	// exporter's template cache. Defaults to NewIANAFieldCache.
+	FieldCache func(templates TemplateCache) (FieldCache, error)
+
+	// DecoderOptions are passed to the decoder of each exporter
+	DecoderOptions DecoderOptions
+
+	// Workers is the number of goroutines decoding messages. Messages of the same exporter are
+	// always decoded by the same worker, such that templates are added before the data sets
+	// referencing them are decoded. Defaults to DefaultCollectorWorkers.
+	Workers int
+
+	// BufferSize is the number of records buffered in the channel returned by Records. Defaults
+	// to DefaultCollectorBufferSize.
+	BufferSize int
+
+	// IdleTimeout is the duration after which the decoder and the caches of an exporter that did
+	// not send any message are released, such that exporters changing their source port, e.g., on
+	// restart, do not accumulate decoders. Over TCP, the decoder of an exporter is additionally
+	// released once its connection is closed, as templates are scoped to the connection. Template
+	// caches implementing StatefulTemplateCache are started when the decoder of their exporter is
+	// created, and stopped on release by cancelling the context passed to Start. Defaults to
+	// DefaultCollectorIdleTimeout.
+	IdleTimeout time.Duration
+
+	// ClockSkewObserver, if not nil, observes the export time of each decoded message against the
+	// time it was received at, with exporters identified by their address
+	ClockSkewObserver *ClockSkewObserver
+}
+
+// RecordEnvelope is a single data record received by a Collector together with the information
+// of the message it was contained in
+type RecordEnvelope struct {
+	// Exporter is the address of the exporter the message was received from
+	Exporter netip.AddrPort
+
+	ObservationDomainId uint32
+	ExportTime          time.Time
+	TemplateId          uint16
+	// ReceivedAt is the time the listener received the message at
+	ReceivedAt time.Time
+
+	Record DataRecord
+}
+
+// Collector receives IPFIX messages on a UDP or TCP listener, decodes them, and emits their data
+// records on the channel returned by Records. It bundles the listener, the decoders, and the caches
+// used for decoding, which are otherwise wired together by hand as in the collector examples.
+//
+// Messages of each exporter are decoded with a template cache of their own, such that templates of
+// different exporters with the same observation domain and template id do not collide.
+type Collector struct {
+	options CollectorOptions
+
+	records chan RecordEnvelope
+	metrics *Metrics
+}
+
+// NewCollector creates a new Collector from opts. The collector does not receive messages
+// until Start is called.
+func NewCollector(opts CollectorOptions) *Collector {
+	if opts.Transport == "" {
+		opts.Transport = TransportUDP
+	}
+	if opts.TemplateCache == nil {
+		opts.TemplateCache = func(netip.AddrPort) TemplateCache {
+			return NewDefaultEphemeralCache()
+		}
+	}
+	if opts.FieldCache == nil {
+		opts.FieldCache = func(templates TemplateCache) (FieldCache, error) {
+			return NewIANAFieldCache(templates), nil
+		}
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultCollectorWorkers
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultCollectorBufferSize
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultCollectorIdleTimeout
+	}
+	return &Collector{
+		options: opts,
+		records: make(chan RecordEnvelope, opts.BufferSize),
+		metrics: NewMetrics(),
+	}
+}
+
+// Register registers the metrics of the collector's listener and decoders with r,
+// see Metrics.Register.
+func (c *Collector) Register(r prometheus.Registerer) error {
+	return c.metrics.Register(r)
+}
+
+// Records returns the channel of decoded data records. The channel is closed when Start returns.
+func (c *Collector) Records() <-chan RecordEnvelope {
+	return c.records
+}
+
+// Start runs the listener and the decoder workers until ctx is cancelled or the listener fails.
+// Start blocks and returns the error of the listener, if any. Consumers must keep reading from
+// Records, otherwise the collector stops decoding messages once the channel's buffer is full.
+func (c *Collector) Start(ctx context.Context) error {
+	defer close(c.records)
+
+	packets := make(chan packet, DefaultUDPChannelBufferSize)
+	var listen func(context.Context) error
+	switch c.options.Transport {
+	case TransportUDP:
+		l := NewUDPListener(c.options.BindAddr).WithMetrics(c.metrics)
+		l.packets = packets
+		listen = l.Listen
+	case TransportTCP:
+		l := NewTCPListener(c.options.BindAddr).WithMetrics(c.metrics)
+		l.packets = packets
+		listen = l.Listen
+	default:
+		return fmt.Errorf("failed to start collector, unsupported transport %q", c.options.Transport)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listenerErr := make(chan error, 1)
+	go func() {
+		listenerErr <- listen(ctx)
+		// stop the workers if the listener failed
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	workers := make([]chan packet, c.options.Workers)
+	for i := range workers {
+		workers[i] = make(chan packet, DefaultUDPChannelBufferSize)
+		wg.Add(1)
+		go func(in <-chan packet) {
+			defer wg.Done()
+			c.work(ctx, in)
+		}(workers[i])
+	}
+
+	c.dispatch(ctx, packets, workers)
+
+	for _, w := range workers {
+		close(w)
+	}
+	wg.Wait()
+	return <-listenerErr
+}
+
+// dispatch passes each packet to the worker responsible for the packet's exporter until
+// ctx is cancelled or the listener closed packets
+func (c *Collector) dispatch(ctx context.Context, packets <-chan packet, workers []chan packet) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p, ok := <-packets:
+			if !ok {
+				return
+			}
+			select {
+			case workers[shardOf(p.exporter, len(workers))] <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// exporterDecoder is the decoder of a single exporter held by a worker
+type exporterDecoder struct {
+	decoder   *Decoder
+	templates TemplateCache
+	// lastSeen is the time the latest message of the exporter was received at
+	lastSeen time.Time
+
+	// stop cancels the context of a stateful template cache's Start, which closes done once Start
+	// returned. Both are nil for template caches that are not stateful.
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// work decodes the packets of in with a decoder per exporter and emits their data records.
+// Decoders of exporters whose connection closed or that were idle for the idle timeout are released.
+func (c *Collector) work(ctx context.Context, in <-chan packet) {
+	logger := FromContext(ctx)
+
+	// decoders are only accessed by this worker, as exporters are always dispatched to the same worker
+	decoders := make(map[netip.AddrPort]*exporterDecoder)
+	defer func() {
+		for exporter, d := range decoders {
+			c.release(ctx, exporter, d)
+		}
+	}()
+
+	sweep := time.NewTicker(c.options.IdleTimeout / 2)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case now := <-sweep.C:
+			for exporter, d := range decoders {
+				if now.Sub(d.lastSeen) >= c.options.IdleTimeout {
+					delete(decoders, exporter)
+					c.release(ctx, exporter, d)
+				}
+			}
+		case p, ok := <-in:
+			if !ok {
+				return
+			}
+			if p.closed {
+				if d, ok := decoders[p.exporter]; ok {
+					delete(decoders, p.exporter)
+					c.release(ctx, p.exporter, d)
+				}
+				continue
+			}
+
+			d, ok := decoders[p.exporter]
+			if !ok {
+				var err error
+				d, err = c.newDecoder(ctx, p.exporter)
+				if err != nil {
+					logger.Error(err, "failed to create decoder for exporter", "exporter", p.exporter.String())
+					continue
+				}
+				decoders[p.exporter] = d
+			}
+			d.lastSeen = p.receivedAt
+
+			if !c.decode(ctx, d.decoder, p) {
+				return
+			}
+		}
+	}
+}
+
+// decode decodes the messages of p with d and emits their data records. It returns false if ctx
+// was cancelled before all records were sent.
+func (c *Collector) decode(ctx context.Context, d *Decoder, p packet) bool {
+	logger := FromContext(ctx)
+
+	// with DecoderOptions.ConcatenatedMessages, a datagram may contain multiple messages,
+	// which are decoded until the first error
+	buf := bytes.NewBuffer(p.payload)
+	for buf.Len() > 0 {
+		msg, err := d.Decode(ctx, buf)
+		if err != nil {
+			logger.Error(err, "failed to decode IPFIX message", "exporter", p.exporter.String())
+		}
+		// the message is discarded entirely if nil, otherwise records of sets preceding the
+		// failing set are still emitted
+		if msg != nil && c.options.ClockSkewObserver != nil {
+			c.options.ClockSkewObserver.Observe(p.exporter.String(), msg, p.receivedAt)
+		}
+		if msg != nil && !c.emit(ctx, p, msg) {
+			return false
+		}
+		if err != nil {
+			break
+		}
+	}
+	return true
+}
+
+// release stops the template cache of an exporter's decoder if it is stateful and waits for its
+// Start to return, e.g., for a PersistentCache to write its final checkpoint.
+func (c *Collector) release(ctx context.Context, exporter netip.AddrPort, d *exporterDecoder) {
+	FromContext(ctx).V(2).Info("releasing decoder of exporter", "exporter", exporter.String())
+	if d.stop != nil {
+		d.stop()
+		<-d.done
+	}
+}
+
+// emit sends the data records of msg, decoded from p, to the records channel. It returns false if
+// ctx was cancelled before all records were sent.
+func (c *Collector) emit(ctx context.Context, p packet, msg *Message) bool {
+	exportTime := time.Unix(int64(msg.ExportTime), 0).UTC()
+	for _, set := range msg.Sets {
+		ds, ok := set.Set.(*DataSet)
+		if !ok {
+			continue
+		}
+		for _, dr := range ds.Records {
+			select {
+			case c.records <- RecordEnvelope{
+				Exporter:            p.exporter,
+				ObservationDomainId: msg.ObservationDomainId,
+				ExportTime:          exportTime,
+				TemplateId:          dr.TemplateId,
+				ReceivedAt:          p.receivedAt,
+				Record:              dr,
+			}:
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newDecoder creates a decoder with its own template cache for a new exporter
+func (c *Collector) newDecoder(ctx context.Context, exporter netip.AddrPort) (*exporterDecoder, error) {
+	templates := c.options.TemplateCache(exporter)
+	if templates == nil {
+		return nil, errors.New("template cache is nil")
+	}
+	fields, err := c.options.FieldCache(templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create field cache, %w", err)
+	}
+	d := &exporterDecoder{
+		decoder:   NewDecoder(templates, fields, c.options.DecoderOptions).WithMetrics(c.metrics, c.options.BindAddr),
+		templates: templates,
+	}
+
+	// stateful caches, e.g., PersistentCache, block adding templates or never expire them until
+	// they are started. Start blocks for the lifetime of the exporter's decoder.
+	if sc, ok := templates.(StatefulTemplateCache); ok {
+		var cacheCtx context.Context
+		cacheCtx, d.stop = context.WithCancel(ctx)
+		d.done = make(chan struct{})
+		go func() {
+			defer close(d.done)
+			if err := sc.Start(cacheCtx); err != nil {
+				FromContext(ctx).Error(err, "failed to start template cache of exporter", "exporter", exporter.String())
+			}
+		}()
+	}
+	return d, nil
+}
+
+// packet is a raw IPFIX message together with the address of the exporter it was received from
+// and the time it was received at
+type packet struct {
+	exporter   netip.AddrPort
+	payload    []byte
+	receivedAt time.Time
+
+	// closed marks the end of the exporter's TCP connection instead of a message, such that
+	// consumers can release the state of the transport session
+	closed bool
+}
+
+// newPacket creates a packet from the remote address of a listener's socket. IPv4 exporters
+// received on dual-stack sockets are unmapped, such that their address is the same regardless
+// of the listener's bind address.
+func newPacket(addr net.Addr, payload []byte) packet {
+	return packet{
+		exporter:   exporterAddrPort(addr),
+		payload:    payload,
+		receivedAt: time.Now(),
+	}
+}
+
+// exporterAddrPort returns the unmapped address and port of a UDP or TCP remote address, and the
+// zero value for other addresses
+func exporterAddrPort(addr net.Addr) netip.AddrPort {
+	var exporter netip.AddrPort
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		exporter = a.AddrPort()
+	case *net.TCPAddr:
+		exporter = a.AddrPort()
+	}
+	return netip.AddrPortFrom(exporter.Addr().Unmap(), exporter.Port())
+}
+
+// shardOf maps an exporter to one of n workers
+func shardOf(exporter netip.AddrPort, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	b, _ := exporter.MarshalBinary()
+	h.Write(b)
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,413 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startTestCollector runs a UDP collector on a free port and waits until its listener receives packets
+func startTestCollector(t *testing.T, opts CollectorOptions) (*Collector, string) {
+	addr := freeUDPAddr(t)
+	opts.Transport = TransportUDP
+	opts.BindAddr = addr
+	c := NewCollector(opts)
+	if err := c.Register(prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Error("collector did not shut down")
+		}
+	})
+
+	// probe the listener with packets too short to be decoded until it is bound
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	packets := c.metrics.UDPPacketsTotal.WithLabelValues(addr)
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(packets) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("collector did not receive packets")
+		}
+		_, _ = conn.Write([]byte{0x00, 0x0a})
+		time.Sleep(5 * time.Millisecond)
+	}
+	return c, addr
+}
+
+// receiveRecords reads n envelopes from the collector
+func receiveRecords(t *testing.T, c *Collector, n int) []RecordEnvelope {
+	envelopes := make([]RecordEnvelope, 0, n)
+	for len(envelopes) < n {
+		select {
+		case e := <-c.Records():
+			envelopes = append(envelopes, e)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %d records, got %d", n, len(envelopes))
+		}
+	}
+	return envelopes
+}
+
+// newTestCollectorMessage creates an IPFIX message of a single set
+func newTestCollectorMessage(exportTime uint32, observationDomainId uint32, setId uint16, set []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(16+4+len(set)))
+	b = binary.BigEndian.AppendUint32(b, exportTime)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, observationDomainId)
+	b = binary.BigEndian.AppendUint16(b, setId)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(set)))
+	return append(b, set...)
+}
+
+// releaseRecordingCache is a template cache that reports when the collector releases it, i.e.,
+// when the context of its Start is cancelled
+type releaseRecordingCache struct {
+	*EphemeralCache
+	exporter netip.AddrPort
+	released chan<- netip.AddrPort
+}
+
+func (c *releaseRecordingCache) Start(ctx context.Context) error {
+	<-ctx.Done()
+	c.released <- c.exporter
+	return nil
+}
+
+// releaseRecordingCaches returns a template cache factory for CollectorOptions.TemplateCache whose
+// caches report their exporter on the returned channel when the collector releases them
+func releaseRecordingCaches() (func(netip.AddrPort) TemplateCache, <-chan netip.AddrPort) {
+	released := make(chan netip.AddrPort, 16)
+	return func(exporter netip.AddrPort) TemplateCache {
+		return &releaseRecordingCache{
+			EphemeralCache: NewDefaultEphemeralCache().(*EphemeralCache),
+			exporter:       exporter,
+			released:       released,
+		}
+	}, released
+}
+
+func TestCollector(t *testing.T) {
+	fieldCache := func(tc TemplateCache) (FieldCache, error) {
+		return NewFieldCache(tc, CERT())
+	}
+
+	t.Run("emits records of captured message streams", func(t *testing.T) {
+		fixtures, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.ipfix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fixtures) == 0 {
+			t.Fatal("no fixtures found")
+		}
+		c, addr := startTestCollector(t, CollectorOptions{FieldCache: fieldCache, Workers: 2})
+
+		for _, fixture := range fixtures {
+			t.Run(filepath.Base(fixture), func(t *testing.T) {
+				in, err := os.ReadFile(fixture)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// decode the fixture directly for the records the collector is expected to emit
+				templateCache := NewDefaultEphemeralCache()
+				fc, err := fieldCache(templateCache)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoder := NewDecoder(templateCache, fc)
+				expected := make([]RecordEnvelope, 0)
+				r := bytes.NewReader(in)
+				messages := make([][]byte, 0)
+				for r.Len() > 0 {
+					buf, err := ReadMessage(r)
+					if err != nil {
+						t.Fatal(err)
+					}
+					messages = append(messages, bytes.Clone(buf.Bytes()))
+					msg, err := decoder.Decode(context.Background(), buf)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, set := range msg.Sets {
+						if ds, ok := set.Set.(*DataSet); ok {
+							for _, dr := range ds.Records {
+								expected = append(expected, RecordEnvelope{
+									ObservationDomainId: msg.ObservationDomainId,
+									ExportTime:          time.Unix(int64(msg.ExportTime), 0).UTC(),
+									TemplateId:          dr.TemplateId,
+									Record:              dr,
+								})
+							}
+						}
+					}
+				}
+
+				// every fixture is sent from a new socket, i.e., a new exporter
+				conn, err := net.Dial("udp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				exporter := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+				for _, m := range messages {
+					if _, err := conn.Write(m); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				envelopes := receiveRecords(t, c, len(expected))
+				for i, e := range envelopes {
+					if e.Exporter != exporter {
+						t.Errorf("expected exporter %s, got %s", exporter, e.Exporter)
+					}
+					if e.ObservationDomainId != expected[i].ObservationDomainId {
+						t.Errorf("expected observation domain %d, got %d", expected[i].ObservationDomainId, e.ObservationDomainId)
+					}
+					if !e.ExportTime.Equal(expected[i].ExportTime) {
+						t.Errorf("expected export time %s, got %s", expected[i].ExportTime, e.ExportTime)
+					}
+					if e.TemplateId != expected[i].TemplateId {
+						t.Errorf("expected template id %d, got %d", expected[i].TemplateId, e.TemplateId)
+					}
+					if e.Record.String() != expected[i].Record.String() {
+						t.Errorf("expected record %s, got %s", expected[i].Record.String(), e.Record.String())
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("templates are scoped per exporter", func(t *testing.T) {
+		c, addr := startTestCollector(t, CollectorOptions{Workers: 4})
+
+		// both exporters define template 256 in observation domain 1, but with different fields
+		exporters := []struct {
+			template []byte
+			data     []byte
+		}{
+			{
+				// sourceTransportPort
+				template: []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02},
+				data:     []byte{0x01, 0xbb},
+			},
+			{
+				// octetDeltaCount
+				template: []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x08},
+				data:     []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0xdc},
+			},
+		}
+		conns := make(map[netip.AddrPort]int)
+		for i, e := range exporters {
+			conn, err := net.Dial("udp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conns[conn.LocalAddr().(*net.UDPAddr).AddrPort()] = i
+
+			if _, err := conn.Write(newTestCollectorMessage(1700000000, 1, 2, e.template)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write(newTestCollectorMessage(1700000001, 1, 256, e.data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, e := range receiveRecords(t, c, len(exporters)) {
+			i, ok := conns[e.Exporter]
+			if !ok {
+				t.Fatalf("unexpected exporter %s", e.Exporter)
+			}
+			if e.ObservationDomainId != 1 || e.TemplateId != 256 {
+				t.Errorf("expected record of template 256 in observation domain 1, got %d in %d", e.TemplateId, e.ObservationDomainId)
+			}
+			if !e.ExportTime.Equal(time.Unix(1700000001, 0)) {
+				t.Errorf("expected export time of the data message, got %s", e.ExportTime)
+			}
+			switch i {
+			case 0:
+				if v, ok := e.Record.Uint64("sourceTransportPort"); !ok || v != 443 {
+					t.Errorf("expected port 443 from exporter %s, got %s", e.Exporter, e.Record.String())
+				}
+			case 1:
+				if v, ok := e.Record.Uint64("octetDeltaCount"); !ok || v != 1500 {
+					t.Errorf("expected 1500 octets from exporter %s, got %s", e.Exporter, e.Record.String())
+				}
+			}
+		}
+	})
+
+	t.Run("decodes concatenated messages of a datagram", func(t *testing.T) {
+		c, addr := startTestCollector(t, CollectorOptions{DecoderOptions: DecoderOptions{ConcatenatedMessages: true}})
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// template 256 with sourceTransportPort, followed by two records in a second message
+		datagram := newTestCollectorMessage(1700000000, 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})
+		datagram = append(datagram, newTestCollectorMessage(1700000001, 1, 256, []byte{0x01, 0xbb, 0x00, 0x50})...)
+		if _, err := conn.Write(datagram); err != nil {
+			t.Fatal(err)
+		}
+
+		envelopes := receiveRecords(t, c, 2)
+		for i, port := range []uint64{443, 80} {
+			if v, ok := envelopes[i].Record.Uint64("sourceTransportPort"); !ok || v != port {
+				t.Errorf("expected port %d in record %d, got %s", port, i, envelopes[i].Record.String())
+			}
+		}
+	})
+
+	t.Run("decoders of idle exporters are released", func(t *testing.T) {
+		templateCache, released := releaseRecordingCaches()
+		c, addr := startTestCollector(t, CollectorOptions{TemplateCache: templateCache, IdleTimeout: 50 * time.Millisecond})
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		exporter := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+
+		if _, err := conn.Write(newTestCollectorMessage(1700000000, 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(newTestCollectorMessage(1700000001, 1, 256, []byte{0x01, 0xbb})); err != nil {
+			t.Fatal(err)
+		}
+		receiveRecords(t, c, 1)
+
+		// the decoder of the connection probing the listener is released as well
+		timeout := time.After(time.Second)
+		for e := (netip.AddrPort{}); e != exporter; {
+			select {
+			case e = <-released:
+			case <-timeout:
+				t.Fatal("expected decoder of idle exporter to be released")
+			}
+		}
+	})
+
+	t.Run("stateful template caches are started and stopped", func(t *testing.T) {
+		dir := t.TempDir()
+		fileOf := func(exporter netip.AddrPort) string {
+			return filepath.Join(dir, strings.ReplaceAll(exporter.String(), ":", "_")+".json")
+		}
+		templateCache := func(exporter netip.AddrPort) TemplateCache {
+			file, err := os.Create(fileOf(exporter))
+			if err != nil {
+				t.Error(err)
+				return nil
+			}
+			cache, _ := cacheFactory(file)
+			return cache
+		}
+		c, addr := startTestCollector(t, CollectorOptions{TemplateCache: templateCache, IdleTimeout: 50 * time.Millisecond})
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		exporter := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+
+		// adding the template blocks until the persistent cache is started
+		if _, err := conn.Write(newTestCollectorMessage(1700000000, 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(newTestCollectorMessage(1700000001, 1, 256, []byte{0x01, 0xbb})); err != nil {
+			t.Fatal(err)
+		}
+		receiveRecords(t, c, 1)
+
+		// the idle exporter's cache writes its templates when stopped
+		name := fileOf(exporter)
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			b, err := os.ReadFile(name)
+			if err == nil && bytes.Contains(b, []byte(`"template_id":256`)) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected template of exporter to be written to %s, got %q", name, b)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("decoders of closed connections are released", func(t *testing.T) {
+		templateCache, released := releaseRecordingCaches()
+		addr := freeTCPAddr(t)
+		c := NewCollector(CollectorOptions{Transport: TransportTCP, BindAddr: addr, TemplateCache: templateCache})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() { _ = c.Start(ctx) }()
+
+		var conn net.Conn
+		var err error
+		deadline := time.Now().Add(2 * time.Second)
+		for conn, err = net.Dial("tcp", addr); err != nil; conn, err = net.Dial("tcp", addr) {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		exporter := conn.LocalAddr().(*net.TCPAddr).AddrPort()
+
+		template := newTestCollectorMessage(1700000000, 1, 2, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02})
+		if _, err := conn.Write(append(template, newTestCollectorMessage(1700000001, 1, 256, []byte{0x01, 0xbb})...)); err != nil {
+			t.Fatal(err)
+		}
+		receiveRecords(t, c, 1)
+		conn.Close()
+
+		select {
+		case e := <-released:
+			if e != exporter {
+				t.Errorf("expected decoder of %s to be released, got %s", exporter, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected decoder to be released once the connection closed")
+		}
+	})
+
+	t.Run("unsupported transport", func(t *testing.T) {
+		c := NewCollector(CollectorOptions{Transport: "sctp", BindAddr: "127.0.0.1:0"})
+		if err := c.Start(context.Background()); err == nil {
+			t.Error("expected error for unsupported transport")
+		}
+		if _, ok := <-c.Records(); ok {
+			t.Error("expected records channel to be closed")
+		}
+	})
+}
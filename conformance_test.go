@@ -0,0 +1,22 @@
+package ipfix_test
+
+import (
+	"testing"
+
+	"github.com/zoomoid/go-ipfix"
+	"github.com/zoomoid/go-ipfix/ipfixtest"
+)
+
+// TestConformance decodes the fixtures of testdata/conformance, see
+// testdata/conformance/generate.go for their contents. Regenerate the golden files with
+//
+//	go test -run TestConformance . -ipfixtest.update
+func TestConformance(t *testing.T) {
+	ipfixtest.Run(t, "testdata/conformance",
+		ipfixtest.WithFieldCache(func(tc ipfix.TemplateCache) (ipfix.FieldCache, error) {
+			return ipfix.NewFieldCache(tc, ipfix.CERT())
+		}),
+		// routers pad sets to multiples of 4 bytes, which is not retained when decoding
+		ipfixtest.WithNonCanonical("router"),
+	)
+}
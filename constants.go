@@ -17,8 +17,13 @@ limitations under the License.
 package ipfix
 
 import (
+	"bufio"
 	"bytes"
 	"embed"
+	"fmt"
+	"io"
+	"sort"
+	"unicode"
 )
 
 var (
@@ -26,6 +31,9 @@ var (
 	spec embed.FS
 
 	ianaIpfixIEs map[uint16]*InformationElement = MustReadCSV(mustReadFile(spec.ReadFile("hack/ipfix-information-elements.csv")))
+
+	// ianaIpfixIEsByName indexes ianaIpfixIEs by name
+	ianaIpfixIEsByName map[string]*InformationElement = indexByName(ianaIpfixIEs)
 )
 
 func init() {
@@ -34,8 +42,11 @@ func init() {
 
 func initGlobalIANARegistry() {
 	ianaIpfixIEs = MustReadCSV(mustReadFile(spec.ReadFile("hack/ipfix-information-elements.csv")))
+	ianaIpfixIEsByName = indexByName(ianaIpfixIEs)
 }
 
+// iana returns the global registry of IANA-assigned information elements. The returned
+// elements MUST NOT be mutated, use IANA for obtaining copies instead.
 func iana() map[uint16]*InformationElement {
 	if len(ianaIpfixIEs) == 0 {
 		initGlobalIANARegistry()
@@ -44,6 +55,129 @@ func iana() map[uint16]*InformationElement {
 	return ianaIpfixIEs
 }
 
+func indexByName(ies map[uint16]*InformationElement) map[string]*InformationElement {
+	m := make(map[string]*InformationElement, len(ies))
+	for _, ie := range ies {
+		// deprecated duplicates of other elements are not named in the registry
+		if ie.Name != "" {
+			m[ie.Name] = ie
+		}
+	}
+	return m
+}
+
+// IANA returns all information elements assigned by IANA, keyed by their id. The elements
+// are copies, such that mutating them does not affect the registry used by NewIANAFieldCache.
+func IANA() map[uint16]*InformationElement {
+	ies := iana()
+	m := make(map[uint16]*InformationElement, len(ies))
+	for id, ie := range ies {
+		c := ie.Clone()
+		m[id] = &c
+	}
+	return m
+}
+
+// IANAByName returns a copy of the information element assigned by IANA with the given name,
+// e.g., "octetDeltaCount", and false if no such element exists.
+func IANAByName(name string) (*InformationElement, bool) {
+	iana()
+	ie, ok := ianaIpfixIEsByName[name]
+	if !ok {
+		return nil, false
+	}
+	c := ie.Clone()
+	return &c, true
+}
+
+// LookupIE returns a copy of the information element assigned by IANA with the given id if pen
+// is 0. For any other pen, i.e., enterprise-specific information elements, and unassigned ids,
+// LookupIE returns nil.
+func LookupIE(pen uint32, id uint16) *InformationElement {
+	if pen != 0 {
+		return nil
+	}
+	ie, ok := iana()[id]
+	if !ok {
+		return nil
+	}
+	c := ie.Clone()
+	return &c
+}
+
+// LoadIANARegistryFrom reads information elements from the IANA IPFIX information element registry
+// in either of its published formats, CSV or XML, e.g., a copy of
+// https://www.iana.org/assignments/ipfix/ipfix-information-elements.csv obtained at runtime.
+// The elements are sorted by id. Rows without an abstract data type, e.g., reserved ids, are skipped.
+//
+// Unlike IANA, which is read from the registry embedded at build time, this allows updating the
+// information elements without a new release of the library:
+//
+//	ies, err := ipfix.LoadIANARegistryFrom(f)
+//	if err != nil {
+//		return err
+//	}
+//	err = ipfix.LoadRegistries(ctx, fieldCache, ies)
+//
+// Data types unknown to this library cause an error wrapping ErrUnknownDataType.
+func LoadIANARegistryFrom(r io.Reader) ([]InformationElement, error) {
+	br := bufio.NewReader(r)
+
+	// the XML registry starts with a declaration or the registry node, the CSV registry with a header
+	isXML := false
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry, %w", err)
+		}
+		if unicode.IsSpace(c) || c == '\uFEFF' {
+			continue
+		}
+		isXML = c == '<'
+		if err := br.UnreadRune(); err != nil {
+			return nil, fmt.Errorf("failed to read registry, %w", err)
+		}
+		break
+	}
+
+	var ies []InformationElement
+	if isXML {
+		m, _, err := readXML(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read XML registry, %w", err)
+		}
+		ies = make([]InformationElement, 0, len(m))
+		for _, ie := range m {
+			ies = append(ies, ie)
+		}
+	} else {
+		m, err := ReadCSV(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV registry, %w", err)
+		}
+		ies = make([]InformationElement, 0, len(m))
+		for _, ie := range m {
+			ies = append(ies, *ie)
+		}
+	}
+
+	n := 0
+	for _, ie := range ies {
+		if ie.Type != nil {
+			ies[n] = ie
+			n++
+		}
+	}
+	ies = ies[:n]
+	sort.Slice(ies, func(i, j int) bool {
+		return ies[i].Id < ies[j].Id
+	})
+	return ies, nil
+}
+
 func mustReadFile(f []byte, err error) *bytes.Buffer {
 	if err != nil {
 		panic(err)
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import "testing"
+
+func TestIANA(t *testing.T) {
+	t.Run("id and name are consistent", func(t *testing.T) {
+		ies := IANA()
+		if len(ies) == 0 {
+			t.Fatal("expected IANA registry to not be empty")
+		}
+		for id, ie := range ies {
+			if ie.Id != id {
+				t.Errorf("expected element %s at %d to have id %d, got %d", ie.Name, id, id, ie.Id)
+			}
+			if byId := LookupIE(0, id); byId == nil || byId.Name != ie.Name {
+				t.Errorf("expected element %d to be %s, got %v", id, ie.Name, byId)
+			}
+			if ie.Name == "" {
+				// deprecated duplicates of other elements are not named in the registry
+				continue
+			}
+			byName, ok := IANAByName(ie.Name)
+			if !ok {
+				t.Errorf("expected element %s to be found by name", ie.Name)
+				continue
+			}
+			if byName.Id != id {
+				t.Errorf("expected element %s to have id %d, got %d", ie.Name, id, byName.Id)
+			}
+		}
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		ie, ok := IANAByName("octetDeltaCount")
+		if !ok || ie.Id != 1 {
+			t.Errorf("expected octetDeltaCount to have id 1, got %v", ie)
+		}
+		if _, ok := IANAByName("notAnInformationElement"); ok {
+			t.Error("expected unknown name not to be found")
+		}
+		if ie := LookupIE(0, 2); ie == nil || ie.Name != "packetDeltaCount" {
+			t.Errorf("expected packetDeltaCount for id 2, got %v", ie)
+		}
+		if ie := LookupIE(6871, 2); ie != nil {
+			t.Errorf("expected no element for enterprise-specific id, got %v", ie)
+		}
+		if ie := LookupIE(0, 32767); ie != nil {
+			t.Errorf("expected no element for unassigned id, got %v", ie)
+		}
+	})
+
+	t.Run("returned elements are copies", func(t *testing.T) {
+		ie, _ := IANAByName("octetDeltaCount")
+		ie.Name = "mutated"
+		*ie.Type = "string"
+		IANA()[1].Name = "mutated"
+		LookupIE(0, 1).Id = 0
+
+		original, ok := IANAByName("octetDeltaCount")
+		if !ok || original.Id != 1 || *original.Type != "unsigned64" {
+			t.Errorf("expected registry to be unaffected by mutations, got %v", original)
+		}
+	})
+}
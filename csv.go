@@ -18,9 +18,12 @@ package ipfix
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/zoomoid/go-ipfix/iana/units"
 )
 
 func MustReadCSV(r io.Reader) map[uint16]*InformationElement {
@@ -49,14 +52,23 @@ func ReadCSV(r io.Reader) (map[uint16]*InformationElement, error) {
 
 		field := InformationElement{}
 
-		id, _ := strconv.Atoi(record[0])
+		id, err := strconv.Atoi(record[0])
+		if err != nil {
+			// rows of id ranges, e.g., "105-127" for NetFlow v9 compatibility or unassigned ids,
+			// do not define information elements
+			continue
+		}
 		field.Id = uint16(id)
 
 		field.Name = record[1]
 
 		if typ := record[2]; typ != "" {
+			c, err := LookupConstructor(typ)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read information element %d, %w", id, err)
+			}
 			field.Type = &typ
-			field.Constructor = LookupConstructor(typ)
+			field.Constructor = c
 		}
 
 		if sem := record[3]; sem != "" {
@@ -70,8 +82,9 @@ func ReadCSV(r io.Reader) (map[uint16]*InformationElement, error) {
 			field.Description = &description
 		}
 
-		if units := record[6]; units != "" {
-			field.Units = &units
+		if u := record[6]; u != "" {
+			u = normalizeUnits(u)
+			field.Units = &u
 		}
 
 		fr := strings.Split(record[7], "-")
@@ -79,13 +92,13 @@ func ReadCSV(r io.Reader) (map[uint16]*InformationElement, error) {
 			lows, highs := fr[0], fr[1]
 			var low, high int
 			if strings.HasPrefix(lows, "0x") {
-				l, _ := strconv.ParseInt(lows, 16, 32)
+				l, _ := strconv.ParseInt(lows, 0, 32)
 				low = int(l)
 			} else {
 				low, _ = strconv.Atoi(lows)
 			}
 			if strings.HasPrefix(highs, "0x") {
-				h, _ := strconv.ParseInt(highs, 16, 32)
+				h, _ := strconv.ParseInt(highs, 0, 32)
 				high = int(h)
 			} else {
 				high, _ = strconv.Atoi(highs)
@@ -105,12 +118,29 @@ func ReadCSV(r io.Reader) (map[uint16]*InformationElement, error) {
 		}
 
 		if revision := record[10]; revision != "" {
-			rev, _ := strconv.Atoi(record[9])
+			rev, _ := strconv.Atoi(revision)
 			field.Revision = &rev
 		}
 
+		if len(record) > 11 && record[11] != "" {
+			date := record[11]
+			field.Date = &date
+		}
+
 		fieldMap[uint16(id)] = &field
 	}
 
 	return fieldMap, nil
 }
+
+// normalizeUnits maps the units of a registry onto the constants of the units package, which use
+// hyphens where the IANA registry uses spaces, e.g., "4-octet words". Units unknown to the units
+// package are returned as-is.
+func normalizeUnits(u string) string {
+	if n := strings.ReplaceAll(strings.TrimSpace(u), " ", "-"); n != u {
+		if _, ok := units.ToNumber(n); ok {
+			return n
+		}
+	}
+	return u
+}
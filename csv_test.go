@@ -17,15 +17,111 @@ limitations under the License.
 package ipfix
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+	"github.com/zoomoid/go-ipfix/iana/units"
 )
 
 func TestReadCSV(t *testing.T) {
-	srcFile, _ := os.Open("./ipfix-information-elements.csv")
+	srcFile, _ := os.Open("./hack/ipfix-information-elements.csv")
 	defer srcFile.Close()
 	_, err := ReadCSV(srcFile)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestLoadIANARegistryFrom(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		srcFile, err := os.Open("./hack/ipfix-information-elements.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srcFile.Close()
+
+		ies, err := LoadIANARegistryFrom(srcFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := 0
+		for _, ie := range IANA() {
+			if ie.Type != nil {
+				expected++
+			}
+		}
+		if len(ies) != expected {
+			t.Errorf("expected %d information elements, got %d", expected, len(ies))
+		}
+
+		for _, ie := range ies {
+			switch ie.Id {
+			case 1:
+				if ie.Name != "octetDeltaCount" || ie.Semantics != semantics.DeltaCounter || ie.Units == nil || *ie.Units != units.Octets || ie.Constructor == nil {
+					t.Errorf("expected octetDeltaCount, got %v", ie)
+				}
+			case 207:
+				if ie.Units == nil || *ie.Units != units.FourOctetWords {
+					t.Errorf("expected ipv4IHL to be measured in %s, got %v", units.FourOctetWords, ie.Units)
+				}
+			}
+		}
+	})
+
+	t.Run("XML", func(t *testing.T) {
+		registry := `<?xml version='1.0' encoding='UTF-8'?>
+<registry xmlns="http://www.iana.org/assignments" id="ipfix">
+  <registry id="ipfix-information-elements">
+    <record>
+      <name>octetDeltaCount</name>
+      <dataType>unsigned64</dataType>
+      <group>flowCounter</group>
+      <dataTypeSemantics>deltaCounter</dataTypeSemantics>
+      <elementId>1</elementId>
+      <status>current</status>
+      <units>octets</units>
+      <revision>0</revision>
+      <date>2013-02-18</date>
+    </record>
+    <record>
+      <dataType>unsigned8</dataType>
+      <dataTypeSemantics>identifier</dataTypeSemantics>
+      <name>ipv4IHL</name>
+      <elementId>207</elementId>
+      <units>4-octet words</units>
+    </record>
+    <record>
+      <name>Reserved</name>
+      <elementId>0</elementId>
+    </record>
+  </registry>
+</registry>`
+
+		ies, err := LoadIANARegistryFrom(strings.NewReader(registry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ies) != 2 {
+			t.Fatalf("expected 2 information elements, got %v", ies)
+		}
+		if ie := ies[0]; ie.Id != 1 || ie.Semantics != semantics.DeltaCounter || *ie.Units != units.Octets {
+			t.Errorf("expected octetDeltaCount, got %v", ie)
+		}
+		if ie := ies[1]; ie.Id != 207 || ie.Semantics != semantics.Identifier || *ie.Units != units.FourOctetWords {
+			t.Errorf("expected ipv4IHL, got %v", ie)
+		}
+	})
+
+	t.Run("unknown data type", func(t *testing.T) {
+		registry := "ElementID,Name,Abstract Data Type,Data Type Semantics,Status,Description,Units,Range,Additional Information,Reference,Revision,Date\n" +
+			"1,octetDeltaCount,unsigned128,deltaCounter,current,,octets,,,,0,2013-02-18\n"
+		_, err := LoadIANARegistryFrom(strings.NewReader(registry))
+		if !errors.Is(err, ErrUnknownDataType) {
+			t.Errorf("expected ErrUnknownDataType, got %v", err)
+		}
+	})
+}
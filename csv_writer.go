@@ -0,0 +1,218 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// CSVListMode determines how CSVWriter writes fields of the structured data types of RFC 6313
+type CSVListMode int
+
+const (
+	// CSVListsSkip omits the columns of basicList, subTemplateList, and subTemplateMultiList fields
+	CSVListsSkip CSVListMode = iota
+	// CSVListsJSON writes the JSON encoding of structured data types into a single cell
+	CSVListsJSON
+)
+
+// CSVOptions configures a CSVWriter
+type CSVOptions struct {
+	// Lists determines how fields of structured data types are written, defaults to CSVListsSkip
+	Lists CSVListMode
+	// FlushEachRecord flushes the underlying writer after each record, e.g., for piping records
+	// into other tools as they arrive
+	FlushEachRecord bool
+	// Comma is the field delimiter, defaults to ','
+	Comma rune
+}
+
+// CSVWriter writes data records of a single template as CSV, e.g., for loading records into
+// spreadsheets or pandas for ad-hoc analysis.
+//
+// The header row names the columns by the keys of DataRecord.Flatten in the order of the template's
+// fields, i.e., scope fields of options templates first and prefixed with "scope.", and repeated
+// fields suffixed with their occurrence. Values are formatted per type: IP addresses in dotted or
+// colon notation, timestamps in RFC 3339, octet arrays in hex, and all other values in their
+// default format.
+type CSVWriter struct {
+	w       *csv.Writer
+	options CSVOptions
+
+	templateId uint16
+	fieldCount int
+	columns    []csvColumn
+
+	headerWritten bool
+}
+
+// csvColumn is a column of a CSVWriter, written from the record's field at index
+type csvColumn struct {
+	index int
+	name  string
+}
+
+// NewCSVWriter creates a CSVWriter writing records of template to w. The header row is written
+// before the first record, or on Flush if no record was written.
+func NewCSVWriter(w io.Writer, template *Template, opts CSVOptions) *CSVWriter {
+	cw := &CSVWriter{
+		w:       csv.NewWriter(w),
+		options: opts,
+	}
+	if opts.Comma != 0 {
+		cw.w.Comma = opts.Comma
+	}
+
+	var scopes, fields []Field
+	switch r := template.Record.(type) {
+	case *TemplateRecord:
+		fields = r.Fields
+	case *OptionsTemplateRecord:
+		scopes = r.Scopes
+		fields = append(append(make([]Field, 0, len(r.Scopes)+len(r.Options)), r.Scopes...), r.Options...)
+	}
+	if template.Record != nil {
+		cw.templateId = template.Record.Id()
+	}
+	cw.fieldCount = len(fields)
+
+	names := make(fieldNames, len(fields))
+	for i, f := range fields {
+		name := names.next(f, i < len(scopes))
+		if isStructuredType(f.Type()) && opts.Lists == CSVListsSkip {
+			continue
+		}
+		cw.columns = append(cw.columns, csvColumn{index: i, name: name})
+	}
+	return cw
+}
+
+// Header returns the names of the writer's columns
+func (cw *CSVWriter) Header() []string {
+	header := make([]string, 0, len(cw.columns))
+	for _, c := range cw.columns {
+		header = append(header, c.name)
+	}
+	return header
+}
+
+// WriteRecord writes the record as a row. Records of another template than the writer's return
+// ErrTemplateMismatch. Records with fewer fields than the template leave the cells of the missing
+// fields empty.
+func (cw *CSVWriter) WriteRecord(record DataRecord) error {
+	if record.TemplateId != cw.templateId {
+		return fmt.Errorf("failed to write record, %w: record of template %d, expected %d", ErrTemplateMismatch, record.TemplateId, cw.templateId)
+	}
+	if len(record.Fields) > cw.fieldCount {
+		return fmt.Errorf("failed to write record, %w: record has %d fields, template %d has %d", ErrTemplateMismatch, len(record.Fields), cw.templateId, cw.fieldCount)
+	}
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	row := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		if c.index >= len(record.Fields) {
+			continue
+		}
+		cell, err := csvCell(record.Fields[c.index])
+		if err != nil {
+			return fmt.Errorf("failed to write field %s, %w", c.name, err)
+		}
+		row[i] = cell
+	}
+	if err := cw.w.Write(row); err != nil {
+		return err
+	}
+	if cw.options.FlushEachRecord {
+		return cw.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying writer, including the header row if no record
+// was written yet
+func (cw *CSVWriter) Flush() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *CSVWriter) writeHeader() error {
+	if cw.headerWritten {
+		return nil
+	}
+	cw.headerWritten = true
+	return cw.w.Write(cw.Header())
+}
+
+// isStructuredType returns true for the names of the structured data types of RFC 6313
+func isStructuredType(name string) bool {
+	switch name {
+	case "basicList", "subTemplateList", "subTemplateMultiList":
+		return true
+	}
+	return false
+}
+
+// csvCell formats the value of f for a cell of CSVWriter
+func csvCell(f Field) (string, error) {
+	dt := f.Value()
+	if dt == nil {
+		return "", nil
+	}
+	switch dt.(type) {
+	case *BasicList, *SubTemplateList, *SubTemplateMultiList:
+		b, err := json.Marshal(dt)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	switch v := dt.Value().(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case net.IP:
+		if v == nil {
+			return "", nil
+		}
+		return v.String(), nil
+	case net.HardwareAddr:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
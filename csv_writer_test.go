@@ -0,0 +1,190 @@
+package ipfix
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateCSV = flag.Bool("csv.update", false, "regenerate the golden files of CSVWriter")
+
+func TestCSVWriter(t *testing.T) {
+	ies := iana()
+
+	// field creates a field of the IANA IE id, and sets its value unless v is nil
+	field := func(id uint16, reversed bool, v any) Field {
+		ie := ies[id].Clone()
+		length := ie.Constructor().DefaultLength()
+		if length == 0 {
+			length = VariableLength
+		}
+		f := NewFieldBuilder(&ie).SetReversed(reversed).SetLength(length).Complete()
+		if v != nil {
+			f.SetValue(v)
+		}
+		return f
+	}
+
+	start := time.Date(2023, time.November, 1, 12, 0, 0, 123000000, time.UTC)
+
+	ports := NewBasicList().(*BasicList).SetFieldID(7)
+	ports.SetValue([]DataType{
+		NewUnsigned16().SetValue(uint16(443)),
+		NewUnsigned16().SetValue(uint16(80)),
+	})
+
+	// flowFields are the IEs of the flow template, with a repeated octetDeltaCount
+	flowFields := []struct {
+		id       uint16
+		reversed bool
+	}{
+		{8, false}, {27, false}, {7, false}, {152, false}, {1, false}, {1, true},
+		{56, false}, {313, false}, {82, false}, {291, false}, {1, false},
+	}
+	flowTemplate := func() *Template {
+		fields := make([]Field, 0, len(flowFields))
+		for _, f := range flowFields {
+			fields = append(fields, field(f.id, f.reversed, nil))
+		}
+		return &Template{Record: &TemplateRecord{TemplateId: 256, FieldCount: uint16(len(fields)), Fields: fields}}
+	}
+	flows := []DataRecord{
+		{TemplateId: 256, Fields: []Field{
+			field(8, false, "192.0.2.1"),
+			field(27, false, "2001:db8::1"),
+			field(7, false, 443),
+			field(152, false, start),
+			field(1, false, 1500),
+			field(1, true, 64000),
+			field(56, false, "00:00:5e:00:53:01"),
+			field(313, false, []byte{0xde, 0xad, 0xbe, 0xef}),
+			field(82, false, "eth0, \"uplink\""),
+			field(291, false, ports),
+			field(1, false, 20),
+		}},
+		// a shorter record leaves the cells of missing fields empty
+		{TemplateId: 256, Fields: []Field{
+			field(8, false, "198.51.100.7"),
+			field(27, false, "::"),
+			field(7, false, 53),
+			field(152, false, start.Add(time.Second)),
+		}},
+	}
+
+	optionsTemplate := &Template{Record: &OptionsTemplateRecord{
+		TemplateId:      257,
+		FieldCount:      3,
+		ScopeFieldCount: 1,
+		Scopes:          []Field{field(149, false, nil)},
+		Options:         []Field{field(149, false, nil), field(41, false, nil)},
+	}}
+	options := []DataRecord{
+		{TemplateId: 257, Fields: []Field{
+			field(149, false, 1),
+			field(149, false, 2),
+			field(41, false, 1000),
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		template *Template
+		records  []DataRecord
+		opts     CSVOptions
+	}{
+		{"flows", flowTemplate(), flows, CSVOptions{}},
+		{"flows_lists_json", flowTemplate(), flows, CSVOptions{Lists: CSVListsJSON}},
+		{"flows_semicolon", flowTemplate(), flows[:1], CSVOptions{Comma: ';'}},
+		{"options", optionsTemplate, options, CSVOptions{}},
+		{"empty", optionsTemplate, nil, CSVOptions{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := NewCSVWriter(buf, tc.template, tc.opts)
+			for _, record := range tc.records {
+				if err := w.WriteRecord(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "csv", tc.name+".csv")
+			if *updateCSV {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) {
+				t.Errorf("expected\n%s\ngot\n%s", expected, buf.Bytes())
+			}
+		})
+	}
+
+	t.Run("header matches flattened keys", func(t *testing.T) {
+		w := NewCSVWriter(&bytes.Buffer{}, flowTemplate(), CSVOptions{Lists: CSVListsJSON})
+		flat := flows[0].Flatten()
+		for _, name := range w.Header() {
+			if name == "basicList" {
+				continue
+			}
+			if _, ok := flat[name]; !ok {
+				t.Errorf("expected column %s to be a key of the flattened record", name)
+			}
+		}
+	})
+
+	t.Run("record of another template", func(t *testing.T) {
+		w := NewCSVWriter(&bytes.Buffer{}, flowTemplate(), CSVOptions{})
+		if err := w.WriteRecord(options[0]); !errors.Is(err, ErrTemplateMismatch) {
+			t.Errorf("expected ErrTemplateMismatch, got %v", err)
+		}
+		longer := DataRecord{TemplateId: 256, Fields: append(append([]Field{}, flows[0].Fields...), field(8, false, "192.0.2.2"))}
+		if err := w.WriteRecord(longer); !errors.Is(err, ErrTemplateMismatch) {
+			t.Errorf("expected ErrTemplateMismatch for record with more fields than the template, got %v", err)
+		}
+	})
+
+	t.Run("flush each record", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := NewCSVWriter(buf, flowTemplate(), CSVOptions{FlushEachRecord: true})
+		if err := w.WriteRecord(flows[1]); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+			t.Errorf("expected header and record to be flushed, got %d lines", lines)
+		}
+
+		buf.Reset()
+		w = NewCSVWriter(buf, flowTemplate(), CSVOptions{})
+		if err := w.WriteRecord(flows[1]); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected record to be buffered, got %q", buf.String())
+		}
+	})
+
+	t.Run("IPv4 addresses in dotted notation", func(t *testing.T) {
+		cell, err := csvCell(field(8, false, net.IPv4(192, 0, 2, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cell != "192.0.2.1" {
+			t.Errorf("expected 192.0.2.1, got %s", cell)
+		}
+	})
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/netip"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type DataRecord struct {
@@ -31,6 +37,9 @@ type DataRecord struct {
 
 	template   *Template
 	fieldCache FieldCache
+
+	// index is built lazily by FieldById and FieldByName for records with many fields
+	index *fieldIndex
 }
 
 func (dr *DataRecord) Encode(w io.Writer) (n int, err error) {
@@ -44,39 +53,108 @@ func (dr *DataRecord) Encode(w io.Writer) (n int, err error) {
 	return n, nil
 }
 
+// EncodeStrict encodes the data record such that it matches the template set via With. In contrast to
+// Encode, it verifies each field against the field of the template at the same position:
+//
+//   - fields must have the same id and enterprise number as the template's field,
+//   - fixed-length fields must encode to exactly the length announced by the template, fields without
+//     a value are encoded as zero-filled bytes of that length,
+//   - variable-length fields are always encoded with a length prefix matching their value.
+//
+// If any field does not match the template, EncodeStrict returns an error naming the field and does not
+// write anything to w.
+func (dr *DataRecord) EncodeStrict(w io.Writer) (n int, err error) {
+	if dr.template == nil {
+		return 0, fmt.Errorf("failed to encode data record of template %d strictly, template is nil", dr.TemplateId)
+	}
+	var templateFields []Field
+	switch t := dr.template.Record.(type) {
+	case *TemplateRecord:
+		templateFields = t.Fields
+	case *OptionsTemplateRecord:
+		templateFields = make([]Field, 0, len(t.Scopes)+len(t.Options))
+		templateFields = append(templateFields, t.Scopes...)
+		templateFields = append(templateFields, t.Options...)
+	default:
+		return 0, fmt.Errorf("failed to encode data record strictly, cannot use %T as template", t)
+	}
+	if len(dr.Fields) != len(templateFields) {
+		return 0, fmt.Errorf("failed to encode data record strictly, record has %d fields, template %d announces %d", len(dr.Fields), dr.template.Record.Id(), len(templateFields))
+	}
+
+	// buffer the record such that nothing is written on a mismatch
+	buf := &bytes.Buffer{}
+	for idx, f := range dr.Fields {
+		err := encodeFieldStrict(buf, f, templateFields[idx])
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode field %d (%s) of data record strictly, %w", idx, f.Name(), err)
+		}
+	}
+	return w.Write(buf.Bytes())
+}
+
+// encodeFieldStrict encodes a single field f to buf after checking it against the template's field tf
+func encodeFieldStrict(buf *bytes.Buffer, f Field, tf Field) error {
+	if f.Id() != tf.Id() || f.PEN() != tf.PEN() || f.Reversed() != tf.Reversed() {
+		return fmt.Errorf("field (%d,%d) does not match template field %s (%d,%d)", f.PEN(), f.Id(), tf.Name(), tf.PEN(), tf.Id())
+	}
+
+	switch tf.(type) {
+	case *VariableLengthField:
+		vf, ok := f.(*VariableLengthField)
+		if !ok {
+			return fmt.Errorf("template announces a variable-length field, found %T", f)
+		}
+		_, err := vf.Encode(buf)
+		return err
+	default:
+		if _, ok := f.(*FixedLengthField); !ok {
+			return fmt.Errorf("template announces a fixed-length field, found %T", f)
+		}
+		expected := int(tf.Length())
+		if ff := f.(*FixedLengthField); ff.value == nil {
+			buf.Write(make([]byte, expected))
+			return nil
+		}
+		fb := &bytes.Buffer{}
+		_, err := f.Encode(fb)
+		if err != nil {
+			return err
+		}
+		if fb.Len() != expected {
+			return fmt.Errorf("encoded length %d does not match length %d announced by template", fb.Len(), expected)
+		}
+		buf.Write(fb.Bytes())
+		return nil
+	}
+}
+
 func (dr *DataRecord) With(t *Template) *DataRecord {
 	dr.template = t
 	return dr
 }
 
+// Decode decodes a single data record from r using the record's template. If r is exhausted before
+// all fields of the template were decoded, Decode returns io.EOF and the record is incomplete.
 func (dr *DataRecord) Decode(r io.Reader) (n int, err error) {
-	m := 0
 	switch t := dr.template.Record.(type) {
 	case *TemplateRecord:
-		m, err = dr.decodeFromTempalte(r, t)
-		n += m
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return n, fmt.Errorf("failed to decode data set, %w", err)
-		}
+		n, err = dr.decodeFromTempalte(r, t)
 	case *OptionsTemplateRecord:
-		m, err = dr.decodeFromOptionsTemplate(r, t)
-		n += m
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return n, fmt.Errorf("failed to decode data set, %w", err)
+		n, err = dr.decodeFromOptionsTemplate(r, t)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return n, io.EOF
 		}
+		return n, fmt.Errorf("failed to decode data set, %w", err)
 	}
 
 	ie, err := dataRecordToIE(*dr)
 	if err != nil {
 		return n, err
 	}
-	if ie != nil {
+	if ie != nil && dr.fieldCache != nil {
 		err = dr.fieldCache.Add(context.TODO(), *ie)
 		if err != nil {
 			return n, err
@@ -120,8 +198,26 @@ func (d *DataRecord) decodeFromOptionsTemplate(r io.Reader, t *OptionsTemplateRe
 	return
 }
 
+// DecodeRecordWithFields decodes a single record of the given fields from r, e.g., for custom decoding
+// of structured data. The fields are cloned before decoding, such that fields of templates can be
+// passed as is without being modified. Variable-length fields consume their length prefix from r.
+//
+// If r is exhausted before all fields were decoded, DecodeRecordWithFields returns the fields decoded
+// until then together with io.EOF.
+func DecodeRecordWithFields(r io.Reader, fields []Field) ([]Field, error) {
+	dr := DataRecord{}
+	if _, err := dr.decodeWithFields(r, fields); err != nil {
+		return dr.Fields, err
+	}
+	return dr.Fields, nil
+}
+
+// decodeWithFields decodes the given template fields from r and appends them to the fields already
+// decoded, such that the option fields of an options template follow its scope fields
 func (d *DataRecord) decodeWithFields(r io.Reader, fields []Field) (n int, err error) {
-	dfs := make([]Field, 0, len(fields))
+	dfs := make([]Field, 0, len(d.Fields)+len(fields))
+	dfs = append(dfs, d.Fields...)
+	state, _ := decodeStateOf(r)
 	for idx, templateField := range fields {
 		// Clone the field of the template to decode the value into while also preserving the
 		// template information
@@ -130,17 +226,48 @@ func (d *DataRecord) decodeWithFields(r io.Reader, fields []Field) (n int, err e
 		m, err := tf.Decode(r)
 		n += m
 		if err != nil {
-			if err == io.EOF {
-				break
+			d.Fields = dfs
+			// a set exhausted in the middle of a field is exhausted all the same
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return n, io.EOF
 			}
 			return n, fmt.Errorf("failed to decode field (%d, %d/%d [%s]), %w", idx, tf.PEN(), tf.Id(), name, err)
 		}
+		// count only fields actually decoded, the trailing padding of a set is not a field
+		if err := state.addField(); err != nil {
+			d.Fields = dfs
+			return n, fmt.Errorf("failed to decode field (%d, %d/%d [%s]), %w", idx, tf.PEN(), tf.Id(), name, err)
+		}
 		dfs = append(dfs, tf)
 	}
 	d.Fields = dfs
 	return
 }
 
+// minRecordLength returns the minimum length of records of t, i.e., the length of its fixed-length
+// fields plus a single length byte per variable-length field. Following RFC 7011, Section 3.3.1,
+// only trailing bytes of a set shorter than this are padding.
+func minRecordLength(t *Template) int {
+	l := 0
+	add := func(fields []Field) {
+		for _, f := range fields {
+			if _, ok := f.(*VariableLengthField); ok {
+				l++
+				continue
+			}
+			l += int(f.Length())
+		}
+	}
+	switch r := t.Record.(type) {
+	case *TemplateRecord:
+		add(r.Fields)
+	case *OptionsTemplateRecord:
+		add(r.Scopes)
+		add(r.Options)
+	}
+	return l
+}
+
 func (d *DataRecord) Length() uint16 {
 	l := uint16(0)
 	for _, f := range d.Fields {
@@ -167,7 +294,19 @@ func (dr *DataRecord) String() string {
 	return fmt.Sprintf("<id=%d,len=%d>%v", dr.TemplateId, dr.FieldCount, sl)
 }
 
+// UnmarshalJSON restores a data record marshalled with MarshalJSON. Fields are restored with the
+// FieldCache of the record and the TemplateCache of its template, if set, such that structured
+// data types are restored with the caches they are decoded with.
 func (dr *DataRecord) UnmarshalJSON(in []byte) error {
+	var templateCache TemplateCache
+	if dr.template != nil {
+		templateCache = dr.template.templateCache
+	}
+	return dr.unmarshalJSON(in, dr.fieldCache, templateCache)
+}
+
+// unmarshalJSON is UnmarshalJSON restoring fields with the given caches
+func (dr *DataRecord) unmarshalJSON(in []byte, fieldCache FieldCache, templateCache TemplateCache) error {
 	type idr struct {
 		TemplateId uint16 `json:"template_id,omitempty"`
 		FieldCount uint16 `json:"field_count,omitempty"`
@@ -186,18 +325,88 @@ func (dr *DataRecord) UnmarshalJSON(in []byte) error {
 	dr.FieldCount = t.FieldCount
 	fs := make([]Field, 0, len(t.Fields))
 	for _, cf := range t.Fields {
-		// TODO(zoomoid): check if this is ok, i.e., "we don't need the FieldManager and TemplateManager here anymore"
-		fs = append(fs, cf.restore(nil, nil))
+		f, err := cf.restore(fieldCache, templateCache)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, f)
+	}
+	dr.Fields = fs
+
+	return nil
+}
+
+// unmarshalRecordsJSON restores the JSON array of data records in with the given caches, e.g., the
+// records of structured data types
+func unmarshalRecordsJSON(in json.RawMessage, fieldCache FieldCache, templateCache TemplateCache) ([]DataRecord, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(in, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	records := make([]DataRecord, 0, len(raw))
+	for i, r := range raw {
+		dr := DataRecord{}
+		if err := dr.unmarshalJSON(r, fieldCache, templateCache); err != nil {
+			return nil, fmt.Errorf("failed to restore record %d, %w", i, err)
+		}
+		records = append(records, dr)
+	}
+	return records, nil
+}
+
+func (dr *DataRecord) MarshalYAML() (interface{}, error) {
+	type idr struct {
+		TemplateId uint16  `yaml:"templateId,omitempty"`
+		FieldCount uint16  `yaml:"fieldCount,omitempty"`
+		Fields     []Field `yaml:"fields,omitempty"`
+	}
+
+	return &idr{
+		TemplateId: dr.TemplateId,
+		FieldCount: dr.FieldCount,
+		Fields:     dr.Fields,
+	}, nil
+}
+
+func (dr *DataRecord) UnmarshalYAML(node *yaml.Node) error {
+	type idr struct {
+		TemplateId uint16      `yaml:"templateId,omitempty"`
+		FieldCount uint16      `yaml:"fieldCount,omitempty"`
+		Fields     []yamlField `yaml:"fields,omitempty"`
+	}
+
+	t := &idr{}
+	err := node.Decode(t)
+	if err != nil {
+		return err
+	}
+
+	dr.TemplateId = t.TemplateId
+	dr.FieldCount = t.FieldCount
+	fs, err := restoreYAMLFields(t.Fields, nil, nil)
+	if err != nil {
+		return err
 	}
 	dr.Fields = fs
 
 	return nil
 }
 
+// Clone creates a deep copy of the data record. The template and the FieldCache are shared
+// between the original and the clone.
 func (d *DataRecord) Clone() DataRecord {
-	fs := make([]Field, 0)
-	for _, f := range d.Fields {
-		fs = append(fs, f.Clone())
+	var fs []Field
+	if d.Fields != nil {
+		fs = make([]Field, 0, len(d.Fields))
+		for _, f := range d.Fields {
+			fs = append(fs, f.Clone())
+		}
 	}
 
 	return DataRecord{
@@ -205,5 +414,219 @@ func (d *DataRecord) Clone() DataRecord {
 		FieldCount: d.FieldCount,
 
 		Fields: fs,
+
+		template:   d.template,
+		fieldCache: d.fieldCache,
+	}
+}
+
+// fieldIndexThreshold is the number of fields in a data record from which on lookups by id or name
+// build an index instead of scanning all fields
+const fieldIndexThreshold int = 16
+
+type fieldNameKey struct {
+	EnterpriseId uint32
+	Name         string
+}
+
+// fieldIndex maps keys of fields to their position in a data record's Fields. As Fields is exported
+// and may be modified at any time, the index is validated on every lookup and rebuilt if stale, i.e.,
+// if Fields was reassigned or changed its length, or if an indexed position holds a different field.
+type fieldIndex struct {
+	length int
+	// first is the address of the first element of the indexed Fields, which changes when Fields is
+	// reassigned or grown beyond its capacity
+	first  *Field
+	byKey  map[FieldKey]int
+	byName map[fieldNameKey]int
+}
+
+// stale returns true if idx does not index the current Fields of dr
+func (idx *fieldIndex) stale(dr *DataRecord) bool {
+	return idx == nil || idx.length != len(dr.Fields) || idx.first != &dr.Fields[0]
+}
+
+// fieldKeyOf returns the FieldKey of f. Reversed fields are keyed by their reverse IE, i.e., by the
+// reverse PEN as defined by RFC 5103 for IANA IEs.
+func fieldKeyOf(f Field) FieldKey {
+	return wireKey(f)
+}
+
+func (dr *DataRecord) buildIndex() *fieldIndex {
+	idx := &fieldIndex{
+		length: len(dr.Fields),
+		first:  &dr.Fields[0],
+		byKey:  make(map[FieldKey]int, len(dr.Fields)),
+		byName: make(map[fieldNameKey]int, len(dr.Fields)),
+	}
+	// iterate backwards such that the first occurrence of a field wins, same as with scanning
+	for i := len(dr.Fields) - 1; i >= 0; i-- {
+		f := dr.Fields[i]
+		if f == nil {
+			continue
+		}
+		idx.byKey[fieldKeyOf(f)] = i
+		idx.byName[fieldNameKey{EnterpriseId: f.PEN(), Name: f.Name()}] = i
+	}
+	return idx
+}
+
+// lookup finds the first field matching match, using the index for records with many fields.
+func (dr *DataRecord) lookup(indexed func(*fieldIndex) (int, bool), match func(Field) bool) (Field, bool) {
+	if len(dr.Fields) < fieldIndexThreshold {
+		for _, f := range dr.Fields {
+			if f != nil && match(f) {
+				return f, true
+			}
+		}
+		return nil, false
+	}
+	if dr.index.stale(dr) {
+		dr.index = dr.buildIndex()
+	}
+	i, ok := indexed(dr.index)
+	if !ok {
+		return nil, false
+	}
+	if dr.Fields[i] != nil && match(dr.Fields[i]) {
+		return dr.Fields[i], true
+	}
+	// the field at the indexed position was replaced in place, which is not detected by stale
+	dr.index = dr.buildIndex()
+	if i, ok := indexed(dr.index); ok {
+		return dr.Fields[i], true
+	}
+	return nil, false
+}
+
+// FieldById returns the first field of the record with the given enterprise id and field id.
+// Reversed fields as per RFC 5103 are found with the ReversePEN, and reversed enterprise-specific
+// fields with the key of their reverse IE as per the ReverseResolver of their PEN.
+//
+// FieldById is not safe for concurrent use, as it lazily builds an index for records with many fields.
+// The index is rebuilt when Fields is reassigned, e.g., by appending, but fields assigned in place to
+// an element of Fields are only found once the field previously at that position is looked up.
+func (dr *DataRecord) FieldById(pen uint32, id uint16) (Field, bool) {
+	key := NewFieldKey(pen, id)
+	return dr.lookup(func(idx *fieldIndex) (int, bool) {
+		i, ok := idx.byKey[key]
+		return i, ok
+	}, func(f Field) bool {
+		return fieldKeyOf(f) == key
+	})
+}
+
+// FieldByName returns the first field of the record with the given enterprise id and name.
+// Reversed fields carry the name prefixed with "reversed", e.g., "reversedOctetDeltaCount".
+//
+// FieldByName is not safe for concurrent use, as it lazily builds an index for records with many fields.
+func (dr *DataRecord) FieldByName(pen uint32, name string) (Field, bool) {
+	key := fieldNameKey{EnterpriseId: pen, Name: name}
+	return dr.lookup(func(idx *fieldIndex) (int, bool) {
+		i, ok := idx.byName[key]
+		return i, ok
+	}, func(f Field) bool {
+		return f.PEN() == pen && f.Name() == name
+	})
+}
+
+// fieldByAnyName returns the first field with the given name, regardless of its enterprise id
+func (dr *DataRecord) fieldByAnyName(name string) (Field, bool) {
+	for _, f := range dr.Fields {
+		if f != nil && f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// valueByName returns the DataType of the first field of the given name, or nil if no such
+// field exists or the field carries no value
+func (dr *DataRecord) valueByName(name string) DataType {
+	f, ok := dr.FieldByName(0, name)
+	if !ok {
+		f, ok = dr.fieldByAnyName(name)
+	}
+	if !ok || f == nil {
+		return nil
+	}
+	return f.Value()
+}
+
+// Uint64 returns the value of the first field with the given name as uint64, if the field's data
+// type is any of the unsigned integer types. IANA fields are preferred over enterprise-specific
+// fields of the same name.
+func (dr *DataRecord) Uint64(name string) (uint64, bool) {
+	switch v := dr.valueByName(name).(type) {
+	case *Unsigned8:
+		return uint64(v.value), true
+	case *Unsigned16:
+		return uint64(v.value), true
+	case *Unsigned32:
+		return uint64(v.value), true
+	case *Unsigned64:
+		return v.value, true
+	default:
+		return 0, false
+	}
+}
+
+// Int64 returns the value of the first field with the given name as int64, if the field's data
+// type is any of the signed integer types. IANA fields are preferred over enterprise-specific
+// fields of the same name.
+func (dr *DataRecord) Int64(name string) (int64, bool) {
+	switch v := dr.valueByName(name).(type) {
+	case *Signed8:
+		return int64(v.value), true
+	case *Signed16:
+		return int64(v.value), true
+	case *Signed32:
+		return int64(v.value), true
+	case *Signed64:
+		return v.value, true
+	default:
+		return 0, false
+	}
+}
+
+// StringValue returns the value of the first field with the given name, if the field's data type is string.
+// IANA fields are preferred over enterprise-specific fields of the same name.
+func (dr *DataRecord) StringValue(name string) (string, bool) {
+	v, ok := dr.valueByName(name).(*String)
+	if !ok {
+		return "", false
+	}
+	return v.value, true
+}
+
+// IP returns the value of the first field with the given name as netip.Addr, if the field's data
+// type is either ipv4Address or ipv6Address. IANA fields are preferred over enterprise-specific
+// fields of the same name.
+func (dr *DataRecord) IP(name string) (netip.Addr, bool) {
+	switch v := dr.valueByName(name).(type) {
+	case *IPv4Address:
+		addr, ok := netip.AddrFromSlice(v.value)
+		return addr.Unmap(), ok
+	case *IPv6Address:
+		return netip.AddrFromSlice(v.value)
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// Time returns the value of the first field with the given name, if the field's data type is any
+// of the dateTime types. IANA fields are preferred over enterprise-specific fields of the same name.
+func (dr *DataRecord) Time(name string) (time.Time, bool) {
+	switch v := dr.valueByName(name).(type) {
+	case *DateTimeSeconds:
+		return v.value, true
+	case *DateTimeMilliseconds:
+		return v.value, true
+	case *DateTimeMicroseconds:
+		return v.value, true
+	case *DateTimeNanoseconds:
+		return v.value, true
+	default:
+		return time.Time{}, false
 	}
 }
@@ -0,0 +1,401 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataRecordFieldAccess(t *testing.T) {
+	ies := iana()
+
+	field := func(id uint16, v any) Field {
+		ie := ies[id].Clone()
+		return NewFieldBuilder(&ie).Complete().SetValue(v)
+	}
+
+	start := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	newRecord := func() *DataRecord {
+		reversed := NewFieldBuilder(func() *InformationElement { ie := ies[1].Clone(); return &ie }()).
+			SetReversed(true).
+			Complete().
+			SetValue(17)
+		enterprise := NewFieldBuilder(&InformationElement{
+			Id:          18,
+			Name:        "payload",
+			Constructor: NewOctetArray,
+		}).SetPEN(6871).SetLength(VariableLength).Complete().SetValue([]byte{0xde, 0xad})
+
+		return &DataRecord{
+			Fields: []Field{
+				field(8, "192.0.2.1"),
+				field(28, "2001:db8::1"),
+				field(1, 1500),
+				field(7, 443),
+				field(96, "https"),
+				field(150, start),
+				field(152, start),
+				field(154, start),
+				field(156, start),
+				field(210, []byte{0x00}),
+				reversed,
+				enterprise,
+			},
+		}
+	}
+
+	// pad a record with a lot of (irrelevant) fields such that lookups use the index
+	withManyFields := func() *DataRecord {
+		r := newRecord()
+		for i := 0; i < fieldIndexThreshold; i++ {
+			r.Fields = append([]Field{field(210, []byte{0x00})}, r.Fields...)
+		}
+		return r
+	}
+
+	for name, factory := range map[string]func() *DataRecord{
+		"scanning": newRecord,
+		"indexed":  withManyFields,
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := factory()
+
+			t.Run("FieldById", func(t *testing.T) {
+				f, ok := r.FieldById(0, 8)
+				if !ok || f.Name() != "sourceIPv4Address" {
+					t.Errorf("expected sourceIPv4Address, got %v", f)
+				}
+				f, ok = r.FieldById(6871, 18)
+				if !ok || f.Name() != "payload" {
+					t.Errorf("expected enterprise field payload, got %v", f)
+				}
+				f, ok = r.FieldById(ReversePEN, 1)
+				if !ok || f.Name() != "reversedOctetDeltaCount" {
+					t.Errorf("expected reversed field, got %v", f)
+				}
+				if _, ok := r.FieldById(0, 12); ok {
+					t.Error("expected no destinationIPv4Address field")
+				}
+			})
+
+			t.Run("FieldByName", func(t *testing.T) {
+				f, ok := r.FieldByName(0, "octetDeltaCount")
+				if !ok || f.Id() != 1 {
+					t.Errorf("expected octetDeltaCount, got %v", f)
+				}
+				if _, ok := r.FieldByName(0, "payload"); ok {
+					t.Error("expected enterprise field not to be found with PEN 0")
+				}
+				if _, ok := r.FieldByName(6871, "payload"); !ok {
+					t.Error("expected enterprise field to be found")
+				}
+			})
+
+			t.Run("Uint64", func(t *testing.T) {
+				if v, ok := r.Uint64("octetDeltaCount"); !ok || v != 1500 {
+					t.Errorf("expected 1500, got %d (%t)", v, ok)
+				}
+				if v, ok := r.Uint64("sourceTransportPort"); !ok || v != 443 {
+					t.Errorf("expected 443, got %d (%t)", v, ok)
+				}
+				if v, ok := r.Uint64("reversedOctetDeltaCount"); !ok || v != 17 {
+					t.Errorf("expected 17, got %d (%t)", v, ok)
+				}
+				if _, ok := r.Uint64("sourceIPv4Address"); ok {
+					t.Error("expected mismatched type to return false")
+				}
+				if _, ok := r.Uint64("doesNotExist"); ok {
+					t.Error("expected missing field to return false")
+				}
+			})
+
+			t.Run("Int64", func(t *testing.T) {
+				if _, ok := r.Int64("octetDeltaCount"); ok {
+					t.Error("expected mismatched type to return false")
+				}
+			})
+
+			t.Run("StringValue", func(t *testing.T) {
+				if v, ok := r.StringValue("applicationName"); !ok || v != "https" {
+					t.Errorf("expected https, got %s (%t)", v, ok)
+				}
+				if _, ok := r.StringValue("octetDeltaCount"); ok {
+					t.Error("expected mismatched type to return false")
+				}
+			})
+
+			t.Run("IP", func(t *testing.T) {
+				if v, ok := r.IP("sourceIPv4Address"); !ok || v != netip.MustParseAddr("192.0.2.1") {
+					t.Errorf("expected 192.0.2.1, got %s (%t)", v, ok)
+				}
+				if v, ok := r.IP("destinationIPv6Address"); !ok || v != netip.MustParseAddr("2001:db8::1") {
+					t.Errorf("expected 2001:db8::1, got %s (%t)", v, ok)
+				}
+				if _, ok := r.IP("applicationName"); ok {
+					t.Error("expected mismatched type to return false")
+				}
+			})
+
+			t.Run("Time", func(t *testing.T) {
+				for _, name := range []string{"flowStartSeconds", "flowStartMilliseconds", "flowStartMicroseconds", "flowStartNanoseconds"} {
+					if v, ok := r.Time(name); !ok || !v.Equal(start) {
+						t.Errorf("expected %s to be %s, got %s (%t)", name, start, v, ok)
+					}
+				}
+				if _, ok := r.Time("octetDeltaCount"); ok {
+					t.Error("expected mismatched type to return false")
+				}
+			})
+
+			t.Run("in-place modification", func(t *testing.T) {
+				idx := len(r.Fields) - 1
+				r.Fields[idx] = field(12, "198.51.100.1")
+				if _, ok := r.FieldById(6871, 18); ok {
+					t.Error("expected replaced field not to be found anymore")
+				}
+				if v, ok := r.IP("destinationIPv4Address"); !ok || v != netip.MustParseAddr("198.51.100.1") {
+					t.Errorf("expected replacing field to be found, got %s (%t)", v, ok)
+				}
+			})
+		})
+	}
+
+	t.Run("index is only rebuilt when stale", func(t *testing.T) {
+		r := withManyFields()
+		if _, ok := r.FieldById(0, 8); !ok {
+			t.Fatal("expected sourceIPv4Address")
+		}
+		idx := r.index
+		for i := 0; i < 3; i++ {
+			if _, ok := r.FieldById(0, 12); ok {
+				t.Fatal("expected no destinationIPv4Address field")
+			}
+		}
+		if r.index != idx {
+			t.Error("expected misses not to rebuild a fresh index")
+		}
+
+		r.Fields = append(r.Fields, field(12, "198.51.100.1"))
+		if v, ok := r.IP("destinationIPv4Address"); !ok || v != netip.MustParseAddr("198.51.100.1") {
+			t.Errorf("expected appended field to be found, got %s (%t)", v, ok)
+		}
+		if r.index == idx {
+			t.Error("expected appending a field to rebuild the index")
+		}
+	})
+}
+
+func TestDataRecordEncodeStrict(t *testing.T) {
+	ies := iana()
+
+	newField := func(id uint16, length uint16) Field {
+		ie := ies[id].Clone()
+		return NewFieldBuilder(&ie).SetLength(length).Complete()
+	}
+
+	template := &Template{
+		Record: &TemplateRecord{
+			TemplateId: 256,
+			FieldCount: 4,
+			Fields: []Field{
+				newField(8, 4),
+				newField(2, 4), // reduced-length unsigned64
+				newField(96, VariableLength),
+				newField(7, 2),
+			},
+		},
+	}
+
+	roundTrip := func(t *testing.T, record *DataRecord) *DataRecord {
+		buf := &bytes.Buffer{}
+		_, err := record.With(template).EncodeStrict(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &DataRecord{TemplateId: 256}
+		_, err = decoded.With(template).Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected record to be consumed entirely, %d bytes left", buf.Len())
+		}
+		return decoded
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, app := range []string{"https", strings.Repeat("x", 300)} {
+			record := &DataRecord{
+				TemplateId: 256,
+				Fields: []Field{
+					newField(8, 4).SetValue("192.0.2.1"),
+					newField(2, 4).SetValue(1500),
+					newField(96, VariableLength).SetValue(app),
+					newField(7, 2).SetValue(443),
+				},
+			}
+			decoded := roundTrip(t, record)
+
+			if ip, _ := decoded.IP("sourceIPv4Address"); ip.String() != "192.0.2.1" {
+				t.Errorf("expected sourceIPv4Address 192.0.2.1, found %v", ip)
+			}
+			if v, _ := decoded.Uint64("packetDeltaCount"); v != 1500 {
+				t.Errorf("expected packetDeltaCount 1500, found %d", v)
+			}
+			if v, _ := decoded.StringValue("applicationName"); v != app {
+				t.Errorf("expected applicationName of length %d, found length %d", len(app), len(v))
+			}
+			if v, _ := decoded.Uint64("sourceTransportPort"); v != 443 {
+				t.Errorf("expected sourceTransportPort 443, found %d", v)
+			}
+		}
+	})
+
+	t.Run("fields without values", func(t *testing.T) {
+		record := &DataRecord{
+			TemplateId: 256,
+			Fields: []Field{
+				newField(8, 4),
+				newField(2, 4),
+				newField(96, VariableLength),
+				newField(7, 2).SetValue(443),
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		n, err := record.With(template).EncodeStrict(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 4 + 4 zero bytes, a zero length prefix, and the port
+		expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xbb}
+		if n != len(expected) || !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("expected %v, found %v", expected, buf.Bytes())
+		}
+
+		decoded := roundTrip(t, record)
+		if v, _ := decoded.Uint64("sourceTransportPort"); v != 443 {
+			t.Errorf("expected sourceTransportPort 443, found %d", v)
+		}
+	})
+
+	t.Run("length mismatch", func(t *testing.T) {
+		record := &DataRecord{
+			TemplateId: 256,
+			Fields: []Field{
+				newField(8, 4).SetValue("192.0.2.1"),
+				newField(2, 8).SetValue(1500), // 8 bytes instead of 4
+				newField(96, VariableLength).SetValue("https"),
+				newField(7, 2).SetValue(443),
+			},
+		}
+		buf := &bytes.Buffer{}
+		_, err := record.With(template).EncodeStrict(buf)
+		if err == nil || !strings.Contains(err.Error(), "packetDeltaCount") {
+			t.Errorf("expected error naming packetDeltaCount, found %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected nothing to be written, found %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("field mismatch", func(t *testing.T) {
+		record := &DataRecord{
+			TemplateId: 256,
+			Fields: []Field{
+				newField(12, 4).SetValue("192.0.2.1"),
+				newField(2, 4).SetValue(1500),
+				newField(96, VariableLength).SetValue("https"),
+				newField(7, 2).SetValue(443),
+			},
+		}
+		_, err := record.With(template).EncodeStrict(&bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "destinationIPv4Address") {
+			t.Errorf("expected error naming destinationIPv4Address, found %v", err)
+		}
+
+		record.Fields = record.Fields[1:]
+		_, err = record.With(template).EncodeStrict(&bytes.Buffer{})
+		if err == nil {
+			t.Error("expected error for missing field, found nil")
+		}
+	})
+}
+
+func TestDecodeRecordWithFields(t *testing.T) {
+	ies := iana()
+
+	newField := func(id uint16, length uint16) Field {
+		ie := ies[id].Clone()
+		return NewFieldBuilder(&ie).SetLength(length).Complete()
+	}
+	// sourceIPv4Address, reduced-length packetDeltaCount, applicationName, sourceTransportPort
+	fields := []Field{
+		newField(8, 4),
+		newField(2, 4),
+		newField(96, VariableLength),
+		newField(7, 2),
+	}
+	record := []byte{192, 0, 2, 1, 0, 0, 0, 42, 4, 'h', 't', 't', 'p', 0x01, 0xbb}
+
+	t.Run("decodes a record", func(t *testing.T) {
+		buf := bytes.NewBuffer(append(record, 0xff))
+		decoded, err := DecodeRecordWithFields(buf, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != len(fields) {
+			t.Fatalf("expected %d fields, got %d", len(fields), len(decoded))
+		}
+		expected := []string{"192.0.2.1", "42", "http", "443"}
+		for i, f := range decoded {
+			if v := fmt.Sprint(f.Value().Value()); v != expected[i] {
+				t.Errorf("expected field %d to be %s, got %s", i, expected[i], v)
+			}
+		}
+		// bytes following the record are not consumed
+		if buf.Len() != 1 {
+			t.Errorf("expected 1 byte to remain, got %d", buf.Len())
+		}
+		// the fields passed in are cloned before decoding
+		for i, f := range fields {
+			if f == decoded[i] {
+				t.Errorf("expected field %d to be cloned", i)
+			}
+			if v := fmt.Sprint(f.Value().Value()); v == expected[i] {
+				t.Errorf("expected field %d not to be modified", i)
+			}
+		}
+	})
+
+	t.Run("stops at exhausted reader", func(t *testing.T) {
+		decoded, err := DecodeRecordWithFields(bytes.NewReader(record[:10]), fields)
+		if err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+		if len(decoded) != 2 {
+			t.Errorf("expected the 2 fields preceding the truncated field, got %d", len(decoded))
+		}
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sync"
 )
 
 type DataType interface {
@@ -78,29 +79,32 @@ type DataType interface {
 	Clone() DataType
 
 	// SetValue sets the internal value on the DataType. SetValue panics if v cannot be asserted to the
-	// internal type (this type safety should be ensured in the conversion from ConsolidatedField to Field)
+	// internal type (this type safety should be ensured in the conversion from ConsolidatedField to Field),
+	// or is out of range of the data type. All DataTypes of this package also implement ValueSetter,
+	// whose SetValueE returns an error instead.
 	SetValue(v any) DataType
 }
 
-// LookupConstructor is an accessor to the private internal, but global map of currently known
-// IPFIX abstract data types.
-//
-// If no constructor is associated with the given name, LookupConstructor panics. This behavior
-// is to be discussed and potentially amended.
-func LookupConstructor(name string) DataTypeConstructor {
-	c, ok := constructors[name]
+// LookupConstructor returns the constructor of the IPFIX abstract data type of the given name,
+// including data types added with RegisterDataType. For unknown names, it returns an error
+// wrapping ErrUnknownDataType.
+func LookupConstructor(name string) (DataTypeConstructor, error) {
+	dataTypes.mu.RLock()
+	defer dataTypes.mu.RUnlock()
+	c, ok := dataTypes.constructors[name]
 	if !ok {
-		panic(fmt.Errorf("data type constructor not defined: %s", name))
+		return nil, fmt.Errorf("%w %s", ErrUnknownDataType, name)
 	}
-	return c
+	return c, nil
 }
 
 // SupportedTypes returns a slice containing all currently known DataType constructors.
 func SupportedTypes() []DataTypeConstructor {
-	cs := make([]DataTypeConstructor, len(constructors))
-	idx := 0
-	for _, c := range constructors {
-		cs[idx] = c
+	dataTypes.mu.RLock()
+	defer dataTypes.mu.RUnlock()
+	cs := make([]DataTypeConstructor, 0, len(dataTypes.constructors))
+	for _, c := range dataTypes.constructors {
+		cs = append(cs, c)
 	}
 	return cs
 }
@@ -113,95 +117,134 @@ func SupportedTypes() []DataTypeConstructor {
 // new DataTypeConstructor functions with parameters curried inside the constructor function's closure.
 type DataTypeConstructor func() DataType
 
-// DataTypeFromNumber looks up the default constructor for each of the currently known
-// IPFIX abstract data types (both in RFC 7011 and RFC 6313) by their IANA-assigned
-// identifier.
-// If an id is given that is NOT in the lookup table, DataTypeFromNumber panics.
-// This behaviour is due to no better error handling mechanism currently existing
-// in the call path of this function.
+// DataTypeFromNumber looks up the default constructor of an IPFIX abstract data type by its
+// IANA-assigned identifier, e.g., in RFC 5610 records, including numbers added with
+// RegisterDataTypeNumber. For unassigned identifiers, it returns an error wrapping
+// ErrUnknownDataType.
+func DataTypeFromNumber(id uint8) (DataTypeConstructor, error) {
+	dataTypes.mu.RLock()
+	defer dataTypes.mu.RUnlock()
+	name, ok := dataTypes.names[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: DataType ID %d is not assigned", ErrUnknownDataType, id)
+	}
+	return dataTypes.constructors[name], nil
+}
+
+// dataTypeToNumber is the inverse of DataTypeFromNumber and returns the IANA-assigned identifier
+// of the abstract data type with the given name, e.g., "unsigned32"
+func dataTypeToNumber(name string) (uint8, error) {
+	dataTypes.mu.RLock()
+	defer dataTypes.mu.RUnlock()
+	for id, n := range dataTypes.names {
+		if n == name {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: DataType %s is not assigned", ErrUnknownDataType, name)
+}
+
+// RegisterDataType adds a custom abstract data type, e.g., a vendor-specific type that decodes
+// smarter than octetArray, such that information elements may reference it by name in their Type.
+// The name must match the Type of the DataTypes created by c. Registering a name that is already
+// known fails with ErrDataTypeExists.
 //
-// TODO(zoomoid): rethink if panicking is the best idea here.
-func DataTypeFromNumber(id uint8) DataTypeConstructor {
-	switch id {
-	case 0:
-		return NewOctetArray
-	case 1:
-		return NewUnsigned8
-	case 2:
-		return NewUnsigned16
-	case 3:
-		return NewUnsigned32
-	case 4:
-		return NewUnsigned64
-	case 5:
-		return NewSigned8
-	case 6:
-		return NewSigned16
-	case 7:
-		return NewSigned32
-	case 8:
-		return NewSigned64
-	case 9:
-		return NewFloat32
-	case 10:
-		return NewFloat64
-	case 11:
-		return NewBoolean
-	case 12:
-		return NewMacAddress
-	case 13:
-		return NewString
-	case 14:
-		return NewDateTimeSeconds
-	case 15:
-		return NewDateTimeMilliseconds
-	case 16:
-		return NewDateTimeMicroseconds
-	case 17:
-		return NewDateTimeNanoseconds
-	case 18:
-		return NewIPv4Address
-	case 19:
-		return NewIPv6Address
-	case 20:
-		return NewBasicList
-	case 21:
-		return NewDefaultSubTemplateList
-	case 22:
-		return NewDefaultSubTemplateMultiList
-	default:
-		err := fmt.Errorf("DataType ID %d is not assigned", id)
-		// logger.V(1).Error(err, "cannot use id for retrieving data type", "id", id)
-		// panic from here because we have no proper error handling propagation from here
-		// a controller configured to recover from panics will pick this up.
-		panic(err)
+// RegisterDataType is safe for concurrent use, also while decoding.
+func RegisterDataType(name string, c DataTypeConstructor) error {
+	if c == nil {
+		return fmt.Errorf("failed to register data type %s, constructor is nil", name)
+	}
+	if typ := c().Type(); typ != name {
+		return fmt.Errorf("failed to register data type %s, constructor creates data type %s", name, typ)
 	}
+	dataTypes.mu.Lock()
+	defer dataTypes.mu.Unlock()
+	if _, ok := dataTypes.constructors[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDataTypeExists, name)
+	}
+	dataTypes.constructors[name] = c
+	return nil
+}
+
+// RegisterDataTypeNumber assigns an identifier to a data type known by name, e.g., one added with
+// RegisterDataType, such that RFC 5610 records announcing information elements of the data type
+// by its identifier can be decoded. Identifiers that are already assigned fail with
+// ErrDataTypeExists, unknown names with ErrUnknownDataType.
+//
+// RegisterDataTypeNumber is safe for concurrent use, also while decoding.
+func RegisterDataTypeNumber(id uint8, name string) error {
+	dataTypes.mu.Lock()
+	defer dataTypes.mu.Unlock()
+	if _, ok := dataTypes.constructors[name]; !ok {
+		return fmt.Errorf("%w %s", ErrUnknownDataType, name)
+	}
+	if existing, ok := dataTypes.names[id]; ok {
+		return fmt.Errorf("%w: DataType ID %d is assigned to %s", ErrDataTypeExists, id, existing)
+	}
+	dataTypes.names[id] = name
+	return nil
+}
+
+// dataTypeRegistry contains all abstract data types known by name, and their IANA-assigned
+// identifiers. It is extended at runtime by RegisterDataType and RegisterDataTypeNumber.
+type dataTypeRegistry struct {
+	mu           sync.RWMutex
+	constructors map[string]DataTypeConstructor
+	names        map[uint8]string
 }
 
-var constructors map[string]DataTypeConstructor = map[string]DataTypeConstructor{
-	"octetArray":           NewOctetArray,
-	"unsigned8":            NewUnsigned8,
-	"unsigned16":           NewUnsigned16,
-	"unsigned32":           NewUnsigned32,
-	"unsigned64":           NewUnsigned64,
-	"signed8":              NewSigned8,
-	"signed16":             NewSigned16,
-	"signed32":             NewSigned32,
-	"signed64":             NewSigned64,
-	"float32":              NewFloat32,
-	"float64":              NewFloat64,
-	"boolean":              NewBoolean,
-	"macAddress":           NewMacAddress,
-	"string":               NewString,
-	"dateTimeSeconds":      NewDateTimeSeconds,
-	"dateTimeMilliseconds": NewDateTimeMilliseconds,
-	"dateTimeMicroseconds": NewDateTimeMicroseconds,
-	"dateTimeNanoseconds":  NewDateTimeNanoseconds,
-	"ipv4Address":          NewIPv4Address,
-	"ipv6Address":          NewIPv6Address,
-	"basicList":            NewBasicList,
-	"subTemplateList":      NewDefaultSubTemplateList,
-	"subTemplateMultiList": NewDefaultSubTemplateMultiList,
+var dataTypes = &dataTypeRegistry{
+	constructors: map[string]DataTypeConstructor{
+		"octetArray":           NewOctetArray,
+		"unsigned8":            NewUnsigned8,
+		"unsigned16":           NewUnsigned16,
+		"unsigned32":           NewUnsigned32,
+		"unsigned64":           NewUnsigned64,
+		"signed8":              NewSigned8,
+		"signed16":             NewSigned16,
+		"signed32":             NewSigned32,
+		"signed64":             NewSigned64,
+		"float32":              NewFloat32,
+		"float64":              NewFloat64,
+		"boolean":              NewBoolean,
+		"macAddress":           NewMacAddress,
+		"string":               NewString,
+		"dateTimeSeconds":      NewDateTimeSeconds,
+		"dateTimeMilliseconds": NewDateTimeMilliseconds,
+		"dateTimeMicroseconds": NewDateTimeMicroseconds,
+		"dateTimeNanoseconds":  NewDateTimeNanoseconds,
+		"ipv4Address":          NewIPv4Address,
+		"ipv6Address":          NewIPv6Address,
+		"basicList":            NewBasicList,
+		"subTemplateList":      NewDefaultSubTemplateList,
+		"subTemplateMultiList": NewDefaultSubTemplateMultiList,
+	},
+	// see https://www.iana.org/assignments/ipfix/ipfix.xhtml#ipfix-information-element-data-types
+	names: map[uint8]string{
+		0:  "octetArray",
+		1:  "unsigned8",
+		2:  "unsigned16",
+		3:  "unsigned32",
+		4:  "unsigned64",
+		5:  "signed8",
+		6:  "signed16",
+		7:  "signed32",
+		8:  "signed64",
+		9:  "float32",
+		10: "float64",
+		11: "boolean",
+		12: "macAddress",
+		13: "string",
+		14: "dateTimeSeconds",
+		15: "dateTimeMilliseconds",
+		16: "dateTimeMicroseconds",
+		17: "dateTimeNanoseconds",
+		18: "ipv4Address",
+		19: "ipv6Address",
+		20: "basicList",
+		21: "subTemplateList",
+		22: "subTemplateMultiList",
+	},
 }
 
 var _ json.Marshaler = DataType(nil)
@@ -0,0 +1,369 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripValue is a representative value of a DataType. If length is non-zero,
+// the DataType is constructed with WithLength, e.g., for reduced-length encoding
+type roundTripValue struct {
+	value  any
+	length uint16
+}
+
+// roundTripValues contains representative values for each of the DataTypes returned by
+// SupportedTypes. Types added to SupportedTypes MUST add values here.
+var roundTripValues = map[string][]roundTripValue{
+	"octetArray": {{value: []byte{0xde, 0xad, 0xbe, 0xef}}, {value: []byte{}}},
+	"unsigned8":  {{value: uint8(0)}, {value: uint8(math.MaxUint8)}},
+	"unsigned16": {{value: uint16(4739)}, {value: uint16(math.MaxUint16)}, {value: uint16(255), length: 1}},
+	"unsigned32": {{value: uint32(math.MaxUint32)}, {value: uint32(65535), length: 2}},
+	"unsigned64": {{value: uint64(math.MaxUint64)}, {value: uint64(1<<53 + 1)}, {value: uint64(1 << 31), length: 4}},
+	"signed8":    {{value: int8(math.MinInt8)}, {value: int8(math.MaxInt8)}},
+	"signed16":   {{value: int16(math.MinInt16)}, {value: int16(-1)}},
+	"signed32":   {{value: int32(math.MinInt32)}, {value: int32(42)}},
+	"signed64":   {{value: int64(math.MinInt64)}, {value: int64(math.MaxInt64)}},
+	"float32":    {{value: float32(3.1415927)}, {value: float32(-0.1)}},
+	"float64":    {{value: math.Pi}, {value: math.SmallestNonzeroFloat64}},
+	"boolean":    {{value: true}, {value: false}},
+	"macAddress": {{value: net.HardwareAddr{0xac, 0x74, 0xb1, 0x88, 0x3a, 0xa5}}, {value: "aa:bb:cc:dd:ee:ff"}},
+	"string":     {{value: "ipfix"}, {value: "ünïcödé"}, {value: ""}},
+
+	"dateTimeSeconds":      {{value: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)}},
+	"dateTimeMilliseconds": {{value: time.Date(2023, 10, 1, 12, 0, 0, 123_000_000, time.UTC)}, {value: "2023-10-01T12:00:00.999Z"}},
+	"dateTimeMicroseconds": {{value: time.Date(2023, 10, 1, 12, 0, 0, 123_456_000, time.UTC)}, {value: time.Date(2023, 10, 1, 12, 0, 0, 999_999_999, time.UTC)}, {value: time.Date(2040, 1, 1, 0, 0, 0, 1_000, time.UTC)}},
+	"dateTimeNanoseconds":  {{value: time.Date(2023, 10, 1, 12, 0, 0, 123_456_789, time.UTC)}, {value: time.Date(2023, 10, 1, 12, 0, 0, 999_999_999, time.UTC)}, {value: time.Date(2040, 1, 1, 0, 0, 0, 1, time.UTC)}},
+
+	"ipv4Address": {{value: net.IPv4(192, 0, 2, 1)}, {value: netip.MustParseAddr("198.51.100.7")}, {value: "203.0.113.255"}},
+	"ipv6Address": {{value: net.ParseIP("2001:db8::1")}, {value: netip.MustParseAddr("2001:db8::ff")}, {value: "::ffff:192.0.2.1"}},
+
+	// registered by TestRegisterDataType
+	"testTCPFlags": {{value: uint16(0x12)}, {value: uint16(0)}},
+}
+
+// listTypes are covered by their respective tests, as they require a FieldCache and TemplateCache
+var listTypes = map[string]bool{
+	"basicList":            true,
+	"subTemplateList":      true,
+	"subTemplateMultiList": true,
+}
+
+func TestDataTypeRoundTrip(t *testing.T) {
+	for _, c := range SupportedTypes() {
+		name := c().Type()
+		if listTypes[name] {
+			continue
+		}
+		values, ok := roundTripValues[name]
+		if !ok {
+			t.Errorf("no representative values for data type %s", name)
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			for _, v := range values {
+				constructor := c
+				if v.length > 0 {
+					constructor = c().WithLength(v.length)
+				}
+
+				// create the original bytes from the representative value
+				original := &bytes.Buffer{}
+				in := constructor().SetValue(v.value)
+				if _, err := in.Encode(original); err != nil {
+					t.Fatal(err)
+				}
+				// variable-length types take their length from the value
+				length := in.Length()
+				if v.length == 0 && c().DefaultLength() == 0 {
+					constructor = c().WithLength(length)
+				}
+
+				decoded := constructor()
+				if _, err := decoded.Decode(bytes.NewBuffer(original.Bytes())); err != nil {
+					t.Fatal(err)
+				}
+				b, err := json.Marshal(decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				restored := constructor()
+				if err := json.Unmarshal(b, restored); err != nil {
+					t.Fatalf("failed to unmarshal %s, %v", string(b), err)
+				}
+				encoded := &bytes.Buffer{}
+				if _, err := restored.Encode(encoded); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(original.Bytes(), encoded.Bytes()) {
+					t.Errorf("expected %v to round-trip via %s to %x, got %x", v.value, string(b), original.Bytes(), encoded.Bytes())
+				}
+			}
+		})
+	}
+
+	t.Run("boolean unmarshals numeric encoding", func(t *testing.T) {
+		for in, expected := range map[string]bool{"1": true, "2": false, "true": true, "false": false} {
+			b := &Boolean{}
+			if err := json.Unmarshal([]byte(in), b); err != nil {
+				t.Fatal(err)
+			}
+			if b.Value() != expected {
+				t.Errorf("expected %s to unmarshal to %v, got %v", in, expected, b.Value())
+			}
+		}
+		if err := json.Unmarshal([]byte("3"), &Boolean{}); err == nil {
+			t.Error("expected 3 to fail unmarshalling")
+		}
+	})
+}
+
+// testTCPFlags is a custom data type for TestRegisterDataType, which renders TCP control bits by
+// their names rather than as a number
+type testTCPFlags struct {
+	Unsigned16
+}
+
+var testTCPFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+func newTestTCPFlags() DataType {
+	return &testTCPFlags{}
+}
+
+func (t *testTCPFlags) Type() string {
+	return "testTCPFlags"
+}
+
+func (t *testTCPFlags) names() []string {
+	names := make([]string, 0)
+	for i, name := range testTCPFlagNames {
+		if t.value&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (t *testTCPFlags) String() string {
+	return strings.Join(t.names(), "|")
+}
+
+func (t *testTCPFlags) Clone() DataType {
+	return &testTCPFlags{Unsigned16: Unsigned16{value: t.value}}
+}
+
+func (t *testTCPFlags) WithLength(length uint16) DataTypeConstructor {
+	return newTestTCPFlags
+}
+
+func (t *testTCPFlags) SetLength(length uint16) DataType {
+	t.Unsigned16.SetLength(length)
+	return t
+}
+
+func (t *testTCPFlags) SetValue(v any) DataType {
+	t.Unsigned16.SetValue(v)
+	return t
+}
+
+func (t *testTCPFlags) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.names())
+}
+
+func (t *testTCPFlags) UnmarshalJSON(in []byte) error {
+	names := make([]string, 0)
+	if err := json.Unmarshal(in, &names); err != nil {
+		return err
+	}
+	t.value = 0
+	for _, name := range names {
+		i := 0
+		for i < len(testTCPFlagNames) && testTCPFlagNames[i] != name {
+			i++
+		}
+		if i == len(testTCPFlagNames) {
+			return fmt.Errorf("unknown TCP flag %s", name)
+		}
+		t.value |= 1 << i
+	}
+	return nil
+}
+
+func TestRegisterDataType(t *testing.T) {
+	ctx := context.Background()
+
+	const name = "testTCPFlags"
+	const number uint8 = 250
+
+	// tests may run more than once, so tolerate previous registrations here
+	if err := RegisterDataType(name, newTestTCPFlags); err != nil && !errors.Is(err, ErrDataTypeExists) {
+		t.Fatal(err)
+	}
+	if err := RegisterDataTypeNumber(number, name); err != nil && !errors.Is(err, ErrDataTypeExists) {
+		t.Fatal(err)
+	}
+
+	t.Run("lookup", func(t *testing.T) {
+		c, err := LookupConstructor(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c().Type() != name {
+			t.Errorf("expected constructor of %s, got %s", name, c().Type())
+		}
+		c, err = DataTypeFromNumber(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c().Type() != name {
+			t.Errorf("expected constructor of %s for %d, got %s", name, number, c().Type())
+		}
+		if n, err := dataTypeToNumber(name); err != nil || n != number {
+			t.Errorf("expected number %d, got %d (%v)", number, n, err)
+		}
+	})
+
+	t.Run("unknown data types", func(t *testing.T) {
+		if _, err := LookupConstructor("unsigned128"); !errors.Is(err, ErrUnknownDataType) {
+			t.Errorf("expected ErrUnknownDataType, got %v", err)
+		}
+		if _, err := DataTypeFromNumber(number + 1); !errors.Is(err, ErrUnknownDataType) {
+			t.Errorf("expected ErrUnknownDataType, got %v", err)
+		}
+		if err := RegisterDataTypeNumber(number+1, "unsigned128"); !errors.Is(err, ErrUnknownDataType) {
+			t.Errorf("expected ErrUnknownDataType, got %v", err)
+		}
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		if err := RegisterDataType(name, newTestTCPFlags); !errors.Is(err, ErrDataTypeExists) {
+			t.Errorf("expected ErrDataTypeExists, got %v", err)
+		}
+		if err := RegisterDataType("unsigned16", NewUnsigned16); !errors.Is(err, ErrDataTypeExists) {
+			t.Errorf("expected ErrDataTypeExists for built-in data type, got %v", err)
+		}
+		if err := RegisterDataTypeNumber(number, "unsigned16"); !errors.Is(err, ErrDataTypeExists) {
+			t.Errorf("expected ErrDataTypeExists, got %v", err)
+		}
+		if err := RegisterDataTypeNumber(2, name); !errors.Is(err, ErrDataTypeExists) {
+			t.Errorf("expected ErrDataTypeExists for IANA-assigned number, got %v", err)
+		}
+	})
+
+	t.Run("constructor must match name", func(t *testing.T) {
+		if err := RegisterDataType("testFlags", newTestTCPFlags); err == nil {
+			t.Error("expected error for constructor of a different data type")
+		}
+	})
+
+	t.Run("announce with RFC 5610", func(t *testing.T) {
+		typ := name
+		sets, err := InformationElementSets(400, InformationElement{Id: 2, EnterpriseId: 32473, Name: "vendorTcpFlags", Type: &typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &Message{Version: 10, Length: uint16(messageHeaderLength), Sets: sets}
+		for _, s := range sets {
+			msg.Length += s.Length
+		}
+		b := &bytes.Buffer{}
+		if _, err := msg.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldCache(templateCache)
+		if _, err := NewDecoder(templateCache, fieldCache).Decode(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		ie, err := fieldCache.Get(ctx, NewFieldKey(32473, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ie.Type == nil || *ie.Type != name || ie.Constructor().Type() != name {
+			t.Errorf("expected learned information element of type %s, got %s", name, ie)
+		}
+	})
+
+	t.Run("decode record", func(t *testing.T) {
+		ie := &InformationElement{}
+		if err := json.Unmarshal([]byte(`{"id":1,"pen":32473,"name":"vendorTcpFlags","type":"testTCPFlags"}`), ie); err != nil {
+			t.Fatal(err)
+		}
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldCache(templateCache)
+		if err := fieldCache.Add(ctx, *ie); err != nil {
+			t.Fatal(err)
+		}
+
+		// template 256 with sourceTransportPort and vendorTcpFlags, and a record of it
+		templates := []byte{0x01, 0x00, 0x00, 0x02, 0x00, 0x07, 0x00, 0x02, 0x80, 0x01, 0x00, 0x02, 0x00, 0x00, 0x7e, 0xd9}
+		records := []byte{0xc3, 0x50, 0x00, 0x12}
+		b := binary.BigEndian.AppendUint16(nil, 10)
+		b = binary.BigEndian.AppendUint16(b, uint16(16+4+len(templates)+4+len(records)))
+		b = binary.BigEndian.AppendUint32(b, 0)
+		b = binary.BigEndian.AppendUint32(b, 0)
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = binary.BigEndian.AppendUint16(b, IPFIX)
+		b = binary.BigEndian.AppendUint16(b, uint16(4+len(templates)))
+		b = append(b, templates...)
+		b = binary.BigEndian.AppendUint16(b, 256)
+		b = binary.BigEndian.AppendUint16(b, uint16(4+len(records)))
+		b = append(b, records...)
+
+		msg, err := NewDecoder(templateCache, fieldCache).Decode(ctx, bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr := msg.Sets[1].Set.(*DataSet).Records[0]
+		f, ok := dr.FieldById(32473, 1)
+		if !ok {
+			t.Fatal("expected record to contain vendorTcpFlags")
+		}
+		if _, ok := f.Value().(*testTCPFlags); !ok {
+			t.Fatalf("expected value of type %T, got %T", &testTCPFlags{}, f.Value())
+		}
+		if s := f.Value().String(); s != "SYN|ACK" {
+			t.Errorf("expected SYN|ACK, got %s", s)
+		}
+
+		// restoring the record from JSON looks up the data type by name again
+		j, err := json.Marshal(&dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := &DataRecord{}
+		if err := json.Unmarshal(j, restored); err != nil {
+			t.Fatal(err)
+		}
+		if s := restored.Fields[1].Value().String(); s != "SYN|ACK" {
+			t.Errorf("expected restored SYN|ACK, got %s", s)
+		}
+	})
+}
@@ -25,6 +25,9 @@ import (
 	"time"
 )
 
+// DateTimeMicroseconds is the dateTimeMicroseconds data type of RFC 7011, Section 6.1.9, encoded
+// as NTP timestamp with the lower 11 bits of the fraction set to zero. Value returns a time.Time
+// in UTC.
 type DateTimeMicroseconds struct {
 	value    time.Time
 	seconds  uint32
@@ -47,12 +50,32 @@ func (t *DateTimeMicroseconds) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets time.Time as value, as well as strings in RFC 3339 format, which is used for
+// times in JSON
+func (t *DateTimeMicroseconds) SetValueE(v any) (DataType, error) {
+	var ts time.Time
+	switch b := v.(type) {
+	case time.Time:
+		ts = b
+	case string:
+		var err error
+		ts, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a time", t, ErrInvalidValue, v)
+	}
+	t.value = ts.UTC()
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *DateTimeMicroseconds) SetValue(v any) DataType {
-	b, ok := v.(time.Time)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.value = b
 	return t
 }
 
@@ -88,36 +111,41 @@ func (*DateTimeMicroseconds) IsReducedLength() bool {
 }
 
 func (t *DateTimeMicroseconds) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
 	t.seconds = binary.BigEndian.Uint32(b[0 : t.Length()/2])
 	// reading the fractional part while also masking the lower 11 bits as per RFC 7011#6.1.9
-	t.fraction = float64(binary.BigEndian.Uint32(b[t.Length()/2:t.Length()])&0xFFFFF800) / math.Pow(2, 32)
-	t.value = ntpEpoch.Add(time.Duration(t.seconds) * time.Second).Add(time.Duration(t.fraction) * time.Second)
+	fraction := binary.BigEndian.Uint32(b[t.Length()/2:t.Length()]) & 0xFFFFF800
+	t.fraction = float64(fraction) / math.Pow(2, 32)
+	t.value = fromNTPTimestamp(t.seconds, fraction)
 	return n, nil
 }
 
 func (t *DateTimeMicroseconds) Encode(w io.Writer) (int, error) {
-	b := make([]byte, 0)
-
-	seconds := uint32(t.value.Sub(ntpEpoch).Seconds())
-	fraction := t.value.Sub(ntpEpoch).Seconds() - float64(seconds)
-
+	b := make([]byte, 0, t.Length())
+	// the lower 11 bits of the fraction must be zero as per RFC 7011#6.1.9
+	seconds, fraction := toNTPTimestamp(t.value, 0xFFFFF800)
 	b = binary.BigEndian.AppendUint32(b, seconds)
-	fr := uint32(fraction*math.Pow(2, 32)) & 0xFFFFF800
-	b = binary.BigEndian.AppendUint32(b, fr)
+	b = binary.BigEndian.AppendUint32(b, fraction)
 	return w.Write(b)
 }
 
 func (t *DateTimeMicroseconds) MarshalJSON() ([]byte, error) {
-	return json.Marshal(t.value)
+	return json.Marshal(t.value.UTC().Format(dateTimeNTPLayout))
 }
 
 func (t *DateTimeMicroseconds) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	ts, err := unmarshalTime(in)
+	if err != nil {
+		return err
+	}
+	t.SetValue(ts)
+	return nil
 }
 
 var _ DataTypeConstructor = NewDateTimeMicroseconds
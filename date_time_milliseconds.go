@@ -24,6 +24,8 @@ import (
 	"time"
 )
 
+// DateTimeMilliseconds is the dateTimeMilliseconds data type of RFC 7011, Section 6.1.8, i.e.,
+// milliseconds since the UNIX epoch. Value returns a time.Time in UTC.
 type DateTimeMilliseconds struct {
 	value time.Time
 }
@@ -44,12 +46,32 @@ func (t *DateTimeMilliseconds) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets time.Time as value, as well as strings in RFC 3339 format, which is used for
+// times in JSON
+func (t *DateTimeMilliseconds) SetValueE(v any) (DataType, error) {
+	var ts time.Time
+	switch b := v.(type) {
+	case time.Time:
+		ts = b
+	case string:
+		var err error
+		ts, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a time", t, ErrInvalidValue, v)
+	}
+	t.value = ts.UTC().Truncate(time.Millisecond)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *DateTimeMilliseconds) SetValue(v any) DataType {
-	b, ok := v.(time.Time)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.value = b
 	return t
 }
 
@@ -85,13 +107,15 @@ func (*DateTimeMilliseconds) IsReducedLength() bool {
 }
 
 func (t *DateTimeMilliseconds) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
 	milliseconds := binary.BigEndian.Uint64(b)
-	t.value = time.UnixMilli(int64(milliseconds))
+	t.value = time.UnixMilli(int64(milliseconds)).UTC()
 	return n, nil
 }
 
@@ -102,11 +126,16 @@ func (t *DateTimeMilliseconds) Encode(w io.Writer) (int, error) {
 }
 
 func (t *DateTimeMilliseconds) MarshalJSON() ([]byte, error) {
-	return json.Marshal(t.value)
+	return json.Marshal(t.value.UTC().Format(dateTimeMillisecondsLayout))
 }
 
 func (t *DateTimeMilliseconds) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	ts, err := unmarshalTime(in)
+	if err != nil {
+		return err
+	}
+	t.SetValue(ts)
+	return nil
 }
 
 var _ DataTypeConstructor = NewDateTimeMilliseconds
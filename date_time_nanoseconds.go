@@ -25,6 +25,8 @@ import (
 	"time"
 )
 
+// DateTimeNanoseconds is the dateTimeNanoseconds data type of RFC 7011, Section 6.1.10, encoded as
+// NTP timestamp. Value returns a time.Time in UTC.
 type DateTimeNanoseconds struct {
 	value    time.Time
 	seconds  uint32
@@ -37,6 +39,40 @@ func NewDateTimeNanoseconds() DataType {
 
 var ntpEpoch time.Time = time.Date(1900, time.Month(1), 1, 0, 0, 0, 0, time.UTC)
 
+// toNTPTimestamp converts a time to the NTP timestamp format used by dateTimeMicroseconds and
+// dateTimeNanoseconds in RFC 7011, Section 6.1.9 and 6.1.10. The fraction is rounded to the
+// nearest value whose bits outside of mask are zero, such that decoding and re-encoding
+// a timestamp yields the same bytes. Times after the NTP rollover in 2036 wrap around, see
+// fromNTPTimestamp.
+func toNTPTimestamp(t time.Time, mask uint32) (seconds uint32, fraction uint32) {
+	s := uint64(t.Unix() - ntpEpoch.Unix())
+	// fraction in units of 2^-32 seconds, rounded to nearest
+	f := (uint64(t.Nanosecond())<<32 + 500_000_000) / 1_000_000_000
+	step := uint64(^mask) + 1
+	f = (f + step/2) / step * step
+	if f >= 1<<32 {
+		s++
+		f -= 1 << 32
+	}
+	return uint32(s), uint32(f)
+}
+
+// fromNTPTimestamp converts an NTP timestamp to a time, rounding the fraction
+// to the nearest nanosecond.
+//
+// The 32 bit seconds of NTP timestamps roll over on 2036-02-07T06:28:16Z. Following RFC 4330,
+// Section 3, seconds with the most significant bit set are in the era starting at the NTP epoch
+// in 1900, all others are in the era starting in 2036, such that timestamps cover the range from
+// 1968 to 2104.
+func fromNTPTimestamp(seconds uint32, fraction uint32) time.Time {
+	s := int64(seconds)
+	if seconds&0x80000000 == 0 {
+		s += 1 << 32
+	}
+	nanoseconds := (uint64(fraction)*1_000_000_000 + 1<<31) >> 32
+	return time.Unix(ntpEpoch.Unix()+s, int64(nanoseconds)).UTC()
+}
+
 func (t *DateTimeNanoseconds) String() string {
 	return fmt.Sprintf("%v", t.value)
 }
@@ -49,12 +85,32 @@ func (t *DateTimeNanoseconds) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets time.Time as value, as well as strings in RFC 3339 format, which is used for
+// times in JSON
+func (t *DateTimeNanoseconds) SetValueE(v any) (DataType, error) {
+	var ts time.Time
+	switch b := v.(type) {
+	case time.Time:
+		ts = b
+	case string:
+		var err error
+		ts, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a time", t, ErrInvalidValue, v)
+	}
+	t.value = ts.UTC()
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *DateTimeNanoseconds) SetValue(v any) DataType {
-	b, ok := v.(time.Time)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.value = b
 	return t
 }
 
@@ -90,36 +146,39 @@ func (*DateTimeNanoseconds) IsReducedLength() bool {
 }
 
 func (t *DateTimeNanoseconds) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
 	t.seconds = binary.BigEndian.Uint32(b[0 : t.Length()/2])
-	// reading the fractional part while also masking the lower 11 bits as per RFC 7011#6.1.9
-	t.fraction = float64(binary.BigEndian.Uint32(b[t.Length()/2:t.Length()])) / math.Pow(2, 32)
-	t.value = ntpEpoch.Add(time.Duration(t.seconds) * time.Second).Add(time.Duration(t.fraction) * time.Second)
+	fraction := binary.BigEndian.Uint32(b[t.Length()/2 : t.Length()])
+	t.fraction = float64(fraction) / math.Pow(2, 32)
+	t.value = fromNTPTimestamp(t.seconds, fraction)
 	return n, nil
 }
 
 func (t *DateTimeNanoseconds) Encode(w io.Writer) (int, error) {
-	b := make([]byte, 0)
-
-	seconds := uint32(t.value.Sub(ntpEpoch).Seconds())
-	fraction := t.value.Sub(ntpEpoch).Seconds() - float64(seconds)
-
+	b := make([]byte, 0, t.Length())
+	seconds, fraction := toNTPTimestamp(t.value, 0xFFFFFFFF)
 	b = binary.BigEndian.AppendUint32(b, seconds)
-	fr := uint32(fraction * math.Pow(2, 32))
-	b = binary.BigEndian.AppendUint32(b, fr)
+	b = binary.BigEndian.AppendUint32(b, fraction)
 	return w.Write(b)
 }
 
 func (t *DateTimeNanoseconds) MarshalJSON() ([]byte, error) {
-	return json.Marshal(t.value)
+	return json.Marshal(t.value.UTC().Format(dateTimeNTPLayout))
 }
 
 func (t *DateTimeNanoseconds) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	ts, err := unmarshalTime(in)
+	if err != nil {
+		return err
+	}
+	t.SetValue(ts)
+	return nil
 }
 
 var _ DataTypeConstructor = NewDateTimeNanoseconds
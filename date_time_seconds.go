@@ -24,6 +24,8 @@ import (
 	"time"
 )
 
+// DateTimeSeconds is the dateTimeSeconds data type of RFC 7011, Section 6.1.7, i.e., seconds since
+// the UNIX epoch. Value returns a time.Time in UTC.
 type DateTimeSeconds struct {
 	value time.Time
 }
@@ -32,6 +34,33 @@ func NewDateTimeSeconds() DataType {
 	return &DateTimeSeconds{}
 }
 
+// Layouts of the dateTime* data types in JSON. All of them are RFC 3339-compliant in UTC with
+// the precision of the respective data type. dateTimeMicroseconds uses nanoseconds as well,
+// as its resolution of 2^-21 seconds is not a whole number of microseconds.
+const (
+	dateTimeSecondsLayout      string = "2006-01-02T15:04:05Z07:00"
+	dateTimeMillisecondsLayout string = "2006-01-02T15:04:05.000Z07:00"
+	dateTimeNTPLayout          string = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// unmarshalTime parses an RFC 3339-encoded JSON string of any precision into a time in UTC
+func unmarshalTime(in []byte) (time.Time, error) {
+	var s string
+	err := json.Unmarshal(in, &s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if s == "" {
+		// null or empty string
+		return time.Time{}, nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse time %q, %w", s, err)
+	}
+	return ts.UTC(), nil
+}
+
 func (t *DateTimeSeconds) String() string {
 	return fmt.Sprintf("%v", t.value)
 }
@@ -44,12 +73,32 @@ func (t *DateTimeSeconds) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets time.Time as value, as well as strings in RFC 3339 format, which is used for
+// times in JSON
+func (t *DateTimeSeconds) SetValueE(v any) (DataType, error) {
+	var ts time.Time
+	switch b := v.(type) {
+	case time.Time:
+		ts = b
+	case string:
+		var err error
+		ts, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a time", t, ErrInvalidValue, v)
+	}
+	t.value = ts.UTC().Truncate(time.Second)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *DateTimeSeconds) SetValue(v any) DataType {
-	b, ok := v.(time.Time)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.value = b
 	return t
 }
 
@@ -85,8 +134,10 @@ func (*DateTimeSeconds) IsReducedLength() bool {
 }
 
 func (t *DateTimeSeconds) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -102,11 +153,16 @@ func (t *DateTimeSeconds) Encode(w io.Writer) (int, error) {
 }
 
 func (t *DateTimeSeconds) MarshalJSON() ([]byte, error) {
-	return json.Marshal(t.value)
+	return json.Marshal(t.value.UTC().Format(dateTimeSecondsLayout))
 }
 
 func (t *DateTimeSeconds) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	ts, err := unmarshalTime(in)
+	if err != nil {
+		return err
+	}
+	t.SetValue(ts)
+	return nil
 }
 
 var _ DataTypeConstructor = NewDateTimeSeconds
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDateTime(t *testing.T) {
+	ntp := func(seconds, fraction uint32) []byte {
+		b := binary.BigEndian.AppendUint32(nil, seconds)
+		return binary.BigEndian.AppendUint32(b, fraction)
+	}
+
+	vectors := []struct {
+		name        string
+		constructor DataTypeConstructor
+		in          []byte
+		expected    time.Time
+		// lossy is set for fractions finer than a nanosecond, which do not re-encode to the same bytes
+		lossy bool
+	}{
+		{"unix epoch", NewDateTimeNanoseconds, ntp(0x83AA7E80, 0), time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"half a second before rollover", NewDateTimeNanoseconds, ntp(0xFFFFFFFF, 0x80000000), time.Date(2036, 2, 7, 6, 28, 15, 500_000_000, time.UTC), false},
+		{"rollover", NewDateTimeNanoseconds, ntp(0, 0), time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC), false},
+		{"after rollover", NewDateTimeNanoseconds, ntp(0x0754FD00, 0), time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"after rollover in microseconds", NewDateTimeMicroseconds, ntp(0x0754FD00, 0x80000000), time.Date(2040, 1, 1, 0, 0, 0, 500_000_000, time.UTC), false},
+		{"largest microseconds fraction", NewDateTimeMicroseconds, ntp(0x83AA7E80, 0xFFFFF800), time.Date(1970, 1, 1, 0, 0, 0, 999_999_523, time.UTC), false},
+		{"microseconds mask lower bits", NewDateTimeMicroseconds, ntp(0x83AA7E80, 0x000007FF), time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"smallest nanoseconds fraction", NewDateTimeNanoseconds, ntp(0x83AA7E80, 1), time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"single nanosecond", NewDateTimeNanoseconds, ntp(0x83AA7E80, 4), time.Date(1970, 1, 1, 0, 0, 0, 1, time.UTC), false},
+	}
+	for _, v := range vectors {
+		t.Run(v.name, func(t *testing.T) {
+			dt := v.constructor()
+			if _, err := dt.Decode(bytes.NewBuffer(v.in)); err != nil {
+				t.Fatal(err)
+			}
+			ts, ok := dt.Value().(time.Time)
+			if !ok {
+				t.Fatalf("expected time.Time, got %T", dt.Value())
+			}
+			if !ts.Equal(v.expected) || ts.Location() != time.UTC {
+				t.Errorf("expected %s, got %s", v.expected.Format(time.RFC3339Nano), ts.Format(time.RFC3339Nano))
+			}
+			if v.lossy {
+				return
+			}
+			b := &bytes.Buffer{}
+			if _, err := v.constructor().SetValue(v.expected).Encode(b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.in, b.Bytes()) {
+				t.Errorf("expected %s to encode to %x, got %x", v.expected.Format(time.RFC3339Nano), v.in, b.Bytes())
+			}
+		})
+	}
+
+	t.Run("values are in UTC", func(t *testing.T) {
+		local := time.Date(2023, 10, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		for _, c := range []DataTypeConstructor{NewDateTimeSeconds, NewDateTimeMilliseconds, NewDateTimeMicroseconds, NewDateTimeNanoseconds} {
+			ts := c().SetValue(local).Value().(time.Time)
+			if ts.Location() != time.UTC || !ts.Equal(local) {
+				t.Errorf("expected %T to hold %s in UTC, got %s", c(), local, ts)
+			}
+		}
+	})
+
+	t.Run("json precision", func(t *testing.T) {
+		ts := time.Date(2023, 10, 1, 12, 0, 0, 123_456_789, time.UTC)
+		for expected, c := range map[string]DataTypeConstructor{
+			`"2023-10-01T12:00:00Z"`:           NewDateTimeSeconds,
+			`"2023-10-01T12:00:00.123Z"`:       NewDateTimeMilliseconds,
+			`"2023-10-01T12:00:00.123456789Z"`: NewDateTimeNanoseconds,
+		} {
+			b, err := json.Marshal(c().SetValue(ts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != expected {
+				t.Errorf("expected %s, got %s", expected, string(b))
+			}
+		}
+	})
+}
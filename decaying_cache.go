@@ -18,8 +18,6 @@ package ipfix
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"sync"
 	"time"
 )
@@ -28,52 +26,111 @@ type templateElement struct {
 	deadline time.Time
 	created  time.Time
 
+	// expired marks elements whose deadline passed. Expired elements are retained as tombstones for
+	// another timeout such that Get can tell expired templates from templates never seen.
 	expired bool
 
 	template *Template
 }
 
+// defaultExpiryInterval is the interval in which Start checks for expired templates while expiry
+// is disabled, such that a timeout set later on is picked up
+const defaultExpiryInterval = time.Second
+
+// TemplateLifetimeMultiplier is the multiple of the exporter's template refresh interval after which
+// templates received over UDP expire when using WithTemplateRefreshInterval. RFC 7011 Section 10.3.7
+// recommends a template lifetime of at least three times the refresh interval, such that a single
+// lost template set does not expire the template.
+const TemplateLifetimeMultiplier = 3
+
+// DecayingEphemeralCache is an in-memory template cache whose templates expire after a timeout,
+// unless they are announced again, as required for UDP transport by RFC 7011 Section 10.3.7.
+//
+// Get returns an error wrapping ErrTemplateExpired for expired templates. Expired templates are
+// removed by Start, which should be run for the lifetime of the cache.
 type DecayingEphemeralCache struct {
 	templates map[TemplateKey]templateElement
 
 	timeout time.Duration
 
+	// refreshOnUse extends the deadline of templates by the timeout from their last usage
+	refreshOnUse bool
+
+	// now returns the current time, overridden by WithClock
+	now func() time.Time
+
 	mu *sync.RWMutex
 
 	name string
 }
 
 var _ TemplateCacheWithTimeout = &DecayingEphemeralCache{}
+var _ TemplateCacheWithStats = &DecayingEphemeralCache{}
+var _ StatefulTemplateCache = &DecayingEphemeralCache{}
+
+// DecayingCacheOption configures a DecayingEphemeralCache created with NewDefaultDecayingEphemeralCache
+// or NewNamedDecayingEphemeralCache
+type DecayingCacheOption func(*DecayingEphemeralCache)
+
+// WithTemplateTimeout sets the duration after which templates expire if they are not announced
+// again, see DecayingEphemeralCache.SetTimeout.
+func WithTemplateTimeout(d time.Duration) DecayingCacheOption {
+	return func(c *DecayingEphemeralCache) {
+		c.timeout = d
+	}
+}
 
-func NewDefaultDecayingEphemeralCache() TemplateCache {
-	return NewNamedDecayingEphemeralCache("default")
+// WithTemplateRefreshInterval sets the timeout of templates to TemplateLifetimeMultiplier times the
+// interval in which the exporter announces its templates again over UDP.
+func WithTemplateRefreshInterval(d time.Duration) DecayingCacheOption {
+	return func(c *DecayingEphemeralCache) {
+		c.timeout = TemplateLifetimeMultiplier * d
+	}
+}
+
+// WithClock replaces the clock used for computing deadlines, e.g., with a fake clock in tests.
+func WithClock(now func() time.Time) DecayingCacheOption {
+	return func(c *DecayingEphemeralCache) {
+		c.now = now
+	}
 }
 
-func NewNamedDecayingEphemeralCache(name string) TemplateCache {
-	return &DecayingEphemeralCache{
+func NewDefaultDecayingEphemeralCache(opts ...DecayingCacheOption) TemplateCache {
+	return NewNamedDecayingEphemeralCache("default", opts...)
+}
+
+func NewNamedDecayingEphemeralCache(name string, opts ...DecayingCacheOption) TemplateCache {
+	c := &DecayingEphemeralCache{
 		templates: make(map[TemplateKey]templateElement),
 		mu:        &sync.RWMutex{},
 		name:      name,
 		timeout:   0,
+		now:       time.Now,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func (ts *DecayingEphemeralCache) GetAll(ctx context.Context) map[TemplateKey]*Template {
-	ts.expireTemplates()
-
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
+	now := ts.now()
 	mm := make(map[TemplateKey]*Template, len(ts.templates))
 	for k, v := range ts.templates {
+		if ts.isExpired(v, now) {
+			continue
+		}
 		mm[k] = v.template
 	}
 	return mm
 }
 
+// Get returns the template stored at key. For templates whose deadline passed, Get returns an
+// UnknownTemplateError wrapping ErrTemplateExpired, which in turn wraps ErrTemplateNotFound.
 func (ts *DecayingEphemeralCache) Get(ctx context.Context, key TemplateKey) (*Template, error) {
-	ts.expireTemplates()
-
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
@@ -81,27 +138,25 @@ func (ts *DecayingEphemeralCache) Get(ctx context.Context, key TemplateKey) (*Te
 	if !ok {
 		return nil, templateNotFound(key.ObservationDomainId, key.TemplateId)
 	}
-
-	if te.expired {
-		return nil, fmt.Errorf("template %d expired for domain %d", key.TemplateId, key.ObservationDomainId)
+	if ts.isExpired(te, ts.now()) {
+		return nil, templateExpired(key.ObservationDomainId, key.TemplateId)
 	}
 
 	return te.template, nil
 }
 
+// Add adds the template to the cache. Adding a template again, i.e., when the exporter announces it
+// again, refreshes its deadline, also for templates that already expired.
 func (ts *DecayingEphemeralCache) Add(ctx context.Context, key TemplateKey, template *Template) error {
-	ts.expireTemplates()
-
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	created := time.Now()
+	created := ts.now()
 	deadline := created.Add(ts.timeout)
 
 	ts.templates[key] = templateElement{
 		created:  created,
 		deadline: deadline,
-		expired:  false,
 		template: template,
 	}
 	return nil
@@ -120,11 +175,43 @@ func (t *DecayingEphemeralCache) Delete(ctx context.Context, key TemplateKey) er
 // runtime, and there are already templates in the cache, a longer or shorter timeout duration does NOT affect
 // the deadline of those templates, only new ones
 func (ts *DecayingEphemeralCache) SetTimeout(d time.Duration) {
-	ts.expireTemplates()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
 
 	ts.timeout = d
 }
 
+// SetRefreshOnUse configures the cache to extend the deadline of templates that are used for
+// decoding data sets, such that only templates that are neither refreshed by the exporter nor
+// used for the timeout duration expire.
+func (ts *DecayingEphemeralCache) SetRefreshOnUse(refresh bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.refreshOnUse = refresh
+}
+
+// Stats returns usage statistics of all templates in the cache that have not expired yet
+func (ts *DecayingEphemeralCache) Stats(ctx context.Context) []TemplateStats {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	now := ts.now()
+	mm := make(map[TemplateKey]*Template, len(ts.templates))
+	for k, v := range ts.templates {
+		if ts.isExpired(v, now) {
+			continue
+		}
+		mm[k] = v.template
+	}
+	stats := templateStats(mm)
+	for i, s := range stats {
+		// the cache's own creation time of the element is authoritative for expiry
+		stats[i].Created = ts.templates[s.Key].created
+	}
+	return stats
+}
+
 func (ts *DecayingEphemeralCache) Type() string {
 	return "decaying_ephemeral"
 }
@@ -134,30 +221,68 @@ func (ts *DecayingEphemeralCache) Name() string {
 }
 
 func (ts *DecayingEphemeralCache) MarshalJSON() ([]byte, error) {
-	ts.expireTemplates()
-
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
-	s := make(map[string]interface{})
+	now := ts.now()
+	s := make(map[TemplateKey]*Template, len(ts.templates))
 	for k, v := range ts.templates {
-		if !v.expired {
-			s[k.String()] = v
+		if ts.isExpired(v, now) {
+			continue
 		}
+		s[k] = v.template
+	}
+	return marshalSortedJSON(s, templateKeyLess, templateKeyString)
+}
+
+// deadlineOf returns the deadline of the element. If refreshOnUse is set, the deadline is computed
+// from the last usage of the template, if that is later than its creation. Callers must hold ts.mu.
+func (ts *DecayingEphemeralCache) deadlineOf(v templateElement) time.Time {
+	deadline := v.deadline
+	if ts.refreshOnUse && v.template != nil {
+		if lastUsed := v.template.LastUsed(); !lastUsed.IsZero() && lastUsed.Add(ts.timeout).After(deadline) {
+			deadline = lastUsed.Add(ts.timeout)
+		}
+	}
+	return deadline
+}
+
+// isExpired returns true if the element was expired by Start, or its deadline passed in the meantime.
+// A timeout of 0 disables expiry. Callers must hold ts.mu.
+func (ts *DecayingEphemeralCache) isExpired(v templateElement, now time.Time) bool {
+	if v.expired {
+		return true
 	}
-	return json.Marshal(s)
+	return ts.timeout > 0 && now.After(ts.deadlineOf(v))
 }
 
+// expireTemplates marks all templates whose deadline has passed as expired and releases them, and
+// removes templates that expired more than a timeout ago. A timeout of 0 disables expiry.
 func (ts *DecayingEphemeralCache) expireTemplates() {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	for _, v := range ts.templates {
-		if time.Now().After(v.deadline) {
-			// template has surpassed its deadline, mark it as expired. Subsequent access
-			// to the template via Get() will return an error saying the template expired.
-			// This is done to differentiate between expiry and non-existence
-			v.expired = true
+	if ts.timeout <= 0 {
+		return
+	}
+
+	now := ts.now()
+	for k, v := range ts.templates {
+		if !v.expired {
+			deadline := ts.deadlineOf(v)
+			if !now.After(deadline) {
+				continue
+			}
+			// map values are copies, so the tombstone needs to be written back
+			ts.templates[k] = templateElement{
+				created:  v.created,
+				deadline: deadline,
+				expired:  true,
+			}
+			continue
+		}
+		if now.After(v.deadline.Add(ts.timeout)) {
+			delete(ts.templates, k)
 		}
 	}
 }
@@ -167,7 +292,32 @@ func (ts *DecayingEphemeralCache) Close(context.Context) error {
 	return nil
 }
 
+// Start removes expired templates in the background until ctx is cancelled, such that templates
+// that are not announced anymore do not stay resident. Get, Add, and GetAll only check the
+// deadline of the templates they access, so without Start, expired templates are never released.
 func (ts *DecayingEphemeralCache) Start(ctx context.Context) error {
-	<-ctx.Done()
-	return nil
+	timer := time.NewTimer(ts.expiryInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			ts.expireTemplates()
+			timer.Reset(ts.expiryInterval())
+		}
+	}
+}
+
+// expiryInterval returns the interval in which Start removes expired templates. This is half the
+// timeout, such that templates are removed at most half a timeout after their deadline.
+func (ts *DecayingEphemeralCache) expiryInterval() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if ts.timeout <= 0 {
+		return defaultExpiryInterval
+	}
+	return ts.timeout / 2
 }
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecayingEphemeralCache(t *testing.T) {
+	t.Run("untouched templates are evicted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := NewDefaultDecayingEphemeralCache().(*DecayingEphemeralCache)
+		cache.SetTimeout(50 * time.Millisecond)
+		err := cache.Add(ctx, NewKey(0, 256), newTestTemplate(t, NewIANAFieldManager(cache), 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- cache.Start(ctx)
+		}()
+
+		// size reads the number of templates resident in the cache, including expired ones that were
+		// not yet removed
+		size := func() int {
+			cache.mu.RLock()
+			defer cache.mu.RUnlock()
+			return len(cache.templates)
+		}
+		if size() != 1 {
+			t.Fatal("expected template to be resident before its deadline")
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for size() != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("expected untouched template to be evicted after the timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Error("expected Start to return after cancellation")
+		}
+	})
+
+	t.Run("expired templates are not found", func(t *testing.T) {
+		ctx := context.Background()
+		cache := NewDefaultDecayingEphemeralCache().(*DecayingEphemeralCache)
+		cache.SetTimeout(10 * time.Millisecond)
+		err := cache.Add(ctx, NewKey(0, 256), newTestTemplate(t, NewIANAFieldManager(cache), 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if _, err := cache.Get(ctx, NewKey(0, 256)); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("expected ErrTemplateNotFound for expired template, got %v", err)
+		}
+		if len(cache.GetAll(ctx)) != 0 {
+			t.Error("expected expired template to be removed")
+		}
+	})
+	// fakeClock returns a clock for WithClock and a function advancing it
+	fakeClock := func() (func() time.Time, func(time.Duration)) {
+		now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+		return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+	}
+
+	t.Run("expired templates are distinguishable from unknown templates", func(t *testing.T) {
+		ctx := context.Background()
+		clock, advance := fakeClock()
+		cache := NewDefaultDecayingEphemeralCache(WithTemplateTimeout(time.Minute), WithClock(clock)).(*DecayingEphemeralCache)
+
+		_, err := cache.Get(ctx, NewKey(0, 256))
+		if !errors.Is(err, ErrTemplateNotFound) || errors.Is(err, ErrTemplateExpired) {
+			t.Errorf("expected ErrTemplateNotFound for unknown template, got %v", err)
+		}
+
+		if err := cache.Add(ctx, NewKey(0, 256), newTestTemplate(t, NewIANAFieldManager(cache), 256)); err != nil {
+			t.Fatal(err)
+		}
+		advance(time.Minute + time.Second)
+
+		_, err = cache.Get(ctx, NewKey(0, 256))
+		if !errors.Is(err, ErrTemplateExpired) || !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("expected ErrTemplateExpired wrapping ErrTemplateNotFound, got %v", err)
+		}
+		var expired *UnknownTemplateError
+		if !errors.As(err, &expired) || !expired.Expired || expired.TemplateId() != 256 {
+			t.Errorf("expected UnknownTemplateError for expired template 256, got %v", err)
+		}
+		if len(cache.GetAll(ctx)) != 0 || len(cache.Stats(ctx)) != 0 {
+			t.Error("expected expired template to be omitted from GetAll and Stats")
+		}
+	})
+
+	t.Run("re-announced templates are refreshed", func(t *testing.T) {
+		ctx := context.Background()
+		clock, advance := fakeClock()
+		cache := NewDefaultDecayingEphemeralCache(WithTemplateRefreshInterval(10*time.Second), WithClock(clock)).(*DecayingEphemeralCache)
+		template := newTestTemplate(t, NewIANAFieldManager(cache), 256)
+
+		for i := 0; i < 5; i++ {
+			if err := cache.Add(ctx, NewKey(0, 256), template); err != nil {
+				t.Fatal(err)
+			}
+			// a lost template set does not expire the template
+			advance(25 * time.Second)
+			if _, err := cache.Get(ctx, NewKey(0, 256)); err != nil {
+				t.Fatalf("expected template to be refreshed by announcement %d, got %v", i, err)
+			}
+		}
+
+		advance(10 * time.Second)
+		if _, err := cache.Get(ctx, NewKey(0, 256)); !errors.Is(err, ErrTemplateExpired) {
+			t.Errorf("expected template to expire %d refresh intervals after its last announcement, got %v", TemplateLifetimeMultiplier, err)
+		}
+
+		// announcing an expired template makes it available again
+		if err := cache.Add(ctx, NewKey(0, 256), template); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 256)); err != nil {
+			t.Errorf("expected re-announced template to be available, got %v", err)
+		}
+	})
+
+	t.Run("expired templates are removed in the background", func(t *testing.T) {
+		ctx := context.Background()
+		clock, advance := fakeClock()
+		cache := NewDefaultDecayingEphemeralCache(WithTemplateTimeout(time.Minute), WithClock(clock)).(*DecayingEphemeralCache)
+		if err := cache.Add(ctx, NewKey(0, 256), newTestTemplate(t, NewIANAFieldManager(cache), 256)); err != nil {
+			t.Fatal(err)
+		}
+
+		advance(time.Minute + time.Second)
+		cache.expireTemplates()
+		if te, ok := cache.templates[NewKey(0, 256)]; !ok || !te.expired || te.template != nil {
+			t.Errorf("expected expired template to be released but remembered, got %+v", te)
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 256)); !errors.Is(err, ErrTemplateExpired) {
+			t.Errorf("expected ErrTemplateExpired, got %v", err)
+		}
+
+		advance(time.Minute)
+		cache.expireTemplates()
+		if len(cache.templates) != 0 {
+			t.Errorf("expected expired template to be removed a timeout after expiry, got %d templates", len(cache.templates))
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 256)); !errors.Is(err, ErrTemplateNotFound) || errors.Is(err, ErrTemplateExpired) {
+			t.Errorf("expected ErrTemplateNotFound for removed template, got %v", err)
+		}
+	})
+}
@@ -21,10 +21,21 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
-	"fmt"
+	"io"
+	"sync"
 	"time"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// tracerName is the instrumentation name of the tracer created from the TracerProvider
+// given to Decoder.WithTracerProvider
+const tracerName string = "github.com/zoomoid/go-ipfix"
+
 // Decoder is instantiated with a fieldManager and a templateManager
 // such that it can decode IPFIX packets into Records containing fields
 // and additionally learn new fields and templates.
@@ -36,220 +47,794 @@ type Decoder struct {
 	// templateCache stores and manages templates. It is injected into the decoder at creation
 	templateCache TemplateCache
 
-	completionHook completionHook
+	completionHook func(DecodeStats)
 
 	options DecoderOptions
 
-	metrics *decoderMetrics
+	// collectors are the prometheus metrics the decoder reports to. If nil, the decoder
+	// falls back to the deprecated package-level collectors
+	collectors *Metrics
+	// listener is used as value for the listener label of collectors
+	listener string
+
+	// tracer creates spans for decoding and template cache operations. It defaults to a no-op
+	// tracer, such that decoders without a TracerProvider do not record anything
+	tracer trace.Tracer
+
+	// parallelism is the number of workers decoding data sets of a message concurrently.
+	// Values of 1 or less decode all sets serially.
+	parallelism int
+
+	// sequenceTracker is fed with the sequence numbers of decoded messages, if set
+	sequenceTracker *SequenceTracker
 }
 
 type DecoderOptions struct {
+	// OmitRFC5610Records drops data records defining new information elements as per RFC 5610
+	// from decoded data sets. Dropped records are counted in DecodeStats.DroppedRecords.
 	OmitRFC5610Records bool
+
+	// SkipUnknownTemplates skips data sets whose template is not in the TemplateCache instead of
+	// failing to decode the entire message. Skipped sets are counted in DecodeStats.DroppedSets.
+	SkipUnknownTemplates bool
+
+	// StrictUnknownFields makes decoding fail with ErrUnknownField when a template or a basicList
+	// references a field not known to the FieldCache. By default, such fields are decoded into
+	// opaque octetArray fields named "unknown(pen/id)".
+	StrictUnknownFields bool
+
+	// Limits bound the resources used for decoding structured data types. Data sets exceeding them
+	// are dropped and counted in DecodeStats.DroppedSets. Zero values of the limits are replaced by
+	// DefaultDecodeLimits when merging options.
+	Limits DecodeLimits
+
+	// ConcatenatedMessages accepts buffers containing more bytes than announced by the message
+	// header, as sent by exporters packing multiple messages into a single datagram. Decode then
+	// consumes only the first message from the buffer, such that the remainder can be decoded by
+	// calling Decode again. By default, such buffers fail with a TrailingBytesError.
+	ConcatenatedMessages bool
+
+	// LazyFieldValues makes fields retain the bytes of their values, see Field.RawBytes, and defers
+	// decoding octetArray and string values until they are accessed with Value. Fields whose value
+	// was neither accessed nor set are encoded from the retained bytes, such that proxies forwarding
+	// records byte for byte neither pay for decoding nor for re-encoding large DPI fields. Lazily
+	// decoded fields are not safe for concurrent use, as accessing their values decodes them.
+	LazyFieldValues bool
 }
 
 var (
 	DefaultDecoderOptions = DecoderOptions{
-		OmitRFC5610Records: false,
+		OmitRFC5610Records:   false,
+		SkipUnknownTemplates: false,
+		StrictUnknownFields:  false,
+		Limits:               DefaultDecodeLimits,
 	}
 )
 
 func (o *DecoderOptions) Merge(opts ...DecoderOptions) {
 	for _, opt := range opts {
 		o.OmitRFC5610Records = o.OmitRFC5610Records || opt.OmitRFC5610Records
+		o.SkipUnknownTemplates = o.SkipUnknownTemplates || opt.SkipUnknownTemplates
+		o.StrictUnknownFields = o.StrictUnknownFields || opt.StrictUnknownFields
+		o.ConcatenatedMessages = o.ConcatenatedMessages || opt.ConcatenatedMessages
+		o.LazyFieldValues = o.LazyFieldValues || opt.LazyFieldValues
+		if opt.Limits.MaxNestingDepth != 0 {
+			o.Limits.MaxNestingDepth = opt.Limits.MaxNestingDepth
+		}
+		if opt.Limits.MaxFieldsPerMessage != 0 {
+			o.Limits.MaxFieldsPerMessage = opt.Limits.MaxFieldsPerMessage
+		}
+		if opt.Limits.MaxListElements != 0 {
+			o.Limits.MaxListElements = opt.Limits.MaxListElements
+		}
 	}
 }
 
-type completionHook func(*decoderMetrics)
-
-type decoderMetrics struct {
-	TotalLength    int64 `json:"total_length,omitempty"`
-	DecodedSets    int64 `json:"decoded_messages,omitempty"`
+// DecodeStats are the statistics of decoding a single message
+type DecodeStats struct {
+	// TotalLength is the number of bytes of the message including headers
+	TotalLength int64 `json:"total_length,omitempty"`
+	// DecodedSets is the number of sets decoded from the message
+	DecodedSets int64 `json:"decoded_sets,omitempty"`
+	// DecodedRecords is the number of records of all kinds decoded from the message
 	DecodedRecords int64 `json:"decoded_records,omitempty"`
+	// DroppedSets is the number of data sets skipped because their template is unknown, or
+	// dropped because they exceed the decoder's DecodeLimits
+	DroppedSets int64 `json:"dropped_sets,omitempty"`
+	// DroppedRecords is the number of records dropped from decoded sets, e.g., RFC 5610 records
+	// with DecoderOptions.OmitRFC5610Records. As the number of records of skipped sets cannot be
+	// determined without template, each set skipped due to an unknown template counts as a
+	// single dropped record. So does each set dropped for exceeding DecodeLimits.
 	DroppedRecords int64 `json:"dropped_records,omitempty"`
+	// Duration is the time spent decoding the message
+	Duration time.Duration `json:"duration,omitempty"`
 }
 
-// NewDecoder creates a new Decoder for a given template cache and field manager
+// NewDecoder creates a new Decoder for a given template cache and field manager. NewDecoder is
+// kept for compatibility, NewDecoderWithOptions is preferred for configuring decoders.
 func NewDecoder(templates TemplateCache, fields FieldCache, opts ...DecoderOptions) *Decoder {
 	options := DefaultDecoderOptions
 	options.Merge(opts...)
 
+	return NewDecoderWithOptions(templates, fields, withDecoderOptions(options))
+}
+
+// DecoderOption configures a Decoder created with NewDecoderWithOptions
+type DecoderOption func(*Decoder)
+
+// withDecoderOptions merges the legacy options struct into the decoder's options
+func withDecoderOptions(options DecoderOptions) DecoderOption {
+	return func(d *Decoder) {
+		d.options.Merge(options)
+	}
+}
+
+// WithOmitRFC5610 drops data records defining new information elements as per RFC 5610
+// from decoded data sets, see DecoderOptions.OmitRFC5610Records.
+func WithOmitRFC5610() DecoderOption {
+	return func(d *Decoder) {
+		d.options.OmitRFC5610Records = true
+	}
+}
+
+// WithSkipUnknownTemplates skips data sets whose template is not in the TemplateCache instead
+// of failing to decode the entire message, see DecoderOptions.SkipUnknownTemplates.
+func WithSkipUnknownTemplates() DecoderOption {
+	return func(d *Decoder) {
+		d.options.SkipUnknownTemplates = true
+	}
+}
+
+// WithStrictUnknownFields makes decoding fail with ErrUnknownField for fields not known to the
+// FieldCache, see DecoderOptions.StrictUnknownFields.
+func WithStrictUnknownFields() DecoderOption {
+	return func(d *Decoder) {
+		d.options.StrictUnknownFields = true
+	}
+}
+
+// WithConcatenatedMessages accepts buffers containing multiple messages, which are decoded by
+// successive calls to Decode, see DecoderOptions.ConcatenatedMessages.
+func WithConcatenatedMessages() DecoderOption {
+	return func(d *Decoder) {
+		d.options.ConcatenatedMessages = true
+	}
+}
+
+// WithLazyFieldValues makes fields retain the bytes of their values and defer decoding until the
+// values are accessed, see DecoderOptions.LazyFieldValues.
+func WithLazyFieldValues() DecoderOption {
+	return func(d *Decoder) {
+		d.options.LazyFieldValues = true
+	}
+}
+
+// WithMaxNestingDepth limits the depth of nested structured data types, see
+// DecodeLimits.MaxNestingDepth. A depth of 0 or less disables the limit.
+func WithMaxNestingDepth(depth int) DecoderOption {
+	return func(d *Decoder) {
+		d.options.Limits.MaxNestingDepth = depth
+	}
+}
+
+// WithMaxFieldsPerMessage limits the number of fields decoded from a message, see
+// DecodeLimits.MaxFieldsPerMessage.
+func WithMaxFieldsPerMessage(n int) DecoderOption {
+	return func(d *Decoder) {
+		d.options.Limits.MaxFieldsPerMessage = n
+	}
+}
+
+// WithMaxListElements limits the number of elements of a single list, see
+// DecodeLimits.MaxListElements.
+func WithMaxListElements(n int) DecoderOption {
+	return func(d *Decoder) {
+		d.options.Limits.MaxListElements = n
+	}
+}
+
+// WithCompletionHook sets a function called with the statistics of each decoded message,
+// regardless of whether decoding succeeded.
+func WithCompletionHook(hook func(DecodeStats)) DecoderOption {
+	return func(d *Decoder) {
+		d.completionHook = hook
+	}
+}
+
+// WithDecoderMetrics makes the decoder report to the given metrics instead of the deprecated
+// package-level collectors, see Decoder.WithMetrics.
+func WithDecoderMetrics(m *Metrics, listener string) DecoderOption {
+	return func(d *Decoder) {
+		d.WithMetrics(m, listener)
+	}
+}
+
+// WithTracerProvider makes the decoder create OpenTelemetry spans, see Decoder.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) DecoderOption {
+	return func(d *Decoder) {
+		d.WithTracerProvider(tp)
+	}
+}
+
+// WithParallelism makes the decoder decode the data sets of a message concurrently on a pool
+// of at most n workers, see Decoder.WithParallelism.
+func WithParallelism(n int) DecoderOption {
+	return func(d *Decoder) {
+		d.WithParallelism(n)
+	}
+}
+
+// WithSequenceTracking makes the decoder feed the sequence number of each successfully decoded
+// message to t. As the decoder does not know the exporter of a message, all messages are tracked
+// for the same exporter. For collectors receiving messages from multiple exporters, use a decoder
+// per exporter, or call SequenceTracker.Observe with the exporter's address instead.
+//
+// Unlike SequenceTracker.Observe, the decoder also counts records dropped by
+// DecoderOptions.OmitRFC5610Records, and resynchronizes the tracker after messages with skipped
+// data sets, whose number of records is unknown.
+func WithSequenceTracking(t *SequenceTracker) DecoderOption {
+	return func(d *Decoder) {
+		d.sequenceTracker = t
+	}
+}
+
+// NewDecoderWithOptions creates a new Decoder for a given template cache and field cache,
+// configured by opts. Without options, the decoder behaves like one created with NewDecoder
+// and DefaultDecoderOptions.
+func NewDecoderWithOptions(templates TemplateCache, fields FieldCache, opts ...DecoderOption) *Decoder {
 	d := &Decoder{
-		fieldCache:    fields,
 		templateCache: templates,
-		options:       options,
-		metrics:       &decoderMetrics{},
+		options:       DefaultDecoderOptions,
+		tracer:        noop.NewTracerProvider().Tracer(tracerName),
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	d.fieldCache = newUnknownFieldCache(fields, d.options.StrictUnknownFields)
 
 	d.initMetrics()
 
 	return d
 }
 
-func (d *Decoder) WithCompletionHook(hook func(*decoderMetrics)) *Decoder {
+// WithMetrics makes the decoder report to the given metrics instead of the deprecated
+// package-level collectors. listener is used as the label value to distinguish decoders
+// of different listeners, e.g., the listener's bind address.
+func (d *Decoder) WithMetrics(m *Metrics, listener string) *Decoder {
+	d.collectors = m
+	d.listener = listener
+	return d
+}
+
+// WithTracerProvider makes the decoder create OpenTelemetry spans for each decoded message and
+// child spans for template cache operations during decoding. Without a TracerProvider,
+// decoding is not traced.
+func (d *Decoder) WithTracerProvider(tp trace.TracerProvider) *Decoder {
+	if tp != nil {
+		d.tracer = tp.Tracer(tracerName)
+	}
+	return d
+}
+
+// WithParallelism makes the decoder decode the data sets of a message concurrently on a pool of
+// at most n workers. Template and options template sets are still decoded serially and in
+// order, and data sets are decoded with the template that was current at their position in the
+// message, such that the decoded message is identical to the one decoded serially. For the same
+// reason, data sets carrying RFC 5610 type information are decoded serially as well, as template
+// sets following them may use the information elements they define.
+//
+// If decoding a data set fails, template sets following it in the message may already have
+// been added to the template cache, unlike in serial decoding. Parallel decoding pays off for
+// messages with many large data sets, e.g., from exporters batching records up to the maximum
+// message length. A value of 1 or less disables parallel decoding, which is the default.
+func (d *Decoder) WithParallelism(n int) *Decoder {
+	d.parallelism = n
+	return d
+}
+
+// WithCompletionHook sets a function called with the statistics of each decoded message,
+// regardless of whether decoding succeeded.
+func (d *Decoder) WithCompletionHook(hook func(DecodeStats)) *Decoder {
 	d.completionHook = hook
 	return d
 }
 
-// Decode takes payload as a buffer and consumes it to construct an IPFIX packet
-// containing records containing decoded fields.
-func (d *Decoder) Decode(ctx context.Context, payload *bytes.Buffer) (msg *Message, err error) {
+// Decode takes payload and consumes it to construct an IPFIX packet containing records containing
+// decoded fields. If payload is a *bytes.Buffer, it is expected to contain a single message and is
+// consumed entirely. Any other reader is treated as a stream of messages, from which exactly one
+// message is read with ReadMessage, e.g., for decoding directly from a TCP connection or a file.
+func (d *Decoder) Decode(ctx context.Context, payload io.Reader) (*Message, error) {
+	msg, _, err := d.DecodeWithStats(ctx, payload)
+	return msg, err
+}
+
+// DecodeWithStats decodes a message like Decode and additionally returns the statistics of
+// decoding the message. Statistics are returned also if decoding fails.
+func (d *Decoder) DecodeWithStats(ctx context.Context, payload io.Reader) (msg *Message, stats DecodeStats, err error) {
 	decoderStart := time.Now()
 
+	ctx, span := d.tracer.Start(ctx, "ipfix.Decoder.Decode")
+	defer func() {
+		if msg != nil {
+			span.SetAttributes(
+				attribute.Int("ipfix.message.sets", len(msg.Sets)),
+				attribute.Int64("ipfix.observation_domain_id", int64(msg.ObservationDomainId)),
+			)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	// observationDomainId is only known after decoding the message header, the deferred
+	// function below therefore must not use msg directly
+	var observationDomainId uint32
+
 	// update metrics at the end of decoding depending on the outcome
 	defer func() {
-		DurationMicroseconds.Observe(float64(time.Since(decoderStart).Nanoseconds()) / 1000) // use nanoseconds for higher precision and then convert it back to microseconds
-		PacketsTotal.Inc()
+		d.collectors.durationMicroseconds(d.listener, observationDomainId).Observe(float64(time.Since(decoderStart).Nanoseconds()) / 1000) // use nanoseconds for higher precision and then convert it back to microseconds
+		d.collectors.packetsTotal(d.listener, observationDomainId).Inc()
 		if err != nil {
-			ErrorsTotal.Inc()
+			d.collectors.errorsTotal(d.listener, observationDomainId).Inc()
 		}
 	}()
 
 	defer func() {
+		stats.Duration = time.Since(decoderStart)
 		if d.completionHook != nil {
-			d.completionHook(d.metrics)
+			d.completionHook(stats)
 		}
-		d.resetMetrics()
 	}()
 
 	if d.templateCache == nil {
-		return nil, errors.New("used decoder before template cache was initialized")
+		return nil, stats, errors.New("used decoder before template cache was initialized")
 	}
 
-	n, err := msg.Decode(payload)
+	buf, ok := payload.(*bytes.Buffer)
+	if !ok {
+		buf, err = ReadMessage(payload)
+		if err != nil {
+			return nil, stats, &DecodeError{Stage: DecodeStageMessageHeader, SetIndex: -1, Err: err}
+		}
+	}
+	span.SetAttributes(attribute.Int("ipfix.message.length", buf.Len()))
+
+	msg = &Message{}
+	n, err := msg.Decode(buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read IPFIX packet header, %w", err)
+		return nil, stats, &DecodeError{Stage: DecodeStageMessageHeader, SetIndex: -1, Err: err}
+	}
+	observationDomainId = msg.ObservationDomainId
+	stats.TotalLength += int64(n) // IPFIX header length
+
+	// decode exactly the bytes announced by the message header, the remainder of the buffer is
+	// either another message or garbage
+	available := n + buf.Len()
+	if int(msg.Length) > available {
+		return nil, stats, &DecodeError{Stage: DecodeStageMessageHeader, SetIndex: -1, Err: &TruncatedMessageError{
+			Length:    int(msg.Length),
+			Available: available,
+		}}
+	}
+	sets := buf
+	if trailing := available - int(msg.Length); trailing > 0 {
+		sets = bytes.NewBuffer(buf.Next(int(msg.Length) - n))
+		if !d.options.ConcatenatedMessages {
+			defer func() {
+				if err == nil {
+					err = &DecodeError{Stage: DecodeStageMessageHeader, SetIndex: -1, Err: &TrailingBytesError{
+						Length:   int(msg.Length),
+						Trailing: trailing,
+					}}
+				}
+			}()
+		}
+	}
+
+	state := newDecodeState(d.options.Limits)
+	state.lazyValues = d.options.LazyFieldValues
+	results := d.decodeSets(ctx, msg, sets, n, state)
+	if d.parallelism > 1 {
+		d.decodeDataSetsParallel(results, state)
+	}
+
+	// dataRecords counts the data records of the message for sequence tracking, which is exact
+	// unless data sets were skipped
+	dataRecords, exact := 0, true
+	defer func() {
+		if d.sequenceTracker != nil && err == nil {
+			d.sequenceTracker.observe("", msg.ObservationDomainId, msg.SequenceNumber, uint32(dataRecords), exact)
+		}
+	}()
+
+	// assemble the message and statistics in the order of the sets, up to the first error
+	for _, r := range results {
+		stats.TotalLength += int64(r.length)
+		if r.err != nil {
+			if r.dropped > 0 {
+				stats.DroppedRecords += int64(r.dropped)
+				d.collectors.droppedRecords(d.listener, observationDomainId, r.set.Kind).Add(float64(r.dropped))
+			}
+			if r.discardMessage {
+				return nil, stats, r.err
+			}
+			return msg, stats, r.err
+		}
+		if r.skipped {
+			exact = false
+			stats.DroppedSets++
+			stats.DroppedRecords++
+			d.collectors.droppedRecords(d.listener, observationDomainId, KindDataSet).Inc()
+			continue
+		}
+
+		stats.DecodedRecords += int64(r.set.Set.Length())
+		stats.DroppedRecords += int64(r.dropped)
+		stats.DecodedSets++
+		if r.set.Kind == KindDataSet {
+			dataRecords += r.set.Set.Length() + r.dropped
+			if ds, ok := r.set.Set.(*DataSet); ok {
+				d.observeUnknownFields(ds.Records)
+			}
+		}
+
+		d.collectors.decodedSets(d.listener, observationDomainId, r.set.Kind).Inc()
+		d.collectors.decodedRecords(d.listener, observationDomainId, r.set.Kind).Add(float64(r.set.Set.Length()))
+		d.collectors.droppedRecords(d.listener, observationDomainId, r.set.Kind).Add(float64(r.dropped))
+
+		msg.Sets = append(msg.Sets, r.set)
 	}
-	d.metrics.TotalLength += int64(n) // IPFIX header length
 
-	for i := 1; payload.Len() > 0; i++ {
+	return
+}
+
+// setResult is the outcome of decoding a single set of a message
+type setResult struct {
+	set Set
+
+	// length is the number of bytes of the message consumed by the set, including its header
+	length int
+	// dropped is the number of records dropped from the set
+	dropped int
+	// skipped is set for data sets skipped due to an unknown template or exceeding DecodeLimits
+	skipped bool
+
+	err error
+	// discardMessage is set for errors in set headers, after which the message is not returned
+	discardMessage bool
+
+	// job is set for data sets that are yet to be decoded in parallel mode
+	job *dataSetJob
+}
+
+// dataSetJob contains everything required for decoding a data set independently of the
+// sets preceding it in the message
+type dataSetJob struct {
+	template *Template
+	// contents are the bytes of the set without its header
+	contents []byte
+
+	key      TemplateKey
+	setIndex int
+	offset   int
+}
+
+// decodeSets decodes the sets of a message in order until the first error. Template and options
+// template sets are added to the template cache as soon as all of their records were decoded,
+// such that subsequent data sets can use them. In parallel mode, data sets not defining IEs are only prepared for
+// decodeDataSetsParallel, otherwise they are decoded as well. position is the offset of the first set from the start of the message,
+// state is the decodeState of the message shared by all data sets.
+func (d *Decoder) decodeSets(ctx context.Context, msg *Message, payload *bytes.Buffer, position int, state *decodeState) []setResult {
+	results := make([]setResult, 0)
+
+	// tr is reused for all sets decoded serially
+	tr := &setReader{state: state}
+
+	for i := 0; payload.Len() > 0; i++ {
 		// set decoding loop
 		h := SetHeader{}
-		_, err := h.Decode(payload)
+		n, err := h.Decode(payload)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read SetHeader, %w", err)
+			// SetHeader.Decode rejects lengths shorter than the header with ErrMalformedSet
+			return append(results, setResult{
+				length:         n,
+				err:            &DecodeError{Stage: DecodeStageSetHeader, SetIndex: i, Offset: position, Err: err},
+				discardMessage: true,
+			})
 		}
-		d.metrics.TotalLength += 4
 		// offset is the number of bytes in the record's payload without the
 		// 4 header (2x2 bytes, templateId and set length) bytes included
 		// by the protocol in the length field; binary.Size(h) captures exactly
 		// that inclusion
 		offset := int(h.Length) - binary.Size(h)
-		if offset < 0 {
-			return nil, errors.New("malformed IPFIX packet")
+		if offset > payload.Len() {
+			return append(results, setResult{
+				length: binary.Size(h),
+				err: &DecodeError{Stage: DecodeStageSetHeader, SetIndex: i, Offset: position, Err: &TruncatedSetError{
+					Id:        h.Id,
+					Length:    int(h.Length),
+					Available: payload.Len(),
+				}},
+				discardMessage: true,
+			})
 		}
-		d.metrics.TotalLength += int64(offset)
+		setPosition := position
+		position += int(h.Length)
 
-		var set Set
+		result := setResult{
+			length: int(h.Length),
+		}
 
-		// create a fresh buffer with only the bytes of the set contents
-		// TODO(zoomoid): this does some copying, and we currently cannot ensure that
-		// the safety constraints of the slices are kept
-		tr := bytes.NewBuffer(payload.Next(offset))
+		// the set is decoded directly from the bytes of the set contents in the payload. The
+		// reader is bounded to the set, such that decoding cannot overflow into the next set
+		contents := payload.Next(offset)
 
-		if h.Id == IPFIX {
-			// IPFIX template set
-			ts := TemplateSet{
-				fieldCache:    d.fieldCache,
-				templateCache: d.templateCache,
-			}
-			_, err = ts.Decode(tr)
+		if h.Id == IPFIX || h.Id == IPFIXOptions {
+			tr.reset(contents)
+			set, templates, err := d.decodeTemplateSet(h, tr, msg.ObservationDomainId)
+			result.set = set
 			if err != nil {
-				return msg, fmt.Errorf("failed to decode template set at index %d, %w", i, err)
-			}
-			d.metrics.DecodedRecords += int64(len(ts.Records))
-
-			set = Set{
-				SetHeader: h,
-				Kind:      KindTemplateSet,
-				Set:       &ts,
+				stage := DecodeStageTemplateSet
+				if h.Id == IPFIXOptions {
+					stage = DecodeStageOptionsTemplateSet
+				}
+				// none of the records of the set are added to the cache, including the ones
+				// decoded before the failing record
+				result.dropped = set.Set.Length() + 1
+				result.err = &DecodeError{Stage: stage, SetIndex: i, Offset: setPosition, Err: err}
+				return append(results, result)
 			}
 
-			for _, record := range ts.Records {
-				r := record // TODO(zoomoid): waiting on https://go.dev/blog/loopvar-preview
-				d.templateCache.Add(ctx, TemplateKey{
-					ObservationDomainId: msg.ObservationDomainId,
-					TemplateId:          record.TemplateId,
-				}, &Template{
-					TemplateMetadata: &TemplateMetadata{
-						TemplateId:          h.Id,
-						ObservationDomainId: msg.ObservationDomainId,
-						CreationTimestamp:   time.Now(),
-					},
-					Record: &r,
-				})
+			// templates are only added once the entire set was decoded, such that a truncated
+			// record does not leave the set's other templates in the cache
+			for _, template := range templates {
+				d.addTemplate(ctx, NewKey(msg.ObservationDomainId, template.Record.Id()), template)
 			}
-		} else if h.Id == IPFIXOptions {
-			ots := &OptionsTemplateSet{
-				templateCache: d.templateCache,
-				fieldCache:    d.fieldCache,
+		} else if h.Id >= 256 {
+			// Ids lower than 256 are reserved and not to be used for template definition
+			key := TemplateKey{
+				ObservationDomainId: msg.ObservationDomainId,
+				TemplateId:          h.Id,
 			}
-
-			// ipfix options template set
-			_, err := ots.Decode(tr)
+			// templates are looked up in order of the sets, such that data sets are decoded
+			// with the template that was current at their position in the message
+			template, err := d.getTemplate(ctx, key)
 			if err != nil {
-				return msg, fmt.Errorf("failed to decode options template set %d, %w", i, err)
+				if d.options.SkipUnknownTemplates && errors.Is(err, ErrTemplateNotFound) {
+					result.skipped = true
+					results = append(results, result)
+					continue
+				}
+				result.err = &DecodeError{Stage: DecodeStageDataSet, SetIndex: i, TemplateKey: key, Offset: setPosition, Err: err}
+				return append(results, result)
 			}
-			d.metrics.DecodedRecords += int64(len(ots.Records))
+			template.MarkUsed(time.Now())
 
-			set = Set{
+			result.set = Set{
 				SetHeader: h,
-				Kind:      KindOptionsTemplateSet,
-				Set:       ots,
+				Kind:      KindDataSet,
 			}
-
-			for _, record := range ots.Records {
-				r := record // TODO(zoomoid): waiting on https://go.dev/blog/loopvar-preview
-				d.templateCache.Add(ctx, TemplateKey{
-					ObservationDomainId: msg.ObservationDomainId,
-					TemplateId:          record.TemplateId,
-				}, &Template{
-					TemplateMetadata: &TemplateMetadata{
-						TemplateId:          h.Id,
-						ObservationDomainId: msg.ObservationDomainId,
-						CreationTimestamp:   time.Now(),
-					},
-					Record: &r,
-				})
+			job := &dataSetJob{
+				template: template,
+				contents: contents,
+				key:      key,
+				setIndex: i,
+				offset:   setPosition,
 			}
-		} else if h.Id >= 256 {
-			// Ids lower than 256 are reserved and not to be used for template definition
-			ds := &DataSet{
-				fieldCache:    d.fieldCache,
-				templateCache: d.templateCache,
+			// data sets defining IEs are decoded in order, such that template sets following them
+			// in the message are decoded with the IEs, as in serial decoding
+			if d.parallelism > 1 && !templateDefinesInformationElements(template) {
+				result.job = job
+			} else {
+				tr.reset(contents)
+				d.decodeDataSet(&result, job, tr)
+				if result.err != nil {
+					return append(results, result)
+				}
 			}
+		} else {
+			result.err = &DecodeError{Stage: DecodeStageSetHeader, SetIndex: i, Offset: setPosition, Err: ErrUnknownSetId}
+			return append(results, result)
+		}
 
-			template, err := d.templateCache.Get(context.TODO(), TemplateKey{
-				ObservationDomainId: msg.ObservationDomainId,
-				TemplateId:          h.Id,
-			})
-			if err != nil {
-				return msg, err
-			}
+		results = append(results, result)
+	}
 
-			_, err = ds.With(template).Decode(tr)
-			if err != nil {
-				return msg, err
+	return results
+}
+
+// decodeTemplateSet decodes the template or options template set of header h from r, and
+// returns the set and the templates defined by it. If decoding a record fails, the returned set
+// contains the records preceding the failing one, and no templates are returned.
+func (d *Decoder) decodeTemplateSet(h SetHeader, r io.Reader, observationDomainId uint32) (Set, []*Template, error) {
+	newTemplate := func(record templateRecord) *Template {
+		return &Template{
+			TemplateMetadata: &TemplateMetadata{
+				TemplateId:          record.Id(),
+				ObservationDomainId: observationDomainId,
+				CreationTimestamp:   time.Now(),
+			},
+			Record: record,
+		}
+	}
+
+	fieldCache := d.fieldCacheFor(observationDomainId)
+	if h.Id == IPFIXOptions {
+		ots := &OptionsTemplateSet{
+			fieldCache:    fieldCache,
+			templateCache: d.templateCache,
+		}
+		set := Set{SetHeader: h, Kind: KindOptionsTemplateSet, Set: ots}
+		if _, err := ots.Decode(r); err != nil {
+			return set, nil, err
+		}
+		templates := make([]*Template, 0, len(ots.Records))
+		for i := range ots.Records {
+			templates = append(templates, newTemplate(&ots.Records[i]))
+		}
+		return set, templates, nil
+	}
+
+	ts := &TemplateSet{
+		fieldCache:    fieldCache,
+		templateCache: d.templateCache,
+	}
+	set := Set{SetHeader: h, Kind: KindTemplateSet, Set: ts}
+	if _, err := ts.Decode(r); err != nil {
+		return set, nil, err
+	}
+	templates := make([]*Template, 0, len(ts.Records))
+	for i := range ts.Records {
+		templates = append(templates, newTemplate(&ts.Records[i]))
+	}
+	return set, templates, nil
+}
+
+// decodeDataSet decodes the data set of job from r into result
+func (d *Decoder) decodeDataSet(result *setResult, job *dataSetJob, r *setReader) {
+	ds := &DataSet{
+		fieldCache:    d.fieldCacheFor(job.key.ObservationDomainId),
+		templateCache: d.templateCache,
+	}
+	_, err := ds.With(job.template).Decode(r)
+	if errors.Is(err, ErrDecodeLimitExceeded) {
+		// the set is dropped like sets of unknown templates, such that the other sets of the
+		// message are still decoded
+		result.skipped = true
+		return
+	}
+	if err != nil {
+		result.err = &DecodeError{Stage: DecodeStageDataSet, SetIndex: job.setIndex, TemplateKey: job.key, Offset: job.offset, Err: err}
+		return
+	}
+
+	if d.options.OmitRFC5610Records {
+		records := ds.Records[:0]
+		for _, record := range ds.Records {
+			if definesInformationElement(record) {
+				result.dropped++
+				continue
 			}
+			records = append(records, record)
+		}
+		ds.Records = records
+	}
+	result.set.Set = ds
+}
 
-			set = Set{
-				SetHeader: h,
-				Kind:      KindDataSet,
-				Set:       ds,
+// fieldCacheFor returns the field cache for decoding sets of an observation domain, i.e., the view
+// of the observation domain if the decoder's field cache keeps fields per observation domain
+func (d *Decoder) fieldCacheFor(observationDomainId uint32) FieldCache {
+	c, ok := d.fieldCache.(*unknownFieldCache)
+	if !ok {
+		return d.fieldCache
+	}
+	scoper, ok := c.FieldCache.(observationDomainScoper)
+	if !ok {
+		return d.fieldCache
+	}
+	return newUnknownFieldCache(scoper.ForObservationDomain(observationDomainId), c.strict)
+}
+
+// decodeDataSetsParallel decodes the data sets prepared by decodeSets on a pool of at most
+// d.parallelism workers. Results are written in place, such that their order is preserved.
+func (d *Decoder) decodeDataSetsParallel(results []setResult, state *decodeState) {
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < d.parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr := &setReader{state: state}
+			for i := range jobs {
+				tr.reset(results[i].job.contents)
+				d.decodeDataSet(&results[i], results[i].job, tr)
 			}
-		} else {
-			return msg, ErrUnknownFlowId
+		}()
+	}
+	for i := range results {
+		if results[i].job != nil {
+			jobs <- i
 		}
+	}
+	close(jobs)
+	wg.Wait()
+}
 
-		d.metrics.DecodedSets++
+// getTemplate retrieves a template from the template cache in a child span of ctx
+func (d *Decoder) getTemplate(ctx context.Context, key TemplateKey) (*Template, error) {
+	ctx, span := d.tracer.Start(ctx, "ipfix.TemplateCache.Get", trace.WithAttributes(templateKeyAttributes(key)...))
+	defer span.End()
+	template, err := d.templateCache.Get(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	observeTemplateLookup(d.collectors, d.listener, key, err)
+	return template, err
+}
 
-		DecodedSets.WithLabelValues(set.Kind).Inc()
-		DecodedRecords.WithLabelValues(set.Kind).Add(float64(d.metrics.DecodedRecords))
-		DroppedRecords.WithLabelValues(set.Kind).Add(float64(d.metrics.DroppedRecords))
+// addTemplate adds a template to the template cache in a child span of ctx
+func (d *Decoder) addTemplate(ctx context.Context, key TemplateKey, template *Template) error {
+	ctx, span := d.tracer.Start(ctx, "ipfix.TemplateCache.Add", trace.WithAttributes(templateKeyAttributes(key)...))
+	defer span.End()
+	err := d.templateCache.Add(ctx, key, template)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
 
-		msg.Sets = append(msg.Sets, set)
+func templateKeyAttributes(key TemplateKey) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("ipfix.observation_domain_id", int64(key.ObservationDomainId)),
+		attribute.Int("ipfix.template_id", int(key.TemplateId)),
 	}
+}
 
-	return
+// observeTemplateLookup counts the outcome of a template lookup for a data set as hit or miss.
+// Errors other than ErrTemplateNotFound, e.g., from remote caches, are neither.
+func observeTemplateLookup(m *Metrics, listener string, key TemplateKey, err error) {
+	switch {
+	case err == nil:
+		m.templateCacheHits(listener, key.ObservationDomainId, KindDataSet).Inc()
+	case errors.Is(err, ErrTemplateNotFound):
+		m.templateCacheMisses(listener, key.ObservationDomainId, KindDataSet).Inc()
+	}
+}
+
+// observeUnknownFields counts the occurrences of fields not known to the decoder's FieldCache in
+// records, including the elements and records of lists
+func (d *Decoder) observeUnknownFields(records []DataRecord) {
+	for _, dr := range records {
+		d.observeUnknownFieldsOf(dr.Fields)
+	}
+}
+
+func (d *Decoder) observeUnknownFieldsOf(fields []Field) {
+	for _, f := range fields {
+		if isUnknownField(f) {
+			d.collectors.unknownFields(d.listener, NewFieldKey(f.PEN(), f.Id())).Inc()
+			continue
+		}
+		// only lists contain further fields, checking the semantics first avoids decoding lazy values
+		if ie := f.Prototype(); ie == nil || ie.Semantics != semantics.List {
+			continue
+		}
+		switch l := f.Value().(type) {
+		case *BasicList:
+			d.observeUnknownFieldsOf(l.Elements())
+		case *SubTemplateList:
+			d.observeUnknownFields(l.Elements())
+		case *SubTemplateMultiList:
+			for _, content := range l.Elements() {
+				d.observeUnknownFields(content.Values)
+			}
+		}
+	}
 }
 
 func (d *Decoder) initMetrics() {
@@ -262,13 +847,44 @@ func (d *Decoder) initMetrics() {
 		DecodedRecords.WithLabelValues(kind).Add(0)
 		DroppedRecords.WithLabelValues(kind).Add(0)
 	}
+	TemplateCacheHits.WithLabelValues(KindDataSet).Add(0)
+	TemplateCacheMisses.WithLabelValues(KindDataSet).Add(0)
+}
+
+// setReader reads the contents of a single set from a subslice of the message without copying
+// them. Unlike bytes.Reader and io.LimitedReader, it returns io.EOF only for non-empty reads, like
+// bytes.Buffer, such that zero-length variable-length fields at the end of a set can be decoded.
+//
+// Decoded records never alias the bytes read from a setReader, as data types copy the bytes
+// they retain.
+type setReader struct {
+	b []byte
+
+	// state is the decodeState of the message the set belongs to
+	state *decodeState
+}
+
+func (r *setReader) decodeState() (*decodeState, int) {
+	return r.state, 0
+}
+
+func (r *setReader) reset(b []byte) {
+	r.b = b
 }
 
-func (d *Decoder) resetMetrics() {
-	d.metrics = &decoderMetrics{
-		TotalLength:    0,
-		DecodedSets:    0,
-		DecodedRecords: 0,
-		DroppedRecords: 0,
+// Len returns the number of unread bytes of the set
+func (r *setReader) Len() int {
+	return len(r.b)
+}
+
+func (r *setReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(r.b) == 0 {
+		return 0, io.EOF
 	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
 }
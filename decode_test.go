@@ -0,0 +1,1257 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// spanRecorder is a trace.TracerProvider recording the spans of its tracers, such that tests do
+// not depend on the OpenTelemetry SDK
+type spanRecorder struct {
+	noop.TracerProvider
+
+	mu    sync.Mutex
+	ids   uint64
+	ended []*recordedSpan
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{recorder: r}
+}
+
+// Ended returns the ended spans in the order they were ended
+func (r *spanRecorder) Ended() []*recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*recordedSpan{}, r.ended...)
+}
+
+type recordingTracer struct {
+	noop.Tracer
+	recorder *spanRecorder
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	t.recorder.mu.Lock()
+	t.recorder.ids++
+	id := t.recorder.ids
+	t.recorder.mu.Unlock()
+
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordedSpan{
+		recorder:   t.recorder,
+		name:       name,
+		parent:     trace.SpanContextFromContext(ctx),
+		attributes: config.Attributes(),
+	}
+	sc := trace.SpanContextConfig{TraceID: trace.TraceID{1}, TraceFlags: trace.FlagsSampled}
+	binary.BigEndian.PutUint64(sc.SpanID[:], id)
+	span.spanContext = trace.NewSpanContext(sc)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	noop.Span
+	recorder *spanRecorder
+
+	name        string
+	spanContext trace.SpanContext
+	parent      trace.SpanContext
+	attributes  []attribute.KeyValue
+	status      codes.Code
+	errors      []error
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.spanContext }
+func (s *recordedSpan) IsRecording() bool              { return true }
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.attributes = append(s.attributes, kv...)
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordedSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.errors = append(s.errors, err)
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.ended = append(s.recorder.ended, s)
+}
+
+func TestDecoderTracing(t *testing.T) {
+	ctx := context.Background()
+
+	newTracedDecoder := func(t *testing.T) (*Decoder, *spanRecorder) {
+		tp := &spanRecorder{}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewDecoder(templateCache, fieldCache).WithTracerProvider(tp), tp
+	}
+
+	attributes := func(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value, len(attrs))
+		for _, kv := range attrs {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	t.Run("decode span with template cache child span", func(t *testing.T) {
+		decoder, recorder := newTracedDecoder(t)
+		payload := newTestDataMessage(256, 2)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spans := recorder.Ended()
+		if len(spans) != 2 {
+			t.Fatalf("expected 2 spans, got %d", len(spans))
+		}
+		get, decode := spans[0], spans[1]
+		if decode.name != "ipfix.Decoder.Decode" || get.name != "ipfix.TemplateCache.Get" {
+			t.Fatalf("unexpected spans %s and %s", decode.name, get.name)
+		}
+		if get.parent.SpanID() != decode.SpanContext().SpanID() {
+			t.Error("expected template cache span to be a child of the decode span")
+		}
+		attrs := attributes(decode.attributes)
+		if v := attrs["ipfix.message.length"].AsInt64(); v != int64(len(payload)) {
+			t.Errorf("expected message length %d, got %d", len(payload), v)
+		}
+		if v := attrs["ipfix.message.sets"].AsInt64(); v != 1 {
+			t.Errorf("expected 1 set, got %d", v)
+		}
+		if _, ok := attrs["ipfix.observation_domain_id"]; !ok {
+			t.Error("expected observation domain attribute")
+		}
+	})
+
+	t.Run("errors are recorded", func(t *testing.T) {
+		decoder, recorder := newTracedDecoder(t)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(300, 1)))
+		if err == nil {
+			t.Fatal("expected decoding with unknown template to fail")
+		}
+		for _, span := range recorder.Ended() {
+			if span.status != codes.Error {
+				t.Errorf("expected span %s to have error status", span.name)
+			}
+			if len(span.errors) == 0 {
+				t.Errorf("expected span %s to record the error", span.name)
+			}
+		}
+	})
+
+	t.Run("no spans without tracer provider", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		decoder := NewDecoder(templateCache, fieldCache)
+		_, span := decoder.tracer.Start(ctx, "test")
+		if span.IsRecording() || span.SpanContext().IsValid() {
+			t.Error("expected default tracer to be a no-op")
+		}
+	})
+}
+
+func TestDecodeWithStats(t *testing.T) {
+	ctx := context.Background()
+
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+		t.Fatal(err)
+	}
+
+	// template 257 describes information elements as per RFC 5610
+	fields := make([]Field, 0, 2)
+	for _, spec := range []struct{ id, length uint16 }{{303, 2}, {341, 0xFFFF}} {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+	err := templateCache.Add(ctx, NewKey(0, 257), &Template{
+		TemplateMetadata: &TemplateMetadata{TemplateId: 257, CreationTimestamp: time.Now()},
+		Record:           &TemplateRecord{TemplateId: 257, FieldCount: 2, Fields: fields},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(id uint16, records []byte) []byte {
+		b := binary.BigEndian.AppendUint16(nil, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(4+len(records)))
+		return append(b, records...)
+	}
+	// two records of template 256
+	payload := newTestDataMessage(256, 2)[16:]
+	// a set of an unknown template
+	payload = append(payload, set(300, []byte{1, 2, 3, 4, 5, 6})...)
+	// a set of two RFC 5610 records
+	rfc5610 := make([]byte, 0)
+	for i, name := range []string{"fooCount", "barCount"} {
+		rfc5610 = binary.BigEndian.AppendUint16(rfc5610, uint16(1000+i))
+		rfc5610 = append(rfc5610, byte(len(name)))
+		rfc5610 = append(rfc5610, []byte(name)...)
+	}
+	payload = append(payload, set(257, rfc5610)...)
+
+	header := make([]byte, 0, 16)
+	header = binary.BigEndian.AppendUint16(header, 10)
+	header = binary.BigEndian.AppendUint16(header, uint16(16+len(payload)))
+	header = binary.BigEndian.AppendUint32(header, uint32(time.Now().Unix()))
+	header = binary.BigEndian.AppendUint32(header, 0)
+	header = binary.BigEndian.AppendUint32(header, 0)
+	message := append(header, payload...)
+
+	t.Run("unknown templates fail without option", func(t *testing.T) {
+		_, _, err := NewDecoder(templateCache, fieldCache).DecodeWithStats(ctx, bytes.NewBuffer(message))
+		if err == nil {
+			t.Fatal("expected decoding to fail on unknown template")
+		}
+	})
+
+	t.Run("skipped sets and dropped records", func(t *testing.T) {
+		var hooked DecodeStats
+		decoder := NewDecoder(templateCache, fieldCache, DecoderOptions{
+			SkipUnknownTemplates: true,
+			OmitRFC5610Records:   true,
+		}).WithCompletionHook(func(s DecodeStats) {
+			hooked = s
+		})
+
+		msg, stats, err := decoder.DecodeWithStats(ctx, bytes.NewBuffer(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Sets) != 2 {
+			t.Fatalf("expected 2 decoded sets, got %d", len(msg.Sets))
+		}
+		if l := msg.Sets[1].Set.Length(); l != 0 {
+			t.Errorf("expected RFC 5610 records to be omitted, got %d records", l)
+		}
+
+		expected := DecodeStats{
+			TotalLength:    int64(len(message)),
+			DecodedSets:    2,
+			DecodedRecords: 2,
+			DroppedSets:    1,
+			DroppedRecords: 3,
+		}
+		if stats.Duration <= 0 {
+			t.Errorf("expected positive duration, got %s", stats.Duration)
+		}
+		stats.Duration = 0
+		if stats != expected {
+			t.Errorf("expected stats %+v, got %+v", expected, stats)
+		}
+		hooked.Duration = 0
+		if hooked != expected {
+			t.Errorf("expected completion hook to receive %+v, got %+v", expected, hooked)
+		}
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	t.Run("unknown template", func(t *testing.T) {
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(300, 1)))
+
+		decodeErr := &DecodeError{}
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("expected DecodeError, got %v", err)
+		}
+		if decodeErr.Stage != DecodeStageDataSet || decodeErr.SetIndex != 0 || decodeErr.Offset != 16 || decodeErr.TemplateKey != NewKey(0, 300) {
+			t.Errorf("unexpected location of error %+v", decodeErr)
+		}
+		unknownTemplateErr := &UnknownTemplateError{}
+		if !errors.As(err, &unknownTemplateErr) || unknownTemplateErr.TemplateKey != NewKey(0, 300) {
+			t.Errorf("expected UnknownTemplateError for template 300, got %v", err)
+		}
+		if !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("expected error to be ErrTemplateNotFound, got %v", err)
+		}
+	})
+
+	t.Run("truncated set", func(t *testing.T) {
+		b := newTestDataMessage(256, 2)
+		// cut off the last record
+		b = b[:len(b)-6]
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(b))
+
+		truncatedErr := &TruncatedSetError{}
+		if !errors.As(err, &truncatedErr) {
+			t.Fatalf("expected TruncatedSetError, got %v", err)
+		}
+		if truncatedErr.Id != 256 || truncatedErr.Length != 16 || truncatedErr.Available != 6 {
+			t.Errorf("unexpected truncation %+v", truncatedErr)
+		}
+		if errors.As(err, new(*UnknownTemplateError)) {
+			t.Error("expected truncated set not to be an UnknownTemplateError")
+		}
+	})
+
+	t.Run("malformed set length", func(t *testing.T) {
+		b := newTestDataMessage(256, 0)
+		binary.BigEndian.PutUint16(b[18:20], 2)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(b))
+		if !errors.Is(err, ErrMalformedSet) {
+			t.Errorf("expected ErrMalformedSet, got %v", err)
+		}
+	})
+}
+
+func TestDecodeDataSetPadding(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache))
+
+	// template of sourceIPv4Address and a variable-length ipPayloadPacketSection, records of which
+	// are at least 5 bytes long
+	template := []byte{0x01, 0x00, 0, 2, 0, 8, 0, 4, 0x01, 0x3a, 0xff, 0xff}
+	if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX, template))); err != nil {
+		t.Fatal(err)
+	}
+	record := []byte{192, 0, 2, 1, 2, 0xca, 0xfe}
+
+	t.Run("trailing bytes shorter than a record are padding", func(t *testing.T) {
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(256, record, []byte{0, 0, 0, 0})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := msg.Sets[0].Set.Length(); l != 1 {
+			t.Errorf("expected 1 data record, got %d", l)
+		}
+	})
+
+	t.Run("truncated records are not padding", func(t *testing.T) {
+		truncated := []byte{192, 0, 2, 2, 4, 0xca, 0xfe}
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(256, record, truncated)))
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+		decodeErr := &DecodeError{}
+		if !errors.As(err, &decodeErr) || decodeErr.Stage != DecodeStageDataSet {
+			t.Errorf("expected DecodeError in data set, got %v", err)
+		}
+	})
+}
+
+func TestDecodeMessageLength(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("short by one", func(t *testing.T) {
+		b := newTestDataMessage(256, 2)
+		msg, err := NewDecoder(templateCache, fieldCache).Decode(ctx, bytes.NewBuffer(b[:len(b)-1]))
+		if msg != nil {
+			t.Error("expected truncated message to be discarded")
+		}
+
+		truncatedErr := &TruncatedMessageError{}
+		if !errors.As(err, &truncatedErr) {
+			t.Fatalf("expected TruncatedMessageError, got %v", err)
+		}
+		if truncatedErr.Length != len(b) || truncatedErr.Available != len(b)-1 || truncatedErr.Missing() != 1 {
+			t.Errorf("unexpected truncation %+v", truncatedErr)
+		}
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected error to be ErrMalformedMessage, got %v", err)
+		}
+		decodeErr := &DecodeError{}
+		if !errors.As(err, &decodeErr) || decodeErr.Stage != DecodeStageMessageHeader {
+			t.Errorf("expected error in message header, got %v", err)
+		}
+	})
+
+	t.Run("long by one", func(t *testing.T) {
+		b := newTestDataMessage(256, 2)
+		buf := bytes.NewBuffer(append(b, 0))
+		msg, err := NewDecoder(templateCache, fieldCache).Decode(ctx, buf)
+
+		trailingErr := &TrailingBytesError{}
+		if !errors.As(err, &trailingErr) {
+			t.Fatalf("expected TrailingBytesError, got %v", err)
+		}
+		if trailingErr.Length != len(b) || trailingErr.Trailing != 1 {
+			t.Errorf("unexpected trailing bytes %+v", trailingErr)
+		}
+		// the message itself is decoded from its announced length only
+		if msg == nil || len(msg.Sets) != 1 || msg.Sets[0].Set.Length() != 2 {
+			t.Fatalf("expected message with 2 records, got %v", msg)
+		}
+		if buf.Len() != 1 {
+			t.Errorf("expected trailing byte to remain in buffer, got %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("concatenated messages", func(t *testing.T) {
+		first, second := newTestDataMessage(256, 1), newTestDataMessage(256, 3)
+		payload := append(append([]byte{}, first...), second...)
+
+		msg, err := NewDecoder(templateCache, fieldCache).Decode(ctx, bytes.NewBuffer(payload))
+		trailingErr := &TrailingBytesError{}
+		if !errors.As(err, &trailingErr) || trailingErr.Trailing != len(second) {
+			t.Errorf("expected TrailingBytesError of %d bytes by default, got %v", len(second), err)
+		}
+		if msg == nil || msg.Length != uint16(len(first)) {
+			t.Errorf("expected first message to be decoded, got %v", msg)
+		}
+
+		decoder := NewDecoderWithOptions(templateCache, fieldCache, WithConcatenatedMessages())
+		buf := bytes.NewBuffer(payload)
+		var records []int
+		for buf.Len() > 0 {
+			msg, err := decoder.Decode(ctx, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, msg.Sets[0].Set.Length())
+		}
+		if len(records) != 2 || records[0] != 1 || records[1] != 3 {
+			t.Errorf("expected two messages with 1 and 3 records, got %v", records)
+		}
+	})
+}
+
+// newTestTemplateSetMessage creates a message of observation domain 1 with a single set of id
+// setId containing the given records
+func newTestTemplateSetMessage(setId uint16, records ...[]byte) []byte {
+	set := make([]byte, 0)
+	for _, record := range records {
+		set = append(set, record...)
+	}
+	b := binary.BigEndian.AppendUint16(nil, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(20+len(set)))
+	b = binary.BigEndian.AppendUint32(b, uint32(time.Now().Unix()))
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, setId)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(set)))
+	return append(b, set...)
+}
+
+func TestDecodeTemplateSets(t *testing.T) {
+	ctx := context.Background()
+
+	// template records of sourceIPv4Address and sourceTransportPort
+	good := func(templateId uint16) []byte {
+		return []byte{byte(templateId >> 8), byte(templateId), 0, 2, 0, 8, 0, 4, 0, 7, 0, 2}
+	}
+	// truncated announces three fields, of which the set only contains two
+	truncated := []byte{0x01, 0x01, 0, 3, 0, 8, 0, 4, 0, 7, 0, 2}
+
+	t.Run("all records are added to the cache", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache))
+
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX, good(256), good(257), []byte{0, 0})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := msg.Sets[0].Set.Length(); l != 2 {
+			t.Errorf("expected 2 template records, got %d", l)
+		}
+		for _, id := range []uint16{256, 257} {
+			if _, err := templateCache.Get(ctx, NewKey(1, id)); err != nil {
+				t.Errorf("expected template %d in cache, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("decoded records are kept in the set", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache))
+
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX, good(256), good(257), good(258))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, ok := msg.Sets[0].Set.(*TemplateSet)
+		if !ok {
+			t.Fatalf("expected %T, got %T", &TemplateSet{}, msg.Sets[0].Set)
+		}
+		if len(ts.Records) != 3 {
+			t.Fatalf("expected 3 template records, got %d", len(ts.Records))
+		}
+		for i, id := range []uint16{256, 257, 258} {
+			if ts.Records[i].TemplateId != id || len(ts.Records[i].Fields) != 2 {
+				t.Errorf("expected record %d to be template %d with 2 fields, got %v", i, id, ts.Records[i])
+			}
+			template, err := templateCache.Get(ctx, NewKey(1, id))
+			if err != nil {
+				t.Fatalf("expected template %d in cache, got %v", id, err)
+			}
+			if template.Record.Id() != id {
+				t.Errorf("expected cached template %d, got %d", id, template.Record.Id())
+			}
+		}
+	})
+
+	t.Run("truncated record discards the entire set", func(t *testing.T) {
+		m := NewMetrics()
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache)).WithMetrics(m, "test")
+
+		_, stats, err := decoder.DecodeWithStats(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX, good(256), truncated)))
+		if err == nil {
+			t.Fatal("expected decoding to fail")
+		}
+		recordErr := &TemplateRecordError{}
+		if !errors.As(err, &recordErr) || recordErr.TemplateId != 257 {
+			t.Errorf("expected TemplateRecordError for template 257, got %v", err)
+		}
+		decodeErr := &DecodeError{}
+		if !errors.As(err, &decodeErr) || decodeErr.Stage != DecodeStageTemplateSet {
+			t.Errorf("expected DecodeError in template set, got %v", err)
+		}
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+
+		// the good template preceding the truncated one is not committed either
+		for _, id := range []uint16{256, 257} {
+			if _, err := templateCache.Get(ctx, NewKey(1, id)); !errors.Is(err, ErrTemplateNotFound) {
+				t.Errorf("expected template %d not to be in cache, got %v", id, err)
+			}
+		}
+
+		if stats.DroppedRecords != 2 {
+			t.Errorf("expected 2 dropped records, got %d", stats.DroppedRecords)
+		}
+		if v := testutil.ToFloat64(m.DroppedRecords.WithLabelValues("test", "", KindTemplateSet)); v != 2 {
+			t.Errorf("expected 2 dropped template records, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.ErrorsTotal.WithLabelValues("test", "")); v != 1 {
+			t.Errorf("expected 1 error, got %v", v)
+		}
+
+		// the same templates are committed once they arrive in a set without truncated records
+		if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX, good(256), good(257)))); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []uint16{256, 257} {
+			if _, err := templateCache.Get(ctx, NewKey(1, id)); err != nil {
+				t.Errorf("expected template %d in cache, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("truncated options template record", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache))
+
+		// privateEnterpriseNumber scoping informationElementId
+		options := []byte{0x01, 0x02, 0, 2, 0, 1, 0x01, 0x5a, 0, 4, 0x01, 0x2f, 0, 2}
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIXOptions, options, options[:10])))
+
+		recordErr := &TemplateRecordError{}
+		if !errors.As(err, &recordErr) || recordErr.TemplateId != 258 {
+			t.Errorf("expected TemplateRecordError for template 258, got %v", err)
+		}
+		decodeErr := &DecodeError{}
+		if !errors.As(err, &decodeErr) || decodeErr.Stage != DecodeStageOptionsTemplateSet {
+			t.Errorf("expected DecodeError in options template set, got %v", err)
+		}
+		if _, err := templateCache.Get(ctx, NewKey(1, 258)); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("expected options template not to be in cache, got %v", err)
+		}
+
+		_, err = decoder.Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIXOptions, options)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		template, err := templateCache.Get(ctx, NewKey(1, 258))
+		if err != nil {
+			t.Fatal(err)
+		}
+		otr := template.Record.(*OptionsTemplateRecord)
+		if len(otr.Scopes) != 1 || len(otr.Options) != 1 || otr.Options[0] == nil {
+			t.Errorf("expected options template of one scope and one option, got %v", otr)
+		}
+	})
+}
+
+func TestDecodeTemplateMetadata(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	before := time.Now()
+	message := newTestTemplateSetMessage(IPFIX, newTestTemplateRecord(256), newTestTemplateRecord(257))
+	if _, err := decoder.Decode(ctx, bytes.NewBuffer(message)); err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(map[templateRecord]uint16)
+	for _, id := range []uint16{256, 257} {
+		key := NewKey(1, id)
+		template, err := templateCache.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if template.TemplateMetadata == nil {
+			t.Fatalf("expected metadata for template %d", id)
+		}
+		if template.TemplateId != id || template.ObservationDomainId != 1 {
+			t.Errorf("expected metadata of template %d in observation domain 1, got %d in %d", id, template.TemplateId, template.ObservationDomainId)
+		}
+		if template.CreationTimestamp.Before(before) {
+			t.Errorf("expected creation timestamp after %s, got %s", before, template.CreationTimestamp)
+		}
+		if template.Key() != key {
+			t.Errorf("expected key %v, got %v", key, template.Key())
+		}
+		if template.Record.Id() != id {
+			t.Errorf("expected record of template %d, got %d", id, template.Record.Id())
+		}
+		if other, ok := records[template.Record]; ok {
+			t.Errorf("expected distinct records, template %d shares its record with template %d", id, other)
+		}
+		records[template.Record] = id
+	}
+}
+
+func TestDecodeParallel(t *testing.T) {
+	ctx := context.Background()
+
+	// set encodes a set with the given id and contents
+	set := func(id uint16, contents ...byte) []byte {
+		b := binary.BigEndian.AppendUint16(nil, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(4+len(contents)))
+		return append(b, contents...)
+	}
+	// message encodes a message of the given sets
+	message := func(sets ...[]byte) []byte {
+		header := newTestDataMessage(256, 0)[:16]
+		b := append([]byte{}, header...)
+		for _, s := range sets {
+			b = append(b, s...)
+		}
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		return b
+	}
+	// records returns the data set contents of n records of the template created by newTestTemplate
+	records := func(n int) []byte {
+		return newTestDataMessage(256, n)[20:]
+	}
+
+	// templates redefines 256 to consist of sourceIPv4Address only, and defines 258 to consist of
+	// dataRecordsReliability, a boolean
+	templates := set(IPFIX,
+		0x01, 0x00, 0x00, 0x01, 0x00, 0x08, 0x00, 0x04,
+		0x01, 0x02, 0x00, 0x01, 0x01, 0x14, 0x00, 0x01,
+	)
+
+	// typeInformation defines fooCount (12345/1000) as unsigned64 in an options data set of
+	// template 400 and uses it in template 401, such that template 401 depends on the data set
+	ieSets, err := InformationElementSets(400, InformationElement{
+		Id:           1000,
+		Name:         "fooCount",
+		EnterpriseId: 12345,
+		Constructor:  NewUnsigned64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeInformation := &bytes.Buffer{}
+	for _, s := range ieSets {
+		if _, err := s.Encode(typeInformation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	typeInformation.Write(set(IPFIX, 0x01, 0x91, 0x00, 0x01, 0x83, 0xe8, 0x00, 0x08, 0x00, 0x00, 0x30, 0x39))
+
+	// decode decodes payload with a fresh decoder with the template of newTestTemplate defined
+	// as 256, and 258 defined as in templates
+	decode := func(t *testing.T, payload []byte, parallelism int) (*Message, DecodeStats, error) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 276))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = templateCache.Add(ctx, NewKey(0, 258), &Template{
+			TemplateMetadata: &TemplateMetadata{TemplateId: 258},
+			Record: &TemplateRecord{
+				TemplateId: 258,
+				FieldCount: 1,
+				Fields:     []Field{fb.SetLength(1).Complete()},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := NewDecoder(templateCache, fieldCache, DecoderOptions{SkipUnknownTemplates: true}).WithParallelism(parallelism)
+		return decoder.DecodeWithStats(ctx, bytes.NewBuffer(payload))
+	}
+
+	for name, tc := range map[string]struct {
+		payload []byte
+		fails   bool
+	}{
+		"many data sets": {payload: newTestMultiSetMessage(256, 16, 50)},
+		"template redefined between data sets": {
+			payload: message(
+				set(256, records(10)...),
+				set(256, records(3)...),
+				templates,
+				set(256, 192, 0, 2, 1, 192, 0, 2, 2),
+				set(258, 0x01, 0x02, 0x01),
+			),
+		},
+		"type information preceding a template set": {
+			payload: message(
+				set(256, records(10)...),
+				typeInformation.Bytes(),
+				set(401, 0, 0, 0, 0, 0, 0, 0, 42),
+			),
+		},
+		"unknown template": {
+			payload: message(
+				set(256, records(10)...),
+				set(300, 0x00, 0x01),
+				set(256, records(5)...),
+			),
+		},
+		"illegal value in data set": {
+			payload: message(
+				set(256, records(10)...),
+				templates,
+				set(258, 0x01, 0x00),
+				set(256, 192, 0, 2, 1),
+			),
+			fails: true,
+		},
+		"truncated set": {
+			payload: message(
+				set(256, records(10)...),
+				set(256, records(10)...)[:20],
+			),
+			fails: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expected, expectedStats, expectedErr := decode(t, tc.payload, 1)
+			actual, actualStats, actualErr := decode(t, tc.payload, 4)
+
+			if fails := expectedErr != nil; fails != tc.fails {
+				t.Fatalf("expected serial decoding to fail: %v, got error %v", tc.fails, expectedErr)
+			}
+
+			if (expectedErr == nil) != (actualErr == nil) || (expectedErr != nil && expectedErr.Error() != actualErr.Error()) {
+				t.Fatalf("expected error %v, got %v", expectedErr, actualErr)
+			}
+			expectedStats.Duration, actualStats.Duration = 0, 0
+			if expectedStats != actualStats {
+				t.Errorf("expected stats %+v, got %+v", expectedStats, actualStats)
+			}
+			e, err := json.Marshal(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := json.Marshal(actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(e, a) {
+				t.Errorf("expected message %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestNewDecoderWithOptions(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+
+	t.Run("options match legacy options struct", func(t *testing.T) {
+		legacy := NewDecoder(templateCache, fieldCache, DecoderOptions{
+			OmitRFC5610Records:   true,
+			SkipUnknownTemplates: true,
+			StrictUnknownFields:  true,
+		})
+		d := NewDecoderWithOptions(templateCache, fieldCache, WithOmitRFC5610(), WithSkipUnknownTemplates(), WithStrictUnknownFields())
+		if d.options != legacy.options {
+			t.Errorf("expected options %+v, got %+v", legacy.options, d.options)
+		}
+		if d := NewDecoderWithOptions(templateCache, fieldCache); d.options != DefaultDecoderOptions {
+			t.Errorf("expected default options %+v, got %+v", DefaultDecoderOptions, d.options)
+		}
+	})
+
+	t.Run("options configure decoding", func(t *testing.T) {
+		var stats []DecodeStats
+		d := NewDecoderWithOptions(templateCache, fieldCache,
+			WithSkipUnknownTemplates(),
+			WithParallelism(2),
+			WithCompletionHook(func(s DecodeStats) { stats = append(stats, s) }),
+		)
+		if d.parallelism != 2 {
+			t.Errorf("expected parallelism 2, got %d", d.parallelism)
+		}
+		_, err := d.Decode(ctx, bytes.NewBuffer(newTestDataMessage(300, 2)))
+		if err != nil {
+			t.Fatalf("expected data set of unknown template to be skipped, got %v", err)
+		}
+		if len(stats) != 1 || stats[0].DroppedSets != 1 {
+			t.Errorf("expected completion hook to be called with a dropped set, got %+v", stats)
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(b, fieldCache, 256))
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := NewDecoder(templateCache, fieldCache)
+	payload := newTestDataMessage(256, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	ctx := context.Background()
+
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	t.Run("concatenated messages from a connection", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer server.Close()
+
+		go func() {
+			defer client.Close()
+			for i := 1; i <= 3; i++ {
+				msg := newTestDataMessage(256, i)
+				binary.BigEndian.PutUint32(msg[8:12], uint32(i))
+				if _, err := client.Write(msg); err != nil {
+					return
+				}
+			}
+		}()
+
+		for i := 1; i <= 3; i++ {
+			msg, err := decoder.Decode(ctx, server)
+			if err != nil {
+				t.Fatalf("failed to decode message %d, %v", i, err)
+			}
+			if msg.SequenceNumber != uint32(i) {
+				t.Errorf("expected sequence number %d, got %d", i, msg.SequenceNumber)
+			}
+			if len(msg.Sets) != 1 || msg.Sets[0].Set.Length() != i {
+				t.Errorf("expected message %d to contain %d records, got %v", i, i, msg.Sets)
+			}
+		}
+
+		_, err := decoder.Decode(ctx, server)
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("expected io.EOF after the last message, got %v", err)
+		}
+	})
+
+	t.Run("truncated stream", func(t *testing.T) {
+		msg := newTestDataMessage(256, 2)
+		_, err := decoder.Decode(ctx, bytes.NewReader(msg[:len(msg)-1]))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		msg := newTestDataMessage(256, 1)
+		binary.BigEndian.PutUint16(msg[0:2], 9)
+		_, err := decoder.Decode(ctx, bytes.NewReader(msg))
+		if !errors.Is(err, ErrInvalidMessageHeader) || !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected ErrInvalidMessageHeader and ErrUnsupportedVersion, got %v", err)
+		}
+	})
+}
+
+func TestDecodePooledBuffers(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+
+	fields := make([]Field, 0, 3)
+	for _, spec := range []struct{ id, length uint16 }{{8, 4}, {96, VariableLength}, {313, VariableLength}} {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+	s := NewExportSession(0).WithTemplateCache(templateCache)
+	err := s.DefineTemplate(ctx, 256, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encode returns a message with a single record of the given values
+	encode := func(t *testing.T, ip string, app string, section []byte) []byte {
+		err := s.AddRecordValues(256, ip, app, section)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if _, err := s.Flush(out); err != nil {
+			t.Fatal(err)
+		}
+		msgs := splitMessages(t, out.Bytes())
+		return msgs[len(msgs)-1]
+	}
+
+	decoder := NewDecoder(templateCache, fieldCache)
+	decode := func(t *testing.T, payload []byte) []Field {
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := msg.Sets[len(msg.Sets)-1].Set.(*DataSet)
+		return set.Records[0].Fields
+	}
+
+	first := decode(t, encode(t, "192.0.2.1", "https", []byte{0xde, 0xad, 0xbe, 0xef}))
+	// decode a second message with different values of the same lengths, such that reused
+	// buffers would overwrite the values of the first message if they were retained
+	_ = decode(t, encode(t, "198.51.100.7", "imaps", []byte{0xca, 0xfe, 0xba, 0xbe}))
+
+	if v := first[0].Value().Value().(net.IP).String(); v != "192.0.2.1" {
+		t.Errorf("expected sourceIPv4Address 192.0.2.1, got %s", v)
+	}
+	if v := first[1].Value().Value().(string); v != "https" {
+		t.Errorf("expected applicationName https, got %s", v)
+	}
+	if v := first[2].Value().Value().([]byte); !bytes.Equal(v, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("expected ipHeaderPacketSection deadbeef, got %x", v)
+	}
+}
+
+// newTestMultiSetMessage creates an IPFIX message containing the given number of data sets,
+// each with n records of the template created by newTestTemplate
+func newTestMultiSetMessage(templateId uint16, sets int, n int) []byte {
+	single := newTestDataMessage(templateId, n)
+	b := append([]byte{}, single[:16]...)
+	for i := 0; i < sets; i++ {
+		b = append(b, single[16:]...)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+func BenchmarkDecodeMultiSet(b *testing.B) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(b, fieldCache, 256))
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := NewDecoder(templateCache, fieldCache)
+	// 64 sets of 160 records each, i.e., close to the maximum message length
+	payload := newTestMultiSetMessage(256, 64, 160)
+
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeParallel(b *testing.B) {
+	ctx := context.Background()
+	payload := newTestMultiSetMessage(256, 64, 160)
+
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			templateCache := NewDefaultEphemeralCache()
+			fieldCache := NewIANAFieldManager(templateCache)
+			err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(b, fieldCache, 256))
+			if err != nil {
+				b.Fatal(err)
+			}
+			decoder := NewDecoder(templateCache, fieldCache).WithParallelism(parallelism)
+
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := decoder.Decode(ctx, bytes.NewBuffer(payload))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeFragmentedReads(t *testing.T) {
+	ctx := context.Background()
+
+	// fragmented returns a reader that returns at most one byte per Read, as e.g. a TCP
+	// stream may legitimately do
+	fragmented := func(b []byte) io.Reader {
+		return iotest.OneByteReader(bytes.NewReader(b))
+	}
+
+	t.Run("message", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+
+		b := &bytes.Buffer{}
+		if _, err := newTestMessage(t).Encode(b); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := NewDecoder(templateCache, fieldCache).Decode(ctx, fragmented(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Sets) != 3 || msg.Sets[2].Kind != KindDataSet || msg.Sets[2].Set.Length() != 2 {
+			t.Fatalf("expected message with a data set of 2 records, got %v", msg.Sets)
+		}
+		records := msg.Sets[2].Set.(*DataSet).Records
+		if v := records[1].Fields[0].Value().String(); v != "198.51.100.7" {
+			t.Errorf("expected second record to contain 198.51.100.7, got %s", v)
+		}
+	})
+
+	t.Run("message header", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		msg := &Message{}
+		n, err := msg.Decode(fragmented(b[:16]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 16 || msg.Length != uint16(len(b)) || msg.ObservationDomainId != binary.BigEndian.Uint32(b[12:16]) {
+			t.Errorf("expected header of %d bytes with length %d, got %d bytes, %v", 16, len(b), n, msg)
+		}
+	})
+
+	t.Run("template record", func(t *testing.T) {
+		b := newTestTemplateRecord(256)
+		tr := &TemplateRecord{fieldCache: NewIANAFieldManager(NewDefaultEphemeralCache()), templateCache: NewDefaultEphemeralCache()}
+		if _, err := tr.Decode(fragmented(b)); err != nil {
+			t.Fatal(err)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := tr.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected template record to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+	})
+
+	t.Run("data types", func(t *testing.T) {
+		for _, c := range []DataTypeConstructor{
+			NewUnsigned8, NewUnsigned16, NewUnsigned32, NewUnsigned64,
+			NewSigned8, NewSigned16, NewSigned32, NewSigned64,
+			NewFloat32, NewFloat64, NewBoolean, NewMacAddress,
+			NewIPv4Address, NewIPv6Address,
+			NewDateTimeSeconds, NewDateTimeMilliseconds, NewDateTimeMicroseconds, NewDateTimeNanoseconds,
+		} {
+			dt, expected := c(), c()
+			b := bytes.Repeat([]byte{0x01}, int(dt.DefaultLength()))
+			n, err := dt.Decode(fragmented(b))
+			if err != nil {
+				t.Fatalf("failed to decode %s, %v", dt.Type(), err)
+			}
+			if _, err := expected.Decode(bytes.NewReader(b)); err != nil {
+				t.Fatal(err)
+			}
+			if n != len(b) || dt.String() != expected.String() {
+				t.Errorf("expected %s to decode %d bytes to %s, got %d bytes, %s", dt.Type(), len(b), expected, n, dt)
+			}
+		}
+	})
+
+	t.Run("variable-length octet array", func(t *testing.T) {
+		value := bytes.Repeat([]byte{0xca, 0xfe}, 150)
+		b := binary.BigEndian.AppendUint16([]byte{0xFF}, uint16(len(value)))
+		b = append(b, value...)
+
+		f := NewFieldBuilder(&InformationElement{
+			Id:          313,
+			Constructor: NewOctetArray,
+		}).SetLength(VariableLength).Complete()
+		n, err := f.Decode(fragmented(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(b) || !bytes.Equal(f.Value().Value().([]byte), value) {
+			t.Errorf("expected %d bytes of value to be decoded, got %d bytes, %v", len(b), n, f.Value().Value())
+		}
+	})
+
+	t.Run("options template record", func(t *testing.T) {
+		// template 257 scoped by observationDomainId with option exportedMessageTotalCount
+		b := []byte{0x01, 0x01, 0x00, 0x02, 0x00, 0x01, 0x00, 0x95, 0x00, 0x04, 0x00, 0x29, 0x00, 0x08}
+		tr := &OptionsTemplateRecord{fieldCache: NewIANAFieldManager(NewDefaultEphemeralCache()), templateCache: NewDefaultEphemeralCache()}
+		if _, err := tr.Decode(fragmented(b)); err != nil {
+			t.Fatal(err)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := tr.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected options template record to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+	})
+
+	t.Run("basic list", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(5 + 3*2)
+
+		// semantic ordered, field id 7 (sourceTransportPort), element length 2, three elements
+		in := []byte{0x04, 0x00, 0x07, 0x00, 0x02, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}
+		if _, err := bl.Decode(fragmented(in)); err != nil {
+			t.Fatal(err)
+		}
+		if els := bl.(*BasicList).Elements(); len(els) != 3 || els[2].Value().Value() != uint16(3) {
+			t.Errorf("expected 3 elements, got %v", els)
+		}
+	})
+
+	t.Run("sub template list", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+			t.Fatal(err)
+		}
+		// a data set of the test template contains the records of the list
+		records := newTestDataMessage(256, 2)[20:]
+		in := append([]byte{0x03, 0x01, 0x00}, records...)
+
+		stl := NewDefaultSubTemplateList().(*SubTemplateList).
+			NewBuilder().
+			WithTemplateCache(templateCache).
+			WithFieldCache(fieldCache).
+			Complete()().
+			SetLength(uint16(len(in)))
+		if _, err := stl.Decode(fragmented(in)); err != nil {
+			t.Fatal(err)
+		}
+		if records := stl.Value().([]DataRecord); len(records) != 2 {
+			t.Errorf("expected 2 records, got %v", records)
+		}
+	})
+
+	t.Run("sub template multi list", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+			t.Fatal(err)
+		}
+		records := newTestDataMessage(256, 2)[20:]
+		in := append([]byte{0x03, 0x01, 0x00, 0x00, byte(4 + len(records))}, records...)
+
+		stml := NewDefaultSubTemplateMultiList().(*SubTemplateMultiList).
+			NewBuilder().
+			WithTemplateCache(templateCache).
+			WithFieldCache(fieldCache).
+			Complete()().
+			SetLength(uint16(len(in)))
+		if _, err := stml.Decode(fragmented(in)); err != nil {
+			t.Fatal(err)
+		}
+		if els := stml.(*SubTemplateMultiList).Elements(); len(els) != 1 || len(els[0].Values) != 2 {
+			t.Errorf("expected 2 records of a single sub template, got %v", els)
+		}
+	})
+
+	t.Run("short reads", func(t *testing.T) {
+		dt := NewOctetArray().SetLength(8)
+		_, err := dt.Decode(fragmented([]byte{0x01, 0x02, 0x03}))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+		}
+
+		_, err = (&TemplateRecord{}).Decode(fragmented([]byte{0x01}))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+		}
+
+		_, err = (&OptionsTemplateRecord{}).Decode(fragmented([]byte{0x01, 0x01, 0x00}))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+}
@@ -76,5 +76,20 @@ the templates such that incoming data records can be decoded if and only if the
 prescribes that decoders (collectors) may store records not able to decode due to absense of the template up to a certain point if e.g.
 asymmetric paths create such a race condition. go-ipfix *does not* implement such a system, but the error returned from the Decoder if
 a template is not known can be used to queue up such messages and work off that queue at any later point.
+
+Errors returned by the Decoder wrap one of the package's sentinels, such that callers can distinguish
+messages that may be decoded later from those that never will:
+
+	msg, err := decoder.Decode(ctx, payload)
+	var missing *ipfix.UnknownTemplateError
+	switch {
+	case errors.As(err, &missing):
+		// queue the message until template missing.TemplateId() arrives in
+		// observation domain missing.ObservationDomainId()
+	case errors.Is(err, ipfix.ErrMalformedMessage):
+		// length or count fields are inconsistent, drop the message
+	case errors.Is(err, ipfix.ErrUnknownSetId):
+		// the message uses a reserved set id
+	}
 */
 package ipfix
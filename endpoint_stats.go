@@ -0,0 +1,251 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// endpointOther is the endpoint of the statistics of all endpoints exceeding the maximum number
+// of endpoints tracked by a listener
+const endpointOther string = "other"
+
+// EndpointStats are the statistics of a remote endpoint of a listener, i.e., an exporter identified
+// by its address and port, as returned by UDPListener.Endpoints and TCPListener.Endpoints
+type EndpointStats struct {
+	// Endpoint is the address and port of the exporter, or "other" for the combined statistics of
+	// the endpoints exceeding the maximum number of tracked endpoints
+	Endpoint string
+	// Messages is the number of messages received from the endpoint
+	Messages uint64
+	// Bytes is the number of bytes of the messages received from the endpoint
+	Bytes uint64
+	// FirstSeen is the time the endpoint was first seen, i.e., the time its first message was
+	// received or its connection was accepted
+	FirstSeen time.Time
+	// LastSeen is the time the latest message of the endpoint was received, or its connection was
+	// closed
+	LastSeen time.Time
+	// Session is the state of the endpoint's TCP connection. It is nil for UDP endpoints and for
+	// TCP endpoints whose connection is closed.
+	Session *SessionStats
+}
+
+// SessionStats is the state of the TCP connection of an endpoint
+type SessionStats struct {
+	// PendingBytes is the number of bytes read of the message currently being received
+	PendingBytes uint64
+	// Messages is the number of messages completed on the connection
+	Messages uint64
+}
+
+// endpointRegistry tracks the statistics of the remote endpoints of a listener. It is shared by
+// the read loops of the listener, i.e., the connection handlers of a TCPListener. Endpoints that
+// were idle for idleTimeout are evicted, and endpoints exceeding maxEndpoints are collapsed into a
+// single endpoint "other", such that neither the registry nor the label values of the endpoint
+// metrics grow unbounded with the number of exporters.
+type endpointRegistry struct {
+	listener string
+	metrics  *Metrics
+
+	// maxEndpoints caps the number of tracked endpoints, 0 means no limit
+	maxEndpoints int
+	// idleTimeout is the duration after which endpoints without messages are evicted, 0 means
+	// endpoints are never evicted
+	idleTimeout time.Duration
+	// now is used in tests for controlling the clock of the registry
+	now func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointEntry
+	lastSweep time.Time
+}
+
+type endpointEntry struct {
+	stats EndpointStats
+	// session is the session of the endpoint's connection while the connection is open
+	session *session
+}
+
+func newEndpointRegistry(listener string, maxEndpoints int, idleTimeout time.Duration) *endpointRegistry {
+	if maxEndpoints < 0 {
+		maxEndpoints = 0
+	}
+	if idleTimeout < 0 {
+		idleTimeout = 0
+	}
+	return &endpointRegistry{
+		listener:     listener,
+		maxEndpoints: maxEndpoints,
+		idleTimeout:  idleTimeout,
+		now:          time.Now,
+		endpoints:    make(map[string]*endpointEntry),
+	}
+}
+
+// endpointOf returns the endpoint of a remote address, i.e., the unmapped address and port of UDP
+// and TCP addresses, such that the endpoints are the same as the exporters of a Collector
+func endpointOf(addr net.Addr) string {
+	if exporter := exporterAddrPort(addr); exporter.IsValid() {
+		return exporter.String()
+	}
+	return addr.String()
+}
+
+// observe records a message of n bytes received from endpoint
+func (r *endpointRegistry) observe(endpoint string, n int) {
+	if r == nil {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.idleTimeout > 0 && now.Sub(r.lastSweep) >= r.idleTimeout {
+		r.sweep(now)
+	}
+	endpoint, e := r.entry(endpoint, now)
+	e.stats.Messages++
+	e.stats.Bytes += uint64(n)
+	e.stats.LastSeen = now
+
+	if r.metrics != nil {
+		r.metrics.ListenerEndpointMessages.WithLabelValues(r.listener, endpoint).Inc()
+		r.metrics.ListenerEndpointBytes.WithLabelValues(r.listener, endpoint).Add(float64(n))
+		r.metrics.ListenerEndpointLastSeen.WithLabelValues(r.listener, endpoint).Set(float64(now.UnixNano()) / 1e9)
+	}
+}
+
+// connect tracks the session of endpoint's connection until disconnect is called. Endpoints are
+// not evicted while their connection is open.
+func (r *endpointRegistry) connect(endpoint string, s *session) {
+	if r == nil {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	endpoint, e := r.entry(endpoint, now)
+	if endpoint != endpointOther {
+		e.session = s
+	}
+}
+
+// disconnect stops tracking the session of endpoint's connection. The endpoint is evicted once it
+// was idle for the idle timeout of the registry.
+func (r *endpointRegistry) disconnect(endpoint string, s *session) {
+	if r == nil {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.endpoints[endpoint]; ok && e.session == s {
+		e.session = nil
+		e.stats.LastSeen = now
+	}
+}
+
+// entry returns the entry of endpoint, creating it if the endpoint is not tracked yet. If the
+// maximum number of endpoints is reached, the entry of "other" is returned. r.mu must be held.
+func (r *endpointRegistry) entry(endpoint string, now time.Time) (string, *endpointEntry) {
+	e, ok := r.endpoints[endpoint]
+	if ok {
+		return endpoint, e
+	}
+	if r.maxEndpoints > 0 && r.tracked() >= r.maxEndpoints {
+		// make room by evicting idle endpoints before collapsing the endpoint into "other"
+		r.sweep(now)
+		if r.tracked() >= r.maxEndpoints {
+			endpoint = endpointOther
+			if e, ok := r.endpoints[endpoint]; ok {
+				return endpoint, e
+			}
+		}
+	}
+	e = &endpointEntry{
+		stats: EndpointStats{
+			Endpoint:  endpoint,
+			FirstSeen: now,
+			LastSeen:  now,
+		},
+	}
+	r.endpoints[endpoint] = e
+	return endpoint, e
+}
+
+// tracked returns the number of endpoints counting towards the maximum, i.e., without "other".
+// r.mu must be held.
+func (r *endpointRegistry) tracked() int {
+	if _, ok := r.endpoints[endpointOther]; ok {
+		return len(r.endpoints) - 1
+	}
+	return len(r.endpoints)
+}
+
+// sweep evicts all endpoints without open connections that were idle for the idle timeout and
+// deletes their metrics. r.mu must be held.
+func (r *endpointRegistry) sweep(now time.Time) {
+	r.lastSweep = now
+	if r.idleTimeout == 0 {
+		return
+	}
+	for endpoint, e := range r.endpoints {
+		if e.session != nil || now.Sub(e.stats.LastSeen) < r.idleTimeout {
+			continue
+		}
+		delete(r.endpoints, endpoint)
+		if r.metrics != nil {
+			r.metrics.ListenerEndpointMessages.DeleteLabelValues(r.listener, endpoint)
+			r.metrics.ListenerEndpointBytes.DeleteLabelValues(r.listener, endpoint)
+			r.metrics.ListenerEndpointLastSeen.DeleteLabelValues(r.listener, endpoint)
+		}
+	}
+}
+
+// snapshot evicts idle endpoints and returns the statistics of the remaining ones ordered by
+// endpoint
+func (r *endpointRegistry) snapshot() []EndpointStats {
+	if r == nil {
+		return nil
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+	stats := make([]EndpointStats, 0, len(r.endpoints))
+	for _, e := range r.endpoints {
+		s := e.stats
+		if e.session != nil {
+			s.Session = &SessionStats{
+				PendingBytes: e.session.pending.Load(),
+				Messages:     e.session.completed.Load(),
+			}
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Endpoint < stats[j].Endpoint
+	})
+	return stats
+}
@@ -0,0 +1,169 @@
+package ipfix
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEndpointRegistry(t *testing.T) {
+	// newTestRegistry creates a registry with metrics and a clock advanced by the returned function
+	newTestRegistry := func(t *testing.T, maxEndpoints int, idleTimeout time.Duration) (*endpointRegistry, func(time.Duration)) {
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		r := newEndpointRegistry("test", maxEndpoints, idleTimeout)
+		r.metrics = m
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.now = func() time.Time { return now }
+		return r, func(d time.Duration) { now = now.Add(d) }
+	}
+
+	endpoints := func(stats []EndpointStats) []string {
+		eps := make([]string, 0, len(stats))
+		for _, s := range stats {
+			eps = append(eps, s.Endpoint)
+		}
+		return eps
+	}
+
+	t.Run("statistics per endpoint", func(t *testing.T) {
+		r, advance := newTestRegistry(t, 0, 0)
+		r.observe("192.0.2.1:4739", 100)
+		advance(time.Second)
+		r.observe("192.0.2.1:4739", 50)
+		r.observe("192.0.2.2:4739", 10)
+
+		stats := r.snapshot()
+		if len(stats) != 2 {
+			t.Fatalf("expected 2 endpoints, got %v", endpoints(stats))
+		}
+		s := stats[0]
+		if s.Endpoint != "192.0.2.1:4739" || s.Messages != 2 || s.Bytes != 150 || s.Session != nil {
+			t.Errorf("unexpected statistics %+v", s)
+		}
+		if d := s.LastSeen.Sub(s.FirstSeen); d != time.Second {
+			t.Errorf("expected endpoint to be seen for 1s, got %v", d)
+		}
+		if c := testutil.ToFloat64(r.metrics.ListenerEndpointBytes.WithLabelValues("test", "192.0.2.1:4739")); c != 150 {
+			t.Errorf("expected 150 bytes, got %v", c)
+		}
+		if ts := testutil.ToFloat64(r.metrics.ListenerEndpointLastSeen.WithLabelValues("test", "192.0.2.1:4739")); ts != float64(s.LastSeen.Unix()) {
+			t.Errorf("expected last seen timestamp %d, got %v", s.LastSeen.Unix(), ts)
+		}
+	})
+
+	t.Run("eviction of idle endpoints", func(t *testing.T) {
+		r, advance := newTestRegistry(t, 0, time.Minute)
+		r.observe("192.0.2.1:4739", 100)
+		r.observe("192.0.2.2:4739", 100)
+		advance(30 * time.Second)
+		r.observe("192.0.2.2:4739", 100)
+		advance(30 * time.Second)
+
+		if eps := endpoints(r.snapshot()); len(eps) != 1 || eps[0] != "192.0.2.2:4739" {
+			t.Errorf("expected only the active endpoint to remain, got %v", eps)
+		}
+		if n := testutil.CollectAndCount(r.metrics.ListenerEndpointMessages); n != 1 {
+			t.Errorf("expected metrics of evicted endpoint to be deleted, got %d series", n)
+		}
+
+		// evicted endpoints start over once they are seen again
+		r.observe("192.0.2.1:4739", 10)
+		if s := r.snapshot()[0]; s.Endpoint != "192.0.2.1:4739" || s.Messages != 1 || s.Bytes != 10 {
+			t.Errorf("expected statistics of returning endpoint to be reset, got %+v", s)
+		}
+	})
+
+	t.Run("endpoints exceeding the maximum are combined", func(t *testing.T) {
+		r, advance := newTestRegistry(t, 2, time.Minute)
+		r.observe("192.0.2.1:4739", 1)
+		r.observe("192.0.2.2:4739", 1)
+		r.observe("192.0.2.3:4739", 1)
+		r.observe("192.0.2.4:4739", 1)
+
+		stats := r.snapshot()
+		if eps := endpoints(stats); len(eps) != 3 || eps[2] != endpointOther {
+			t.Fatalf("expected 2 endpoints and %s, got %v", endpointOther, eps)
+		}
+		if stats[2].Messages != 2 {
+			t.Errorf("expected %s to combine 2 messages, got %d", endpointOther, stats[2].Messages)
+		}
+
+		// idle endpoints make room for new ones
+		advance(time.Minute)
+		r.observe("192.0.2.2:4739", 1)
+		r.observe("192.0.2.5:4739", 1)
+		if eps := endpoints(r.snapshot()); len(eps) != 2 || eps[0] != "192.0.2.2:4739" || eps[1] != "192.0.2.5:4739" {
+			t.Errorf("expected idle endpoints to be evicted, got %v", eps)
+		}
+	})
+
+	t.Run("connected endpoints are not evicted", func(t *testing.T) {
+		r, advance := newTestRegistry(t, 0, time.Minute)
+		s := newSession(nil)
+		s.pending.Store(7)
+		s.completed.Store(3)
+		r.connect("192.0.2.1:4739", s)
+		advance(time.Hour)
+
+		stats := r.snapshot()
+		if len(stats) != 1 || stats[0].Session == nil {
+			t.Fatalf("expected connected endpoint with session, got %+v", stats)
+		}
+		if ss := stats[0].Session; ss.PendingBytes != 7 || ss.Messages != 3 {
+			t.Errorf("unexpected session state %+v", ss)
+		}
+
+		r.disconnect("192.0.2.1:4739", s)
+		if stats := r.snapshot(); len(stats) != 1 || stats[0].Session != nil {
+			t.Errorf("expected disconnected endpoint without session, got %+v", stats)
+		}
+		advance(time.Minute)
+		if stats := r.snapshot(); len(stats) != 0 {
+			t.Errorf("expected disconnected endpoint to be evicted, got %+v", stats)
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		r, _ := newTestRegistry(t, 4, time.Millisecond)
+		r.now = time.Now
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				endpoint := fmt.Sprintf("192.0.2.%d:4739", i)
+				s := newSession(nil)
+				for j := 0; j < 200; j++ {
+					r.connect(endpoint, s)
+					r.observe(endpoint, 10)
+					r.disconnect(endpoint, s)
+				}
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				for _, s := range r.snapshot() {
+					if s.Bytes != 10*s.Messages {
+						t.Errorf("expected 10 bytes per message, got %+v", s)
+						return
+					}
+				}
+			}
+		}()
+		wg.Wait()
+
+		if stats := r.snapshot(); len(stats) > 5 {
+			t.Errorf("expected at most 4 endpoints and %s, got %d", endpointOther, len(stats))
+		}
+	})
+}
@@ -31,10 +31,15 @@ type EphemeralCache struct {
 
 	mu *sync.RWMutex
 
+	subscribers *cacheSubscribers
+
 	name string
 }
 
 var _ TemplateCache = &EphemeralCache{}
+var _ json.Unmarshaler = &EphemeralCache{}
+var _ TemplateCacheWithStats = &EphemeralCache{}
+var _ ObservableTemplateCache = &EphemeralCache{}
 
 // NewBasicTemplateCache creates a new in-memory template cache that lives for the lifetime
 // of the caller
@@ -44,9 +49,10 @@ func NewDefaultEphemeralCache() StatefulTemplateCache {
 
 func NewNamedEphemeralCache(name string) StatefulTemplateCache {
 	ts := &EphemeralCache{
-		templates: make(map[TemplateKey]*Template),
-		mu:        &sync.RWMutex{},
-		name:      name,
+		templates:   make(map[TemplateKey]*Template),
+		mu:          &sync.RWMutex{},
+		subscribers: newCacheSubscribers(),
+		name:        name,
 	}
 	return ts
 }
@@ -71,20 +77,37 @@ func (ts *EphemeralCache) Get(ctx context.Context, key TemplateKey) (*Template,
 
 func (ts *EphemeralCache) Delete(ctx context.Context, key TemplateKey) error {
 	ts.mu.Lock()
-	defer ts.mu.Unlock()
-
+	template, ok := ts.templates[key]
 	delete(ts.templates, key)
+	ts.mu.Unlock()
+
+	if ok {
+		ts.subscribers.publish(CacheEvent{Kind: CacheEventDelete, Key: key, Template: template})
+	}
 	return nil
 }
 
 func (ts *EphemeralCache) Add(ctx context.Context, key TemplateKey, template *Template) error {
 	ts.mu.Lock()
-	defer ts.mu.Unlock()
 	ts.templates[key] = template
+	ts.mu.Unlock()
 
+	ts.subscribers.publish(CacheEvent{Kind: CacheEventAdd, Key: key, Template: template})
 	return nil
 }
 
+// Subscribe registers fn to be called after every Add and Delete, see ObservableTemplateCache
+func (ts *EphemeralCache) Subscribe(fn func(event CacheEvent)) (unsubscribe func()) {
+	return ts.subscribers.subscribe(fn)
+}
+
+// Stats returns usage statistics of all templates in the cache
+func (ts *EphemeralCache) Stats(ctx context.Context) []TemplateStats {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return templateStats(ts.templates)
+}
+
 func (ts *EphemeralCache) Type() string {
 	return "ephemeral"
 }
@@ -97,11 +120,21 @@ func (ts *EphemeralCache) MarshalJSON() ([]byte, error) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
-	s := make(map[string]interface{})
-	for k, v := range ts.templates {
-		s[k.String()] = v
+	return marshalSortedJSON(ts.templates, templateKeyLess, templateKeyString)
+}
+
+// UnmarshalJSON replaces all templates in the cache with the templates marshalled by MarshalJSON.
+// Subscribers are not notified of the restored templates.
+func (ts *EphemeralCache) UnmarshalJSON(in []byte) error {
+	templates, err := unmarshalTemplates(in, nil, ts)
+	if err != nil {
+		return err
 	}
-	return json.Marshal(s)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.templates = templates
+	return nil
 }
 
 func (ts *EphemeralCache) Close(context.Context) error {
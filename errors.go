@@ -26,20 +26,299 @@ var (
 	// It may be used in errors.Is() checks for error type, whereas compound errors constructed
 	// with TemplateNotFound(...) cannot be compared with == due to including more information
 	ErrTemplateNotFound error = errors.New("template not found")
+	// ErrTemplateExpired is used by caches with timeouts, e.g., DecayingEphemeralCache, for templates whose
+	// deadline passed without the exporter announcing them again. It wraps ErrTemplateNotFound, such that
+	// expired templates can be counted separately from templates never seen, but are otherwise handled alike.
+	ErrTemplateExpired error = fmt.Errorf("%w: template expired", ErrTemplateNotFound)
+	// ErrUnsupportedVersion is returned for message headers of a version other than IPFIX (10), e.g.,
+	// NetFlow v5 or v9, such that callers can pass these messages on to a decoder of the respective
+	// protocol.
+	ErrUnsupportedVersion error = errors.New("unsupported version")
 	// ErrUnknownVersion indicates an illegal version number for IPFIX in the header of the message.
-	ErrUnknownVersion error = errors.New("unknown version")
-	// ErrUnknownFlowId is used for indicating usage of a set ID unassigned in IPFIX, which is specifically
-	// the interval [5, 255], which is reserved.
-	ErrUnknownFlowId error = errors.New("unknown flow id")
+	//
+	// Deprecated: use ErrUnsupportedVersion, ErrUnknownVersion is the same error.
+	ErrUnknownVersion error = ErrUnsupportedVersion
+	// ErrInvalidMessageHeader is returned by ReadMessage, and thus by TCP sessions and IPFIX file readers,
+	// for message headers of an unknown version or with an illegal length. Streams of messages cannot be
+	// framed into messages anymore after such a header.
+	ErrInvalidMessageHeader error = errors.New("invalid message header")
+	// ErrUnknownSetId is used for indicating usage of a set ID unassigned in IPFIX, which is specifically
+	// the interval [4, 255], which is reserved.
+	ErrUnknownSetId error = errors.New("unknown set id")
+	// ErrUnknownFlowId is the former name of ErrUnknownSetId.
+	//
+	// Deprecated: use ErrUnknownSetId instead, which is the same error value.
+	ErrUnknownFlowId error = ErrUnknownSetId
+
+	// ErrMalformedMessage is the base error for messages, sets, records, and structured data types whose
+	// length or count fields are inconsistent with their contents. Unlike ErrTemplateNotFound, decoding
+	// such a message will never succeed, so it should be dropped.
+	ErrMalformedMessage error = errors.New("malformed message")
 
 	// ErrIllegalDataTypeEncoding is used in Decode of certain data types that explicitly define illegal formats
 	// such as boolean (1 and 2 encoding true and false and all other values being illegal) or strings
 	// only allowing utf8 sequences.
 	ErrIllegalDataTypeEncoding = errors.New("illegal data type encoding")
+
+	// ErrUnknownField is used by decoders in strict mode for indicating a field that is not known to
+	// the FieldCache, e.g., an enterprise-specific IE of an unregistered PEN.
+	ErrUnknownField = errors.New("unknown field")
+
+	// ErrUnsupportedSchemaVersion is used when unmarshalling JSON messages that were marshalled with
+	// a newer schema than MessageSchemaVersion.
+	ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+	// ErrMalformedSet is used for sets whose length field is smaller than the set header. It wraps
+	// ErrMalformedMessage.
+	ErrMalformedSet = fmt.Errorf("%w: set length is shorter than its header", ErrMalformedMessage)
+
+	// ErrFieldNotFound is used by field caches for information elements that are not known to them.
+	ErrFieldNotFound = errors.New("field not found")
+
+	// ErrUnknownCommonProperties is used by the CommonPropertiesResolver for data records referencing a
+	// commonPropertiesId that was never defined or already withdrawn.
+	ErrUnknownCommonProperties = errors.New("unknown common properties")
+
+	// ErrInvalidValue is used by SetValueE of data types for values of unsupported types or out of
+	// range of the data type, e.g., a negative number for unsigned8.
+	ErrInvalidValue = errors.New("invalid value")
+
+	// ErrBiflowMismatch is used by MergeBiflow for uniflow records that do not share the same flow key.
+	ErrBiflowMismatch = errors.New("biflow mismatch")
+
+	// ErrDecodeLimitExceeded is the base error of DecodeLimitError, used for data sets exceeding the
+	// DecodeLimits of a decoder.
+	ErrDecodeLimitExceeded = errors.New("decode limit exceeded")
+
+	// ErrUnknownDataType is used when reading information element registries that use an abstract data
+	// type for which no DataTypeConstructor is known.
+	ErrUnknownDataType = errors.New("unknown data type")
+
+	// ErrDataTypeExists is used by RegisterDataType and RegisterDataTypeNumber for names and identifiers
+	// of data types that are already registered.
+	ErrDataTypeExists = errors.New("data type already registered")
+
+	// ErrTemplateMismatch is used by CSVWriter for records of a template other than the one the
+	// writer's columns are derived from.
+	ErrTemplateMismatch = errors.New("template mismatch")
+
+	// ErrConflictingField is used by field caches with WithStrictFieldDefinitions for information
+	// elements that redefine an element of the same id and PEN, e.g., with a different data type.
+	ErrConflictingField = errors.New("conflicting field definition")
 )
 
 // templateNotFound wraps ErrTemplateNotFound to provide more information about _where_ the template
 // was expected to be
 func templateNotFound(observationDomainId uint32, templateId uint16) error {
-	return fmt.Errorf("%w for %d in observation domain %d", ErrTemplateNotFound, templateId, observationDomainId)
+	return &UnknownTemplateError{
+		TemplateKey: NewKey(observationDomainId, templateId),
+	}
+}
+
+// templateExpired wraps ErrTemplateExpired for templates whose deadline passed
+func templateExpired(observationDomainId uint32, templateId uint16) error {
+	return &UnknownTemplateError{
+		TemplateKey: NewKey(observationDomainId, templateId),
+		Expired:     true,
+	}
+}
+
+// UnknownTemplateError is returned by template caches and decoders for templates that are not
+// (yet) known. Messages failing with UnknownTemplateError may be decoded successfully later,
+// e.g., after the template was received over a different transport session.
+//
+// UnknownTemplateError unwraps to ErrTemplateNotFound, so errors.Is(err, ErrTemplateNotFound)
+// holds for it. For expired templates, it unwraps to ErrTemplateExpired.
+type UnknownTemplateError struct {
+	TemplateKey TemplateKey
+	// Expired is set for templates that were known, but expired
+	Expired bool
+}
+
+func (e *UnknownTemplateError) Error() string {
+	return fmt.Sprintf("%s for %d in observation domain %d", e.Unwrap(), e.TemplateKey.TemplateId, e.TemplateKey.ObservationDomainId)
+}
+
+func (e *UnknownTemplateError) Unwrap() error {
+	if e.Expired {
+		return ErrTemplateExpired
+	}
+	return ErrTemplateNotFound
+}
+
+// ObservationDomainId returns the observation domain in which the template was expected.
+func (e *UnknownTemplateError) ObservationDomainId() uint32 {
+	return e.TemplateKey.ObservationDomainId
+}
+
+// TemplateId returns the id of the missing template.
+func (e *UnknownTemplateError) TemplateId() uint16 {
+	return e.TemplateKey.TemplateId
+}
+
+// MalformedMessageError is returned for length or count fields that are inconsistent with the
+// remaining bytes, e.g., in template records or structured data types. MalformedMessageError
+// unwraps to ErrMalformedMessage.
+type MalformedMessageError struct {
+	// Offset is the number of bytes decoded from the start of the failing element, e.g., the
+	// record or list, before the inconsistency was detected. DecodeError.Offset provides the
+	// position of the enclosing set in the message.
+	Offset int
+	// Reason describes the inconsistency
+	Reason string
+}
+
+func (e *MalformedMessageError) Error() string {
+	return fmt.Sprintf("%s at offset %d, %s", ErrMalformedMessage, e.Offset, e.Reason)
+}
+
+func (e *MalformedMessageError) Unwrap() error {
+	return ErrMalformedMessage
+}
+
+// malformedMessage constructs a MalformedMessageError at offset with a formatted reason
+func malformedMessage(offset int, format string, args ...any) error {
+	return &MalformedMessageError{
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// TruncatedSetError is returned when a set's length field exceeds the remaining bytes
+// of the message.
+type TruncatedSetError struct {
+	// Id is the set id, i.e., the template id for data sets
+	Id uint16
+	// Length is the length of the set announced by its header, including the header
+	Length int
+	// Available is the number of bytes remaining in the message after the set header
+	Available int
+}
+
+func (e *TruncatedSetError) Error() string {
+	return fmt.Sprintf("set %d of length %d is truncated to %d bytes", e.Id, e.Length, e.Available+4)
+}
+
+// Unwrap returns ErrMalformedMessage, such that errors.Is(err, ErrMalformedMessage) holds for
+// truncated sets.
+func (e *TruncatedSetError) Unwrap() error {
+	return ErrMalformedMessage
+}
+
+// TruncatedMessageError is returned when the length announced by a message header exceeds the
+// bytes available for decoding, e.g., for IPFIX messages cut off in transport.
+type TruncatedMessageError struct {
+	// Length is the length of the message announced by its header, including the header
+	Length int
+	// Available is the number of bytes available for decoding the message, including the header
+	Available int
+}
+
+func (e *TruncatedMessageError) Error() string {
+	return fmt.Sprintf("message of length %d is truncated to %d bytes, %d bytes missing", e.Length, e.Available, e.Missing())
+}
+
+// Missing returns the number of bytes missing from the message
+func (e *TruncatedMessageError) Missing() int {
+	return e.Length - e.Available
+}
+
+// Unwrap returns ErrMalformedMessage, such that errors.Is(err, ErrMalformedMessage) holds for
+// truncated messages.
+func (e *TruncatedMessageError) Unwrap() error {
+	return ErrMalformedMessage
+}
+
+// TrailingBytesError is returned alongside a decoded message when the buffer contains more bytes
+// than announced by the message header. The trailing bytes remain in the buffer. Decoders
+// configured with DecoderOptions.ConcatenatedMessages decode them as another message instead.
+type TrailingBytesError struct {
+	// Length is the length of the decoded message announced by its header
+	Length int
+	// Trailing is the number of bytes following the message
+	Trailing int
+}
+
+func (e *TrailingBytesError) Error() string {
+	return fmt.Sprintf("%d trailing bytes following message of length %d", e.Trailing, e.Length)
+}
+
+// Unwrap returns ErrMalformedMessage, such that errors.Is(err, ErrMalformedMessage) holds for
+// trailing bytes.
+func (e *TrailingBytesError) Unwrap() error {
+	return ErrMalformedMessage
+}
+
+// TemplateRecordError is returned for template and options template records that cannot be
+// decoded, e.g., because their set ends before the record's last field. Decoders add none of
+// the templates of a set containing such a record to the template cache.
+type TemplateRecordError struct {
+	// TemplateId is the id of the failing template record, or 0 if the set ended before it
+	TemplateId uint16
+	// Err is the underlying cause
+	Err error
+}
+
+func (e *TemplateRecordError) Error() string {
+	return fmt.Sprintf("failed to decode template record %d, %v", e.TemplateId, e.Err)
+}
+
+func (e *TemplateRecordError) Unwrap() error {
+	return e.Err
+}
+
+// Decode stages reported in DecodeError.Stage
+const (
+	DecodeStageMessageHeader      string = "message header"
+	DecodeStageSetHeader          string = "set header"
+	DecodeStageTemplateSet        string = "template set"
+	DecodeStageOptionsTemplateSet string = "options template set"
+	DecodeStageDataSet            string = "data set"
+)
+
+// DecodeError is returned by Decoder.Decode and wraps the underlying cause of a decoding failure
+// with the location of the failure in the message. Use errors.As on the wrapped error to
+// distinguish, e.g., a missing template (UnknownTemplateError), which may be retried later,
+// from a malformed message (ErrMalformedMessage), which should be dropped:
+//
+//	msg, err := decoder.Decode(ctx, payload)
+//	var missing *UnknownTemplateError
+//	switch {
+//	case errors.As(err, &missing):
+//		// buffer the message until template missing.TemplateId() arrives
+//	case errors.Is(err, ErrMalformedMessage):
+//		// drop the message
+//	}
+type DecodeError struct {
+	// Stage is one of the DecodeStage* constants
+	Stage string
+	// SetIndex is the zero-based index of the set in the message, or -1 for the message header
+	SetIndex int
+	// TemplateKey is the key of the template used for decoding a data set, and only set
+	// for DecodeStageDataSet
+	TemplateKey TemplateKey
+	// Offset is the byte offset of the failed stage from the start of the message
+	Offset int
+	// Err is the underlying cause
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	switch {
+	case e.SetIndex < 0:
+		return fmt.Sprintf("failed to decode %s, %v", e.Stage, e.Err)
+	case e.Stage == DecodeStageDataSet:
+		return fmt.Sprintf("failed to decode %s %d of template %d at offset %d, %v", e.Stage, e.SetIndex, e.TemplateKey.TemplateId, e.Offset, e.Err)
+	default:
+		return fmt.Sprintf("failed to decode %s %d at offset %d, %v", e.Stage, e.SetIndex, e.Offset, e.Err)
+	}
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// unknownField wraps ErrUnknownField to provide the key of the field that is unknown
+func unknownField(enterpriseId uint32, id uint16) error {
+	return fmt.Errorf("%w %d in enterprise %d", ErrUnknownField, id, enterpriseId)
 }
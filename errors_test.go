@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	t.Run("missing template", func(t *testing.T) {
+		b := newTestDataMessage(300, 1)
+		binary.BigEndian.PutUint32(b[12:16], 42)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(b))
+
+		var missing *UnknownTemplateError
+		if !errors.As(err, &missing) {
+			t.Fatalf("expected UnknownTemplateError, got %v", err)
+		}
+		if missing.ObservationDomainId() != 42 || missing.TemplateId() != 300 {
+			t.Errorf("expected template (42,300) to be missing, got (%d,%d)", missing.ObservationDomainId(), missing.TemplateId())
+		}
+		if !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("expected error to be ErrTemplateNotFound, got %v", err)
+		}
+		if errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected missing template not to be ErrMalformedMessage, got %v", err)
+		}
+	})
+
+	t.Run("missing template in cache", func(t *testing.T) {
+		_, err := templateCache.Get(ctx, NewKey(1, 256))
+		var missing *UnknownTemplateError
+		if !errors.As(err, &missing) || missing.TemplateKey != NewKey(1, 256) {
+			t.Errorf("expected UnknownTemplateError for (1,256), got %v", err)
+		}
+	})
+
+	t.Run("truncated set", func(t *testing.T) {
+		b := newTestDataMessage(256, 2)
+		b = b[:len(b)-6]
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(b))
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+		if !errors.As(err, new(*TruncatedSetError)) {
+			t.Errorf("expected TruncatedSetError, got %v", err)
+		}
+	})
+
+	t.Run("set length shorter than header", func(t *testing.T) {
+		b := newTestDataMessage(256, 0)
+		binary.BigEndian.PutUint16(b[18:20], 2)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(b))
+		if !errors.Is(err, ErrMalformedMessage) || !errors.Is(err, ErrMalformedSet) {
+			t.Errorf("expected ErrMalformedSet wrapping ErrMalformedMessage, got %v", err)
+		}
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Offset != 16 {
+			t.Errorf("expected DecodeError at offset 16, got %v", err)
+		}
+	})
+
+	t.Run("unknown set id", func(t *testing.T) {
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(4, 0)))
+		if !errors.Is(err, ErrUnknownSetId) {
+			t.Errorf("expected ErrUnknownSetId, got %v", err)
+		}
+		if !errors.Is(err, ErrUnknownFlowId) {
+			t.Errorf("expected deprecated ErrUnknownFlowId to match, got %v", err)
+		}
+	})
+
+	t.Run("zero field count in template record", func(t *testing.T) {
+		tr := &TemplateRecord{}
+		_, err := tr.Decode(bytes.NewBuffer([]byte{1, 0, 0, 0}))
+
+		var malformed *MalformedMessageError
+		if !errors.As(err, &malformed) {
+			t.Fatalf("expected MalformedMessageError, got %v", err)
+		}
+		if malformed.Offset != 4 {
+			t.Errorf("expected offset 4, got %d", malformed.Offset)
+		}
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+	})
+
+	t.Run("malformed sub template length", func(t *testing.T) {
+		// semantic, then a sub template header announcing less than its own header
+		list := &SubTemplateMultiList{length: 5, templateManager: templateCache}
+		_, err := list.Decode(bytes.NewBuffer([]byte{byte(SemanticAllOf), 1, 0, 0, 2}))
+
+		var malformed *MalformedMessageError
+		if !errors.As(err, &malformed) {
+			t.Fatalf("expected MalformedMessageError, got %v", err)
+		}
+		if malformed.Offset != 5 {
+			t.Errorf("expected offset 5, got %d", malformed.Offset)
+		}
+	})
+
+	t.Run("sub template list shorter than header", func(t *testing.T) {
+		list := &SubTemplateList{length: 2, templateManager: templateCache}
+		_, err := list.Decode(bytes.NewBuffer([]byte{byte(SemanticAllOf), 1}))
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("expected ErrMalformedMessage, got %v", err)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := fieldCache.Get(ctx, NewFieldKey(0, 32767))
+		if !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("expected ErrFieldNotFound, got %v", err)
+		}
+	})
+}
@@ -44,7 +44,7 @@ func Example_collectorTCP() {
 	}()
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 
@@ -0,0 +1,38 @@
+package ipfix_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zoomoid/go-ipfix"
+)
+
+// Collect IPFIX messages with a Collector, which replaces the hand-wired listener, caches, and decoder
+// of the UDP and TCP collector examples.
+func Example_collector() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	collector := ipfix.NewCollector(ipfix.CollectorOptions{
+		Transport: ipfix.TransportUDP,
+		BindAddr:  "[::]:4739",
+		Workers:   4,
+	})
+	if err := collector.Register(prometheus.DefaultRegisterer); err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		for envelope := range collector.Records() {
+			log.Printf("%s (%d): %s", envelope.Exporter, envelope.ObservationDomainId, envelope.Record.String())
+		}
+	}()
+
+	if err := collector.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
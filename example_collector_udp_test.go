@@ -40,7 +40,7 @@ func Example_collectorUDP() {
 	}()
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 
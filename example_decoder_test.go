@@ -26,7 +26,7 @@ func Example_decoder() {
 	go r.Start(ctx)
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 
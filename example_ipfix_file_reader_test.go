@@ -22,7 +22,7 @@ func Example_ipfixFileReader() {
 	go r.Start(ctx)
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 
@@ -54,7 +54,7 @@ func Example_readFull() {
 	}
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 	for _, rawMessage := range messages {
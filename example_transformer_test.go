@@ -33,7 +33,7 @@ func Example_transformerNormalizeRecords() {
 	go r.Start(ctx)
 
 	templateCache := ipfix.NewDefaultEphemeralCache()
-	fieldCache := ipfix.NewEphemeralFieldCache(templateCache)
+	fieldCache := ipfix.NewIANAFieldCache(templateCache)
 
 	decoder := ipfix.NewDecoder(templateCache, fieldCache, ipfix.DecoderOptions{OmitRFC5610Records: false})
 
@@ -0,0 +1,502 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxMessageLength is the maximum length of an IPFIX message as limited by the 16 bit length
+	// field of the message header
+	MaxMessageLength int = 0xFFFF
+
+	messageHeaderLength int = 16
+	setHeaderLength     int = 4
+)
+
+var (
+	// ErrTemplateNotDefined is returned by ExportSession when adding a record for a template
+	// that was not defined with DefineTemplate before
+	ErrTemplateNotDefined error = errors.New("template not defined")
+	// ErrRecordTooLarge is returned by ExportSession when adding a single record that does not fit
+	// into an IPFIX message, and by SplitMessage for such records
+	ErrRecordTooLarge error = errors.New("record too large for IPFIX message")
+	// ErrMessageTooLarge is returned by Message.Encode and Set.Encode for messages and sets whose
+	// length exceeds MaxMessageLength, which cannot be represented in their length fields. When
+	// reading messages, e.g., with ReadMessageWithLimit, it is returned together with
+	// ErrInvalidMessageHeader for headers declaring a length beyond the maximum accepted.
+	ErrMessageTooLarge error = errors.New("message too large")
+)
+
+// ExportSession builds IPFIX messages from templates and values instead of hand-assembling
+// Message, Set, and record structures. Templates are defined once with DefineTemplate and
+// exported in a template set before the first data record using them. Records are added with
+// AddRecord or AddRecordValues and packed into messages on Flush, which splits records into
+// multiple messages if they exceed the maximum message length. Sequence numbers and export time
+// are set automatically.
+//
+// ExportSession is safe for concurrent use.
+type ExportSession struct {
+	observationDomainId uint32
+
+	// templateCache, if set, receives all templates defined in the session
+	templateCache TemplateCache
+
+	mu sync.Mutex
+	// sequenceNumber is the number of data records exported in the session so far, modulo 2^32,
+	// as per RFC 7011, Section 3.1
+	sequenceNumber uint32
+	templates      map[uint16]*Template
+	// exported contains the ids of templates that were already queued for export
+	exported map[uint16]bool
+	// pending contains template and data records in the order they were added
+	pending []pendingRecord
+
+	// now returns the export time of messages, overridden in tests
+	now func() time.Time
+}
+
+// pendingRecord is either a template record or a data record queued for export
+type pendingRecord struct {
+	// setId is IPFIX for template records and the template id for data records
+	setId uint16
+	// isData is set for data records, which count towards the sequence number
+	isData bool
+
+	// encoded is the record's encoding, which is validated when the record is added
+	encoded []byte
+}
+
+// NewExportSession creates a new session exporting messages in the given observation domain
+func NewExportSession(observationDomainId uint32) *ExportSession {
+	return &ExportSession{
+		observationDomainId: observationDomainId,
+		templates:           make(map[uint16]*Template),
+		exported:            make(map[uint16]bool),
+		pending:             make([]pendingRecord, 0),
+		now:                 time.Now,
+	}
+}
+
+// WithTemplateCache makes the session add all templates defined with DefineTemplate to c, e.g.,
+// for sharing them with a decoder or persisting them.
+func (s *ExportSession) WithTemplateCache(c TemplateCache) *ExportSession {
+	s.templateCache = c
+	return s
+}
+
+// DefineTemplate registers a template of the given fields. The fields are used as prototypes for
+// data records and are best created with a FieldBuilder from a FieldCache. Redefining a template
+// with an already defined id causes the new template to be exported again before its next usage.
+func (s *ExportSession) DefineTemplate(ctx context.Context, id uint16, fields ...Field) error {
+	if id < 256 {
+		return fmt.Errorf("failed to define template %d, template ids must be at least 256", id)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("failed to define template %d, template must contain at least one field", id)
+	}
+
+	template := &Template{
+		TemplateMetadata: &TemplateMetadata{
+			TemplateId:          id,
+			ObservationDomainId: s.observationDomainId,
+			CreationTimestamp:   time.Now(),
+		},
+		Record: &TemplateRecord{
+			TemplateId: id,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		},
+	}
+
+	if s.templateCache != nil {
+		err := s.templateCache.Add(ctx, NewKey(s.observationDomainId, id), template)
+		if err != nil {
+			return fmt.Errorf("failed to add template %d to cache, %w", id, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[id] = template
+	delete(s.exported, id)
+	return nil
+}
+
+// AddRecord queues a data record of the template with the given id. values maps field names
+// of the template to values. Fields of the template without value are exported with their
+// zero value.
+func (s *ExportSession) AddRecord(templateId uint16, values map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, ok := s.templates[templateId]
+	if !ok {
+		return fmt.Errorf("failed to add record, %w: %d", ErrTemplateNotDefined, templateId)
+	}
+	tr := template.Record.(*TemplateRecord)
+
+	fields := make([]Field, 0, len(tr.Fields))
+	matched := 0
+	for _, tf := range tr.Fields {
+		f := tf.Clone()
+		if v, ok := values[tf.Name()]; ok {
+			if _, err := f.SetValueE(v); err != nil {
+				return fmt.Errorf("failed to add record of template %d, %w", templateId, err)
+			}
+			matched++
+		}
+		fields = append(fields, f)
+	}
+	if matched != len(values) {
+		return fmt.Errorf("failed to add record of template %d, values contain fields not in template", templateId)
+	}
+
+	return s.enqueue(template, fields)
+}
+
+// AddRecordValues queues a data record of the template with the given id. values are assigned to
+// the fields of the template in order, and therefore must contain a value for every field.
+func (s *ExportSession) AddRecordValues(templateId uint16, values ...any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, ok := s.templates[templateId]
+	if !ok {
+		return fmt.Errorf("failed to add record, %w: %d", ErrTemplateNotDefined, templateId)
+	}
+	tr := template.Record.(*TemplateRecord)
+	if len(values) != len(tr.Fields) {
+		return fmt.Errorf("failed to add record of template %d, expected %d values, got %d", templateId, len(tr.Fields), len(values))
+	}
+
+	fields := make([]Field, 0, len(tr.Fields))
+	for i, tf := range tr.Fields {
+		f := tf.Clone()
+		if _, err := f.SetValueE(values[i]); err != nil {
+			return fmt.Errorf("failed to add record of template %d, %w", templateId, err)
+		}
+		fields = append(fields, f)
+	}
+
+	return s.enqueue(template, fields)
+}
+
+// enqueue appends a data record of fields to the pending records, preceded by its template
+// if the template was not yet exported. The records are encoded right away, such that a record
+// that cannot be encoded is rejected instead of failing every subsequent Flush. s.mu must be held.
+func (s *ExportSession) enqueue(template *Template, fields []Field) error {
+	tr := template.Record.(*TemplateRecord)
+	dr := &DataRecord{
+		TemplateId: tr.TemplateId,
+		FieldCount: uint16(len(fields)),
+		Fields:     fields,
+	}
+	data, err := encodePendingRecord(tr.TemplateId, dr.With(template).EncodeStrict)
+	if err != nil {
+		return fmt.Errorf("failed to add record of template %d, %w", tr.TemplateId, err)
+	}
+
+	if !s.exported[tr.TemplateId] {
+		encoded, err := encodePendingRecord(IPFIX, tr.Encode)
+		if err != nil {
+			return fmt.Errorf("failed to add template %d, %w", tr.TemplateId, err)
+		}
+		s.pending = append(s.pending, pendingRecord{
+			setId:   IPFIX,
+			encoded: encoded,
+		})
+		s.exported[tr.TemplateId] = true
+	}
+	s.pending = append(s.pending, pendingRecord{
+		setId:   tr.TemplateId,
+		isData:  true,
+		encoded: data,
+	})
+	return nil
+}
+
+// encodePendingRecord encodes a record of the given set id with encode and checks that it fits
+// into a message
+func encodePendingRecord(setId uint16, encode func(io.Writer) (int, error)) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if _, err := encode(b); err != nil {
+		return nil, err
+	}
+	if messageHeaderLength+setHeaderLength+b.Len() > MaxMessageLength {
+		return nil, fmt.Errorf("%w: set %d, %d bytes", ErrRecordTooLarge, setId, b.Len())
+	}
+	return b.Bytes(), nil
+}
+
+// Flush writes all pending records to w, packed into as few messages as possible. Consecutive
+// records of the same set id are grouped into a single set. Messages exceeding MaxMessageLength
+// are split into multiple messages. Flush returns the number of bytes written.
+//
+// Records are validated when they are added, so Flush only fails if writing to w fails, in which
+// case the pending records are kept.
+func (s *ExportSession) Flush(w io.Writer) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return 0, nil
+	}
+
+	exportTime := uint32(s.now().Unix())
+	sequenceNumber := s.sequenceNumber
+
+	out := &bytes.Buffer{}
+	msg := &exportMessage{}
+	for _, r := range s.pending {
+		if !msg.fits(r.setId, len(r.encoded)) {
+			msg.encode(out, exportTime, sequenceNumber, s.observationDomainId)
+			sequenceNumber += msg.dataRecords
+			msg = &exportMessage{}
+		}
+		msg.add(r.setId, r.encoded, r.isData)
+	}
+	msg.encode(out, exportTime, sequenceNumber, s.observationDomainId)
+	sequenceNumber += msg.dataRecords
+
+	n, err := w.Write(out.Bytes())
+	if err != nil {
+		return n, fmt.Errorf("failed to write messages, %w", err)
+	}
+	s.sequenceNumber = sequenceNumber
+	s.pending = s.pending[:0]
+	return n, nil
+}
+
+// exportMessage accumulates encoded records into sets of a single message
+type exportMessage struct {
+	sets        []exportSet
+	length      int
+	dataRecords uint32
+}
+
+type exportSet struct {
+	id      uint16
+	records []byte
+}
+
+// fits returns true if a record of length n of the given set id can be added to the message
+func (m *exportMessage) fits(setId uint16, n int) bool {
+	length := m.length
+	if length == 0 {
+		length = messageHeaderLength
+	}
+	if len(m.sets) == 0 || m.sets[len(m.sets)-1].id != setId {
+		length += setHeaderLength
+	}
+	return length+n <= MaxMessageLength
+}
+
+func (m *exportMessage) add(setId uint16, record []byte, isData bool) {
+	if m.length == 0 {
+		m.length = messageHeaderLength
+	}
+	if len(m.sets) == 0 || m.sets[len(m.sets)-1].id != setId {
+		m.sets = append(m.sets, exportSet{id: setId})
+		m.length += setHeaderLength
+	}
+	last := &m.sets[len(m.sets)-1]
+	last.records = append(last.records, record...)
+	m.length += len(record)
+	if isData {
+		m.dataRecords++
+	}
+}
+
+func (m *exportMessage) encode(w *bytes.Buffer, exportTime uint32, sequenceNumber uint32, observationDomainId uint32) {
+	if len(m.sets) == 0 {
+		return
+	}
+	b := make([]byte, 0, m.length)
+	b = binary.BigEndian.AppendUint16(b, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(m.length))
+	b = binary.BigEndian.AppendUint32(b, exportTime)
+	b = binary.BigEndian.AppendUint32(b, sequenceNumber)
+	b = binary.BigEndian.AppendUint32(b, observationDomainId)
+	for _, set := range m.sets {
+		b = binary.BigEndian.AppendUint16(b, set.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(setHeaderLength+len(set.records)))
+		b = append(b, set.records...)
+	}
+	w.Write(b)
+}
+
+// SplitMessage repacks the records of msg into as many messages of at most maxLength bytes as
+// needed, e.g., for re-exporting messages whose records grew beyond MaxMessageLength. maxLength
+// is capped at MaxMessageLength, and defaults to it if not positive.
+//
+// Template and options template records are moved to the first messages, before any data
+// record, such that collectors learn templates before their usage. The order of records is
+// otherwise preserved, and consecutive records of the same set id are grouped into a single set.
+// Records are never split, records that do not fit into a message of maxLength on their own
+// fail with ErrRecordTooLarge. Sets without records are omitted.
+//
+// The first message carries the sequence number of msg, and the sequence numbers of subsequent
+// messages are incremented by the number of data records in the preceding messages, as per
+// RFC 7011. Records are shared with msg, not copied.
+func SplitMessage(msg *Message, maxLength int) ([]*Message, error) {
+	if maxLength <= 0 || maxLength > MaxMessageLength {
+		maxLength = MaxMessageLength
+	}
+
+	templates := make([]splitRecord, 0)
+	data := make([]splitRecord, 0)
+	for i, s := range msg.Sets {
+		switch ss := s.Set.(type) {
+		case *TemplateSet:
+			for j := range ss.Records {
+				r, err := newSplitRecord(s.Id, &ss.Records[j])
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode template record %d of set %d, %w", j, i, err)
+				}
+				templates = append(templates, r)
+			}
+		case *OptionsTemplateSet:
+			for j := range ss.Records {
+				r, err := newSplitRecord(s.Id, &ss.Records[j])
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode options template record %d of set %d, %w", j, i, err)
+				}
+				templates = append(templates, r)
+			}
+		case *DataSet:
+			for j := range ss.Records {
+				r, err := newSplitRecord(s.Id, &ss.Records[j])
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode data record %d of set %d, %w", j, i, err)
+				}
+				data = append(data, r)
+			}
+		}
+	}
+
+	msgs := make([]*Message, 0, 1)
+	sequenceNumber := msg.SequenceNumber
+	current := &Message{}
+	var dataRecords uint32
+	flush := func() {
+		if len(current.Sets) == 0 {
+			return
+		}
+		msgs = append(msgs, current)
+		sequenceNumber += dataRecords
+		current, dataRecords = &Message{}, 0
+	}
+
+	for _, r := range append(templates, data...) {
+		if messageHeaderLength+setHeaderLength+r.length > maxLength {
+			return nil, fmt.Errorf("%w: set %d, %d bytes", ErrRecordTooLarge, r.setId, r.length)
+		}
+		newSet := len(current.Sets) == 0 || current.Sets[len(current.Sets)-1].Id != r.setId
+		length := int(current.Length) + r.length
+		if newSet {
+			length += setHeaderLength
+		}
+		if len(current.Sets) > 0 && length > maxLength {
+			flush()
+			newSet = true
+		}
+		if len(current.Sets) == 0 {
+			current = &Message{
+				Version:             msg.Version,
+				Length:              uint16(messageHeaderLength),
+				ExportTime:          msg.ExportTime,
+				SequenceNumber:      sequenceNumber,
+				ObservationDomainId: msg.ObservationDomainId,
+			}
+		}
+		if newSet {
+			current.Sets = append(current.Sets, r.newSet())
+			current.Length += uint16(setHeaderLength)
+		}
+		last := &current.Sets[len(current.Sets)-1]
+		r.appendTo(last)
+		last.Length += uint16(r.length)
+		current.Length += uint16(r.length)
+		if r.data != nil {
+			dataRecords++
+		}
+	}
+	flush()
+	return msgs, nil
+}
+
+// splitRecord is a record of any set, with the length of its encoding
+type splitRecord struct {
+	setId  uint16
+	length int
+
+	template        *TemplateRecord
+	optionsTemplate *OptionsTemplateRecord
+	data            *DataRecord
+}
+
+func newSplitRecord(setId uint16, record interface{ Encode(io.Writer) (int, error) }) (splitRecord, error) {
+	n, err := record.Encode(io.Discard)
+	if err != nil {
+		return splitRecord{}, err
+	}
+	r := splitRecord{setId: setId, length: n}
+	switch rr := record.(type) {
+	case *TemplateRecord:
+		r.template = rr
+	case *OptionsTemplateRecord:
+		r.optionsTemplate = rr
+	case *DataRecord:
+		r.data = rr
+	}
+	return r, nil
+}
+
+// newSet creates an empty set of the record's kind
+func (r splitRecord) newSet() Set {
+	s := Set{SetHeader: SetHeader{Id: r.setId, Length: uint16(setHeaderLength)}}
+	switch {
+	case r.template != nil:
+		s.Kind, s.Set = KindTemplateSet, &TemplateSet{Records: make([]TemplateRecord, 0)}
+	case r.optionsTemplate != nil:
+		s.Kind, s.Set = KindOptionsTemplateSet, &OptionsTemplateSet{Records: make([]OptionsTemplateRecord, 0)}
+	default:
+		s.Kind, s.Set = KindDataSet, &DataSet{Records: make([]DataRecord, 0)}
+	}
+	return s
+}
+
+// appendTo appends the record to the set s created by newSet
+func (r splitRecord) appendTo(s *Set) {
+	switch ss := s.Set.(type) {
+	case *TemplateSet:
+		ss.Records = append(ss.Records, *r.template)
+	case *OptionsTemplateSet:
+		ss.Records = append(ss.Records, *r.optionsTemplate)
+	case *DataSet:
+		ss.Records = append(ss.Records, *r.data)
+	}
+}
@@ -0,0 +1,401 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// splitMessages splits a stream of IPFIX messages by their length fields
+func splitMessages(t *testing.T, b []byte) [][]byte {
+	msgs := make([][]byte, 0)
+	for len(b) > 0 {
+		if len(b) < 16 {
+			t.Fatalf("expected message header, got %d bytes", len(b))
+		}
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		msgs = append(msgs, b[:l])
+		b = b[l:]
+	}
+	return msgs
+}
+
+func TestExportSession(t *testing.T) {
+	ctx := context.Background()
+
+	// newTestSession creates a session with the template of newTestTemplate defined at id 256,
+	// and a template cache shared with the session for downstream decoding
+	newTestSession := func(t *testing.T) (*ExportSession, TemplateCache, FieldCache) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		template := newTestTemplate(t, fieldCache, 256)
+
+		s := NewExportSession(1).WithTemplateCache(templateCache)
+		s.now = func() time.Time { return time.Unix(1696161600, 0) }
+		err := s.DefineTemplate(ctx, 256, template.Record.(*TemplateRecord).Fields...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, templateCache, fieldCache
+	}
+
+	t.Run("decoder consumes exported messages", func(t *testing.T) {
+		s, templateCache, fieldCache := newTestSession(t)
+
+		err := s.AddRecord(256, map[string]any{
+			"sourceIPv4Address":   "192.0.2.1",
+			"sourceTransportPort": 4739,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.AddRecordValues(256, net.ParseIP("198.51.100.7"), uint16(4740))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := &bytes.Buffer{}
+		if _, err := s.Flush(out); err != nil {
+			t.Fatal(err)
+		}
+		msgs := splitMessages(t, out.Bytes())
+		if len(msgs) != 1 {
+			t.Fatalf("expected a single message, got %d", len(msgs))
+		}
+
+		decoder := NewDecoder(templateCache, fieldCache)
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(msgs[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ObservationDomainId != 1 || msg.ExportTime != 1696161600 || msg.SequenceNumber != 0 {
+			t.Errorf("unexpected message header %+v", msg)
+		}
+		if len(msg.Sets) != 2 || msg.Sets[0].Kind != KindTemplateSet || msg.Sets[1].Kind != KindDataSet {
+			t.Fatalf("expected a template set followed by a data set, got %v", msg.Sets)
+		}
+		records := msg.Sets[1].Set.(*DataSet).Records
+		if len(records) != 2 {
+			t.Fatalf("expected 2 data records, got %d", len(records))
+		}
+		for i, expected := range []struct {
+			address string
+			port    uint16
+		}{{"192.0.2.1", 4739}, {"198.51.100.7", 4740}} {
+			address := records[i].Fields[0].Value().Value().(net.IP)
+			port := records[i].Fields[1].Value().Value().(uint16)
+			if address.String() != expected.address || port != expected.port {
+				t.Errorf("expected record %d to be %s:%d, got %s:%d", i, expected.address, expected.port, address, port)
+			}
+		}
+
+		// templates are exported once, and sequence numbers count the data records exported before
+		if err := s.AddRecordValues(256, "192.0.2.2", 1); err != nil {
+			t.Fatal(err)
+		}
+		out.Reset()
+		if _, err := s.Flush(out); err != nil {
+			t.Fatal(err)
+		}
+		msg, err = decoder.Decode(ctx, bytes.NewBuffer(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Sets) != 1 || msg.Sets[0].Kind != KindDataSet {
+			t.Errorf("expected template not to be exported again, got %v", msg.Sets)
+		}
+		if msg.SequenceNumber != 2 {
+			t.Errorf("expected sequence number 2, got %d", msg.SequenceNumber)
+		}
+	})
+
+	t.Run("split at message size boundary", func(t *testing.T) {
+		// message header, template set with a single template record of two fields, and data set header
+		overhead := 16 + (4 + 4 + 2*4) + 4
+		recordsPerMessage := (MaxMessageLength - overhead) / 6
+
+		for _, tc := range []struct {
+			records  int
+			messages int
+		}{{recordsPerMessage, 1}, {recordsPerMessage + 1, 2}} {
+			s, _, _ := newTestSession(t)
+			for i := 0; i < tc.records; i++ {
+				if err := s.AddRecordValues(256, "192.0.2.1", i%65536); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := &bytes.Buffer{}
+			if _, err := s.Flush(out); err != nil {
+				t.Fatal(err)
+			}
+			msgs := splitMessages(t, out.Bytes())
+			if len(msgs) != tc.messages {
+				t.Fatalf("expected %d records to be split into %d messages, got %d", tc.records, tc.messages, len(msgs))
+			}
+			if l := len(msgs[0]); l != overhead+recordsPerMessage*6 {
+				t.Errorf("expected first message of %d bytes, got %d", overhead+recordsPerMessage*6, l)
+			}
+			if tc.messages == 2 {
+				if seq := binary.BigEndian.Uint32(msgs[1][8:12]); seq != uint32(recordsPerMessage) {
+					t.Errorf("expected sequence number %d of second message, got %d", recordsPerMessage, seq)
+				}
+				if l := len(msgs[1]); l != 16+4+6 {
+					t.Errorf("expected second message to contain a single record, got %d bytes", l)
+				}
+			}
+		}
+	})
+
+	t.Run("invalid records", func(t *testing.T) {
+		s, _, _ := newTestSession(t)
+		if err := s.AddRecordValues(300, 1); !errors.Is(err, ErrTemplateNotDefined) {
+			t.Errorf("expected ErrTemplateNotDefined, got %v", err)
+		}
+		if err := s.AddRecordValues(256, "192.0.2.1"); err == nil {
+			t.Error("expected error for missing values")
+		}
+		if err := s.AddRecord(256, map[string]any{"destinationTransportPort": 1}); err == nil {
+			t.Error("expected error for field not in template")
+		}
+		if err := s.AddRecordValues(256, "192.0.2.1", struct{}{}); err == nil {
+			t.Error("expected error for value of unsupported type")
+		}
+	})
+
+	t.Run("records that cannot be encoded are rejected", func(t *testing.T) {
+		s, templateCache, fieldCache := newTestSession(t)
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 314))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DefineTemplate(ctx, 258, fb.SetLength(VariableLength).Complete()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.AddRecordValues(258, make([]byte, MaxMessageLength)); !errors.Is(err, ErrRecordTooLarge) {
+			t.Errorf("expected %v, got %v", ErrRecordTooLarge, err)
+		}
+		if err := s.AddRecordValues(256, "192.0.2.1", uint16(4739)); err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if _, err := s.Flush(out); err != nil {
+			t.Fatalf("expected rejected record not to fail flushing, got %v", err)
+		}
+
+		decoded, err := NewDecoder(templateCache, fieldCache).Decode(ctx, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := 0
+		for _, set := range decoded.Sets {
+			switch set := set.Set.(type) {
+			case *TemplateSet:
+				for _, tr := range set.Records {
+					if tr.TemplateId == 258 {
+						t.Error("expected template of the rejected record not to be exported")
+					}
+				}
+			case *DataSet:
+				records += len(set.Records)
+			}
+		}
+		if records != 1 {
+			t.Errorf("expected only the accepted record to be exported, got %d records", records)
+		}
+	})
+}
+
+func TestSplitMessage(t *testing.T) {
+	ctx := context.Background()
+
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	template := newTestTemplate(t, fieldCache, 256)
+	if err := templateCache.Add(ctx, NewKey(0, 256), template); err != nil {
+		t.Fatal(err)
+	}
+	// template 258 consists of a single variable-length ipPayloadPacketSection
+	fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 314))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadTemplate := &Template{
+		TemplateMetadata: &TemplateMetadata{TemplateId: 258, CreationTimestamp: time.Now()},
+		Record:           &TemplateRecord{TemplateId: 258, FieldCount: 1, Fields: []Field{fb.SetLength(0xFFFF).Complete()}},
+	}
+	if err := templateCache.Add(ctx, NewKey(0, 258), payloadTemplate); err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	// payloadMessage creates a message of a single record of template 258, whose encoding is
+	// recordLength bytes long
+	payloadMessage := func(recordLength int) *Message {
+		// the payload is prefixed by the long form of the variable-length encoding
+		f := payloadTemplate.Record.(*TemplateRecord).Fields[0].Clone().SetValue(make([]byte, recordLength-3))
+		return &Message{
+			Version: 10,
+			Sets: []Set{{
+				SetHeader: SetHeader{Id: 258},
+				Kind:      KindDataSet,
+				Set:       &DataSet{Records: []DataRecord{{TemplateId: 258, FieldCount: 1, Fields: []Field{f}}}},
+			}},
+		}
+	}
+
+	t.Run("record filling a message exactly", func(t *testing.T) {
+		msgs, err := SplitMessage(payloadMessage(MaxMessageLength-messageHeaderLength-setHeaderLength), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(msgs))
+		}
+		if l := msgs[0].Length; l != 0xFFFF {
+			t.Errorf("expected message length %d, got %d", 0xFFFF, l)
+		}
+		b := &bytes.Buffer{}
+		if _, err := msgs[0].Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Len() != MaxMessageLength {
+			t.Errorf("expected %d encoded bytes, got %d", MaxMessageLength, b.Len())
+		}
+		decoded, err := decoder.Decode(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := decoded.Sets[0].Set.Length(); l != 1 {
+			t.Errorf("expected 1 decoded record, got %d", l)
+		}
+	})
+
+	t.Run("record too large for any message", func(t *testing.T) {
+		msg := payloadMessage(MaxMessageLength - messageHeaderLength - setHeaderLength + 1)
+		_, err := SplitMessage(msg, 0)
+		if !errors.Is(err, ErrRecordTooLarge) {
+			t.Errorf("expected %v, got %v", ErrRecordTooLarge, err)
+		}
+
+		b := &bytes.Buffer{}
+		if _, err := msg.Encode(b); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("expected encoding to fail with %v, got %v", ErrMessageTooLarge, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("expected nothing to be written, got %d bytes", b.Len())
+		}
+	})
+
+	t.Run("set too large", func(t *testing.T) {
+		half := payloadMessage(MaxMessageLength / 2)
+		records := half.Sets[0].Set.(*DataSet).Records
+		half.Sets[0].Set.(*DataSet).Records = append(records, records[0])
+
+		b := &bytes.Buffer{}
+		if _, err := half.Sets[0].Encode(b); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+		}
+		msgs, err := SplitMessage(half, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 2 {
+			t.Errorf("expected 2 messages, got %d", len(msgs))
+		}
+	})
+
+	t.Run("repacking records", func(t *testing.T) {
+		data, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataSet := data.Sets[0]
+		msg := &Message{
+			Version:             10,
+			ExportTime:          1696161600,
+			SequenceNumber:      100,
+			ObservationDomainId: 1,
+			Sets: []Set{
+				dataSet,
+				{
+					SetHeader: SetHeader{Id: IPFIX},
+					Kind:      KindTemplateSet,
+					Set:       &TemplateSet{Records: []TemplateRecord{*template.Record.(*TemplateRecord)}},
+				},
+				dataSet,
+			},
+		}
+
+		// messages fit four data records of 6 bytes in a single set
+		maxLength := messageHeaderLength + setHeaderLength + 4*6
+		msgs, err := SplitMessage(msg, maxLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 6 {
+			t.Errorf("expected 6 messages, got %d", len(msgs))
+		}
+
+		if k := msgs[0].Sets[0].Kind; k != KindTemplateSet {
+			t.Errorf("expected first set to be a template set, got %s", k)
+		}
+		sequenceNumber := msg.SequenceNumber
+		ports := make([]any, 0, 20)
+		for i, m := range msgs {
+			if m.SequenceNumber != sequenceNumber {
+				t.Errorf("expected sequence number %d of message %d, got %d", sequenceNumber, i, m.SequenceNumber)
+			}
+			if m.ExportTime != msg.ExportTime || m.ObservationDomainId != msg.ObservationDomainId {
+				t.Errorf("expected header of message %d to be copied, got %+v", i, m)
+			}
+			b := &bytes.Buffer{}
+			n, err := m.Encode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int(m.Length) || n > maxLength {
+				t.Errorf("expected message %d length %d to match %d encoded bytes within %d", i, m.Length, n, maxLength)
+			}
+			for j, s := range m.Sets {
+				if s.Kind == KindTemplateSet && (i != 0 || j != 0) {
+					t.Errorf("expected templates only in the first set, got set %d of message %d", j, i)
+				}
+				if ds, ok := s.Set.(*DataSet); ok {
+					sequenceNumber += uint32(len(ds.Records))
+					for _, dr := range ds.Records {
+						ports = append(ports, dr.Fields[1].Value().Value())
+					}
+				}
+			}
+		}
+		if len(ports) != 20 {
+			t.Fatalf("expected 20 data records, got %d", len(ports))
+		}
+		for i, p := range ports {
+			if p != uint16(i%10) {
+				t.Errorf("expected record %d to keep its order, got port %v", i, p)
+			}
+		}
+	})
+}
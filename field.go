@@ -17,23 +17,26 @@ limitations under the License.
 package ipfix
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/zoomoid/go-ipfix/iana/semantics"
+	"gopkg.in/yaml.v3"
 )
 
 type BidirectionalField interface {
-	// IsReversible returns true if the field's underlying information element is
-	// *not* contained in the list of irreversible information elements as per RFC
-	// 5103.
+	// Reversible returns true if the field's underlying information element is
+	// reversible as per the ReverseResolver registered for its PEN. For IANA IEs,
+	// these are all IEs *not* contained in the list of irreversible information
+	// elements as per RFC 5103.
 	//
-	// Note that this function is only practical for information elements described
-	// in RFC 5103, i.e., IEs assigned by IANA, because only for those, the semantics
-	// of reversal are well-defined by the RFC. Enterprise-specific IEs may choose
-	// to implement their own reversal semantics, as it is the case with e.g. CERT IEs
+	// Enterprise-specific IEs may implement their own reversal semantics, as it is
+	// the case with e.g. CERT IEs. IEs of PENs without a ReverseResolver are not
+	// reversible.
 	Reversible() bool
 
 	// Reversed returns the field's state with regards to RFC 5103, i.e., if the
@@ -63,9 +66,17 @@ type Field interface {
 	// Value returns the underlying data type
 	Value() DataType
 
-	// SetValue sets the value on the internal DataType stored in the field
+	// SetValue sets the value on the internal DataType stored in the field. SetValue panics for
+	// values of unsupported types or out of range of the data type.
 	SetValue(v any) Field
 
+	// SetValueE is the variant of SetValue returning an error instead of panicking
+	SetValueE(v any) (Field, error)
+
+	// RawBytes returns the bytes of the field's value as received, if the field was decoded with
+	// DecoderOptions.LazyFieldValues, and nil otherwise
+	RawBytes() []byte
+
 	// Type returns a string representation of the underlying DataType
 	Type() string
 
@@ -126,35 +137,143 @@ type Field interface {
 
 	json.Marshaler
 	json.Unmarshaler
+	yaml.Marshaler
+	yaml.Unmarshaler
 	fmt.Stringer
 }
 
 type consolidatedField struct {
-	Id   uint16 `json:"id"`
-	Name string `json:"name,omitempty"`
+	Id   uint16 `json:"id" yaml:"id"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
 
-	PEN uint32 `json:"pen"`
+	PEN uint32 `json:"pen" yaml:"pen"`
 
 	// Length contains the DataType's length in bytes. Notably, if the field is
 	// encoded with reduced length, this field captures the necessary information
 	// to reconstruct the field later on.
-	Length uint16 `json:"length"`
+	Length uint16 `json:"length" yaml:"length"`
 
 	// To reconstruct the original field type from a consolidated one
-	IsVariableLength bool `json:"is_variable_length,omitempty"`
+	IsVariableLength bool `json:"is_variable_length,omitempty" yaml:"isVariableLength,omitempty"`
 
-	ObservationDomainId uint32 `json:"observation_domain_id,omitempty"`
+	ObservationDomainId uint32 `json:"observation_domain_id,omitempty" yaml:"observationDomainId,omitempty"`
 
 	// Value interface{} `json:"value,omitempty"`
-	Value *json.RawMessage `json:"value,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty" yaml:"-"`
 
 	// Type is a serialized form of the DataType underlying the field. Note that
 	// this string representation is used for Restore(), however, additional
 	// information such as reduced-length encoding requires more information to be
 	// embedded
-	Type string `json:"type,omitempty"`
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	IsScope bool `json:"is_scope,omitempty" yaml:"isScope,omitempty"`
+
+	// Units, Semantics, and Description are only included by encoders with WithVerboseFields
+	// for fields with a prototype. They are purely informational and ignored by restore.
+	Units       string `json:"units,omitempty" yaml:"-"`
+	Semantics   string `json:"semantics,omitempty" yaml:"-"`
+	Description string `json:"description,omitempty" yaml:"-"`
+}
+
+// describe adds the metadata of the information element ie to the consolidated field
+func (cf *consolidatedField) describe(ie *InformationElement) {
+	if ie == nil {
+		return
+	}
+	if ie.Units != nil {
+		cf.Units = *ie.Units
+	}
+	cf.Semantics = ie.Semantics.String()
+	if ie.Description != nil {
+		cf.Description = *ie.Description
+	}
+}
+
+// yamlField is the YAML representation of a consolidatedField. The value of the field is
+// still produced by the DataType's JSON marshaller, but is embedded as a native YAML node
+// rather than as a string of JSON, such that the document stays readable.
+type yamlField struct {
+	consolidatedField `yaml:",inline"`
 
-	IsScope bool `json:"is_scope,omitempty"`
+	Value interface{} `yaml:"value,omitempty"`
+}
+
+// yaml converts the consolidated field into its YAML representation
+func (cf *consolidatedField) yaml() (*yamlField, error) {
+	yf := &yamlField{
+		consolidatedField: *cf,
+	}
+	if cf.Value != nil {
+		dec := json.NewDecoder(strings.NewReader(string(*cf.Value)))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("failed to convert value of field %s to YAML, %w", cf.Name, err)
+		}
+		yf.Value = fromJSONNumbers(v)
+	}
+	return yf, nil
+}
+
+// consolidated converts the YAML representation of a field back into a consolidatedField,
+// which can then be restored just like one read from JSON
+func (yf *yamlField) consolidated() (*consolidatedField, error) {
+	cf := yf.consolidatedField
+	if yf.Value != nil {
+		b, err := json.Marshal(yf.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert value of field %s from YAML, %w", cf.Name, err)
+		}
+		raw := json.RawMessage(b)
+		cf.Value = &raw
+	}
+	return &cf, nil
+}
+
+// restoreYAMLFields restores a list of fields read from YAML
+func restoreYAMLFields(yfs []yamlField, fieldManager FieldCache, templateManager TemplateCache) ([]Field, error) {
+	fs := make([]Field, 0, len(yfs))
+	for _, yf := range yfs {
+		cf, err := yf.consolidated()
+		if err != nil {
+			return nil, err
+		}
+		f, err := cf.restore(fieldManager, templateManager)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// fromJSONNumbers replaces all json.Numbers in v by integers where possible, and floats
+// otherwise, such that large unsigned integers keep their precision in YAML
+func fromJSONNumbers(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(vv.String(), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(vv.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := vv.Float64()
+		return f
+	case []interface{}:
+		for i, el := range vv {
+			vv[i] = fromJSONNumbers(el)
+		}
+		return vv
+	case map[string]interface{}:
+		for k, el := range vv {
+			vv[k] = fromJSONNumbers(el)
+		}
+		return vv
+	default:
+		return v
+	}
 }
 
 var dataTypesWithListSemantics map[string]struct{} = map[string]struct{}{
@@ -163,15 +282,26 @@ var dataTypesWithListSemantics map[string]struct{} = map[string]struct{}{
 	(&SubTemplateMultiList{}).Type(): {},
 }
 
+// dataTypeName returns the name of the data type of a consolidated field without the type of the
+// elements of basicLists, e.g., "basicList" for "basicList<unsigned16>"
+func dataTypeName(typ string) string {
+	name, _, _ := strings.Cut(typ, "<")
+	return name
+}
+
 // restore creates a Field from a consolidatedField again, by deciding whether to use an
 // underlying variable length or fixed length struct.
 // restore also recreates the constructor function from the type string left on the
-// consolidatedField, as well as restoring the internal value of a DataType
-func (cf *consolidatedField) restore(fieldManager FieldCache, templateManager TemplateCache) Field {
-	constr := LookupConstructor(cf.Type)
+// consolidatedField, as well as restoring the internal value of a DataType. Fields of unknown
+// data types cause an error wrapping ErrUnknownDataType.
+func (cf *consolidatedField) restore(fieldManager FieldCache, templateManager TemplateCache) (Field, error) {
+	typeName := dataTypeName(cf.Type)
+	constr, err := LookupConstructor(typeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore field %s, %w", cf.Name, err)
+	}
 
-	// construct an ad-hoc information element. We don't assume it belongs to any specific registry, that's
-	// why we omit lookups here
+	// construct an ad-hoc information element, unless the field cache knows the IE below
 	ie := &InformationElement{
 		Constructor: constr,
 	}
@@ -179,18 +309,33 @@ func (cf *consolidatedField) restore(fieldManager FieldCache, templateManager Te
 	ie.Name = cf.Name
 
 	var reverse bool
-	// Consolidating a reverse field sets the PEN to the PEN reserved for reverse fields
-	if strings.HasPrefix(cf.Name, "reverse") && cf.PEN == ReversePEN {
-		// reset PEN to the default IANA namespace, but preserve the information
-		// that the field is reversed in a separate variable
-		reverse = true
-		cf.PEN = 0
+	// Consolidating a reverse field sets the key of the reverse IE, e.g., the PEN reserved for reverse fields
+	if strings.HasPrefix(cf.Name, "reverse") {
+		if forward, ok := forwardKey(cf.PEN, cf.Id); ok {
+			// reset the key to the forward IE, e.g., the default IANA namespace, but preserve the
+			// information that the field is reversed in a separate variable
+			reverse = true
+			cf.PEN, cf.Id = forward.EnterpriseId, forward.Id
+			ie.Name = forwardName(cf.Name)
+		}
 	}
 
 	ie.Id = cf.Id
 
+	// prefer the IE of the field cache, e.g., for its semantics and units, as long as its data type
+	// matches the restored value
+	if fieldManager != nil {
+		fb, err := fieldManager.GetBuilder(context.TODO(), NewFieldKey(cf.PEN, cf.Id))
+		if err == nil && fb != nil && !fb.IsUnknown() {
+			if known := fb.GetIE(); known != nil && known.Constructor != nil && dataTypeName(known.Constructor().Type()) == typeName {
+				prototype := *known
+				ie = &prototype
+			}
+		}
+	}
+
 	// if DataType type is inherently a list type...
-	if _, isListSemantic := dataTypesWithListSemantics[cf.Type]; isListSemantic {
+	if _, isListSemantic := dataTypesWithListSemantics[typeName]; isListSemantic {
 		ie.Semantics = semantics.List
 	}
 
@@ -203,18 +348,18 @@ func (cf *consolidatedField) restore(fieldManager FieldCache, templateManager Te
 		SetTemplateManager(templateManager)
 
 	f := builder.Complete()
+	if cf.IsScope {
+		f.SetScoped()
+	}
 
-	// TODO(zoomoid): this does not check the sanity of the values! currently,
-	// when unmarshalling a basicList, this will not work because json.Unmarshal
-	// in the Field.UnmarshalJSON unwraps the JSON to []interface{}, but this is
-	// not assignable to the value field of BasicList, as it expects Fields.
+	// structured data types restore their elements and records with the caches passed to the
+	// builder above, and return errors of nested fields instead of assigning raw JSON values
 	if v := cf.Value; v != nil {
 		err := f.Value().UnmarshalJSON(*v)
 		if err != nil {
-			// TODO(zoomoid): panic behaviour of SetValue
-			panic(err)
+			return nil, fmt.Errorf("failed to restore value of field %s, %w", cf.Name, err)
 		}
 	}
 
-	return f
+	return f, nil
 }
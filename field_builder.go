@@ -38,6 +38,9 @@ type FieldBuilder struct {
 
 	fieldManager    FieldCache
 	templateManager TemplateCache
+
+	// unknown is set for builders of fields not known to a FieldCache
+	unknown bool
 }
 
 var _ json.Marshaler = &FieldBuilder{}
@@ -49,10 +52,27 @@ func NewFieldBuilder(ie *InformationElement) *FieldBuilder {
 	}
 }
 
+// clone returns a copy of the builder with its own prototype. The setters of the copy, some of which
+// modify the prototype in place, therefore do not affect b.
+func (b *FieldBuilder) clone() *FieldBuilder {
+	c := *b
+	if b.prototype != nil {
+		prototype := *b.prototype
+		c.prototype = &prototype
+	}
+	return &c
+}
+
 func (b *FieldBuilder) GetIE() *InformationElement {
 	return b.prototype
 }
 
+// IsUnknown returns true if the builder was created by NewUnknownFieldBuilder, i.e., for a
+// field not known to a FieldCache
+func (b *FieldBuilder) IsUnknown() bool {
+	return b.unknown
+}
+
 func (b *FieldBuilder) SetObservationDomain(id uint32) *FieldBuilder {
 	b.observationDomainId = id
 	return b
@@ -108,6 +128,7 @@ func (b *FieldBuilder) Complete() Field {
 			fieldManager:        b.fieldManager,
 			templateManager:     b.templateManager,
 			prototype:           b.prototype,
+			unknown:             b.unknown,
 		}
 	} else {
 		return &FixedLengthField{
@@ -120,6 +141,7 @@ func (b *FieldBuilder) Complete() Field {
 			fieldManager:        b.fieldManager,
 			templateManager:     b.templateManager,
 			prototype:           b.prototype,
+			unknown:             b.unknown,
 		}
 	}
 }
@@ -162,7 +184,13 @@ func (b *dataTypeBuilder) SetTemplateCache(templateCache TemplateCache) *dataTyp
 }
 
 func (b *dataTypeBuilder) Complete() DataTypeConstructor {
-	decoratedConstructor := b.constructor().WithLength(b.length)
+	constructor := b.constructor
+	if constructor == nil {
+		// information elements without a data type, e.g., reserved ones, are decoded as
+		// opaque octet arrays like unknown fields
+		constructor = NewOctetArray
+	}
+	decoratedConstructor := constructor().WithLength(b.length)
 
 	// ListType and TemplateListTypes are decorated additionally with FieldCache or TemplateCache
 	switch lc := decoratedConstructor().(type) {
@@ -24,6 +24,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -38,7 +39,7 @@ type FieldCache interface {
 	// GetBuilder retrieves a field builder instance from the cache for creating
 	// fields during decoding.
 	//
-	// If the field is not found in the cache, a new UnassignedFieldBuilder is
+	// If the field is not found in the cache, a new UnknownFieldBuilder is
 	// returned with the information embedded in the FieldKey
 	//
 	// If an error occurs during retrieval of the field, an error is returned,
@@ -97,34 +98,43 @@ func (k *FieldKey) String() string {
 	return fmt.Sprintf("%d%s%d", k.EnterpriseId, fieldKeySeparator, k.Id)
 }
 
+// fieldKeyLess orders field keys by enterprise id first, and field id second
+func fieldKeyLess(a, b FieldKey) bool {
+	if a.EnterpriseId != b.EnterpriseId {
+		return a.EnterpriseId < b.EnterpriseId
+	}
+	return a.Id < b.Id
+}
+
+func fieldKeyString(k FieldKey) string {
+	return k.String()
+}
+
 func (k *FieldKey) MarshalText() (text []byte, err error) {
 	text = []byte(k.String())
 	return
 }
 
+// Unmarshal parses text of the format of String, i.e., "<enterprise id>:<field id>". Ids out of
+// range of their integer types return an error wrapping strconv.ErrRange.
 func (k *FieldKey) Unmarshal(text string) (err error) {
-	var enterpriseId uint32
-	var fieldId uint16
-
 	key := strings.Split(text, fieldKeySeparator)
 	if len(key) != 2 {
-		return errors.New("template key format is invalid")
+		return fmt.Errorf("field key %q is invalid, expected <enterprise id>%s<field id>", text, fieldKeySeparator)
 	}
 
-	if v, err := strconv.ParseUint(key[0], 10, 64); err != nil {
-		return fmt.Errorf("observation domain id is invalid, %w", err)
-	} else {
-		enterpriseId = uint32(v)
+	enterpriseId, err := strconv.ParseUint(key[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("enterprise id of field key %q is invalid, %w", text, err)
 	}
-	if v, err := strconv.ParseUint(key[1], 10, 64); err != nil {
-		return fmt.Errorf("template id is invalid, %w", err)
-	} else {
-		fieldId = uint16(v)
+	fieldId, err := strconv.ParseUint(key[1], 10, 16)
+	if err != nil {
+		return fmt.Errorf("field id of field key %q is invalid, %w", text, err)
 	}
 
-	k.EnterpriseId = enterpriseId
-	k.Id = fieldId
-	return
+	k.EnterpriseId = uint32(enterpriseId)
+	k.Id = uint16(fieldId)
+	return nil
 }
 
 func (k *FieldKey) UnmarshalText(text []byte) (err error) {
@@ -134,111 +144,233 @@ func (k *FieldKey) UnmarshalText(text []byte) (err error) {
 type EphemeralFieldCache struct {
 	templateManager TemplateCache
 
-	mu *sync.RWMutex
+	// strict rejects information elements in Add that conflict with elements in the cache
+	strict bool
+
+	// mu serializes writers, which copy the current snapshot and publish the modified copy
+	mu *sync.Mutex
+
+	// snapshot is the current, immutable state of the cache, which readers load without locking
+	snapshot atomic.Pointer[fieldCacheSnapshot]
+}
 
+// fieldCacheSnapshot is an immutable state of an EphemeralFieldCache. Neither the maps nor the
+// builders and prototypes therein are modified after the snapshot is published.
+type fieldCacheSnapshot struct {
 	fields map[FieldKey]*FieldBuilder
 
 	prototypes map[FieldKey]*InformationElement
 }
 
 var _ json.Marshaler = &EphemeralFieldCache{}
+var _ json.Unmarshaler = &EphemeralFieldCache{}
+
+// FieldCacheOption configures an EphemeralFieldCache created with NewEphemeralFieldCache or
+// NewIANAFieldCache
+type FieldCacheOption func(*EphemeralFieldCache)
+
+// WithStrictFieldDefinitions makes Add reject information elements that conflict with an element
+// of the same id and PEN already in the cache, e.g., an element learned from RFC 5610 options
+// records with a different data type than the element of a loaded registry. Add returns an error
+// wrapping ErrConflictingField for such elements and keeps the existing element. Without this
+// option, conflicting elements replace the existing ones and are logged.
+func WithStrictFieldDefinitions() FieldCacheOption {
+	return func(fm *EphemeralFieldCache) {
+		fm.strict = true
+	}
+}
+
+func NewEphemeralFieldCache(templateManager TemplateCache, opts ...FieldCacheOption) FieldCache {
+	return newEphemeralFieldCache(templateManager, opts...)
+}
 
-func NewEphemeralFieldCache(templateManager TemplateCache) FieldCache {
+func newEphemeralFieldCache(templateManager TemplateCache, opts ...FieldCacheOption) *EphemeralFieldCache {
 	fm := &EphemeralFieldCache{
-		mu: &sync.RWMutex{},
-		// initialize an empty map of field builders
-		fields:          map[FieldKey]*FieldBuilder{},
-		prototypes:      map[FieldKey]*InformationElement{},
+		mu:              &sync.Mutex{},
 		templateManager: templateManager,
 	}
-
+	for _, opt := range opts {
+		opt(fm)
+	}
+	// initialize an empty snapshot of field builders
+	fm.snapshot.Store(&fieldCacheSnapshot{
+		fields:     map[FieldKey]*FieldBuilder{},
+		prototypes: map[FieldKey]*InformationElement{},
+	})
 	return fm
 }
 
+// GetBuilder returns a copy of the builder of the field, such that callers may use the builder's
+// setters without affecting other callers.
 func (fm *EphemeralFieldCache) GetBuilder(ctx context.Context, key FieldKey) (*FieldBuilder, error) {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
-
-	field, ok := fm.fields[key]
+	field, ok := fm.snapshot.Load().fields[key]
 	if !ok {
 		// logger.V(2).Info("fieldManager: unknown key", "enterpriseId", enterpriseId)
-		return NewUnassignedFieldBuilder(key.Id).SetPEN(key.EnterpriseId), nil
+		return NewUnknownFieldBuilder(key.EnterpriseId, key.Id), nil
 	}
-	return field, nil
+	return field.clone(), nil
 }
 
 func (fm *EphemeralFieldCache) Get(ctx context.Context, key FieldKey) (*InformationElement, error) {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
-
-	ie, ok := fm.prototypes[key]
+	ie, ok := fm.snapshot.Load().prototypes[key]
 	if !ok {
 		// logger.V(2).Info("fieldManager: unknown key", "enterpriseId", enterpriseId)
-		return nil, fmt.Errorf("unknown information element for \"%s\"", key.String())
+		return nil, fmt.Errorf("%w: unknown information element for \"%s\"", ErrFieldNotFound, key.String())
 	}
 	return ie, nil
 }
 
+// Add adds the information element to the cache, replacing any element of the same id and PEN.
+// Conflicting redefinitions, i.e., elements that are not Equal to the element they replace, are
+// logged, or rejected with an error wrapping ErrConflictingField by caches created with
+// WithStrictFieldDefinitions.
 func (fm *EphemeralFieldCache) Add(ctx context.Context, element InformationElement) error {
-	fm.mu.Lock()
-	defer fm.mu.Unlock()
+	var err error
+	fm.update(func(s *fieldCacheSnapshot) {
+		fk := NewFieldKey(element.EnterpriseId, element.Id)
+		if existing, ok := s.prototypes[fk]; ok && existing.Conflicts(element) {
+			diff := existing.Diff(element)
+			if fm.strict {
+				err = fmt.Errorf("%w: %s (%s) redefines %s of %s", ErrConflictingField, fk.String(), element.Name, strings.Join(diff, ", "), existing.Name)
+				return
+			}
+			FromContext(ctx).Info("redefining conflicting information element", "key", fk.String(), "name", element.Name, "previous", existing.Name, "differences", diff)
+		}
+		fm.add(s, element)
+	})
+	return err
+}
 
+// add adds the builder and prototype of element to the unpublished snapshot s
+func (fm *EphemeralFieldCache) add(s *fieldCacheSnapshot, element InformationElement) {
 	fk := NewFieldKey(element.EnterpriseId, element.Id)
 
-	fm.prototypes[fk] = &element
-	fm.fields[fk] = NewFieldBuilder(&element).
+	// the type is marshalled instead of the constructor, such that the element can be restored
+	if element.Type == nil && element.Constructor != nil {
+		typ := element.Constructor().Type()
+		element.Type = &typ
+	}
+
+	s.prototypes[fk] = &element
+	// the builder holds its own prototype, which is never handed out but cloned in GetBuilder
+	prototype := element
+	s.fields[fk] = NewFieldBuilder(&prototype).
 		SetFieldManager(fm).
 		SetTemplateManager(fm.templateManager).
 		SetPEN(element.EnterpriseId)
+}
 
+func (fm *EphemeralFieldCache) Delete(ctx context.Context, key FieldKey) error {
+	fm.update(func(s *fieldCacheSnapshot) {
+		delete(s.fields, key)
+		delete(s.prototypes, key)
+	})
 	return nil
 }
 
-func (fm *EphemeralFieldCache) Delete(ctx context.Context, key FieldKey) error {
+// update copies the current snapshot, applies fn to the copy, and publishes it
+func (fm *EphemeralFieldCache) update(fn func(*fieldCacheSnapshot)) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
-	delete(fm.fields, key)
-	delete(fm.prototypes, key)
-	return nil
+	current := fm.snapshot.Load()
+	next := &fieldCacheSnapshot{
+		fields:     make(map[FieldKey]*FieldBuilder, len(current.fields)+1),
+		prototypes: make(map[FieldKey]*InformationElement, len(current.prototypes)+1),
+	}
+	for k, v := range current.fields {
+		next.fields[k] = v
+	}
+	for k, v := range current.prototypes {
+		next.prototypes[k] = v
+	}
+	fn(next)
+	fm.snapshot.Store(next)
 }
 
+// GetAllBuilders returns copies of the builders of all fields in the cache
 func (fm *EphemeralFieldCache) GetAllBuilders(ctx context.Context) map[FieldKey]*FieldBuilder {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
-
-	return fm.fields
+	fields := fm.snapshot.Load().fields
+	mm := make(map[FieldKey]*FieldBuilder, len(fields))
+	for k, v := range fields {
+		mm[k] = v.clone()
+	}
+	return mm
 }
 
+// GetAll returns the information elements of all fields in the cache. The map is shared by all
+// callers and must not be modified.
 func (fm *EphemeralFieldCache) GetAll(ctx context.Context) map[FieldKey]*InformationElement {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
-
-	return fm.prototypes
+	return fm.snapshot.Load().prototypes
 }
 
 func (fm *EphemeralFieldCache) MarshalJSON() ([]byte, error) {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
+	return marshalSortedJSON(fm.snapshot.Load().fields, fieldKeyLess, fieldKeyString)
+}
 
-	s := make(map[string]interface{})
-	for k, v := range fm.fields {
-		s[k.String()] = v
+// UnmarshalJSON replaces all fields in the cache with the fields marshalled by MarshalJSON. The
+// information elements are restored from the builders' prototypes and keyed by the keys of the
+// JSON object, such that an element's id and PEN always match its key.
+func (fm *EphemeralFieldCache) UnmarshalJSON(in []byte) error {
+	raw := make(map[string]*FieldBuilder)
+	err := json.Unmarshal(in, &raw)
+	if err != nil {
+		return err
 	}
-	return json.Marshal(s)
-}
 
-// NewIANAFieldManager is a utility for creating field managers with initialized IANA fields quickly,
-// e.g. for unit testing.
-//
-// NewIANAFieldManager panics if failing to add an IE to the cache.
-func NewIANAFieldManager(templateManager TemplateCache) FieldCache {
-	fm := NewEphemeralFieldCache(templateManager)
-	for idx, ie := range iana() {
-		err := fm.Add(context.Background(), *ie)
+	elements := make([]InformationElement, 0, len(raw))
+	for k, b := range raw {
+		key := FieldKey{}
+		err := key.UnmarshalText([]byte(k))
 		if err != nil {
-			panic(fmt.Errorf("failed to add IANA IE %d to ipfix field manager, %w", idx, err))
+			return fmt.Errorf("failed to parse field key \"%s\", %w", k, err)
+		}
+		if b == nil || b.prototype == nil {
+			return fmt.Errorf("field %s has no information element", k)
 		}
+		ie := *b.prototype
+		ie.EnterpriseId, ie.Id = key.EnterpriseId, key.Id
+		elements = append(elements, ie)
 	}
+
+	fm.update(func(s *fieldCacheSnapshot) {
+		s.fields = make(map[FieldKey]*FieldBuilder, len(elements))
+		s.prototypes = make(map[FieldKey]*InformationElement, len(elements))
+		for _, ie := range elements {
+			fm.add(s, ie)
+		}
+	})
+	return nil
+}
+
+// NewIANAFieldCache creates an EphemeralFieldCache containing all information elements
+// assigned by IANA, such that decoders can resolve fields without adding each element manually.
+func NewIANAFieldCache(templateCache TemplateCache, opts ...FieldCacheOption) FieldCache {
+	fm := newEphemeralFieldCache(templateCache, opts...)
+	// add all IEs to a single snapshot rather than copying the snapshot for each IE
+	fm.update(func(s *fieldCacheSnapshot) {
+		for _, ie := range iana() {
+			fm.add(s, *ie)
+		}
+	})
 	return fm
 }
+
+// NewFieldCache creates a field cache containing all information elements assigned by IANA and
+// the elements of the given enterprise registries, e.g., CERT(). It returns an error if any of
+// the elements cannot be added.
+//
+//	fieldCache, err := ipfix.NewFieldCache(templateCache, ipfix.CERT())
+func NewFieldCache(templateCache TemplateCache, regs ...[]InformationElement) (FieldCache, error) {
+	fc := NewIANAFieldCache(templateCache)
+	if err := LoadRegistries(context.Background(), fc, regs...); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// NewIANAFieldManager is a utility for creating field managers with initialized IANA fields quickly,
+// e.g. for unit testing. It is equivalent to NewIANAFieldCache.
+func NewIANAFieldManager(templateManager TemplateCache) FieldCache {
+	return NewIANAFieldCache(templateManager)
+}
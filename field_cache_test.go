@@ -0,0 +1,375 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestTemplateRecord encodes a template record with an IANA field, a reversed IANA field, and an
+// enterprise-specific field
+func newTestTemplateRecord(templateId uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, templateId)
+	b = binary.BigEndian.AppendUint16(b, 3)
+	// sourceIPv4Address
+	b = binary.BigEndian.AppendUint16(b, 8)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	// reversedOctetDeltaCount
+	b = binary.BigEndian.AppendUint16(b, 0x8000|1)
+	b = binary.BigEndian.AppendUint16(b, 8)
+	b = binary.BigEndian.AppendUint32(b, ReversePEN)
+	// enterprise-specific field 6871/18
+	b = binary.BigEndian.AppendUint16(b, 0x8000|18)
+	b = binary.BigEndian.AppendUint16(b, VariableLength)
+	return binary.BigEndian.AppendUint32(b, 6871)
+}
+
+func TestEphemeralFieldCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("builders are not shared", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		key := NewFieldKey(0, 8)
+
+		b, err := fieldCache.GetBuilder(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.SetPEN(6871).SetLength(16)
+
+		b, err = fieldCache.GetBuilder(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pen := b.GetIE().EnterpriseId; pen != 0 {
+			t.Errorf("expected builder of IANA field to have PEN 0, got %d", pen)
+		}
+		if f := b.Complete(); f.PEN() != 0 {
+			t.Errorf("expected field of IANA IE to have PEN 0, got %d", f.PEN())
+		}
+		if ie, err := fieldCache.Get(ctx, key); err != nil || ie.EnterpriseId != 0 {
+			t.Errorf("expected prototype to be unchanged, got %v, %v", ie, err)
+		}
+	})
+
+	t.Run("marshalling is deterministic", func(t *testing.T) {
+		fieldCache, err := NewFieldCache(NewDefaultEphemeralCache(), CERT())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := json.Marshal(fieldCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(fieldCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatal("expected marshalling the same cache twice to be byte-identical")
+		}
+
+		keys := jsonObjectKeys(t, a)
+		// keys are ordered numerically rather than lexically, and IANA fields come first
+		if len(keys) < 11 || keys[0] != "0:0" || keys[2] != "0:2" || keys[10] != "0:10" {
+			t.Errorf("expected keys ordered by enterprise and field id, got %v", keys[:11])
+		}
+		if last := keys[len(keys)-1]; !strings.HasPrefix(last, fmt.Sprintf("%d:", CERTPEN)) {
+			t.Errorf("expected CERT fields last, got %s", last)
+		}
+	})
+
+	t.Run("marshal and unmarshal", func(t *testing.T) {
+		fieldCache, err := NewFieldCache(NewDefaultEphemeralCache(), CERT())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fieldCache.Add(ctx, InformationElement{Id: 18, EnterpriseId: 32473, Name: "payload", Constructor: NewOctetArray})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := json.Marshal(fieldCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := NewEphemeralFieldCache(NewDefaultEphemeralCache())
+		if err := json.Unmarshal(a, restored); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Errorf("expected restored cache to marshal identically, got\n%s\n%s", a, b)
+		}
+
+		ie, err := restored.Get(ctx, NewFieldKey(32473, 18))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ie.Name != "payload" || ie.Constructor == nil {
+			t.Errorf("expected enterprise-specific field to be restored, got %v", ie)
+		}
+		fb, err := restored.GetBuilder(ctx, NewFieldKey(0, 8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := fb.SetLength(4).Complete(); f.Name() != "sourceIPv4Address" || f.Id() != 8 {
+			t.Errorf("expected sourceIPv4Address (8), got %s (%d)", f.Name(), f.Id())
+		}
+	})
+
+	t.Run("add and delete", func(t *testing.T) {
+		fieldCache := NewEphemeralFieldCache(NewDefaultEphemeralCache())
+		key := NewFieldKey(6871, 18)
+		all := fieldCache.GetAll(ctx)
+
+		err := fieldCache.Add(ctx, InformationElement{Id: 18, EnterpriseId: 6871, Name: "payload", Constructor: NewOctetArray})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := fieldCache.GetBuilder(ctx, key); b.IsUnknown() || b.GetIE().Name != "payload" {
+			t.Errorf("expected builder of added field, got %v", b.GetIE())
+		}
+		if len(all) != 0 {
+			t.Error("expected previously returned map not to be modified by Add")
+		}
+
+		if err := fieldCache.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := fieldCache.GetBuilder(ctx, key); !b.IsUnknown() {
+			t.Error("expected unknown builder after deletion")
+		}
+		if _, err := fieldCache.Get(ctx, key); err == nil {
+			t.Error("expected error for deleted field")
+		}
+	})
+
+	t.Run("conflicting redefinitions", func(t *testing.T) {
+		key := NewFieldKey(0, 7) // sourceTransportPort
+		strict := NewIANAFieldCache(NewDefaultEphemeralCache(), WithStrictFieldDefinitions())
+		known, err := strict.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// re-announcing the same element, e.g., from RFC 5610 records, is not a conflict
+		if err := strict.Add(ctx, known.Clone()); err != nil {
+			t.Errorf("expected equal redefinition to be accepted, got %v", err)
+		}
+
+		redefined := known.Clone()
+		redefined.Type = nil
+		redefined.Constructor = NewString
+		err = strict.Add(ctx, redefined)
+		if !errors.Is(err, ErrConflictingField) {
+			t.Fatalf("expected ErrConflictingField, got %v", err)
+		}
+		if ie, _ := strict.Get(ctx, key); ie.dataType() != "unsigned16" {
+			t.Errorf("expected existing element to be kept, got type %s", ie.dataType())
+		}
+
+		lenient := NewIANAFieldCache(NewDefaultEphemeralCache())
+		if err := lenient.Add(ctx, redefined); err != nil {
+			t.Fatal(err)
+		}
+		if ie, _ := lenient.Get(ctx, key); ie.dataType() != "string" {
+			t.Errorf("expected element to be replaced, got type %s", ie.dataType())
+		}
+	})
+
+	t.Run("concurrent template decoding", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					tr := &TemplateRecord{fieldCache: fieldCache, templateCache: templateCache}
+					if _, err := tr.Decode(bytes.NewBuffer(newTestTemplateRecord(uint16(256 + i)))); err != nil {
+						errs <- err
+						return
+					}
+					if len(tr.Fields) != 3 || tr.Fields[0].PEN() != 0 || !tr.Fields[1].Reversed() || tr.Fields[2].PEN() != 6871 {
+						errs <- fmt.Errorf("unexpected template fields %v", tr.Fields)
+						return
+					}
+				}
+			}(i)
+		}
+		// concurrently learn enterprise-specific fields, as done for RFC 5610 records
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := fieldCache.Add(ctx, InformationElement{Id: uint16(1000 + j), EnterpriseId: 6871, Name: "learned", Constructor: NewOctetArray})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		for k, b := range fieldCache.GetAllBuilders(ctx) {
+			if b.GetIE().EnterpriseId != k.EnterpriseId {
+				t.Errorf("expected builder of %s to have PEN %d, got %d", k.String(), k.EnterpriseId, b.GetIE().EnterpriseId)
+			}
+		}
+	})
+}
+
+func BenchmarkFieldCacheGetBuilder(b *testing.B) {
+	ctx := context.Background()
+	fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+	keys := []FieldKey{NewFieldKey(0, 8), NewFieldKey(0, 7), NewFieldKey(0, 1), NewFieldKey(0, 152)}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			fb, err := fieldCache.GetBuilder(ctx, keys[i%len(keys)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			fb.SetLength(4).Complete()
+			i++
+		}
+	})
+}
+
+func BenchmarkDecodeTemplateRecord(b *testing.B) {
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	record := newTestTemplateRecord(256)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tr := &TemplateRecord{fieldCache: fieldCache, templateCache: templateCache}
+			if _, err := tr.Decode(bytes.NewBuffer(record)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestNewFieldCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("iana", func(t *testing.T) {
+		fieldCache := NewIANAFieldCache(NewDefaultEphemeralCache())
+		all := fieldCache.GetAll(ctx)
+		if len(all) != len(IANA()) {
+			t.Errorf("expected %d information elements, got %d", len(IANA()), len(all))
+		}
+		for key, ie := range all {
+			// keys are derived from the elements, so elements need to carry their id
+			if ie.Id != key.Id || ie.EnterpriseId != 0 {
+				t.Errorf("expected information element of key %s to have id 0/%d, got %d/%d", key.String(), key.Id, ie.EnterpriseId, ie.Id)
+			}
+		}
+
+		b, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := b.Complete(); f.Id() != 1 || f.Name() != "octetDeltaCount" {
+			t.Errorf("expected octetDeltaCount (1), got %s (%d)", f.Name(), f.Id())
+		}
+	})
+
+	t.Run("with enterprise registries", func(t *testing.T) {
+		fieldCache, err := NewFieldCache(NewDefaultEphemeralCache(), CERT())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := len(fieldCache.GetAll(ctx)); l != len(IANA())+len(CERT()) {
+			t.Errorf("expected %d information elements, got %d", len(IANA())+len(CERT()), l)
+		}
+		if _, err := fieldCache.Get(ctx, NewFieldKey(0, 8)); err != nil {
+			t.Error(err)
+		}
+		ie := CERT()[0]
+		if _, err := fieldCache.Get(ctx, NewFieldKey(CERTPEN, ie.Id)); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestFieldKeyUnmarshal(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected *FieldKey
+		// err is the error wrapped by the error of invalid keys, if any
+		err error
+	}{
+		{"0:0", &FieldKey{}, nil},
+		{"29305:1", &FieldKey{EnterpriseId: ReversePEN, Id: 1}, nil},
+		{"4294967295:65535", &FieldKey{EnterpriseId: 4294967295, Id: 65535}, nil},
+		{"4294967296:1", nil, strconv.ErrRange},
+		{"0:65536", nil, strconv.ErrRange},
+		{"-1:1", nil, strconv.ErrSyntax},
+		{"0:x", nil, strconv.ErrSyntax},
+		{"0", nil, nil},
+		{"0:1:2", nil, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			k := &FieldKey{}
+			err := k.Unmarshal(tc.text)
+			if tc.expected != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *k != *tc.expected {
+					t.Errorf("expected %v, got %v", tc.expected, k)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, got %v", k)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("expected error wrapping %v, got %v", tc.err, err)
+			}
+			if strings.Contains(err.Error(), "template") {
+				t.Errorf("expected error to refer to field keys, got %v", err)
+			}
+		})
+	}
+}
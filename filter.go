@@ -0,0 +1,506 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// FilterAction is the action a FilterRule applies to the fields it selects
+type FilterAction int
+
+const (
+	// FilterDrop removes fields from records and templates
+	FilterDrop FilterAction = iota
+	// FilterTruncate truncates the values of octetArray and string fields to a maximum length
+	FilterTruncate
+	// FilterReplace replaces the values of fields with a constant
+	FilterReplace
+	// FilterTransform replaces the values of fields with the values computed by a ValueTransformer
+	FilterTransform
+)
+
+func (a FilterAction) String() string {
+	switch a {
+	case FilterDrop:
+		return "drop"
+	case FilterTruncate:
+		return "truncate"
+	case FilterReplace:
+		return "replace"
+	case FilterTransform:
+		return "transform"
+	default:
+		return fmt.Sprintf("FilterAction(%d)", int(a))
+	}
+}
+
+// ValueTransformer computes replacement values of fields, e.g., for anonymizing IP addresses with
+// CryptoPAN. The returned value is set on a clone of the field with SetValue, so it may be either a
+// DataType or any value accepted by the field's DataType.
+type ValueTransformer interface {
+	TransformValue(f Field) (any, error)
+}
+
+// ValueTransformerFunc is a function implementing ValueTransformer
+type ValueTransformerFunc func(f Field) (any, error)
+
+func (fn ValueTransformerFunc) TransformValue(f Field) (any, error) {
+	return fn(f)
+}
+
+// FieldSelector selects fields either by name, or by their key if Name is empty. Keys are matched
+// against the keys of fields in templates, such that reversed fields of RFC 5103 are selected by
+// the key of their reverse IE, and names include the "reverse" prefix of reversed fields.
+type FieldSelector struct {
+	Key  FieldKey
+	Name string
+}
+
+// SelectKey selects fields by their PEN and id
+func SelectKey(enterpriseId uint32, id uint16) FieldSelector {
+	return FieldSelector{Key: NewFieldKey(enterpriseId, id)}
+}
+
+// SelectName selects fields by their name
+func SelectName(name string) FieldSelector {
+	return FieldSelector{Name: name}
+}
+
+func (s FieldSelector) matches(f Field) bool {
+	if s.Name != "" {
+		return f.Name() == s.Name
+	}
+	return wireKey(f) == s.Key
+}
+
+// FilterRule applies an action to all fields matched by its selector
+type FilterRule struct {
+	Selector FieldSelector
+	Action   FilterAction
+
+	// Length is the maximum length in bytes of values truncated with FilterTruncate
+	Length uint16
+	// Value is the constant replacing values with FilterReplace
+	Value any
+	// Transformer computes the values replacing values with FilterTransform
+	Transformer ValueTransformer
+}
+
+// DropField creates a rule removing the selected fields
+func DropField(s FieldSelector) FilterRule {
+	return FilterRule{Selector: s, Action: FilterDrop}
+}
+
+// TruncateField creates a rule truncating the values of the selected fields to at most length
+// bytes. Fixed-length fields longer than length are shortened in their templates as well.
+func TruncateField(s FieldSelector, length uint16) FilterRule {
+	return FilterRule{Selector: s, Action: FilterTruncate, Length: length}
+}
+
+// ReplaceField creates a rule replacing the values of the selected fields with value
+func ReplaceField(s FieldSelector, value any) FilterRule {
+	return FilterRule{Selector: s, Action: FilterReplace, Value: value}
+}
+
+// TransformField creates a rule replacing the values of the selected fields with the values
+// computed by t
+func TransformField(s FieldSelector, t ValueTransformer) FilterRule {
+	return FilterRule{Selector: s, Action: FilterTransform, Transformer: t}
+}
+
+// FieldFilter strips or masks fields of decoded messages, e.g., before sharing them with third
+// parties. Unlike modifying records in place, FieldFilter rewrites the template records of the
+// message consistently with the data records, and recomputes the lengths of records, sets, and the
+// message, such that the filtered message remains valid IPFIX when encoded again.
+//
+// Rules are matched against every field in order, and the first matching rule applies. This
+// includes fields of records in subTemplateLists and subTemplateMultiLists, as well as the
+// elements of basicLists, which are all removed if they are dropped. As rules only depend on the
+// fields themselves, templates exported in earlier messages are filtered consistently with data
+// records of later messages, given that all messages pass the same filter.
+//
+// FieldFilter is safe for concurrent use.
+type FieldFilter struct {
+	rules []FilterRule
+}
+
+// NewFieldFilter creates a filter of the given rules
+func NewFieldFilter(rules ...FilterRule) *FieldFilter {
+	return &FieldFilter{
+		rules: rules,
+	}
+}
+
+// Apply returns a filtered copy of msg. msg itself is not modified.
+func (ff *FieldFilter) Apply(msg *Message) (*Message, error) {
+	out := &Message{
+		Version:             msg.Version,
+		ExportTime:          msg.ExportTime,
+		SequenceNumber:      msg.SequenceNumber,
+		ObservationDomainId: msg.ObservationDomainId,
+		Sets:                make([]Set, 0, len(msg.Sets)),
+	}
+
+	length := messageHeaderLength
+	for i, s := range msg.Sets {
+		fs := Set{
+			SetHeader: s.SetHeader,
+			Kind:      s.Kind,
+		}
+		switch ss := s.Set.(type) {
+		case *TemplateSet:
+			records := make([]TemplateRecord, 0, len(ss.Records))
+			for _, tr := range ss.Records {
+				ftr, err := ff.filterTemplateRecord(tr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to filter set %d, %w", i, err)
+				}
+				records = append(records, ftr)
+			}
+			fs.Set = &TemplateSet{Records: records}
+		case *OptionsTemplateSet:
+			records := make([]OptionsTemplateRecord, 0, len(ss.Records))
+			for _, otr := range ss.Records {
+				fotr, err := ff.filterOptionsTemplateRecord(otr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to filter set %d, %w", i, err)
+				}
+				records = append(records, fotr)
+			}
+			fs.Set = &OptionsTemplateSet{Records: records}
+		case *DataSet:
+			records := make([]DataRecord, 0, len(ss.Records))
+			for _, dr := range ss.Records {
+				fdr, err := ff.filterDataRecord(dr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to filter set %d, %w", i, err)
+				}
+				records = append(records, fdr)
+			}
+			fs.Set = &DataSet{Records: records}
+		default:
+			// sets without records are left untouched
+			fs.Set = s.Set
+		}
+
+		if fs.Set != nil {
+			b := &bytes.Buffer{}
+			if _, err := fs.Set.Encode(b); err != nil {
+				return nil, fmt.Errorf("failed to encode filtered set %d, %w", i, err)
+			}
+			if l := setHeaderLength + b.Len(); l > MaxMessageLength {
+				return nil, fmt.Errorf("failed to filter set %d, %w: set of %d bytes", i, ErrMessageTooLarge, l)
+			}
+			fs.Length = uint16(setHeaderLength + b.Len())
+		}
+		length += int(fs.Length)
+		out.Sets = append(out.Sets, fs)
+	}
+	if length > MaxMessageLength {
+		// replaced values may be longer than the original ones
+		return nil, fmt.Errorf("failed to filter message, %w: message of %d bytes", ErrMessageTooLarge, length)
+	}
+	out.Length = uint16(length)
+	return out, nil
+}
+
+// match returns the first rule matching f, or nil
+func (ff *FieldFilter) match(f Field) *FilterRule {
+	for i := range ff.rules {
+		if ff.rules[i].Selector.matches(f) {
+			return &ff.rules[i]
+		}
+	}
+	return nil
+}
+
+func (ff *FieldFilter) filterTemplateRecord(tr TemplateRecord) (TemplateRecord, error) {
+	fields, err := ff.filterTemplateFields(tr.Fields)
+	if err != nil {
+		return TemplateRecord{}, fmt.Errorf("failed to filter template %d, %w", tr.TemplateId, err)
+	}
+	if len(fields) == 0 && len(tr.Fields) > 0 {
+		// template records without fields withdraw templates
+		return TemplateRecord{}, fmt.Errorf("failed to filter template %d, all fields are dropped", tr.TemplateId)
+	}
+	return TemplateRecord{
+		TemplateId: tr.TemplateId,
+		FieldCount: uint16(len(fields)),
+		Fields:     fields,
+
+		fieldCache:    tr.fieldCache,
+		templateCache: tr.templateCache,
+	}, nil
+}
+
+func (ff *FieldFilter) filterOptionsTemplateRecord(otr OptionsTemplateRecord) (OptionsTemplateRecord, error) {
+	scopes, err := ff.filterTemplateFields(otr.Scopes)
+	if err != nil {
+		return OptionsTemplateRecord{}, fmt.Errorf("failed to filter options template %d, %w", otr.TemplateId, err)
+	}
+	if len(scopes) == 0 && len(otr.Scopes) > 0 {
+		// RFC 7011 requires options templates to have at least one scope field
+		return OptionsTemplateRecord{}, fmt.Errorf("failed to filter options template %d, all scope fields are dropped", otr.TemplateId)
+	}
+	options, err := ff.filterTemplateFields(otr.Options)
+	if err != nil {
+		return OptionsTemplateRecord{}, fmt.Errorf("failed to filter options template %d, %w", otr.TemplateId, err)
+	}
+	return OptionsTemplateRecord{
+		TemplateId:      otr.TemplateId,
+		FieldCount:      uint16(len(scopes) + len(options)),
+		ScopeFieldCount: uint16(len(scopes)),
+		Scopes:          scopes,
+		Options:         options,
+
+		fieldCache:    otr.fieldCache,
+		templateCache: otr.templateCache,
+	}, nil
+}
+
+// filterTemplateFields filters the fields of a template. Only dropping and truncating fields
+// changes templates, the values of template fields are not used.
+func (ff *FieldFilter) filterTemplateFields(fields []Field) ([]Field, error) {
+	out := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		rule := ff.match(f)
+		if rule == nil {
+			out = append(out, f.Clone())
+			continue
+		}
+		switch rule.Action {
+		case FilterDrop:
+			continue
+		case FilterTruncate:
+			if err := truncatable(f.Constructor()()); err != nil {
+				return nil, fmt.Errorf("failed to truncate field %s, %w", f.Name(), err)
+			}
+			c := f.Clone()
+			if fl, ok := c.(*FixedLengthField); ok && fl.Length() > rule.Length {
+				fl.constructor = fl.constructor().WithLength(rule.Length)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, f.Clone())
+		}
+	}
+	return out, nil
+}
+
+func (ff *FieldFilter) filterDataRecord(dr DataRecord) (DataRecord, error) {
+	fields := make([]Field, 0, len(dr.Fields))
+	for _, f := range dr.Fields {
+		nf, err := ff.filterField(f)
+		if err != nil {
+			return DataRecord{}, err
+		}
+		if nf != nil {
+			fields = append(fields, nf)
+		}
+	}
+	return DataRecord{
+		TemplateId: dr.TemplateId,
+		FieldCount: uint16(len(fields)),
+		Fields:     fields,
+
+		fieldCache: dr.fieldCache,
+	}, nil
+}
+
+// filterField returns the filtered clone of f, or nil if f is dropped
+func (ff *FieldFilter) filterField(f Field) (Field, error) {
+	rule := ff.match(f)
+	if rule == nil {
+		c := f.Clone()
+		if c.Value() == nil {
+			return c, nil
+		}
+		v, err := ff.filterValue(c.Value())
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter field %s, %w", f.Name(), err)
+		}
+		return c.SetValue(v), nil
+	}
+
+	switch rule.Action {
+	case FilterDrop:
+		return nil, nil
+	case FilterTruncate:
+		c := f.Clone()
+		if c.Value() == nil {
+			return c, nil
+		}
+		if err := truncatable(c.Value()); err != nil {
+			return nil, fmt.Errorf("failed to truncate field %s, %w", f.Name(), err)
+		}
+		fl, isFixed := c.(*FixedLengthField)
+		if isFixed && fl.Length() <= rule.Length {
+			return c, nil
+		}
+		v := truncate(c.Value(), rule.Length)
+		if isFixed {
+			fl.constructor = fl.constructor().WithLength(rule.Length)
+			v = v.SetLength(rule.Length)
+		}
+		return c.SetValue(v), nil
+	case FilterReplace:
+		v := rule.Value
+		if dt, ok := v.(DataType); ok {
+			// records must not share the same value
+			v = dt.Clone()
+		}
+		return replaceValue(f, v)
+	case FilterTransform:
+		if rule.Transformer == nil {
+			return nil, fmt.Errorf("failed to transform field %s, transformer is nil", f.Name())
+		}
+		v, err := rule.Transformer.TransformValue(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform field %s, %w", f.Name(), err)
+		}
+		return replaceValue(f, v)
+	default:
+		return nil, fmt.Errorf("failed to filter field %s, unknown action %s", f.Name(), rule.Action)
+	}
+}
+
+// filterValue filters the fields nested in structured data types, and returns all other values
+// as they are
+func (ff *FieldFilter) filterValue(v DataType) (DataType, error) {
+	switch vv := v.(type) {
+	case *BasicList:
+		elements := make([]Field, 0, len(vv.value))
+		for i, el := range vv.value {
+			nel, err := ff.filterField(el)
+			if err != nil {
+				return nil, fmt.Errorf("failed to filter element %d of %T, %w", i, vv, err)
+			}
+			if nel == nil {
+				continue
+			}
+			if _, ok := nel.(*FixedLengthField); ok {
+				// elements may be truncated
+				vv.elementLength = nel.Length()
+			}
+			elements = append(elements, nel)
+		}
+		vv.value = elements
+		vv.length = 0
+		for _, el := range elements {
+			vv.length += el.Length()
+		}
+		return vv, nil
+	case *SubTemplateList:
+		records := make([]DataRecord, 0, len(vv.value))
+		for i, dr := range vv.value {
+			fdr, err := ff.filterDataRecord(dr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to filter record %d of %T, %w", i, vv, err)
+			}
+			records = append(records, fdr)
+		}
+		return vv.SetValue(records), nil
+	case *SubTemplateMultiList:
+		contents := make([]subTemplateListContent, 0, len(vv.value))
+		for i, c := range vv.value {
+			records := make([]DataRecord, 0, len(c.Values))
+			b := &bytes.Buffer{}
+			for j, dr := range c.Values {
+				fdr, err := ff.filterDataRecord(dr)
+				if err != nil {
+					return nil, fmt.Errorf("failed to filter record %d of sub template %d in %T, %w", j, i, vv, err)
+				}
+				if _, err := fdr.Encode(b); err != nil {
+					return nil, fmt.Errorf("failed to encode record %d of sub template %d in %T, %w", j, i, vv, err)
+				}
+				records = append(records, fdr)
+			}
+			contents = append(contents, subTemplateListContent{
+				TemplateId: c.TemplateId,
+				Length:     uint16(b.Len()) + subTemplateMultiListContentHeaderLength,
+				Values:     records,
+			})
+		}
+		return vv.SetValue(contents), nil
+	default:
+		return v, nil
+	}
+}
+
+// replaceValue returns a clone of f carrying v. Values of fixed-length fields must keep their
+// length, which is the case for all fixed-length data types except for octetArray and string, whose
+// values are padded or cut to the field's length.
+func replaceValue(f Field, v any) (Field, error) {
+	c := f.Clone()
+	length := c.Length()
+	if _, err := c.SetValueE(v); err != nil {
+		return nil, fmt.Errorf("failed to replace value of field %s, %w", f.Name(), err)
+	}
+	if _, ok := c.(*FixedLengthField); !ok || c.Length() == length {
+		return c, nil
+	}
+	switch dt := c.Value().(type) {
+	case *String:
+		dt.SetLength(length)
+	case *OctetArray:
+		b := make([]byte, length)
+		copy(b, dt.value)
+		dt.SetValue(b)
+	default:
+		return nil, fmt.Errorf("failed to replace value of field %s, length %d of %T does not match field length %d", f.Name(), c.Length(), dt, length)
+	}
+	return c, nil
+}
+
+// truncatable returns an error for data types other than octetArray and string, for which
+// truncating values is not sensible
+func truncatable(dt DataType) error {
+	switch dt.(type) {
+	case *OctetArray, *String:
+		return nil
+	default:
+		return fmt.Errorf("values of type %s cannot be truncated", dt.Type())
+	}
+}
+
+// truncate returns a copy of the octetArray or string dt cut to at most length bytes
+func truncate(dt DataType, length uint16) DataType {
+	switch v := dt.(type) {
+	case *OctetArray:
+		if len(v.value) <= int(length) {
+			return v.Clone()
+		}
+		b := make([]byte, length)
+		copy(b, v.value)
+		return v.Clone().SetValue(b)
+	case *String:
+		if len(v.value) <= int(length) {
+			return v.Clone()
+		}
+		// do not cut a multi-byte rune in half
+		cut := int(length)
+		for cut > 0 && !utf8.RuneStart(v.value[cut]) {
+			cut--
+		}
+		return v.Clone().SetValue(v.value[:cut])
+	default:
+		return dt
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFieldFilter(t *testing.T) {
+	ctx := context.Background()
+
+	// newTestFilterMessage creates a message with templates 256 and 257, and a data record of
+	// template 256 containing a subTemplateList of two records of template 257
+	newTestFilterMessage := func(t *testing.T) *Message {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		field := func(id, length uint16) Field {
+			fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fb.SetLength(length).Complete()
+		}
+
+		// sourceIPv4Address, sourceMacAddress, applicationName, interfaceName, subTemplateList
+		outer := []Field{field(8, 4), field(56, 6), field(96, 16), field(82, 0xFFFF), field(292, 0xFFFF)}
+		// sourceMacAddress, destinationTransportPort
+		inner := []Field{field(56, 6), field(11, 2)}
+
+		record := func(template []Field, values ...any) DataRecord {
+			fields := make([]Field, 0, len(template))
+			for i, f := range template {
+				fields = append(fields, f.Clone().SetValue(values[i]))
+			}
+			return DataRecord{FieldCount: uint16(len(fields)), Fields: fields}
+		}
+		innerRecords := []DataRecord{
+			record(inner, "00:00:5e:00:53:01", uint16(443)),
+			record(inner, "00:00:5e:00:53:02", uint16(8443)),
+		}
+		for i := range innerRecords {
+			innerRecords[i].TemplateId = 257
+		}
+		stl := &SubTemplateList{templateId: 257, semantic: SemanticAllOf, templateManager: templateCache}
+		stl.SetValue(innerRecords)
+		dr := record(outer, "192.0.2.1", "00:00:5e:00:53:00", "https", "ethernet0/1", stl)
+		dr.TemplateId = 256
+		// applicationName is a fixed-length field padded to its length in the template
+		dr.Fields[2].Value().SetLength(16)
+
+		return &Message{
+			Version:        10,
+			ExportTime:     1696161600,
+			SequenceNumber: 1,
+			Sets: []Set{
+				{
+					SetHeader: SetHeader{Id: IPFIX},
+					Kind:      KindTemplateSet,
+					Set: &TemplateSet{Records: []TemplateRecord{
+						{TemplateId: 256, FieldCount: uint16(len(outer)), Fields: outer},
+						{TemplateId: 257, FieldCount: uint16(len(inner)), Fields: inner},
+					}},
+				},
+				{
+					SetHeader: SetHeader{Id: 256},
+					Kind:      KindDataSet,
+					Set:       &DataSet{Records: []DataRecord{dr}},
+				},
+			},
+		}
+	}
+
+	// roundTrip encodes msg and decodes it again with fresh caches
+	roundTrip := func(t *testing.T, msg *Message) *Message {
+		b := &bytes.Buffer{}
+		n, err := msg.Encode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int(msg.Length) || binary.BigEndian.Uint16(b.Bytes()[2:4]) != uint16(n) {
+			t.Fatalf("expected message length %d to match encoded length %d", msg.Length, n)
+		}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+
+		decoded, err := NewDecoder(templateCache, fieldCache).Decode(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		msg := newTestFilterMessage(t)
+		filter := NewFieldFilter(
+			DropField(SelectName("sourceMacAddress")),
+			TruncateField(SelectName("applicationName"), 4),
+			TruncateField(SelectKey(0, 82), 8),
+			ReplaceField(SelectKey(0, 8), "192.0.2.0"),
+			TransformField(SelectName("destinationTransportPort"), ValueTransformerFunc(func(f Field) (any, error) {
+				return f.Value().Value().(uint16) + 1, nil
+			})),
+		)
+		filtered, err := filter.Apply(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := roundTrip(t, filtered)
+
+		if l := len(decoded.Sets); l != 2 {
+			t.Fatalf("expected 2 sets, got %d", l)
+		}
+		drs := decoded.Sets[1].Set.(*DataSet).Records
+		if len(drs) != 1 {
+			t.Fatalf("expected 1 data record, got %d", len(drs))
+		}
+		dr := drs[0]
+		if len(dr.Fields) != 4 {
+			t.Fatalf("expected 4 fields, got %d", len(dr.Fields))
+		}
+		if _, ok := dr.FieldByName(0, "sourceMacAddress"); ok {
+			t.Error("expected sourceMacAddress to be dropped from record")
+		}
+		if f, _ := dr.FieldByName(0, "sourceIPv4Address"); !f.Value().Value().(net.IP).Equal(net.ParseIP("192.0.2.0")) {
+			t.Errorf("expected sourceIPv4Address to be replaced, got %v", f.Value())
+		}
+		if f, _ := dr.FieldByName(0, "applicationName"); f.Value().Value() != "http" || f.Length() != 4 {
+			t.Errorf("expected applicationName to be truncated to 4 bytes, got %q of length %d", f.Value(), f.Length())
+		}
+		if f, _ := dr.FieldByName(0, "interfaceName"); f.Value().Value() != "ethernet" {
+			t.Errorf("expected interfaceName to be truncated to 8 bytes, got %q", f.Value())
+		}
+
+		f, _ := dr.FieldByName(0, "subTemplateList")
+		stl := f.Value().(*SubTemplateList)
+		if l := len(stl.Elements()); l != 2 {
+			t.Fatalf("expected 2 records in subTemplateList, got %d", l)
+		}
+		for i, r := range stl.Elements() {
+			if len(r.Fields) != 1 {
+				t.Errorf("expected record %d of subTemplateList to have 1 field, got %d", i, len(r.Fields))
+			}
+			if _, ok := r.FieldByName(0, "sourceMacAddress"); ok {
+				t.Errorf("expected sourceMacAddress to be dropped from record %d of subTemplateList", i)
+			}
+		}
+		if p := stl.Elements()[1].Fields[0].Value().Value(); p != uint16(8444) {
+			t.Errorf("expected destinationTransportPort to be transformed, got %v", p)
+		}
+
+		for _, tr := range filtered.Sets[0].Set.(*TemplateSet).Records {
+			for _, f := range tr.Fields {
+				if f.Id() == 56 {
+					t.Errorf("expected sourceMacAddress to be dropped from template %d", tr.TemplateId)
+				}
+			}
+			if int(tr.FieldCount) != len(tr.Fields) {
+				t.Errorf("expected field count of template %d to be %d, got %d", tr.TemplateId, len(tr.Fields), tr.FieldCount)
+			}
+		}
+	})
+
+	t.Run("input is not modified", func(t *testing.T) {
+		msg := newTestFilterMessage(t)
+		expected := msg.String()
+		_, err := NewFieldFilter(DropField(SelectKey(0, 56)), TruncateField(SelectName("applicationName"), 2)).Apply(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := msg.String(); s != expected {
+			t.Errorf("expected message to be unchanged\n%s, got\n%s", expected, s)
+		}
+	})
+
+	t.Run("no rules", func(t *testing.T) {
+		msg := newTestFilterMessage(t)
+		filtered, err := NewFieldFilter().Apply(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := roundTrip(t, filtered)
+		expected := msg.Sets[1].Set.(*DataSet).Records[0].Flatten()
+		got := decoded.Sets[1].Set.(*DataSet).Records[0].Flatten()
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected unfiltered record %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("dropping all fields fails", func(t *testing.T) {
+		_, err := NewFieldFilter(
+			DropField(SelectKey(0, 56)),
+			DropField(SelectKey(0, 11)),
+		).Apply(newTestFilterMessage(t))
+		if err == nil {
+			t.Error("expected error for template without fields")
+		}
+	})
+
+	t.Run("truncating unsupported types fails", func(t *testing.T) {
+		_, err := NewFieldFilter(TruncateField(SelectKey(0, 8), 2)).Apply(newTestFilterMessage(t))
+		if err == nil {
+			t.Error("expected error for truncating sourceIPv4Address")
+		}
+	})
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+
+	"gopkg.in/yaml.v3"
 )
 
 type FixedLengthField struct {
@@ -44,6 +47,12 @@ type FixedLengthField struct {
 	isScope bool
 
 	prototype *InformationElement
+
+	// unknown is set for fields created by a builder of NewUnknownFieldBuilder
+	unknown bool
+
+	// raw is set for fields decoded with DecoderOptions.LazyFieldValues
+	raw rawValue
 }
 
 func (f *FixedLengthField) Lift() *VariableLengthField {
@@ -58,19 +67,63 @@ func (f *FixedLengthField) Lift() *VariableLengthField {
 		isScope:             f.isScope,
 		observationDomainId: f.observationDomainId,
 		prototype:           f.prototype,
+		unknown:             f.unknown,
+		raw:                 f.raw,
 	}
 }
 
+// Decode decodes the field's value from r. When decoding with DecoderOptions.LazyFieldValues, the
+// bytes of the value are retained, and decoding octetArray and string values is deferred until
+// their value is accessed.
 func (f *FixedLengthField) Decode(r io.Reader) (int, error) {
 	if f.value == nil {
 		f.value = f.constructor()
 	}
-	return f.value.Decode(r)
+	state, depth := decodeStateOf(r)
+	if !state.lazy() {
+		return f.value.Decode(r)
+	}
+
+	b := make([]byte, f.value.Length())
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		return n, err
+	}
+	f.raw = rawValue{bytes: b, pending: deferrable(f.value)}
+	if f.raw.pending {
+		return n, nil
+	}
+	_, err = f.value.Decode(state.nest(bytes.NewReader(b), depth))
+	return n, err
+}
+
+// decodeRaw decodes the retained bytes of a lazily decoded field into its value. Only data types
+// that cannot fail decoding are deferred, so there is no error to return.
+func (f *FixedLengthField) decodeRaw() {
+	if !f.raw.pending {
+		return
+	}
+	f.raw.pending = false
+	_, _ = f.value.Decode(bytes.NewReader(f.raw.bytes))
 }
 
+// RawBytes returns the bytes of the field's value as received, if the field was decoded with
+// DecoderOptions.LazyFieldValues, and nil otherwise. The bytes are not updated by SetValue and must
+// not be modified.
+func (f *FixedLengthField) RawBytes() []byte {
+	return f.raw.bytes
+}
+
+// Encode writes the field's value to w. If the field has no value, Encode writes zero-filled bytes
+// of the field's length, such that subsequent fields of a record are not shifted. Fields decoded with
+// DecoderOptions.LazyFieldValues whose value was neither accessed nor set write the bytes they
+// were decoded from.
 func (f *FixedLengthField) Encode(w io.Writer) (int, error) {
+	if f.raw.retained() {
+		return w.Write(f.raw.bytes)
+	}
 	if f.value == nil {
-		return 0, nil
+		return w.Write(make([]byte, f.Length()))
 	}
 	return f.value.Encode(w)
 }
@@ -106,28 +159,48 @@ func (f *FixedLengthField) Prototype() *InformationElement {
 }
 
 // Value returns the fields value. If value is nil, i.e., has not yet been assigned, Value
-// returns the zero value of the DataType constructor.
+// returns the zero value of the DataType constructor. As the returned value can be modified,
+// lazily decoded fields are encoded from their value afterwards.
 func (f *FixedLengthField) Value() DataType {
 	if f.value == nil {
 		f.value = f.constructor()
 	}
+	f.decodeRaw()
+	f.raw.touched = true
 	return f.value
 }
 
+// SetValue sets v on the field's data type, see SetValueE. SetValue panics for values that
+// SetValueE returns an error for.
 func (f *FixedLengthField) SetValue(v any) Field {
+	if _, err := f.SetValueE(v); err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// SetValueE sets v on the field's data type, or replaces the data type if v is a DataType itself.
+// Values of unsupported types or out of range of the data type return an error wrapping
+// ErrInvalidValue.
+func (f *FixedLengthField) SetValueE(v any) (Field, error) {
 	// if the value implements DataType, set the field's value directly and return
 	dt, ok := v.(DataType)
 	if ok {
 		f.value = dt
-		return f
+		f.raw.pending, f.raw.touched = false, true
+		return f, nil
 	}
 
-	// otherwise, call setValue on the field's data type (and constructing it first, if not done yet)
+	// otherwise, set the value on the field's data type (and constructing it first, if not done yet)
 	if f.value == nil {
 		f.value = f.constructor()
 	}
-	f.value.SetValue(v)
-	return f
+	f.raw.pending, f.raw.touched = false, true
+	_, err := SetValueE(f.value, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set value of field %s, %w", f.Name(), err)
+	}
+	return f, nil
 }
 
 func (f *FixedLengthField) Length() uint16 {
@@ -155,7 +228,7 @@ func (f *FixedLengthField) IsScope() bool {
 }
 
 func (f *FixedLengthField) Reversible() bool {
-	return reversible(f.id)
+	return reversibleIE(f.pen, f.id)
 }
 
 func (f *FixedLengthField) Reversed() bool {
@@ -165,12 +238,11 @@ func (f *FixedLengthField) Reversed() bool {
 // consolidate converts the FixedLengthField into a format this is easily marshalled
 // to JSON or other serial formats. Mainly it replaces the function component
 func (f *FixedLengthField) consolidate() consolidatedField {
-	pen := f.pen
-	if f.reversed {
-		pen = ReversePEN
-	}
+	// reversed fields are consolidated with the key of their reverse IE
+	key := wireKey(f)
+	pen := key.EnterpriseId
 	cf := consolidatedField{
-		Id:                  f.Id(),
+		Id:                  key.Id,
 		Name:                f.Name(),
 		IsVariableLength:    false,
 		Length:              f.Length(),
@@ -179,6 +251,7 @@ func (f *FixedLengthField) consolidate() consolidatedField {
 		Type:                f.Type(),
 		IsScope:             f.IsScope(),
 	}
+	f.decodeRaw()
 	if f.value != nil {
 		encValue, _ := json.Marshal(f.value)
 		bb := json.RawMessage(encValue)
@@ -198,7 +271,11 @@ func (f *FixedLengthField) UnmarshalJSON(in []byte) error {
 	if err != nil {
 		return err
 	}
-	tflf, ok := cf.restore(f.fieldManager, f.templateManager).(*FixedLengthField)
+	restored, err := cf.restore(f.fieldManager, f.templateManager)
+	if err != nil {
+		return err
+	}
+	tflf, ok := restored.(*FixedLengthField)
 	if !ok {
 		return fmt.Errorf("could not unmarshal field to variable length field")
 	}
@@ -206,6 +283,33 @@ func (f *FixedLengthField) UnmarshalJSON(in []byte) error {
 	return nil
 }
 
+func (f *FixedLengthField) MarshalYAML() (interface{}, error) {
+	cf := f.consolidate()
+	return cf.yaml()
+}
+
+func (f *FixedLengthField) UnmarshalYAML(node *yaml.Node) error {
+	yf := &yamlField{}
+	err := node.Decode(yf)
+	if err != nil {
+		return err
+	}
+	cf, err := yf.consolidated()
+	if err != nil {
+		return err
+	}
+	restored, err := cf.restore(f.fieldManager, f.templateManager)
+	if err != nil {
+		return err
+	}
+	t, ok := restored.(*FixedLengthField)
+	if !ok {
+		return fmt.Errorf("could not unmarshal field to fixed length field")
+	}
+	*f = *t
+	return nil
+}
+
 func (f *FixedLengthField) Clone() Field {
 	var ndt DataType
 	if dt := f.value; dt != nil {
@@ -222,15 +326,18 @@ func (f *FixedLengthField) Clone() Field {
 		value:               ndt,
 		constructor:         f.constructor,
 		prototype:           f.prototype,
+		unknown:             f.unknown,
 		observationDomainId: f.observationDomainId,
 		fieldManager:        f.fieldManager,
 		templateManager:     f.templateManager,
+		raw:                 f.raw,
 	}
 }
 
 func (f *FixedLengthField) String() string {
 	val := "nil"
 
+	f.decodeRaw()
 	if f.value != nil {
 		val = fmt.Sprintf("<%s>\"%s\"", f.value.Type(), f.value.String())
 	} else if f.constructor != nil {
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"strconv"
+)
+
+// Flatten converts the data record to a flat map from keys to the native values of its fields, e.g.,
+// for indexing records in document stores such as Elasticsearch or ClickHouse.
+//
+// Fields are keyed by their name. The structured data types of RFC 6313 are flattened recursively,
+// with the elements of lists keyed by the name of the list field, their index, and their own name:
+//
+//   - elements of a basicList: "basicList.0.sourceIPv4Address",
+//   - fields of the records of a subTemplateList: "subTemplateList.0.sourceIPv4Address",
+//   - fields of the records of a subTemplateMultiList, indexed by the sub template and the record
+//     within it: "subTemplateMultiList.0.1.sourceIPv4Address".
+//
+// Scope fields of records of options templates are prefixed with "scope.", such that they do not
+// collide with option fields of the same name. Repeated fields of the same name within a record are
+// suffixed with "#" and their occurrence, e.g., "octetDeltaCount#1" for the second occurrence.
+func (dr *DataRecord) Flatten() map[string]any {
+	m := make(map[string]any, len(dr.Fields))
+	flattenFields(m, "", dr.Fields)
+	return m
+}
+
+// flattenFields adds the fields to m, with keys prefixed with prefix
+func flattenFields(m map[string]any, prefix string, fields []Field) {
+	names := make(fieldNames, len(fields))
+	for _, f := range fields {
+		flattenField(m, prefix+names.next(f, f.IsScope()), f)
+	}
+}
+
+// fieldNames counts the occurrences of names of fields within a record, in order to assign the
+// keys of Flatten to its fields
+type fieldNames map[string]int
+
+// next returns the key of f as the next field of the record, i.e., its name, prefixed with
+// "scope." if scope is true, and suffixed with its occurrence if the name is repeated
+func (o fieldNames) next(f Field, scope bool) string {
+	name := f.Name()
+	if name == "" {
+		// e.g., elements of basic lists created from values only
+		name = unknownFieldName(f.PEN(), f.Id())
+	}
+	if scope {
+		name = "scope." + name
+	}
+	n := o[name]
+	o[name]++
+	if n > 0 {
+		name = name + "#" + strconv.Itoa(n)
+	}
+	return name
+}
+
+// flattenField adds the value of f to m at key, or, for structured data types, its elements
+func flattenField(m map[string]any, key string, f Field) {
+	if f.Value() == nil {
+		m[key] = nil
+		return
+	}
+	switch v := f.Value().(type) {
+	case *BasicList:
+		for i, el := range v.Elements() {
+			flattenFields(m, key+"."+strconv.Itoa(i)+".", []Field{el})
+		}
+	case *SubTemplateList:
+		for i, record := range v.Elements() {
+			flattenFields(m, key+"."+strconv.Itoa(i)+".", record.Fields)
+		}
+	case *SubTemplateMultiList:
+		for i, content := range v.Elements() {
+			for j, record := range content.Values {
+				flattenFields(m, key+"."+strconv.Itoa(i)+"."+strconv.Itoa(j)+".", record.Fields)
+			}
+		}
+	default:
+		m[key] = v.Value()
+	}
+}
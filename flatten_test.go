@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	ctx := context.Background()
+	fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+	field := func(id, length uint16, value any) Field {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.SetLength(length).Complete().SetValue(value)
+	}
+	address := func(s string) Field {
+		return field(8, 4, netip.MustParseAddr(s))
+	}
+	port := func(p uint16) Field {
+		return field(7, 2, p)
+	}
+	ports := func(ps ...uint16) Field {
+		elements := make([]Field, 0, len(ps))
+		for _, p := range ps {
+			elements = append(elements, port(p))
+		}
+		return field(291, VariableLength, (&BasicList{fieldId: 7, semantic: SemanticOrdered}).SetValue(elements))
+	}
+
+	t.Run("flat record", func(t *testing.T) {
+		dr := DataRecord{
+			Fields: []Field{address("192.0.2.1"), port(4739), field(1, 8, uint64(1500)), field(1, 8, uint64(40))},
+		}
+		expected := map[string]any{
+			"sourceIPv4Address":   net.ParseIP("192.0.2.1").To4(),
+			"sourceTransportPort": uint16(4739),
+			"octetDeltaCount":     uint64(1500),
+			"octetDeltaCount#1":   uint64(40),
+		}
+		if flat := dr.Flatten(); !reflect.DeepEqual(flat, expected) {
+			t.Errorf("expected %v, got %v", expected, flat)
+		}
+	})
+
+	t.Run("scope fields", func(t *testing.T) {
+		scope := address("192.0.2.1")
+		scope.SetScoped()
+		dr := DataRecord{
+			Fields: []Field{scope, address("198.51.100.7")},
+		}
+		expected := map[string]any{
+			"scope.sourceIPv4Address": net.ParseIP("192.0.2.1").To4(),
+			"sourceIPv4Address":       net.ParseIP("198.51.100.7").To4(),
+		}
+		if flat := dr.Flatten(); !reflect.DeepEqual(flat, expected) {
+			t.Errorf("expected %v, got %v", expected, flat)
+		}
+	})
+
+	t.Run("nested lists", func(t *testing.T) {
+		// a subTemplateList of records containing a basicList each, and a subTemplateMultiList
+		// of two sub templates
+		stl := NewDefaultSubTemplateList().SetValue([]DataRecord{
+			{TemplateId: 300, Fields: []Field{address("192.0.2.1"), ports(80, 443)}},
+			{TemplateId: 300, Fields: []Field{address("192.0.2.2"), ports(22)}},
+		})
+		stml := NewDefaultSubTemplateMultiList().SetValue([]subTemplateListContent{
+			{TemplateId: 301, Values: []DataRecord{
+				{TemplateId: 301, Fields: []Field{port(53)}},
+				{TemplateId: 301, Fields: []Field{port(853)}},
+			}},
+			{TemplateId: 302, Values: []DataRecord{
+				{TemplateId: 302, Fields: []Field{address("203.0.113.9")}},
+			}},
+		})
+		dr := DataRecord{
+			Fields: []Field{
+				address("198.51.100.7"),
+				field(292, VariableLength, stl),
+				field(293, VariableLength, stml),
+			},
+		}
+
+		expected := map[string]any{
+			"sourceIPv4Address": net.ParseIP("198.51.100.7").To4(),
+
+			"subTemplateList.0.sourceIPv4Address":               net.ParseIP("192.0.2.1").To4(),
+			"subTemplateList.0.basicList.0.sourceTransportPort": uint16(80),
+			"subTemplateList.0.basicList.1.sourceTransportPort": uint16(443),
+			"subTemplateList.1.sourceIPv4Address":               net.ParseIP("192.0.2.2").To4(),
+			"subTemplateList.1.basicList.0.sourceTransportPort": uint16(22),
+			"subTemplateMultiList.0.0.sourceTransportPort":      uint16(53),
+			"subTemplateMultiList.0.1.sourceTransportPort":      uint16(853),
+			"subTemplateMultiList.1.0.sourceIPv4Address":        net.ParseIP("203.0.113.9").To4(),
+		}
+		if flat := dr.Flatten(); !reflect.DeepEqual(flat, expected) {
+			t.Errorf("expected\n%v, got\n%v", expected, flat)
+		}
+	})
+}
@@ -44,12 +44,25 @@ func (t *Float32) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers and floating-point numbers as value. Finite values exceeding the
+// range of float32 return an error.
+func (t *Float32) SetValueE(v any) (DataType, error) {
+	f, err := floatFromAny(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	if !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %v is not in the range of float32", t, ErrInvalidValue, f)
+	}
+	t.value = float32(f)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Float32) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = float32(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -82,8 +95,10 @@ func (*Float32) IsReducedLength() bool {
 }
 
 func (t *Float32) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
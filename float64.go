@@ -44,12 +44,21 @@ func (t *Float64) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers and floating-point numbers as value
+func (t *Float64) SetValueE(v any) (DataType, error) {
+	f, err := floatFromAny(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = f
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Float64) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = ty
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -82,8 +91,10 @@ func (*Float64) IsReducedLength() bool {
 }
 
 func (t *Float64) Decode(in io.Reader) (int, error) {
-	b := make([]byte, t.Length())
-	n, err := in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err := io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzz targets below decode arbitrary bytes, which must never panic. Run them with, e.g.,
+//
+//	go test -run '^$' -fuzz FuzzMessageDecode
+
+// newFuzzCaches returns fresh caches for each input, such that templates learned from one input
+// do not affect the decoding of another
+func newFuzzCaches(tb testing.TB) (StatefulTemplateCache, FieldCache) {
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	if err := templateCache.Add(context.Background(), NewKey(0, 256), newTestTemplate(tb, fieldCache, 256)); err != nil {
+		tb.Fatal(err)
+	}
+	return templateCache, fieldCache
+}
+
+func FuzzMessageDecode(f *testing.F) {
+	msg := &bytes.Buffer{}
+	if _, err := newTestMessage(f).Encode(msg); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(msg.Bytes())
+	f.Add(newTestDataMessage(256, 2))
+	f.Add(newTestMultiSetMessage(256, 3, 2))
+
+	// a message defining template 300 followed by a data set using it
+	b := binary.BigEndian.AppendUint16(nil, 10)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	record := newTestTemplateRecord(300)
+	b = binary.BigEndian.AppendUint16(b, IPFIX)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(record)))
+	b = append(b, record...)
+	b = binary.BigEndian.AppendUint16(b, 300)
+	b = binary.BigEndian.AppendUint16(b, 4+4+8+4)
+	b = append(b, 192, 0, 2, 1, 0, 0, 0, 0, 0, 0, 0, 42, 3, 'a', 'b', 'c')
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	f.Add(b)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		templateCache, fieldCache := newFuzzCaches(t)
+		decoder := NewDecoder(templateCache, fieldCache)
+		_, _ = decoder.Decode(context.Background(), bytes.NewBuffer(data))
+	})
+}
+
+// FuzzDecode decodes streams of messages with a single decoder resolving IANA fields, such that
+// templates announced by earlier messages are used for data sets of later ones. It is seeded with
+// the captures of the conformance tests. Decoding must return a message or an error for each
+// message of the stream.
+func FuzzDecode(f *testing.F) {
+	captures, err := filepath.Glob("testdata/conformance/*.ipfix")
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(captures) == 0 {
+		f.Fatal("no captures in testdata/conformance")
+	}
+	for _, capture := range captures {
+		b, err := os.ReadFile(capture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldCache(templateCache))
+		r := bytes.NewReader(data)
+		for {
+			raw, err := ReadMessage(r)
+			if err != nil {
+				// the stream cannot be framed anymore
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrInvalidMessageHeader) {
+					t.Fatalf("unexpected error reading message, %v", err)
+				}
+				return
+			}
+			msg, err := decoder.Decode(context.Background(), raw)
+			if msg == nil && err == nil {
+				t.Fatal("expected either a message or an error")
+			}
+		}
+	})
+}
+
+// FuzzSetLength decodes messages of two data sets, in which the length of the first set is replaced
+// by length, and the message is truncated by cut bytes. Set lengths shorter than the set header or
+// exceeding the remaining message must fail with ErrMalformedSet and TruncatedSetError, respectively.
+func FuzzSetLength(f *testing.F) {
+	for _, length := range []uint16{0, 3, 4, 16, 17, 32, 36, 37, 65535} {
+		f.Add(length, uint8(0))
+		f.Add(length, uint8(7))
+	}
+
+	f.Fuzz(func(t *testing.T, length uint16, cut uint8) {
+		b := newTestMultiSetMessage(256, 2, 2)
+		// keep the message header and the first set header
+		b = b[:len(b)-int(cut)%(len(b)-20)]
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		binary.BigEndian.PutUint16(b[18:20], length)
+		available := len(b) - 20
+
+		templateCache, fieldCache := newFuzzCaches(t)
+		_, err := NewDecoder(templateCache, fieldCache).Decode(context.Background(), bytes.NewBuffer(b))
+
+		truncatedErr := &TruncatedSetError{}
+		switch {
+		case length < 4:
+			if !errors.Is(err, ErrMalformedSet) {
+				t.Errorf("expected ErrMalformedSet for set length %d, got %v", length, err)
+			}
+		case int(length)-4 > available:
+			if !errors.As(err, &truncatedErr) {
+				t.Fatalf("expected TruncatedSetError for set length %d and %d available bytes, got %v", length, available, err)
+			}
+			if truncatedErr.Id != 256 || truncatedErr.Length != int(length) || truncatedErr.Available != available {
+				t.Errorf("unexpected truncation %+v", truncatedErr)
+			}
+			decodeErr := &DecodeError{}
+			if !errors.As(err, &decodeErr) || decodeErr.Stage != DecodeStageSetHeader || decodeErr.SetIndex != 0 {
+				t.Errorf("expected error in header of the first set, got %v", err)
+			}
+		default:
+			// the first set fits into the message, only a subsequent set may be truncated
+			if errors.As(err, &truncatedErr) && truncatedErr.Length == int(length) && truncatedErr.Available == available {
+				t.Errorf("expected first set of length %d not to be truncated, got %v", length, err)
+			}
+		}
+	})
+}
+
+func FuzzTemplateRecordDecode(f *testing.F) {
+	f.Add(newTestTemplateRecord(256))
+	f.Add(newTestTemplateRecord(65535))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		templateCache, fieldCache := newFuzzCaches(t)
+		tr := &TemplateRecord{
+			fieldCache:    fieldCache,
+			templateCache: templateCache,
+		}
+		_, _ = tr.Decode(bytes.NewBuffer(data))
+	})
+}
+
+func FuzzBasicListDecode(f *testing.F) {
+	// allOf sourceIPv4Address
+	b := []byte{byte(SemanticAllOf)}
+	b = binary.BigEndian.AppendUint16(b, 8)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	f.Add(append(b, 192, 0, 2, 1, 192, 0, 2, 2))
+	// ordered variable-length enterprise-specific field 6871/18
+	b = []byte{byte(SemanticOrdered)}
+	b = binary.BigEndian.AppendUint16(b, 0x8000|18)
+	b = binary.BigEndian.AppendUint16(b, VariableLength)
+	b = binary.BigEndian.AppendUint32(b, 6871)
+	f.Add(append(b, 2, 'h', 'i', 0))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, fieldCache := newFuzzCaches(t)
+		l := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(data)))
+		_, _ = l.Decode(bytes.NewBuffer(data))
+	})
+}
+
+func FuzzVariableLengthFieldDecode(f *testing.F) {
+	// the ids of string, octetArray, basicList, subTemplateList, and subTemplateMultiList IEs
+	f.Add(uint16(82), []byte{3, 'h', 'i', '!'})
+	f.Add(uint16(313), []byte{0xFF, 0, 2, 0xde, 0xad})
+	f.Add(uint16(291), []byte{7, byte(SemanticAllOf), 0, 4, 0, 1, 17, 6})
+	f.Add(uint16(292), []byte{9, byte(SemanticAllOf), 1, 0, 192, 0, 2, 1, 0, 80})
+	f.Add(uint16(293), []byte{10, byte(SemanticAllOf), 1, 0, 0, 10, 192, 0, 2, 1, 0, 80})
+
+	f.Fuzz(func(t *testing.T, id uint16, data []byte) {
+		templateCache, fieldCache := newFuzzCaches(t)
+		builder, err := fieldCache.GetBuilder(context.Background(), NewFieldKey(0, id))
+		if err != nil {
+			t.Skip()
+		}
+		field := builder.
+			SetLength(VariableLength).
+			SetFieldManager(fieldCache).
+			SetTemplateManager(templateCache).
+			Complete()
+		_, _ = field.Decode(bytes.NewBuffer(data))
+	})
+}
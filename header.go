@@ -18,6 +18,7 @@ package ipfix
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -34,6 +35,8 @@ const (
 	IPFIXOptions
 )
 
+// SetHeader is the 4-byte header preceding each set of an IPFIX message. Its Length includes
+// the header itself, such that an empty set has a length of 4.
 type SetHeader struct {
 	// 0 for TemplateSet, 1 for OptionsTemplateSet, and
 	// 256-65535 for DataSet as TemplateId (thus uint16)
@@ -42,23 +45,29 @@ type SetHeader struct {
 	Length uint16 `json:"length,omitempty"`
 }
 
+// Decode reads the set header from r. Lengths shorter than the set header itself are rejected
+// with ErrMalformedSet, after reading both fields.
 func (sh *SetHeader) Decode(r io.Reader) (n int, err error) {
 	t := make([]byte, 2)
-	n, err = r.Read(t)
+	n, err = io.ReadFull(r, t)
 	if err != nil {
 		return
 	}
 	sh.Id = binary.BigEndian.Uint16(t)
 
-	m, err := r.Read(t)
+	m, err := io.ReadFull(r, t)
 	n += m
 	if err != nil {
 		return
 	}
 	sh.Length = binary.BigEndian.Uint16(t)
+	if int(sh.Length) < setHeaderLength {
+		return n, fmt.Errorf("%w, illegal set length %d", ErrMalformedSet, sh.Length)
+	}
 	return
 }
 
+// Encode writes the set header to w as is, i.e., without validating its length.
 func (sh *SetHeader) Encode(w io.Writer) (n int, err error) {
 	t := make([]byte, 0)
 
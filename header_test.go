@@ -0,0 +1,89 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSetHeader(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		for _, h := range []SetHeader{
+			{Id: IPFIX, Length: 4},
+			{Id: IPFIXOptions, Length: 26},
+			{Id: 256, Length: 1400},
+			{Id: 65535, Length: 65535},
+		} {
+			buf := &bytes.Buffer{}
+			n, err := h.Encode(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 4 || buf.Len() != 4 {
+				t.Errorf("expected 4 bytes to be encoded, got %d", n)
+			}
+
+			decoded := SetHeader{}
+			n, err = decoded.Decode(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 4 {
+				t.Errorf("expected 4 bytes to be decoded, got %d", n)
+			}
+			if decoded != h {
+				t.Errorf("expected %+v, got %+v", h, decoded)
+			}
+		}
+	})
+
+	t.Run("empty set", func(t *testing.T) {
+		h := SetHeader{}
+		n, err := h.Decode(bytes.NewReader([]byte{0x01, 0x00, 0x00, 0x04}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 || h.Id != 256 || h.Length != 4 {
+			t.Errorf("unexpected header %+v after %d bytes", h, n)
+		}
+
+		// an empty template set decodes to a set without records
+		ctx := context.Background()
+		templateCache := NewDefaultEphemeralCache()
+		msg, err := NewDecoder(templateCache, NewIANAFieldManager(templateCache)).Decode(ctx, bytes.NewBuffer(newTestTemplateSetMessage(IPFIX)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Sets) != 1 || msg.Sets[0].Length != 4 || msg.Sets[0].Set.Length() != 0 {
+			t.Errorf("expected a single empty set, got %+v", msg.Sets)
+		}
+	})
+
+	t.Run("length shorter than header", func(t *testing.T) {
+		for length := byte(0); length < 4; length++ {
+			h := SetHeader{}
+			n, err := h.Decode(bytes.NewReader([]byte{0x01, 0x00, 0x00, length}))
+			if !errors.Is(err, ErrMalformedSet) {
+				t.Errorf("expected ErrMalformedSet for length %d, got %v", length, err)
+			}
+			if n != 4 {
+				t.Errorf("expected both fields to be read, got %d bytes", n)
+			}
+		}
+	})
+
+	t.Run("short read", func(t *testing.T) {
+		for _, b := range [][]byte{{0x01}, {0x01, 0x00, 0x00}} {
+			h := SetHeader{}
+			n, err := h.Decode(bytes.NewReader(b))
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+			}
+			if n != len(b) {
+				t.Errorf("expected %d bytes to be read, got %d", len(b), n)
+			}
+		}
+	})
+}
@@ -106,6 +106,14 @@ func FromNumber(i uint8) Semantic {
 	}
 }
 
+// ToNumber is the inverse of FromNumber. Undefined is mapped to the number of Default.
+func ToNumber(s Semantic) uint8 {
+	if s == Undefined || s > SNMPGauge {
+		return 0
+	}
+	return uint8(s - Default)
+}
+
 var _ fmt.Stringer = Semantic(0)
 var _ encoding.TextMarshaler = Semantic(0)
 
@@ -58,3 +58,14 @@ func FromNumber(i uint16) string {
 		return Unassigned
 	}
 }
+
+// ToNumber is the inverse of FromNumber and returns the IANA-assigned number of the unit and true,
+// or false if the unit is unknown.
+func ToNumber(unit string) (uint16, bool) {
+	for i := uint16(0); i <= 16; i++ {
+		if u := FromNumber(i); u != Unassigned && u == unit {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -35,6 +35,14 @@ func (i *InformationElementRange) Clone() *InformationElementRange {
 	}
 }
 
+// equal reports whether both ranges are either nil or have the same bounds
+func (i *InformationElementRange) equal(other *InformationElementRange) bool {
+	if i == nil || other == nil {
+		return i == other
+	}
+	return *i == *other
+}
+
 type InformationElement struct {
 	Constructor DataTypeConstructor `json:"-" yaml:"-"`
 
@@ -115,6 +123,67 @@ func (i *InformationElement) Clone() InformationElement {
 	return ie
 }
 
+// Equal reports whether i and other define the same information element, i.e., whether they
+// agree in id, PEN, name, data type, semantics, units, and range. Descriptive attributes such as
+// the description, status, or revision are not compared.
+func (i InformationElement) Equal(other InformationElement) bool {
+	return len(i.Diff(other)) == 0
+}
+
+// Conflicts reports whether other redefines i, i.e., whether both share the same id and PEN but
+// are not Equal, e.g., an information element learned from RFC 5610 options records that differs
+// from the element of a loaded registry.
+func (i InformationElement) Conflicts(other InformationElement) bool {
+	return i.Id == other.Id && i.EnterpriseId == other.EnterpriseId && !i.Equal(other)
+}
+
+// Diff returns the names of the attributes compared by Equal in which i and other differ, in the
+// order "id", "pen", "name", "type", "semantics", "units", and "range".
+func (i InformationElement) Diff(other InformationElement) []string {
+	var diff []string
+	if i.Id != other.Id {
+		diff = append(diff, "id")
+	}
+	if i.EnterpriseId != other.EnterpriseId {
+		diff = append(diff, "pen")
+	}
+	if i.Name != other.Name {
+		diff = append(diff, "name")
+	}
+	if i.dataType() != other.dataType() {
+		diff = append(diff, "type")
+	}
+	if i.Semantics != other.Semantics {
+		diff = append(diff, "semantics")
+	}
+	if stringOrEmpty(i.Units) != stringOrEmpty(other.Units) {
+		diff = append(diff, "units")
+	}
+	if !i.Range.equal(other.Range) {
+		diff = append(diff, "range")
+	}
+	return diff
+}
+
+// dataType returns the name of the data type of the element, either from its type or from its
+// constructor, as elements of registries only carry the latter
+func (i InformationElement) dataType() string {
+	if i.Type != nil {
+		return *i.Type
+	}
+	if i.Constructor != nil {
+		return i.Constructor().Type()
+	}
+	return ""
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func (i *InformationElement) UnmarshalJSON(in []byte) error {
 	type serializableInformationElement struct {
 		Id           uint16 `json:"id,omitempty" yaml:"id,omitempty"`
@@ -159,6 +228,10 @@ func (i *InformationElement) UnmarshalJSON(in []byte) error {
 		return nil
 	}
 
-	i.Constructor = LookupConstructor(*i.Type)
+	c, err := LookupConstructor(*i.Type)
+	if err != nil {
+		return err
+	}
+	i.Constructor = c
 	return nil
 }
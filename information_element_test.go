@@ -0,0 +1,99 @@
+package ipfix
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+)
+
+func TestInformationElementEqual(t *testing.T) {
+	units := "octets"
+	typ := "unsigned64"
+	description := "The number of octets since the previous report"
+	known := InformationElement{
+		Id:          1,
+		Name:        "octetDeltaCount",
+		Constructor: NewUnsigned64,
+		Semantics:   semantics.DeltaCounter,
+		Units:       &units,
+		Description: &description,
+	}
+
+	t.Run("equal definitions", func(t *testing.T) {
+		// the type of learned elements is given by name rather than constructor, and descriptive
+		// attributes are not compared
+		learned := InformationElement{
+			Id:        1,
+			Name:      "octetDeltaCount",
+			Type:      &typ,
+			Semantics: semantics.DeltaCounter,
+			Units:     &units,
+		}
+		if !known.Equal(learned) || !learned.Equal(known) {
+			t.Errorf("expected elements to be equal, differing in %v", known.Diff(learned))
+		}
+		if known.Conflicts(learned) {
+			t.Error("expected equal elements not to conflict")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		modify   func(ie *InformationElement)
+		diff     []string
+		conflict bool
+	}{
+		{
+			name: "same id with different type",
+			modify: func(ie *InformationElement) {
+				ie.Constructor = NewUnsigned32
+			},
+			diff:     []string{"type"},
+			conflict: true,
+		},
+		{
+			name: "same id with different name, semantics, and units",
+			modify: func(ie *InformationElement) {
+				ie.Name = "octetTotalCount"
+				ie.Semantics = semantics.TotalCounter
+				ie.Units = nil
+			},
+			diff:     []string{"name", "semantics", "units"},
+			conflict: true,
+		},
+		{
+			name: "same id with range",
+			modify: func(ie *InformationElement) {
+				ie.Range = &InformationElementRange{Low: 0, High: 1500}
+			},
+			diff:     []string{"range"},
+			conflict: true,
+		},
+		{
+			name: "different enterprise",
+			modify: func(ie *InformationElement) {
+				ie.EnterpriseId = 6871
+				ie.Constructor = NewOctetArray
+			},
+			diff:     []string{"pen", "type"},
+			conflict: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			other := known.Clone()
+			tc.modify(&other)
+			if known.Equal(other) {
+				t.Error("expected elements not to be equal")
+			}
+			if diff := known.Diff(other); !reflect.DeepEqual(diff, tc.diff) {
+				t.Errorf("expected elements to differ in %v, got %v", tc.diff, diff)
+			}
+			if conflict := known.Conflicts(other); conflict != tc.conflict {
+				t.Errorf("expected Conflicts to be %t, got %t", tc.conflict, conflict)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import "context"
+
+// InstrumentedTemplateCache decorates a TemplateCache with counters for template hits and misses
+// of Get, e.g., for caches used outside of a Decoder. Note that a Decoder already counts its own
+// lookups, so wrapping the cache of a decoder reporting to the same Metrics counts lookups twice.
+//
+// All other methods are passed through to the wrapped cache unchanged.
+type InstrumentedTemplateCache struct {
+	TemplateCache
+
+	collectors *Metrics
+	listener   string
+}
+
+var _ TemplateCache = &InstrumentedTemplateCache{}
+
+// NewInstrumentedTemplateCache wraps cache such that lookups are counted in the deprecated
+// package-level collectors TemplateCacheHits and TemplateCacheMisses, unless WithMetrics is used.
+func NewInstrumentedTemplateCache(cache TemplateCache) *InstrumentedTemplateCache {
+	return &InstrumentedTemplateCache{
+		TemplateCache: cache,
+	}
+}
+
+// WithMetrics makes the cache report to the given metrics instead of the deprecated package-level
+// collectors. listener is used as the label value, like for Decoder.WithMetrics.
+func (c *InstrumentedTemplateCache) WithMetrics(m *Metrics, listener string) *InstrumentedTemplateCache {
+	c.collectors = m
+	c.listener = listener
+	return c
+}
+
+// Get retrieves the template from the wrapped cache and counts the lookup as hit or miss
+func (c *InstrumentedTemplateCache) Get(ctx context.Context, key TemplateKey) (*Template, error) {
+	template, err := c.TemplateCache.Get(ctx, key)
+	observeTemplateLookup(c.collectors, c.listener, key, err)
+	return template, err
+}
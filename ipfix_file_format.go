@@ -17,9 +17,12 @@ limitations under the License.
 package ipfix
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 )
@@ -59,11 +62,17 @@ type RawMessage []byte
 //		// Do anything with the decoded message afterwards
 //	}
 func ReadFull(f io.Reader) ([]RawMessage, error) {
+	return ReadFullWithLimit(f, uint16(MaxMessageLength))
+}
+
+// ReadFullWithLimit is ReadFull for messages of at most maxLength bytes. A message header declaring a
+// longer message stops reading with an error wrapping ErrMessageTooLarge.
+func ReadFullWithLimit(f io.Reader, maxLength uint16) ([]RawMessage, error) {
 	b := make([]RawMessage, 0)
 	for {
-		msg, err := readMessage(f)
+		msg, err := readMessage(f, maxLength)
 		if msg != nil {
-			b = append(b, msg)
+			b = append(b, msg.Bytes())
 		}
 		if err != nil {
 			if err == io.EOF {
@@ -91,6 +100,47 @@ func NewIPFIXFileReader(f io.ReadCloser) *ipfixFileReader {
 	return r
 }
 
+// gzipMagic is the magic number at the beginning of every gzip stream, see RFC 1952
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// NewCompressedIPFIXFileReader creates a new reader from a file-like reader that may be gzip-compressed,
+// e.g., a file "flow_records.ipfix.gz". Compression is detected from the gzip magic number at the start
+// of f, such that uncompressed files are read just like with NewIPFIXFileReader.
+//
+// The returned reader provides the same Messages() and Errors() channels as NewIPFIXFileReader. Closing
+// it closes both the gzip reader and f.
+func NewCompressedIPFIXFileReader(f io.ReadCloser) (*ipfixFileReader, error) {
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("ipfixFileReader: failed to detect compression, %w", err)
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return NewIPFIXFileReader(&readCloser{Reader: br, closers: []io.Closer{f}}), nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("ipfixFileReader: failed to read gzip header, %w", err)
+	}
+	return NewIPFIXFileReader(&readCloser{Reader: zr, closers: []io.Closer{zr, f}}), nil
+}
+
+// readCloser reads from Reader and closes all closers in order on Close
+type readCloser struct {
+	io.Reader
+
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	errs := make([]error, 0, len(r.closers))
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
 func (r *ipfixFileReader) Start(ctx context.Context) error {
 	childCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -134,43 +184,89 @@ func (r *ipfixFileReader) Errors() <-chan error {
 	return r.errorCh
 }
 
-func readMessage(r io.Reader) ([]byte, error) {
-	var version, length uint16
-
-	var read int
-	messageHeader := make([]byte, 4)
-
-	n, err := r.Read(messageHeader)
-	if err != nil || n != 4 {
+func (r *ipfixFileReader) readMessage() ([]byte, error) {
+	msg, err := ReadMessage(r.handle)
+	if err != nil {
 		return nil, err
 	}
-	read += n
+	return msg.Bytes(), nil
+}
+
+var (
+	// ErrWriterClosed is returned by IPFIXFileWriter.WriteMessage after the writer was closed
+	ErrWriterClosed = errors.New("ipfixFileWriter: writer is closed")
+)
+
+// IPFIXFileWriter writes IPFIX messages to a file according to the IPFIX File Format of RFC 5655,
+// i.e., as a plain sequence of messages framed by the length field of their headers. Files written by
+// IPFIXFileWriter can be read using ReadFull or NewIPFIXFileReader.
+//
+//	f, _ := os.Create("flow_records.ipfix")
+//	w := ipfix.NewIPFIXFileWriter(f)
+//	defer w.Close()
+//	for _, msg := range msgs {
+//		if err := w.WriteMessage(msg); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// IPFIXFileWriter is safe for concurrent use, messages are never interleaved.
+type IPFIXFileWriter struct {
+	handle io.WriteCloser
 
-	version = binary.BigEndian.Uint16(messageHeader[0:2])
-	length = binary.BigEndian.Uint16(messageHeader[2:4])
+	mu     sync.Mutex
+	closed bool
+}
 
-	if version != 10 {
-		return nil, errors.New("ipfixFileReader: unknown protocol version number")
+// NewIPFIXFileWriter creates a new writer for a file-like writer. Closing the IPFIXFileWriter closes f.
+func NewIPFIXFileWriter(f io.WriteCloser) *IPFIXFileWriter {
+	return &IPFIXFileWriter{
+		handle: f,
 	}
+}
 
-	rem := length - 4
-	payload := make([]byte, rem)
-	n, err = r.Read(payload)
-	read += n
+// WriteMessage encodes msg and writes it to the underlying file. The message must be of version 10,
+// and its declared Length must match the number of bytes of the encoded message, otherwise, the reader
+// would not be able to frame the messages of the file again. Invalid messages are not written at all.
+func (w *IPFIXFileWriter) WriteMessage(msg *Message) error {
+	if msg == nil {
+		return errors.New("ipfixFileWriter: message is nil")
+	}
+	if msg.Version != 10 {
+		return fmt.Errorf("ipfixFileWriter: failed to write message of version %d, %w", msg.Version, ErrUnsupportedVersion)
+	}
 
-	p := make([]byte, 0)
-	p = append(p, messageHeader...)
-	p = append(p, payload[0:n]...)
+	buf := &bytes.Buffer{}
+	_, err := msg.Encode(buf)
 	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return p, err
-		}
-		return nil, err
+		return fmt.Errorf("ipfixFileWriter: failed to encode message, %w", err)
+	}
+	if buf.Len() != int(msg.Length) {
+		return fmt.Errorf("ipfixFileWriter: declared message length %d does not match encoded length %d", msg.Length, buf.Len())
 	}
 
-	return p, nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	_, err = w.handle.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("ipfixFileWriter: failed to write message, %w", err)
+	}
+	return nil
 }
 
-func (r *ipfixFileReader) readMessage() ([]byte, error) {
-	return readMessage(r.handle)
+// Close closes the underlying file. Subsequent calls to WriteMessage return ErrWriterClosed,
+// subsequent calls to Close are no-ops.
+func (w *IPFIXFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.handle.Close()
 }
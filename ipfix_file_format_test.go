@@ -0,0 +1,233 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIPFIXFileWriter(t *testing.T) {
+	ctx := context.Background()
+
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(templateCache, fieldCache)
+
+	t.Run("round trip", func(t *testing.T) {
+		raw := [][]byte{
+			newTestDataMessage(256, 1),
+			newTestDataMessage(256, 3),
+			newTestDataMessage(256, 5),
+		}
+
+		f, err := os.CreateTemp(t.TempDir(), "*.ipfix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewIPFIXFileWriter(f)
+		for _, r := range raw {
+			msg, err := decoder.Decode(ctx, bytes.NewBuffer(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.WriteMessage(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err = os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		msgs, err := ReadFull(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != len(raw) {
+			t.Fatalf("expected %d messages, found %d", len(raw), len(msgs))
+		}
+		for i, msg := range msgs {
+			if !bytes.Equal(msg, raw[i]) {
+				t.Errorf("expected message %d to be %v, found %v", i, raw[i], msg)
+			}
+		}
+	})
+
+	t.Run("invalid messages", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "*.ipfix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewIPFIXFileWriter(f)
+		defer w.Close()
+
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg.Version = 9
+		if err := w.WriteMessage(msg); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected %v, found %v", ErrUnsupportedVersion, err)
+		}
+
+		msg.Version = 10
+		msg.Length += 6
+		if err := w.WriteMessage(msg); err == nil {
+			t.Error("expected error for mismatching length, found nil")
+		}
+
+		if info, _ := f.Stat(); info.Size() != 0 {
+			t.Errorf("expected invalid messages not to be written, found %d bytes", info.Size())
+		}
+	})
+
+	t.Run("write after close", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "*.ipfix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewIPFIXFileWriter(f)
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteMessage(msg); !errors.Is(err, ErrWriterClosed) {
+			t.Errorf("expected %v, found %v", ErrWriterClosed, err)
+		}
+	})
+}
+
+// countingCloser counts calls to Close of the wrapped reader
+type countingCloser struct {
+	io.Reader
+
+	closed int
+}
+
+func (c *countingCloser) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestCompressedIPFIXFileReader(t *testing.T) {
+	raw := [][]byte{
+		newTestDataMessage(256, 1),
+		newTestDataMessage(256, 3),
+		newTestDataMessage(256, 5),
+	}
+
+	plain := &bytes.Buffer{}
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	for _, r := range raw {
+		plain.Write(r)
+		_, err := zw.Write(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"gzip":         compressed.Bytes(),
+		"uncompressed": plain.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			f := &countingCloser{Reader: bytes.NewReader(content)}
+			r, err := NewCompressedIPFIXFileReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- r.Start(ctx)
+			}()
+
+			msgs := make([][]byte, 0)
+		loop:
+			for {
+				select {
+				case msg := <-r.Messages():
+					msgs = append(msgs, msg)
+				case err := <-r.Errors():
+					if !errors.Is(err, io.EOF) {
+						t.Fatal(err)
+					}
+					break loop
+				case <-ctx.Done():
+					t.Fatal(ctx.Err())
+				}
+			}
+			cancel()
+			<-done
+
+			if len(msgs) != len(raw) {
+				t.Fatalf("expected %d messages, found %d", len(raw), len(msgs))
+			}
+			for i, msg := range msgs {
+				if !bytes.Equal(msg, raw[i]) {
+					t.Errorf("expected message %d to be %v, found %v", i, raw[i], msg)
+				}
+			}
+
+			err = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.closed != 1 {
+				t.Errorf("expected underlying file to be closed once, found %d", f.closed)
+			}
+		})
+	}
+
+	t.Run("corrupt gzip header", func(t *testing.T) {
+		_, err := NewCompressedIPFIXFileReader(&countingCloser{Reader: bytes.NewReader([]byte{0x1f, 0x8b, 0x00})})
+		if err == nil {
+			t.Error("expected error for corrupt gzip header, found nil")
+		}
+	})
+}
@@ -0,0 +1,584 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+	"github.com/zoomoid/go-ipfix/iana/status"
+)
+
+// ipfixcol2 configuration files follow the format of the information element definitions of
+// libfds, the IPFIX library of ipfixcol2, e.g.,
+//
+//	<ipfix-elements>
+//	  <scope>
+//	    <pen>6871</pen>
+//	    <name>cert</name>
+//	  </scope>
+//	  <element>
+//	    <id>111</id>
+//	    <name>httpUserAgent</name>
+//	    <dataType>string</dataType>
+//	    <dataSemantic>default</dataSemantic>
+//	    <units>none</units>
+//	    <status>current</status>
+//	  </element>
+//	  <template id="256" odid="0">
+//	    <field id="iana:sourceIPv4Address"/>
+//	    <field id="cert:httpUserAgent" length="var"/>
+//	  </template>
+//	</ipfix-elements>
+//
+// Elements belong to the scope preceding them. Templates and options templates, whose scope fields
+// are denoted by scopeField nodes, extend the format of libfds. Fields of templates are referenced
+// by the libfds identifier "scope:name", with scope names of the file, "iana", and "cert", by
+// "pen:id", or by the name of an IANA element. Reverse elements are referenced by their forward
+// element in the scope "scope@reverse", e.g., "iana@reverse:octetDeltaCount". Lengths default to
+// the length of the field's data type, or "var" for variable-length data types.
+
+const (
+	ipfixcol2Root          = "ipfix-elements"
+	ipfixcol2ReverseSuffix = "@reverse"
+)
+
+// ipfixcol2Scopes are the scope names known without being declared by a configuration file
+var ipfixcol2Scopes = map[string]uint32{
+	"iana": 0,
+	"cert": CERTPEN,
+}
+
+// ipfixcol2Unknown captures nodes not known to the format, which are ignored with a warning
+type ipfixcol2Unknown struct {
+	XMLName xml.Name
+}
+
+type ipfixcol2Biflow struct {
+	Mode  string `xml:"mode,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type ipfixcol2Scope struct {
+	PEN    uint32           `xml:"pen"`
+	Name   string           `xml:"name"`
+	Biflow *ipfixcol2Biflow `xml:"biflow,omitempty"`
+
+	Unknown []ipfixcol2Unknown `xml:",any"`
+}
+
+type ipfixcol2Element struct {
+	Id           uint16  `xml:"id"`
+	Name         string  `xml:"name"`
+	DataType     string  `xml:"dataType"`
+	DataSemantic string  `xml:"dataSemantic,omitempty"`
+	Units        string  `xml:"units,omitempty"`
+	Status       string  `xml:"status,omitempty"`
+	BiflowId     *uint16 `xml:"biflowId,omitempty"`
+
+	Unknown []ipfixcol2Unknown `xml:",any"`
+}
+
+type ipfixcol2Field struct {
+	Id     string `xml:"id,attr"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type ipfixcol2Template struct {
+	XMLName             xml.Name
+	Id                  uint16           `xml:"id,attr"`
+	ObservationDomainId uint32           `xml:"odid,attr"`
+	Name                string           `xml:"name,attr,omitempty"`
+	Scopes              []ipfixcol2Field `xml:"scopeField"`
+	Fields              []ipfixcol2Field `xml:"field"`
+
+	Unknown []ipfixcol2Unknown `xml:",any"`
+}
+
+// LoadIpfixcol2Config reads information elements and templates from an ipfixcol2 configuration
+// file and adds them to fc and tc, respectively. Nodes not known to the format are ignored and
+// logged as warnings. Templates are added after all elements of the file, such that they may
+// reference elements defined after them. tc may be nil for files without templates.
+func LoadIpfixcol2Config(ctx context.Context, r io.Reader, fc FieldCache, tc TemplateCache) error {
+	logger := FromContext(ctx)
+	l := &ipfixcol2Loader{
+		fieldCache:    fc,
+		templateCache: tc,
+		scopes:        make(map[string]uint32, len(ipfixcol2Scopes)),
+		names:         make(map[uint32]map[string]uint16),
+	}
+	for name, pen := range ipfixcol2Scopes {
+		l.scopes[name] = pen
+	}
+
+	d := xml.NewDecoder(r)
+	root, err := ipfixcol2RootElement(d)
+	if err != nil {
+		return fmt.Errorf("failed to read ipfixcol2 configuration, %w", err)
+	}
+	if root.Name.Local != ipfixcol2Root {
+		return fmt.Errorf("failed to read ipfixcol2 configuration, unexpected root node %s", root.Name.Local)
+	}
+
+	var scope *ipfixcol2Scope
+	templates := make([]ipfixcol2Template, 0)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read ipfixcol2 configuration, %w", err)
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			// end of the root node, as nested nodes are consumed by DecodeElement and Skip
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "scope":
+			s := &ipfixcol2Scope{}
+			if err := d.DecodeElement(s, &start); err != nil {
+				return fmt.Errorf("failed to read scope of ipfixcol2 configuration, %w", err)
+			}
+			warnIpfixcol2Unknown(ctx, "scope", s.Unknown)
+			scope = s
+			l.scopes[s.Name] = s.PEN
+		case "element":
+			e := ipfixcol2Element{}
+			if err := d.DecodeElement(&e, &start); err != nil {
+				return fmt.Errorf("failed to read element of ipfixcol2 configuration, %w", err)
+			}
+			warnIpfixcol2Unknown(ctx, "element", e.Unknown)
+			var pen uint32
+			if scope != nil {
+				pen = scope.PEN
+			}
+			if err := l.addElement(ctx, pen, e); err != nil {
+				return err
+			}
+		case "template", "options-template":
+			t := ipfixcol2Template{}
+			if err := d.DecodeElement(&t, &start); err != nil {
+				return fmt.Errorf("failed to read template of ipfixcol2 configuration, %w", err)
+			}
+			warnIpfixcol2Unknown(ctx, start.Name.Local, t.Unknown)
+			templates = append(templates, t)
+		default:
+			logger.Info("ignoring unknown node of ipfixcol2 configuration", "node", start.Name.Local)
+			if err := d.Skip(); err != nil {
+				return fmt.Errorf("failed to read ipfixcol2 configuration, %w", err)
+			}
+		}
+	}
+
+	if len(templates) > 0 && tc == nil {
+		return errors.New("failed to load templates of ipfixcol2 configuration, no template cache given")
+	}
+	for _, t := range templates {
+		if err := l.addTemplate(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipfixcol2RootElement returns the first start element of d, skipping the XML prolog
+func ipfixcol2RootElement(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+func warnIpfixcol2Unknown(ctx context.Context, parent string, unknown []ipfixcol2Unknown) {
+	for _, u := range unknown {
+		FromContext(ctx).Info("ignoring unknown node of ipfixcol2 configuration", "parent", parent, "node", u.XMLName.Local)
+	}
+}
+
+// ipfixcol2Loader resolves the field references of templates against the scopes and elements
+// of a configuration file and the field cache
+type ipfixcol2Loader struct {
+	fieldCache    FieldCache
+	templateCache TemplateCache
+
+	// scopes maps scope names to enterprise numbers
+	scopes map[string]uint32
+	// names maps names of elements to ids per enterprise number, the field cache is indexed
+	// lazily on the first name not defined in the file
+	names   map[uint32]map[string]uint16
+	indexed bool
+}
+
+func (l *ipfixcol2Loader) addElement(ctx context.Context, pen uint32, e ipfixcol2Element) error {
+	ie := InformationElement{
+		Id:           e.Id,
+		Name:         e.Name,
+		EnterpriseId: pen,
+		Semantics:    semantics.Parse(e.DataSemantic),
+		Status:       status.Parse(e.Status),
+	}
+	if e.Units != "" && e.Units != "none" {
+		u := normalizeUnits(e.Units)
+		ie.Units = &u
+	}
+
+	c, err := LookupConstructor(e.DataType)
+	if err != nil {
+		// like for elements announced as per RFC 5610, an unknown data type must not prevent
+		// loading the remainder of the file
+		FromContext(ctx).Info("element of ipfixcol2 configuration has an unknown data type, falling back to octetArray",
+			"enterpriseId", pen, "id", e.Id, "name", e.Name, "dataType", e.DataType)
+		c = NewOctetArray
+	}
+	typ := c().Type()
+	ie.Type = &typ
+	ie.Constructor = c
+	// list types are only decoded correctly with list semantics
+	if _, isListSemantic := dataTypesWithListSemantics[typ]; isListSemantic {
+		ie.Semantics = semantics.List
+	}
+
+	if err := l.fieldCache.Add(ctx, ie); err != nil {
+		return fmt.Errorf("failed to add information element %d/%d to field cache, %w", pen, e.Id, err)
+	}
+	l.index(pen, e.Name, e.Id)
+	return nil
+}
+
+func (l *ipfixcol2Loader) index(pen uint32, name string, id uint16) {
+	m, ok := l.names[pen]
+	if !ok {
+		m = make(map[string]uint16)
+		l.names[pen] = m
+	}
+	if _, ok := m[name]; !ok {
+		m[name] = id
+	}
+}
+
+func (l *ipfixcol2Loader) addTemplate(ctx context.Context, t ipfixcol2Template) error {
+	if t.Id < 256 {
+		return fmt.Errorf("failed to load template %d of ipfixcol2 configuration, template ids must be at least 256", t.Id)
+	}
+
+	fields := func(refs []ipfixcol2Field) ([]Field, error) {
+		fs := make([]Field, 0, len(refs))
+		for _, ref := range refs {
+			f, err := l.field(ctx, ref)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load template %d of ipfixcol2 configuration, %w", t.Id, err)
+			}
+			fs = append(fs, f)
+		}
+		return fs, nil
+	}
+
+	template := &Template{
+		TemplateMetadata: &TemplateMetadata{
+			Name:                t.Name,
+			TemplateId:          t.Id,
+			ObservationDomainId: t.ObservationDomainId,
+			CreationTimestamp:   time.Now(),
+		},
+	}
+
+	options, err := fields(t.Fields)
+	if err != nil {
+		return err
+	}
+	if t.XMLName.Local == "options-template" {
+		scopes, err := fields(t.Scopes)
+		if err != nil {
+			return err
+		}
+		if len(scopes) == 0 {
+			return fmt.Errorf("failed to load options template %d of ipfixcol2 configuration, options templates must contain at least one scope field", t.Id)
+		}
+		template.Record = &OptionsTemplateRecord{
+			TemplateId:      t.Id,
+			FieldCount:      uint16(len(scopes) + len(options)),
+			ScopeFieldCount: uint16(len(scopes)),
+			Scopes:          scopes,
+			Options:         options,
+			fieldCache:      l.fieldCache,
+			templateCache:   l.templateCache,
+		}
+	} else {
+		if len(t.Scopes) > 0 {
+			FromContext(ctx).Info("ignoring scope fields of ipfixcol2 template", "templateId", t.Id)
+		}
+		if len(options) == 0 {
+			return fmt.Errorf("failed to load template %d of ipfixcol2 configuration, template must contain at least one field", t.Id)
+		}
+		template.Record = &TemplateRecord{
+			TemplateId:    t.Id,
+			FieldCount:    uint16(len(options)),
+			Fields:        options,
+			fieldCache:    l.fieldCache,
+			templateCache: l.templateCache,
+		}
+	}
+
+	if err := l.templateCache.Add(ctx, NewKey(t.ObservationDomainId, t.Id), template); err != nil {
+		return fmt.Errorf("failed to add template %d to template cache, %w", t.Id, err)
+	}
+	return nil
+}
+
+// field creates the field referenced by ref
+func (l *ipfixcol2Loader) field(ctx context.Context, ref ipfixcol2Field) (Field, error) {
+	pen, id, reverse, err := l.resolve(ctx, ref.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := l.fieldCache.GetBuilder(ctx, NewFieldKey(pen, id))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = NewUnknownFieldBuilder(pen, id)
+	}
+
+	var length uint16
+	switch ref.Length {
+	case "":
+		length = b.GetIE().Constructor().DefaultLength()
+		if length == 0 {
+			length = VariableLength
+		}
+	case "var":
+		length = VariableLength
+	default:
+		v, err := strconv.ParseUint(ref.Length, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("illegal length %q of field %s", ref.Length, ref.Id)
+		}
+		length = uint16(v)
+	}
+
+	return b.
+		SetLength(length).
+		SetPEN(pen).
+		SetReversed(reverse).
+		SetFieldManager(l.fieldCache).
+		SetTemplateManager(l.templateCache).
+		Complete(), nil
+}
+
+// resolve returns the key of the forward element referenced by ref, and whether the reverse
+// element is referenced
+func (l *ipfixcol2Loader) resolve(ctx context.Context, ref string) (pen uint32, id uint16, reverse bool, err error) {
+	scope, name, ok := strings.Cut(ref, ":")
+	if !ok {
+		// bare names reference IANA elements
+		scope, name = "iana", ref
+	}
+
+	if p, err := strconv.ParseUint(scope, 10, 32); err == nil {
+		pen = uint32(p)
+	} else {
+		scope, reverse = strings.CutSuffix(scope, ipfixcol2ReverseSuffix)
+		p, ok := l.scopes[scope]
+		if !ok {
+			return 0, 0, false, fmt.Errorf("unknown scope %q of field %s", scope, ref)
+		}
+		pen = p
+	}
+
+	if i, err := strconv.ParseUint(name, 10, 16); err == nil {
+		id = uint16(i)
+	} else {
+		i, ok := l.lookup(ctx, pen, name)
+		if !ok {
+			return 0, 0, false, fmt.Errorf("unknown field %s", ref)
+		}
+		id = i
+	}
+
+	if forward, ok := forwardKey(pen, id); ok && !reverse {
+		// numeric references of reverse elements, e.g., 29305:1
+		return forward.EnterpriseId, forward.Id, true, nil
+	}
+	return pen, id, reverse, nil
+}
+
+func (l *ipfixcol2Loader) lookup(ctx context.Context, pen uint32, name string) (uint16, bool) {
+	if id, ok := l.names[pen][name]; ok {
+		return id, true
+	}
+	if l.indexed {
+		return 0, false
+	}
+	l.indexed = true
+	for _, ie := range l.fieldCache.GetAll(ctx) {
+		l.index(ie.EnterpriseId, ie.Name, ie.Id)
+	}
+	id, ok := l.names[pen][name]
+	return id, ok
+}
+
+// WriteIpfixcol2Config writes information elements and templates to w in the format read by
+// LoadIpfixcol2Config. Elements are grouped into a scope per enterprise number, named "iana",
+// "cert", or "pen" followed by the enterprise number. Fields of templates are referenced by name
+// if their scope is known to the reader, and by "pen:id" otherwise.
+func WriteIpfixcol2Config(w io.Writer, ies []InformationElement, templates []*Template) error {
+	scopeNames := map[uint32]string{}
+	for name, pen := range ipfixcol2Scopes {
+		scopeNames[pen] = name
+	}
+	byScope := make(map[uint32][]InformationElement)
+	for _, ie := range ies {
+		byScope[ie.EnterpriseId] = append(byScope[ie.EnterpriseId], ie)
+		if _, ok := scopeNames[ie.EnterpriseId]; !ok {
+			scopeNames[ie.EnterpriseId] = fmt.Sprintf("pen%d", ie.EnterpriseId)
+		}
+	}
+	pens := make([]uint32, 0, len(byScope))
+	for pen := range byScope {
+		pens = append(pens, pen)
+	}
+	slices.Sort(pens)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	root := xml.StartElement{Name: xml.Name{Local: ipfixcol2Root}}
+	if err := e.EncodeToken(root); err != nil {
+		return err
+	}
+
+	for _, pen := range pens {
+		scope := ipfixcol2Scope{PEN: pen, Name: scopeNames[pen]}
+		if err := e.EncodeElement(scope, xml.StartElement{Name: xml.Name{Local: "scope"}}); err != nil {
+			return err
+		}
+		elements := byScope[pen]
+		slices.SortFunc(elements, func(a, b InformationElement) int {
+			return cmp.Compare(a.Id, b.Id)
+		})
+		for _, ie := range elements {
+			if err := e.EncodeElement(newIpfixcol2Element(ie), xml.StartElement{Name: xml.Name{Local: "element"}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	templates = slices.Clone(templates)
+	slices.SortFunc(templates, func(a, b *Template) int {
+		ka, kb := a.Key(), b.Key()
+		if c := cmp.Compare(ka.ObservationDomainId, kb.ObservationDomainId); c != 0 {
+			return c
+		}
+		return cmp.Compare(ka.TemplateId, kb.TemplateId)
+	})
+	for _, t := range templates {
+		it := ipfixcol2Template{}
+		key := t.Key()
+		it.Id, it.ObservationDomainId = key.TemplateId, key.ObservationDomainId
+		if t.TemplateMetadata != nil {
+			it.Name = t.Name
+		}
+		switch r := t.Record.(type) {
+		case *TemplateRecord:
+			it.XMLName.Local = "template"
+			it.Fields = newIpfixcol2Fields(scopeNames, r.Fields)
+		case *OptionsTemplateRecord:
+			it.XMLName.Local = "options-template"
+			it.Scopes = newIpfixcol2Fields(scopeNames, r.Scopes)
+			it.Fields = newIpfixcol2Fields(scopeNames, r.Options)
+		default:
+			return fmt.Errorf("cannot use %T as template for templates.Template", r)
+		}
+		if err := e.Encode(it); err != nil {
+			return err
+		}
+	}
+
+	if err := e.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newIpfixcol2Element(ie InformationElement) ipfixcol2Element {
+	e := ipfixcol2Element{
+		Id:           ie.Id,
+		Name:         ie.Name,
+		DataSemantic: ie.Semantics.String(),
+		Units:        "none",
+		Status:       ie.Status.String(),
+	}
+	if ie.Type != nil {
+		e.DataType = *ie.Type
+	} else if ie.Constructor != nil {
+		e.DataType = ie.Constructor().Type()
+	}
+	if ie.Units != nil {
+		e.Units = *ie.Units
+	}
+	return e
+}
+
+func newIpfixcol2Fields(scopeNames map[uint32]string, fields []Field) []ipfixcol2Field {
+	refs := make([]ipfixcol2Field, 0, len(fields))
+	for _, f := range fields {
+		ref := ipfixcol2Field{}
+		scope, ok := scopeNames[f.PEN()]
+		if name := f.Prototype().Name; ok && name != "" && name != unknownFieldName(f.PEN(), f.Id()) {
+			if f.Reversed() {
+				scope += ipfixcol2ReverseSuffix
+			}
+			ref.Id = scope + ":" + name
+		} else {
+			key := wireKey(f)
+			ref.Id = fmt.Sprintf("%d:%d", key.EnterpriseId, key.Id)
+		}
+
+		switch ff := f.(type) {
+		case *VariableLengthField:
+			ref.Length = "var"
+		default:
+			ref.Length = strconv.FormatUint(uint64(ff.Length()), 10)
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
@@ -0,0 +1,212 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+)
+
+func loadIpfixcol2Fixture(t *testing.T, ctx context.Context, name string, fc FieldCache, tc TemplateCache) {
+	f, err := os.Open(filepath.Join("testdata", "ipfixcol2", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := LoadIpfixcol2Config(ctx, f, fc, tc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestYafHTTPMessage creates a message of a single record of the yaf HTTP template defined
+// in testdata/ipfixcol2/yaf_dpi.xml
+func newTestYafHTTPMessage() []byte {
+	record := []byte{192, 0, 2, 1, 198, 51, 100, 2}
+	record = binary.BigEndian.AppendUint16(record, 49152)
+	record = binary.BigEndian.AppendUint16(record, 80)
+	for _, s := range []string{"example.com", "/index.html", "curl/8.0"} {
+		record = append(record, byte(len(s)))
+		record = append(record, s...)
+	}
+	record = binary.BigEndian.AppendUint32(record, 1500)
+
+	b := binary.BigEndian.AppendUint16(nil, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(16+4+len(record)))
+	b = binary.BigEndian.AppendUint32(b, 1700000000)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 50688)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(record)))
+	return append(b, record...)
+}
+
+func TestIpfixcol2Config(t *testing.T) {
+	t.Run("elements of libfds", func(t *testing.T) {
+		ctx := context.Background()
+		tc := NewDefaultEphemeralCache()
+		fc := NewIANAFieldManager(tc)
+		loadIpfixcol2Fixture(t, ctx, "cert.xml", fc, nil)
+
+		b, err := fc.GetBuilder(ctx, NewFieldKey(CERTPEN, 111))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ie := b.GetIE(); ie.Name != "httpUserAgent" || *ie.Type != "string" || ie.EnterpriseId != CERTPEN {
+			t.Errorf("unexpected element %s", ie)
+		}
+		b, err = fc.GetBuilder(ctx, NewFieldKey(CERTPEN, 14))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ie := b.GetIE(); ie.Name != "initialTCPFlags" || ie.Semantics != semantics.Flags || ie.Units != nil {
+			t.Errorf("unexpected element %s", ie)
+		}
+	})
+
+	t.Run("yaf DPI template decodes data set", func(t *testing.T) {
+		ctx := context.Background()
+		tc := NewDefaultEphemeralCache()
+		fc := NewIANAFieldManager(tc)
+		loadIpfixcol2Fixture(t, ctx, "cert.xml", fc, nil)
+		loadIpfixcol2Fixture(t, ctx, "yaf_dpi.xml", fc, tc)
+
+		template, err := tc.Get(ctx, NewKey(0, 50688))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if template.Name != "yaf_http" || template.TemplateId != 50688 {
+			t.Errorf("unexpected metadata %+v", template.TemplateMetadata)
+		}
+
+		msg, err := NewDecoder(tc, fc).Decode(ctx, bytes.NewBuffer(newTestYafHTTPMessage()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := msg.Sets[0].Set.(*DataSet).Records
+		if len(records) != 1 {
+			t.Fatalf("expected a single record, got %d", len(records))
+		}
+		record := records[0]
+		for name, expected := range map[string]string{
+			"httpHost":      "example.com",
+			"httpGet":       "/index.html",
+			"httpUserAgent": "curl/8.0",
+		} {
+			f := record.getFieldByName(CERTPEN, name)
+			if f == nil {
+				t.Fatalf("expected field %s in record %s", name, record.String())
+			}
+			if v := f.Value().Value(); v != expected {
+				t.Errorf("expected %s to be %q, got %v", name, expected, v)
+			}
+		}
+		if v, ok := record.Uint64("destinationTransportPort"); !ok || v != 80 {
+			t.Errorf("expected destination port 80, got %d", v)
+		}
+		reversed := record.Fields[len(record.Fields)-1]
+		if !reversed.Reversed() || reversed.Id() != 1 || reversed.Value().Value() != uint64(1500) {
+			t.Errorf("expected reversed octetDeltaCount of 1500, got %s", reversed)
+		}
+	})
+
+	t.Run("unknown nodes are ignored with warnings", func(t *testing.T) {
+		mu := sync.Mutex{}
+		warnings := make([]string, 0)
+		ctx := logr.NewContext(context.Background(), funcr.New(func(prefix, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, args)
+		}, funcr.Options{}))
+
+		tc := NewDefaultEphemeralCache()
+		fc := NewIANAFieldManager(tc)
+		loadIpfixcol2Fixture(t, ctx, "cert.xml", fc, nil)
+		loadIpfixcol2Fixture(t, ctx, "yaf_dpi.xml", fc, tc)
+
+		for _, node := range []string{"inputPlugins", "comment"} {
+			found := false
+			for _, w := range warnings {
+				found = found || strings.Contains(w, `"node"="`+node+`"`)
+			}
+			if !found {
+				t.Errorf("expected warning for node %s, got %v", node, warnings)
+			}
+		}
+	})
+
+	t.Run("unresolvable fields", func(t *testing.T) {
+		ctx := context.Background()
+		for _, ref := range []string{"foo:httpHost", "cert:httpHost", "iana:noSuchElement"} {
+			tc := NewDefaultEphemeralCache()
+			fc := NewIANAFieldManager(tc)
+			config := `<ipfix-elements><template id="256"><field id="` + ref + `"/></template></ipfix-elements>`
+			if err := LoadIpfixcol2Config(ctx, strings.NewReader(config), fc, tc); err == nil {
+				t.Errorf("expected error for field %s", ref)
+			}
+		}
+	})
+
+	t.Run("unexpected root", func(t *testing.T) {
+		tc := NewDefaultEphemeralCache()
+		err := LoadIpfixcol2Config(context.Background(), strings.NewReader("<ipfix-aliases/>"), NewIANAFieldManager(tc), tc)
+		if err == nil {
+			t.Error("expected error for unexpected root node")
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		ctx := context.Background()
+		tc := NewDefaultEphemeralCache()
+		fc := NewIANAFieldManager(tc)
+		loadIpfixcol2Fixture(t, ctx, "cert.xml", fc, nil)
+		loadIpfixcol2Fixture(t, ctx, "yaf_dpi.xml", fc, tc)
+		template, err := tc.Get(ctx, NewKey(0, 50688))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ies := make([]InformationElement, 0)
+		for key, ie := range fc.GetAll(ctx) {
+			if key.EnterpriseId == CERTPEN {
+				ies = append(ies, *ie)
+			}
+		}
+		buf := &bytes.Buffer{}
+		if err := WriteIpfixcol2Config(buf, ies, []*Template{template}); err != nil {
+			t.Fatal(err)
+		}
+
+		// a fresh field cache knows CERT's elements only from the written file
+		rtc := NewDefaultEphemeralCache()
+		rfc := NewIANAFieldManager(rtc)
+		if err := LoadIpfixcol2Config(ctx, bytes.NewReader(buf.Bytes()), rfc, rtc); err != nil {
+			t.Fatalf("failed to load written configuration %s, %v", buf.String(), err)
+		}
+		restored, err := rtc.Get(ctx, NewKey(0, 50688))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, actual := &bytes.Buffer{}, &bytes.Buffer{}
+		if err := template.Describe(expected, TemplateFormatCSV); err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.Describe(actual, TemplateFormatCSV); err != nil {
+			t.Fatal(err)
+		}
+		if expected.String() != actual.String() {
+			t.Errorf("expected template\n%s\ngot\n%s", expected.String(), actual.String())
+		}
+		if restored.Name != "yaf_http" {
+			t.Errorf("expected name of template to be restored, got %q", restored.Name)
+		}
+	})
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package ipfixtest provides a conformance harness for decoding captured IPFIX messages against
+golden files.
+
+A fixture is a file of IPFIX messages in IPFIX File Format (RFC 5655), i.e., messages
+concatenated without any framing, e.g., as written by yaf or extracted from a packet capture.
+Next to each fixture NAME.ipfix, the harness expects a golden file NAME.golden.json containing
+the JSON encoding of all decoded messages. Each fixture is decoded with a fresh pair of caches,
+and compared to its golden file. Afterwards, each decoded message is encoded again and compared
+byte by byte to the original message, unless the fixture is marked as non-canonical, e.g.,
+because its exporter pads sets, which is lost during decoding.
+
+Downstream users may run their own captures through the harness:
+
+	func TestCaptures(t *testing.T) {
+		ipfixtest.Run(t, "testdata/captures",
+			ipfixtest.WithNonCanonical("router"),
+		)
+	}
+
+Golden files are (re-)generated by running the tests with the -ipfixtest.update flag:
+
+	go test -run TestCaptures . -ipfixtest.update
+*/
+package ipfixtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zoomoid/go-ipfix"
+)
+
+// FixtureExtension is the file extension of fixtures discovered by Fixtures
+const FixtureExtension = ".ipfix"
+
+// GoldenExtension is the file extension of golden files next to fixtures
+const GoldenExtension = ".golden.json"
+
+var update = flag.Bool("ipfixtest.update", false, "regenerate the golden files of ipfixtest fixtures")
+
+// Fixture is a file of IPFIX messages, and the golden file of its decoded messages
+type Fixture struct {
+	// Name of the fixture, i.e., the file name without FixtureExtension
+	Name string
+	// Path of the file containing the messages in IPFIX File Format
+	Path string
+	// Golden is the path of the file containing the JSON encoding of the decoded messages
+	Golden string
+}
+
+// Fixtures returns all fixtures in dir sorted by name, i.e., all files ending in FixtureExtension.
+func Fixtures(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+FixtureExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fixtures in %s, %w", dir, err)
+	}
+	sort.Strings(paths)
+	fixtures := make([]Fixture, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), FixtureExtension)
+		fixtures = append(fixtures, Fixture{
+			Name:   name,
+			Path:   path,
+			Golden: filepath.Join(dir, name+GoldenExtension),
+		})
+	}
+	return fixtures, nil
+}
+
+type options struct {
+	fieldCache     func(ipfix.TemplateCache) (ipfix.FieldCache, error)
+	decoderOptions []ipfix.DecoderOption
+	nonCanonical   map[string]struct{}
+	update         bool
+}
+
+// Option configures Run and Check
+type Option func(*options)
+
+// WithFieldCache sets the constructor of each fixture's field cache. By default, fields are
+// resolved from a cache containing all IANA information elements.
+func WithFieldCache(fn func(ipfix.TemplateCache) (ipfix.FieldCache, error)) Option {
+	return func(o *options) {
+		o.fieldCache = fn
+	}
+}
+
+// WithDecoderOptions configures the decoder of each fixture
+func WithDecoderOptions(opts ...ipfix.DecoderOption) Option {
+	return func(o *options) {
+		o.decoderOptions = append(o.decoderOptions, opts...)
+	}
+}
+
+// WithNonCanonical marks the fixtures of the given names as non-canonical, i.e., their messages
+// are not expected to encode to the original bytes after decoding.
+func WithNonCanonical(names ...string) Option {
+	return func(o *options) {
+		for _, name := range names {
+			o.nonCanonical[name] = struct{}{}
+		}
+	}
+}
+
+// WithUpdate overrides the -ipfixtest.update flag. If set, golden files are written instead of
+// compared.
+func WithUpdate(update bool) Option {
+	return func(o *options) {
+		o.update = update
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		fieldCache: func(tc ipfix.TemplateCache) (ipfix.FieldCache, error) {
+			return ipfix.NewIANAFieldCache(tc), nil
+		},
+		nonCanonical: make(map[string]struct{}),
+		update:       *update,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Run checks all fixtures in dir in a subtest each. It fails if dir contains no fixtures.
+func Run(t *testing.T, dir string, opts ...Option) {
+	t.Helper()
+	fixtures, err := Fixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no fixtures in %s", dir)
+	}
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			Check(t, fixture, opts...)
+		})
+	}
+}
+
+// Check decodes all messages of the fixture with a fresh pair of caches, and compares their JSON
+// encoding to the fixture's golden file. Messages of canonical fixtures are additionally encoded
+// again and compared to the original bytes.
+func Check(t testing.TB, fixture Fixture, opts ...Option) {
+	t.Helper()
+	o := newOptions(opts...)
+
+	f, err := os.Open(fixture.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	raw, err := ipfix.ReadFull(f)
+	if err != nil {
+		t.Fatalf("failed to read messages of fixture %s, %v", fixture.Name, err)
+	}
+
+	templateCache := ipfix.NewDefaultEphemeralCache()
+	fieldCache, err := o.fieldCache(templateCache)
+	if err != nil {
+		t.Fatalf("failed to create field cache, %v", err)
+	}
+	decoder := ipfix.NewDecoderWithOptions(templateCache, fieldCache, o.decoderOptions...)
+
+	_, nonCanonical := o.nonCanonical[fixture.Name]
+	messages := make([]json.RawMessage, 0, len(raw))
+	for i, msg := range raw {
+		decoded, err := decoder.Decode(context.Background(), bytes.NewBuffer(msg))
+		if err != nil {
+			t.Fatalf("failed to decode message %d of fixture %s, %v", i, fixture.Name, err)
+		}
+		// marshal right away, decoded messages must not outlive decoding of the next one
+		b, err := json.Marshal(decoded)
+		if err != nil {
+			t.Fatalf("failed to marshal message %d of fixture %s, %v", i, fixture.Name, err)
+		}
+		messages = append(messages, b)
+
+		if err := checkRecordLengths(decoded); err != nil {
+			t.Errorf("message %d of fixture %s, %v", i, fixture.Name, err)
+		}
+		if nonCanonical {
+			continue
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := decoded.Encode(encoded); err != nil {
+			t.Fatalf("failed to encode message %d of fixture %s, %v", i, fixture.Name, err)
+		}
+		if d := diffBytes(msg, encoded.Bytes()); d != "" {
+			t.Errorf("message %d of fixture %s does not encode to its original bytes: %s", i, fixture.Name, d)
+		}
+	}
+
+	actual, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	if o.update {
+		if err := os.WriteFile(fixture.Golden, actual, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(fixture.Golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file of fixture %s, run with -ipfixtest.update to create it, %v", fixture.Name, err)
+	}
+	if d := diffLines(expected, actual); d != "" {
+		t.Errorf("decoded messages of fixture %s do not match golden file %s: %s", fixture.Name, fixture.Golden, d)
+	}
+}
+
+// record is implemented by all records of sets
+type record interface {
+	Encode(w io.Writer) (int, error)
+	Length() uint16
+}
+
+// checkRecordLengths checks that the length of each record of msg matches its encoding, such that
+// sets and messages built from the records are framed correctly.
+func checkRecordLengths(msg *ipfix.Message) error {
+	for i, set := range msg.Sets {
+		records := make([]record, 0)
+		switch s := set.Set.(type) {
+		case *ipfix.TemplateSet:
+			for j := range s.Records {
+				records = append(records, &s.Records[j])
+			}
+		case *ipfix.OptionsTemplateSet:
+			for j := range s.Records {
+				records = append(records, &s.Records[j])
+			}
+		case *ipfix.DataSet:
+			for j := range s.Records {
+				records = append(records, &s.Records[j])
+			}
+		}
+		for j, r := range records {
+			b := &bytes.Buffer{}
+			if _, err := r.Encode(b); err != nil {
+				return fmt.Errorf("failed to encode record %d of set %d, %w", j, i, err)
+			}
+			if int(r.Length()) != b.Len() {
+				return fmt.Errorf("record %d of set %d has length %d, but encodes to %d bytes", j, i, r.Length(), b.Len())
+			}
+		}
+	}
+	return nil
+}
+
+// diffBytes describes the first difference between expected and actual, or returns an empty
+// string if they are equal.
+func diffBytes(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+	i := 0
+	for i < len(expected) && i < len(actual) && expected[i] == actual[i] {
+		i++
+	}
+	window := func(b []byte) []byte {
+		return b[i:min(i+16, len(b))]
+	}
+	return fmt.Sprintf("expected %d bytes, got %d, first difference at offset %d: expected % x, got % x",
+		len(expected), len(actual), i, window(expected), window(actual))
+}
+
+// diffLines describes the first differing line of expected and actual, or returns an empty
+// string if they are equal.
+func diffLines(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+	e, a := strings.Split(string(expected), "\n"), strings.Split(string(actual), "\n")
+	i := 0
+	for i < len(e) && i < len(a) && e[i] == a[i] {
+		i++
+	}
+	line := func(lines []string) string {
+		if i >= len(lines) {
+			return "<EOF>"
+		}
+		return strings.TrimSpace(lines[i])
+	}
+	return fmt.Sprintf("first difference in line %d: expected %s, got %s", i+1, line(e), line(a))
+}
@@ -0,0 +1,70 @@
+package ipfixtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	dir := t.TempDir()
+	fixture, err := os.ReadFile("../testdata/conformance/softflowd.ipfix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.ipfix", "a.ipfix", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), fixture, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("discovery", func(t *testing.T) {
+		fixtures, err := Fixtures(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fixtures) != 2 || fixtures[0].Name != "a" || fixtures[1].Name != "b" {
+			t.Fatalf("expected fixtures a and b, got %v", fixtures)
+		}
+		if fixtures[0].Golden != filepath.Join(dir, "a"+GoldenExtension) {
+			t.Errorf("expected golden file next to fixture, got %s", fixtures[0].Golden)
+		}
+	})
+
+	t.Run("update writes golden files", func(t *testing.T) {
+		Run(t, dir, WithUpdate(true))
+		golden, err := os.ReadFile(filepath.Join(dir, "a"+GoldenExtension))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile("../testdata/conformance/softflowd" + GoldenExtension)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(golden) != string(expected) {
+			t.Error("expected written golden file to equal the one of the conformance fixture")
+		}
+		Run(t, dir)
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	if d := diffLines([]byte("a\nb\n"), []byte("a\nb\n")); d != "" {
+		t.Errorf("expected no difference, got %s", d)
+	}
+	d := diffLines([]byte("a\nb\nc\n"), []byte("a\nx\nc\n"))
+	if !strings.Contains(d, "line 2") || !strings.Contains(d, "expected b, got x") {
+		t.Errorf("expected difference in line 2, got %s", d)
+	}
+	if d := diffLines([]byte("a"), []byte("a\nb")); !strings.Contains(d, "expected <EOF>, got b") {
+		t.Errorf("expected difference at the end, got %s", d)
+	}
+}
+
+func TestDiffBytes(t *testing.T) {
+	d := diffBytes([]byte{0, 1, 2, 3}, []byte{0, 1, 4})
+	if !strings.Contains(d, "offset 2") || !strings.Contains(d, "expected 4 bytes, got 3") {
+		t.Errorf("expected difference at offset 2, got %s", d)
+	}
+}
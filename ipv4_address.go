@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 )
 
 type IPv4Address struct {
@@ -43,14 +44,38 @@ func (t *IPv4Address) Value() interface{} {
 	return t.value
 }
 
-func (t *IPv4Address) SetValue(v any) DataType {
+// SetValueE sets IPv4 addresses as value, given as string, net.IP, netip.Addr, or 4 bytes.
+// IPv4-mapped IPv6 addresses are converted to IPv4, all other IPv6 addresses return an error.
+func (t *IPv4Address) SetValueE(v any) (DataType, error) {
+	var ip net.IP
 	switch b := v.(type) {
 	case string:
-		t.value = net.ParseIP(b)
+		ip = net.ParseIP(b).To4()
 	case net.IP:
-		t.value = b
+		ip = b.To4()
+	case netip.Addr:
+		if b.Unmap().Is4() {
+			ip = net.IP(b.Unmap().AsSlice())
+		}
+	case []byte:
+		if len(b) == net.IPv4len {
+			ip = net.IP(b)
+		}
 	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T in %T", v, t.value, t))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not an address", t, ErrInvalidValue, v)
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %v is not an IPv4 address", t, ErrInvalidValue, v)
+	}
+	t.value = ip
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *IPv4Address) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -64,8 +89,13 @@ func (*IPv4Address) DefaultLength() uint16 {
 }
 
 func (t *IPv4Address) Clone() DataType {
+	var v net.IP
+	if t.value != nil {
+		v = make(net.IP, len(t.value))
+		copy(v, t.value)
+	}
 	return &IPv4Address{
-		value: t.value,
+		value: v,
 	}
 }
 
@@ -83,8 +113,9 @@ func (*IPv4Address) IsReducedLength() bool {
 }
 
 func (t *IPv4Address) Decode(in io.Reader) (n int, err error) {
+	// the value retains b, so unlike for numeric types it is not taken from the scratch pool
 	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -101,7 +132,15 @@ func (t *IPv4Address) MarshalJSON() ([]byte, error) {
 }
 
 func (t *IPv4Address) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	err := json.Unmarshal(in, &t.value)
+	if err != nil {
+		return err
+	}
+	// net.IP unmarshals to the 16-byte form, which would be encoded as such
+	if v4 := t.value.To4(); v4 != nil {
+		t.value = v4
+	}
+	return nil
 }
 
 var _ DataTypeConstructor = NewIPv4Address
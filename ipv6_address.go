@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 )
 
 type IPv6Address struct {
@@ -43,14 +44,39 @@ func (t *IPv6Address) Value() interface{} {
 	return t.value
 }
 
-func (t *IPv6Address) SetValue(v any) DataType {
+// SetValueE sets IP addresses as value, given as string, net.IP, netip.Addr, or 16 bytes. IPv4
+// addresses are converted to IPv4-mapped IPv6 addresses.
+func (t *IPv6Address) SetValueE(v any) (DataType, error) {
+	var ip net.IP
 	switch b := v.(type) {
 	case string:
-		t.value = net.ParseIP(b)
+		ip = net.ParseIP(b).To16()
 	case net.IP:
-		t.value = b
+		ip = b.To16()
+	case netip.Addr:
+		if b.IsValid() {
+			a := b.As16()
+			ip = net.IP(a[:])
+		}
+	case []byte:
+		if len(b) == net.IPv6len {
+			ip = net.IP(b)
+		}
 	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T in %T", v, t.value, t))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not an address", t, ErrInvalidValue, v)
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %v is not an IP address", t, ErrInvalidValue, v)
+	}
+	t.value = ip
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *IPv6Address) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -64,8 +90,13 @@ func (t *IPv6Address) DefaultLength() uint16 {
 }
 
 func (t *IPv6Address) Clone() DataType {
+	var v net.IP
+	if t.value != nil {
+		v = make(net.IP, len(t.value))
+		copy(v, t.value)
+	}
 	return &IPv6Address{
-		value: t.value,
+		value: v,
 	}
 }
 
@@ -83,8 +114,9 @@ func (*IPv6Address) IsReducedLength() bool {
 }
 
 func (t *IPv6Address) Decode(in io.Reader) (n int, err error) {
+	// the value retains b, so unlike for numeric types it is not taken from the scratch pool
 	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
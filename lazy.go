@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// rawValue retains the bytes of a field's value as received, for fields decoded with
+// DecoderOptions.LazyFieldValues. The bytes are owned by the field and never modified, such that
+// clones of a field share them.
+type rawValue struct {
+	// bytes of the value, without the length prefix of variable-length fields
+	bytes []byte
+	// pending is true until the value was decoded from bytes
+	pending bool
+	// touched is true once the value was handed out by Value or replaced by SetValue, after which
+	// Encode encodes the value instead of writing bytes
+	touched bool
+}
+
+// retained returns true if Encode can write the bytes instead of encoding the value
+func (r *rawValue) retained() bool {
+	return r.bytes != nil && !r.touched
+}
+
+// deferrable returns true for data types whose decoding can be deferred until their value is
+// accessed. These are octetArray and string, which are the bulk of DPI fields and can be decoded
+// from any sequence of bytes, such that deferring cannot hide decoding errors. Strings rejecting
+// invalid UTF-8 are therefore decoded eagerly.
+func deferrable(dt DataType) bool {
+	switch t := dt.(type) {
+	case *OctetArray:
+		return true
+	case *String:
+		return t.policy != UTF8PolicyReject
+	}
+	return false
+}
+
+// writeVariableLength writes b to w prefixed by its length, in the long format if longFormat is
+// set or the length does not fit into the short format
+func writeVariableLength(w io.Writer, b []byte, longFormat bool) (int, error) {
+	var prefix []byte
+	if len(b) >= 255 || longFormat {
+		prefix = binary.BigEndian.AppendUint16([]byte{0xFF}, uint16(len(b)))
+	} else {
+		prefix = []byte{byte(len(b))}
+	}
+	return w.Write(append(prefix, b...))
+}
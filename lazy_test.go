@@ -0,0 +1,181 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestDPITemplate creates a template set of template 256 with sourceTransportPort,
+// ipHeaderPacketSection (octetArray), interfaceName (string), and sourceIPv4Address
+func newTestDPITemplate() []byte {
+	b := binary.BigEndian.AppendUint16(nil, 256)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	for _, f := range [][2]uint16{{7, 2}, {313, VariableLength}, {82, VariableLength}, {8, 4}} {
+		b = binary.BigEndian.AppendUint16(b, f[0])
+		b = binary.BigEndian.AppendUint16(b, f[1])
+	}
+	return b
+}
+
+// newTestDPIRecords creates n records of the template of newTestDPITemplate, each carrying a
+// packet section of the given length. The interface name is encoded in the long length format
+// despite being short, which encoders only reproduce if they retain the format.
+func newTestDPIRecords(n int, length int) []byte {
+	section := bytes.Repeat([]byte{0xab}, length)
+	b := make([]byte, 0, n*(length+20))
+	for i := 0; i < n; i++ {
+		b = binary.BigEndian.AppendUint16(b, 443)
+		b = append(b, 0xff)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(section)))
+		b = append(b, section...)
+		b = append(b, 0xff, 0x00, 0x04)
+		b = append(b, "eth0"...)
+		b = append(b, 192, 0, 2, 1)
+	}
+	return b
+}
+
+func TestLazyFieldValues(t *testing.T) {
+	ctx := context.Background()
+
+	// decode decodes data after the message of newTestDPITemplate, and returns the decoded message
+	decode := func(t testing.TB, data []byte, opts ...DecoderOption) *Message {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoderWithOptions(templateCache, NewIANAFieldManager(templateCache), opts...)
+		_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(1700000000, 0, IPFIX, newTestDPITemplate())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	data := newTestCollectorMessage(1700000001, 0, 256, newTestDPIRecords(2, 300))
+
+	t.Run("round trip is byte-identical", func(t *testing.T) {
+		msg := decode(t, data, WithLazyFieldValues())
+		buf := &bytes.Buffer{}
+		if _, err := msg.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("expected re-encoded message to be identical\nexpected %x\ngot      %x", data, buf.Bytes())
+		}
+	})
+
+	t.Run("values are decoded on access", func(t *testing.T) {
+		msg := decode(t, data, WithLazyFieldValues())
+		record := msg.Sets[0].Set.(*DataSet).Records[0]
+
+		section := record.Fields[1]
+		if raw := section.RawBytes(); !bytes.Equal(raw, bytes.Repeat([]byte{0xab}, 300)) {
+			t.Errorf("expected raw bytes of packet section, got %x", raw)
+		}
+		if v, ok := section.Value().Value().([]byte); !ok || len(v) != 300 || v[0] != 0xab {
+			t.Errorf("expected decoded packet section, got %v", section.Value())
+		}
+		if v := record.Fields[2].Value().Value(); v != "eth0" {
+			t.Errorf("expected interface name eth0, got %v", v)
+		}
+		if raw := record.Fields[0].RawBytes(); !bytes.Equal(raw, []byte{0x01, 0xbb}) {
+			t.Errorf("expected raw bytes of eagerly decoded port, got %x", raw)
+		}
+		if port, ok := record.Uint64("sourceTransportPort"); !ok || port != 443 {
+			t.Errorf("expected port 443, got %d", port)
+		}
+		if length := record.Fields[1].Length(); length != 303 {
+			t.Errorf("expected length 303 of packet section including prefix, got %d", length)
+		}
+
+		// accessing values still encodes identically
+		buf := &bytes.Buffer{}
+		if _, err := msg.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("expected re-encoded message to be identical after accessing values")
+		}
+	})
+
+	t.Run("set values are encoded", func(t *testing.T) {
+		msg := decode(t, data, WithLazyFieldValues())
+		record := msg.Sets[0].Set.(*DataSet).Records[0]
+		record.Fields[1].SetValue([]byte{0xcd})
+		record.Fields[3].SetValue("198.51.100.1")
+
+		buf := &bytes.Buffer{}
+		if _, err := record.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		expected := binary.BigEndian.AppendUint16(nil, 443)
+		// the long length format of the received field is kept
+		expected = append(expected, 0xff, 0x00, 0x01, 0xcd)
+		expected = append(expected, 0xff, 0x00, 0x04)
+		expected = append(expected, "eth0"...)
+		expected = append(expected, 198, 51, 100, 1)
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("expected %x, got %x", expected, buf.Bytes())
+		}
+		if raw := record.Fields[1].RawBytes(); len(raw) != 300 {
+			t.Errorf("expected raw bytes to remain as received, got %d bytes", len(raw))
+		}
+	})
+
+	t.Run("clones retain bytes", func(t *testing.T) {
+		msg := decode(t, data, WithLazyFieldValues())
+		f := msg.Sets[0].Set.(*DataSet).Records[0].Fields[2]
+		c := f.Clone()
+		if !bytes.Equal(c.RawBytes(), []byte("eth0")) {
+			t.Errorf("expected clone to retain raw bytes, got %x", c.RawBytes())
+		}
+		if v := c.Value().Value(); v != "eth0" {
+			t.Errorf("expected clone to decode interface name, got %v", v)
+		}
+	})
+
+	t.Run("eager decoding retains no bytes", func(t *testing.T) {
+		msg := decode(t, data)
+		for _, f := range msg.Sets[0].Set.(*DataSet).Records[0].Fields {
+			if f.RawBytes() != nil {
+				t.Errorf("expected no raw bytes in field %s", f.Name())
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeLazyFieldValues(b *testing.B) {
+	ctx := context.Background()
+	data := newTestCollectorMessage(1700000001, 0, 256, newTestDPIRecords(16, 2048))
+
+	for name, opts := range map[string][]DecoderOption{
+		"eager": nil,
+		"lazy":  {WithLazyFieldValues()},
+	} {
+		b.Run(name, func(b *testing.B) {
+			templateCache := NewDefaultEphemeralCache()
+			decoder := NewDecoderWithOptions(templateCache, NewIANAFieldManager(templateCache), opts...)
+			_, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(1700000000, 0, IPFIX, newTestDPITemplate())))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msg, err := decoder.Decode(ctx, bytes.NewBuffer(data))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := msg.Encode(&bytes.Buffer{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// DecodeLimits bound the resources used for decoding a single message, such that exporters cannot
+// craft messages with deeply nested or excessively long structured data types of RFC 6313 that
+// exhaust the collector's stack or memory. A value of 0 disables the respective limit.
+//
+// Data sets exceeding a limit are dropped with a DecodeLimitError, and counted in
+// DecodeStats.DroppedSets and DecodeStats.DroppedRecords, while the other sets of the message
+// are still decoded.
+type DecodeLimits struct {
+	// MaxNestingDepth is the maximum depth of nested basicList, subTemplateList, and
+	// subTemplateMultiList fields, e.g., 1 allows lists in data records, but no lists in lists.
+	MaxNestingDepth int
+	// MaxFieldsPerMessage is the maximum number of fields decoded from all data records of a
+	// message, including the fields of records and elements in lists.
+	MaxFieldsPerMessage int
+	// MaxListElements is the maximum number of elements of a single basicList, or of records of a
+	// single subTemplateList or subTemplateMultiList.
+	MaxListElements int
+}
+
+// DefaultDecodeLimits only limit the nesting depth, which suffices for any sensible use of
+// structured data types, e.g., yaf's deep packet inspection records nest three levels deep.
+var DefaultDecodeLimits = DecodeLimits{
+	MaxNestingDepth: 16,
+}
+
+// DecodeLimitError is returned for data sets exceeding one of the DecodeLimits. It unwraps to
+// ErrDecodeLimitExceeded.
+type DecodeLimitError struct {
+	// Limit is the name of the exceeded limit, e.g., "nesting depth"
+	Limit string
+	// Max is the configured value of the exceeded limit
+	Max int
+}
+
+func (e *DecodeLimitError) Error() string {
+	return fmt.Sprintf("%s, %s exceeds %d", ErrDecodeLimitExceeded, e.Limit, e.Max)
+}
+
+func (e *DecodeLimitError) Unwrap() error {
+	return ErrDecodeLimitExceeded
+}
+
+// decodeState is the state of decoding a single message that is shared by all decoders of its
+// data sets, including nested ones. It travels along with the readers passed to Decode, such that
+// the signatures of Decode do not need to change for it.
+type decodeState struct {
+	limits DecodeLimits
+
+	// lazyValues defers decoding field values, see DecoderOptions.LazyFieldValues
+	lazyValues bool
+
+	// fields is the number of fields decoded from the message so far. Data sets may be decoded
+	// in parallel, so it is shared among workers.
+	fields atomic.Int64
+}
+
+func newDecodeState(limits DecodeLimits) *decodeState {
+	return &decodeState{limits: limits}
+}
+
+// lazy returns true if fields retain their bytes and defer decoding their values
+func (s *decodeState) lazy() bool {
+	return s != nil && s.lazyValues
+}
+
+// stateReader is implemented by readers carrying the decodeState of the message and the nesting
+// depth of the records read from them
+type stateReader interface {
+	io.Reader
+	decodeState() (*decodeState, int)
+}
+
+// nestedReader is the reader of list contents, which are decoded one level deeper than the
+// record containing the list
+type nestedReader struct {
+	io.Reader
+
+	state *decodeState
+	depth int
+}
+
+func (r *nestedReader) decodeState() (*decodeState, int) {
+	return r.state, r.depth
+}
+
+// decodeStateOf returns the decodeState and nesting depth carried by r. For readers not carrying
+// any, e.g., when decoding records outside of a Decoder, the state is nil, and no limits apply.
+func decodeStateOf(r io.Reader) (*decodeState, int) {
+	if sr, ok := r.(stateReader); ok {
+		return sr.decodeState()
+	}
+	return nil, 0
+}
+
+// isStructured returns true for the structured data types of RFC 6313, whose contents are decoded
+// one level deeper
+func isStructured(dt DataType) bool {
+	switch dt.(type) {
+	case *BasicList, *SubTemplateList, *SubTemplateMultiList:
+		return true
+	}
+	return false
+}
+
+// nest returns a reader of r that carries the state at the given depth, or r itself if s is nil
+func (s *decodeState) nest(r io.Reader, depth int) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &nestedReader{Reader: r, state: s, depth: depth}
+}
+
+// enterList checks a list decoded at the given depth against MaxNestingDepth
+func (s *decodeState) enterList(depth int) error {
+	if s == nil || s.limits.MaxNestingDepth <= 0 || depth <= s.limits.MaxNestingDepth {
+		return nil
+	}
+	return &DecodeLimitError{Limit: "nesting depth", Max: s.limits.MaxNestingDepth}
+}
+
+// addListElement checks the number of elements of a list against MaxListElements
+func (s *decodeState) addListElement(elements int) error {
+	if s == nil || s.limits.MaxListElements <= 0 || elements <= s.limits.MaxListElements {
+		return nil
+	}
+	return &DecodeLimitError{Limit: "list elements", Max: s.limits.MaxListElements}
+}
+
+// addField counts a decoded field against MaxFieldsPerMessage
+func (s *decodeState) addField() error {
+	if s == nil || s.limits.MaxFieldsPerMessage <= 0 {
+		return nil
+	}
+	if s.fields.Add(1) > int64(s.limits.MaxFieldsPerMessage) {
+		return &DecodeLimitError{Limit: "fields per message", Max: s.limits.MaxFieldsPerMessage}
+	}
+	return nil
+}
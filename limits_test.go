@@ -0,0 +1,175 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestNestedList encodes a basicList nested depth levels deep, whose innermost list contains
+// n elements of sourceTransportPort
+func newTestNestedList(depth int, n int) []byte {
+	b := []byte{byte(SemanticOrdered)}
+	if depth <= 1 {
+		b = binary.BigEndian.AppendUint16(b, 7)
+		b = binary.BigEndian.AppendUint16(b, 2)
+		for i := 0; i < n; i++ {
+			b = binary.BigEndian.AppendUint16(b, uint16(i))
+		}
+		return b
+	}
+	b = binary.BigEndian.AppendUint16(b, 291)
+	b = binary.BigEndian.AppendUint16(b, 0xFFFF)
+	return append(b, newTestVariableLength(newTestNestedList(depth-1, n))...)
+}
+
+// newTestVariableLength prefixes b with its variable-length encoding of RFC 7011 section 7
+func newTestVariableLength(b []byte) []byte {
+	if len(b) < 255 {
+		return append([]byte{byte(len(b))}, b...)
+	}
+	return append(binary.BigEndian.AppendUint16([]byte{255}, uint16(len(b))), b...)
+}
+
+func TestDecodeLimits(t *testing.T) {
+	ctx := context.Background()
+
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+		t.Fatal(err)
+	}
+	// template 258 consists of a single variable-length basicList
+	fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 291))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = templateCache.Add(ctx, NewKey(0, 258), &Template{
+		TemplateMetadata: &TemplateMetadata{TemplateId: 258, CreationTimestamp: time.Now()},
+		Record:           &TemplateRecord{TemplateId: 258, FieldCount: 1, Fields: []Field{fb.SetLength(0xFFFF).Complete()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// message creates a message of two records of template 256, followed by a set of a single
+	// record of template 258 containing a list nested depth levels deep with n elements
+	message := func(depth int, n int) []byte {
+		payload := newTestDataMessage(256, 2)[16:]
+		record := newTestVariableLength(newTestNestedList(depth, n))
+		payload = binary.BigEndian.AppendUint16(payload, 258)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(4+len(record)))
+		payload = append(payload, record...)
+
+		header := make([]byte, 0, 16)
+		header = binary.BigEndian.AppendUint16(header, 10)
+		header = binary.BigEndian.AppendUint16(header, uint16(16+len(payload)))
+		header = binary.BigEndian.AppendUint32(header, uint32(time.Now().Unix()))
+		header = binary.BigEndian.AppendUint32(header, 0)
+		header = binary.BigEndian.AppendUint32(header, 0)
+		return append(header, payload...)
+	}
+
+	dropped := DecodeStats{
+		DecodedSets:    1,
+		DecodedRecords: 2,
+		DroppedSets:    1,
+		DroppedRecords: 1,
+	}
+	decoded := DecodeStats{
+		DecodedSets:    2,
+		DecodedRecords: 3,
+	}
+
+	cases := []struct {
+		name     string
+		options  []DecoderOption
+		depth, n int
+		expected DecodeStats
+	}{
+		{"default limits", nil, 8, 4, decoded},
+		{"nesting depth at limit", []DecoderOption{WithMaxNestingDepth(4)}, 4, 4, decoded},
+		{"nesting depth beyond limit", []DecoderOption{WithMaxNestingDepth(4)}, 5, 4, dropped},
+		{"nesting depth beyond default limit", nil, DefaultDecodeLimits.MaxNestingDepth + 1, 4, dropped},
+		{"unlimited nesting depth", []DecoderOption{WithMaxNestingDepth(0)}, DefaultDecodeLimits.MaxNestingDepth + 1, 4, decoded},
+		{"list elements at limit", []DecoderOption{WithMaxListElements(4)}, 2, 4, decoded},
+		{"list elements beyond limit", []DecoderOption{WithMaxListElements(4)}, 2, 5, dropped},
+		// 2 records of template 256 with 2 fields each, 1 list field, and 1 nested list of 4 elements
+		{"fields per message at limit", []DecoderOption{WithMaxFieldsPerMessage(10)}, 2, 4, decoded},
+		{"fields per message beyond limit", []DecoderOption{WithMaxFieldsPerMessage(9)}, 2, 4, dropped},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := message(tc.depth, tc.n)
+			decoder := NewDecoderWithOptions(templateCache, fieldCache, tc.options...)
+			msg, stats, err := decoder.DecodeWithStats(ctx, bytes.NewBuffer(m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats.Duration = 0
+			tc.expected.TotalLength = int64(len(m))
+			if stats != tc.expected {
+				t.Errorf("expected stats %+v, got %+v", tc.expected, stats)
+			}
+			if l := msg.Sets[0].Set.Length(); l != 2 {
+				t.Errorf("expected other set to be decoded with 2 records, got %d", l)
+			}
+		})
+	}
+
+	t.Run("parallel decoding", func(t *testing.T) {
+		m := message(3, 4)
+		decoder := NewDecoderWithOptions(templateCache, fieldCache, WithMaxNestingDepth(2), WithParallelism(2))
+		_, stats, err := decoder.DecodeWithStats(ctx, bytes.NewBuffer(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.Duration = 0
+		expected := dropped
+		expected.TotalLength = int64(len(m))
+		if stats != expected {
+			t.Errorf("expected stats %+v, got %+v", expected, stats)
+		}
+	})
+
+	t.Run("limit error", func(t *testing.T) {
+		l := &BasicList{fieldManager: fieldCache}
+		b := newTestNestedList(3, 1)
+		state := newDecodeState(DecodeLimits{MaxNestingDepth: 2})
+		_, err := l.SetLength(uint16(len(b))).Decode(state.nest(bytes.NewBuffer(b), 0))
+		if !errors.Is(err, ErrDecodeLimitExceeded) {
+			t.Fatalf("expected %v, got %v", ErrDecodeLimitExceeded, err)
+		}
+		var limitErr *DecodeLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != "nesting depth" || limitErr.Max != 2 {
+			t.Errorf("expected nesting depth limit error, got %#v", limitErr)
+		}
+	})
+
+	t.Run("no limits outside of decoder", func(t *testing.T) {
+		l := &BasicList{fieldManager: fieldCache}
+		b := newTestNestedList(DefaultDecodeLimits.MaxNestingDepth+1, 1)
+		if _, err := l.SetLength(uint16(len(b))).Decode(bytes.NewBuffer(b)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListLength(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+
+	fieldOf := func(t *testing.T, id uint16, length uint16) Field {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.SetLength(length).Complete()
+	}
+
+	addTemplate := func(t *testing.T, tr *TemplateRecord) *Template {
+		tmpl := &Template{
+			TemplateMetadata: &TemplateMetadata{
+				TemplateId:        tr.TemplateId,
+				CreationTimestamp: time.Now(),
+			},
+			Record: tr,
+		}
+		err := templateCache.Add(ctx, TemplateKey{TemplateId: tr.TemplateId}, tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	subTemplate := addTemplate(t, &TemplateRecord{
+		TemplateId: 300,
+		FieldCount: 2,
+		Fields: []Field{
+			fieldOf(t, 8, 4), // sourceIPv4Address
+			fieldOf(t, 7, 2), // sourceTransportPort
+		},
+	})
+
+	subRecord := func(t *testing.T, port uint16) DataRecord {
+		return DataRecord{
+			TemplateId: 300,
+			FieldCount: 2,
+			Fields: []Field{
+				fieldOf(t, 8, 4).SetValue(net.IP{192, 0, 2, 1}),
+				fieldOf(t, 7, 2).SetValue(port),
+			},
+		}
+	}
+
+	// roundTrip places the list field into a data record of a template consisting of a single
+	// variable-length field, and checks that the record encodes to exactly DataRecord.Length()
+	// bytes and decodes back into a record of the same length
+	roundTrip := func(t *testing.T, templateId uint16, list Field) {
+		tmpl := addTemplate(t, &TemplateRecord{
+			TemplateId: templateId,
+			FieldCount: 1,
+			Fields:     []Field{fieldOf(t, list.Id(), VariableLength)},
+		})
+
+		dr := &DataRecord{TemplateId: templateId, FieldCount: 1, Fields: []Field{list}}
+		buf := &bytes.Buffer{}
+		n, err := dr.Encode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int(dr.Length()) || buf.Len() != int(dr.Length()) {
+			t.Fatalf("expected encoded record of %d bytes, got %d", dr.Length(), buf.Len())
+		}
+
+		encoded := bytes.Clone(buf.Bytes())
+		decoded := &DataRecord{TemplateId: templateId}
+		m, err := decoded.With(tmpl).Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != len(encoded) {
+			t.Errorf("expected %d bytes decoded, got %d", len(encoded), m)
+		}
+		if decoded.Length() != dr.Length() {
+			t.Errorf("expected decoded record length %d, got %d", dr.Length(), decoded.Length())
+		}
+
+		reencoded := &bytes.Buffer{}
+		if _, err := decoded.Encode(reencoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reencoded.Bytes(), encoded) {
+			t.Errorf("expected re-encoded record %v, got %v", encoded, reencoded.Bytes())
+		}
+	}
+
+	t.Run("subTemplateList", func(t *testing.T) {
+		list := fieldOf(t, 292, VariableLength)
+		if list.Length() != VariableLength {
+			t.Fatalf("expected variable length before setting a value, got %d", list.Length())
+		}
+		list.SetValue([]DataRecord{subRecord(t, 443), subRecord(t, 80)})
+
+		stl := list.Value().(*SubTemplateList)
+		if stl.TemplateID() != subTemplate.Record.Id() {
+			t.Errorf("expected template id %d, got %d", subTemplate.Record.Id(), stl.TemplateID())
+		}
+		if stl.length != stl.Length() {
+			t.Errorf("expected length %d after SetValue, got %d", stl.Length(), stl.length)
+		}
+		if stl.Length() != subTemplateListHeaderLength+2*6 {
+			t.Errorf("expected list length %d, got %d", subTemplateListHeaderLength+2*6, stl.Length())
+		}
+
+		roundTrip(t, 400, list)
+	})
+
+	t.Run("subTemplateMultiList", func(t *testing.T) {
+		list := fieldOf(t, 293, VariableLength)
+		list.SetValue([]subTemplateListContent{
+			{TemplateId: 300, Values: []DataRecord{subRecord(t, 443), subRecord(t, 80)}},
+			{TemplateId: 300, Values: []DataRecord{subRecord(t, 53)}},
+		})
+
+		stml := list.Value().(*SubTemplateMultiList)
+		for i, el := range stml.Elements() {
+			expected := subTemplateMultiListContentHeaderLength + uint16(len(el.Values))*6
+			if el.Length != expected {
+				t.Errorf("expected length %d of sub template %d, got %d", expected, i, el.Length)
+			}
+		}
+		if stml.length != stml.Length() {
+			t.Errorf("expected length %d after SetValue, got %d", stml.Length(), stml.length)
+		}
+
+		roundTrip(t, 401, list)
+	})
+
+	t.Run("basicList", func(t *testing.T) {
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().(*BasicList).SetFieldID(7)
+		bl.SetValue([]DataType{
+			NewUnsigned16().SetValue(uint16(443)),
+			NewUnsigned16().SetValue(uint16(80)),
+			NewUnsigned16().SetValue(uint16(53)),
+		})
+		if bl.length != bl.Length() {
+			t.Errorf("expected length %d after SetValue, got %d", bl.Length(), bl.length)
+		}
+		if bl.elementLength != 2 {
+			t.Errorf("expected element length 2, got %d", bl.elementLength)
+		}
+
+		roundTrip(t, 402, fieldOf(t, 291, VariableLength).SetValue(bl))
+	})
+
+	t.Run("enterprise-specific basicList", func(t *testing.T) {
+		bl := NewBasicList().(*BasicList).WithManager(fieldCache)().(*BasicList).SetFieldID(7)
+		bl.pen = 29305
+		bl.SetValue([]DataType{
+			NewUnsigned16().SetValue(uint16(443)),
+		})
+		if !bl.isEnterprise {
+			t.Error("expected list with PEN to be enterprise-specific")
+		}
+		if bl.Length() != basicListMinimumHeaderLength+4+2 {
+			t.Errorf("expected list length %d, got %d", basicListMinimumHeaderLength+4+2, bl.Length())
+		}
+		buf := &bytes.Buffer{}
+		n, err := bl.Encode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int(bl.Length()) {
+			t.Errorf("expected %d bytes encoded, got %d", bl.Length(), n)
+		}
+	})
+}
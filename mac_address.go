@@ -43,18 +43,35 @@ func (t *MacAddress) Value() interface{} {
 	return t.value
 }
 
-func (t *MacAddress) SetValue(v any) DataType {
+// SetValueE sets 48-bit MAC addresses as value, given as string, net.HardwareAddr, or 6 bytes
+func (t *MacAddress) SetValueE(v any) (DataType, error) {
+	var ma net.HardwareAddr
 	switch b := v.(type) {
 	case string:
-		ma, err := net.ParseMAC(b)
+		var err error
+		ma, err = net.ParseMAC(b)
 		if err != nil {
-			panic(fmt.Errorf("cannot set value in %T, %w", t, err))
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
 		}
-		t.value = ma
 	case net.HardwareAddr:
-		t.value = b
+		ma = b
+	case []byte:
+		ma = net.HardwareAddr(b)
 	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T in %T", v, t.value, t))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a MAC address", t, ErrInvalidValue, v)
+	}
+	if len(ma) != 6 {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %v is not a 48-bit MAC address", t, ErrInvalidValue, v)
+	}
+	t.value = ma
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *MacAddress) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -68,8 +85,13 @@ func (*MacAddress) DefaultLength() uint16 {
 }
 
 func (t *MacAddress) Clone() DataType {
+	var v net.HardwareAddr
+	if t.value != nil {
+		v = make(net.HardwareAddr, len(t.value))
+		copy(v, t.value)
+	}
 	return &MacAddress{
-		value: t.value,
+		value: v,
 	}
 }
 
@@ -87,8 +109,9 @@ func (*MacAddress) IsReducedLength() bool {
 }
 
 func (t *MacAddress) Decode(in io.Reader) (n int, err error) {
+	// the value retains b, so unlike for numeric types it is not taken from the scratch pool
 	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -119,6 +142,10 @@ func (t *MacAddress) UnmarshalJSON(in []byte) error {
 	if err != nil {
 		return err
 	}
+	if m == "" {
+		t.value = nil
+		return nil
+	}
 	mac, err := net.ParseMAC(m)
 	if err != nil {
 		return err
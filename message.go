@@ -17,11 +17,22 @@ limitations under the License.
 package ipfix
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
+// MessageSchemaVersion is the version of the JSON schema produced by Message.MarshalJSON. It is
+// incremented on incompatible changes to the schema, such that consumers of stored messages can
+// detect them.
+const MessageSchemaVersion uint32 = 1
+
 type Message struct {
 	Version             uint16 `json:"version,omitempty" yaml:"version,omitempty"`
 	Length              uint16 `json:"length,omitempty" yaml:"length,omitempty"`
@@ -31,23 +42,176 @@ type Message struct {
 	Sets                []Set  `json:"sets,omitempty" yaml:"sets,omitempty"`
 }
 
+// String prints the message header followed by an indented tree of its sets, their records, and
+// the fields of the records, using the String methods of each. This is meant for debugging, the
+// format is not stable.
 func (p *Message) String() string {
-	s := make([]string, 0, len(p.Sets))
-	for _, set := range p.Sets {
-		s = append(s, set.String())
-	}
-	return fmt.Sprintf("{version:%d length:%d exportTime:%d sequenceNumber:%d observationDomainId:%d sets:%v}",
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Message<version=%d,length=%d,exportTime=%d (%s),sequenceNumber=%d,observationDomainId=%d>\n",
 		p.Version,
 		p.Length,
 		p.ExportTime,
+		time.Unix(int64(p.ExportTime), 0).UTC().Format(time.RFC3339),
 		p.SequenceNumber,
 		p.ObservationDomainId,
-		s,
 	)
+	for _, set := range p.Sets {
+		if set.Set == nil {
+			fmt.Fprintf(b, "  %s<ID=%d,Length=%d>\n", set.Kind, set.Id, set.Length)
+			continue
+		}
+		fmt.Fprintf(b, "  %s<ID=%d,Length=%d,Records=%d>\n", set.Kind, set.Id, set.Length, set.Set.Length())
+		switch ss := set.Set.(type) {
+		case *TemplateSet:
+			for _, tr := range ss.Records {
+				fmt.Fprintf(b, "    <id=%d,len=%d>\n", tr.TemplateId, tr.FieldCount)
+				writeFields(b, "", tr.Fields)
+			}
+		case *OptionsTemplateSet:
+			for _, otr := range ss.Records {
+				fmt.Fprintf(b, "    <id=%d,len=%d,scopes=%d>\n", otr.TemplateId, otr.FieldCount, otr.ScopeFieldCount)
+				writeFields(b, "scope ", otr.Scopes)
+				writeFields(b, "", otr.Options)
+			}
+		case *DataSet:
+			for _, dr := range ss.Records {
+				fmt.Fprintf(b, "    <id=%d,len=%d>\n", dr.TemplateId, dr.FieldCount)
+				writeFields(b, "", dr.Fields)
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// writeFields writes a line for each field to b, indented below their record
+func writeFields(b *strings.Builder, prefix string, fields []Field) {
+	for _, f := range fields {
+		if f == nil {
+			fmt.Fprintf(b, "      %snil\n", prefix)
+			continue
+		}
+		fmt.Fprintf(b, "      %s%s\n", prefix, f.String())
+	}
+}
+
+var _ json.Marshaler = &Message{}
+var _ json.Unmarshaler = &Message{}
+
+// jsonMessage is the stable JSON schema of a message. Unlike the struct tags on Message,
+// header fields are never omitted.
+type jsonMessage struct {
+	SchemaVersion       uint32 `json:"schema_version"`
+	Version             uint16 `json:"version"`
+	Length              uint16 `json:"length"`
+	ExportTime          uint32 `json:"export_time"`
+	SequenceNumber      uint32 `json:"sequence_number"`
+	ObservationDomainId uint32 `json:"observation_domain_id"`
+	Sets                []Set  `json:"sets"`
+}
+
+// MarshalJSON marshals the message header and all its sets including MessageSchemaVersion.
+func (p *Message) MarshalJSON() ([]byte, error) {
+	sets := p.Sets
+	if sets == nil {
+		sets = []Set{}
+	}
+	return json.Marshal(&jsonMessage{
+		SchemaVersion:       MessageSchemaVersion,
+		Version:             p.Version,
+		Length:              p.Length,
+		ExportTime:          p.ExportTime,
+		SequenceNumber:      p.SequenceNumber,
+		ObservationDomainId: p.ObservationDomainId,
+		Sets:                sets,
+	})
+}
+
+// JSONEncoderOption configures Message.EncodeJSON
+type JSONEncoderOption func(*jsonEncoderOptions)
+
+type jsonEncoderOptions struct {
+	verboseFields bool
+}
+
+// WithVerboseFields includes the units, semantics, and description of the information element of
+// each field of data records in the JSON output, given that the field has a prototype. Fields of
+// records nested in structured data types are encoded as usual. The additional metadata is ignored
+// when unmarshalling messages again.
+func WithVerboseFields() JSONEncoderOption {
+	return func(o *jsonEncoderOptions) {
+		o.verboseFields = true
+	}
+}
+
+// EncodeJSON writes the same JSON encoding of the message as MarshalJSON to w, but without
+// materializing it in memory at once. Instead, the records of each set are marshalled and written
+// one at a time, such that the memory required is bounded by the largest record, not the message.
+// This is useful for messages containing many or large records, e.g., of subTemplateMultiLists.
+func (p *Message) EncodeJSON(w io.Writer, opts ...JSONEncoderOption) error {
+	options := &jsonEncoderOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	bw := bufio.NewWriter(w)
+	// the header consists of numbers only and thus needs no escaping
+	_, err := fmt.Fprintf(bw, `{"schema_version":%d,"version":%d,"length":%d,"export_time":%d,"sequence_number":%d,"observation_domain_id":%d,"sets":[`,
+		MessageSchemaVersion, p.Version, p.Length, p.ExportTime, p.SequenceNumber, p.ObservationDomainId)
+	if err != nil {
+		return fmt.Errorf("failed to write message header, %w", err)
+	}
+	for i := range p.Sets {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		if err := p.Sets[i].encodeJSON(bw, options); err != nil {
+			return fmt.Errorf("failed to write set %d, %w", i, err)
+		}
+	}
+	bw.WriteString("]}")
+	return bw.Flush()
+}
+
+// UnmarshalJSON unmarshals messages marshalled with MarshalJSON. Messages without schema version
+// are assumed to be of the first schema version, messages with a schema version newer than
+// MessageSchemaVersion fail with ErrUnsupportedSchemaVersion.
+func (p *Message) UnmarshalJSON(in []byte) error {
+	m := &jsonMessage{}
+	err := json.Unmarshal(in, m)
+	if err != nil {
+		return err
+	}
+	if m.SchemaVersion > MessageSchemaVersion {
+		return fmt.Errorf("failed to unmarshal message, %w %d", ErrUnsupportedSchemaVersion, m.SchemaVersion)
+	}
+	*p = Message{
+		Version:             m.Version,
+		Length:              m.Length,
+		ExportTime:          m.ExportTime,
+		SequenceNumber:      m.SequenceNumber,
+		ObservationDomainId: m.ObservationDomainId,
+		Sets:                m.Sets,
+	}
+	return nil
 }
 
+// Encode writes the message in IPFIX binary format to w. Messages and sets whose encoding exceeds
+// the maximum length of their 16 bit length fields fail with ErrMessageTooLarge instead of
+// writing wrapped lengths, nothing is written in that case. Use SplitMessage to split such
+// messages.
 func (p *Message) Encode(w io.Writer) (int, error) {
-	b := make([]byte, 0)
+	// encode the payload first, such that oversized messages are not written partially
+	payload := &bytes.Buffer{}
+	for i, fs := range p.Sets {
+		if _, err := fs.Encode(payload); err != nil {
+			return 0, fmt.Errorf("failed to encode set %d, %w", i, err)
+		}
+	}
+	if l := messageHeaderLength + payload.Len(); l > MaxMessageLength {
+		return 0, fmt.Errorf("%w: message of %d bytes", ErrMessageTooLarge, l)
+	}
+
+	b := make([]byte, 0, messageHeaderLength+payload.Len())
 
 	// packet header
 	b = binary.BigEndian.AppendUint16(b, uint16(p.Version))
@@ -56,29 +220,19 @@ func (p *Message) Encode(w io.Writer) (int, error) {
 	b = binary.BigEndian.AppendUint32(b, p.SequenceNumber)
 	b = binary.BigEndian.AppendUint32(b, p.ObservationDomainId)
 
-	nh, err := w.Write(b)
-	if err != nil {
-		return nh, err
-	}
-
-	// message payload
-	var nb int
-	for _, fs := range p.Sets {
-		nfs, err := fs.Encode(w)
-		nb += nfs
-		if err != nil {
-			return nb, err
-		}
-	}
-	return nh + nb, err
+	b = append(b, payload.Bytes()...)
+	return w.Write(b)
 }
 
+// Decode reads the message header from r. Headers of a version other than IPFIX fail with an error
+// wrapping ErrUnsupportedVersion, and headers announcing a length shorter than the header itself fail
+// with an error wrapping ErrInvalidMessageHeader, before any further bytes are read.
 func (p *Message) Decode(r io.Reader) (int, error) {
 	var carry int = 0
 	var shortbuf []byte = make([]byte, 2)
 	var longbuf []byte = make([]byte, 4)
 
-	n, err := r.Read(shortbuf)
+	n, err := io.ReadFull(r, shortbuf)
 	carry += n
 	if err != nil {
 		return carry, err
@@ -86,31 +240,35 @@ func (p *Message) Decode(r io.Reader) (int, error) {
 	p.Version = binary.BigEndian.Uint16(shortbuf)
 
 	if p.Version != 10 {
-		return carry, ErrUnknownVersion
+		return carry, fmt.Errorf("%w, %w %d", ErrInvalidMessageHeader, ErrUnsupportedVersion, p.Version)
 	}
 
-	n, err = r.Read(shortbuf)
+	n, err = io.ReadFull(r, shortbuf)
 	carry += n
 	if err != nil {
-		return 0, err
+		return carry, err
 	}
 	p.Length = binary.BigEndian.Uint16(shortbuf)
 
-	n, err = r.Read(longbuf)
+	if int(p.Length) < messageHeaderLength {
+		return carry, fmt.Errorf("%w, illegal message length %d", ErrInvalidMessageHeader, p.Length)
+	}
+
+	n, err = io.ReadFull(r, longbuf)
 	carry += n
 	if err != nil {
 		return carry, err
 	}
 	p.ExportTime = binary.BigEndian.Uint32(longbuf)
 
-	n, err = r.Read(longbuf)
+	n, err = io.ReadFull(r, longbuf)
 	carry += n
 	if err != nil {
 		return carry, err
 	}
 	p.SequenceNumber = binary.BigEndian.Uint32(longbuf)
 
-	n, err = r.Read(longbuf)
+	n, err = io.ReadFull(r, longbuf)
 	carry += n
 	if err != nil {
 		return carry, err
@@ -119,3 +277,56 @@ func (p *Message) Decode(r io.Reader) (int, error) {
 
 	return carry, nil
 }
+
+// ReadMessage reads a single IPFIX message from r, a stream of messages such as a TCP connection or
+// an IPFIX file, framed by the length field of the message header. Only the bytes of the message are
+// consumed from r, the returned buffer contains exactly the message and can be passed to Decoder.Decode.
+//
+// ReadMessage returns io.EOF if r ends before the message, and io.ErrUnexpectedEOF if r ends in the
+// middle of the message. For headers of an unknown version or with an illegal length, it returns an
+// error wrapping ErrInvalidMessageHeader.
+func ReadMessage(r io.Reader) (*bytes.Buffer, error) {
+	return readMessage(r, uint16(MaxMessageLength))
+}
+
+// ReadMessageWithLimit is ReadMessage for messages of at most maxLength bytes. Headers declaring a
+// longer message return an error wrapping both ErrInvalidMessageHeader and ErrMessageTooLarge before
+// the message is allocated, e.g., for streams that are not known to carry IPFIX.
+func ReadMessageWithLimit(r io.Reader, maxLength uint16) (*bytes.Buffer, error) {
+	return readMessage(r, maxLength)
+}
+
+// readMessage is ReadMessage for messages of at most maxLength bytes
+func readMessage(r io.Reader, maxLength uint16) (*bytes.Buffer, error) {
+	// use io.ReadFull, as readers such as gzip.Reader may return fewer bytes than requested per Read
+	header := make([]byte, messageHeaderLength)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	version := binary.BigEndian.Uint16(header[0:2])
+	length := binary.BigEndian.Uint16(header[2:4])
+	if version != 10 {
+		return nil, fmt.Errorf("%w, %w %d", ErrInvalidMessageHeader, ErrUnsupportedVersion, version)
+	}
+	if int(length) < messageHeaderLength {
+		return nil, fmt.Errorf("%w, illegal message length %d", ErrInvalidMessageHeader, length)
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("%w, %w: length %d exceeds %d", ErrInvalidMessageHeader, ErrMessageTooLarge, length, maxLength)
+	}
+
+	// only allocate the message once the header is validated
+	msg := make([]byte, length)
+	copy(msg, header)
+	_, err = io.ReadFull(r, msg[messageHeaderLength:])
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// the header was read, so r ended in the middle of the message
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bytes.NewBuffer(msg), nil
+}
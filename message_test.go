@@ -1,7 +1,17 @@
 package ipfix
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -70,4 +80,587 @@ func TestMessage_String(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+
+	t.Run("tree of sets, records, and fields", func(t *testing.T) {
+		lines := strings.Split(newTestMessage(t).String(), "\n")
+		expected := []string{
+			"Message<version=10,length=66,exportTime=1696161600 (2023-10-01T12:00:00Z),sequenceNumber=42,observationDomainId=1>",
+			"  TemplateSet<ID=2,Length=16,Records=1>",
+			"    <id=256,len=2>",
+			"      {id:8 ",
+			"      {id:7 ",
+			"  OptionsTemplateSet<ID=3,Length=18,Records=1>",
+			"    <id=257,len=2,scopes=1>",
+			"      scope {id:346 ",
+			"      {id:303 ",
+			"  DataSet<ID=256,Length=16,Records=2>",
+			"    <id=256,len=2>",
+			"      {id:8 ",
+			"      {id:7 ",
+			"    <id=256,len=2>",
+			"      {id:8 ",
+			"      {id:7 ",
+		}
+		if len(lines) != len(expected) {
+			t.Fatalf("expected %d lines, got %d:\n%s", len(expected), len(lines), strings.Join(lines, "\n"))
+		}
+		for i, prefix := range expected {
+			if !strings.HasPrefix(lines[i], prefix) {
+				t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+			}
+		}
+	})
+
+	t.Run("sets without records", func(t *testing.T) {
+		s := (&Message{Version: 10, Sets: []Set{{SetHeader: SetHeader{Id: 256, Length: 4}}}}).String()
+		if !strings.HasSuffix(s, "\n  <ID=256,Length=4>") {
+			t.Errorf("expected set header only, got %q", s)
+		}
+	})
+}
+
+// newTestMessage creates a message with a template set, an options template set, and a data set
+// of the template in the template set
+func newTestMessage(t testing.TB) *Message {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+
+	template := newTestTemplate(t, fieldCache, 256)
+	templateRecord := template.Record.(*TemplateRecord)
+
+	field := func(id, length uint16) Field {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.SetLength(length).Complete()
+	}
+	scope := field(346, 4)
+	scope.SetScoped()
+	optionsTemplateRecord := OptionsTemplateRecord{
+		TemplateId:      257,
+		FieldCount:      2,
+		ScopeFieldCount: 1,
+		Scopes:          []Field{scope},
+		Options:         []Field{field(303, 2)},
+	}
+
+	dataRecords := make([]DataRecord, 0, 2)
+	for _, v := range []struct {
+		address string
+		port    int
+	}{{"192.0.2.1", 4739}, {"198.51.100.7", 4740}} {
+		fields := make([]Field, 0, len(templateRecord.Fields))
+		for _, f := range templateRecord.Fields {
+			fields = append(fields, f.Clone())
+		}
+		fields[0].SetValue(v.address)
+		fields[1].SetValue(v.port)
+		dataRecords = append(dataRecords, DataRecord{
+			TemplateId: 256,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		})
+	}
+
+	return &Message{
+		Version:             10,
+		Length:              66,
+		ExportTime:          1696161600,
+		SequenceNumber:      42,
+		ObservationDomainId: 1,
+		Sets: []Set{
+			{
+				SetHeader: SetHeader{Id: IPFIX, Length: 16},
+				Kind:      KindTemplateSet,
+				Set:       &TemplateSet{Records: []TemplateRecord{*templateRecord}},
+			},
+			{
+				SetHeader: SetHeader{Id: IPFIXOptions, Length: 18},
+				Kind:      KindOptionsTemplateSet,
+				Set:       &OptionsTemplateSet{Records: []OptionsTemplateRecord{optionsTemplateRecord}},
+			},
+			{
+				SetHeader: SetHeader{Id: 256, Length: 16},
+				Kind:      KindDataSet,
+				Set:       &DataSet{Records: dataRecords},
+			},
+		},
+	}
+}
+
+func TestMessageDecode(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		msg := &Message{}
+		n, err := msg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != messageHeaderLength || msg.Version != 10 || msg.Length != uint16(len(b)) {
+			t.Errorf("expected header of message of %d bytes, got %d bytes, %v", len(b), n, msg)
+		}
+	})
+
+	t.Run("NetFlow v9 header", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		binary.BigEndian.PutUint16(b[0:2], 9)
+
+		_, err := (&Message{}).Decode(bytes.NewReader(b))
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+		}
+
+		// decoding from a buffer skips framing the message with ReadMessage
+		templateCache := NewDefaultEphemeralCache()
+		msg, err := NewDecoder(templateCache, NewIANAFieldCache(templateCache)).Decode(context.Background(), bytes.NewBuffer(b))
+		if !errors.Is(err, ErrUnsupportedVersion) || msg != nil {
+			t.Errorf("expected ErrUnsupportedVersion without message, got %v, %v", err, msg)
+		}
+	})
+
+	t.Run("length shorter than header", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		binary.BigEndian.PutUint16(b[2:4], 12)
+
+		_, err := (&Message{}).Decode(bytes.NewReader(b))
+		if !errors.Is(err, ErrInvalidMessageHeader) || errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected ErrInvalidMessageHeader, got %v", err)
+		}
+	})
+}
+
+func TestReadMessage(t *testing.T) {
+	t.Run("reads messages across short reads", func(t *testing.T) {
+		first, second := newTestDataMessage(256, 3), newTestDataMessage(257, 1)
+		r := iotest.OneByteReader(bytes.NewReader(append(append([]byte{}, first...), second...)))
+		for _, expected := range [][]byte{first, second} {
+			buf, err := ReadMessage(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) {
+				t.Errorf("expected %x, got %x", expected, buf.Bytes())
+			}
+		}
+		if _, err := ReadMessage(r); err != io.EOF {
+			t.Errorf("expected io.EOF after the last message, got %v", err)
+		}
+	})
+
+	t.Run("truncated messages", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		for _, n := range []int{messageHeaderLength, len(b) - 1} {
+			if _, err := ReadMessage(bytes.NewReader(b[:n])); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("expected io.ErrUnexpectedEOF for %d of %d bytes, got %v", n, len(b), err)
+			}
+		}
+	})
+
+	t.Run("messages exceeding the limit", func(t *testing.T) {
+		b := newTestDataMessage(256, 1)
+		for _, limit := range []uint16{uint16(len(b)) - 1, uint16(messageHeaderLength)} {
+			r := bytes.NewReader(b)
+			_, err := ReadMessageWithLimit(r, limit)
+			if !errors.Is(err, ErrMessageTooLarge) || !errors.Is(err, ErrInvalidMessageHeader) {
+				t.Errorf("expected ErrMessageTooLarge and ErrInvalidMessageHeader for limit %d, got %v", limit, err)
+			}
+			if r.Len() != len(b)-messageHeaderLength {
+				t.Errorf("expected only the header to be consumed, %d bytes remain", r.Len())
+			}
+		}
+
+		buf, err := ReadMessageWithLimit(bytes.NewReader(b), uint16(len(b)))
+		if err != nil || !bytes.Equal(buf.Bytes(), b) {
+			t.Errorf("expected message of exactly the limit to be read, got %v", err)
+		}
+	})
+
+	t.Run("ReadFullWithLimit", func(t *testing.T) {
+		small, large := newTestDataMessage(256, 1), newTestDataMessage(256, 100)
+		msgs, err := ReadFullWithLimit(bytes.NewReader(append(append([]byte{}, small...), small...)), uint16(len(small)))
+		if err != nil || len(msgs) != 2 {
+			t.Errorf("expected 2 messages, got %d, %v", len(msgs), err)
+		}
+		msgs, err = ReadFullWithLimit(bytes.NewReader(append(append([]byte{}, small...), large...)), uint16(len(small)))
+		if !errors.Is(err, ErrMessageTooLarge) || msgs != nil {
+			t.Errorf("expected ErrMessageTooLarge without messages, got %d messages, %v", len(msgs), err)
+		}
+	})
+}
+
+func TestMessageJSON(t *testing.T) {
+	golden, err := os.ReadFile("hack/message.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newTestMessage(t)
+	original := &bytes.Buffer{}
+	if _, err := msg.Encode(original); err != nil {
+		t.Fatal(err)
+	}
+	if original.Len() != int(msg.Length) {
+		t.Fatalf("expected test message of %d bytes, got %d", msg.Length, original.Len())
+	}
+
+	t.Run("marshal matches golden fixture", func(t *testing.T) {
+		b, err := json.MarshalIndent(msg, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bytes.TrimSpace(golden), b) {
+			t.Errorf("expected marshalled message to equal golden fixture, got\n%s", string(b))
+		}
+	})
+
+	t.Run("unmarshal golden fixture", func(t *testing.T) {
+		restored := &Message{}
+		if err := json.Unmarshal(golden, restored); err != nil {
+			t.Fatal(err)
+		}
+		kinds := []string{KindTemplateSet, KindOptionsTemplateSet, KindDataSet}
+		if len(restored.Sets) != len(kinds) {
+			t.Fatalf("expected %d sets, got %d", len(kinds), len(restored.Sets))
+		}
+		for i, kind := range kinds {
+			if restored.Sets[i].Kind != kind {
+				t.Errorf("expected set %d to be of kind %s, got %s", i, kind, restored.Sets[i].Kind)
+			}
+		}
+
+		encoded := &bytes.Buffer{}
+		if _, err := restored.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(original.Bytes(), encoded.Bytes()) {
+			t.Errorf("expected restored message to encode to\n%x, got\n%x", original.Bytes(), encoded.Bytes())
+		}
+	})
+
+	t.Run("legacy kinds", func(t *testing.T) {
+		legacy := bytes.ReplaceAll(golden, []byte(`"kind": "DataSet"`), []byte(`"kind": "DataRecord"`))
+		restored := &Message{}
+		if err := json.Unmarshal(legacy, restored); err != nil {
+			t.Fatal(err)
+		}
+		if restored.Sets[2].Kind != KindDataSet {
+			t.Errorf("expected legacy kind to be normalized to %s, got %s", KindDataSet, restored.Sets[2].Kind)
+		}
+	})
+
+	t.Run("newer schema version", func(t *testing.T) {
+		err := json.Unmarshal([]byte(`{"schema_version":2,"version":10}`), &Message{})
+		if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+			t.Errorf("expected ErrUnsupportedSchemaVersion, got %v", err)
+		}
+	})
+}
+
+// TestMessageJSONStructuredLists round-trips a yaf-style record of a subTemplateList, a
+// basicList, and a subTemplateMultiList with a nested basicList through JSON
+func TestMessageJSONStructuredLists(t *testing.T) {
+	ctx := context.Background()
+	templateCache := NewDefaultEphemeralCache()
+	fieldCache := NewIANAFieldManager(templateCache)
+	decoder := NewDecoder(templateCache, fieldCache, DecoderOptions{})
+
+	templateRecord := func(id uint16, fields ...[2]uint16) []byte {
+		b := binary.BigEndian.AppendUint16(nil, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+		for _, f := range fields {
+			b = binary.BigEndian.AppendUint16(b, f[0])
+			b = binary.BigEndian.AppendUint16(b, f[1])
+		}
+		return b
+	}
+	var templates []byte
+	templates = append(templates, templateRecord(300, [2]uint16{8, 4}, [2]uint16{7, 2})...) // sourceIPv4Address, sourceTransportPort
+	templates = append(templates, templateRecord(301, [2]uint16{291, VariableLength})...)   // basicList
+	templates = append(templates, templateRecord(256,
+		[2]uint16{1, 8},                // octetDeltaCount
+		[2]uint16{292, VariableLength}, // subTemplateList
+		[2]uint16{291, VariableLength}, // basicList
+		[2]uint16{293, VariableLength}, // subTemplateMultiList
+	)...)
+	if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(1700000000, 0, 2, templates))); err != nil {
+		t.Fatal(err)
+	}
+
+	// basicList of allOf destinationTransportPort
+	bl := []byte{0x04, 0x00, 0x0b, 0x00, 0x02, 0x00, 0x35, 0x00, 0x7b}
+	// subTemplateList of allOf template 300
+	stl := []byte{0x03, 0x01, 0x2c, 192, 0, 2, 1, 0x01, 0xbb, 192, 0, 2, 2, 0x00, 0x50}
+	// subTemplateMultiList of allOf template 300 and template 301, nesting the basicList
+	stml := []byte{0x03, 0x01, 0x2c, 0x00, 0x0a, 192, 0, 2, 3, 0x00, 0x16}
+	stml = append(stml, 0x01, 0x2d, 0x00, byte(4+1+len(bl)), byte(len(bl)))
+	stml = append(stml, bl...)
+
+	record := binary.BigEndian.AppendUint64(nil, 1500)
+	for _, list := range [][]byte{stl, bl, stml} {
+		record = append(record, byte(len(list)))
+		record = append(record, list...)
+	}
+	original := newTestCollectorMessage(1700000001, 0, 256, record)
+	msg, err := decoder.Decode(ctx, bytes.NewBuffer(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restored message encodes to original bytes", func(t *testing.T) {
+		restored := &Message{}
+		if err := json.Unmarshal(b, restored); err != nil {
+			t.Fatal(err)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := restored.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(original, encoded.Bytes()) {
+			t.Errorf("expected restored message to encode to\n%x, got\n%x", original, encoded.Bytes())
+		}
+	})
+
+	t.Run("lists are restored with the caches of the field", func(t *testing.T) {
+		fields := msg.Sets[0].Set.(*DataSet).Records[0].Fields
+		in, err := json.Marshal(fields[3].Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, 293))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fb.SetLength(VariableLength).Complete()
+		stml, ok := f.Value().(*SubTemplateMultiList)
+		if !ok {
+			t.Fatalf("expected value of field to be *SubTemplateMultiList, got %T", f.Value())
+		}
+		if err := json.Unmarshal(in, stml); err != nil {
+			t.Fatal(err)
+		}
+
+		contents := stml.value
+		if len(contents) != 2 {
+			t.Fatalf("expected 2 sub templates, got %d", len(contents))
+		}
+		nested, ok := contents[1].Values[0].Fields[0].Value().(*BasicList)
+		if !ok {
+			t.Fatalf("expected nested field to be *BasicList, got %T", contents[1].Values[0].Fields[0].Value())
+		}
+		if nested.fieldManager == nil {
+			t.Error("expected nested basicList to be restored with field cache")
+		}
+		elements := nested.Elements()
+		if len(elements) != 2 {
+			t.Fatalf("expected 2 elements, got %d", len(elements))
+		}
+		if elements[0].Prototype().Description == nil {
+			t.Error("expected elements of nested basicList to be restored from field cache")
+		}
+	})
+
+	t.Run("nested fields of unknown data types cause errors", func(t *testing.T) {
+		in := []byte(`{"metadata":{"semantic":"allOf","template_id":300},"records":[{"template_id":300,"field_count":1,"fields":[{"id":8,"name":"sourceIPv4Address","type":"unknownType","length":4}]}]}`)
+		err := json.Unmarshal(in, &SubTemplateList{})
+		if !errors.Is(err, ErrUnknownDataType) {
+			t.Errorf("expected ErrUnknownDataType, got %v", err)
+		}
+	})
+}
+
+// newTestLargeMessage creates a message of n data sets, each containing n records consisting of
+// addresses and a subTemplateMultiList of two sub templates with n records each
+func newTestLargeMessage(tb testing.TB, n int) *Message {
+	ctx := context.Background()
+	fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+	field := func(id, length uint16, value any) Field {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return fb.SetLength(length).Complete().SetValue(value)
+	}
+
+	msg := &Message{
+		Version:             10,
+		ExportTime:          1696161600,
+		SequenceNumber:      42,
+		ObservationDomainId: 1,
+		Sets:                make([]Set, 0, n),
+	}
+	for i := 0; i < n; i++ {
+		records := make([]DataRecord, 0, n)
+		for j := 0; j < n; j++ {
+			ports := make([]DataRecord, 0, n)
+			counters := make([]DataRecord, 0, n)
+			for k := 0; k < n; k++ {
+				ports = append(ports, DataRecord{TemplateId: 301, FieldCount: 1, Fields: []Field{field(7, 2, uint16(k))}})
+				counters = append(counters, DataRecord{TemplateId: 302, FieldCount: 1, Fields: []Field{field(1, 8, uint64(k))}})
+			}
+			stml := NewDefaultSubTemplateMultiList().SetValue([]subTemplateListContent{
+				{TemplateId: 301, Values: ports},
+				{TemplateId: 302, Values: counters},
+			})
+			records = append(records, DataRecord{
+				TemplateId: 256,
+				FieldCount: 3,
+				Fields: []Field{
+					field(8, 4, netip.MustParseAddr("192.0.2.1")),
+					field(12, 4, netip.MustParseAddr("198.51.100.7")),
+					field(293, VariableLength, stml),
+				},
+			})
+		}
+		msg.Sets = append(msg.Sets, Set{
+			SetHeader: SetHeader{Id: 256},
+			Kind:      KindDataSet,
+			Set:       &DataSet{Records: records},
+		})
+	}
+	return msg
+}
+
+func TestMessageEncodeJSON(t *testing.T) {
+	for name, msg := range map[string]*Message{
+		"test message":  newTestMessage(t),
+		"large message": newTestLargeMessage(t, 8),
+		"empty message": {Version: 10},
+		"empty sets": {Version: 10, Sets: []Set{
+			{SetHeader: SetHeader{Id: 256}, Kind: KindDataSet, Set: &DataSet{}},
+			{SetHeader: SetHeader{Id: IPFIX}, Kind: KindTemplateSet, Set: &TemplateSet{Records: []TemplateRecord{}}},
+			{},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expected, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &bytes.Buffer{}
+			if err := msg.EncodeJSON(b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, b.Bytes()) {
+				t.Errorf("expected streamed encoding to equal marshalled message\n%s, got\n%s", expected, b.Bytes())
+			}
+		})
+	}
+}
+
+func TestMessageEncodeJSONVerboseFields(t *testing.T) {
+	msg := newTestMessage(t)
+
+	b := &bytes.Buffer{}
+	if err := msg.EncodeJSON(b, WithVerboseFields()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("metadata of prototypes", func(t *testing.T) {
+		var out struct {
+			Sets []struct {
+				Kind    string `json:"kind"`
+				Records []struct {
+					Fields []map[string]any `json:"fields"`
+				} `json:"records"`
+			} `json:"sets"`
+		}
+		if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range out.Sets {
+			for _, r := range s.Records {
+				for _, f := range r.Fields {
+					_, hasSemantics := f["semantics"]
+					_, hasDescription := f["description"]
+					if s.Kind != KindDataSet && (hasSemantics || hasDescription) {
+						t.Errorf("expected fields of %s to be encoded without metadata, got %v", s.Kind, f)
+					}
+				}
+			}
+		}
+		fields := out.Sets[2].Records[0].Fields
+		if len(fields) != 2 {
+			t.Fatalf("expected 2 fields, got %d", len(fields))
+		}
+		if s := fields[1]["semantics"]; s != "identifier" {
+			t.Errorf("expected semantics of sourceTransportPort to be identifier, got %v", s)
+		}
+		if d, _ := fields[1]["description"].(string); d == "" {
+			t.Error("expected description of sourceTransportPort")
+		}
+		if u, ok := fields[1]["units"]; ok {
+			t.Errorf("expected no units for sourceTransportPort, got %v", u)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := msg.EncodeJSON(b); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b.Bytes(), []byte(`"description"`)) {
+			t.Errorf("expected no metadata without WithVerboseFields, got %s", b.Bytes())
+		}
+	})
+
+	t.Run("restore ignores metadata", func(t *testing.T) {
+		expected, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := &Message{}
+		if err := json.Unmarshal(b.Bytes(), restored); err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reference := &Message{}
+		if err := json.Unmarshal(expected, reference); err != nil {
+			t.Fatal(err)
+		}
+		want, err := json.Marshal(reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("expected restored message\n%s, got\n%s", want, got)
+		}
+	})
+}
+
+// BenchmarkMessageJSON compares the memory used by marshalling a large message at once to
+// streaming it with EncodeJSON
+func BenchmarkMessageJSON(b *testing.B) {
+	msg := newTestLargeMessage(b, 16)
+
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, err := json.Marshal(msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Discard.Write(out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("EncodeJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := msg.EncodeJSON(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
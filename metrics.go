@@ -16,8 +16,624 @@ limitations under the License.
 
 package ipfix
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics bundles all prometheus collectors used by the Decoder, the TCPListener and the
+// UDPListener. In contrast to the package-level collectors, a Metrics instance is labeled
+// per listener address and, if enabled with WithObservationDomainLabel, per observation
+// domain for the decoder metrics, such that multiple collectors running in the same process
+// can be distinguished.
+//
+// Create a new instance with NewMetrics, register it with a prometheus.Registerer using
+// Register, and inject it into decoders and listeners using their respective WithMetrics
+// methods. Multiple Metrics instances may be registered against the same registry; the
+// collectors are then shared and distinguished by their labels.
+type Metrics struct {
+	PacketsTotal         *prometheus.CounterVec
+	ErrorsTotal          *prometheus.CounterVec
+	DurationMicroseconds *prometheus.HistogramVec
+	DecodedSets          *prometheus.CounterVec
+	DecodedRecords       *prometheus.CounterVec
+	DroppedRecords       *prometheus.CounterVec
+	// UnknownFields counts the occurrences of fields not known to the field cache in decoded data
+	// records, capped in label values by WithUnknownFieldLabels
+	UnknownFields *prometheus.CounterVec
+	// TemplateCacheHits and TemplateCacheMisses count template lookups for data sets by the
+	// Decoder or an InstrumentedTemplateCache
+	TemplateCacheHits   *prometheus.CounterVec
+	TemplateCacheMisses *prometheus.CounterVec
+	// RecordsLost and ExporterRestarts are reported by SequenceTrackers
+	RecordsLost      *prometheus.CounterVec
+	ExporterRestarts *prometheus.CounterVec
+	// TemplateCacheEvictions is reported by BoundedEphemeralCaches
+	TemplateCacheEvictions *prometheus.CounterVec
+
+	TCPActiveConnections *prometheus.GaugeVec
+	TCPErrorsTotal       *prometheus.CounterVec
+	TCPReceivedBytes     *prometheus.CounterVec
+
+	UDPPacketsTotal *prometheus.CounterVec
+	UDPErrorsTotal  *prometheus.CounterVec
+	UDPPacketBytes  *prometheus.CounterVec
+	// UDPDroppedPackets counts packets dropped because the listener's channel was full
+	UDPDroppedPackets *prometheus.CounterVec
+
+	// PipelineQueueDepth and PipelineWorkerBusySeconds are reported per worker by Pipelines
+	PipelineQueueDepth        *prometheus.GaugeVec
+	PipelineWorkerBusySeconds *prometheus.CounterVec
+
+	// ExporterClockSkewSeconds is reported per exporter by ClockSkewObservers
+	ExporterClockSkewSeconds *prometheus.HistogramVec
+
+	// ListenerEndpointMessages, ListenerEndpointBytes, and ListenerEndpointLastSeen are reported per
+	// exporter by listeners with WithUDPEndpointStats or WithTCPEndpointStats. The label values of
+	// evicted endpoints are deleted.
+	ListenerEndpointMessages *prometheus.CounterVec
+	ListenerEndpointBytes    *prometheus.CounterVec
+	ListenerEndpointLastSeen *prometheus.GaugeVec
+
+	// observationDomainLabel enables populating the observation domain label of decoder metrics
+	observationDomainLabel bool
+	// maxObservationDomains caps the number of distinct observation domain label values,
+	// 0 means no limit
+	maxObservationDomains int
+
+	// maxUnknownFields caps the number of distinct enterprise and field id label values of
+	// UnknownFields, 0 means no limit
+	maxUnknownFields int
+
+	mu                 sync.Mutex
+	observationDomains map[uint32]struct{}
+	unknownFieldKeys   map[FieldKey]struct{}
+}
+
+// MetricsOption configures a Metrics instance created with NewMetrics
+type MetricsOption func(*Metrics)
+
+// WithObservationDomainLabel populates the observation domain label of the decoder metrics
+// with the observation domain id of decoded messages. Without this option, the label is empty.
+//
+// As every observation domain creates a new time series per metric, max caps the number of
+// distinct label values. Observation domains exceeding the cap are collapsed into the label
+// value "other". If max is 0, the number of label values is not capped.
+func WithObservationDomainLabel(max int) MetricsOption {
+	return func(m *Metrics) {
+		m.observationDomainLabel = true
+		if max > 0 {
+			m.maxObservationDomains = max
+		}
+	}
+}
+
+// observationDomainOther is the label value for observation domains exceeding the cap
+const observationDomainOther string = "other"
+
+// DefaultMaxUnknownFields is the number of distinct unknown fields labeled individually in
+// UnknownFields if Metrics are created without WithUnknownFieldLabels
+const DefaultMaxUnknownFields int = 256
+
+// WithUnknownFieldLabels caps the number of distinct unknown fields labeled with their enterprise
+// and field id in UnknownFields. As the ids are taken from the templates of exporters, every
+// exporter may otherwise create an arbitrary number of time series. Fields exceeding the cap are
+// counted with the label values "other". If max is 0, the number of label values is not capped.
+func WithUnknownFieldLabels(max int) MetricsOption {
+	return func(m *Metrics) {
+		if max >= 0 {
+			m.maxUnknownFields = max
+		}
+	}
+}
+
+// unknownFieldOther is the label value of the enterprise and field id of unknown fields exceeding
+// the cap
+const unknownFieldOther string = "other"
+
+const (
+	labelListener          string = "listener"
+	labelObservationDomain string = "observation_domain"
+	labelType              string = "type"
+	labelEnterprise        string = "pen"
+	labelField             string = "id"
+	labelCache             string = "cache"
+	labelWorker            string = "worker"
+	labelExporter          string = "exporter"
+)
 
+var (
+	decoderLabels    = []string{labelListener, labelObservationDomain}
+	decoderSetLabels = []string{labelListener, labelObservationDomain, labelType}
+	listenerLabels   = []string{labelListener}
+	unknownLabels    = []string{labelListener, labelEnterprise, labelField}
+	cacheLabels      = []string{labelCache}
+	pipelineLabels   = []string{labelListener, labelWorker}
+	exporterLabels   = []string{labelListener, labelExporter}
+)
+
+// NewMetrics creates a new set of unregistered collectors. Metric names are the same as
+// the ones of the deprecated package-level collectors.
+func NewMetrics(opts ...MetricsOption) *Metrics {
+	m := &Metrics{
+		PacketsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_decoded_packets_total",
+			Help: "Total number of decoded packets in decoder",
+		}, decoderLabels),
+		ErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_errors_total",
+			Help: "Total number of errors in decoder",
+		}, decoderLabels),
+		DurationMicroseconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "decoder_duration_microseconds",
+			Help:    "Duration of decoding per protocol in microseconds",
+			Buckets: durationBuckets,
+		}, decoderLabels),
+		DecodedSets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_decoded_sets_total",
+			Help: "Total number of decoded sets per type",
+		}, decoderSetLabels),
+		DecodedRecords: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "collector",
+			Name:      "decoder_decoded_records_total",
+			Help:      "Total number of decoded records per type",
+		}, decoderSetLabels),
+		DroppedRecords: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "collector",
+			Name:      "decoder_dropped_records_total",
+			Help:      "Total number of records dropped due to filters per type",
+		}, decoderSetLabels),
+		UnknownFields: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_unknown_fields_total",
+			Help: "Total number of fields not known to the field cache per enterprise and field id",
+		}, unknownLabels),
+		TemplateCacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_template_cache_hits_total",
+			Help: "Total number of template lookups that found the template per set type",
+		}, decoderSetLabels),
+		TemplateCacheMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decoder_template_cache_misses_total",
+			Help: "Total number of template lookups that did not find the template per set type",
+		}, decoderSetLabels),
+		RecordsLost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "records_lost_total",
+			Help: "Total number of data records lost as per the sequence numbers of messages",
+		}, decoderLabels),
+		ExporterRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_restarts_total",
+			Help: "Total number of exporter restarts detected from the sequence numbers of messages",
+		}, decoderLabels),
+		TemplateCacheEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "template_cache_evictions_total",
+			Help: "Total number of templates evicted from bounded template caches per cache",
+		}, cacheLabels),
+		TCPActiveConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "tcp_listener_active_connections_total",
+			Help: "Total number of active connections currently maintained by the TCP listener",
+		}, listenerLabels),
+		TCPErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tcp_listener_errors_total",
+			Help: "Total number of errors encountered in the TCP listener",
+		}, listenerLabels),
+		TCPReceivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tcp_listener_received_bytes",
+			Help: "Total number of bytes read in the TCP listener",
+		}, listenerLabels),
+		UDPPacketsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "udp_listener_packets_total",
+			Help: "Total number of packets received via UDP listener",
+		}, listenerLabels),
+		UDPErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "udp_listener_errors_total",
+			Help: "Total number of errors encountered in the UDP listener",
+		}, listenerLabels),
+		UDPPacketBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "udp_listener_packet_bytes",
+			Help: "Total number of bytes read in the UDP listener",
+		}, listenerLabels),
+		UDPDroppedPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "udp_listener_dropped_packets_total",
+			Help: "Total number of packets dropped by the UDP listener because consumers were too slow",
+		}, listenerLabels),
+		PipelineQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pipeline_queue_depth",
+			Help: "Number of buffers queued for decoding per pipeline worker",
+		}, pipelineLabels),
+		PipelineWorkerBusySeconds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pipeline_worker_busy_seconds_total",
+			Help: "Total time spent decoding per pipeline worker, its rate is the worker's utilization",
+		}, pipelineLabels),
+		ExporterClockSkewSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "exporter_clock_skew_seconds",
+			Help:    "Difference between the receive time and the export time of messages per exporter in seconds",
+			Buckets: clockSkewBuckets,
+		}, exporterLabels),
+		ListenerEndpointMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "listener_endpoint_messages_total",
+			Help: "Total number of messages received by the listener per exporter",
+		}, exporterLabels),
+		ListenerEndpointBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "listener_endpoint_bytes_total",
+			Help: "Total number of bytes of messages received by the listener per exporter",
+		}, exporterLabels),
+		ListenerEndpointLastSeen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "listener_endpoint_last_seen_timestamp_seconds",
+			Help: "Unix time the latest message was received by the listener per exporter",
+		}, exporterLabels),
+		observationDomains: make(map[uint32]struct{}),
+		maxUnknownFields:   DefaultMaxUnknownFields,
+		unknownFieldKeys:   make(map[FieldKey]struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Register registers all collectors of m with r. If a collector of the same name was already
+// registered, e.g., by another Metrics instance used for a different listener, m adopts the
+// already registered collector instead of failing, such that both instances increment the same
+// metrics with different label values.
+func (m *Metrics) Register(r prometheus.Registerer) error {
+	var err error
+	m.PacketsTotal, err = register(r, m.PacketsTotal)
+	if err != nil {
+		return err
+	}
+	m.ErrorsTotal, err = register(r, m.ErrorsTotal)
+	if err != nil {
+		return err
+	}
+	m.DurationMicroseconds, err = register(r, m.DurationMicroseconds)
+	if err != nil {
+		return err
+	}
+	m.DecodedSets, err = register(r, m.DecodedSets)
+	if err != nil {
+		return err
+	}
+	m.DecodedRecords, err = register(r, m.DecodedRecords)
+	if err != nil {
+		return err
+	}
+	m.DroppedRecords, err = register(r, m.DroppedRecords)
+	if err != nil {
+		return err
+	}
+	m.UnknownFields, err = register(r, m.UnknownFields)
+	if err != nil {
+		return err
+	}
+	m.TemplateCacheHits, err = register(r, m.TemplateCacheHits)
+	if err != nil {
+		return err
+	}
+	m.TemplateCacheMisses, err = register(r, m.TemplateCacheMisses)
+	if err != nil {
+		return err
+	}
+	m.RecordsLost, err = register(r, m.RecordsLost)
+	if err != nil {
+		return err
+	}
+	m.ExporterRestarts, err = register(r, m.ExporterRestarts)
+	if err != nil {
+		return err
+	}
+	m.TemplateCacheEvictions, err = register(r, m.TemplateCacheEvictions)
+	if err != nil {
+		return err
+	}
+	m.TCPActiveConnections, err = register(r, m.TCPActiveConnections)
+	if err != nil {
+		return err
+	}
+	m.TCPErrorsTotal, err = register(r, m.TCPErrorsTotal)
+	if err != nil {
+		return err
+	}
+	m.TCPReceivedBytes, err = register(r, m.TCPReceivedBytes)
+	if err != nil {
+		return err
+	}
+	m.UDPPacketsTotal, err = register(r, m.UDPPacketsTotal)
+	if err != nil {
+		return err
+	}
+	m.UDPErrorsTotal, err = register(r, m.UDPErrorsTotal)
+	if err != nil {
+		return err
+	}
+	m.UDPPacketBytes, err = register(r, m.UDPPacketBytes)
+	if err != nil {
+		return err
+	}
+	m.UDPDroppedPackets, err = register(r, m.UDPDroppedPackets)
+	if err != nil {
+		return err
+	}
+	m.PipelineQueueDepth, err = register(r, m.PipelineQueueDepth)
+	if err != nil {
+		return err
+	}
+	m.PipelineWorkerBusySeconds, err = register(r, m.PipelineWorkerBusySeconds)
+	if err != nil {
+		return err
+	}
+	m.ExporterClockSkewSeconds, err = register(r, m.ExporterClockSkewSeconds)
+	if err != nil {
+		return err
+	}
+	m.ListenerEndpointMessages, err = register(r, m.ListenerEndpointMessages)
+	if err != nil {
+		return err
+	}
+	m.ListenerEndpointBytes, err = register(r, m.ListenerEndpointBytes)
+	if err != nil {
+		return err
+	}
+	m.ListenerEndpointLastSeen, err = register(r, m.ListenerEndpointLastSeen)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// register registers c with r and returns either c or, if an equivalent collector is
+// already registered, the existing one.
+func register[T prometheus.Collector](r prometheus.Registerer, c T) (T, error) {
+	err := r.Register(c)
+	if err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// observationDomain returns the label value for a given observation domain id, i.e., an empty
+// string if the label is disabled, and "other" if the cap of distinct values is exceeded
+func (m *Metrics) observationDomain(observationDomainId uint32) string {
+	if !m.observationDomainLabel {
+		return ""
+	}
+	if m.maxObservationDomains > 0 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.observationDomains[observationDomainId]; !ok {
+			if len(m.observationDomains) >= m.maxObservationDomains {
+				return observationDomainOther
+			}
+			m.observationDomains[observationDomainId] = struct{}{}
+		}
+	}
+	return strconv.FormatUint(uint64(observationDomainId), 10)
+}
+
+// The following accessors return the collector for a given set of label values. They are
+// safe to call on a nil *Metrics, in which case they fall back to the deprecated
+// package-level collectors.
+
+func (m *Metrics) packetsTotal(listener string, observationDomainId uint32) prometheus.Counter {
+	if m == nil {
+		return PacketsTotal
+	}
+	return m.PacketsTotal.WithLabelValues(listener, m.observationDomain(observationDomainId))
+}
+
+func (m *Metrics) errorsTotal(listener string, observationDomainId uint32) prometheus.Counter {
+	if m == nil {
+		return ErrorsTotal
+	}
+	return m.ErrorsTotal.WithLabelValues(listener, m.observationDomain(observationDomainId))
+}
+
+func (m *Metrics) durationMicroseconds(listener string, observationDomainId uint32) prometheus.Observer {
+	if m == nil {
+		return DurationMicroseconds
+	}
+	return m.DurationMicroseconds.WithLabelValues(listener, m.observationDomain(observationDomainId))
+}
+
+func (m *Metrics) decodedSets(listener string, observationDomainId uint32, kind string) prometheus.Counter {
+	if m == nil {
+		return DecodedSets.WithLabelValues(kind)
+	}
+	return m.DecodedSets.WithLabelValues(listener, m.observationDomain(observationDomainId), kind)
+}
+
+func (m *Metrics) decodedRecords(listener string, observationDomainId uint32, kind string) prometheus.Counter {
+	if m == nil {
+		return DecodedRecords.WithLabelValues(kind)
+	}
+	return m.DecodedRecords.WithLabelValues(listener, m.observationDomain(observationDomainId), kind)
+}
+
+func (m *Metrics) droppedRecords(listener string, observationDomainId uint32, kind string) prometheus.Counter {
+	if m == nil {
+		return DroppedRecords.WithLabelValues(kind)
+	}
+	return m.DroppedRecords.WithLabelValues(listener, m.observationDomain(observationDomainId), kind)
+}
+
+func (m *Metrics) unknownFields(listener string, key FieldKey) prometheus.Counter {
+	if m == nil {
+		pen, id := defaultUnknownFields.unknownFieldLabels(key)
+		return UnknownFields.WithLabelValues(pen, id)
+	}
+	pen, id := m.unknownFieldLabels(key)
+	return m.UnknownFields.WithLabelValues(listener, pen, id)
+}
+
+// unknownFieldLabels returns the enterprise and field id label values of an unknown field, or
+// "other" if the cap of distinct fields is exceeded
+func (m *Metrics) unknownFieldLabels(key FieldKey) (string, string) {
+	if m.maxUnknownFields > 0 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.unknownFieldKeys[key]; !ok {
+			if len(m.unknownFieldKeys) >= m.maxUnknownFields {
+				return unknownFieldOther, unknownFieldOther
+			}
+			m.unknownFieldKeys[key] = struct{}{}
+		}
+	}
+	return strconv.FormatUint(uint64(key.EnterpriseId), 10), strconv.FormatUint(uint64(key.Id), 10)
+}
+
+// defaultUnknownFields caps the label values of the deprecated package-level UnknownFields
+var defaultUnknownFields = &Metrics{
+	maxUnknownFields: DefaultMaxUnknownFields,
+	unknownFieldKeys: make(map[FieldKey]struct{}),
+}
+
+func (m *Metrics) templateCacheHits(listener string, observationDomainId uint32, kind string) prometheus.Counter {
+	if m == nil {
+		return TemplateCacheHits.WithLabelValues(kind)
+	}
+	return m.TemplateCacheHits.WithLabelValues(listener, m.observationDomain(observationDomainId), kind)
+}
+
+func (m *Metrics) templateCacheMisses(listener string, observationDomainId uint32, kind string) prometheus.Counter {
+	if m == nil {
+		return TemplateCacheMisses.WithLabelValues(kind)
+	}
+	return m.TemplateCacheMisses.WithLabelValues(listener, m.observationDomain(observationDomainId), kind)
+}
+
+func (m *Metrics) recordsLost(listener string, observationDomainId uint32) prometheus.Counter {
+	if m == nil {
+		return RecordsLost
+	}
+	return m.RecordsLost.WithLabelValues(listener, m.observationDomain(observationDomainId))
+}
+
+func (m *Metrics) exporterRestarts(listener string, observationDomainId uint32) prometheus.Counter {
+	if m == nil {
+		return ExporterRestarts
+	}
+	return m.ExporterRestarts.WithLabelValues(listener, m.observationDomain(observationDomainId))
+}
+
+func (m *Metrics) templateCacheEvictions(cache string) prometheus.Counter {
+	if m == nil {
+		return TemplateCacheEvictions.WithLabelValues(cache)
+	}
+	return m.TemplateCacheEvictions.WithLabelValues(cache)
+}
+
+func (m *Metrics) tcpActiveConnections(listener string) prometheus.Gauge {
+	if m == nil {
+		return TCPActiveConnections
+	}
+	return m.TCPActiveConnections.WithLabelValues(listener)
+}
+
+func (m *Metrics) tcpErrorsTotal(listener string) prometheus.Counter {
+	if m == nil {
+		return TCPErrorsTotal
+	}
+	return m.TCPErrorsTotal.WithLabelValues(listener)
+}
+
+func (m *Metrics) tcpReceivedBytes(listener string) prometheus.Counter {
+	if m == nil {
+		return TCPReceivedBytes
+	}
+	return m.TCPReceivedBytes.WithLabelValues(listener)
+}
+
+func (m *Metrics) udpPacketsTotal(listener string) prometheus.Counter {
+	if m == nil {
+		return UDPPacketsTotal
+	}
+	return m.UDPPacketsTotal.WithLabelValues(listener)
+}
+
+func (m *Metrics) udpErrorsTotal(listener string) prometheus.Counter {
+	if m == nil {
+		return UDPErrorsTotal
+	}
+	return m.UDPErrorsTotal.WithLabelValues(listener)
+}
+
+func (m *Metrics) udpPacketBytes(listener string) prometheus.Counter {
+	if m == nil {
+		return UDPPacketBytes
+	}
+	return m.UDPPacketBytes.WithLabelValues(listener)
+}
+
+func (m *Metrics) udpDroppedPackets(listener string) prometheus.Counter {
+	if m == nil {
+		return UDPDroppedPackets
+	}
+	return m.UDPDroppedPackets.WithLabelValues(listener)
+}
+
+// Collectors returns all package-level collectors used by decoders and listeners that were not
+// given a *Metrics instance.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		PacketsTotal,
+		ErrorsTotal,
+		DurationMicroseconds,
+		DecodedSets,
+		DecodedRecords,
+		DroppedRecords,
+		UnknownFields,
+		TemplateCacheHits,
+		TemplateCacheMisses,
+		RecordsLost,
+		ExporterRestarts,
+		TemplateCacheEvictions,
+		TCPActiveConnections,
+		TCPErrorsTotal,
+		TCPReceivedBytes,
+		UDPPacketsTotal,
+		UDPErrorsTotal,
+		UDPPacketBytes,
+		UDPDroppedPackets,
+	}
+}
+
+// RegisterMetrics registers all package-level collectors with r. Collectors that are already
+// registered with r are skipped, such that calling RegisterMetrics more than once does not fail.
+func RegisterMetrics(r prometheus.Registerer) error {
+	for _, c := range Collectors() {
+		err := r.Register(c)
+		if err != nil {
+			are := prometheus.AlreadyRegisteredError{}
+			if errors.As(err, &are) && are.ExistingCollector == c {
+				continue
+			}
+			return fmt.Errorf("failed to register collector, %w", err)
+		}
+	}
+	return nil
+}
+
+// clockSkewBuckets are symmetric around 0, as exporter clocks may run ahead of or behind the
+// collector's clock
+var clockSkewBuckets = []float64{-3600, -600, -60, -10, -1, 0, 1, 10, 60, 600, 3600}
+
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// The package-level collectors below are used by decoders and listeners that were not
+// given a *Metrics instance. They are not registered automatically, use RegisterMetrics
+// for registering all of them at once.
+//
+// Deprecated: use NewMetrics and inject the instance into decoders and listeners instead.
+// Package-level collectors cannot be labeled per listener and panic on duplicate registration
+// with prometheus.MustRegister.
 var (
 	PacketsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "decoder_decoded_packets_total",
@@ -30,7 +646,7 @@ var (
 	DurationMicroseconds = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "decoder_duration_microseconds",
 		Help:    "Duration of decoding per protocol in microseconds",
-		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500},
+		Buckets: durationBuckets,
 	})
 	DecodedSets = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "decoder_decoded_sets_total",
@@ -46,8 +662,34 @@ var (
 		Name:      "decoder_dropped_records_total",
 		Help:      "Total number of records dropped due to filters per type",
 	}, []string{"type"})
+	UnknownFields = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "decoder_unknown_fields_total",
+		Help: "Total number of fields not known to the field cache per enterprise and field id",
+	}, []string{labelEnterprise, labelField})
+	TemplateCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "decoder_template_cache_hits_total",
+		Help: "Total number of template lookups that found the template per set type",
+	}, []string{"type"})
+	TemplateCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "decoder_template_cache_misses_total",
+		Help: "Total number of template lookups that did not find the template per set type",
+	}, []string{"type"})
+	RecordsLost = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "records_lost_total",
+		Help: "Total number of data records lost as per the sequence numbers of messages",
+	})
+	ExporterRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_restarts_total",
+		Help: "Total number of exporter restarts detected from the sequence numbers of messages",
+	})
+	TemplateCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "template_cache_evictions_total",
+		Help: "Total number of templates evicted from bounded template caches per cache",
+	}, cacheLabels)
 )
 
+// Deprecated: use NewMetrics and Metrics.TCPActiveConnections, Metrics.TCPErrorsTotal, and
+// Metrics.TCPReceivedBytes instead.
 var (
 	TCPActiveConnections = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "tcp_listener_active_connections_total",
@@ -63,6 +705,8 @@ var (
 	})
 )
 
+// Deprecated: use NewMetrics and Metrics.UDPPacketsTotal, Metrics.UDPErrorsTotal,
+// Metrics.UDPPacketBytes, and Metrics.UDPDroppedPackets instead.
 var (
 	UDPPacketsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "udp_listener_packets_total",
@@ -76,4 +720,8 @@ var (
 		Name: "udp_listener_packet_bytes",
 		Help: "Total number of bytes read in the UDP listener",
 	})
+	UDPDroppedPackets = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "udp_listener_dropped_packets_total",
+		Help: "Total number of packets dropped by the UDP listener because consumers were too slow",
+	})
 )
@@ -0,0 +1,260 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// freeUDPAddr returns a local UDP address that was free at the time of calling
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("register two listeners against one registry", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+
+		m1 := NewMetrics()
+		m2 := NewMetrics()
+		if err := m1.Register(registry); err != nil {
+			t.Fatal(err)
+		}
+		if err := m2.Register(registry); err != nil {
+			t.Fatal(err)
+		}
+
+		addr1, addr2 := freeUDPAddr(t), freeUDPAddr(t)
+		l1 := NewUDPListener(addr1).WithMetrics(m1)
+		l2 := NewUDPListener(addr2).WithMetrics(m2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go l1.Listen(ctx)
+		go l2.Listen(ctx)
+
+		send := func(addr string, payload []byte) {
+			conn, err := net.Dial("udp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_, err = conn.Write(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the listener might not have bound yet, retry sending until the packet arrives
+		receive := func(addr string, l *UDPListener) {
+			for i := 0; i < 50; i++ {
+				send(addr, []byte{0x00, 0x0a})
+				select {
+				case <-l.Messages():
+					return
+				case <-time.After(20 * time.Millisecond):
+				}
+			}
+			t.Fatalf("did not receive packet on %s", addr)
+		}
+		receive(addr1, l1)
+		receive(addr2, l2)
+		receive(addr2, l2)
+
+		if v := testutil.ToFloat64(m1.UDPPacketsTotal.WithLabelValues(addr1)); v < 1 {
+			t.Errorf("expected at least 1 packet for %s, got %v", addr1, v)
+		}
+		if v := testutil.ToFloat64(m1.UDPPacketsTotal.WithLabelValues(addr2)); v < 2 {
+			t.Errorf("expected at least 2 packets for %s, got %v", addr2, v)
+		}
+		// both instances share the same registered collectors
+		if m1.UDPPacketsTotal != m2.UDPPacketsTotal {
+			t.Error("expected second metrics instance to adopt already registered collector")
+		}
+	})
+
+	t.Run("decoder metrics labeled by listener and observation domain", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		m := NewMetrics(WithObservationDomainLabel(0))
+		if err := m.Register(registry); err != nil {
+			t.Fatal(err)
+		}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewEphemeralFieldCache(templateCache)
+		decoder := NewDecoder(templateCache, fieldCache).WithMetrics(m, "127.0.0.1:4739")
+
+		// a message consisting only of an empty template set
+		b := make([]byte, 0)
+		b = binary.BigEndian.AppendUint16(b, 10)
+		b = binary.BigEndian.AppendUint16(b, 20)
+		b = binary.BigEndian.AppendUint32(b, uint32(time.Now().Unix()))
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = binary.BigEndian.AppendUint32(b, 42)
+		b = binary.BigEndian.AppendUint16(b, IPFIX)
+		b = binary.BigEndian.AppendUint16(b, 4)
+
+		for i := 0; i < 3; i++ {
+			_, err := decoder.Decode(context.Background(), bytes.NewBuffer(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if v := testutil.ToFloat64(m.PacketsTotal.WithLabelValues("127.0.0.1:4739", "42")); v != 3 {
+			t.Errorf("expected 3 decoded packets, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.DecodedSets.WithLabelValues("127.0.0.1:4739", "42", KindTemplateSet)); v != 3 {
+			t.Errorf("expected 3 decoded template sets, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.ErrorsTotal.WithLabelValues("127.0.0.1:4739", "42")); v != 0 {
+			t.Errorf("expected no errors, got %v", v)
+		}
+	})
+
+	t.Run("observation domain label is optional and capped", func(t *testing.T) {
+		// emptyTemplateSetMessage is a message consisting only of an empty template set
+		emptyTemplateSetMessage := func(observationDomainId uint32) *bytes.Buffer {
+			b := make([]byte, 0)
+			b = binary.BigEndian.AppendUint16(b, 10)
+			b = binary.BigEndian.AppendUint16(b, 20)
+			b = binary.BigEndian.AppendUint32(b, uint32(time.Now().Unix()))
+			b = binary.BigEndian.AppendUint32(b, 1)
+			b = binary.BigEndian.AppendUint32(b, observationDomainId)
+			b = binary.BigEndian.AppendUint16(b, IPFIX)
+			b = binary.BigEndian.AppendUint16(b, 4)
+			return bytes.NewBuffer(b)
+		}
+		decode := func(m *Metrics, observationDomainIds ...uint32) {
+			templateCache := NewDefaultEphemeralCache()
+			decoder := NewDecoder(templateCache, NewEphemeralFieldCache(templateCache)).WithMetrics(m, "test")
+			for _, id := range observationDomainIds {
+				_, err := decoder.Decode(context.Background(), emptyTemplateSetMessage(id))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		disabled := NewMetrics()
+		decode(disabled, 1, 2, 3)
+		if v := testutil.ToFloat64(disabled.PacketsTotal.WithLabelValues("test", "")); v != 3 {
+			t.Errorf("expected 3 packets without observation domain label, got %v", v)
+		}
+		if n := testutil.CollectAndCount(disabled.PacketsTotal); n != 1 {
+			t.Errorf("expected a single time series, got %d", n)
+		}
+
+		capped := NewMetrics(WithObservationDomainLabel(2))
+		decode(capped, 1, 2, 3, 4, 1)
+		for label, expected := range map[string]float64{"1": 2, "2": 1, "other": 2} {
+			if v := testutil.ToFloat64(capped.PacketsTotal.WithLabelValues("test", label)); v != expected {
+				t.Errorf("expected %v packets for observation domain %s, got %v", expected, label, v)
+			}
+		}
+		if n := testutil.CollectAndCount(capped.DecodedSets); n != 3 {
+			t.Errorf("expected 3 time series of decoded sets, got %d", n)
+		}
+	})
+
+	t.Run("template cache hits and misses", func(t *testing.T) {
+		ctx := context.Background()
+		m := NewMetrics()
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+			t.Fatal(err)
+		}
+		decoder := NewDecoder(templateCache, fieldCache).WithMetrics(m, "test")
+
+		for i := 0; i < 2; i++ {
+			if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 1))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(257, 1))); !errors.Is(err, ErrTemplateNotFound) {
+			t.Fatalf("expected ErrTemplateNotFound, got %v", err)
+		}
+
+		if v := testutil.ToFloat64(m.TemplateCacheHits.WithLabelValues("test", "", KindDataSet)); v != 2 {
+			t.Errorf("expected 2 hits, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.TemplateCacheMisses.WithLabelValues("test", "", KindDataSet)); v != 1 {
+			t.Errorf("expected 1 miss, got %v", v)
+		}
+	})
+
+	t.Run("instrumented template cache", func(t *testing.T) {
+		ctx := context.Background()
+		m := NewMetrics()
+		inner := NewDefaultEphemeralCache()
+		if err := inner.Add(ctx, NewKey(0, 256), newTestTemplate(t, NewIANAFieldManager(inner), 256)); err != nil {
+			t.Fatal(err)
+		}
+		cache := NewInstrumentedTemplateCache(inner).WithMetrics(m, "cache")
+
+		if _, err := cache.Get(ctx, NewKey(0, 256)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Get(ctx, NewKey(0, 257)); !errors.Is(err, ErrTemplateNotFound) {
+			t.Fatalf("expected ErrTemplateNotFound, got %v", err)
+		}
+		if v := testutil.ToFloat64(m.TemplateCacheHits.WithLabelValues("cache", "", KindDataSet)); v != 1 {
+			t.Errorf("expected 1 hit, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.TemplateCacheMisses.WithLabelValues("cache", "", KindDataSet)); v != 1 {
+			t.Errorf("expected 1 miss, got %v", v)
+		}
+		if cache.Name() != inner.Name() {
+			t.Errorf("expected name %q to be passed through, got %q", inner.Name(), cache.Name())
+		}
+	})
+
+	t.Run("register package-level collectors twice", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		if err := RegisterMetrics(registry); err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterMetrics(registry); err != nil {
+			t.Fatalf("expected second registration to succeed, got %v", err)
+		}
+
+		// collectors without labels are exported even without observations
+		if n, err := testutil.GatherAndCount(registry, "decoder_decoded_packets_total", "tcp_listener_errors_total", "udp_listener_errors_total"); err != nil || n != 3 {
+			t.Errorf("expected 3 metrics, got %d (%v)", n, err)
+		}
+
+		// collectors of a Metrics instance share names, but not labels, with the package-level ones
+		if err := NewMetrics().Register(registry); err == nil {
+			t.Error("expected registration of conflicting collectors to fail")
+		}
+	})
+}
@@ -19,8 +19,11 @@ package ipfix
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 )
 
 type OctetArray struct {
@@ -52,18 +55,35 @@ func (t *OctetArray) Value() interface{} {
 	return t.value
 }
 
-func (t *OctetArray) SetValue(v any) DataType {
-	// byte arrays are base64-string encoded in JSON
-	switch b := v.(type) {
+// SetValueE sets byte slices as value. Strings are decoded from base64, as byte slices are
+// base64-encoded in JSON.
+func (t *OctetArray) SetValueE(v any) (DataType, error) {
+	var b []byte
+	switch vv := v.(type) {
 	case string:
-		sd, _ := base64.StdEncoding.DecodeString(b)
-		t.value = sd
-		t.length = uint16(len(sd))
+		var err error
+		b, err = base64.StdEncoding.DecodeString(vv)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set value in %T, %w, %w", t, ErrInvalidValue, err)
+		}
 	case []byte:
-		t.value = b
-		t.length = uint16(len(b))
+		b = vv
 	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T in %T", v, t.value, t))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a byte slice", t, ErrInvalidValue, v)
+	}
+	if len(b) > math.MaxUint16 {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %d bytes exceed the maximum length of a field", t, ErrInvalidValue, len(b))
+	}
+	t.value = b
+	t.length = uint16(len(b))
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *OctetArray) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -73,8 +93,14 @@ func (*OctetArray) DefaultLength() uint16 {
 }
 
 func (t *OctetArray) Clone() DataType {
+	var v []byte
+	if t.value != nil {
+		v = make([]byte, len(t.value))
+		copy(v, t.value)
+	}
 	return &OctetArray{
-		value: t.value,
+		value:  v,
+		length: t.length,
 	}
 }
 
@@ -99,8 +125,9 @@ func (*OctetArray) IsReducedLength() bool {
 }
 
 func (t *OctetArray) Decode(in io.Reader) (n int, err error) {
+	// the value retains b, so unlike for numeric types it is not taken from the scratch pool
 	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -133,12 +160,24 @@ func (t *OctetArray) MarshalJSON() ([]byte, error) {
 
 // This overwrites the canonic UnmarshalJSON implementation for byte slices
 func (t *OctetArray) UnmarshalJSON(in []byte) error {
-	// takes in a byte slice of the form \"0x<>\" where we only want the <> part
-	o, err := hex.DecodeString(string(in)[3 : len(in)-1])
+	var s string
+	err := json.Unmarshal(in, &s)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		// nil values are marshalled to empty strings
+		t.value = nil
+		t.length = 0
+		return nil
+	}
+	// takes in a string of the form "0x<>" where we only want the <> part
+	o, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
 	if err != nil {
 		return err
 	}
 	t.value = o
+	t.length = uint16(len(o))
 	return nil
 }
 
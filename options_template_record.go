@@ -20,9 +20,10 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
+
+	"gopkg.in/yaml.v3"
 )
 
 type OptionsTemplateRecord struct {
@@ -66,20 +67,20 @@ func (otr *OptionsTemplateRecord) Decode(r io.Reader) (n int, err error) {
 	{
 		// option template record header
 		t := make([]byte, 2)
-		n, err = r.Read(t)
+		n, err = io.ReadFull(r, t)
 		if err != nil {
 			return n, err
 		}
 		otr.TemplateId = binary.BigEndian.Uint16(t)
 
-		m, err := r.Read(t)
+		m, err := io.ReadFull(r, t)
 		n += m
 		if err != nil {
 			return n, err
 		}
 		otr.FieldCount = binary.BigEndian.Uint16(t)
 
-		m, err = r.Read(t)
+		m, err = io.ReadFull(r, t)
 		n += m
 		if err != nil {
 			return n, err
@@ -87,7 +88,7 @@ func (otr *OptionsTemplateRecord) Decode(r io.Reader) (n int, err error) {
 		otr.ScopeFieldCount = binary.BigEndian.Uint16(t)
 
 		if otr.ScopeFieldCount == 0 {
-			return n, errors.New("options template record scope field count must not be zero")
+			return n, malformedMessage(n, "options template record scope field count must not be zero")
 		}
 	}
 
@@ -103,9 +104,9 @@ func (otr *OptionsTemplateRecord) Decode(r io.Reader) (n int, err error) {
 	// optionsSize is the number of fields that remain after the scopes in the Options Template record
 	optionsSize := int(otr.FieldCount) - int(otr.ScopeFieldCount)
 	if optionsSize < 0 {
-		return n, errors.New("negative length OptionsTemplateSet")
+		return n, malformedMessage(n, "options template record field count %d is smaller than scope field count %d", otr.FieldCount, otr.ScopeFieldCount)
 	}
-	otr.Options = make([]Field, optionsSize)
+	otr.Options = make([]Field, 0, optionsSize)
 	for i := 0; i < optionsSize; i++ {
 		m, err := otr.decodeOptionsField(r)
 		n += m
@@ -143,7 +144,7 @@ func (otr *OptionsTemplateRecord) decodeTemplateField(r io.Reader) (f Field, n i
 	var reverse bool
 
 	b := make([]byte, 2)
-	m, err := r.Read(b)
+	m, err := io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return nil, n, err
@@ -156,7 +157,7 @@ func (otr *OptionsTemplateRecord) decodeTemplateField(r io.Reader) (f Field, n i
 	// length announcement via the template: this is either fixed or variable (i.e., 0xFFFF).
 	// The FieldBuilder will therefore either create a fixed-length or variable-length field
 	// on FieldBuilder.Complete()
-	m, err = r.Read(b)
+	m, err = io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return nil, n, err
@@ -167,17 +168,17 @@ func (otr *OptionsTemplateRecord) decodeTemplateField(r io.Reader) (f Field, n i
 	if rawFieldId >= 0x8000 {
 		// first bit is 1, therefore this is a enterprise-specific IE
 		b := make([]byte, 4)
-		m, err := r.Read(b)
+		m, err := io.ReadFull(r, b)
 		n += m
 		if err != nil {
 			return nil, n, err
 		}
 		enterpriseId = binary.BigEndian.Uint32(b)
 
-		if enterpriseId == ReversePEN && reversible(fieldId) {
+		if forward, ok := forwardKey(enterpriseId, fieldId); ok {
 			reverse = true
-			// clear enterprise id, because this would obscure lookup
-			enterpriseId = 0
+			// look up the forward IE, e.g., clear the reverse PEN, because this would obscure lookup
+			enterpriseId, fieldId = forward.EnterpriseId, forward.Id
 		}
 	}
 
@@ -185,6 +186,9 @@ func (otr *OptionsTemplateRecord) decodeTemplateField(r io.Reader) (f Field, n i
 	if err != nil {
 		return nil, n, err
 	}
+	if fieldBuilder == nil {
+		fieldBuilder = NewUnknownFieldBuilder(enterpriseId, fieldId)
+	}
 
 	f = fieldBuilder.
 		SetLength(fieldLength).
@@ -197,7 +201,14 @@ func (otr *OptionsTemplateRecord) decodeTemplateField(r io.Reader) (f Field, n i
 	return f, n, nil
 }
 
+// Encode writes the options template record to w. Unset FieldCount and ScopeFieldCount are
+// computed from Scopes and Options, counts inconsistent with them fail before anything is written.
 func (otr *OptionsTemplateRecord) Encode(w io.Writer) (n int, err error) {
+	fieldCount, scopeFieldCount, err := otr.counts()
+	if err != nil {
+		return 0, err
+	}
+
 	l := make([]byte, 2)
 	binary.BigEndian.PutUint16(l, otr.TemplateId)
 	ln, err := w.Write(l)
@@ -206,30 +217,31 @@ func (otr *OptionsTemplateRecord) Encode(w io.Writer) (n int, err error) {
 		return n, err
 	}
 	l = make([]byte, 2)
-	binary.BigEndian.PutUint16(l, otr.FieldCount)
+	binary.BigEndian.PutUint16(l, fieldCount)
 	ln, err = w.Write(l)
 	n += ln
 	if err != nil {
 		return n, err
 	}
 	l = make([]byte, 2)
-	binary.BigEndian.PutUint16(l, otr.ScopeFieldCount)
+	binary.BigEndian.PutUint16(l, scopeFieldCount)
 	ln, err = w.Write(l)
 	n += ln
 	if err != nil {
 		return n, err
 	}
 	for _, r := range otr.Scopes {
-		isEnterprise := r.PEN() != 0
+		key := wireKey(r)
+		isEnterprise := key.EnterpriseId != 0
 		b := make([]byte, 0)
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint16(b, penMask|r.Id())
+			b = binary.BigEndian.AppendUint16(b, penMask|key.Id)
 		} else {
-			b = binary.BigEndian.AppendUint16(b, r.Id())
+			b = binary.BigEndian.AppendUint16(b, key.Id)
 		}
 		b = binary.BigEndian.AppendUint16(b, r.Length())
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint32(b, r.PEN())
+			b = binary.BigEndian.AppendUint32(b, key.EnterpriseId)
 		}
 		bn, err := w.Write(b)
 		n += bn
@@ -238,16 +250,17 @@ func (otr *OptionsTemplateRecord) Encode(w io.Writer) (n int, err error) {
 		}
 	}
 	for _, r := range otr.Options {
-		isEnterprise := r.PEN() != 0
+		key := wireKey(r)
+		isEnterprise := key.EnterpriseId != 0
 		b := make([]byte, 0)
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint16(b, penMask|r.Id())
+			b = binary.BigEndian.AppendUint16(b, penMask|key.Id)
 		} else {
-			b = binary.BigEndian.AppendUint16(b, r.Id())
+			b = binary.BigEndian.AppendUint16(b, key.Id)
 		}
 		b = binary.BigEndian.AppendUint16(b, r.Length())
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint32(b, r.PEN())
+			b = binary.BigEndian.AppendUint32(b, key.EnterpriseId)
 		}
 		bn, err := w.Write(b)
 		n += bn
@@ -258,6 +271,20 @@ func (otr *OptionsTemplateRecord) Encode(w io.Writer) (n int, err error) {
 	return n, err
 }
 
+// counts returns the field count and scope field count of the record derived from its fields.
+// Counts set on the record need to match the derived ones.
+func (otr *OptionsTemplateRecord) counts() (fieldCount uint16, scopeFieldCount uint16, err error) {
+	fieldCount = uint16(len(otr.Scopes) + len(otr.Options))
+	scopeFieldCount = uint16(len(otr.Scopes))
+	if otr.ScopeFieldCount != 0 && otr.ScopeFieldCount != scopeFieldCount {
+		return 0, 0, fmt.Errorf("options template record %d declares %d scope fields, but has %d", otr.TemplateId, otr.ScopeFieldCount, scopeFieldCount)
+	}
+	if otr.FieldCount != 0 && otr.FieldCount != fieldCount {
+		return 0, 0, fmt.Errorf("options template record %d declares %d fields, but has %d", otr.TemplateId, otr.FieldCount, fieldCount)
+	}
+	return fieldCount, scopeFieldCount, nil
+}
+
 func (otr *OptionsTemplateRecord) MarshalJSON() ([]byte, error) {
 	type iotr struct {
 		TemplateId uint16 `json:"template_id,omitempty" yaml:"templateId,omitempty"`
@@ -309,14 +336,67 @@ func (otr *OptionsTemplateRecord) UnmarshalJSON(in []byte) error {
 	ss := make([]Field, 0, len(t.Scopes))
 	for _, cf := range t.Scopes {
 		// TODO(zoomoid): check if this is ok, i.e., "we don't need the FieldManager and TemplateManager here anymore"
-		ss = append(ss, cf.restore(otr.fieldCache, otr.templateCache))
+		f, err := cf.restore(otr.fieldCache, otr.templateCache)
+		if err != nil {
+			return err
+		}
+		ss = append(ss, f)
 	}
 	otr.Scopes = ss
 
 	os := make([]Field, 0, len(t.Options))
-	for _, cf := range t.Scopes {
+	for _, cf := range t.Options {
 		// TODO(zoomoid): check if this is ok, i.e., "we don't need the FieldManager and TemplateManager here anymore"
-		os = append(os, cf.restore(otr.fieldCache, otr.templateCache))
+		f, err := cf.restore(otr.fieldCache, otr.templateCache)
+		if err != nil {
+			return err
+		}
+		os = append(os, f)
+	}
+	otr.Options = os
+
+	return nil
+}
+
+func (otr *OptionsTemplateRecord) MarshalYAML() (interface{}, error) {
+	type iotr struct {
+		TemplateId uint16  `yaml:"templateId,omitempty"`
+		Scopes     []Field `yaml:"scopes,omitempty"`
+		Options    []Field `yaml:"options,omitempty"`
+	}
+
+	return &iotr{
+		TemplateId: otr.TemplateId,
+		Scopes:     otr.Scopes,
+		Options:    otr.Options,
+	}, nil
+}
+
+func (otr *OptionsTemplateRecord) UnmarshalYAML(node *yaml.Node) error {
+	type iotr struct {
+		TemplateId uint16      `yaml:"templateId,omitempty"`
+		Scopes     []yamlField `yaml:"scopes,omitempty"`
+		Options    []yamlField `yaml:"options,omitempty"`
+	}
+
+	t := &iotr{}
+	err := node.Decode(t)
+	if err != nil {
+		return err
+	}
+	otr.TemplateId = t.TemplateId
+	otr.ScopeFieldCount = uint16(len(t.Scopes))
+	otr.FieldCount = uint16(len(t.Scopes) + len(t.Options))
+
+	ss, err := restoreYAMLFields(t.Scopes, otr.fieldCache, otr.templateCache)
+	if err != nil {
+		return err
+	}
+	otr.Scopes = ss
+
+	os, err := restoreYAMLFields(t.Options, otr.fieldCache, otr.templateCache)
+	if err != nil {
+		return err
 	}
 	otr.Options = os
 
@@ -326,21 +406,10 @@ func (otr *OptionsTemplateRecord) UnmarshalJSON(in []byte) error {
 func (otr *OptionsTemplateRecord) Length() uint16 {
 	l := uint16(0)
 	for _, f := range otr.Scopes {
-		// OptionsTemplateRecord fields do not have the intrinsic length as DataRecord fields, but rather
-		// static length of sizeof(fieldId) + sizeof(fieldLength) + (penProvided ? sizeof(pen) : 0)
-		// which in practice is either 4 bytes or 4+4 = 8 bytes
-		if f.PEN() == 0 {
-			l += 4
-		} else {
-			l += 8
-		}
+		l += fieldSpecifierLength(f)
 	}
 	for _, f := range otr.Options {
-		if f.PEN() == 0 {
-			l += 4
-		} else {
-			l += 8
-		}
+		l += fieldSpecifierLength(f)
 	}
 	return l + 2 + 2 + 2 // length of scopes and options + record header
 }
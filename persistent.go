@@ -17,12 +17,14 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -48,16 +50,52 @@ type PersistentCache struct {
 	name string
 
 	ready bool
+
+	// checkpointInterval is the interval at which the cache is written to file while running
+	checkpointInterval time.Duration
+	// checkpointIntervalMu guards checkpointInterval against changes while running
+	checkpointIntervalMu sync.Mutex
+	// checkpointIntervalChanged notifies the checkpoint loop of a new interval
+	checkpointIntervalChanged chan struct{}
+	// checkpointOnAdd writes the cache to file on every Add
+	checkpointOnAdd bool
+	// checkpointMu serializes checkpoints, such that concurrent renames cannot reorder snapshots
+	checkpointMu sync.Mutex
+	// wrapWriter is used in tests for injecting failures into writing checkpoints
+	wrapWriter func(io.Writer) io.Writer
+}
+
+// PersistentCacheOption configures a PersistentCache created with NewDefaultPersistentCache or
+// NewNamedPersistentCache
+type PersistentCacheOption func(*PersistentCache)
+
+// WithCheckpointInterval periodically writes the cache to file while it is running, such that
+// templates are not lost on crashes. An interval of zero disables periodic checkpoints, which is
+// the default, and the cache is only written to file in Close.
+func WithCheckpointInterval(d time.Duration) PersistentCacheOption {
+	return func(c *PersistentCache) {
+		c.checkpointInterval = d
+	}
+}
+
+// WithCheckpointOnAdd writes the cache to file on every Add. As every checkpoint serializes the
+// entire cache, this is only advisable for small numbers of templates. Add returns errors of
+// failed checkpoints, the template is added to the cache regardless.
+func WithCheckpointOnAdd() PersistentCacheOption {
+	return func(c *PersistentCache) {
+		c.checkpointOnAdd = true
+	}
 }
 
 var _ StatefulTemplateCache = &PersistentCache{}
 var _ TemplateCacheDriver = &PersistentCache{}
+var _ TemplateCacheWithStats = &PersistentCache{}
 
-func NewDefaultPersistentCache(file *os.File, fieldCache FieldCache, templateCache StatefulTemplateCache) StatefulTemplateCache {
-	return NewNamedPersistentCache("default", file, fieldCache, templateCache)
+func NewDefaultPersistentCache(file *os.File, fieldCache FieldCache, templateCache StatefulTemplateCache, opts ...PersistentCacheOption) StatefulTemplateCache {
+	return NewNamedPersistentCache("default", file, fieldCache, templateCache, opts...)
 }
 
-func NewNamedPersistentCache(name string, file *os.File, fieldCache FieldCache, templateCache StatefulTemplateCache) StatefulTemplateCache {
+func NewNamedPersistentCache(name string, file *os.File, fieldCache FieldCache, templateCache StatefulTemplateCache, opts ...PersistentCacheOption) StatefulTemplateCache {
 	c := &PersistentCache{
 		file:       file,
 		fieldCache: fieldCache,
@@ -66,6 +104,11 @@ func NewNamedPersistentCache(name string, file *os.File, fieldCache FieldCache,
 		// wg:         &sync.WaitGroup{},
 		name:  name,
 		ready: false,
+
+		checkpointIntervalChanged: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
 	// immediately lock mutex to prevent frontend functions from passing by Start/Initialize
@@ -78,7 +121,14 @@ func (t *PersistentCache) Add(ctx context.Context, key TemplateKey, template *Te
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	return t.cache.Add(ctx, key, template)
+	err := t.cache.Add(ctx, key, template)
+	if err != nil {
+		return err
+	}
+	if t.checkpointOnAdd {
+		return t.checkpoint(ctx)
+	}
+	return nil
 }
 
 func (t *PersistentCache) Delete(ctx context.Context, key TemplateKey) error {
@@ -102,6 +152,17 @@ func (t *PersistentCache) GetAll(ctx context.Context) map[TemplateKey]*Template
 	return t.cache.GetAll(ctx)
 }
 
+// Stats returns usage statistics of all templates in the underlying cache
+func (t *PersistentCache) Stats(ctx context.Context) []TemplateStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if sc, ok := t.cache.(TemplateCacheWithStats); ok {
+		return sc.Stats(ctx)
+	}
+	return templateStats(t.cache.GetAll(ctx))
+}
+
 func (t *PersistentCache) MarshalJSON() ([]byte, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -145,40 +206,43 @@ func (t *PersistentCache) Initialize(ctx context.Context) error {
 	}
 
 	type marshalledTemplates struct {
-		ExportedAt time.Time                  `json:"exported_at,omitempty"`
-		StoreType  string                     `json:"store_type,omitempty"`
-		StoreName  string                     `json:"store_name,omitempty"`
-		Templates  map[string]json.RawMessage `json:"templates,omitempty"`
+		ExportedAt time.Time       `json:"exported_at,omitempty"`
+		StoreType  string          `json:"store_type,omitempty"`
+		StoreName  string          `json:"store_name,omitempty"`
+		Templates  json.RawMessage `json:"templates,omitempty"`
+	}
+
+	// an empty or truncated file is most likely the result of a crash while writing the file
+	// with a previous version not writing checkpoints atomically. Start with an empty cache in
+	// that case instead of failing entirely. Any other malformed file is returned as an error,
+	// such that it is not replaced by the next checkpoint
+	if len(bytes.TrimSpace(b)) == 0 {
+		FromContext(ctx).Error(nil, "template file is empty, starting with an empty cache", "file", t.file.Name())
+		return nil
 	}
 
 	ts := marshalledTemplates{}
 	err = json.Unmarshal(b, &ts)
 	if err != nil {
-		return err
+		if isTruncatedJSON(b) {
+			FromContext(ctx).Error(err, "template file is truncated, starting with an empty cache", "file", t.file.Name())
+			return nil
+		}
+		return fmt.Errorf("failed to restore templates from %s, %w", t.file.Name(), err)
 	}
 	// logger.V(1).Info("restoring templates from file", "store_name", ts.StoreName, "store_type", ts.StoreType, "exported_at", ts.ExportedAt)
 
-	templateMap := make(map[TemplateKey]Template)
-	for key, value := range ts.Templates {
-
-		tt := Template{}
-		err := json.Unmarshal(value, &tt)
-		if err != nil {
-			return err
-		}
-
-		kkey := TemplateKey{}
-		err = kkey.UnmarshalText([]byte(key))
-		if err != nil {
-			return err
-		}
-
-		templateMap[kkey] = tt
+	if len(ts.Templates) == 0 {
+		return nil
+	}
+	templateMap, err := unmarshalTemplates(ts.Templates, t.fieldCache, t.cache)
+	if err != nil {
+		return err
 	}
 
 	for k, v := range templateMap {
 		// pass through mutex/waitgroup of PersistentCache's Add
-		err := t.cache.Add(ctx, k, &v)
+		err := t.cache.Add(ctx, k, v)
 		if err != nil {
 			return err
 		}
@@ -189,22 +253,48 @@ func (t *PersistentCache) Initialize(ctx context.Context) error {
 	return nil
 }
 
-func (t *PersistentCache) Close(context.Context) error {
-	fn := t.file.Name()
+// isTruncatedJSON returns true if b is the beginning of a valid JSON value, i.e., it only lacks
+// its end. json.Unmarshal reports this as a generic syntax error, whereas json.Decoder reports
+// io.ErrUnexpectedEOF.
+func isTruncatedJSON(b []byte) bool {
+	var v json.RawMessage
+	err := json.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
 
+// Close closes the cache's file and dumps all templates to it. The dump replaces the file
+// atomically, such that a failed dump leaves the previous contents intact. If ctx is done before
+// the dump completes, Close returns ctx's error and the dump is aborted before replacing the file.
+func (t *PersistentCache) Close(ctx context.Context) error {
 	// close file for reading access
 	err := t.file.Close()
 	if err != nil {
 		return err
 	}
 
-	// re-open file for writing access
-	file, err := os.Create(fn)
-	if err != nil {
+	// buffered such that the dump can finish after Close returned on ctx being done
+	done := make(chan error, 1)
+	go func() {
+		done <- t.checkpoint(ctx)
+	}()
+	select {
+	case err := <-done:
 		return err
+	case <-ctx.Done():
+		return fmt.Errorf("failed to dump templates to %s, %w", t.file.Name(), ctx.Err())
+	}
+}
+
+// checkpoint dumps the templates to a temporary file in the directory of the cache's file and
+// renames it over the cache's file. Renaming is atomic, such that a crash during checkpointing
+// leaves the previous snapshot intact. If ctx is done before renaming, the checkpoint is aborted.
+func (t *PersistentCache) checkpoint(ctx context.Context) error {
+	t.checkpointMu.Lock()
+	defer t.checkpointMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("aborted checkpoint, %w", err)
 	}
-	t.file = file
-	defer t.file.Close()
 
 	// dump templates to JSON, write to file and close handle
 	type templates struct {
@@ -231,14 +321,99 @@ func (t *PersistentCache) Close(context.Context) error {
 		return err
 	}
 
-	_, err = t.file.Write(o)
+	fn := t.file.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn)+".tmp-*")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create temporary checkpoint file, %w", err)
+	}
+	// removing the temporary file fails after it was renamed, which is fine
+	defer os.Remove(tmp.Name())
+
+	var w io.Writer = tmp
+	if t.wrapWriter != nil {
+		w = t.wrapWriter(w)
+	}
+	_, err = w.Write(o)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint, %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("aborted checkpoint, %w", err)
 	}
 
+	err = os.Rename(tmp.Name(), fn)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s with checkpoint, %w", fn, err)
+	}
 	return nil
 }
 
+// SetCheckpointInterval changes the interval at which the cache is written to file while it is
+// running, see WithCheckpointInterval. It can be called before or after Start, an interval of
+// zero stops periodic checkpoints.
+func (t *PersistentCache) SetCheckpointInterval(d time.Duration) {
+	t.checkpointIntervalMu.Lock()
+	t.checkpointInterval = d
+	t.checkpointIntervalMu.Unlock()
+
+	// the loop reads the latest interval, so a pending notification suffices
+	select {
+	case t.checkpointIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (t *PersistentCache) getCheckpointInterval() time.Duration {
+	t.checkpointIntervalMu.Lock()
+	defer t.checkpointIntervalMu.Unlock()
+	return t.checkpointInterval
+}
+
+// runCheckpoints writes checkpoints periodically until ctx is cancelled
+func (t *PersistentCache) runCheckpoints(ctx context.Context) {
+	logger := FromContext(ctx)
+
+	var ticker *time.Ticker
+	// tick stays nil while periodic checkpoints are disabled, blocking forever
+	var tick <-chan time.Time
+	reset := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if d := t.getCheckpointInterval(); d > 0 {
+			ticker = time.NewTicker(d)
+			tick = ticker.C
+		}
+	}
+	reset()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.checkpointIntervalChanged:
+			reset()
+		case <-tick:
+			// checkpoints aborted on shutdown are superseded by the final dump in Close
+			if err := t.checkpoint(ctx); err != nil && ctx.Err() == nil {
+				logger.Error(err, "failed to checkpoint templates", "file", t.file.Name())
+			}
+		}
+	}
+}
+
 // Start implements manager.Runnable, to handle the lifecycle of the persistent cache
 func (t *PersistentCache) Start(ctx context.Context) error {
 	// start the underlying cache asynchronously first, Start(...) will block the goroutine
@@ -266,15 +441,21 @@ func (t *PersistentCache) Start(ctx context.Context) error {
 		return err
 	}
 
+	checkpointsDone := make(chan struct{})
+	go func() {
+		defer close(checkpointsDone)
+		t.runCheckpoints(ctx)
+	}()
+
 	// block until the root context is cancelled, e.g., by signaling
 	<-ctx.Done()
+	// wait for a running checkpoint to finish before writing the final one
+	<-checkpointsDone
 
 	// perform shutdown with a separate context that cancels automatically after 5 seconds
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 
-	// TODO(zoomoid): Close() does currently not adhere to the context's deadline, i.e., it runs
-	// til completion anyway
 	if err := t.Close(shutdownCtx); err != nil {
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			return fmt.Errorf("cancelled context before completing dump to file, %w", err)
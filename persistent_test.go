@@ -17,32 +17,25 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-func cacheFactory(file *os.File) (StatefulTemplateCache, error) {
+func cacheFactory(file *os.File, opts ...PersistentCacheOption) (StatefulTemplateCache, error) {
 	underlyingTemplateCache := NewNamedEphemeralCache("backing_cache")
 
-	// this field cache does not load any field definitions, we need to add them manually
-	// for a field manager with loaded IPFIX fields, see pkg/collector/internal/managers/fields.go
-	fieldManager := NewEphemeralFieldCache(underlyingTemplateCache)
+	fieldManager := NewIANAFieldCache(underlyingTemplateCache)
 
-	for id, f := range iana() {
-		if f.Id == 0 {
-			f.Id = id
-		}
-		err := fieldManager.Add(context.Background(), *f)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	cache := NewNamedPersistentCache("persistence_test", file, fieldManager, underlyingTemplateCache)
+	cache := NewNamedPersistentCache("persistence_test", file, fieldManager, underlyingTemplateCache, opts...)
 
 	return cache, nil
 }
@@ -241,6 +234,358 @@ func TestPersistentCache(t *testing.T) {
 			t.Fatal(err)
 		}
 	})
+
+	// newCache creates a persistent cache from the file at p, creating it if it does not exist
+	newCache := func(t *testing.T, p string, opts ...PersistentCacheOption) *PersistentCache {
+		file, err := os.OpenFile(p, os.O_RDONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache, err := cacheFactory(file, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache.(*PersistentCache)
+	}
+
+	// restored returns the number of templates restored from the file at p
+	restored := func(t *testing.T, p string) int {
+		cache := newCache(t, p)
+		defer cache.file.Close()
+		err := cache.Initialize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(cache.cache.GetAll(context.Background()))
+	}
+
+	newTemplate := func(id uint16) *Template {
+		return &Template{
+			Record: &TemplateRecord{
+				TemplateId: id,
+				FieldCount: 1,
+				Fields: []Field{
+					NewFieldBuilder(iana()[8]).SetLength(4).Complete(),
+				},
+			},
+		}
+	}
+
+	// start runs the cache until the test ends and waits for Start to have initialized it
+	start := func(t *testing.T, cache *PersistentCache) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = cache.Start(ctx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		// GetAll blocks until Start released the mutex after initialization
+		cache.GetAll(ctx)
+	}
+
+	t.Run("empty or truncated file", func(t *testing.T) {
+		for name, content := range map[string][]byte{
+			"empty":     {},
+			"truncated": fixtureTemplates[:len(fixtureTemplates)/2],
+		} {
+			t.Run(name, func(t *testing.T) {
+				p := filepath.Join(t.TempDir(), "templates.json")
+				err := os.WriteFile(p, content, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := restored(t, p); n != 0 {
+					t.Errorf("expected to start with an empty cache, found %d templates", n)
+				}
+			})
+		}
+	})
+
+	t.Run("corrupt file is not replaced", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "templates.json")
+		content := append([]byte("{\"templates\": ]"), fixtureTemplates...)
+		err := os.WriteFile(p, content, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Start fails before the first periodic checkpoint could replace the file
+		cache := newCache(t, p, WithCheckpointInterval(time.Millisecond))
+		defer cache.file.Close()
+		var serr *json.SyntaxError
+		if err := cache.Start(context.Background()); !errors.As(err, &serr) {
+			t.Errorf("expected syntax error for corrupt file, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Error("expected corrupt file to be left untouched")
+		}
+	})
+
+	t.Run("checkpoint on add", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "templates.json")
+		cache := newCache(t, p, WithCheckpointOnAdd())
+		start(t, cache)
+
+		err := cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := restored(t, p); n != 1 {
+			t.Errorf("expected 1 template in checkpoint, found %d", n)
+		}
+	})
+
+	t.Run("periodic checkpoints", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "templates.json")
+		cache := newCache(t, p, WithCheckpointInterval(10*time.Millisecond))
+		start(t, cache)
+
+		err := cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for restored(t, p) != 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for checkpoint")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("checkpoint interval set while running survives crash", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "templates.json")
+		cache := newCache(t, p)
+		start(t, cache)
+
+		err := cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.Add(context.Background(), NewKey(0, 257), newTemplate(257))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.SetCheckpointInterval(10 * time.Millisecond)
+
+		// the cache is never closed, reopening the file while it is running resembles a crash
+		deadline := time.Now().Add(2 * time.Second)
+		for restored(t, p) != 2 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for checkpoint")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// disabling checkpoints again keeps the previous snapshot
+		cache.SetCheckpointInterval(0)
+		time.Sleep(20 * time.Millisecond)
+		err = cache.Add(context.Background(), NewKey(0, 258), newTemplate(258))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := restored(t, p); n != 2 {
+			t.Errorf("expected no checkpoints after disabling them, found %d templates", n)
+		}
+	})
+
+	t.Run("failed checkpoint keeps previous snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "templates.json")
+		err := os.WriteFile(p, fixtureTemplates, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fail atomic.Bool
+		cache := newCache(t, p, WithCheckpointOnAdd())
+		cache.wrapWriter = func(w io.Writer) io.Writer {
+			if fail.Load() {
+				return &failingWriter{w: w}
+			}
+			return w
+		}
+		start(t, cache)
+
+		err = cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the checkpoint loop is interrupted mid-write
+		fail.Store(true)
+		err = cache.Add(context.Background(), NewKey(0, 257), newTemplate(257))
+		if err == nil {
+			t.Fatal("expected checkpoint to fail")
+		}
+
+		if n := restored(t, p); n != 4 {
+			t.Errorf("expected previous snapshot with 4 templates, found %d", n)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected temporary checkpoint files to be removed, found %d files", len(entries))
+		}
+	})
+	t.Run("close with cancelled context", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "templates.json")
+		err := os.WriteFile(p, fixtureTemplates, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache := newCache(t, p)
+		err = cache.Initialize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := cache.Close(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		// wait for the aborted dump
+		cache.checkpointMu.Lock()
+		defer cache.checkpointMu.Unlock()
+
+		if n := restored(t, p); n != 3 {
+			t.Errorf("expected previous snapshot with 3 templates, found %d", n)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected no temporary checkpoint files, found %d files", len(entries))
+		}
+	})
+
+	t.Run("close exceeding deadline", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "templates.json")
+		err := os.WriteFile(p, fixtureTemplates, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		release := make(chan struct{})
+		cache := newCache(t, p)
+		cache.wrapWriter = func(w io.Writer) io.Writer {
+			return &blockingWriter{w: w, release: release}
+		}
+		err = cache.Initialize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if err := cache.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("expected Close to return on the deadline, took %v", d)
+		}
+
+		// the dump finishes writing after the deadline, and is aborted before replacing the file
+		close(release)
+		cache.checkpointMu.Lock()
+		defer cache.checkpointMu.Unlock()
+		if n := restored(t, p); n != 3 {
+			t.Errorf("expected previous snapshot with 3 templates, found %d", n)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected temporary checkpoint files to be removed, found %d files", len(entries))
+		}
+	})
+
+	t.Run("failed close keeps previous snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "templates.json")
+		err := os.WriteFile(p, fixtureTemplates, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache := newCache(t, p)
+		cache.wrapWriter = func(w io.Writer) io.Writer {
+			return &failingWriter{w: w}
+		}
+		err = cache.Initialize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.cache.Add(context.Background(), NewKey(0, 256), newTemplate(256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cache.Close(context.Background()); err == nil {
+			t.Fatal("expected close to fail")
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != string(fixtureTemplates) {
+			t.Errorf("expected file to be left intact, found %s", string(b))
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected temporary checkpoint files to be removed, found %d files", len(entries))
+		}
+	})
+}
+
+// blockingWriter blocks writes to w until release is closed, simulating a slow disk
+type blockingWriter struct {
+	w       io.Writer
+	release <-chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return b.w.Write(p)
+}
+
+// failingWriter writes half of the bytes to w before failing, simulating a crash mid-write
+type failingWriter struct {
+	w io.Writer
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	n, _ := f.w.Write(b[:len(b)/2])
+	return n, errors.New("simulated crash")
 }
 
 var fixtureTemplates []byte = []byte(`
@@ -250,7 +595,7 @@ var fixtureTemplates []byte = []byte(`
   "store_name": "persistence_test/in_memory",
   "templates": {
     "0-300": {
-      "kind": "TemplateRecord",
+      "kind": "TemplateSet",
       "record": {
         "template_id": 300,
         "fields": [
@@ -324,7 +669,7 @@ var fixtureTemplates []byte = []byte(`
       }
     },
     "0-301": {
-      "kind": "TemplateRecord",
+      "kind": "TemplateSet",
       "record": {
         "template_id": 301,
         "fields": [
@@ -362,7 +707,7 @@ var fixtureTemplates []byte = []byte(`
       }
     },
     "0-302": {
-      "kind": "OptionsTemplateRecord",
+      "kind": "OptionsTemplateSet",
       "record": {
         "template_id": 302,
         "scopes": [
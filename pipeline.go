@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPipelineQueueSize is the number of buffers queued per worker of a Pipeline. Once the
+// queue of a worker is full, the pipeline stops reading from its source, such that listeners
+// feeding the source apply their own back-pressure, e.g., UDPListener drops packets.
+const DefaultPipelineQueueSize int = 64
+
+// Pipeline decodes buffers received from a source channel, e.g., the channel of a UDPListener or
+// TCPListener, on a pool of workers sharing a single Decoder. Messages of the same observation
+// domain are always decoded by the same worker in the order they were received, such that
+// templates are added to the TemplateCache before the data sets referencing them are decoded.
+//
+// Templates are scoped to transport sessions. As buffers carry no information about their
+// exporter, a Pipeline is meant for the messages of a single exporter, or of exporters using
+// distinct observation domains. Use a Collector for keeping templates per exporter.
+type Pipeline struct {
+	src     <-chan []byte
+	decoder *Decoder
+	workers int
+
+	results chan *Message
+	errors  chan error
+
+	// metrics is optional, name is the value of the metrics' listener label
+	metrics *Metrics
+	name    string
+}
+
+// NewPipeline creates a pipeline decoding the buffers of src with dec on the given number of
+// workers. The decoder is used concurrently and must not be reconfigured afterwards. Workers of 0
+// or less are replaced by a single worker.
+func NewPipeline(src <-chan []byte, dec *Decoder, workers int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pipeline{
+		src:     src,
+		decoder: dec,
+		workers: workers,
+		results: make(chan *Message, workers*DefaultPipelineQueueSize),
+		errors:  make(chan error, workers*DefaultPipelineQueueSize),
+	}
+}
+
+// WithMetrics makes the pipeline report the queue depth and the time spent decoding per worker
+// to m. name is used as the value of the listener label, e.g., the listener's bind address.
+func (p *Pipeline) WithMetrics(m *Metrics, name string) *Pipeline {
+	p.metrics = m
+	p.name = name
+	return p
+}
+
+// Results returns the channel of decoded messages. The channel is closed when Start returns.
+func (p *Pipeline) Results() <-chan *Message {
+	return p.results
+}
+
+// Errors returns the channel of decoding errors. Messages that were decoded partially, i.e., up
+// to a failing set, are sent to Results in addition to the error. The channel is closed when
+// Start returns.
+func (p *Pipeline) Errors() <-chan error {
+	return p.errors
+}
+
+// Start decodes the buffers of the source until either the source is closed or ctx is cancelled.
+// In both cases, buffers already queued for workers are still decoded before Results and Errors
+// are closed and Start returns. Consumers must therefore keep reading from both channels until
+// they are closed, otherwise the pipeline stops decoding once their buffers are full.
+func (p *Pipeline) Start(ctx context.Context) error {
+	defer close(p.errors)
+	defer close(p.results)
+
+	var wg sync.WaitGroup
+	queues := make([]chan []byte, p.workers)
+	for i := range queues {
+		queues[i] = make(chan []byte, DefaultPipelineQueueSize)
+		wg.Add(1)
+		go func(worker int, in <-chan []byte) {
+			defer wg.Done()
+			// buffers queued before cancellation are still decoded
+			p.work(context.WithoutCancel(ctx), worker, in)
+		}(i, queues[i])
+	}
+
+	p.dispatch(ctx, queues)
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	return nil
+}
+
+// dispatch passes each buffer to the worker responsible for the buffer's observation domain until
+// ctx is cancelled or the source is closed
+func (p *Pipeline) dispatch(ctx context.Context, queues []chan []byte) {
+	depths := p.queueDepths()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b, ok := <-p.src:
+			if !ok {
+				return
+			}
+			i := shardOfMessage(b, len(queues))
+			select {
+			case queues[i] <- b:
+			case <-ctx.Done():
+				return
+			}
+			if depths != nil {
+				depths[i].Set(float64(len(queues[i])))
+			}
+		}
+	}
+}
+
+// work decodes the buffers of in and sends the outcome to the results and errors channels
+func (p *Pipeline) work(ctx context.Context, worker int, in <-chan []byte) {
+	var depth prometheus.Gauge
+	var busy prometheus.Counter
+	if p.metrics != nil {
+		label := strconv.Itoa(worker)
+		depth = p.metrics.PipelineQueueDepth.WithLabelValues(p.name, label)
+		busy = p.metrics.PipelineWorkerBusySeconds.WithLabelValues(p.name, label)
+	}
+
+	for b := range in {
+		if depth != nil {
+			depth.Set(float64(len(in)))
+		}
+		start := time.Now()
+		msg, err := p.decoder.Decode(ctx, bytes.NewBuffer(b))
+		if busy != nil {
+			busy.Add(time.Since(start).Seconds())
+		}
+
+		if msg != nil {
+			p.results <- msg
+		}
+		if err != nil {
+			p.errors <- err
+		}
+	}
+}
+
+// queueDepths returns the queue depth gauges of all workers, or nil without metrics
+func (p *Pipeline) queueDepths() []prometheus.Gauge {
+	if p.metrics == nil {
+		return nil
+	}
+	gauges := make([]prometheus.Gauge, p.workers)
+	for i := range gauges {
+		gauges[i] = p.metrics.PipelineQueueDepth.WithLabelValues(p.name, strconv.Itoa(i))
+	}
+	return gauges
+}
+
+// shardOfMessage returns the worker out of n responsible for the observation domain of the message
+// in b. Observation domains are usually numbered sequentially, so they are distributed round-robin.
+// Buffers too short for a message header are passed to the first worker, whose decoder reports
+// them as malformed.
+func shardOfMessage(b []byte, n int) int {
+	if n <= 1 || len(b) < messageHeaderLength {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b[12:16]) % uint32(n))
+}
@@ -0,0 +1,221 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestPipelineMessages creates a template message and a data message of n records for each of
+// the observation domains, with the template messages of all observation domains first
+func newTestPipelineMessages(observationDomains int, n int) [][]byte {
+	// template 256 of sourceTransportPort
+	template := []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x07, 0x00, 0x02}
+	records := make([]byte, 0, 2*n)
+	for i := 0; i < n; i++ {
+		records = binary.BigEndian.AppendUint16(records, uint16(i))
+	}
+
+	messages := make([][]byte, 0, 2*observationDomains)
+	for odid := 1; odid <= observationDomains; odid++ {
+		messages = append(messages, newTestCollectorMessage(1700000000, uint32(odid), IPFIX, template))
+	}
+	for odid := 1; odid <= observationDomains; odid++ {
+		messages = append(messages, newTestCollectorMessage(1700000001, uint32(odid), 256, records))
+	}
+	return messages
+}
+
+// drainPipeline reads from the pipeline's channels until both are closed
+func drainPipeline(t testing.TB, p *Pipeline) ([]*Message, []error) {
+	messages, errs := make([]*Message, 0), make([]error, 0)
+	results, errors := p.Results(), p.Errors()
+	// the timeout is reset on every received value, such that long benchmarks do not time out
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	for results != nil || errors != nil {
+		timer.Reset(5 * time.Second)
+		select {
+		case msg, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			messages = append(messages, msg)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			errs = append(errs, err)
+		case <-timer.C:
+			t.Fatal("pipeline did not close its channels")
+		}
+	}
+	return messages, errs
+}
+
+func TestPipeline(t *testing.T) {
+	newDecoder := func() *Decoder {
+		templateCache := NewDefaultEphemeralCache()
+		return NewDecoder(templateCache, NewIANAFieldManager(templateCache))
+	}
+
+	t.Run("decodes messages of all observation domains in order", func(t *testing.T) {
+		messages := newTestPipelineMessages(16, 4)
+		src := make(chan []byte, len(messages))
+		for _, m := range messages {
+			src <- m
+		}
+		close(src)
+
+		p := NewPipeline(src, newDecoder(), 4)
+		errCh := make(chan error, 1)
+		go func() { errCh <- p.Start(context.Background()) }()
+
+		decoded, errs := drainPipeline(t, p)
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		// data sets decoded before the template of their observation domain would fail
+		if len(errs) != 0 {
+			t.Fatalf("expected no errors, got %v", errs)
+		}
+		if len(decoded) != len(messages) {
+			t.Fatalf("expected %d messages, got %d", len(messages), len(decoded))
+		}
+		records := make(map[uint32]int)
+		for _, msg := range decoded {
+			if ds, ok := msg.Sets[0].Set.(*DataSet); ok {
+				records[msg.ObservationDomainId] += len(ds.Records)
+			}
+		}
+		for odid := uint32(1); odid <= 16; odid++ {
+			if records[odid] != 4 {
+				t.Errorf("expected 4 records in observation domain %d, got %d", odid, records[odid])
+			}
+		}
+	})
+
+	t.Run("errors are reported alongside results", func(t *testing.T) {
+		src := make(chan []byte, 2)
+		// a data set of an unknown template, and a buffer too short for a message header
+		src <- newTestCollectorMessage(1700000000, 1, 256, []byte{0x01, 0xbb})
+		src <- []byte{0x00, 0x0a}
+		close(src)
+
+		p := NewPipeline(src, newDecoder(), 2)
+		go func() { _ = p.Start(context.Background()) }()
+		decoded, errs := drainPipeline(t, p)
+		if len(errs) != 2 {
+			t.Errorf("expected 2 errors, got %v", errs)
+		}
+		if len(decoded) != 1 {
+			t.Errorf("expected the partially decoded message, got %d messages", len(decoded))
+		}
+	})
+
+	t.Run("drains queued buffers on cancellation", func(t *testing.T) {
+		messages := newTestPipelineMessages(8, 16)
+		// the source is never closed, such that the pipeline only stops on cancellation
+		src := make(chan []byte, len(messages))
+		for _, m := range messages {
+			src <- m
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		p := NewPipeline(src, newDecoder(), 4)
+		errCh := make(chan error, 1)
+		go func() { errCh <- p.Start(ctx) }()
+
+		// wait for the first message before cancelling
+		first := <-p.Results()
+		cancel()
+		decoded, errs := drainPipeline(t, p)
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+
+		// every buffer taken from the source was decoded
+		consumed := len(messages) - len(src)
+		if received := 1 + len(decoded) + len(errs); received != consumed {
+			t.Errorf("expected %d buffers taken from the source to be decoded, got %d", consumed, received)
+		}
+		if first == nil {
+			t.Error("expected first message")
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		messages := newTestPipelineMessages(2, 1)
+		src := make(chan []byte, len(messages))
+		for _, m := range messages {
+			src <- m
+		}
+		close(src)
+
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		p := NewPipeline(src, newDecoder(), 2).WithMetrics(m, "test")
+		go func() { _ = p.Start(context.Background()) }()
+		drainPipeline(t, p)
+
+		for _, worker := range []string{"0", "1"} {
+			if busy := testutil.ToFloat64(m.PipelineWorkerBusySeconds.WithLabelValues("test", worker)); busy <= 0 {
+				t.Errorf("expected worker %s to report time spent decoding, got %f", worker, busy)
+			}
+			if depth := testutil.ToFloat64(m.PipelineQueueDepth.WithLabelValues("test", worker)); depth != 0 {
+				t.Errorf("expected queue of worker %s to be empty, got %f", worker, depth)
+			}
+		}
+	})
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	ctx := context.Background()
+	// data messages of 64 observation domains with 64 records each
+	messages := newTestPipelineMessages(64, 64)
+	templates, data := messages[:64], messages[64:]
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			templateCache := NewDefaultEphemeralCache()
+			decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache))
+			for _, t := range templates {
+				if _, err := decoder.Decode(ctx, bytes.NewBuffer(t)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			src := make(chan []byte, workers*DefaultPipelineQueueSize)
+			p := NewPipeline(src, decoder, workers)
+			go func() { _ = p.Start(ctx) }()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					src <- data[i%len(data)]
+				}
+				close(src)
+			}()
+
+			b.SetBytes(int64(len(data[0])))
+			b.ReportAllocs()
+			b.ResetTimer()
+			decoded, errs := drainPipeline(b, p)
+			b.StopTimer()
+			if len(errs) > 0 {
+				b.Fatal(errs[0])
+			}
+			if len(decoded) != b.N {
+				b.Fatalf("expected %d messages, got %d", b.N, len(decoded))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"sync"
+)
+
+// scratchBufferSize is the initial capacity of pooled scratch buffers. It covers all fixed-length
+// data types, larger buffers for variable-length fields are grown on demand and kept in the pool.
+//
+// Only data types that convert the read bytes into a value use scratch buffers. Data types whose
+// value is the read bytes, i.e., IPv4Address, IPv6Address, MacAddress, and OctetArray, allocate
+// their value per decode instead, as a pooled buffer would have to be copied into the value anyway.
+const scratchBufferSize = 16
+
+// maxPooledBufferSize caps the capacity of buffers that are returned to the pool, such that a
+// single large set or variable-length field does not pin memory for the lifetime of the pool.
+const maxPooledBufferSize = 0xFFFF
+
+var scratchPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, scratchBufferSize)
+		return &b
+	},
+}
+
+// getScratch returns a pooled scratch buffer of length n. The buffer MUST be returned with
+// putScratch once it is no longer used, and its contents MUST NOT be retained beyond that,
+// i.e., data types that keep the decoded bytes as their value need to copy them.
+func getScratch(n int) *[]byte {
+	p := scratchPool.Get().(*[]byte)
+	if cap(*p) < n {
+		*p = make([]byte, n)
+	}
+	*p = (*p)[:n]
+	return p
+}
+
+// putScratch returns a scratch buffer obtained from getScratch to the pool.
+func putScratch(p *[]byte) {
+	if cap(*p) > maxPooledBufferSize {
+		return
+	}
+	scratchPool.Put(p)
+}
@@ -16,7 +16,11 @@ limitations under the License.
 
 package ipfix
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
 // ReversePEN is the private enterprise number designated for signaling bidirectional flow information
 // contained in the record. By default, if a field has this PEN, the prototype IE is taken from the IANA
@@ -172,6 +176,138 @@ func reversible(fieldId uint16) bool {
 	return !nonReversible
 }
 
+// ReverseResolver implements the conventions of a registry of information elements for carrying the
+// reverse direction of biflows. For IANA IEs, these are defined by RFC 5103, i.e., reverse IEs carry
+// the ReversePEN and the id of their forward IE, except for the NonReversibleFields. Enterprise
+// registries may define their own conventions, e.g., CERT marks reverse IEs by setting bit 0x4000 in
+// the id of the forward IE, see ReverseBitResolver.
+//
+// Resolvers are registered with RegisterReverseResolver for the PENs of the IEs they resolve. They
+// are consulted by Field.Reversible, for decoding reverse IEs of templates and basicLists into
+// reversed fields of their forward IE, and for encoding reversed fields again.
+type ReverseResolver interface {
+	// Reverse returns the key of the IE carrying the reverse direction of the IE (pen, id), and false
+	// if the IE is not reversible.
+	Reverse(pen uint32, id uint16) (FieldKey, bool)
+	// Forward is the inverse of Reverse and returns the key of the IE whose reverse direction is
+	// carried by the IE (pen, id), and false if the IE is not a reverse IE.
+	Forward(pen uint32, id uint16) (FieldKey, bool)
+}
+
+// IANAReverseResolver implements RFC 5103 for IANA IEs. It is registered for both PEN 0 and ReversePEN.
+type IANAReverseResolver struct{}
+
+var _ ReverseResolver = IANAReverseResolver{}
+
+func (IANAReverseResolver) Reverse(pen uint32, id uint16) (FieldKey, bool) {
+	if pen != 0 || !reversible(id) {
+		return FieldKey{}, false
+	}
+	return NewFieldKey(ReversePEN, id), true
+}
+
+func (IANAReverseResolver) Forward(pen uint32, id uint16) (FieldKey, bool) {
+	if pen != ReversePEN || !reversible(id) {
+		return FieldKey{}, false
+	}
+	return NewFieldKey(0, id), true
+}
+
+// ReverseBitResolver implements the convention of enterprise registries that mark reverse IEs by
+// setting Bit in the id of the forward IE of the same PEN, e.g., CERT's registry used by yaf:
+//
+//	ipfix.RegisterReverseResolver(6871, &ipfix.ReverseBitResolver{PEN: 6871, Bit: 0x4000})
+type ReverseBitResolver struct {
+	PEN uint32
+	Bit uint16
+
+	// NonReversible contains the ids of forward IEs of the registry that are not reversible
+	NonReversible map[uint16]struct{}
+}
+
+var _ ReverseResolver = &ReverseBitResolver{}
+
+func (r *ReverseBitResolver) Reverse(pen uint32, id uint16) (FieldKey, bool) {
+	if pen != r.PEN || id&r.Bit != 0 {
+		return FieldKey{}, false
+	}
+	if _, nonReversible := r.NonReversible[id]; nonReversible {
+		return FieldKey{}, false
+	}
+	return NewFieldKey(pen, id|r.Bit), true
+}
+
+func (r *ReverseBitResolver) Forward(pen uint32, id uint16) (FieldKey, bool) {
+	if pen != r.PEN || id&r.Bit == 0 {
+		return FieldKey{}, false
+	}
+	if _, nonReversible := r.NonReversible[id&^r.Bit]; nonReversible {
+		return FieldKey{}, false
+	}
+	return NewFieldKey(pen, id&^r.Bit), true
+}
+
+var (
+	reverseResolversMu sync.RWMutex
+	reverseResolvers   = map[uint32]ReverseResolver{
+		0:          IANAReverseResolver{},
+		ReversePEN: IANAReverseResolver{},
+	}
+)
+
+// RegisterReverseResolver registers r as the resolver of IEs with the given PEN, replacing any resolver
+// registered for pen before. IEs of PENs without a resolver are not reversible. Resolvers should be
+// registered before decoding, as fields already decoded are not resolved again.
+func RegisterReverseResolver(pen uint32, r ReverseResolver) {
+	reverseResolversMu.Lock()
+	defer reverseResolversMu.Unlock()
+	reverseResolvers[pen] = r
+}
+
+func lookupReverseResolver(pen uint32) (ReverseResolver, bool) {
+	reverseResolversMu.RLock()
+	defer reverseResolversMu.RUnlock()
+	r, ok := reverseResolvers[pen]
+	return r, ok
+}
+
+// reverseKey returns the key of the IE carrying the reverse direction of the IE (pen, id) as per
+// the resolver registered for pen
+func reverseKey(pen uint32, id uint16) (FieldKey, bool) {
+	r, ok := lookupReverseResolver(pen)
+	if !ok {
+		return FieldKey{}, false
+	}
+	return r.Reverse(pen, id)
+}
+
+// forwardKey returns the key of the forward IE of the reverse IE (pen, id) as per the resolver
+// registered for pen
+func forwardKey(pen uint32, id uint16) (FieldKey, bool) {
+	r, ok := lookupReverseResolver(pen)
+	if !ok {
+		return FieldKey{}, false
+	}
+	return r.Forward(pen, id)
+}
+
+// reversibleIE returns true if the IE (pen, id) is reversible as per the resolver registered for pen
+func reversibleIE(pen uint32, id uint16) bool {
+	_, ok := reverseKey(pen, id)
+	return ok
+}
+
+// wireKey returns the key with which f is encoded in templates and basicLists, i.e., for reversed
+// fields the key of the reverse IE
+func wireKey(f Field) FieldKey {
+	if f.Reversed() {
+		if k, ok := reverseKey(f.PEN(), f.Id()); ok {
+			return k
+		}
+	}
+	return NewFieldKey(f.PEN(), f.Id())
+}
+
 // reversedName prefixes a field's usual name with "reversed" in camelCase
 // to textually indicate the presence of PEN 29305.
 //
@@ -182,3 +318,229 @@ func reversedName(name string) string {
 	s := strings.ToUpper(string([]rune(name)[0:1])) // UTF-8
 	return "reversed" + s + name[1:]
 }
+
+// forwardName removes the "reversed" prefix of reversedName from name, such that fields restored
+// from their reversed name are not prefixed twice
+func forwardName(name string) string {
+	s, ok := strings.CutPrefix(name, "reversed")
+	if !ok || s == "" {
+		return name
+	}
+	return strings.ToLower(s[0:1]) + s[1:]
+}
+
+// biflowKeyCounterparts maps the IANA IEs of the flow key that describe one endpoint of a flow
+// to the IE describing the other endpoint. In the reverse direction of a biflow, the endpoints
+// are swapped.
+var biflowKeyCounterparts = map[uint16]uint16{
+	7:  11, // sourceTransportPort, destinationTransportPort
+	11: 7,
+	8:  12, // sourceIPv4Address, destinationIPv4Address
+	12: 8,
+	27: 28, // sourceIPv6Address, destinationIPv6Address
+	28: 27,
+	56: 80, // sourceMacAddress, destinationMacAddress
+	80: 56,
+}
+
+// biflowSharedKeyFields are the IANA IEs of the flow key that are the same in both directions
+var biflowSharedKeyFields = map[uint16]struct{}{
+	4: {}, // protocolIdentifier
+}
+
+// biflowDirectionField is the IANA IE biflowDirection
+const biflowDirectionField uint16 = 239
+
+// biflowDirectionReverseInitiator is the value of biflowDirection denoting that the destination
+// of the biflow initiated the connection
+const biflowDirectionReverseInitiator uint8 = 2
+
+// SplitBiflow converts a biflow record as per RFC 5103 into two uniflow records. The forward record
+// carries all non-reversed fields of dr. The reverse record carries the reversed fields of dr under
+// their non-reversed IE, together with the flow key of dr, with source and destination addresses,
+// ports, and MAC addresses swapped, and the protocolIdentifier.
+//
+// biflowDirection describes the biflow and is omitted from both uniflow records. If it denotes the
+// destination as the initiator (reverseInitiator), the records are swapped, such that forward is
+// always the direction of the initiator.
+//
+// Use SplitBiflow to obtain records as exported by a uniflow exporter, e.g., for collectors that
+// do not understand biflows, and MergeBiflow to reverse it. Use SplitBidirectional instead to view
+// both directions of a record from the perspective of the biflow, i.e., with the flow key as is.
+//
+// The uniflow records are not bound to the template of dr anymore. Fields are cloned, such that
+// dr is left unchanged.
+func SplitBiflow(dr DataRecord) (forward DataRecord, reverse DataRecord, err error) {
+	swap := false
+	forward, reverse, err = splitReversed(&dr, func(f Field) (Field, Field, error) {
+		if f.PEN() != 0 {
+			return f.Clone(), nil, nil
+		}
+		if f.Id() == biflowDirectionField {
+			direction, ok := f.Value().Value().(uint8)
+			if !ok {
+				return nil, nil, fmt.Errorf("biflowDirection is of type %T", f.Value().Value())
+			}
+			swap = direction == biflowDirectionReverseInitiator
+			return nil, nil, nil
+		}
+		// the reverse direction shares the flow key of the forward direction
+		if _, ok := biflowSharedKeyFields[f.Id()]; ok {
+			return f.Clone(), f.Clone(), nil
+		}
+		if counterpart, ok := biflowKeyCounterparts[f.Id()]; ok {
+			cf, err := withId(f, counterpart)
+			if err != nil {
+				return nil, nil, err
+			}
+			return f.Clone(), cf, nil
+		}
+		return f.Clone(), nil, nil
+	})
+	if err != nil {
+		return DataRecord{}, DataRecord{}, fmt.Errorf("failed to split biflow, %w", err)
+	}
+
+	if swap {
+		forward, reverse = reverse, forward
+	}
+	return forward, reverse, nil
+}
+
+// SplitBidirectional splits a record carrying reversed fields as per RFC 5103 into a forward and a
+// reverse record. The forward record carries all non-reversed fields of dr. The reverse record carries
+// the reversed fields of dr under their non-reversed IE, i.e., with their base name, and all fields of
+// dr for which dr does not carry a reversed counterpart, e.g., flow keys and non-reversible fields.
+// Both records retain the order of fields in dr, but are not bound to the template of dr anymore.
+//
+// In contrast to SplitBiflow, SplitBidirectional neither swaps flow keys nor interprets biflowDirection,
+// such that both records describe the same flow, e.g., for comparing the counters of both directions.
+// If dr does not carry any reversed fields, ok is false and forward and reverse are nil. Fields are cloned,
+// such that dr is left unchanged.
+func (dr *DataRecord) SplitBidirectional() (forward, reverse *DataRecord, ok bool) {
+	reversed := make(map[FieldKey]struct{})
+	for _, f := range dr.Fields {
+		if f.Reversed() {
+			reversed[NewFieldKey(f.PEN(), f.Id())] = struct{}{}
+		}
+	}
+	if len(reversed) == 0 {
+		return nil, nil, false
+	}
+
+	fwd, rev, _ := splitReversed(dr, func(f Field) (Field, Field, error) {
+		if _, directional := reversed[NewFieldKey(f.PEN(), f.Id())]; directional {
+			return f.Clone(), nil, nil
+		}
+		return f.Clone(), f.Clone(), nil
+	})
+	return &fwd, &rev, true
+}
+
+// splitReversed splits dr into a forward and a reverse record. Reversed fields of dr are added to
+// the reverse record under their non-reversed IE. For all other fields, split returns the fields
+// added to the forward and the reverse record, either of which may be nil. Both records retain the
+// order of fields in dr.
+func splitReversed(dr *DataRecord, split func(f Field) (forward Field, reverse Field, err error)) (forward DataRecord, reverse DataRecord, err error) {
+	forward = DataRecord{TemplateId: dr.TemplateId, fieldCache: dr.fieldCache}
+	reverse = DataRecord{TemplateId: dr.TemplateId, fieldCache: dr.fieldCache}
+	for _, f := range dr.Fields {
+		if f.Reversed() {
+			reverse.Fields = append(reverse.Fields, withReversed(f, false))
+			continue
+		}
+		ff, rf, err := split(f)
+		if err != nil {
+			return DataRecord{}, DataRecord{}, err
+		}
+		if ff != nil {
+			forward.Fields = append(forward.Fields, ff)
+		}
+		if rf != nil {
+			reverse.Fields = append(reverse.Fields, rf)
+		}
+	}
+	forward.FieldCount = uint16(len(forward.Fields))
+	reverse.FieldCount = uint16(len(reverse.Fields))
+	return forward, reverse, nil
+}
+
+// MergeBiflow is the inverse of SplitBiflow and combines two uniflow records into a biflow record
+// as per RFC 5103. The biflow record carries all fields of fwd, and the fields of rev as reversed
+// fields. Flow key fields of rev are not reversed, but must match the swapped flow key of fwd,
+// otherwise MergeBiflow returns an error wrapping ErrBiflowMismatch.
+//
+// Fields of rev that cannot be reversed, i.e., non-reversible IANA IEs and enterprise-specific IEs
+// without a ReverseResolver, are omitted if fwd carries the same field, and are an error otherwise.
+func MergeBiflow(fwd, rev DataRecord) (DataRecord, error) {
+	merged := DataRecord{
+		TemplateId: fwd.TemplateId,
+		Fields:     make([]Field, 0, len(fwd.Fields)+len(rev.Fields)),
+		fieldCache: fwd.fieldCache,
+	}
+	for _, f := range fwd.Fields {
+		if f.Reversed() {
+			return DataRecord{}, fmt.Errorf("failed to merge biflow, forward record contains reversed field %s", f.Name())
+		}
+		merged.Fields = append(merged.Fields, f.Clone())
+	}
+
+	for _, f := range rev.Fields {
+		if f.Reversed() {
+			return DataRecord{}, fmt.Errorf("failed to merge biflow, reverse record contains reversed field %s", f.Name())
+		}
+		if f.PEN() == 0 {
+			keyId, isKey := f.Id(), false
+			if counterpart, ok := biflowKeyCounterparts[f.Id()]; ok {
+				keyId, isKey = counterpart, true
+			} else if _, ok := biflowSharedKeyFields[f.Id()]; ok {
+				isKey = true
+			}
+			if isKey {
+				ff, ok := fwd.FieldById(0, keyId)
+				if !ok {
+					return DataRecord{}, fmt.Errorf("failed to merge biflow, forward record is missing flow key field %d, %w", keyId, ErrBiflowMismatch)
+				}
+				if ff.Value().String() != f.Value().String() {
+					return DataRecord{}, fmt.Errorf("failed to merge biflow, flow key field %s is %s in forward and %s in reverse direction, %w", ff.Name(), ff.Value(), f.Value(), ErrBiflowMismatch)
+				}
+				continue
+			}
+		}
+		if !f.Reversible() {
+			if _, ok := fwd.FieldById(f.PEN(), f.Id()); ok {
+				continue
+			}
+			return DataRecord{}, fmt.Errorf("failed to merge biflow, field %s (%d/%d) of reverse record is not reversible", f.Name(), f.PEN(), f.Id())
+		}
+		merged.Fields = append(merged.Fields, withReversed(f, true))
+	}
+	return merged, nil
+}
+
+// withReversed returns a clone of f with the reversal state set to reversed
+func withReversed(f Field, reversed bool) Field {
+	switch cf := f.Clone().(type) {
+	case *FixedLengthField:
+		cf.reversed = reversed
+		return cf
+	case *VariableLengthField:
+		cf.reversed = reversed
+		return cf
+	default:
+		return cf
+	}
+}
+
+// withId returns a field of the IANA IE with the given id carrying a clone of the value of f
+func withId(f Field, id uint16) (Field, error) {
+	ie := LookupIE(0, id)
+	if ie == nil {
+		return nil, fmt.Errorf("unknown IANA information element %d", id)
+	}
+	return NewFieldBuilder(ie).
+		SetLength(f.Length()).
+		SetObservationDomain(f.ObservationDomainId()).
+		Complete().
+		SetValue(f.Value().Clone()), nil
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBiflow(t *testing.T) {
+	ies := iana()
+
+	field := func(id uint16, reversed bool, v any) Field {
+		ie := ies[id].Clone()
+		return NewFieldBuilder(&ie).SetReversed(reversed).Complete().SetValue(v)
+	}
+
+	start := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+	reverseStart := start.Add(20 * time.Millisecond)
+
+	// newBiflow creates a biflow record as exported by yaf, with reverse counters and timestamps,
+	// and the non-reversible flowId and biflowDirection
+	newBiflow := func(direction uint8) DataRecord {
+		return DataRecord{
+			TemplateId: 256,
+			Fields: []Field{
+				field(8, false, "192.0.2.1"),
+				field(12, false, "198.51.100.7"),
+				field(7, false, 52000),
+				field(11, false, 443),
+				field(4, false, 6),
+				field(148, false, 42),
+				field(152, false, start),
+				field(152, true, reverseStart),
+				field(1, false, 1500),
+				field(1, true, 64000),
+				field(2, false, 10),
+				field(2, true, 50),
+				field(239, false, direction),
+			},
+		}
+	}
+
+	expectFields := func(t *testing.T, dr DataRecord, expected map[FieldKey]string) {
+		t.Helper()
+		if len(dr.Fields) != len(expected) {
+			t.Errorf("expected %d fields, got %v", len(expected), dr.Fields)
+		}
+		for key, value := range expected {
+			f, ok := dr.FieldById(key.EnterpriseId, key.Id)
+			if !ok {
+				t.Errorf("expected field %d/%d", key.EnterpriseId, key.Id)
+				continue
+			}
+			if f.Value().String() != value {
+				t.Errorf("expected %s to be %s, got %s", f.Name(), value, f.Value())
+			}
+		}
+	}
+
+	forwardFields := map[FieldKey]string{
+		NewFieldKey(0, 8):   "192.0.2.1",
+		NewFieldKey(0, 12):  "198.51.100.7",
+		NewFieldKey(0, 7):   "52000",
+		NewFieldKey(0, 11):  "443",
+		NewFieldKey(0, 4):   "6",
+		NewFieldKey(0, 148): "42",
+		NewFieldKey(0, 152): field(152, false, start).Value().String(),
+		NewFieldKey(0, 1):   "1500",
+		NewFieldKey(0, 2):   "10",
+	}
+	reverseFields := map[FieldKey]string{
+		NewFieldKey(0, 8):   "198.51.100.7",
+		NewFieldKey(0, 12):  "192.0.2.1",
+		NewFieldKey(0, 7):   "443",
+		NewFieldKey(0, 11):  "52000",
+		NewFieldKey(0, 4):   "6",
+		NewFieldKey(0, 152): field(152, false, reverseStart).Value().String(),
+		NewFieldKey(0, 1):   "64000",
+		NewFieldKey(0, 2):   "50",
+	}
+
+	t.Run("split", func(t *testing.T) {
+		biflow := newBiflow(1)
+		forward, reverse, err := SplitBiflow(biflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectFields(t, forward, forwardFields)
+		expectFields(t, reverse, reverseFields)
+		for _, f := range append(forward.Fields, reverse.Fields...) {
+			if f.Reversed() {
+				t.Errorf("expected uniflow records not to contain reversed fields, got %s", f.Name())
+			}
+		}
+		if len(biflow.Fields) != 13 || !biflow.Fields[9].Reversed() {
+			t.Error("expected biflow record to be unchanged")
+		}
+	})
+
+	t.Run("split reverse initiator", func(t *testing.T) {
+		forward, reverse, err := SplitBiflow(newBiflow(biflowDirectionReverseInitiator))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectFields(t, forward, reverseFields)
+		expectFields(t, reverse, forwardFields)
+	})
+
+	t.Run("split bidirectional", func(t *testing.T) {
+		biflow := newBiflow(1)
+		forward, reverse, ok := biflow.SplitBidirectional()
+		if !ok {
+			t.Fatal("expected biflow record to be split")
+		}
+
+		common := map[FieldKey]string{
+			NewFieldKey(0, 8):   "192.0.2.1",
+			NewFieldKey(0, 12):  "198.51.100.7",
+			NewFieldKey(0, 7):   "52000",
+			NewFieldKey(0, 11):  "443",
+			NewFieldKey(0, 4):   "6",
+			NewFieldKey(0, 148): "42",
+			NewFieldKey(0, 239): "1",
+		}
+		expectedForward := map[FieldKey]string{
+			NewFieldKey(0, 152): field(152, false, start).Value().String(),
+			NewFieldKey(0, 1):   "1500",
+			NewFieldKey(0, 2):   "10",
+		}
+		expectedReverse := map[FieldKey]string{
+			NewFieldKey(0, 152): field(152, false, reverseStart).Value().String(),
+			NewFieldKey(0, 1):   "64000",
+			NewFieldKey(0, 2):   "50",
+		}
+		for k, v := range common {
+			expectedForward[k] = v
+			expectedReverse[k] = v
+		}
+		expectFields(t, *forward, expectedForward)
+		expectFields(t, *reverse, expectedReverse)
+
+		if f, ok := reverse.FieldByName(0, "octetDeltaCount"); !ok || f.Reversed() {
+			t.Errorf("expected reverse record to carry octetDeltaCount under its base name, got %v", f)
+		}
+		if int(reverse.FieldCount) != len(reverse.Fields) {
+			t.Errorf("expected field count %d, got %d", len(reverse.Fields), reverse.FieldCount)
+		}
+		if len(biflow.Fields) != 13 || !biflow.Fields[9].Reversed() {
+			t.Error("expected biflow record to be unchanged")
+		}
+	})
+
+	t.Run("split bidirectional uniflow", func(t *testing.T) {
+		uniflow := DataRecord{
+			TemplateId: 256,
+			Fields:     []Field{field(8, false, "192.0.2.1"), field(1, false, 1500)},
+		}
+		forward, reverse, ok := uniflow.SplitBidirectional()
+		if ok || forward != nil || reverse != nil {
+			t.Error("expected record without reversed fields not to be split")
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		forward, reverse, err := SplitBiflow(newBiflow(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged, err := MergeBiflow(forward, reverse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[FieldKey]string{
+			NewFieldKey(ReversePEN, 152): field(152, false, reverseStart).Value().String(),
+			NewFieldKey(ReversePEN, 1):   "64000",
+			NewFieldKey(ReversePEN, 2):   "50",
+		}
+		for k, v := range forwardFields {
+			expected[k] = v
+		}
+		expectFields(t, merged, expected)
+		if f, ok := merged.FieldByName(0, "reversedOctetDeltaCount"); !ok || !f.Reversed() {
+			t.Errorf("expected reversedOctetDeltaCount, got %v", f)
+		}
+	})
+
+	t.Run("merge mismatching flow keys", func(t *testing.T) {
+		forward, reverse, err := SplitBiflow(newBiflow(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reverse.Fields[0] = field(8, false, "203.0.113.1")
+		if _, err := MergeBiflow(forward, reverse); !errors.Is(err, ErrBiflowMismatch) {
+			t.Errorf("expected ErrBiflowMismatch, got %v", err)
+		}
+	})
+
+	t.Run("merge non-reversible fields", func(t *testing.T) {
+		forward, reverse, err := SplitBiflow(newBiflow(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// flowId is shared by both directions
+		reverse.Fields = append(reverse.Fields, field(148, false, 42))
+		merged, err := MergeBiflow(forward, reverse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := merged.FieldById(ReversePEN, 148); ok {
+			t.Error("expected flowId not to be reversed")
+		}
+
+		reverse.Fields = append(reverse.Fields, field(10, false, 3))
+		if _, err := MergeBiflow(forward, reverse); err == nil {
+			t.Error("expected error for non-reversible ingressInterface only in reverse record")
+		}
+	})
+}
+
+func TestReverseResolver(t *testing.T) {
+	ctx := context.Background()
+
+	// decodeTemplate decodes the template record b and checks that it encodes to b again, and
+	// that its length matches its encoding
+	decodeTemplate := func(t *testing.T, fieldCache FieldCache, b []byte) *TemplateRecord {
+		t.Helper()
+		tr := &TemplateRecord{fieldCache: fieldCache, templateCache: NewDefaultEphemeralCache()}
+		if _, err := tr.Decode(bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := tr.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected template record to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+		if l := int(tr.Length()); l != len(b) {
+			t.Errorf("expected template record length %d, got %d", len(b), l)
+		}
+		return tr
+	}
+
+	t.Run("IANA", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		tr := decodeTemplate(t, fieldCache, newTestTemplateRecord(256))
+
+		f := tr.Fields[1]
+		if !f.Reversed() || f.PEN() != 0 || f.Id() != 1 || f.Name() != "reversedOctetDeltaCount" {
+			t.Errorf("expected reversedOctetDeltaCount, got %s", f)
+		}
+		if !f.Reversible() {
+			t.Error("expected octetDeltaCount to be reversible")
+		}
+		if tr.Fields[2].Reversible() {
+			t.Error("expected enterprise-specific field without resolver not to be reversible")
+		}
+	})
+
+	t.Run("options template", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+		// template 257 scoped by observationDomainId with options octetDeltaCount and its reverse
+		b := []byte{0x01, 0x01, 0x00, 0x03, 0x00, 0x01, 0x00, 0x95, 0x00, 0x04, 0x00, 0x01, 0x00, 0x08}
+		b = binary.BigEndian.AppendUint16(b, 0x8000|1)
+		b = binary.BigEndian.AppendUint16(b, 8)
+		b = binary.BigEndian.AppendUint32(b, ReversePEN)
+
+		otr := &OptionsTemplateRecord{fieldCache: fieldCache, templateCache: NewDefaultEphemeralCache()}
+		if _, err := otr.Decode(bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		if f := otr.Options[1]; !f.Reversed() || f.PEN() != 0 || f.Id() != 1 {
+			t.Errorf("expected reversedOctetDeltaCount, got %s", f)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := otr.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected options template record to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+		if l := int(otr.Length()); l != len(b) {
+			t.Errorf("expected options template record length %d, got %d", len(b), l)
+		}
+	})
+
+	t.Run("basic list", func(t *testing.T) {
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+
+		// semantic ordered, reversedOctetDeltaCount, element length 8, two elements
+		b := []byte{0x04}
+		b = binary.BigEndian.AppendUint16(b, 0x8000|1)
+		b = binary.BigEndian.AppendUint16(b, 8)
+		b = binary.BigEndian.AppendUint32(b, ReversePEN)
+		b = binary.BigEndian.AppendUint64(b, 1500)
+		b = binary.BigEndian.AppendUint64(b, 40)
+
+		decoded := NewBasicList().(*BasicList).WithManager(fieldCache)().SetLength(uint16(len(b))).(*BasicList)
+		if _, err := decoded.Decode(bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := decoded.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected decoded basic list to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+
+		// a list built from reversed fields is announced by the reverse IE as well
+		ies := iana()
+		elements := make([]Field, 0, 2)
+		for _, v := range []uint64{1500, 40} {
+			ie := ies[1].Clone()
+			elements = append(elements, NewFieldBuilder(&ie).SetLength(8).SetReversed(true).Complete().SetValue(v))
+		}
+		built := NewBasicList().(*BasicList).WithManager(fieldCache)().(*BasicList).SetSemantic(SemanticOrdered)
+		built.SetValue(elements)
+		encoded.Reset()
+		if _, err := built.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), b) {
+			t.Errorf("expected built basic list to encode to\n%v, got\n%v", b, encoded.Bytes())
+		}
+		if l := int(built.Length()); l != len(b) {
+			t.Errorf("expected basic list length %d, got %d", len(b), l)
+		}
+	})
+
+	t.Run("enterprise", func(t *testing.T) {
+		// PEN 32473 is reserved for documentation by RFC 5612
+		const pen uint32 = 32473
+		RegisterReverseResolver(pen, &ReverseBitResolver{
+			PEN:           pen,
+			Bit:           0x4000,
+			NonReversible: map[uint16]struct{}{2: {}},
+		})
+		t.Cleanup(func() {
+			reverseResolversMu.Lock()
+			defer reverseResolversMu.Unlock()
+			delete(reverseResolvers, pen)
+		})
+
+		fieldCache := NewIANAFieldManager(NewDefaultEphemeralCache())
+		typ := "unsigned64"
+		for id, name := range map[uint16]string{1: "fooCount", 2: "fooId"} {
+			err := fieldCache.Add(ctx, InformationElement{Id: id, Name: name, EnterpriseId: pen, Type: &typ, Constructor: NewUnsigned64})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b := binary.BigEndian.AppendUint16(nil, 256)
+		b = binary.BigEndian.AppendUint16(b, 3)
+		for _, id := range []uint16{1, 0x4000 | 1, 2} {
+			b = binary.BigEndian.AppendUint16(b, 0x8000|id)
+			b = binary.BigEndian.AppendUint16(b, 8)
+			b = binary.BigEndian.AppendUint32(b, pen)
+		}
+		tr := decodeTemplate(t, fieldCache, b)
+
+		forward, reverse, nonReversible := tr.Fields[0], tr.Fields[1], tr.Fields[2]
+		if forward.Reversed() || !forward.Reversible() {
+			t.Errorf("expected reversible forward field, got %s", forward)
+		}
+		if !reverse.Reversed() || reverse.PEN() != pen || reverse.Id() != 1 || reverse.Name() != "reversedFooCount" {
+			t.Errorf("expected reversedFooCount, got %s", reverse)
+		}
+		if nonReversible.Reversible() {
+			t.Error("expected fooId not to be reversible")
+		}
+
+		dr := DataRecord{Fields: tr.Fields}
+		if f, ok := dr.FieldById(pen, 0x4000|1); !ok || f != reverse {
+			t.Errorf("expected reversed field to be found by its reverse IE, got %v", f)
+		}
+	})
+}
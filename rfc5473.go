@@ -0,0 +1,201 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"fmt"
+	"sync"
+)
+
+// commonPropertiesIdField is the IANA IE commonPropertiesId (0/137), which RFC 5473 uses as the scope
+// of options records that define common properties and as the reference to them in data records
+var commonPropertiesIdField = NewFieldKey(0, 137)
+
+// commonPropertiesKey identifies a set of common properties. RFC 5473 defines commonPropertiesId to be
+// unique per observation domain
+type commonPropertiesKey struct {
+	observationDomainId uint32
+	id                  uint64
+}
+
+// commonProperties are the fields of an options record defining a set of common properties, apart from
+// the commonPropertiesId scope, together with the options template they were defined by
+type commonProperties struct {
+	templateId uint16
+	fields     []Field
+}
+
+// CommonPropertiesResolver implements the reduction of redundancy in IPFIX records of RFC 5473. Exporters
+// send properties common to many flows once in options records scoped by commonPropertiesId, and data
+// records then only carry the commonPropertiesId. The resolver learns the definitions from decoded
+// messages in Observe, and Enrich joins them back into data records referencing them.
+//
+// A definition is replaced by any later options record with the same commonPropertiesId, and evicted
+// by a common properties withdrawal, i.e., an options record only consisting of the commonPropertiesId,
+// or by withdrawal of the options template it was defined by.
+//
+// The resolver is opt-in and independent of the decoder, as enriching records changes their shape.
+// It is safe for concurrent use.
+type CommonPropertiesResolver struct {
+	mu         sync.RWMutex
+	properties map[commonPropertiesKey]commonProperties
+}
+
+// NewCommonPropertiesResolver creates a resolver without any known common properties
+func NewCommonPropertiesResolver() *CommonPropertiesResolver {
+	return &CommonPropertiesResolver{
+		properties: make(map[commonPropertiesKey]commonProperties),
+	}
+}
+
+// Observe learns common properties from the options data records in msg, and evicts the ones
+// withdrawn by it. Sets are processed in order, such that a message may both withdraw and
+// redefine common properties.
+func (c *CommonPropertiesResolver) Observe(msg *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range msg.Sets {
+		switch set := s.Set.(type) {
+		case *TemplateSet:
+			for _, tr := range set.Records {
+				if tr.FieldCount == 0 {
+					c.withdrawTemplate(msg.ObservationDomainId, s.Id, tr.TemplateId)
+				}
+			}
+		case *OptionsTemplateSet:
+			for _, otr := range set.Records {
+				if otr.FieldCount == 0 {
+					c.withdrawTemplate(msg.ObservationDomainId, s.Id, otr.TemplateId)
+				}
+			}
+		case *DataSet:
+			for _, dr := range set.Records {
+				c.observe(msg.ObservationDomainId, dr)
+			}
+		}
+	}
+}
+
+// observe stores or withdraws the common properties defined by dr, if any
+func (c *CommonPropertiesResolver) observe(observationDomainId uint32, dr DataRecord) {
+	if dr.template == nil {
+		return
+	}
+	otr, ok := dr.template.Record.(*OptionsTemplateRecord)
+	if !ok || !scopedByCommonPropertiesId(otr) {
+		return
+	}
+	id, ok := commonPropertiesId(&dr)
+	if !ok {
+		return
+	}
+	key := commonPropertiesKey{observationDomainId: observationDomainId, id: id}
+
+	fields := make([]Field, 0, len(dr.Fields)-1)
+	for _, f := range dr.Fields {
+		if fieldKeyOf(f) == commonPropertiesIdField {
+			continue
+		}
+		fields = append(fields, f.Clone())
+	}
+	if len(fields) == 0 {
+		// common properties withdrawal message
+		delete(c.properties, key)
+		return
+	}
+	c.properties[key] = commonProperties{
+		templateId: otr.TemplateId,
+		fields:     fields,
+	}
+}
+
+// withdrawTemplate evicts all common properties defined by the withdrawn template. Withdrawing the
+// template id that equals the set id withdraws all (options) templates of the observation domain.
+func (c *CommonPropertiesResolver) withdrawTemplate(observationDomainId uint32, setId uint16, templateId uint16) {
+	for key, p := range c.properties {
+		if key.observationDomainId != observationDomainId {
+			continue
+		}
+		if templateId == setId || p.templateId == templateId {
+			delete(c.properties, key)
+		}
+	}
+}
+
+// Enrich appends the fields of the common properties referenced by the commonPropertiesId field of dr
+// to its fields. The observation domain is taken from the metadata of the record's template. Records
+// without a commonPropertiesId are left unchanged. If the referenced common properties are unknown,
+// Enrich returns an error wrapping ErrUnknownCommonProperties.
+//
+// Enriching a record more than once appends the properties again.
+func (c *CommonPropertiesResolver) Enrich(dr *DataRecord) error {
+	id, ok := commonPropertiesId(dr)
+	if !ok {
+		return nil
+	}
+	var observationDomainId uint32
+	if dr.template != nil && dr.template.TemplateMetadata != nil {
+		observationDomainId = dr.template.ObservationDomainId
+	}
+
+	c.mu.RLock()
+	p, ok := c.properties[commonPropertiesKey{observationDomainId: observationDomainId, id: id}]
+	c.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to enrich record of template %d with common properties %d, %w", dr.TemplateId, id, ErrUnknownCommonProperties)
+	}
+
+	for _, f := range p.fields {
+		dr.Fields = append(dr.Fields, f.Clone())
+	}
+	if dr.FieldCount != 0 {
+		dr.FieldCount += uint16(len(p.fields))
+	}
+	// invalidate the lookup index, which does not know about the appended fields
+	dr.index = nil
+	return nil
+}
+
+// scopedByCommonPropertiesId returns true if commonPropertiesId is among the scope fields of otr
+func scopedByCommonPropertiesId(otr *OptionsTemplateRecord) bool {
+	for _, f := range otr.Scopes {
+		if fieldKeyOf(f) == commonPropertiesIdField {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPropertiesId returns the value of the commonPropertiesId field of dr, if present
+func commonPropertiesId(dr *DataRecord) (uint64, bool) {
+	f, ok := dr.FieldById(commonPropertiesIdField.EnterpriseId, commonPropertiesIdField.Id)
+	if !ok || f.Value() == nil {
+		return 0, false
+	}
+	switch v := f.Value().Value().(type) {
+	case uint64:
+		return v, true
+	case uint32:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCommonPropertiesResolver(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		observationDomainId uint32 = 1
+		definitionId        uint16 = 300
+		dataId              uint16 = 301
+		withdrawalId        uint16 = 302
+	)
+
+	// newTestSetup creates caches with an options template 300 defining common properties
+	// exporterIPv4Address and observationPointId, a data template 301 referencing them next to
+	// sourceTransportPort, and an options template 302 for common properties withdrawals
+	newTestSetup := func(t *testing.T) (*Decoder, TemplateCache) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		fields := func(specs ...[2]uint16) []Field {
+			fs := make([]Field, 0, len(specs))
+			for _, spec := range specs {
+				fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, spec[0]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fs = append(fs, fb.SetLength(spec[1]).Complete())
+			}
+			return fs
+		}
+		metadata := func(id uint16) *TemplateMetadata {
+			return &TemplateMetadata{TemplateId: id, ObservationDomainId: observationDomainId, CreationTimestamp: time.Now()}
+		}
+		templates := map[uint16]*Template{
+			definitionId: {
+				TemplateMetadata: metadata(definitionId),
+				Record: &OptionsTemplateRecord{
+					TemplateId:      definitionId,
+					FieldCount:      3,
+					ScopeFieldCount: 1,
+					Scopes:          fields([2]uint16{137, 8}),
+					Options:         fields([2]uint16{130, 4}, [2]uint16{138, 4}),
+				},
+			},
+			dataId: {
+				TemplateMetadata: metadata(dataId),
+				Record: &TemplateRecord{
+					TemplateId: dataId,
+					FieldCount: 2,
+					Fields:     fields([2]uint16{137, 8}, [2]uint16{7, 2}),
+				},
+			},
+			withdrawalId: {
+				TemplateMetadata: metadata(withdrawalId),
+				Record: &OptionsTemplateRecord{
+					TemplateId:      withdrawalId,
+					FieldCount:      1,
+					ScopeFieldCount: 1,
+					Scopes:          fields([2]uint16{137, 8}),
+				},
+			},
+		}
+		for id, template := range templates {
+			if err := templateCache.Add(ctx, NewKey(observationDomainId, id), template); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return NewDecoder(templateCache, fieldCache), templateCache
+	}
+
+	// newMessage creates a message of the given sets, each being a set id followed by the set's records
+	newMessage := func(sets ...[]byte) []byte {
+		body := make([]byte, 0)
+		for _, s := range sets {
+			body = binary.BigEndian.AppendUint16(body, binary.BigEndian.Uint16(s[:2]))
+			body = binary.BigEndian.AppendUint16(body, uint16(len(s)+2))
+			body = append(body, s[2:]...)
+		}
+		b := make([]byte, 0, 16+len(body))
+		b = binary.BigEndian.AppendUint16(b, 10)
+		b = binary.BigEndian.AppendUint16(b, uint16(16+len(body)))
+		b = binary.BigEndian.AppendUint32(b, uint32(time.Now().Unix()))
+		b = binary.BigEndian.AppendUint32(b, 0)
+		b = binary.BigEndian.AppendUint32(b, observationDomainId)
+		return append(b, body...)
+	}
+	definition := func(id uint64, exporter net.IP, observationPoint uint32) []byte {
+		s := binary.BigEndian.AppendUint16(nil, definitionId)
+		s = binary.BigEndian.AppendUint64(s, id)
+		s = append(s, exporter.To4()...)
+		return binary.BigEndian.AppendUint32(s, observationPoint)
+	}
+	references := func(ids ...uint64) []byte {
+		s := binary.BigEndian.AppendUint16(nil, dataId)
+		for i, id := range ids {
+			s = binary.BigEndian.AppendUint64(s, id)
+			s = binary.BigEndian.AppendUint16(s, uint16(4739+i))
+		}
+		return s
+	}
+	withdrawal := func(id uint64) []byte {
+		s := binary.BigEndian.AppendUint16(nil, withdrawalId)
+		return binary.BigEndian.AppendUint64(s, id)
+	}
+
+	decode := func(t *testing.T, d *Decoder, b []byte) *Message {
+		msg, err := d.Decode(ctx, bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	referencingRecords := func(msg *Message) []DataRecord {
+		records := make([]DataRecord, 0)
+		for _, s := range msg.Sets {
+			if s.Id == dataId {
+				records = append(records, s.Set.(*DataSet).Records...)
+			}
+		}
+		return records
+	}
+	expectProperties := func(t *testing.T, dr DataRecord, exporter string, observationPoint uint32) {
+		t.Helper()
+		if len(dr.Fields) != 4 {
+			t.Fatalf("expected record to be enriched to 4 fields, got %v", dr.Fields)
+		}
+		if ip, ok := dr.IP("exporterIPv4Address"); !ok || ip.String() != exporter {
+			t.Errorf("expected exporterIPv4Address %s, got %v", exporter, ip)
+		}
+		if v, ok := dr.Uint64("observationPointId"); !ok || v != uint64(observationPoint) {
+			t.Errorf("expected observationPointId %d, got %d", observationPoint, v)
+		}
+	}
+
+	t.Run("enriches referencing records", func(t *testing.T) {
+		d, _ := newTestSetup(t)
+		r := NewCommonPropertiesResolver()
+
+		msg := decode(t, d, newMessage(definition(1, net.ParseIP("192.0.2.1"), 5), references(1, 1, 1)))
+		r.Observe(msg)
+
+		records := referencingRecords(msg)
+		if len(records) != 3 {
+			t.Fatalf("expected 3 referencing records, got %d", len(records))
+		}
+		for i := range records {
+			if err := r.Enrich(&records[i]); err != nil {
+				t.Fatal(err)
+			}
+			expectProperties(t, records[i], "192.0.2.1", 5)
+			if port, ok := records[i].Uint64("sourceTransportPort"); !ok || port != uint64(4739+i) {
+				t.Errorf("expected record's own fields to be retained, got port %d", port)
+			}
+		}
+	})
+
+	t.Run("records without reference are unchanged", func(t *testing.T) {
+		d, templateCache := newTestSetup(t)
+		r := NewCommonPropertiesResolver()
+		r.Observe(decode(t, d, newMessage(definition(1, net.ParseIP("192.0.2.1"), 5))))
+
+		template := newTestTemplate(t, NewIANAFieldManager(templateCache), 256)
+		if err := templateCache.Add(ctx, NewKey(0, 256), template); err != nil {
+			t.Fatal(err)
+		}
+		msg := decode(t, d, newTestDataMessage(256, 1))
+		dr := msg.Sets[0].Set.(*DataSet).Records[0]
+		if err := r.Enrich(&dr); err != nil {
+			t.Fatal(err)
+		}
+		if len(dr.Fields) != 2 {
+			t.Errorf("expected record without commonPropertiesId to be unchanged, got %v", dr.Fields)
+		}
+	})
+
+	t.Run("redefinition replaces properties", func(t *testing.T) {
+		d, _ := newTestSetup(t)
+		r := NewCommonPropertiesResolver()
+		r.Observe(decode(t, d, newMessage(definition(1, net.ParseIP("192.0.2.1"), 5))))
+
+		msg := decode(t, d, newMessage(definition(1, net.ParseIP("198.51.100.1"), 6), references(1)))
+		r.Observe(msg)
+		dr := referencingRecords(msg)[0]
+		if err := r.Enrich(&dr); err != nil {
+			t.Fatal(err)
+		}
+		expectProperties(t, dr, "198.51.100.1", 6)
+	})
+
+	t.Run("withdrawal evicts properties", func(t *testing.T) {
+		d, _ := newTestSetup(t)
+		r := NewCommonPropertiesResolver()
+		r.Observe(decode(t, d, newMessage(definition(1, net.ParseIP("192.0.2.1"), 5), definition(2, net.ParseIP("192.0.2.2"), 7))))
+
+		msg := decode(t, d, newMessage(withdrawal(1), references(1, 2)))
+		r.Observe(msg)
+		records := referencingRecords(msg)
+		if err := r.Enrich(&records[0]); !errors.Is(err, ErrUnknownCommonProperties) {
+			t.Errorf("expected ErrUnknownCommonProperties for withdrawn properties, got %v", err)
+		}
+		if err := r.Enrich(&records[1]); err != nil {
+			t.Fatal(err)
+		}
+		expectProperties(t, records[1], "192.0.2.2", 7)
+	})
+
+	t.Run("template withdrawal evicts properties", func(t *testing.T) {
+		d, _ := newTestSetup(t)
+		r := NewCommonPropertiesResolver()
+		r.Observe(decode(t, d, newMessage(definition(1, net.ParseIP("192.0.2.1"), 5))))
+
+		r.Observe(&Message{
+			ObservationDomainId: observationDomainId,
+			Sets: []Set{{
+				SetHeader: SetHeader{Id: 3},
+				Kind:      KindOptionsTemplateSet,
+				Set:       &OptionsTemplateSet{Records: []OptionsTemplateRecord{{TemplateId: definitionId}}},
+			}},
+		})
+		msg := decode(t, d, newMessage(references(1)))
+		dr := referencingRecords(msg)[0]
+		if err := r.Enrich(&dr); !errors.Is(err, ErrUnknownCommonProperties) {
+			t.Errorf("expected ErrUnknownCommonProperties after template withdrawal, got %v", err)
+		}
+	})
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
 	"github.com/zoomoid/go-ipfix/iana/semantics"
@@ -46,6 +48,21 @@ func definesInformationElement(dr DataRecord) bool {
 	return idField != nil && nameField != nil
 }
 
+// templateDefinesInformationElements returns true if records of t define information elements,
+// i.e., if decoding them adds IEs to the field cache
+func templateDefinesInformationElements(t *Template) bool {
+	switch r := t.Record.(type) {
+	case *TemplateRecord:
+		return definesInformationElement(DataRecord{Fields: r.Fields})
+	case *OptionsTemplateRecord:
+		fields := make([]Field, 0, len(r.Scopes)+len(r.Options))
+		fields = append(fields, r.Scopes...)
+		fields = append(fields, r.Options...)
+		return definesInformationElement(DataRecord{Fields: fields})
+	}
+	return false
+}
+
 // dataRecordToIE converts a data record containing (new) Information Elements to learn
 // to IE objects to be added to field caches. This implements the learning mechanism of RFC 5610
 // If the data record defines new information elements (RFC 5610), add them to FieldManager
@@ -124,7 +141,14 @@ func dataRecordToIE(dr DataRecord) (*InformationElement, error) {
 		if !ok {
 			return nil, fmt.Errorf("'informationElementDataType' field is not of type Unsigned8, cannot use field for deriving new IE")
 		}
-		dtc := DataTypeFromNumber(dt.Value().(uint8))
+		dtc, err := DataTypeFromNumber(dt.Value().(uint8))
+		if err != nil {
+			// data types are announced over the network, so an exporter knowing more data types than
+			// we do must not prevent learning the IE. Fall back to raw bytes instead.
+			FromContext(context.TODO()).Info("information element announces an unknown data type, falling back to octetArray",
+				"enterpriseId", ie.EnterpriseId, "id", ie.Id, "name", ie.Name, "error", err.Error())
+			dtc = NewOctetArray
+		}
 		typ := dtc().Type()
 		ie.Type = &typ
 		ie.Constructor = dtc
@@ -179,3 +203,183 @@ func dataRecordToIE(dr DataRecord) (*InformationElement, error) {
 	// logger.V(4).Info("created new information element from data record", "ie", ie.String())
 	return ie, nil
 }
+
+// informationElementTemplateScopes and informationElementTemplateOptions are the IANA IEs and their
+// lengths of the options template announcing Information Element definitions as per RFC 5610, Section 3.9,
+// in the order described at dataRecordToIE.
+var (
+	informationElementTemplateScopes = []struct {
+		id     uint16
+		length uint16
+	}{
+		{346, 4}, // privateEnterpriseNumber
+		{303, 2}, // informationElementId
+	}
+	informationElementTemplateOptions = []struct {
+		id     uint16
+		length uint16
+	}{
+		{339, 1},              // informationElementDataType
+		{344, 1},              // informationElementSemantics
+		{345, 2},              // informationElementUnits
+		{342, 8},              // informationElementRangeBegin
+		{343, 8},              // informationElementRangeEnd
+		{341, VariableLength}, // informationElementName
+		{340, VariableLength}, // informationElementDescription
+	}
+)
+
+// NewInformationElementTemplateRecord creates the options template record with the given id that
+// exporters use for announcing Information Element definitions as per RFC 5610, i.e., scoped by
+// privateEnterpriseNumber and informationElementId and carrying dataType, semantics, units, range, name,
+// and description of the IE. Data records of the template are created with NewInformationElementRecords.
+func NewInformationElementTemplateRecord(templateId uint16) *OptionsTemplateRecord {
+	fields := func(specs []struct {
+		id     uint16
+		length uint16
+	}) []Field {
+		fs := make([]Field, 0, len(specs))
+		for _, spec := range specs {
+			ie := iana()[spec.id].Clone()
+			fs = append(fs, NewFieldBuilder(&ie).SetLength(spec.length).Complete())
+		}
+		return fs
+	}
+	scopes := fields(informationElementTemplateScopes)
+	options := fields(informationElementTemplateOptions)
+	return &OptionsTemplateRecord{
+		TemplateId:      templateId,
+		FieldCount:      uint16(len(scopes) + len(options)),
+		ScopeFieldCount: uint16(len(scopes)),
+		Scopes:          scopes,
+		Options:         options,
+	}
+}
+
+// NewInformationElementRecords creates a data record of the options template created by
+// NewInformationElementTemplateRecord for each of the ies. The data type of an IE is taken from its
+// Type, or, if unset, from its Constructor. Units and ranges that are not set are encoded as
+// "none" and [0,0], respectively, and a missing description as the empty string.
+func NewInformationElementRecords(templateId uint16, ies ...InformationElement) ([]DataRecord, error) {
+	otr := NewInformationElementTemplateRecord(templateId)
+	template := &Template{
+		TemplateMetadata: &TemplateMetadata{
+			TemplateId: templateId,
+		},
+		Record: otr,
+	}
+
+	records := make([]DataRecord, 0, len(ies))
+	for _, ie := range ies {
+		values, err := informationElementValues(ie)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create record for information element (%d,%d), %w", ie.EnterpriseId, ie.Id, err)
+		}
+
+		fields := make([]Field, 0, otr.FieldCount)
+		for _, tf := range append(otr.Scopes, otr.Options...) {
+			fields = append(fields, tf.Clone().SetValue(values[len(fields)]))
+		}
+		dr := DataRecord{
+			TemplateId: templateId,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		}
+		records = append(records, *dr.With(template))
+	}
+	return records, nil
+}
+
+// informationElementValues returns the values of the fields of a data record defining ie in the
+// order of the fields of NewInformationElementTemplateRecord
+func informationElementValues(ie InformationElement) ([]any, error) {
+	var typ string
+	if ie.Type != nil {
+		typ = *ie.Type
+	} else if ie.Constructor != nil {
+		typ = ie.Constructor().Type()
+	} else {
+		return nil, fmt.Errorf("information element has neither type nor constructor")
+	}
+	dataType, err := dataTypeToNumber(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := uint16(0)
+	if ie.Units != nil {
+		u, ok := units.ToNumber(*ie.Units)
+		if !ok {
+			return nil, fmt.Errorf("unit %s is not assigned", *ie.Units)
+		}
+		unit = u
+	}
+
+	var rangeBegin, rangeEnd uint64
+	if ie.Range != nil {
+		// see dataRecordToIE for the conversion from unsigned64
+		rangeBegin = uint64(ie.Range.Low)
+		rangeEnd = uint64(ie.Range.High)
+	}
+
+	var description string
+	if ie.Description != nil {
+		description = *ie.Description
+	}
+
+	return []any{
+		ie.EnterpriseId,
+		ie.Id,
+		dataType,
+		semantics.ToNumber(ie.Semantics),
+		unit,
+		rangeBegin,
+		rangeEnd,
+		ie.Name,
+		description,
+	}, nil
+}
+
+// InformationElementSets creates the sets for announcing the ies to a collector as per RFC 5610, i.e.,
+// an options template set containing the template created by NewInformationElementTemplateRecord,
+// followed by a data set of the records created by NewInformationElementRecords. The sets are ready
+// to be appended to Message.Sets, note that Message.Length is not updated by this.
+func InformationElementSets(templateId uint16, ies ...InformationElement) ([]Set, error) {
+	records, err := NewInformationElementRecords(templateId, ies...)
+	if err != nil {
+		return nil, err
+	}
+	otr := NewInformationElementTemplateRecord(templateId)
+
+	templateSet := &OptionsTemplateSet{
+		Records: []OptionsTemplateRecord{*otr},
+	}
+	dataSet := &DataSet{
+		Records: records,
+	}
+
+	sets := make([]Set, 0, 2)
+	for _, s := range []struct {
+		id   uint16
+		kind string
+		set  set
+	}{
+		{IPFIXOptions, KindOptionsTemplateSet, templateSet},
+		{templateId, KindDataSet, dataSet},
+	} {
+		b := &bytes.Buffer{}
+		_, err := s.set.Encode(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s of information elements, %w", s.kind, err)
+		}
+		sets = append(sets, Set{
+			SetHeader: SetHeader{
+				Id:     s.id,
+				Length: uint16(setHeaderLength + b.Len()),
+			},
+			Kind: s.kind,
+			Set:  s.set,
+		})
+	}
+	return sets, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/zoomoid/go-ipfix/iana/semantics"
+	"github.com/zoomoid/go-ipfix/iana/units"
+)
+
+func TestInformationElementSets(t *testing.T) {
+	ctx := context.Background()
+
+	const templateId uint16 = 400
+
+	description := "number of foos observed"
+	typ := "unsigned64"
+	unit := units.Packets
+	ies := []InformationElement{
+		{
+			Id:           1000,
+			Name:         "fooCount",
+			EnterpriseId: 12345,
+			Type:         &typ,
+			Semantics:    semantics.TotalCounter,
+			Units:        &unit,
+			Description:  &description,
+			Range:        &InformationElementRange{Low: 1, High: 100},
+		},
+		{
+			Id:           1001,
+			Name:         "barName",
+			EnterpriseId: 12345,
+			Constructor:  NewString,
+			Semantics:    semantics.Default,
+		},
+	}
+
+	t.Run("round trip through decoder", func(t *testing.T) {
+		sets, err := InformationElementSets(templateId, ies...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sets) != 2 {
+			t.Fatalf("expected 2 sets, got %d", len(sets))
+		}
+		if sets[0].Id != IPFIXOptions || sets[1].Id != templateId {
+			t.Fatalf("expected sets %d and %d, got %d and %d", IPFIXOptions, templateId, sets[0].Id, sets[1].Id)
+		}
+
+		msg := &Message{
+			Version: 10,
+			Length:  uint16(messageHeaderLength),
+			Sets:    sets,
+		}
+		for _, s := range sets {
+			msg.Length += s.Length
+		}
+		b := &bytes.Buffer{}
+		if _, err := msg.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Len() != int(msg.Length) {
+			t.Fatalf("expected message of %d bytes, got %d", msg.Length, b.Len())
+		}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		// TODO(zoomoid): remove once options template sets add their records to the template cache when decoding
+		err = templateCache.Add(ctx, NewKey(0, templateId), &Template{
+			TemplateMetadata: &TemplateMetadata{TemplateId: templateId},
+			Record:           NewInformationElementTemplateRecord(templateId),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewDecoder(templateCache, fieldCache).Decode(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+
+		foo, err := fieldCache.Get(ctx, NewFieldKey(12345, 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if foo.Name != "fooCount" || *foo.Type != "unsigned64" || foo.Semantics != semantics.TotalCounter {
+			t.Errorf("unexpected definition of fooCount, got %s", foo)
+		}
+		if foo.Units == nil || *foo.Units != units.Packets {
+			t.Errorf("expected units %s, got %v", units.Packets, foo.Units)
+		}
+		if foo.Range == nil || foo.Range.Low != 1 || foo.Range.High != 100 {
+			t.Errorf("expected range [1,100], got %v", foo.Range)
+		}
+		if foo.Description == nil || *foo.Description != description {
+			t.Errorf("expected description %q, got %v", description, foo.Description)
+		}
+
+		bar, err := fieldCache.Get(ctx, NewFieldKey(12345, 1001))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bar.Name != "barName" || *bar.Type != "string" {
+			t.Errorf("unexpected definition of barName, got %s", bar)
+		}
+	})
+
+	t.Run("unknown data type number falls back to octetArray", func(t *testing.T) {
+		sets, err := InformationElementSets(templateId, ies[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// announce a data type number that is not assigned (yet)
+		sets[1].Set.(*DataSet).Records[0].Fields[2].SetValue(uint8(251))
+
+		msg := &Message{
+			Version: 10,
+			Length:  uint16(messageHeaderLength),
+			Sets:    sets,
+		}
+		for _, s := range sets {
+			msg.Length += s.Length
+		}
+		b := &bytes.Buffer{}
+		if _, err := msg.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		if _, err := NewDecoder(templateCache, fieldCache).Decode(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		foo, err := fieldCache.Get(ctx, NewFieldKey(12345, 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if foo.Name != "fooCount" || foo.Type == nil || *foo.Type != "octetArray" {
+			t.Errorf("expected fooCount of type octetArray, got %s", foo)
+		}
+	})
+
+	t.Run("information element without type", func(t *testing.T) {
+		_, err := NewInformationElementRecords(templateId, InformationElement{Id: 1, Name: "untyped"})
+		if err == nil {
+			t.Fatal("expected error for information element without type")
+		}
+	})
+
+	t.Run("unknown units", func(t *testing.T) {
+		u := "furlongs"
+		_, err := NewInformationElementRecords(templateId, InformationElement{Id: 1, Name: "distance", Type: &typ, Units: &u})
+		if err == nil {
+			t.Fatal("expected error for unknown units")
+		}
+	})
+}
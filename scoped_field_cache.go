@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ScopedFieldCache decorates a FieldCache such that information elements learned from RFC 5610
+// options data are kept per observation domain. Exporters may announce conflicting definitions
+// of the same (pen, id), e.g., in lab setups using PEN 0 or a placeholder PEN, and without scoping
+// the definition learned last is used for all observation domains.
+//
+// The wrapped cache holds the statically registered elements, e.g., the ones assigned by IANA, and
+// elements added to the ScopedFieldCache directly. Both are shared by all observation domains.
+// A Decoder using a ScopedFieldCache decodes each message with the view returned by
+// ForObservationDomain for the message's observation domain, which adds learned elements to the
+// observation domain only.
+//
+// To scope learned elements per exporter as well, use a ScopedFieldCache per exporter wrapping the
+// same shared cache, e.g., in CollectorOptions.FieldCache.
+type ScopedFieldCache struct {
+	FieldCache
+
+	mu     sync.Mutex
+	scopes map[uint32]*observationDomainFieldCache
+}
+
+var _ FieldCache = &ScopedFieldCache{}
+
+// NewScopedFieldCache wraps shared such that learned information elements are kept per
+// observation domain
+func NewScopedFieldCache(shared FieldCache) *ScopedFieldCache {
+	return &ScopedFieldCache{
+		FieldCache: shared,
+		scopes:     make(map[uint32]*observationDomainFieldCache),
+	}
+}
+
+// ForObservationDomain returns the view of the cache for an observation domain. Lookups of the
+// view return the elements learned in the observation domain first, and fall back to the shared
+// cache otherwise. Add and Delete of the view only modify the learned elements of the observation
+// domain.
+func (c *ScopedFieldCache) ForObservationDomain(observationDomainId uint32) FieldCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scope, ok := c.scopes[observationDomainId]
+	if !ok {
+		scope = &observationDomainFieldCache{
+			shared:  c.FieldCache,
+			learned: newEphemeralFieldCache(nil),
+		}
+		c.scopes[observationDomainId] = scope
+	}
+	return scope
+}
+
+// observationDomainScoper is implemented by field caches that keep fields per observation domain,
+// such that a Decoder can use the fields of the observation domain of the message it decodes
+type observationDomainScoper interface {
+	ForObservationDomain(observationDomainId uint32) FieldCache
+}
+
+var _ observationDomainScoper = &ScopedFieldCache{}
+
+// observationDomainFieldCache is the view of a ScopedFieldCache for a single observation domain
+type observationDomainFieldCache struct {
+	shared FieldCache
+
+	// learned contains the elements added to the view
+	learned *EphemeralFieldCache
+}
+
+var _ FieldCache = &observationDomainFieldCache{}
+
+func (c *observationDomainFieldCache) GetBuilder(ctx context.Context, key FieldKey) (*FieldBuilder, error) {
+	b, err := c.learned.GetBuilder(ctx, key)
+	if err == nil && b != nil && !b.IsUnknown() {
+		return b, nil
+	}
+	return c.shared.GetBuilder(ctx, key)
+}
+
+func (c *observationDomainFieldCache) Get(ctx context.Context, key FieldKey) (*InformationElement, error) {
+	ie, err := c.learned.Get(ctx, key)
+	if err == nil {
+		return ie, nil
+	}
+	if !errors.Is(err, ErrFieldNotFound) {
+		return nil, err
+	}
+	return c.shared.Get(ctx, key)
+}
+
+func (c *observationDomainFieldCache) Add(ctx context.Context, ie InformationElement) error {
+	return c.learned.Add(ctx, ie)
+}
+
+func (c *observationDomainFieldCache) Delete(ctx context.Context, key FieldKey) error {
+	return c.learned.Delete(ctx, key)
+}
+
+// GetAllBuilders returns the builders of the shared cache and the learned elements, where learned
+// elements take precedence
+func (c *observationDomainFieldCache) GetAllBuilders(ctx context.Context) map[FieldKey]*FieldBuilder {
+	builders := c.shared.GetAllBuilders(ctx)
+	mm := make(map[FieldKey]*FieldBuilder, len(builders))
+	for k, v := range builders {
+		mm[k] = v
+	}
+	for k, v := range c.learned.GetAllBuilders(ctx) {
+		mm[k] = v
+	}
+	return mm
+}
+
+// GetAll returns the information elements of the shared cache and the learned elements, where
+// learned elements take precedence
+func (c *observationDomainFieldCache) GetAll(ctx context.Context) map[FieldKey]*InformationElement {
+	elements := c.shared.GetAll(ctx)
+	mm := make(map[FieldKey]*InformationElement, len(elements))
+	for k, v := range elements {
+		mm[k] = v
+	}
+	for k, v := range c.learned.GetAll(ctx) {
+		mm[k] = v
+	}
+	return mm
+}
+
+func (c *observationDomainFieldCache) MarshalJSON() ([]byte, error) {
+	return marshalSortedJSON(c.GetAllBuilders(context.TODO()), fieldKeyLess, fieldKeyString)
+}
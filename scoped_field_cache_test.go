@@ -0,0 +1,133 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestScopedFieldCache(t *testing.T) {
+	ctx := context.Background()
+
+	// PEN 32473 is reserved for documentation by RFC 5612
+	const pen uint32 = 32473
+	const templateId uint16 = 400
+
+	// learn defines the IE (pen, 1) as typ by RFC 5610 options data in the observation domain
+	learn := func(t *testing.T, decoder *Decoder, observationDomainId uint32, constructor DataTypeConstructor) {
+		sets, err := InformationElementSets(templateId, InformationElement{
+			Id:           1,
+			Name:         "fooValue",
+			EnterpriseId: pen,
+			Constructor:  constructor,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &Message{
+			Version:             10,
+			Length:              uint16(messageHeaderLength),
+			ObservationDomainId: observationDomainId,
+			Sets:                sets,
+		}
+		for _, s := range sets {
+			msg.Length += s.Length
+		}
+		b := &bytes.Buffer{}
+		if _, err := msg.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decoder.Decode(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// decodeValue decodes a data record of template 300 containing the 4 bytes "abcd" as (pen, 1)
+	decodeValue := func(t *testing.T, decoder *Decoder, observationDomainId uint32) Field {
+		template := binary.BigEndian.AppendUint16(nil, 300)
+		template = binary.BigEndian.AppendUint16(template, 1)
+		template = binary.BigEndian.AppendUint16(template, 0x8000|1)
+		template = binary.BigEndian.AppendUint16(template, 4)
+		template = binary.BigEndian.AppendUint32(template, pen)
+		if _, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(0, observationDomainId, IPFIX, template))); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(0, observationDomainId, 300, []byte("abcd"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.Sets[0].Set.(*DataSet).Records[0].Fields[0]
+	}
+
+	t.Run("learned elements are scoped per observation domain", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		shared := NewIANAFieldCache(templateCache)
+		fieldCache := NewScopedFieldCache(shared)
+		decoder := NewDecoder(templateCache, fieldCache)
+
+		learn(t, decoder, 1, NewUnsigned32)
+		learn(t, decoder, 2, NewString)
+
+		if f := decodeValue(t, decoder, 1); f.Name() != "fooValue" || f.Value().Value() != uint32(0x61626364) {
+			t.Errorf("expected fooValue to be unsigned32 in observation domain 1, got %s", f)
+		}
+		if f := decodeValue(t, decoder, 2); f.Name() != "fooValue" || f.Value().Value() != "abcd" {
+			t.Errorf("expected fooValue to be a string in observation domain 2, got %s", f)
+		}
+		if f := decodeValue(t, decoder, 3); f.Name() == "fooValue" || f.Value().Type() != "octetArray" {
+			t.Errorf("expected fooValue to be unknown in observation domain 3, got %s", f)
+		}
+
+		// learned elements are not added to the shared cache
+		if _, err := shared.Get(ctx, NewFieldKey(pen, 1)); err == nil {
+			t.Error("expected learned element not to be added to the shared cache")
+		}
+	})
+
+	t.Run("shared elements are visible in all observation domains", func(t *testing.T) {
+		fieldCache := NewScopedFieldCache(NewIANAFieldCache(NewDefaultEphemeralCache()))
+		typ := "unsigned64"
+		if err := fieldCache.Add(ctx, InformationElement{Id: 2, Name: "fooCount", EnterpriseId: pen, Type: &typ, Constructor: NewUnsigned64}); err != nil {
+			t.Fatal(err)
+		}
+
+		scope := fieldCache.ForObservationDomain(1)
+		if scope != fieldCache.ForObservationDomain(1) {
+			t.Error("expected the same view for the same observation domain")
+		}
+		for _, key := range []FieldKey{NewFieldKey(0, 8), NewFieldKey(pen, 2)} {
+			ie, err := scope.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ie.Id != key.Id || ie.EnterpriseId != key.EnterpriseId {
+				t.Errorf("expected element %s, got %s", key.String(), ie)
+			}
+			if b, err := scope.GetBuilder(ctx, key); err != nil || b.IsUnknown() {
+				t.Errorf("expected builder of element %s, got %v", key.String(), err)
+			}
+		}
+
+		// learned elements take precedence over shared ones in their observation domain only
+		if err := scope.Add(ctx, InformationElement{Id: 2, Name: "fooName", EnterpriseId: pen, Constructor: NewString}); err != nil {
+			t.Fatal(err)
+		}
+		if ie, _ := scope.Get(ctx, NewFieldKey(pen, 2)); ie == nil || ie.Name != "fooName" {
+			t.Errorf("expected learned element fooName, got %v", ie)
+		}
+		if ie, _ := fieldCache.ForObservationDomain(2).Get(ctx, NewFieldKey(pen, 2)); ie == nil || ie.Name != "fooCount" {
+			t.Errorf("expected shared element fooCount, got %v", ie)
+		}
+		if all := scope.GetAll(ctx); all[NewFieldKey(pen, 2)].Name != "fooName" || all[NewFieldKey(0, 8)] == nil {
+			t.Error("expected learned and shared elements in GetAll")
+		}
+
+		if err := scope.Delete(ctx, NewFieldKey(pen, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if ie, _ := scope.Get(ctx, NewFieldKey(pen, 2)); ie == nil || ie.Name != "fooCount" {
+			t.Errorf("expected shared element fooCount after deleting the learned one, got %v", ie)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"sync"
+)
+
+// SequenceEventKind distinguishes the events reported by a SequenceTracker
+type SequenceEventKind int
+
+const (
+	// SequenceGap is reported for data records that were declared lost
+	SequenceGap SequenceEventKind = iota
+	// SequenceReset is reported when an exporter restarted its sequence numbers, e.g., after
+	// restarting the exporting process
+	SequenceReset
+)
+
+func (k SequenceEventKind) String() string {
+	switch k {
+	case SequenceGap:
+		return "gap"
+	case SequenceReset:
+		return "reset"
+	default:
+		return "unknown"
+	}
+}
+
+// SequenceEvent is reported by a SequenceTracker for lost records and exporter restarts
+type SequenceEvent struct {
+	Kind SequenceEventKind
+
+	Exporter            string
+	ObservationDomainId uint32
+
+	// Expected is the sequence number of the first lost record for gaps, and the sequence
+	// number expected before the restart for resets
+	Expected uint32
+	// Received is the sequence number of the first record received after the gap for gaps,
+	// and the sequence number the exporter restarted with for resets
+	Received uint32
+	// Lost is the number of lost records of a gap
+	Lost uint32
+}
+
+// sequenceKey identifies the sequence numbers of an observation domain of an exporter
+type sequenceKey struct {
+	exporter            string
+	observationDomainId uint32
+}
+
+// sequenceRange is a range of sequence numbers not received yet
+type sequenceRange struct {
+	start  uint32
+	length uint32
+}
+
+// sequenceState is the state of the sequence numbers of a single observation domain
+type sequenceState struct {
+	// next is the sequence number expected for the next message
+	next uint32
+	// synced is false before the first message, and after messages whose number of data records
+	// is not known
+	synced bool
+	// missing are the ranges of sequence numbers skipped by messages, which are declared lost
+	// once they fall out of the reorder window
+	missing []sequenceRange
+	// restart is the sequence number of a message that was behind by more than the reorder window,
+	// and restartNext the sequence number expected after it. The message is confirmed as the first
+	// message after an exporter restart if the next message continues it.
+	restart     uint32
+	restartNext uint32
+	restarting  bool
+}
+
+// SequenceTracker tracks the sequence numbers of messages per exporter and observation domain, as
+// defined in RFC 7011, to detect lost data records and exporter restarts. Sequence numbers count
+// data records only, template and options template records do not increment them.
+//
+// Messages skipping sequence numbers open a gap that is filled by late messages arriving within
+// the reorder window. Gaps falling out of the window are reported as lost. Messages that are
+// behind by more than the window are considered an exporter restart, if the following message
+// continues the restarted sequence. Otherwise, they are ignored as late messages of gaps that
+// were already reported lost.
+//
+// Feed a tracker either with decoded messages using Observe, or let a decoder feed it by creating
+// the decoder with WithSequenceTracking. A SequenceTracker is safe for concurrent use.
+type SequenceTracker struct {
+	mu     sync.Mutex
+	states map[sequenceKey]*sequenceState
+
+	// window is the number of records by which messages may be reordered before gaps are
+	// declared lost
+	window uint32
+
+	callback func(SequenceEvent)
+
+	collectors *Metrics
+	listener   string
+}
+
+// SequenceTrackerOption configures a SequenceTracker created with NewSequenceTracker
+type SequenceTrackerOption func(*SequenceTracker)
+
+// WithReorderWindow tolerates reordering of messages by up to records data records before gaps
+// are declared lost. By default, gaps are declared lost immediately.
+func WithReorderWindow(records uint32) SequenceTrackerOption {
+	return func(t *SequenceTracker) {
+		t.window = records
+	}
+}
+
+// WithSequenceCallback sets a function called for each lost gap and exporter restart. The function
+// is called synchronously by Observe, or by the decoder feeding the tracker.
+func WithSequenceCallback(callback func(SequenceEvent)) SequenceTrackerOption {
+	return func(t *SequenceTracker) {
+		t.callback = callback
+	}
+}
+
+// WithSequenceMetrics makes the tracker count lost records and exporter restarts in the given
+// metrics instead of the deprecated package-level collectors. listener is used as the label value,
+// like for Decoder.WithMetrics.
+func WithSequenceMetrics(m *Metrics, listener string) SequenceTrackerOption {
+	return func(t *SequenceTracker) {
+		t.collectors = m
+		t.listener = listener
+	}
+}
+
+// NewSequenceTracker creates a tracker without any known exporters
+func NewSequenceTracker(opts ...SequenceTrackerOption) *SequenceTracker {
+	t := &SequenceTracker{
+		states: make(map[sequenceKey]*sequenceState),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Observe tracks the sequence number of msg received from exporter, which distinguishes exporters
+// using the same observation domain ids, e.g., by their address. The number of data records of
+// msg is the number of records of its data sets.
+func (t *SequenceTracker) Observe(exporter string, msg *Message) {
+	records := 0
+	for _, s := range msg.Sets {
+		if ds, ok := s.Set.(*DataSet); ok {
+			records += len(ds.Records)
+		}
+	}
+	t.observe(exporter, msg.ObservationDomainId, msg.SequenceNumber, uint32(records), true)
+}
+
+// observe tracks a message with sequence number seq carrying the given number of data records. If
+// exact is false, the number of records is not known, e.g., because data sets were skipped, and
+// the tracker synchronizes to the next message.
+func (t *SequenceTracker) observe(exporter string, observationDomainId uint32, seq uint32, records uint32, exact bool) {
+	events := t.track(sequenceKey{exporter: exporter, observationDomainId: observationDomainId}, seq, records, exact)
+	// report outside of the lock, such that callbacks may use the tracker
+	for _, e := range events {
+		e.Exporter = exporter
+		e.ObservationDomainId = observationDomainId
+		t.report(e)
+	}
+}
+
+// track updates the state of key with a message and returns the resulting events
+func (t *SequenceTracker) track(key sequenceKey, seq uint32, records uint32, exact bool) (events []SequenceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[key]
+	if !ok {
+		s = &sequenceState{}
+		t.states[key] = s
+	}
+	if !exact {
+		defer func() {
+			s.synced = false
+			s.missing = nil
+			s.restarting = false
+		}()
+	}
+
+	if !s.synced {
+		s.next = seq + records
+		s.synced = true
+		return nil
+	}
+
+	if s.restarting {
+		s.restarting = false
+		if seq == s.restartNext {
+			// the message continues the sequence of the message behind the window, which therefore
+			// was not late but the first message after the exporter restarted
+			events = append(events, SequenceEvent{
+				Kind:     SequenceReset,
+				Expected: s.next,
+				Received: s.restart,
+			})
+			s.next = seq + records
+			s.missing = nil
+			return events
+		}
+	}
+
+	// sequence numbers wrap around at 2^32, such that the distance is computed modulo 2^32 and
+	// messages up to 2^31 records ahead are considered ahead, and all others behind
+	distance := seq - s.next
+	switch {
+	case distance == 0:
+		s.next += records
+	case int32(distance) > 0:
+		s.missing = append(s.missing, sequenceRange{start: s.next, length: distance})
+		s.next = seq + records
+	default:
+		if s.fill(seq, records) {
+			break
+		}
+		if s.next-seq > t.window {
+			s.restart = seq
+			s.restartNext = seq + records
+			s.restarting = true
+		}
+		// otherwise, the message is a late duplicate within the window
+	}
+
+	// declare all gaps lost that fell out of the window
+	missing := s.missing[:0]
+	for _, r := range s.missing {
+		if s.next-r.start > t.window {
+			events = append(events, SequenceEvent{
+				Kind:     SequenceGap,
+				Expected: r.start,
+				Received: r.start + r.length,
+				Lost:     r.length,
+			})
+			continue
+		}
+		missing = append(missing, r)
+	}
+	s.missing = missing
+
+	return events
+}
+
+// fill removes the sequence numbers of a late message from the missing ranges. It returns false
+// if the message does not belong to any missing range.
+func (s *sequenceState) fill(seq uint32, records uint32) bool {
+	for i, r := range s.missing {
+		offset := seq - r.start
+		if offset >= r.length {
+			continue
+		}
+		end := offset + records
+		remaining := make([]sequenceRange, 0, 2)
+		if offset > 0 {
+			remaining = append(remaining, sequenceRange{start: r.start, length: offset})
+		}
+		if end < r.length {
+			remaining = append(remaining, sequenceRange{start: r.start + end, length: r.length - end})
+		}
+		s.missing = append(s.missing[:i], append(remaining, s.missing[i+1:]...)...)
+		return true
+	}
+	return false
+}
+
+// report counts e in the tracker's metrics and calls the callback
+func (t *SequenceTracker) report(e SequenceEvent) {
+	switch e.Kind {
+	case SequenceGap:
+		t.collectors.recordsLost(t.listener, e.ObservationDomainId).Add(float64(e.Lost))
+	case SequenceReset:
+		t.collectors.exporterRestarts(t.listener, e.ObservationDomainId).Inc()
+	}
+	if t.callback != nil {
+		t.callback(e)
+	}
+}
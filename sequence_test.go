@@ -0,0 +1,208 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	// newMessage creates a message with the given sequence number, a template set of templates
+	// template records, and a data set of records data records
+	newMessage := func(seq uint32, records int, templates int) *Message {
+		return &Message{
+			ObservationDomainId: 1,
+			SequenceNumber:      seq,
+			Sets: []Set{
+				{Kind: KindTemplateSet, Set: &TemplateSet{Records: make([]TemplateRecord, templates)}},
+				{Kind: KindDataSet, Set: &DataSet{Records: make([]DataRecord, records)}},
+			},
+		}
+	}
+
+	// newTracker creates a tracker with the given window that records all events
+	newTracker := func(window uint32) (*SequenceTracker, *[]SequenceEvent, *Metrics) {
+		events := make([]SequenceEvent, 0)
+		m := NewMetrics(WithObservationDomainLabel(0))
+		tracker := NewSequenceTracker(
+			WithReorderWindow(window),
+			WithSequenceMetrics(m, "test"),
+			WithSequenceCallback(func(e SequenceEvent) {
+				events = append(events, e)
+			}),
+		)
+		return tracker, &events, m
+	}
+
+	t.Run("in order", func(t *testing.T) {
+		tracker, events, _ := newTracker(0)
+		// template records do not increment the sequence number
+		tracker.Observe("exporter", newMessage(100, 10, 3))
+		tracker.Observe("exporter", newMessage(110, 0, 2))
+		tracker.Observe("exporter", newMessage(110, 5, 0))
+		tracker.Observe("exporter", newMessage(115, 5, 0))
+		if len(*events) != 0 {
+			t.Errorf("expected no events for messages in order, got %v", *events)
+		}
+	})
+
+	t.Run("loss", func(t *testing.T) {
+		tracker, events, m := newTracker(0)
+		tracker.Observe("exporter", newMessage(0, 10, 0))
+		tracker.Observe("exporter", newMessage(17, 10, 0))
+		if len(*events) != 1 {
+			t.Fatalf("expected a single gap, got %v", *events)
+		}
+		e := (*events)[0]
+		if e.Kind != SequenceGap || e.Expected != 10 || e.Received != 17 || e.Lost != 7 || e.Exporter != "exporter" || e.ObservationDomainId != 1 {
+			t.Errorf("unexpected gap %+v", e)
+		}
+		if v := testutil.ToFloat64(m.RecordsLost.WithLabelValues("test", "1")); v != 7 {
+			t.Errorf("expected 7 lost records, got %v", v)
+		}
+	})
+
+	t.Run("reorder within window", func(t *testing.T) {
+		tracker, events, _ := newTracker(20)
+		tracker.Observe("exporter", newMessage(0, 10, 0))
+		tracker.Observe("exporter", newMessage(20, 10, 0))
+		tracker.Observe("exporter", newMessage(10, 10, 0))
+		tracker.Observe("exporter", newMessage(30, 10, 0))
+		tracker.Observe("exporter", newMessage(40, 10, 0))
+		if len(*events) != 0 {
+			t.Errorf("expected reordered message to fill the gap, got %v", *events)
+		}
+	})
+
+	t.Run("loss after window", func(t *testing.T) {
+		tracker, events, _ := newTracker(20)
+		tracker.Observe("exporter", newMessage(0, 10, 0))
+		tracker.Observe("exporter", newMessage(20, 10, 0))
+		if len(*events) != 0 {
+			t.Fatalf("expected gap within window not to be declared lost yet, got %v", *events)
+		}
+		// partially fill the gap
+		tracker.Observe("exporter", newMessage(10, 4, 0))
+		tracker.Observe("exporter", newMessage(30, 10, 0))
+		if len(*events) != 1 || (*events)[0].Expected != 14 || (*events)[0].Lost != 6 {
+			t.Errorf("expected remainder of gap to be lost after the window, got %v", *events)
+		}
+		// the late remainder is ignored
+		tracker.Observe("exporter", newMessage(14, 6, 0))
+		tracker.Observe("exporter", newMessage(40, 10, 0))
+		if len(*events) != 1 {
+			t.Errorf("expected late message not to cause further events, got %v", *events)
+		}
+	})
+
+	t.Run("wraparound", func(t *testing.T) {
+		tracker, events, _ := newTracker(0)
+		tracker.Observe("exporter", newMessage(1<<32-10, 10, 0))
+		tracker.Observe("exporter", newMessage(0, 10, 0))
+		tracker.Observe("exporter", newMessage(10, 10, 0))
+		if len(*events) != 0 {
+			t.Errorf("expected no events when wrapping around, got %v", *events)
+		}
+
+		tracker, events, _ = newTracker(0)
+		tracker.Observe("exporter", newMessage(1<<32-5, 10, 1))
+		tracker.Observe("exporter", newMessage(7, 10, 0))
+		if len(*events) != 1 || (*events)[0].Expected != 5 || (*events)[0].Lost != 2 {
+			t.Errorf("expected gap of 2 records across the wraparound, got %v", *events)
+		}
+	})
+
+	t.Run("exporter restart", func(t *testing.T) {
+		tracker, events, m := newTracker(5)
+		tracker.Observe("exporter", newMessage(1000, 10, 0))
+		tracker.Observe("exporter", newMessage(1010, 10, 0))
+		tracker.Observe("exporter", newMessage(0, 10, 3))
+		if len(*events) != 0 {
+			t.Fatalf("expected restart not to be confirmed before the next message, got %v", *events)
+		}
+		tracker.Observe("exporter", newMessage(10, 10, 0))
+		tracker.Observe("exporter", newMessage(20, 10, 0))
+		if len(*events) != 1 {
+			t.Fatalf("expected a single reset, got %v", *events)
+		}
+		if e := (*events)[0]; e.Kind != SequenceReset || e.Expected != 1020 || e.Received != 0 {
+			t.Errorf("unexpected reset %+v", e)
+		}
+		if v := testutil.ToFloat64(m.ExporterRestarts.WithLabelValues("test", "1")); v != 1 {
+			t.Errorf("expected a single restart, got %v", v)
+		}
+	})
+
+	t.Run("late message beyond window is not a restart", func(t *testing.T) {
+		tracker, events, _ := newTracker(0)
+		tracker.Observe("exporter", newMessage(0, 10, 0))
+		tracker.Observe("exporter", newMessage(20, 10, 0))
+		tracker.Observe("exporter", newMessage(10, 10, 0))
+		tracker.Observe("exporter", newMessage(30, 10, 0))
+		if len(*events) != 1 || (*events)[0].Kind != SequenceGap {
+			t.Errorf("expected only the gap, got %v", *events)
+		}
+	})
+
+	t.Run("exporters and domains are tracked separately", func(t *testing.T) {
+		tracker, events, _ := newTracker(0)
+		tracker.Observe("a", newMessage(0, 10, 0))
+		tracker.Observe("b", newMessage(500, 10, 0))
+		other := newMessage(7, 10, 0)
+		other.ObservationDomainId = 2
+		tracker.Observe("a", other)
+		tracker.Observe("a", newMessage(10, 10, 0))
+		tracker.Observe("b", newMessage(510, 10, 0))
+		if len(*events) != 0 {
+			t.Errorf("expected no events, got %v", *events)
+		}
+	})
+
+	t.Run("decoder feeds tracker", func(t *testing.T) {
+		ctx := context.Background()
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		if err := templateCache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256)); err != nil {
+			t.Fatal(err)
+		}
+
+		events := make([]SequenceEvent, 0)
+		tracker := NewSequenceTracker(WithSequenceCallback(func(e SequenceEvent) {
+			events = append(events, e)
+		}))
+		decoder := NewDecoderWithOptions(templateCache, fieldCache, WithSequenceTracking(tracker))
+
+		for _, m := range []struct {
+			seq     uint32
+			records int
+		}{{0, 3}, {3, 2}, {9, 1}} {
+			b := newTestDataMessage(256, m.records)
+			binary.BigEndian.PutUint32(b[8:12], m.seq)
+			if _, err := decoder.Decode(ctx, bytes.NewBuffer(b)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(events) != 1 || events[0].Expected != 5 || events[0].Lost != 4 {
+			t.Errorf("expected gap of 4 records, got %v", events)
+		}
+	})
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package ipfix
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -39,6 +41,22 @@ const (
 	KindOptionsTemplateSet string = "OptionsTemplateSet"
 )
 
+// legacyKinds maps the record-based kinds used by earlier versions of the module, e.g., in
+// persisted template caches, to the set-based Kind* constants
+var legacyKinds = map[string]string{
+	"DataRecord":            KindDataSet,
+	"TemplateRecord":        KindTemplateSet,
+	"OptionsTemplateRecord": KindOptionsTemplateSet,
+}
+
+// normalizeKind returns the Kind* constant for both current and legacy kinds
+func normalizeKind(kind string) string {
+	if k, ok := legacyKinds[kind]; ok {
+		return k
+	}
+	return kind
+}
+
 var _ fmt.Stringer = &Set{}
 var _ json.Marshaler = &Set{}
 var _ json.Unmarshaler = &Set{}
@@ -83,7 +101,124 @@ func (s *Set) MarshalJSON() ([]byte, error) {
 	return json.Marshal(t)
 }
 
+// setJSONHeader is the JSON encoding of the set header used by Set.MarshalJSON and Set.encodeJSON
+type setJSONHeader struct {
+	Id uint16 `json:"id,omitempty"`
+
+	Length uint16 `json:"length,omitempty"`
+
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+}
+
+// verboseDataRecord converts dr into a value marshalled like dr, but with the metadata of the
+// prototypes of its fields
+func verboseDataRecord(dr *DataRecord) any {
+	type vdr struct {
+		TemplateId uint16              `json:"template_id,omitempty"`
+		FieldCount uint16              `json:"field_count,omitempty"`
+		Fields     []consolidatedField `json:"fields,omitempty"`
+	}
+	var fields []consolidatedField
+	if dr.Fields != nil {
+		fields = make([]consolidatedField, 0, len(dr.Fields))
+		for _, f := range dr.Fields {
+			cf := f.consolidate()
+			cf.describe(f.Prototype())
+			fields = append(fields, cf)
+		}
+	}
+	return &vdr{
+		TemplateId: dr.TemplateId,
+		FieldCount: dr.FieldCount,
+		Fields:     fields,
+	}
+}
+
+// encodeJSON writes the same JSON encoding as MarshalJSON to w, marshalling one record at a time
+func (s *Set) encodeJSON(w *bufio.Writer, options *jsonEncoderOptions) error {
+	header, err := json.Marshal(&setJSONHeader{
+		Id:     s.Id,
+		Length: s.Length,
+		Kind:   s.Kind,
+	})
+	if err != nil {
+		return err
+	}
+
+	// records are collected as pointers such that they are not copied, and nil slices are
+	// encoded as null like in MarshalJSON
+	var records []any
+	switch ff := s.Set.(type) {
+	case *DataSet:
+		if ff.Records != nil {
+			records = make([]any, 0, len(ff.Records))
+			for i := range ff.Records {
+				if options.verboseFields {
+					records = append(records, verboseDataRecord(&ff.Records[i]))
+					continue
+				}
+				records = append(records, &ff.Records[i])
+			}
+		}
+	case *TemplateSet:
+		if ff.Records != nil {
+			records = make([]any, 0, len(ff.Records))
+			for i := range ff.Records {
+				records = append(records, &ff.Records[i])
+			}
+		}
+	case *OptionsTemplateSet:
+		if ff.Records != nil {
+			records = make([]any, 0, len(ff.Records))
+			for i := range ff.Records {
+				records = append(records, &ff.Records[i])
+			}
+		}
+	default:
+		// sets of unknown type have no records, as in MarshalJSON
+		w.Write(header)
+		return nil
+	}
+
+	// continue the header object with the records
+	w.Write(header[:len(header)-1])
+	if len(header) > 2 {
+		w.WriteByte(',')
+	}
+	w.WriteString(`"records":`)
+	if records == nil {
+		w.WriteString("null}")
+		return nil
+	}
+	w.WriteByte('[')
+	for i, r := range records {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record %d, %w", i, err)
+		}
+		w.Write(b)
+	}
+	_, err = w.WriteString("]}")
+	return err
+}
+
+// Encode writes the set in IPFIX binary format to w. Sets whose records exceed the maximum set
+// length fail with ErrMessageTooLarge, and nothing is written.
 func (s *Set) Encode(w io.Writer) (n int, err error) {
+	// encode the body first, such that oversized sets are not written partially
+	body := &bytes.Buffer{}
+	if s.Set != nil {
+		if _, err := s.Set.Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	if l := setHeaderLength + body.Len(); l > MaxMessageLength {
+		return 0, fmt.Errorf("%w: set %d of %d bytes", ErrMessageTooLarge, s.Id, l)
+	}
+
 	// header
 	l := make([]byte, 2)
 	binary.BigEndian.PutUint16(l, s.SetHeader.Id)
@@ -100,12 +235,10 @@ func (s *Set) Encode(w io.Writer) (n int, err error) {
 		return n, err
 	}
 	// body
-	if s.Set != nil {
-		bn, err := s.Set.Encode(w)
-		n += bn
-		if err != nil {
-			return n, err
-		}
+	bn, err := w.Write(body.Bytes())
+	n += bn
+	if err != nil {
+		return n, err
 	}
 	return n, nil
 }
@@ -125,7 +258,8 @@ func (s *Set) UnmarshalJSON(in []byte) error {
 	}
 
 	var ff set
-	switch t.Kind {
+	kind := normalizeKind(t.Kind)
+	switch kind {
 	case KindDataSet:
 		dfs := &DataSet{}
 		err = json.Unmarshal(t.Records, &dfs.Records)
@@ -147,6 +281,8 @@ func (s *Set) UnmarshalJSON(in []byte) error {
 			break
 		}
 		ff = iotfs
+	default:
+		return fmt.Errorf("cannot use %s as a set kind for unmarshalling", t.Kind)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal into Records, %w", err)
@@ -154,7 +290,7 @@ func (s *Set) UnmarshalJSON(in []byte) error {
 
 	*s = Set{
 		SetHeader: t.SetHeader,
-		Kind:      t.Kind,
+		Kind:      kind,
 		Set:       ff,
 	}
 	return nil
@@ -200,26 +336,47 @@ func (d *DataSet) With(t *Template) *DataSet {
 
 func (d *DataSet) Decode(r io.Reader) (n int, err error) {
 	if d.template == nil {
-		return 0, errors.New("no template bound to data record")
+		return 0, fmt.Errorf("failed to decode data set, %w", ErrTemplateNotFound)
 	}
 
-	for {
+	b, ok := r.(interface {
+		io.Reader
+		Len() int
+	})
+	if !ok {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		b = bytes.NewReader(buf)
+	}
+
+	minLength := minRecordLength(d.template)
+	for b.Len() > 0 {
+		if b.Len() < minLength {
+			// trailing bytes shorter than any record are padding
+			return n, nil
+		}
 		dr := DataRecord{
 			template:   d.template,
+			fieldCache: d.fieldCache,
 			TemplateId: d.template.TemplateId,
 		}
-		m, err := dr.Decode(r)
+		m, err := dr.Decode(b)
 		n += m
-		if err != nil && err == io.EOF {
+		if err != nil {
+			if err == io.EOF {
+				return n, malformedMessage(n, "record %d of template %d is truncated", len(d.Records), d.template.TemplateId)
+			}
 			return n, err
 		}
-		d.Records = append(d.Records, dr)
-		if err == io.EOF {
-			break
+		if m == 0 {
+			// records of templates consisting only of zero-length fields would never exhaust the set
+			return n, malformedMessage(n, "records of template %d are empty", d.template.TemplateId)
 		}
+		d.Records = append(d.Records, dr)
 	}
-
-	return
+	return n, nil
 }
 
 type TemplateSet struct {
@@ -252,20 +409,27 @@ func (d *TemplateSet) Encode(w io.Writer) (n int, err error) {
 	return n, nil
 }
 
+// Decode decodes template records until the end of r. If a record cannot be decoded, e.g.,
+// because r ends within the record, Decode returns a TemplateRecordError, and Records
+// only contains the records preceding it.
 func (d *TemplateSet) Decode(r io.Reader) (n int, err error) {
 	d.Records = make([]TemplateRecord, 0)
 	// "as long as there's set header data (Set ID, Length)"
 	for {
-		templateRecord := TemplateRecord{}
+		templateRecord := TemplateRecord{
+			fieldCache:    d.fieldCache,
+			templateCache: d.templateCache,
+		}
 
 		m, err := templateRecord.Decode(r)
 		n += m
 		if err != nil {
-			if err == io.EOF {
+			if isEndOfTemplateSet(m, templateRecord.TemplateId, err) {
 				break
 			}
-			return n, err
+			return n, templateRecordError(templateRecord.TemplateId, m, err)
 		}
+		d.Records = append(d.Records, templateRecord)
 	}
 	return
 }
@@ -301,25 +465,49 @@ func (d *OptionsTemplateSet) Encode(w io.Writer) (n int, err error) {
 	return n, nil
 }
 
+// Decode decodes options template records until the end of r. If a record cannot be decoded,
+// e.g., because r ends within the record, Decode returns a TemplateRecordError, and Records
+// only contains the records preceding it.
 func (d *OptionsTemplateSet) Decode(r io.Reader) (n int, err error) {
 	d.Records = make([]OptionsTemplateRecord, 0)
-	// TODO(zoomoid): maybe we need this for bound checks...
-	// for r.Len() >= 4 {
 	for {
-		record := OptionsTemplateRecord{}
+		record := OptionsTemplateRecord{
+			fieldCache:    d.fieldCache,
+			templateCache: d.templateCache,
+		}
 
 		m, err := record.Decode(r)
 		n += m
 		if err != nil {
-			if err == io.EOF {
+			if isEndOfTemplateSet(m, record.TemplateId, err) {
 				break
 			}
-			return n, err
+			return n, templateRecordError(record.TemplateId, m, err)
 		}
+		d.Records = append(d.Records, record)
 	}
 	return
 }
 
+// isEndOfTemplateSet reports whether decoding a template record of templateId failed after n
+// bytes only because the set ended, either before the record, or within padding at the end
+// of the set. Padding consists of zeros, and template ids are never zero.
+func isEndOfTemplateSet(n int, templateId uint16, err error) bool {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false
+	}
+	return n == 0 || (templateId == 0 && n < 4)
+}
+
+// templateRecordError wraps the error of decoding a template record of templateId after n
+// bytes. A set ending within the record is reported as a malformed message.
+func templateRecordError(templateId uint16, n int, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = malformedMessage(n, "set ends within template record")
+	}
+	return &TemplateRecordError{TemplateId: templateId, Err: err}
+}
+
 type set interface {
 	fmt.Stringer
 
@@ -46,14 +46,22 @@ func (t *Signed32) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers of any width and whole floating-point numbers as value, given they
+// are in the range of int32.
+func (t *Signed32) SetValueE(v any) (DataType, error) {
+	i, err := signedFromAny(v, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = int32(i)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Signed32) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = int32(ty)
-	case int:
-		t.value = int32(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -71,7 +79,9 @@ func (*Signed32) DefaultLength() uint16 {
 
 func (t *Signed32) Clone() DataType {
 	return &Signed32{
-		value: t.value,
+		value:         t.value,
+		reducedLength: t.reducedLength,
+		length:        t.length,
 	}
 }
 
@@ -103,8 +113,10 @@ func (t *Signed32) IsReducedLength() bool {
 }
 
 func (t *Signed32) Decode(in io.Reader) (n int, err error) {
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -116,7 +128,7 @@ func (t *Signed32) Decode(in io.Reader) (n int, err error) {
 	// sample MSB and pad byte array with it
 	msb := b[0] >> 7
 	offset := t.DefaultLength() - t.Length()
-	c := make([]byte, t.DefaultLength())
+	var c [4]byte
 	if msb != 0 {
 		for i := uint16(0); i < offset; i++ {
 			// padding loop
@@ -126,7 +138,7 @@ func (t *Signed32) Decode(in io.Reader) (n int, err error) {
 	for i := uint16(0); i < t.length; i++ {
 		c[i+offset] = b[i]
 	}
-	t.value = int32(binary.BigEndian.Uint32(c))
+	t.value = int32(binary.BigEndian.Uint32(c[:]))
 	return
 }
 
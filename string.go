@@ -17,19 +17,72 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
 )
 
+// UTF8Policy determines how String handles decoded values that are not valid UTF-8 or
+// that contain NUL bytes. RFC 7011#section-6.1.6 states that "Collecting Processes SHOULD
+// detect and ignore such values".
+type UTF8Policy int
+
+const (
+	// UTF8PolicyReplace replaces each invalid UTF-8 sequence and each embedded NUL byte
+	// with the Unicode replacement character U+FFFD.
+	UTF8PolicyReplace UTF8Policy = iota
+	// UTF8PolicyReject fails decoding with ErrIllegalDataTypeEncoding if the value is not
+	// valid UTF-8 or contains embedded NUL bytes.
+	UTF8PolicyReject
+	// UTF8PolicyStrip removes invalid UTF-8 sequences and embedded NUL bytes from the value.
+	UTF8PolicyStrip
+)
+
+func (p UTF8Policy) String() string {
+	switch p {
+	case UTF8PolicyReplace:
+		return "replace"
+	case UTF8PolicyReject:
+		return "reject"
+	case UTF8PolicyStrip:
+		return "strip"
+	default:
+		return fmt.Sprintf("UTF8Policy(%d)", int(p))
+	}
+}
+
+// DefaultUTF8Policy is the policy used by all String data types created by NewString and
+// their curried constructors. Change it before decoding anything to change the policy globally,
+// or use (*String).WithPolicy for individual values.
+var DefaultUTF8Policy UTF8Policy = UTF8PolicyReplace
+
 type String struct {
 	value string
 
 	length uint16
+
+	policy UTF8Policy
 }
 
 func NewString() DataType {
-	return &String{}
+	return &String{
+		policy: DefaultUTF8Policy,
+	}
+}
+
+// WithPolicy sets the UTF8Policy applied during Decode
+func (t *String) WithPolicy(policy UTF8Policy) *String {
+	t.policy = policy
+	return t
+}
+
+// Policy returns the UTF8Policy applied during Decode
+func (t *String) Policy() UTF8Policy {
+	return t.policy
 }
 
 func (s *String) String() string {
@@ -44,13 +97,36 @@ func (t *String) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets strings and byte slices as value. Invalid UTF-8 sequences are handled according
+// to the UTF8Policy of the string.
+func (t *String) SetValueE(v any) (DataType, error) {
+	var b []byte
+	switch vv := v.(type) {
+	case string:
+		b = []byte(vv)
+	case []byte:
+		b = vv
+	default:
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T is not a string", t, ErrInvalidValue, v)
+	}
+	s, err := sanitizeUTF8(b, t.policy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	if len(s) > math.MaxUint16 {
+		return nil, fmt.Errorf("cannot set value in %T, %w, %d bytes exceed the maximum length of a field", t, ErrInvalidValue, len(s))
+	}
+	t.value = s
+	t.length = uint16(len(s))
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *String) SetValue(v any) DataType {
-	b, ok := v.(string)
-	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.value = b
-	t.length = uint16(len(b))
 	return t
 }
 
@@ -67,14 +143,18 @@ func (*String) DefaultLength() uint16 {
 
 func (t *String) Clone() DataType {
 	return &String{
-		value: t.value,
+		value:  t.value,
+		length: t.length,
+		policy: t.policy,
 	}
 }
 
-func (*String) WithLength(length uint16) DataTypeConstructor {
+func (t *String) WithLength(length uint16) DataTypeConstructor {
+	policy := t.policy
 	return func() DataType {
 		return &String{
 			length: length,
+			policy: policy,
 		}
 	}
 }
@@ -89,25 +169,64 @@ func (*String) IsReducedLength() bool {
 }
 
 func (t *String) Decode(in io.Reader) (n int, err error) {
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	// the declared length, either from the template or from the variable-length prefix,
+	// MUST be consumed entirely, otherwise subsequent fields are shifted
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
-	// check if in is a valid utf8 string
-	// TODO(zoomoid): reactivate this, but this broke a lot of string decoding in prior versions...
-	// if !utf8.Valid(b) {
-	// 	// "Collecting Processes SHOULD detect and ignore such values." (RFC7011#section-6.1)
-	// 	logger.V(1).Info("WARN decoded string data type that is not valid UTF-8, ignoring...", "bytes", b)
-	// 	return nil
-	// }
-	t.value = string(b)
+	// fixed-length string fields are commonly padded with trailing NUL bytes by exporters
+	// written in C, those are not part of the value
+	b = bytes.TrimRight(b, "\x00")
+
+	value, err := sanitizeUTF8(b, t.policy)
+	if err != nil {
+		return n, fmt.Errorf("failed to decode %T, %w", t, err)
+	}
+	t.value = value
 	return
 }
 
+// sanitizeUTF8 applies policy to b and returns the resulting string
+func sanitizeUTF8(b []byte, policy UTF8Policy) (string, error) {
+	if utf8.Valid(b) && bytes.IndexByte(b, 0x00) == -1 {
+		// fast-track
+		return string(b), nil
+	}
+	switch policy {
+	case UTF8PolicyReject:
+		if i := bytes.IndexByte(b, 0x00); i != -1 && utf8.Valid(b) {
+			return "", fmt.Errorf("%w: embedded NUL byte at offset %d", ErrIllegalDataTypeEncoding, i)
+		}
+		return "", fmt.Errorf("%w: invalid UTF-8 sequence %q", ErrIllegalDataTypeEncoding, b)
+	case UTF8PolicyStrip:
+		return strings.ReplaceAll(strings.ToValidUTF8(string(b), ""), "\x00", ""), nil
+	default:
+		return strings.ReplaceAll(strings.ToValidUTF8(string(b), string(utf8.RuneError)), "\x00", string(utf8.RuneError)), nil
+	}
+}
+
+// Encode writes the string value to w. If the length of the string is set, e.g., by the template
+// or the decoded variable-length field, exactly length bytes are written: shorter values are
+// padded with NUL bytes, longer values are truncated at the last complete rune.
 func (t *String) Encode(w io.Writer) (int, error) {
 	b := []byte(t.value)
-	return w.Write(b)
+	if t.length == 0 || int(t.length) == len(b) {
+		return w.Write(b)
+	}
+	if cut := int(t.length); len(b) > cut {
+		// do not cut a multi-byte rune in half
+		for cut > 0 && !utf8.RuneStart(b[cut]) {
+			cut--
+		}
+		b = b[:cut]
+	}
+	c := make([]byte, t.length)
+	copy(c, b)
+	return w.Write(c)
 }
 
 func (t *String) MarshalJSON() ([]byte, error) {
@@ -115,7 +234,12 @@ func (t *String) MarshalJSON() ([]byte, error) {
 }
 
 func (t *String) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &t.value)
+	err := json.Unmarshal(in, &t.value)
+	if err != nil {
+		return err
+	}
+	t.length = uint16(len(t.value))
+	return nil
 }
 
 var _ DataTypeConstructor = NewString
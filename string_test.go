@@ -0,0 +1,170 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	malformed := []struct {
+		name     string
+		in       []byte
+		replaced string
+		stripped string
+	}{
+		{
+			name:     "valid",
+			in:       []byte("example.org"),
+			replaced: "example.org",
+			stripped: "example.org",
+		},
+		{
+			name:     "invalid continuation byte",
+			in:       []byte{'a', 0x80, 'b'},
+			replaced: "a�b",
+			stripped: "ab",
+		},
+		{
+			name:     "truncated multi-byte sequence",
+			in:       []byte{'a', 0xE2, 0x82},
+			replaced: "a�",
+			stripped: "a",
+		},
+		{
+			name:     "overlong encoding",
+			in:       []byte{0xC0, 0xAF, 'x'},
+			replaced: "�x",
+			stripped: "x",
+		},
+		{
+			name:     "embedded NUL byte",
+			in:       []byte{'a', 0x00, 'b'},
+			replaced: "a�b",
+			stripped: "ab",
+		},
+		{
+			name:     "trailing NUL padding",
+			in:       []byte{'a', 'b', 0x00, 0x00},
+			replaced: "ab",
+			stripped: "ab",
+		},
+	}
+
+	for _, tc := range malformed {
+		t.Run("replace "+tc.name, func(t *testing.T) {
+			s := NewString().SetLength(uint16(len(tc.in))).(*String).WithPolicy(UTF8PolicyReplace)
+			n, err := s.Decode(bytes.NewBuffer(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tc.in) {
+				t.Errorf("expected to consume %d bytes, consumed %d", len(tc.in), n)
+			}
+			if s.Value() != tc.replaced {
+				t.Errorf("expected %q, got %q", tc.replaced, s.Value())
+			}
+		})
+		t.Run("strip "+tc.name, func(t *testing.T) {
+			s := NewString().SetLength(uint16(len(tc.in))).(*String).WithPolicy(UTF8PolicyStrip)
+			_, err := s.Decode(bytes.NewBuffer(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Value() != tc.stripped {
+				t.Errorf("expected %q, got %q", tc.stripped, s.Value())
+			}
+		})
+		t.Run("reject "+tc.name, func(t *testing.T) {
+			s := NewString().SetLength(uint16(len(tc.in))).(*String).WithPolicy(UTF8PolicyReject)
+			_, err := s.Decode(bytes.NewBuffer(tc.in))
+			valid := tc.replaced == tc.stripped && !bytes.ContainsRune([]byte(tc.replaced), '�')
+			if valid && err != nil {
+				t.Fatal(err)
+			}
+			if !valid && !errors.Is(err, ErrIllegalDataTypeEncoding) {
+				t.Errorf("expected ErrIllegalDataTypeEncoding, got %v", err)
+			}
+		})
+	}
+
+	t.Run("policy is kept by curried constructors", func(t *testing.T) {
+		s := NewString().(*String).WithPolicy(UTF8PolicyReject)
+		c := s.WithLength(3)().(*String)
+		if c.Policy() != UTF8PolicyReject {
+			t.Errorf("expected policy %s, got %s", UTF8PolicyReject, c.Policy())
+		}
+		if c.Clone().(*String).Policy() != UTF8PolicyReject {
+			t.Errorf("expected cloned policy %s", UTF8PolicyReject)
+		}
+	})
+
+	t.Run("short read of declared length", func(t *testing.T) {
+		s := NewString().SetLength(8)
+		_, err := s.Decode(bytes.NewBuffer([]byte("abc")))
+		if err == nil {
+			t.Error("expected error for value shorter than declared length")
+		}
+	})
+
+	t.Run("encode pads and truncates to declared length", func(t *testing.T) {
+		s := NewString().SetLength(4).SetValue("ab").SetLength(4)
+		b := &bytes.Buffer{}
+		_, err := s.Encode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), []byte{'a', 'b', 0x00, 0x00}) {
+			t.Errorf("expected NUL-padded value, got %v", b.Bytes())
+		}
+
+		s = NewString().SetValue("aäb").SetLength(2)
+		b.Reset()
+		_, err = s.Encode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), []byte{'a', 0x00}) {
+			t.Errorf("expected value truncated at rune boundary, got %v", b.Bytes())
+		}
+	})
+
+	t.Run("variable-length field", func(t *testing.T) {
+		in := []byte{0x04, 'a', 0xFF, 'b', 'c', 0x01}
+		f := NewFieldBuilder(&InformationElement{
+			Id:          96,
+			Name:        "applicationName",
+			Constructor: NewString,
+		}).SetLength(VariableLength).Complete()
+		r := bytes.NewBuffer(in)
+		n, err := f.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 5 {
+			t.Errorf("expected 5 consumed bytes, got %d", n)
+		}
+		if f.Value().Value() != "a�bc" {
+			t.Errorf("expected replaced value, got %q", f.Value().Value())
+		}
+		if r.Len() != 1 {
+			t.Errorf("expected trailing byte to remain in buffer, %d remaining", r.Len())
+		}
+	})
+}
@@ -46,15 +46,17 @@ type SubTemplateList struct {
 
 	templateId uint16
 
-	// length is the length of the records nested in the SubTemplateList in bytes
-	// It does not include the semantic and templateId, which are statically added
-	// when using SubTemplateList.Length()
+	// length is the length of the entire SubTemplateList in bytes, i.e., including the
+	// semantic and templateId header. Decode uses it to bound the list, SetValue and
+	// UnmarshalJSON keep it in sync with Length()
 	length uint16
 
 	// value DataRecord
 	value []DataRecord
 
 	templateManager TemplateCache
+	// fieldManager is used for restoring the fields of records from JSON
+	fieldManager FieldCache
 
 	// observationDomainId is used for scoping templates in their manager
 	// it is required for looking up the template belonging to this types templateId
@@ -87,20 +89,29 @@ func (t *SubTemplateList) Value() interface{} {
 	return t.value
 }
 
-func (t *SubTemplateList) SetValue(v any) DataType {
+// SetValueE sets a slice of DataRecords as value. Lists without template id take the template
+// id of the first record.
+func (t *SubTemplateList) SetValueE(v any) (DataType, error) {
 	// TODO(zoomoid): is this safe to assert? can we cleanly extract a slice of subTemplateListContent from a consolidated field?
 	b, ok := v.([]DataRecord)
 	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T cannot be asserted to %T", t, ErrInvalidValue, v, t.value)
 	}
 	t.value = b
-	l := uint16(0)
-	for _, e := range b {
-		for _, f := range e.Fields {
-			l += f.Length()
-		}
+	if t.templateId == 0 && len(b) > 0 {
+		// lists built programmatically take the template id from their records
+		t.templateId = b[0].TemplateId
+	}
+	t.length = t.Length()
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *SubTemplateList) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.length = l
 	return t
 }
 
@@ -120,10 +131,15 @@ func (*SubTemplateList) DefaultLength() uint16 {
 	return subTemplateListHeaderLength
 }
 
+// Clone creates a deep copy of the sub template list. Each record is cloned, such that mutating
+// the records of the clone does not affect the original list. The TemplateCache is shared.
 func (t *SubTemplateList) Clone() DataType {
-	vs := make([]DataRecord, 0)
-	for _, el := range t.value {
-		vs = append(vs, el.Clone())
+	var vs []DataRecord
+	if t.value != nil {
+		vs = make([]DataRecord, 0, len(t.value))
+		for _, el := range t.value {
+			vs = append(vs, el.Clone())
+		}
 	}
 	return &SubTemplateList{
 		value:               vs,
@@ -133,6 +149,7 @@ func (t *SubTemplateList) Clone() DataType {
 		length:              t.length,
 		observationDomainId: t.observationDomainId,
 		templateManager:     t.templateManager,
+		fieldManager:        t.fieldManager,
 	}
 }
 
@@ -169,10 +186,19 @@ func (t *SubTemplateList) Elements() []DataRecord {
 }
 
 func (t *SubTemplateList) Decode(r io.Reader) (n int, err error) {
+	if t.length < subTemplateListHeaderLength {
+		return n, malformedMessage(n, "%T list length %d is shorter than its header", t, t.length)
+	}
+
+	state, depth := decodeStateOf(r)
+	if err := state.enterList(depth + 1); err != nil {
+		return n, fmt.Errorf("failed to decode %T, %w", t, err)
+	}
+
 	// semantic and listBuffer are included in the length field preceeding
 	// when using variable-length encoding
 	b := make([]byte, 1)
-	m, err := r.Read(b)
+	m, err := io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read list semantic in %T, %w", t, err)
@@ -180,7 +206,7 @@ func (t *SubTemplateList) Decode(r io.Reader) (n int, err error) {
 	t.semantic = ListSemantic(uint8(b[0]))
 
 	b = make([]byte, 2)
-	m, err = r.Read(b)
+	m, err = io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, fmt.Errorf("failed to read template id in %T, %w", t, err)
@@ -188,7 +214,7 @@ func (t *SubTemplateList) Decode(r io.Reader) (n int, err error) {
 	t.templateId = binary.BigEndian.Uint16(b)
 
 	if t.templateManager == nil {
-		return n, fmt.Errorf("failed to get template (%d,%d), manager is nil", t.observationDomainId, t.templateId)
+		return n, fmt.Errorf("failed to get template (%d,%d), manager is nil, %w", t.observationDomainId, t.templateId, ErrTemplateNotFound)
 	}
 
 	tmpl, err := t.templateManager.Get(context.TODO(), TemplateKey{
@@ -214,27 +240,39 @@ func (t *SubTemplateList) Decode(r io.Reader) (n int, err error) {
 	// fields already determined the length of this DataType, use this length parameter to
 	// read data.
 	lb := make([]byte, t.length-subTemplateListHeaderLength) // we already read 3 bytes from the buffer of valid data for the stl
-	m, err = r.Read(lb)
+	m, err = io.ReadFull(r, lb)
 	n += m
-	if err != nil && err != io.EOF {
+	if err != nil {
 		return n, fmt.Errorf("failed to read from field buffer for decoding %T, %w", t, err)
 	}
 	listBuffer := bytes.NewBuffer(lb)
+	elements := state.nest(listBuffer, depth+1)
+	minLength := minRecordLength(tmpl)
 	for listBuffer.Len() > 0 {
+		if listBuffer.Len() < minLength {
+			// trailing bytes shorter than any record are padding
+			break
+		}
+		if err := state.addListElement(len(records) + 1); err != nil {
+			return n, fmt.Errorf("failed to decode sub template from list buffer in %T, %w", t, err)
+		}
 		dr := DataRecord{}
-		m, err := dr.With(tmpl).Decode(listBuffer)
-		n += m
-		if err != nil && err != io.EOF {
+		m, err := dr.With(tmpl).Decode(elements)
+		if err != nil {
+			if err == io.EOF {
+				return n, malformedMessage(n, "record %d of template %d in %T is truncated", len(records), t.templateId, t)
+			}
 			return n, fmt.Errorf("failed to decode sub template from list buffer in %T, %w", t, err)
 		}
-		records = append(records, dr)
-		if err == io.EOF {
-			break
+		if m == 0 {
+			// records of templates consisting only of zero-length fields would never exhaust the list
+			return n, malformedMessage(n, "records of template %d in %T are empty", t.templateId, t)
 		}
+		records = append(records, dr)
 	}
 
 	t.value = records
-	return n, io.EOF
+	return n, nil
 }
 
 func (t *SubTemplateList) Encode(w io.Writer) (n int, err error) {
@@ -274,34 +312,42 @@ type marshalledSubTemplateList struct {
 	Records  []DataRecord            `json:"records" yaml:"records"`
 }
 
+// unmarshalledSubTemplateList defers unmarshalling the records until the caches of the list are
+// known to restore their fields with
+type unmarshalledSubTemplateList struct {
+	Metadata subTemplateListMetadata `json:"metadata"`
+	Records  json.RawMessage         `json:"records"`
+}
+
 func (t *SubTemplateList) MarshalJSON() ([]byte, error) {
 	return json.Marshal(marshalledSubTemplateList{
 		Metadata: subTemplateListMetadata{
-			Semantic:   t.semantic,
-			TemplateId: t.templateId,
+			Semantic:            t.semantic,
+			TemplateId:          t.templateId,
+			ObservationDomainId: t.observationDomainId,
 		},
 		Records: t.value,
 	})
 }
 
+// UnmarshalJSON restores the list and its records. The fields of the records, including nested
+// structured data types, are restored with the FieldCache and TemplateCache of the list, e.g., the
+// caches of the field builder the list was created with.
 func (t *SubTemplateList) UnmarshalJSON(in []byte) error {
-	tt := marshalledSubTemplateList{}
+	tt := unmarshalledSubTemplateList{}
 	err := json.Unmarshal(in, &tt)
 	if err != nil {
 		return err
 	}
-	t.value = tt.Records
-	l := uint16(0)
-	for _, e := range t.value {
-		for _, f := range e.Fields {
-			l += f.Length()
-		}
+	records, err := unmarshalRecordsJSON(tt.Records, t.fieldManager, t.templateManager)
+	if err != nil {
+		return fmt.Errorf("failed to restore records of %T, %w", t, err)
 	}
-	t.length = l
+	t.value = records
+	t.length = t.Length()
 	t.templateId = tt.Metadata.TemplateId
 	t.semantic = tt.Metadata.Semantic
 	t.observationDomainId = tt.Metadata.ObservationDomainId
-	// cannot restore functional fields such as TemplateCache and FieldCache from JSON
 	return nil
 }
 
@@ -331,6 +377,7 @@ func (t *subTemplateListBuilder) Complete() DataTypeConstructor {
 	return func() DataType {
 		return &SubTemplateList{
 			templateManager:     t.templateManager,
+			fieldManager:        t.fieldManager,
 			observationDomainId: t.observationDomainId,
 			semantic:            SemanticUndefined,
 		}
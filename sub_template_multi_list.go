@@ -40,11 +40,15 @@ func newSubTemplateMultiList(length uint16) DataType {
 type SubTemplateMultiList struct {
 	semantic ListSemantic
 
+	// length is the length of the entire list in bytes, i.e., including the semantic
+	// and the headers of each sub template
 	length uint16
 
 	value []subTemplateListContent
 
 	templateManager TemplateCache
+	// fieldManager is used for restoring the fields of records from JSON
+	fieldManager FieldCache
 
 	// observationDomainId is used for scoping templates in their manager
 	// it is required for looking up the template belonging to this types templateId
@@ -70,31 +74,35 @@ func (t *SubTemplateMultiList) Value() interface{} {
 	return t.value
 }
 
-func (t *SubTemplateMultiList) SetValue(v any) DataType {
+// SetValueE sets the contents of a subTemplateMultiList as value, as obtained from Value of
+// another subTemplateMultiList
+func (t *SubTemplateMultiList) SetValueE(v any) (DataType, error) {
 	// TODO(zoomoid): is this safe to assert? can we cleanly extract a slice of subTemplateListContent from a consolidated field?
 	b, ok := v.([]subTemplateListContent)
 	if !ok {
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+		return nil, fmt.Errorf("cannot set value in %T, %w, %T cannot be asserted to %T", t, ErrInvalidValue, v, t.value)
 	}
 	t.value = b
-	l := uint16(0)
-	for _, e := range b {
-		for _, dr := range e.Values {
-			// better computed length than before assuming e.Length being set
-			l += dr.Length()
-		}
+	for i := range t.value {
+		t.value[i].Length = t.value[i].length()
+	}
+	t.length = t.Length()
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
+func (t *SubTemplateMultiList) SetValue(v any) DataType {
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
-	t.length = l
 	return t
 }
 
 func (t *SubTemplateMultiList) Length() uint16 {
 	var length uint16
 	for _, rr := range t.value {
-		length += 4 // Template ID of this particular set of records + its length
-		for _, r := range rr.Values {
-			length += r.Length()
-		}
+		length += rr.length()
 	}
 	return length + 1 // include 1 byte for semantics here
 }
@@ -104,13 +112,17 @@ func (*SubTemplateMultiList) DefaultLength() uint16 {
 }
 
 func (t *SubTemplateMultiList) Clone() DataType {
-	vs := make([]subTemplateListContent, 0)
-	for _, el := range t.value {
-		vs = append(vs, el.Clone())
+	var vs []subTemplateListContent
+	if t.value != nil {
+		vs = make([]subTemplateListContent, 0, len(t.value))
+		for _, el := range t.value {
+			vs = append(vs, el.Clone())
+		}
 	}
 	return &SubTemplateMultiList{
 		semantic:            t.semantic,
 		templateManager:     t.templateManager,
+		fieldManager:        t.fieldManager,
 		observationDomainId: t.observationDomainId,
 		length:              t.length,
 		value:               vs,
@@ -123,8 +135,8 @@ func (t *SubTemplateMultiList) WithLength(length uint16) DataTypeConstructor {
 	}
 }
 
-// TODO(zoomoid): check if this is safely done with just the length of the elements,
-// or if we need to include "headers" of each subtemplate as well
+// SetLength sets the length of the entire list, i.e., including the semantic and the
+// headers of each sub template, which bounds the list during Decode
 func (t *SubTemplateMultiList) SetLength(length uint16) DataType {
 	t.length = length
 	return t
@@ -147,46 +159,69 @@ func (t *SubTemplateMultiList) Elements() []subTemplateListContent {
 	return t.value
 }
 
+var (
+	// subTemplateMultiListContentHeaderLength is the number of bytes preceding each sub template's
+	// records in a subTemplateMultiList, i.e., template id (uint16) and length (uint16)
+	subTemplateMultiListContentHeaderLength uint16 = 4
+)
+
+// Decode reads the entire subTemplateMultiList from r. The list's length must be set beforehand, either
+// by the template or by the enclosing variable-length field. Each sub template section is decoded only
+// within the bounds of its own length field, such that sections with different templates do not overrun
+// each other. Decode returns the number of bytes consumed from r.
 func (t *SubTemplateMultiList) Decode(r io.Reader) (n int, err error) {
-	err = binary.Read(r, binary.BigEndian, &t.semantic)
-	if err != nil {
-		return n, fmt.Errorf("failed to read list semantic in %T, %w", t, err)
+	if t.length < 1 {
+		return n, malformedMessage(n, "%T list length %d is shorter than its header", t, t.length)
 	}
 
-	// exhaust the previously sliced buffer
-	lb := make([]byte, t.length-1) // already read one byte of the list buffer for the semantic
-	m, err := r.Read(lb)
+	// read the entire list at once, the semantic is its first byte
+	lb := make([]byte, t.length)
+	m, err := io.ReadFull(r, lb)
 	n += m
 	if err != nil {
-		return n, fmt.Errorf("failed to read length in %T, %w", t, err)
+		return n, fmt.Errorf("failed to read list content in %T, %w", t, err)
 	}
-	listBuffer := bytes.NewBuffer(lb)
+	t.semantic = ListSemantic(lb[0])
+	listBuffer := bytes.NewBuffer(lb[1:])
 
-	for listBuffer.Len() > 0 {
-		var subTemplateId, subTemplateLength uint16
+	state, depth := decodeStateOf(r)
+	if err := state.enterList(depth + 1); err != nil {
+		return n, fmt.Errorf("failed to decode %T, %w", t, err)
+	}
+	// records counts the records of all sub templates against the limit of list elements
+	records := 0
 
-		err = binary.Read(listBuffer, binary.BigEndian, &subTemplateId)
-		if err != nil {
-			return n, fmt.Errorf("failed to read sub template id in %T, %w", t, err)
+	t.value = make([]subTemplateListContent, 0)
+	for i := 0; listBuffer.Len() > 0; i++ {
+		if listBuffer.Len() < int(subTemplateMultiListContentHeaderLength) {
+			return n, malformedMessage(int(t.length)-listBuffer.Len(), "header of sub template %d in %T is truncated to %d bytes", i, t, listBuffer.Len())
 		}
+		subTemplateId := binary.BigEndian.Uint16(listBuffer.Next(2))
+		subTemplateLength := binary.BigEndian.Uint16(listBuffer.Next(2))
 
-		if listBuffer.Len() == 0 {
-			// no elements in subTemplateMultiList, abort...
-			break
+		if subTemplateLength < subTemplateMultiListContentHeaderLength {
+			return n, malformedMessage(int(t.length)-listBuffer.Len(), "illegal length %d of sub template %d (%d) in %T", subTemplateLength, i, subTemplateId, t)
 		}
-
-		err = binary.Read(listBuffer, binary.BigEndian, &subTemplateLength)
-		if err != nil {
-			return n, fmt.Errorf("failed to read sub template length in %T, %w", t, err)
+		contentLength := int(subTemplateLength - subTemplateMultiListContentHeaderLength)
+		if contentLength > listBuffer.Len() {
+			return n, malformedMessage(int(t.length)-listBuffer.Len(), "length %d of sub template %d (%d) exceeds remaining list length %d in %T", subTemplateLength, i, subTemplateId, listBuffer.Len(), t)
 		}
 
 		s := subTemplateListContent{
 			TemplateId: subTemplateId,
 			Length:     subTemplateLength,
+			Values:     make([]DataRecord, 0),
+		}
+
+		// slice off exactly this sub template's records
+		section := bytes.NewBuffer(listBuffer.Next(contentLength))
+		if section.Len() == 0 {
+			t.value = append(t.value, s)
+			continue
 		}
 
 		if t.templateManager == nil {
-			return n, fmt.Errorf("failed to get template (%d,%d), manager is nil", t.observationDomainId, subTemplateId)
+			return n, fmt.Errorf("failed to get template (%d,%d), manager is nil, %w", t.observationDomainId, subTemplateId, ErrTemplateNotFound)
 		}
 
 		tmpl, err := t.templateManager.Get(context.TODO(), TemplateKey{
@@ -197,24 +232,37 @@ func (t *SubTemplateMultiList) Decode(r io.Reader) (n int, err error) {
 			return n, fmt.Errorf("failed to get template (%d,%d) from manager in %T, %w", t.observationDomainId, subTemplateId, t, err)
 		}
 
-		records := make([]DataRecord, 0)
-		for listBuffer.Len() > 0 {
-			dr := DataRecord{}
-			m, err := dr.With(tmpl).Decode(listBuffer)
-			n += m
-			if err != nil && err != io.EOF {
-				return n, err
-			}
-			records = append(records, dr)
-			if err == io.EOF {
+		elements := state.nest(section, depth+1)
+		minLength := minRecordLength(tmpl)
+		for section.Len() > 0 {
+			if section.Len() < minLength {
+				// trailing bytes shorter than any record are padding
 				break
 			}
+			records++
+			if err := state.addListElement(records); err != nil {
+				return n, fmt.Errorf("failed to decode record of sub template %d (%d) in %T, %w", i, subTemplateId, t, err)
+			}
+			dr := DataRecord{
+				TemplateId: subTemplateId,
+			}
+			m, err := dr.With(tmpl).Decode(elements)
+			if err != nil {
+				if err == io.EOF {
+					return n, malformedMessage(n, "record %d of sub template %d (%d) in %T is truncated", len(s.Values), i, subTemplateId, t)
+				}
+				return n, fmt.Errorf("failed to decode record of sub template %d (%d) in %T, %w", i, subTemplateId, t, err)
+			}
+			if m == 0 {
+				// records of templates consisting only of zero-length fields would never exhaust the section
+				return n, malformedMessage(n, "records of sub template %d (%d) in %T are empty", i, subTemplateId, t)
+			}
+			s.Values = append(s.Values, dr)
 		}
-		s.Values = records
 
 		t.value = append(t.value, s)
 	}
-	return n, io.EOF
+	return n, nil
 }
 
 func (t *SubTemplateMultiList) Encode(w io.Writer) (n int, err error) {
@@ -237,7 +285,7 @@ func (t *SubTemplateMultiList) Encode(w io.Writer) (n int, err error) {
 			return n, err
 		}
 		l = make([]byte, 2)
-		binary.BigEndian.PutUint16(l, drs.Length)
+		binary.BigEndian.PutUint16(l, drs.length())
 		ln, err = w.Write(l)
 		n += ln
 		if err != nil {
@@ -278,21 +326,36 @@ func (t *SubTemplateMultiList) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// unmarshalledSubTemplateMultiList defers unmarshalling the records of each sub template until
+// the caches of the list are known to restore their fields with
+type unmarshalledSubTemplateMultiList struct {
+	Metadata subTemplateMultiListMetadata `json:"metadata"`
+	Records  []struct {
+		TemplateId uint16          `json:"template_id"`
+		Values     json.RawMessage `json:"values"`
+	} `json:"records,omitempty"`
+}
+
+// UnmarshalJSON restores the list and the records of its sub templates. The fields of the records,
+// including nested structured data types, are restored with the FieldCache and TemplateCache of
+// the list, e.g., the caches of the field builder the list was created with.
 func (t *SubTemplateMultiList) UnmarshalJSON(in []byte) error {
-	s := &marshalledSubTemplateMultiList{}
+	s := &unmarshalledSubTemplateMultiList{}
 	err := json.Unmarshal(in, s)
 	if err != nil {
 		return err
 	}
-	t.value = s.Records
-	l := uint16(0)
-	for _, e := range t.value {
-		for _, dr := range e.Values {
-			// better computed length than before assuming e.Length being set
-			l += dr.Length()
+	t.value = make([]subTemplateListContent, 0, len(s.Records))
+	for _, content := range s.Records {
+		records, err := unmarshalRecordsJSON(content.Values, t.fieldManager, t.templateManager)
+		if err != nil {
+			return fmt.Errorf("failed to restore records of sub template %d of %T, %w", content.TemplateId, t, err)
 		}
+		c := subTemplateListContent{TemplateId: content.TemplateId, Values: records}
+		c.Length = c.length()
+		t.value = append(t.value, c)
 	}
-	t.length = l
+	t.length = t.Length()
 
 	t.semantic = s.Metadata.Semantic
 	t.observationDomainId = s.Metadata.ObservationDomainId
@@ -305,6 +368,17 @@ type subTemplateListContent struct {
 	Values     []DataRecord `json:"values" yaml:"values"`
 }
 
+// length computes the number of bytes of the sub template's records including its header
+// of template id and length. Encode writes this instead of Length, which may be stale
+// after the records were modified.
+func (c *subTemplateListContent) length() uint16 {
+	l := subTemplateMultiListContentHeaderLength
+	for _, dr := range c.Values {
+		l += dr.Length()
+	}
+	return l
+}
+
 var _ json.Marshaler = &subTemplateListContent{}
 var _ json.Unmarshaler = &subTemplateListContent{}
 var _ fmt.Stringer = &subTemplateListContent{}
@@ -314,9 +388,12 @@ func (s *subTemplateListContent) Len() int {
 }
 
 func (s *subTemplateListContent) Clone() subTemplateListContent {
-	vs := make([]DataRecord, 0)
-	for _, el := range s.Values {
-		vs = append(vs, el.Clone())
+	var vs []DataRecord
+	if s.Values != nil {
+		vs = make([]DataRecord, 0, len(s.Values))
+		for _, el := range s.Values {
+			vs = append(vs, el.Clone())
+		}
 	}
 
 	return subTemplateListContent{
@@ -334,12 +411,16 @@ func (s *subTemplateListContent) String() string {
 	return fmt.Sprintf("SubTemplate(%d/%d)[%s]", s.TemplateId, s.Len(), strings.Join(drs, " "))
 }
 
+// subTemplateListContentJSON has the fields, but not the methods of subTemplateListContent, such
+// that (un)marshalling it does not recurse into MarshalJSON and UnmarshalJSON again
+type subTemplateListContentJSON subTemplateListContent
+
 func (s *subTemplateListContent) MarshalJSON() ([]byte, error) {
-	return json.Marshal(s)
+	return json.Marshal((*subTemplateListContentJSON)(s))
 }
 
 func (s *subTemplateListContent) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, s)
+	return json.Unmarshal(in, (*subTemplateListContentJSON)(s))
 }
 
 type subTemplateMultiListBuilder struct {
@@ -368,6 +449,7 @@ func (t *subTemplateMultiListBuilder) Complete() DataTypeConstructor {
 	return func() DataType {
 		return &SubTemplateMultiList{
 			templateManager:     t.templateManager,
+			fieldManager:        t.fieldManager,
 			observationDomainId: t.observationDomainId,
 			semantic:            SemanticUndefined,
 		}
@@ -0,0 +1,235 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSubTemplateMultiList(t *testing.T) {
+	t.Run("Decode with two different sub templates", func(t *testing.T) {
+		ctx := context.Background()
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+
+		fieldOf := func(id uint16, length uint16) Field {
+			fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fb.SetLength(length).Complete()
+		}
+
+		templates := []*TemplateRecord{
+			{
+				TemplateId: 300,
+				FieldCount: 2,
+				Fields: []Field{
+					fieldOf(8, 4), // sourceIPv4Address
+					fieldOf(7, 2), // sourceTransportPort
+				},
+			},
+			{
+				TemplateId: 301,
+				FieldCount: 1,
+				Fields: []Field{
+					fieldOf(1, 8), // octetDeltaCount
+				},
+			},
+		}
+		for _, tr := range templates {
+			err := templateCache.Add(ctx, TemplateKey{TemplateId: tr.TemplateId}, &Template{
+				TemplateMetadata: &TemplateMetadata{
+					TemplateId:        tr.TemplateId,
+					CreationTimestamp: time.Now(),
+				},
+				Record: tr,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fixture := []byte{
+			0x03, // semantic allOf
+			// sub template 300 with two records of 6 bytes each
+			0x01, 0x2c, 0x00, 0x10,
+			192, 0, 2, 1, 0x01, 0xbb,
+			192, 0, 2, 2, 0x00, 0x50,
+			// sub template 301 with one record of 8 bytes
+			0x01, 0x2d, 0x00, 0x0c,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0xdc,
+		}
+		trailer := []byte{0xca, 0xfe}
+
+		stml := NewDefaultSubTemplateMultiList().(*SubTemplateMultiList).
+			NewBuilder().
+			WithTemplateCache(templateCache).
+			WithFieldCache(fieldCache).
+			Complete()().
+			SetLength(uint16(len(fixture))).(*SubTemplateMultiList)
+
+		r := bytes.NewBuffer(append(append([]byte{}, fixture...), trailer...))
+		n, err := stml.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(fixture) {
+			t.Errorf("expected %d bytes consumed, got %d", len(fixture), n)
+		}
+		if !bytes.Equal(r.Bytes(), trailer) {
+			t.Errorf("expected trailing bytes to remain untouched, got %v", r.Bytes())
+		}
+		if stml.Semantic() != SemanticAllOf {
+			t.Errorf("expected semantic allOf, got %s", stml.Semantic())
+		}
+
+		els := stml.Elements()
+		if len(els) != 2 {
+			t.Fatalf("expected 2 sub template sections, got %d", len(els))
+		}
+		if els[0].TemplateId != 300 || len(els[0].Values) != 2 {
+			t.Fatalf("expected 2 records of template 300, got %d of %d", len(els[0].Values), els[0].TemplateId)
+		}
+		if els[1].TemplateId != 301 || len(els[1].Values) != 1 {
+			t.Fatalf("expected 1 record of template 301, got %d of %d", len(els[1].Values), els[1].TemplateId)
+		}
+
+		if v, ok := els[0].Values[1].IP("sourceIPv4Address"); !ok || v != netip.MustParseAddr("192.0.2.2") {
+			t.Errorf("expected 192.0.2.2, got %s", v)
+		}
+		if v, ok := els[0].Values[1].Uint64("sourceTransportPort"); !ok || v != 80 {
+			t.Errorf("expected port 80, got %d", v)
+		}
+		if v, ok := els[1].Values[0].Uint64("octetDeltaCount"); !ok || v != 1500 {
+			t.Errorf("expected 1500 octets, got %d", v)
+		}
+		if stml.Length() != uint16(len(fixture)) {
+			t.Errorf("expected length %d, got %d", len(fixture), stml.Length())
+		}
+	})
+
+	t.Run("Decode rejects overlong sub template length", func(t *testing.T) {
+		fixture := []byte{0x03, 0x01, 0x2c, 0x00, 0x20, 0x00}
+		stml := NewDefaultSubTemplateMultiList().SetLength(uint16(len(fixture)))
+		_, err := stml.Decode(bytes.NewBuffer(fixture))
+		if err == nil {
+			t.Error("expected error for sub template length exceeding the list")
+		}
+	})
+	t.Run("byte counts propagate through nested lists", func(t *testing.T) {
+		ctx := context.Background()
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+
+		fieldOf := func(id uint16, length uint16) Field {
+			fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fb.SetLength(length).SetFieldManager(fieldCache).SetTemplateManager(templateCache).Complete()
+		}
+
+		// template 302 of sourceTransportPort and a variable-length basicList of interfaceName
+		err := templateCache.Add(ctx, TemplateKey{TemplateId: 302}, &Template{
+			TemplateMetadata: &TemplateMetadata{TemplateId: 302, CreationTimestamp: time.Now()},
+			Record: &TemplateRecord{
+				TemplateId: 302,
+				FieldCount: 2,
+				Fields:     []Field{fieldOf(7, 2), fieldOf(291, VariableLength)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		interfaces := []byte{0x04, 0x00, 0x52, 0xff, 0xff, 0x04, 'e', 't', 'h', '0', 0x02, 'l', 'o'}
+		record := append([]byte{0x01, 0xbb, byte(len(interfaces))}, interfaces...)
+		list := append([]byte{0x03, 0x01, 0x2e, 0x00, byte(4 + 2*len(record))}, record...)
+		list = append(list, record...)
+		in := append([]byte{byte(len(list))}, list...)
+		trailer := []byte{0xca, 0xfe}
+
+		f := fieldOf(293, VariableLength)
+		r := bytes.NewBuffer(append(append([]byte{}, in...), trailer...))
+		n, err := f.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(in) {
+			t.Errorf("expected %d bytes consumed, got %d", len(in), n)
+		}
+		if !bytes.Equal(r.Bytes(), trailer) {
+			t.Errorf("expected trailing bytes to remain untouched, got %v", r.Bytes())
+		}
+		if int(f.Length()) != len(in) {
+			t.Errorf("expected field length %d, got %d", len(in), f.Length())
+		}
+
+		stml := f.Value().(*SubTemplateMultiList)
+		els := stml.Elements()
+		if len(els) != 1 || len(els[0].Values) != 2 {
+			t.Fatalf("expected 2 records of template 302, got %v", els)
+		}
+		for _, dr := range els[0].Values {
+			if int(dr.Length()) != len(record) {
+				t.Errorf("expected record length %d, got %d", len(record), dr.Length())
+			}
+		}
+
+		m, err := stml.SetLength(uint16(len(list))).Decode(bytes.NewBuffer(list))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != len(list) {
+			t.Errorf("expected list to consume %d bytes, got %d", len(list), m)
+		}
+	})
+
+	t.Run("JSON round trip", func(t *testing.T) {
+		port := NewFieldBuilder(&InformationElement{
+			Id:          7,
+			Name:        "sourceTransportPort",
+			Constructor: NewUnsigned16,
+		}).SetLength(2).Complete().SetValue(uint16(443))
+
+		stml := NewDefaultSubTemplateMultiList().SetValue([]subTemplateListContent{
+			{TemplateId: 300, Values: []DataRecord{{TemplateId: 300, FieldCount: 1, Fields: []Field{port}}}},
+		})
+		b, err := json.Marshal(stml)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := &SubTemplateMultiList{}
+		if err := json.Unmarshal(b, restored); err != nil {
+			t.Fatal(err)
+		}
+		els := restored.Elements()
+		if len(els) != 1 || els[0].TemplateId != 300 || len(els[0].Values) != 1 {
+			t.Fatalf("expected 1 record of template 300, got %v", els)
+		}
+		if v, ok := els[0].Values[0].Uint64("sourceTransportPort"); !ok || v != 443 {
+			t.Errorf("expected port 443, got %d", v)
+		}
+	})
+}
@@ -17,28 +17,120 @@ limitations under the License.
 package ipfix
 
 import (
-	"bytes"
+	"bufio"
 	"context"
-	"encoding/binary"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 type TCPListener struct {
 	bindAddr string
 	packetCh chan []byte
 
+	// packets is used instead of packetCh if not nil, such that consumers such as the Collector
+	// receive the address of the exporter next to each message
+	packets chan packet
+
 	addr     *net.TCPAddr
 	listener *net.TCPListener
+
+	metrics *Metrics
+
+	// idleTimeout is the duration after which a connection is closed if no bytes were received.
+	// If zero, connections never time out.
+	idleTimeout time.Duration
+
+	// maxMessageLength is the maximum length of IPFIX messages accepted on a connection
+	maxMessageLength uint16
+
+	// connections limits the number of concurrent connections, if not nil
+	connections chan struct{}
+
+	// tlsConfig is used for wrapping accepted connections in TLS, if not nil
+	tlsConfig *tls.Config
+
+	// endpoints tracks the statistics and sessions of remote endpoints, if not nil
+	endpoints *endpointRegistry
+}
+
+// TCPListenerOption configures a TCPListener created with NewTCPListener
+type TCPListenerOption func(*TCPListener)
+
+// WithTCPIdleTimeout closes connections on which no bytes were received for the given duration.
+// A duration of zero disables the timeout, which is the default.
+func WithTCPIdleTimeout(d time.Duration) TCPListenerOption {
+	return func(l *TCPListener) {
+		l.idleTimeout = d
+	}
+}
+
+// WithTCPMaxConnections limits the number of connections handled concurrently by the listener.
+// Connections exceeding the limit are closed immediately after being accepted. A limit of zero
+// or less disables the limit, which is the default.
+func WithTCPMaxConnections(n int) TCPListenerOption {
+	return func(l *TCPListener) {
+		if n <= 0 {
+			l.connections = nil
+			return
+		}
+		l.connections = make(chan struct{}, n)
+	}
+}
+
+// WithTCPMaxMessageLength limits the length of IPFIX messages accepted by the listener. Connections
+// announcing longer messages are closed before the message body is read. By default, all messages
+// up to the maximum length of 65535 bytes are accepted.
+func WithTCPMaxMessageLength(length uint16) TCPListenerOption {
+	return func(l *TCPListener) {
+		l.maxMessageLength = length
+	}
 }
 
-func NewTCPListener(bindAddr string) *TCPListener {
-	return &TCPListener{
-		bindAddr: bindAddr,
-		packetCh: make(chan []byte, tcpChannelBufferSize),
+// WithTCPEndpointStats tracks the statistics and the session state of each remote endpoint, which
+// are returned by Endpoints and reported in the endpoint metrics of the listener's Metrics.
+// Endpoints are evicted once their connection is closed and no message was received for
+// idleTimeout, an idleTimeout of zero disables eviction. At most maxEndpoints endpoints are
+// tracked, further endpoints are combined into a single endpoint "other". A maxEndpoints of zero
+// disables the limit.
+func WithTCPEndpointStats(maxEndpoints int, idleTimeout time.Duration) TCPListenerOption {
+	return func(l *TCPListener) {
+		l.endpoints = newEndpointRegistry(l.bindAddr, maxEndpoints, idleTimeout)
+		l.endpoints.metrics = l.metrics
+	}
+}
+
+func NewTCPListener(bindAddr string, opts ...TCPListenerOption) *TCPListener {
+	l := &TCPListener{
+		bindAddr:         bindAddr,
+		packetCh:         make(chan []byte, tcpChannelBufferSize),
+		maxMessageLength: 0xFFFF,
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
+	return l
+}
+
+// WithMetrics makes the listener report to the given metrics labeled with its bind address
+// instead of the deprecated package-level collectors.
+func (l *TCPListener) WithMetrics(m *Metrics) *TCPListener {
+	l.metrics = m
+	if l.endpoints != nil {
+		l.endpoints.metrics = m
+	}
+	return l
+}
+
+// Endpoints returns the statistics of the remote endpoints of the listener ordered by endpoint,
+// including the session state of open connections. It returns nil for listeners created without
+// WithTCPEndpointStats.
+func (l *TCPListener) Endpoints() []EndpointStats {
+	return l.endpoints.snapshot()
 }
 
 func (l *TCPListener) Listen(ctx context.Context) (err error) {
@@ -54,89 +146,199 @@ func (l *TCPListener) Listen(ctx context.Context) (err error) {
 	}
 	defer l.listener.Close()
 
-	// async tcp handler function
+	acceptDone := make(chan struct{})
 	go func() {
-		for {
-			if l.listener == nil {
+		defer close(acceptDone)
+		l.accept(ctx, l.listener)
+	}()
+
+	logger.Info("Started TCP listener", "addr", l.bindAddr)
+
+	select {
+	case <-ctx.Done():
+	case <-acceptDone:
+	}
+	logger.Info("Shutting down TCP listener", "addr", l.addr)
+
+	// unblock the accept loop and wait for it to exit
+	l.listener.Close()
+	<-acceptDone
+	return nil
+}
+
+// accept accepts connections of ln and handles them until ln is closed or ctx is cancelled. Other
+// errors, e.g., running out of file descriptors under load, are transient: accepting is retried
+// with an exponential backoff, like net/http.Server does, instead of shutting down the listener.
+func (l *TCPListener) accept(ctx context.Context, ln interface{ Accept() (net.Conn, error) }) {
+	logger := FromContext(ctx)
+
+	var backoff time.Duration
+	for {
+		conn, rerr := ln.Accept()
+		if rerr != nil {
+			if errors.Is(rerr, net.ErrClosed) {
 				return
 			}
-			conn, rerr := l.listener.Accept()
-			TCPActiveConnections.Inc()
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
-				ErrorsTotal.Inc()
-				logger.Error(err, "failed to accept TCP connection", "addr", l.addr)
-				err = rerr
+			l.metrics.tcpErrorsTotal(l.bindAddr).Inc()
+			backoff *= 2
+			if backoff == 0 {
+				backoff = minAcceptBackoff
+			}
+			if backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			logger.Error(rerr, "failed to accept TCP connection, retrying", "addr", l.bindAddr, "backoff", backoff)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-ctx.Done():
 				return
 			}
+		}
+		backoff = 0
+
+		if !l.acquire() {
+			l.metrics.tcpErrorsTotal(l.bindAddr).Inc()
+			logger.Info("rejected TCP connection, too many active connections", "remote_addr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
 
-			// handle each accepted connection in a separate goroutine for S C A L E
-			// IPFIX associates an entire TCP connection with a session. It may transmit more than
-			// one packet, and it may be kept alive during the entire exporting process (at least
-			// that is what yaf does).
-			go func(conn net.Conn) {
-				if conn == nil {
+		// handle each accepted connection in a separate goroutine for S C A L E
+		// IPFIX associates an entire TCP connection with a session. It may transmit more than
+		// one packet, and it may be kept alive during the entire exporting process (at least
+		// that is what yaf does). The active connections gauge is only incremented in handle,
+		// i.e., for successfully accepted connections.
+		go func(conn net.Conn) {
+			defer l.release()
+			if l.tlsConfig != nil {
+				remoteAddr := conn.RemoteAddr().String()
+				conn, err := l.handshake(ctx, conn)
+				if err != nil {
+					l.metrics.tcpErrorsTotal(l.bindAddr).Inc()
+					logger.Error(err, "rejected TLS connection", "remote_addr", remoteAddr)
 					return
 				}
+				l.handle(ctx, conn)
+				return
+			}
+			l.handle(ctx, conn)
+		}(conn)
+	}
+}
 
-				// initiate close after being done reading
-				defer logger.V(3).Info("tcp: closed connection")
-				defer TCPActiveConnections.Dec()
-				defer conn.Close()
-
-				var rerr error
-				defer func() {
-					if rerr != nil {
-						ErrorsTotal.Inc()
-					}
-				}()
-
-				// instantiate a new session from the connection to receive packets from
-				session := newSessionFromConnection(conn)
-				logger.V(3).Info("starting new session from TCP connection", "source", conn.RemoteAddr().String())
-				errorCh := make(chan error)
-
-				// run this loop indefinitely in a goroutine to not block. The session resets internally
-				// and will be reused for subsequent packets.
-				go func() {
-					for {
-						err := session.receive(ctx)
-						if err != nil {
-							errorCh <- err
-							return
-						}
-					}
-				}()
-
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					case err := <-errorCh:
-						if errors.Is(err, io.EOF) {
-							logger.V(1).Info("connection closed by remote", "remote_addr", conn.RemoteAddr().String())
-						} else {
-							logger.Error(err, "failed to read IPFIX packet", "remote_addr", conn.RemoteAddr().String())
-						}
-						return
-					case packet := <-session.messages():
-						// write packet to event source channel
-						TCPReceivedBytes.Add(float64(len(packet)))
-						logger.V(3).Info("wrote IPFIX packet to event source channel", "length", len(packet))
-						l.packetCh <- packet
-					}
-				}
-			}(conn)
+const (
+	// minAcceptBackoff and maxAcceptBackoff bound the delay before accepting again after an error
+	minAcceptBackoff time.Duration = 5 * time.Millisecond
+	maxAcceptBackoff time.Duration = time.Second
+)
+
+// acquire reserves a slot for a new connection. It returns false if the maximum number of
+// concurrent connections is reached
+func (l *TCPListener) acquire() bool {
+	if l.connections == nil {
+		return true
+	}
+	select {
+	case l.connections <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot of a closed connection
+func (l *TCPListener) release() {
+	if l.connections == nil {
+		return
+	}
+	<-l.connections
+}
+
+// handle reads IPFIX messages from a single connection until the connection is closed by the remote,
+// fails, times out, or ctx is cancelled. handle closes the connection before returning.
+func (l *TCPListener) handle(ctx context.Context, conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	logger := FromContext(ctx)
+
+	l.metrics.tcpActiveConnections(l.bindAddr).Inc()
+
+	// initiate close after being done reading
+	defer logger.V(3).Info("tcp: closed connection")
+	defer l.metrics.tcpActiveConnections(l.bindAddr).Dec()
+	defer conn.Close()
+
+	// instantiate a new session from the connection to receive packets from
+	session := newSessionFromConnection(conn)
+	session.idleTimeout = l.idleTimeout
+	session.maxMessageLength = l.maxMessageLength
+	logger.V(3).Info("starting new session from TCP connection", "source", conn.RemoteAddr().String())
+
+	endpoint := endpointOf(conn.RemoteAddr())
+	l.endpoints.connect(endpoint, session)
+	defer l.endpoints.disconnect(endpoint, session)
+
+	if l.packets != nil {
+		// consumers release the state of the transport session once the connection is closed
+		defer func() {
+			select {
+			case l.packets <- packet{exporter: exporterAddrPort(conn.RemoteAddr()), closed: true}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// buffered such that the goroutine below can always exit, even if handle already returned
+	errorCh := make(chan error, 1)
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// run this loop indefinitely in a goroutine to not block. The session is reused for subsequent packets.
+	go func() {
+		for {
+			err := session.receive(sessionCtx)
+			if err != nil {
+				errorCh <- err
+				return
+			}
 		}
 	}()
 
-	logger.Info("Started TCP listener", "addr", l.bindAddr)
-
-	<-ctx.Done()
-	logger.Info("Shutting down TCP listener", "addr", l.addr)
-	return
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errorCh:
+			if errors.Is(err, io.EOF) {
+				logger.V(1).Info("connection closed by remote", "remote_addr", conn.RemoteAddr().String())
+			} else {
+				l.metrics.tcpErrorsTotal(l.bindAddr).Inc()
+				logger.Error(err, "failed to read IPFIX packet", "remote_addr", conn.RemoteAddr().String())
+			}
+			return
+		case packet := <-session.messages():
+			// write packet to event source channel
+			l.metrics.tcpReceivedBytes(l.bindAddr).Add(float64(len(packet)))
+			l.endpoints.observe(endpoint, len(packet))
+			logger.V(3).Info("wrote IPFIX packet to event source channel", "length", len(packet))
+			if l.packets != nil {
+				select {
+				case l.packets <- newPacket(conn.RemoteAddr(), packet):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case l.packetCh <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
 }
 
 func (l *TCPListener) Messages() <-chan []byte {
@@ -145,25 +347,40 @@ func (l *TCPListener) Messages() <-chan []byte {
 
 var (
 	tcpChannelBufferSize int = 10
-
-	// ipfixMessageHeaderLength is the number of bytes in an IPFIX packet header
-	ipfixMessageHeaderLength uint16 = 16
 )
 
 type session struct {
-	offset uint16
-	length uint16
-
 	messageCh chan []byte
-	message   bytes.Buffer
 
-	reader io.Reader
+	// reader buffers the connection, such that bytes of subsequent messages received in
+	// a single read are retained for the next message
+	reader *bufio.Reader
+
+	// conn is used for setting read deadlines, it is nil for sessions not reading from a connection
+	conn net.Conn
+
+	idleTimeout      time.Duration
+	maxMessageLength uint16
+
+	// pending is the number of bytes read of the message currently being received, and completed
+	// the number of messages received entirely. Both are read concurrently by Endpoints.
+	pending   atomic.Uint64
+	completed atomic.Uint64
 }
 
 func newSessionFromConnection(conn net.Conn) *session {
+	s := newSession(conn)
+	s.conn = conn
+	return s
+}
+
+// newSession creates a session reading a stream of IPFIX messages from r, e.g., a reassembled
+// TCP stream from a packet capture
+func newSession(r io.Reader) *session {
 	return &session{
-		messageCh: make(chan []byte),
-		reader:    conn,
+		messageCh:        make(chan []byte),
+		reader:           bufio.NewReader(r),
+		maxMessageLength: 0xFFFF,
 	}
 }
 
@@ -171,141 +388,45 @@ func (s *session) messages() <-chan []byte {
 	return s.messageCh
 }
 
-// receive successively reads from the connection's reader to piece together a message
+// receive reads a single message from the connection and passes it to the message channel
 func (s *session) receive(ctx context.Context) error {
 	logger := FromContext(ctx)
-	// working on header bytes
-	if s.offset < ipfixMessageHeaderLength {
-		_, err := s.receiveHeader()
-		if err != nil {
-			return err
-		}
-
-		if s.offset < ipfixMessageHeaderLength {
-			// still too little bytes, will call this method again from the connection
-			return nil
-		}
-	}
 
-	_, err := s.receiveBody()
+	buf, err := readMessage(s, s.maxMessageLength)
 	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("session closed unexpectedly: %w", err)
+		}
 		return err
 	}
-
-	if s.offset < s.length {
-		// not done with body yet, next call to Receive will advance the reading
-		return nil
+	message := buf.Bytes()
+	s.pending.Store(0)
+	s.completed.Add(1)
+
+	select {
+	case s.messageCh <- message:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	s.messageCh <- s.message.Bytes()
-
-	// since messageCh is unbuffered, when the above unblocks, the buffer has been consumed by the handler
-	// to be passed on to the EventSource. Afterwards, we can reset all internal fields for re-use
-	s.length = 0
-	s.offset = 0
-	s.message.Reset()
-
-	logger.V(3).Info("session: cleaning up session after received ipfix message for reuse")
+	logger.V(3).Info("session: received ipfix message", "length", len(message))
 	return nil
 }
 
-func (s *session) receiveHeader() (int, error) {
-	var remains uint16 = ipfixMessageHeaderLength
-	var offset uint16
-
-	headerBuffer := &bytes.Buffer{}
-
-	// if this method was already called but not enough bytes for a header were read, this is true...
-	if s.offset > 0 {
-		// there's already stuff in the buffer, copy it into an ipfixHeader struct
-		remains = ipfixMessageHeaderLength - s.offset // update "remains" such that we don't exceed the header when reading from the socket
-		_, err := headerBuffer.ReadFrom(&s.message)   // the buffer abstraction here makes it easy to do this multiple times
+// Read reads from the connection. If the session has an idle timeout, the deadline is
+// extended for each read such that only connections not sending anything time out.
+func (s *session) Read(b []byte) (int, error) {
+	if s.idleTimeout > 0 && s.conn != nil {
+		err := s.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
 		if err != nil {
-			return -1, fmt.Errorf("failed to read from message buffer into header buffer, %w", err)
+			return 0, fmt.Errorf("failed to set read deadline, %w", err)
 		}
 	}
-
-	// only read "remaining bytes upto a full header"
-	b := make([]byte, remains)
-	len, err := s.reader.Read(b)
-	if len == 0 {
-		if offset > 0 {
-			return len, fmt.Errorf("session closed unexpectedly: %w", err)
-		}
-		if errors.Is(err, io.EOF) {
-			return len, err
-		}
-		return len, fmt.Errorf("failed to read from socket: %w", err)
-	}
-	_, err = headerBuffer.Write(b)
-	if err != nil {
-		return len, fmt.Errorf("failed to write into header buffer: %w", err)
-	}
-
-	offset += uint16(len)
-
-	if offset < ipfixMessageHeaderLength {
-		// not a whole header yet...
-		// copy the internal header buffer which contains all read bytes for the header up to now
-		// into the message of the session
-		// reset the message buffer and write the currently read header bytes to it
-		s.message.Reset()
-		_, err = s.message.ReadFrom(headerBuffer)
-		if err != nil {
-			return -1, fmt.Errorf("failed to write from header buffer to message: %w", err)
-		}
-		// s.message = bytes.NewBuffer(headerBuffer.Bytes())
-		s.offset = offset
-		s.length = offset
-		return len, nil
-	}
-	// now we have the full header
-	b = headerBuffer.Bytes()
-
-	// bytes 0 and 1 are "version" but we don't need that here
-	msgLength := binary.BigEndian.Uint16(b[2:4])
-	if err != nil {
-		return len, fmt.Errorf("failed to read packet length from header buffer, %w", err)
+	n, err := s.reader.Read(b)
+	s.pending.Add(uint64(n))
+	if err != nil && err != io.EOF {
+		// io.EOF is returned unwrapped, as readers such as io.ReadFull compare it with ==
+		return n, fmt.Errorf("failed to read from socket: %w", err)
 	}
-	// we've read the first 4 bytes of the headerBuffer, we need to reset the offset to be
-	// able to read from it in its entirety
-
-	// reset the entire message buffer and write the current header bytes to it
-	s.message.Reset()
-	_, err = s.message.Write(b)
-	if err != nil {
-		return len, fmt.Errorf("failed to write from header buffer to message, %w", err)
-	}
-
-	// s.message = bytes.NewBuffer(headerBuffer.Bytes())
-	s.offset = ipfixMessageHeaderLength
-	s.length = msgLength
-
-	return len, nil
-}
-
-func (s *session) receiveBody() (int, error) {
-	remains := s.length - s.offset
-
-	if remains == 0 {
-		// IPFIX message without a body
-		return 0, nil
-	}
-
-	b := make([]byte, remains)
-	len, err := s.reader.Read(b)
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return len, err
-		} else {
-			return len, fmt.Errorf("session closed unexpectedly: %w", err)
-		}
-	}
-	_, err = s.message.Write(b[:len]) // only write the newly read portion of the buffer to the message buffer, otherwise this adds 0s
-	if err != nil {
-		return len, fmt.Errorf("failed to write read bytes to message buffer, %w", err)
-	}
-	s.offset += uint16(len)
-
-	return len, nil
+	return n, err
 }
@@ -0,0 +1,289 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTCPListener(t *testing.T) {
+	// newTestListener creates a listener with metrics and a handled connection, and returns
+	// the client side of the connection and a channel closed once the handler returned
+	newTestListener := func(t *testing.T, ctx context.Context, opts ...TCPListenerOption) (*TCPListener, net.Conn, <-chan struct{}) {
+		metrics := NewMetrics()
+		err := metrics.Register(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := NewTCPListener("test", opts...).WithMetrics(metrics)
+
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			l.handle(ctx, server)
+		}()
+		return l, client, done
+	}
+
+	receive := func(t *testing.T, l *TCPListener) []byte {
+		select {
+		case msg := <-l.Messages():
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+			return nil
+		}
+	}
+
+	waitClosed := func(t *testing.T, done <-chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for connection to be closed")
+		}
+	}
+
+	t.Run("back-to-back messages in a single write", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, client, done := newTestListener(t, ctx)
+
+		m1 := newTestDataMessage(256, 1)
+		m2 := newTestDataMessage(256, 4)
+		go client.Write(append(append([]byte{}, m1...), m2...))
+
+		if msg := receive(t, l); !bytes.Equal(msg, m1) {
+			t.Errorf("expected first message %v, found %v", m1, msg)
+		}
+		if msg := receive(t, l); !bytes.Equal(msg, m2) {
+			t.Errorf("expected second message %v, found %v", m2, msg)
+		}
+
+		client.Close()
+		waitClosed(t, done)
+		if c := testutil.ToFloat64(l.metrics.TCPErrorsTotal.WithLabelValues("test")); c != 0 {
+			t.Errorf("expected no errors on regular close, found %v", c)
+		}
+		if c := testutil.ToFloat64(l.metrics.TCPActiveConnections.WithLabelValues("test")); c != 0 {
+			t.Errorf("expected no active connections, found %v", c)
+		}
+	})
+
+	t.Run("slow sender", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, client, _ := newTestListener(t, ctx, WithTCPIdleTimeout(500*time.Millisecond))
+
+		m := newTestDataMessage(256, 2)
+		go func() {
+			for _, chunk := range [][]byte{m[:3], m[3:17], m[17:]} {
+				client.Write(chunk)
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+
+		if msg := receive(t, l); !bytes.Equal(msg, m) {
+			t.Errorf("expected message %v, found %v", m, msg)
+		}
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, client, done := newTestListener(t, ctx, WithTCPIdleTimeout(100*time.Millisecond))
+
+		go client.Write([]byte{0x00, 0x0a, 0x00})
+
+		waitClosed(t, done)
+		if c := testutil.ToFloat64(l.metrics.TCPErrorsTotal.WithLabelValues("test")); c != 1 {
+			t.Errorf("expected 1 error, found %v", c)
+		}
+		if c := testutil.ToFloat64(l.metrics.TCPActiveConnections.WithLabelValues("test")); c != 0 {
+			t.Errorf("expected no active connections, found %v", c)
+		}
+	})
+
+	t.Run("malformed header", func(t *testing.T) {
+		for name, header := range map[string][]byte{
+			"unknown version": {0x00, 0x09, 0x00, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			"short length":    {0x00, 0x0a, 0x00, 0x0f, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			"exceeds maximum": {0x00, 0x0a, 0x04, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				l, client, done := newTestListener(t, ctx, WithTCPMaxMessageLength(512))
+
+				go client.Write(header)
+
+				waitClosed(t, done)
+				if c := testutil.ToFloat64(l.metrics.TCPErrorsTotal.WithLabelValues("test")); c != 1 {
+					t.Errorf("expected 1 error, found %v", c)
+				}
+			})
+		}
+	})
+
+	t.Run("accept loop exits on close", func(t *testing.T) {
+		l := NewTCPListener("127.0.0.1:0")
+		before := runtime.NumGoroutine()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() { errCh <- l.Listen(ctx) }()
+
+		// give the accept loop time to block in Accept before shutting down
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for listener to exit")
+		}
+		if _, err := l.listener.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected listener to be closed, got %v", err)
+		}
+		if after := runtime.NumGoroutine(); after > before {
+			t.Errorf("expected no leaked goroutines, got %d before and %d after", before, after)
+		}
+	})
+
+	t.Run("accept loop retries after errors", func(t *testing.T) {
+		metrics := NewMetrics()
+		if err := metrics.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		l := NewTCPListener("test").WithMetrics(metrics)
+
+		client, server := net.Pipe()
+		defer client.Close()
+		ln := &scriptedAcceptor{
+			conns: []net.Conn{nil, nil, server},
+			errs:  []error{errors.New("too many open files"), errors.New("connection aborted"), nil},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			l.accept(ctx, ln)
+		}()
+
+		// the connection accepted after the errors is handled
+		if _, err := client.Write(newTestCollectorMessage(1700000000, 1, 256, []byte{0x01, 0xbb})); err != nil {
+			t.Fatal(err)
+		}
+		receive(t, l)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for accept loop to exit on close")
+		}
+		if c := testutil.ToFloat64(l.metrics.TCPErrorsTotal.WithLabelValues("test")); c != 2 {
+			t.Errorf("expected 2 accept errors, got %v", c)
+		}
+	})
+
+	t.Run("session state of endpoints", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, client, done := newTestListener(t, ctx, WithTCPEndpointStats(16, time.Minute))
+
+		// session returns the session state of the single endpoint once it satisfies ok
+		session := func(t *testing.T, ok func(*SessionStats) bool) *SessionStats {
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				stats := l.Endpoints()
+				if len(stats) == 1 && stats[0].Session != nil && ok(stats[0].Session) {
+					return stats[0].Session
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for session state, got %+v", stats)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		m := newTestDataMessage(256, 2)
+		if _, err := client.Write(m[:20]); err != nil {
+			t.Fatal(err)
+		}
+		session(t, func(s *SessionStats) bool { return s.PendingBytes == 20 && s.Messages == 0 })
+
+		go client.Write(m[20:])
+		if msg := receive(t, l); !bytes.Equal(msg, m) {
+			t.Errorf("expected message %v, found %v", m, msg)
+		}
+		session(t, func(s *SessionStats) bool { return s.PendingBytes == 0 && s.Messages == 1 })
+
+		client.Close()
+		waitClosed(t, done)
+		stats := l.Endpoints()
+		if len(stats) != 1 || stats[0].Session != nil {
+			t.Fatalf("expected closed connection without session, got %+v", stats)
+		}
+		if stats[0].Messages != 1 || stats[0].Bytes != uint64(len(m)) {
+			t.Errorf("unexpected statistics %+v", stats[0])
+		}
+	})
+
+	t.Run("max connections", func(t *testing.T) {
+		l := NewTCPListener("test", WithTCPMaxConnections(2))
+		if !l.acquire() || !l.acquire() {
+			t.Fatal("expected two connections to be accepted")
+		}
+		if l.acquire() {
+			t.Error("expected third connection to be rejected")
+		}
+		l.release()
+		if !l.acquire() {
+			t.Error("expected connection to be accepted after release")
+		}
+	})
+}
+
+// scriptedAcceptor returns the connections and errors in order, followed by net.ErrClosed
+type scriptedAcceptor struct {
+	conns []net.Conn
+	errs  []error
+}
+
+func (a *scriptedAcceptor) Accept() (net.Conn, error) {
+	if len(a.conns) == 0 {
+		return nil, net.ErrClosed
+	}
+	conn, err := a.conns[0], a.errs[0]
+	a.conns, a.errs = a.conns[1:], a.errs[1:]
+	return conn, err
+}
@@ -20,16 +20,62 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sync/atomic"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type TemplateMetadata struct {
-	Name                string            `json:"name,omitempty"`
-	TemplateId          uint16            `json:"template_id,omitempty"`
-	ObservationDomainId uint32            `json:"observation_domain_id,omitempty"`
-	CreationTimestamp   time.Time         `json:"created"`
-	Labels              map[string]string `json:"labels,omitempty"`
-	Annotations         map[string]string `json:"annotations,omitempty"`
+	Name                string            `json:"name,omitempty" yaml:"name,omitempty"`
+	TemplateId          uint16            `json:"template_id,omitempty" yaml:"templateId,omitempty"`
+	ObservationDomainId uint32            `json:"observation_domain_id,omitempty" yaml:"observationDomainId,omitempty"`
+	CreationTimestamp   time.Time         `json:"created" yaml:"created"`
+	Labels              map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations         map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+
+	// usageCount is the number of data sets decoded using the template
+	usageCount atomic.Uint64
+	// lastUsed is the time of the last usage of the template in nanoseconds since the unix epoch
+	lastUsed atomic.Int64
+}
+
+// MarkUsed records that the template was used for decoding a data set at the given time.
+// MarkUsed uses atomic operations only and is therefore safe to be called concurrently
+// without holding the lock of the cache the template is stored in.
+func (m *TemplateMetadata) MarkUsed(at time.Time) {
+	if m == nil {
+		return
+	}
+	m.usageCount.Add(1)
+	ts := at.UnixNano()
+	for {
+		last := m.lastUsed.Load()
+		if last >= ts || m.lastUsed.CompareAndSwap(last, ts) {
+			return
+		}
+	}
+}
+
+// UsageCount returns the number of data sets decoded using the template
+func (m *TemplateMetadata) UsageCount() uint64 {
+	if m == nil {
+		return 0
+	}
+	return m.usageCount.Load()
+}
+
+// LastUsed returns the time at which the template was last used for decoding a data set.
+// If the template was never used, LastUsed returns the zero time.
+func (m *TemplateMetadata) LastUsed() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	ts := m.lastUsed.Load()
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ts)
 }
 
 type Template struct {
@@ -44,6 +90,8 @@ type Template struct {
 type templateRecord interface {
 	json.Marshaler
 	json.Unmarshaler
+	yaml.Marshaler
+	yaml.Unmarshaler
 
 	Type() string
 	Id() uint16
@@ -61,8 +109,23 @@ func (tr *Template) WithTemplateCache(f TemplateCache) *Template {
 	return tr
 }
 
+// Key returns the key of the template in a TemplateCache. The template id is taken from the
+// record, and the observation domain from the metadata, if present.
+func (tr *Template) Key() TemplateKey {
+	key := TemplateKey{}
+	if tr.TemplateMetadata != nil {
+		key = NewKey(tr.ObservationDomainId, tr.TemplateId)
+	}
+	if tr.Record != nil {
+		key.TemplateId = tr.Record.Id()
+	}
+	return key
+}
+
 var _ json.Marshaler = &Template{}
 var _ json.Unmarshaler = &Template{}
+var _ yaml.Marshaler = &Template{}
+var _ yaml.Unmarshaler = &Template{}
 
 func (tr Template) MarshalJSON() ([]byte, error) {
 	type itr struct {
@@ -98,9 +161,9 @@ func (t *Template) UnmarshalJSON(in []byte) error {
 
 	err := json.Unmarshal(in, &it)
 	if err != nil {
-		return nil
+		return err
 	}
-	switch it.Kind {
+	switch normalizeKind(it.Kind) {
 	case KindTemplateSet:
 		tr := TemplateRecord{
 			fieldCache:    t.fieldCache,
@@ -122,7 +185,71 @@ func (t *Template) UnmarshalJSON(in []byte) error {
 		}
 		t.Record = &otr
 	default:
-		return fmt.Errorf("cannot use %v as a template for unmarshaling", it.Record)
+		return fmt.Errorf("cannot use %s as a template kind for unmarshaling", it.Kind)
+	}
+	t.TemplateMetadata = it.TemplateMetadata
+	return nil
+}
+
+func (tr Template) MarshalYAML() (interface{}, error) {
+	type itr struct {
+		Kind     string            `yaml:"kind"`
+		Metadata *TemplateMetadata `yaml:"metadata,omitempty"`
+		Record   interface{}       `yaml:"record"`
+	}
+
+	switch t := tr.Record.(type) {
+	case *TemplateRecord, *OptionsTemplateRecord:
+		r, err := t.MarshalYAML()
+		if err != nil {
+			return nil, err
+		}
+		return itr{
+			Kind:     t.Type(),
+			Metadata: tr.TemplateMetadata,
+			Record:   r,
+		}, nil
+	default:
+		return nil, fmt.Errorf("cannot use %T as template for templates.Template", t)
+	}
+}
+
+func (t *Template) UnmarshalYAML(node *yaml.Node) error {
+	type itr struct {
+		Kind     string            `yaml:"kind"`
+		Metadata *TemplateMetadata `yaml:"metadata,omitempty"`
+		Record   yaml.Node         `yaml:"record"`
+	}
+
+	it := itr{}
+	err := node.Decode(&it)
+	if err != nil {
+		return err
+	}
+	switch normalizeKind(it.Kind) {
+	case KindTemplateSet:
+		tr := TemplateRecord{
+			fieldCache:    t.fieldCache,
+			templateCache: t.templateCache,
+		}
+		err := it.Record.Decode(&tr)
+		if err != nil {
+			return err
+		}
+		t.Record = &tr
+	case KindOptionsTemplateSet:
+		otr := OptionsTemplateRecord{
+			fieldCache:    t.fieldCache,
+			templateCache: t.templateCache,
+		}
+		err := it.Record.Decode(&otr)
+		if err != nil {
+			return err
+		}
+		t.Record = &otr
+	default:
+		return fmt.Errorf("cannot use %s as a template kind for unmarshaling", it.Kind)
 	}
+	t.TemplateMetadata = it.Metadata
 	return nil
 }
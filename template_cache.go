@@ -23,8 +23,10 @@ import (
 
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // TemplateCache stores templates observed in an IPFIX/Netflow stream of flow packets
@@ -107,6 +109,140 @@ type TemplateCacheDriver interface {
 	Close(context.Context) error
 }
 
+// TemplateCacheWithStats is the interface to be implemented by caches that report usage statistics
+// of their templates, e.g., to find templates that were defined by an exporter but never used.
+type TemplateCacheWithStats interface {
+	TemplateCache
+
+	// Stats returns usage statistics of all templates currently stored in the cache, sorted by key
+	Stats(ctx context.Context) []TemplateStats
+}
+
+// ObservableTemplateCache is the interface to be implemented by caches that notify subscribers
+// about added and deleted templates, e.g., for updating a UI or replicating templates to a
+// secondary store.
+type ObservableTemplateCache interface {
+	TemplateCache
+
+	// Subscribe registers fn to be called for every subsequent mutation of the cache and returns
+	// a function removing the subscription again. fn is called synchronously from the goroutine
+	// mutating the cache, but without holding the cache's lock, such that fn may call the cache.
+	// Events of concurrent mutations may be delivered out of order.
+	Subscribe(fn func(event CacheEvent)) (unsubscribe func())
+}
+
+// CacheEventKind is the kind of mutation reported in a CacheEvent
+type CacheEventKind int
+
+const (
+	// CacheEventAdd is emitted for templates that were added or replaced
+	CacheEventAdd CacheEventKind = iota
+	// CacheEventDelete is emitted for templates that were deleted or evicted
+	CacheEventDelete
+)
+
+func (k CacheEventKind) String() string {
+	switch k {
+	case CacheEventAdd:
+		return "add"
+	case CacheEventDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// CacheEvent describes a single mutation of an ObservableTemplateCache
+type CacheEvent struct {
+	Kind CacheEventKind
+	Key  TemplateKey
+	// Template is the added template for CacheEventAdd, and the removed one for CacheEventDelete
+	Template *Template
+}
+
+// cacheSubscribers manages the subscriptions of an ObservableTemplateCache
+type cacheSubscribers struct {
+	mu        sync.Mutex
+	next      int
+	callbacks map[int]func(CacheEvent)
+}
+
+func newCacheSubscribers() *cacheSubscribers {
+	return &cacheSubscribers{
+		callbacks: make(map[int]func(CacheEvent)),
+	}
+}
+
+func (s *cacheSubscribers) subscribe(fn func(CacheEvent)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.callbacks[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.callbacks, id)
+	}
+}
+
+// publish calls all subscribers with the events in order. Callers must not hold the lock of
+// their cache.
+func (s *cacheSubscribers) publish(events ...CacheEvent) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	callbacks := make([]func(CacheEvent), 0, len(s.callbacks))
+	for _, fn := range s.callbacks {
+		callbacks = append(callbacks, fn)
+	}
+	s.mu.Unlock()
+
+	for _, event := range events {
+		for _, fn := range callbacks {
+			fn(event)
+		}
+	}
+}
+
+// TemplateStats contains usage statistics of a single template in a cache
+type TemplateStats struct {
+	Key     TemplateKey `json:"key"`
+	Created time.Time   `json:"created"`
+	// LastUsed is the zero time for templates that were never used
+	LastUsed   time.Time `json:"last_used"`
+	UsageCount uint64    `json:"usage_count"`
+}
+
+// Unused returns true if the template was never used for decoding a data set
+func (s TemplateStats) Unused() bool {
+	return s.UsageCount == 0
+}
+
+// templateStats collects the usage statistics of templates sorted by their key
+func templateStats(templates map[TemplateKey]*Template) []TemplateStats {
+	stats := make([]TemplateStats, 0, len(templates))
+	for k, t := range templates {
+		if t == nil {
+			continue
+		}
+		s := TemplateStats{
+			Key:        k,
+			LastUsed:   t.LastUsed(),
+			UsageCount: t.UsageCount(),
+		}
+		if t.TemplateMetadata != nil {
+			s.Created = t.CreationTimestamp
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return templateKeyLess(stats[i].Key, stats[j].Key)
+	})
+	return stats
+}
+
 type TemplateKey struct {
 	ObservationDomainId uint32
 	TemplateId          uint16
@@ -127,6 +263,45 @@ func (k *TemplateKey) String() string {
 	return fmt.Sprintf("%d%s%d", k.ObservationDomainId, templateKeySeparator, k.TemplateId)
 }
 
+// templateKeyLess orders template keys by observation domain id first, and template id second
+func templateKeyLess(a, b TemplateKey) bool {
+	if a.ObservationDomainId != b.ObservationDomainId {
+		return a.ObservationDomainId < b.ObservationDomainId
+	}
+	return a.TemplateId < b.TemplateId
+}
+
+func templateKeyString(k TemplateKey) string {
+	return k.String()
+}
+
+// unmarshalTemplates restores templates marshalled by a template cache, i.e., a JSON object of
+// templates keyed by the string form of their TemplateKey. The caches are injected into the
+// restored templates and may be nil.
+func unmarshalTemplates(in []byte, fieldCache FieldCache, templateCache TemplateCache) (map[TemplateKey]*Template, error) {
+	raw := make(map[string]json.RawMessage)
+	err := json.Unmarshal(in, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[TemplateKey]*Template, len(raw))
+	for k, v := range raw {
+		key := TemplateKey{}
+		err := key.UnmarshalText([]byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template key \"%s\", %w", k, err)
+		}
+		template := (&Template{}).WithFieldCache(fieldCache).WithTemplateCache(templateCache)
+		err = json.Unmarshal(v, template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal template %s, %w", k, err)
+		}
+		templates[key] = template
+	}
+	return templates, nil
+}
+
 func (k *TemplateKey) MarshalText() (text []byte, err error) {
 	text = []byte(k.String())
 	return
@@ -141,12 +316,12 @@ func (k *TemplateKey) Unmarshal(text string) (err error) {
 		return errors.New("template key format is invalid")
 	}
 
-	if v, err := strconv.ParseUint(key[0], 10, 64); err != nil {
+	if v, err := strconv.ParseUint(key[0], 10, 32); err != nil {
 		return fmt.Errorf("observation domain id is invalid, %w", err)
 	} else {
 		observationDomainId = uint32(v)
 	}
-	if v, err := strconv.ParseUint(key[1], 10, 64); err != nil {
+	if v, err := strconv.ParseUint(key[1], 10, 16); err != nil {
 		return fmt.Errorf("template id is invalid, %w", err)
 	} else {
 		templateId = uint16(v)
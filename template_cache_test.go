@@ -0,0 +1,380 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestTemplate creates a template of sourceIPv4Address and sourceTransportPort
+func newTestTemplate(t testing.TB, fieldCache FieldCache, templateId uint16) *Template {
+	ctx := context.Background()
+	fields := make([]Field, 0, 2)
+	for _, spec := range []struct{ id, length uint16 }{{8, 4}, {7, 2}} {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, spec.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, fb.SetLength(spec.length).Complete())
+	}
+	return &Template{
+		TemplateMetadata: &TemplateMetadata{
+			TemplateId:        templateId,
+			CreationTimestamp: time.Now(),
+		},
+		Record: &TemplateRecord{
+			TemplateId: templateId,
+			FieldCount: uint16(len(fields)),
+			Fields:     fields,
+		},
+	}
+}
+
+// newTestDataMessage creates an IPFIX message containing a single data set of n records of
+// the template created by newTestTemplate
+func newTestDataMessage(templateId uint16, n int) []byte {
+	set := make([]byte, 0)
+	for i := 0; i < n; i++ {
+		set = append(set, 192, 0, 2, byte(i))
+		set = binary.BigEndian.AppendUint16(set, uint16(i))
+	}
+	b := make([]byte, 0)
+	b = binary.BigEndian.AppendUint16(b, 10)
+	b = binary.BigEndian.AppendUint16(b, uint16(16+4+len(set)))
+	b = binary.BigEndian.AppendUint32(b, uint32(time.Now().Unix()))
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint16(b, templateId)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(set)))
+	return append(b, set...)
+}
+
+func TestTemplateStats(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("usage is counted by decoder", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		fieldCache := NewIANAFieldManager(templateCache)
+		for _, id := range []uint16{256, 257} {
+			err := templateCache.Add(ctx, NewKey(0, id), newTestTemplate(t, fieldCache, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		decoder := NewDecoder(templateCache, fieldCache)
+		msg, err := decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := msg.Sets[0].Set.Length(); l != 3 {
+			t.Fatalf("expected 3 decoded records, got %d", l)
+		}
+
+		stats := templateCache.(TemplateCacheWithStats).Stats(ctx)
+		if len(stats) != 2 {
+			t.Fatalf("expected stats for 2 templates, got %d", len(stats))
+		}
+		if stats[0].Key.TemplateId != 256 || stats[0].UsageCount != 1 || stats[0].LastUsed.IsZero() {
+			t.Errorf("expected template 256 to be used once, got %+v", stats[0])
+		}
+		if !stats[1].Unused() || !stats[1].LastUsed.IsZero() {
+			t.Errorf("expected template 257 to be unused, got %+v", stats[1])
+		}
+		firstUsage := stats[0].LastUsed
+
+		time.Sleep(time.Millisecond)
+		_, err = decoder.Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats = templateCache.(TemplateCacheWithStats).Stats(ctx)
+		if stats[0].UsageCount != 2 {
+			t.Errorf("expected template 256 to be used twice, got %d", stats[0].UsageCount)
+		}
+		if !stats[0].LastUsed.After(firstUsage) {
+			t.Errorf("expected last usage %s to be after %s", stats[0].LastUsed, firstUsage)
+		}
+	})
+
+	t.Run("decaying cache refreshes deadline on use", func(t *testing.T) {
+		for _, refresh := range []bool{true, false} {
+			cache := NewDefaultDecayingEphemeralCache().(*DecayingEphemeralCache)
+			cache.SetTimeout(time.Hour)
+			cache.SetRefreshOnUse(refresh)
+
+			fieldCache := NewIANAFieldManager(cache)
+			err := cache.Add(ctx, NewKey(0, 256), newTestTemplate(t, fieldCache, 256))
+			if err != nil {
+				t.Fatal(err)
+			}
+			added := cache.templates[NewKey(0, 256)].deadline
+
+			time.Sleep(time.Millisecond)
+			_, err = NewDecoder(cache, fieldCache).Decode(ctx, bytes.NewBuffer(newTestDataMessage(256, 1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := cache.deadlineOf(cache.templates[NewKey(0, 256)])
+			if refresh && !deadline.After(added) {
+				t.Errorf("expected deadline %s to be extended by usage beyond %s", deadline, added)
+			}
+			if !refresh && !deadline.Equal(added) {
+				t.Errorf("expected deadline %s to remain %s without refresh on use", deadline, added)
+			}
+		}
+	})
+}
+
+func TestObservableTemplateCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("add and delete are published", func(t *testing.T) {
+		cache := NewDefaultEphemeralCache().(ObservableTemplateCache)
+		fieldCache := NewIANAFieldManager(cache)
+
+		events := make([]CacheEvent, 0)
+		unsubscribe := cache.Subscribe(func(event CacheEvent) {
+			// callbacks must be able to use the cache without deadlocking
+			_ = cache.GetAll(ctx)
+			events = append(events, event)
+		})
+
+		template := newTestTemplate(t, fieldCache, 256)
+		if err := cache.Add(ctx, NewKey(0, 256), template); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Delete(ctx, NewKey(0, 256)); err != nil {
+			t.Fatal(err)
+		}
+		// deleting a missing template is not a mutation
+		if err := cache.Delete(ctx, NewKey(0, 257)); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []CacheEvent{
+			{Kind: CacheEventAdd, Key: NewKey(0, 256), Template: template},
+			{Kind: CacheEventDelete, Key: NewKey(0, 256), Template: template},
+		}
+		if len(events) != len(expected) {
+			t.Fatalf("expected %d events, got %d", len(expected), len(events))
+		}
+		for i := range expected {
+			if events[i] != expected[i] {
+				t.Errorf("expected event %d to be %s of %v, got %s of %v", i, expected[i].Kind, expected[i].Key, events[i].Kind, events[i].Key)
+			}
+		}
+
+		unsubscribe()
+		if err := cache.Add(ctx, NewKey(0, 256), template); err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(expected) {
+			t.Errorf("expected no events after unsubscribing, got %d", len(events)-len(expected))
+		}
+	})
+
+	t.Run("evictions are published", func(t *testing.T) {
+		cache := NewBoundedEphemeralCache("test", 1)
+		fieldCache := NewIANAFieldManager(cache)
+
+		kinds := make([]CacheEventKind, 0)
+		keys := make([]TemplateKey, 0)
+		cache.Subscribe(func(event CacheEvent) {
+			kinds = append(kinds, event.Kind)
+			keys = append(keys, event.Key)
+		})
+		for _, id := range []uint16{256, 257} {
+			if err := cache.Add(ctx, NewKey(0, id), newTestTemplate(t, fieldCache, id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expectedKinds := []CacheEventKind{CacheEventAdd, CacheEventDelete, CacheEventAdd}
+		expectedKeys := []TemplateKey{NewKey(0, 256), NewKey(0, 256), NewKey(0, 257)}
+		if len(kinds) != len(expectedKinds) {
+			t.Fatalf("expected %d events, got %v", len(expectedKinds), kinds)
+		}
+		for i := range expectedKinds {
+			if kinds[i] != expectedKinds[i] || keys[i] != expectedKeys[i] {
+				t.Errorf("expected event %d to be %s of %v, got %s of %v", i, expectedKinds[i], expectedKeys[i], kinds[i], keys[i])
+			}
+		}
+	})
+}
+
+// jsonObjectKeys returns the keys of the JSON object in b in the order they appear in
+func jsonObjectKeys(t *testing.T, b []byte) []string {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	keys := make([]string, 0, len(m))
+	if _, err := dec.Token(); err != nil {
+		t.Fatal(err)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestTemplateCacheMarshalJSON(t *testing.T) {
+	ctx := context.Background()
+	fieldCache := NewIANAFieldCache(NewDefaultEphemeralCache())
+
+	// keys are added in an order that differs from both their numerical and lexical order
+	keys := []TemplateKey{NewKey(10, 256), NewKey(2, 1000), NewKey(2, 256), NewKey(1, 300)}
+	expected := []string{"1-300", "2-256", "2-1000", "10-256"}
+
+	for name, newCache := range map[string]func(t *testing.T) TemplateCache{
+		"ephemeral": func(t *testing.T) TemplateCache { return NewDefaultEphemeralCache() },
+		"decaying":  func(t *testing.T) TemplateCache { return NewDefaultDecayingEphemeralCache() },
+		"bounded":   func(t *testing.T) TemplateCache { return NewBoundedEphemeralCache("bounded", 16) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			for _, key := range keys {
+				if err := cache.Add(ctx, key, newTestTemplate(t, fieldCache, key.TemplateId)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a, err := json.Marshal(cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(a, b) {
+				t.Errorf("expected identical output, got\n%s\n%s", a, b)
+			}
+
+			actual := jsonObjectKeys(t, a)
+			if len(actual) != len(expected) {
+				t.Fatalf("expected keys %v, got %v", expected, actual)
+			}
+			for i := range expected {
+				if actual[i] != expected[i] {
+					t.Errorf("expected keys %v, got %v", expected, actual)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestEphemeralCacheUnmarshalJSON(t *testing.T) {
+	ctx := context.Background()
+	fieldCache := NewIANAFieldCache(NewDefaultEphemeralCache())
+
+	field := func(id, length uint16) Field {
+		fb, err := fieldCache.GetBuilder(ctx, NewFieldKey(0, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.SetLength(length).Complete()
+	}
+	scope := field(346, 4)
+	scope.SetScoped()
+	optionsTemplate := &Template{
+		TemplateMetadata: &TemplateMetadata{
+			TemplateId:          257,
+			ObservationDomainId: 1,
+			CreationTimestamp:   time.Date(2023, time.October, 1, 12, 0, 0, 0, time.UTC),
+		},
+		Record: &OptionsTemplateRecord{
+			TemplateId:      257,
+			FieldCount:      2,
+			ScopeFieldCount: 1,
+			Scopes:          []Field{scope},
+			Options:         []Field{field(303, 2)},
+		},
+	}
+	template := newTestTemplate(t, fieldCache, 256)
+	template.CreationTimestamp = time.Date(2023, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	cache := NewDefaultEphemeralCache()
+	for key, tmpl := range map[TemplateKey]*Template{NewKey(1, 256): template, NewKey(1, 257): optionsTemplate, NewKey(2, 256): template} {
+		if err := cache.Add(ctx, key, tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := json.Marshal(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewDefaultEphemeralCache()
+	if err := json.Unmarshal(a, restored); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("expected restored cache to marshal identically, got\n%s\n%s", a, b)
+	}
+
+	tmpl, err := restored.Get(ctx, NewKey(1, 257))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otr, ok := tmpl.Record.(*OptionsTemplateRecord)
+	if !ok {
+		t.Fatalf("expected options template record, got %T", tmpl.Record)
+	}
+	if len(otr.Scopes) != 1 || otr.Scopes[0].Name() != "privateEnterpriseNumber" || len(otr.Options) != 1 {
+		t.Errorf("expected options template to be restored, got %v", otr)
+	}
+
+	t.Run("invalid key", func(t *testing.T) {
+		err := json.Unmarshal([]byte(`{"256":{"kind":"TemplateSet","record":{}}}`), NewDefaultEphemeralCache())
+		if err == nil {
+			t.Error("expected error for template key without observation domain")
+		}
+	})
+
+	t.Run("key out of range", func(t *testing.T) {
+		for _, key := range []string{"4294967296-256", "0-65536"} {
+			err := json.Unmarshal([]byte(`{"`+key+`":{"kind":"TemplateSet","record":{}}}`), NewDefaultEphemeralCache())
+			if !errors.Is(err, strconv.ErrRange) {
+				t.Errorf("expected error wrapping strconv.ErrRange for key %s, got %v", key, err)
+			}
+		}
+	})
+}
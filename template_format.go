@@ -0,0 +1,190 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Formats supported by Template.Describe and DumpTemplates
+const (
+	// TemplateFormatTable renders templates as aligned text for humans to read
+	TemplateFormatTable string = "table"
+	// TemplateFormatCSV renders templates as CSV with a header row
+	TemplateFormatCSV string = "csv"
+)
+
+// templateColumns are the columns of a template's field in both formats
+var templateColumns = []string{"pen", "id", "name", "type", "length", "scope"}
+
+// Describe writes the fields of the template to w in the given format, either TemplateFormatTable
+// or TemplateFormatCSV. Each row describes a single field, with scope fields of options templates
+// first. Variable-length fields are rendered with length "var", reduced-length fields as, e.g.,
+// "4 (reduced from 8)".
+func (t *Template) Describe(w io.Writer, format string) error {
+	rows, err := t.rows()
+	if err != nil {
+		return err
+	}
+	switch format {
+	case TemplateFormatTable:
+		return writeTemplateTable(w, templateColumns, rows)
+	case TemplateFormatCSV:
+		return writeTemplateCSV(w, templateColumns, rows)
+	default:
+		return fmt.Errorf("unknown template format %q", format)
+	}
+}
+
+// DumpTemplates writes all templates of cache to w in the given format, either TemplateFormatTable
+// or TemplateFormatCSV, sorted by observation domain id and template id. In table format, each
+// template is described by its own table, preceded by a line denoting the template's key. In CSV
+// format, all templates share a single table with the template's key in the first two columns.
+func DumpTemplates(ctx context.Context, cache TemplateCache, w io.Writer, format string) error {
+	templates := cache.GetAll(ctx)
+	keys := make([]TemplateKey, 0, len(templates))
+	for key := range templates {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b TemplateKey) int {
+		if c := cmp.Compare(a.ObservationDomainId, b.ObservationDomainId); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.TemplateId, b.TemplateId)
+	})
+
+	switch format {
+	case TemplateFormatTable:
+		for i, key := range keys {
+			if i > 0 {
+				if _, err := io.WriteString(w, "\n"); err != nil {
+					return err
+				}
+			}
+			kind := ""
+			if r := templates[key].Record; r != nil {
+				kind = r.Type()
+			}
+			_, err := fmt.Fprintf(w, "# %s %d in observation domain %d\n", kind, key.TemplateId, key.ObservationDomainId)
+			if err != nil {
+				return err
+			}
+			if err := templates[key].Describe(w, format); err != nil {
+				return fmt.Errorf("failed to describe template (%d,%d), %w", key.ObservationDomainId, key.TemplateId, err)
+			}
+		}
+		return nil
+	case TemplateFormatCSV:
+		header := append([]string{"observation_domain_id", "template_id"}, templateColumns...)
+		rows := make([][]string, 0)
+		for _, key := range keys {
+			templateRows, err := templates[key].rows()
+			if err != nil {
+				return fmt.Errorf("failed to describe template (%d,%d), %w", key.ObservationDomainId, key.TemplateId, err)
+			}
+			for _, row := range templateRows {
+				rows = append(rows, append([]string{
+					strconv.FormatUint(uint64(key.ObservationDomainId), 10),
+					strconv.FormatUint(uint64(key.TemplateId), 10),
+				}, row...))
+			}
+		}
+		return writeTemplateCSV(w, header, rows)
+	default:
+		return fmt.Errorf("unknown template format %q", format)
+	}
+}
+
+// rows returns a row of templateColumns for each field of the template
+func (t *Template) rows() ([][]string, error) {
+	rows := make([][]string, 0)
+	switch r := t.Record.(type) {
+	case *TemplateRecord:
+		for _, f := range r.Fields {
+			rows = append(rows, describeField(f, false))
+		}
+	case *OptionsTemplateRecord:
+		for _, f := range r.Scopes {
+			rows = append(rows, describeField(f, true))
+		}
+		for _, f := range r.Options {
+			rows = append(rows, describeField(f, false))
+		}
+	default:
+		return nil, fmt.Errorf("cannot use %T as template for templates.Template", r)
+	}
+	return rows, nil
+}
+
+func describeField(f Field, scope bool) []string {
+	var length string
+	switch ff := f.(type) {
+	case *VariableLengthField:
+		length = "var"
+	default:
+		length = strconv.FormatUint(uint64(ff.Length()), 10)
+		if v := ff.Value(); v != nil && v.IsReducedLength() {
+			length = fmt.Sprintf("%d (reduced from %d)", ff.Length(), v.DefaultLength())
+		}
+	}
+	return []string{
+		strconv.FormatUint(uint64(f.PEN()), 10),
+		strconv.FormatUint(uint64(f.Id()), 10),
+		f.Name(),
+		f.Type(),
+		length,
+		strconv.FormatBool(scope),
+	}
+}
+
+func writeTemplateTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, col := range row {
+			if i > 0 {
+				if _, err := io.WriteString(tw, "\t"); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(tw, col); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(tw, "\n"); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func writeTemplateCSV(w io.Writer, header []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
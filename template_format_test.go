@@ -0,0 +1,90 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateFormat(t *testing.T) {
+	ctx := context.Background()
+
+	p := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(p, fixtureTemplates, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	persistent, err := cacheFactory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := persistent.(*PersistentCache).Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// use the restored backing cache, as the persistent cache itself is locked until started
+	cache := persistent.(*PersistentCache).cache
+
+	for format, golden := range map[string]string{
+		TemplateFormatTable: "hack/templates.golden.txt",
+		TemplateFormatCSV:   "hack/templates.golden.csv",
+	} {
+		t.Run(format+" matches golden fixture", func(t *testing.T) {
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &bytes.Buffer{}
+			if err := DumpTemplates(ctx, cache, b, format); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, b.Bytes()) {
+				t.Errorf("expected templates to equal golden fixture, got\n%s", b.String())
+			}
+		})
+	}
+
+	t.Run("describe single template", func(t *testing.T) {
+		template, err := cache.Get(ctx, NewKey(0, 302))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &bytes.Buffer{}
+		if err := template.Describe(b, TemplateFormatCSV); err != nil {
+			t.Fatal(err)
+		}
+		expected := "pen,id,name,type,length,scope\n" +
+			"0,346,privateEnterpriseNumber,unsigned32,4,true\n" +
+			"0,303,informationElementId,unsigned16,2,true\n"
+		if !bytes.HasPrefix(b.Bytes(), []byte(expected)) {
+			t.Errorf("expected description to start with scope fields, got\n%s", b.String())
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := DumpTemplates(ctx, cache, &bytes.Buffer{}, "xml"); err == nil {
+			t.Error("expected unknown format to fail")
+		}
+	})
+}
@@ -20,9 +20,10 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
+
+	"gopkg.in/yaml.v3"
 )
 
 type TemplateRecord struct {
@@ -71,16 +72,17 @@ func (tr *TemplateRecord) Encode(w io.Writer) (n int, err error) {
 		return n, err
 	}
 	for _, r := range tr.Fields {
-		isEnterprise := r.PEN() != 0
+		key := wireKey(r)
+		isEnterprise := key.EnterpriseId != 0
 		b := make([]byte, 0)
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint16(b, penMask|r.Id())
+			b = binary.BigEndian.AppendUint16(b, penMask|key.Id)
 		} else {
-			b = binary.BigEndian.AppendUint16(b, r.Id())
+			b = binary.BigEndian.AppendUint16(b, key.Id)
 		}
 		b = binary.BigEndian.AppendUint16(b, r.Length())
 		if isEnterprise {
-			b = binary.BigEndian.AppendUint32(b, r.PEN())
+			b = binary.BigEndian.AppendUint32(b, key.EnterpriseId)
 		}
 		bn, err := w.Write(b)
 		n += bn
@@ -95,20 +97,20 @@ func (tr *TemplateRecord) Decode(r io.Reader) (n int, err error) {
 	{
 		// template record header
 		t := make([]byte, 2)
-		n, err = r.Read(t)
+		n, err = io.ReadFull(r, t)
 		if err != nil {
 			return n, err
 		}
 		tr.TemplateId = binary.BigEndian.Uint16(t)
 
-		m, err := r.Read(t)
+		m, err := io.ReadFull(r, t)
 		n += m
 		if err != nil {
 			return n, err
 		}
 		tr.FieldCount = binary.BigEndian.Uint16(t)
 		if tr.FieldCount == 0 {
-			return n, errors.New("template record field count must not be zero")
+			return n, malformedMessage(n, "template record field count must not be zero")
 		}
 	}
 
@@ -131,7 +133,7 @@ func (tr *TemplateRecord) decodeTemplateField(r io.Reader) (n int, err error) {
 	var reverse bool
 
 	b := make([]byte, 2)
-	m, err := r.Read(b)
+	m, err := io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, err
@@ -144,7 +146,7 @@ func (tr *TemplateRecord) decodeTemplateField(r io.Reader) (n int, err error) {
 	// length announcement via the template: this is either fixed or variable (i.e., 0xFFFF).
 	// The FieldBuilder will therefore either create a fixed-length or variable-length field
 	// on FieldBuilder.Complete()
-	m, err = r.Read(b)
+	m, err = io.ReadFull(r, b)
 	n += m
 	if err != nil {
 		return n, err
@@ -155,17 +157,17 @@ func (tr *TemplateRecord) decodeTemplateField(r io.Reader) (n int, err error) {
 	if rawFieldId >= 0x8000 {
 		// first bit is 1, therefore this is a enterprise-specific IE
 		b := make([]byte, 4)
-		m, err := r.Read(b)
+		m, err := io.ReadFull(r, b)
 		n += m
 		if err != nil {
 			return n, err
 		}
 		enterpriseId = binary.BigEndian.Uint32(b)
 
-		if enterpriseId == ReversePEN && reversible(fieldId) {
+		if forward, ok := forwardKey(enterpriseId, fieldId); ok {
 			reverse = true
-			// clear enterprise id, because this would obscure lookup
-			enterpriseId = 0
+			// look up the forward IE, e.g., clear the reverse PEN, because this would obscure lookup
+			enterpriseId, fieldId = forward.EnterpriseId, forward.Id
 		}
 	}
 
@@ -173,6 +175,9 @@ func (tr *TemplateRecord) decodeTemplateField(r io.Reader) (n int, err error) {
 	if err != nil {
 		return n, err
 	}
+	if fieldBuilder == nil {
+		fieldBuilder = NewUnknownFieldBuilder(enterpriseId, fieldId)
+	}
 
 	f := fieldBuilder.
 		SetLength(fieldLength).
@@ -228,7 +233,46 @@ func (tr *TemplateRecord) UnmarshalJSON(in []byte) error {
 	fs := make([]Field, 0, len(t.Fields))
 	for _, cf := range t.Fields {
 		// tr.fieldManager and tr.templateManager can still be nil
-		fs = append(fs, cf.restore(tr.fieldCache, tr.templateCache))
+		f, err := cf.restore(tr.fieldCache, tr.templateCache)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, f)
+	}
+	tr.Fields = fs
+
+	return nil
+}
+
+func (tr *TemplateRecord) MarshalYAML() (interface{}, error) {
+	type itr struct {
+		TemplateId uint16  `yaml:"templateId,omitempty"`
+		Fields     []Field `yaml:"fields,omitempty"`
+	}
+
+	return &itr{
+		TemplateId: tr.TemplateId,
+		Fields:     tr.Fields,
+	}, nil
+}
+
+func (tr *TemplateRecord) UnmarshalYAML(node *yaml.Node) error {
+	type itr struct {
+		TemplateId uint16      `yaml:"templateId,omitempty"`
+		Fields     []yamlField `yaml:"fields,omitempty"`
+	}
+
+	t := &itr{}
+	err := node.Decode(t)
+	if err != nil {
+		return err
+	}
+	tr.TemplateId = t.TemplateId
+	tr.FieldCount = uint16(len(t.Fields))
+
+	fs, err := restoreYAMLFields(t.Fields, tr.fieldCache, tr.templateCache)
+	if err != nil {
+		return err
 	}
 	tr.Fields = fs
 
@@ -238,14 +282,19 @@ func (tr *TemplateRecord) UnmarshalJSON(in []byte) error {
 func (tr *TemplateRecord) Length() uint16 {
 	l := uint16(0)
 	for _, f := range tr.Fields {
-		// TemplateRecord fields do not have the intrinsic length as DataRecord fields, but rather
-		// static length of sizeof(fieldId) + sizeof(fieldLength) + (penProvided ? sizeof(pen) : 0)
-		// which in practice is either 4 bytes or 4+4 = 8 bytes
-		if f.PEN() == 0 {
-			l += 4
-		} else {
-			l += 8
-		}
+		l += fieldSpecifierLength(f)
 	}
 	return l + 2 + 2
 }
+
+// fieldSpecifierLength returns the length of f's field specifier in a template record. Template
+// record fields do not have the intrinsic length as DataRecord fields, but rather static length of
+// sizeof(fieldId) + sizeof(fieldLength) + (penProvided ? sizeof(pen) : 0), which in practice is
+// either 4 bytes or 4+4 = 8 bytes. Like Encode, it uses the wire key of f, such that reversed
+// fields of IANA's registry account for the PEN 29305 written for them.
+func fieldSpecifierLength(f Field) uint16 {
+	if wireKey(f).EnterpriseId == 0 {
+		return 4
+	}
+	return 8
+}
@@ -17,8 +17,13 @@ limitations under the License.
 package ipfix
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestTemplate(t *testing.T) {
@@ -76,9 +81,9 @@ func TestTemplate(t *testing.T) {
 	}
 
 	marshalledTemplates := [][]byte{
-		[]byte(`{"kind":"TemplateRecord","record":{"template_id":300,"fields":[{"id":2,"name":"packetDeltaCount","length":4,"type":"unsigned64"},{"id":150,"name":"flowStartSeconds","length":4,"type":"dateTimeSeconds"},{"id":10,"name":"ingressInterface","length":2,"type":"unsigned32"},{"id":14,"name":"egressInterface","length":2,"type":"unsigned32"},{"id":4,"name":"protocolIdentifier","length":1,"type":"unsigned8"},{"id":6,"name":"tcpControlBits","length":2,"type":"unsigned16"},{"id":1,"name":"octetDeltaCount","length":4,"type":"unsigned64"},{"id":7,"name":"sourceTransportPort","length":2,"type":"unsigned16"},{"id":11,"name":"destinationTransportPort","length":2,"type":"unsigned16"},{"id":8,"name":"sourceIPv4Address","length":4,"type":"ipv4Address"},{"id":12,"name":"destinationIPv4Address","length":4,"type":"ipv4Address"}]}}`),
-		[]byte(`{"kind":"TemplateRecord","record":{"fields":[{"id":14,"name":"egressInterface","length":2,"type":"unsigned32"},{"id":4,"name":"protocolIdentifier","length":1,"type":"unsigned8"},{"id":6,"name":"tcpControlBits","length":2,"type":"unsigned16"},{"id":1,"name":"octetDeltaCount","length":4,"type":"unsigned64"},{"id":7,"name":"sourceTransportPort","length":2,"type":"unsigned16"}]}}`),
-		[]byte(`{"kind":"OptionsTemplateRecord","record":{"scopes":[{"id":346,"name":"privateEnterpriseNumber","length":4,"type":"unsigned32"},{"id":303,"name":"informationElementId","length":2,"type":"unsigned16"}],"options":[{"id":339,"name":"informationElementDataType","length":1,"type":"unsigned8"},{"id":344,"name":"informationElementSemantics","length":1,"type":"unsigned8"},{"id":345,"name":"informationElementUnits","length":2,"type":"unsigned16"},{"id":342,"name":"informationElementRangeBegin","length":8,"type":"unsigned64"},{"id":343,"name":"informationElementRangeEnd","length":8,"type":"unsigned64"},{"id":341,"name":"informationElementName","length":65535,"is_variable_length":true,"type":"string"},{"id":340,"name":"informationElementDescription","length":65535,"is_variable_length":true,"type":"string"}]}}`),
+		[]byte(`{"kind":"TemplateSet","record":{"template_id":300,"fields":[{"id":2,"name":"packetDeltaCount","length":4,"type":"unsigned64"},{"id":150,"name":"flowStartSeconds","length":4,"type":"dateTimeSeconds"},{"id":10,"name":"ingressInterface","length":2,"type":"unsigned32"},{"id":14,"name":"egressInterface","length":2,"type":"unsigned32"},{"id":4,"name":"protocolIdentifier","length":1,"type":"unsigned8"},{"id":6,"name":"tcpControlBits","length":2,"type":"unsigned16"},{"id":1,"name":"octetDeltaCount","length":4,"type":"unsigned64"},{"id":7,"name":"sourceTransportPort","length":2,"type":"unsigned16"},{"id":11,"name":"destinationTransportPort","length":2,"type":"unsigned16"},{"id":8,"name":"sourceIPv4Address","length":4,"type":"ipv4Address"},{"id":12,"name":"destinationIPv4Address","length":4,"type":"ipv4Address"}]}}`),
+		[]byte(`{"kind":"TemplateSet","record":{"fields":[{"id":14,"name":"egressInterface","length":2,"type":"unsigned32"},{"id":4,"name":"protocolIdentifier","length":1,"type":"unsigned8"},{"id":6,"name":"tcpControlBits","length":2,"type":"unsigned16"},{"id":1,"name":"octetDeltaCount","length":4,"type":"unsigned64"},{"id":7,"name":"sourceTransportPort","length":2,"type":"unsigned16"}]}}`),
+		[]byte(`{"kind":"OptionsTemplateSet","record":{"scopes":[{"id":346,"name":"privateEnterpriseNumber","length":4,"type":"unsigned32"},{"id":303,"name":"informationElementId","length":2,"type":"unsigned16"}],"options":[{"id":339,"name":"informationElementDataType","length":1,"type":"unsigned8"},{"id":344,"name":"informationElementSemantics","length":1,"type":"unsigned8"},{"id":345,"name":"informationElementUnits","length":2,"type":"unsigned16"},{"id":342,"name":"informationElementRangeBegin","length":8,"type":"unsigned64"},{"id":343,"name":"informationElementRangeEnd","length":8,"type":"unsigned64"},{"id":341,"name":"informationElementName","length":65535,"is_variable_length":true,"type":"string"},{"id":340,"name":"informationElementDescription","length":65535,"is_variable_length":true,"type":"string"}]}}`),
 	}
 
 	t.Run("marshal template to json", func(t *testing.T) {
@@ -104,3 +109,241 @@ func TestTemplate(t *testing.T) {
 		}
 	})
 }
+
+func TestOptionsTemplateRecord(t *testing.T) {
+	ies := iana()
+
+	newRecord := func() *OptionsTemplateRecord {
+		return &OptionsTemplateRecord{
+			TemplateId: 1591,
+			Scopes: []Field{
+				NewFieldBuilder(ies[346]).SetLength(4).Complete().SetScoped(),
+			},
+			Options: []Field{
+				NewFieldBuilder(ies[339]).SetLength(1).Complete(),
+				NewFieldBuilder(ies[341]).SetLength(VariableLength).Complete(),
+			},
+		}
+	}
+
+	t.Run("json round trip", func(t *testing.T) {
+		b, err := json.Marshal(&Template{Record: newRecord()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := &Template{}
+		if err := json.Unmarshal(b, restored); err != nil {
+			t.Fatal(err)
+		}
+
+		otr, ok := restored.Record.(*OptionsTemplateRecord)
+		if !ok {
+			t.Fatalf("expected %T, found %T", &OptionsTemplateRecord{}, restored.Record)
+		}
+		if otr.ScopeFieldCount != 1 || otr.FieldCount != 3 {
+			t.Errorf("expected 1 scope and 3 fields, found %d and %d", otr.ScopeFieldCount, otr.FieldCount)
+		}
+		if len(otr.Scopes) != 1 || !otr.Scopes[0].IsScope() || otr.Scopes[0].Id() != 346 {
+			t.Errorf("expected scope field 346, found %v", otr.Scopes)
+		}
+		if len(otr.Options) != 2 || otr.Options[0].Id() != 339 || otr.Options[1].Id() != 341 {
+			t.Errorf("expected option fields 339 and 341, found %v", otr.Options)
+		}
+
+		expected := &bytes.Buffer{}
+		if _, err := newRecord().Encode(expected); err != nil {
+			t.Fatal(err)
+		}
+		actual := &bytes.Buffer{}
+		if _, err := otr.Encode(actual); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+			t.Errorf("expected restored record to encode to %v, found %v", expected.Bytes(), actual.Bytes())
+		}
+	})
+
+	t.Run("unset counts are computed on encode", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		n, err := newRecord().Encode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if fieldCount, scopeFieldCount := binary.BigEndian.Uint16(b[2:4]), binary.BigEndian.Uint16(b[4:6]); fieldCount != 3 || scopeFieldCount != 1 {
+			t.Errorf("expected 3 fields and 1 scope, found %d and %d", fieldCount, scopeFieldCount)
+		}
+		if n != int(newRecord().Length()) {
+			t.Errorf("expected %d bytes, found %d", newRecord().Length(), n)
+		}
+	})
+
+	t.Run("inconsistent counts fail on encode", func(t *testing.T) {
+		for name, record := range map[string]func(*OptionsTemplateRecord){
+			"scope field count": func(otr *OptionsTemplateRecord) { otr.ScopeFieldCount = 2 },
+			"field count":       func(otr *OptionsTemplateRecord) { otr.FieldCount = 2 },
+		} {
+			otr := newRecord()
+			record(otr)
+			buf := &bytes.Buffer{}
+			if _, err := otr.Encode(buf); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%s: expected nothing to be written, found %d bytes", name, buf.Len())
+			}
+		}
+	})
+}
+
+func TestTemplateYAML(t *testing.T) {
+	ies := iana()
+
+	t.Run("template round trip", func(t *testing.T) {
+		template := &Template{
+			TemplateMetadata: &TemplateMetadata{
+				TemplateId:          300,
+				ObservationDomainId: 1,
+				CreationTimestamp:   time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC),
+			},
+			Record: &TemplateRecord{
+				TemplateId: 300,
+				Fields: []Field{
+					NewFieldBuilder(ies[8]).SetLength(4).Complete(),
+					NewFieldBuilder(ies[2]).SetLength(4).Complete(),
+					NewFieldBuilder(ies[96]).SetLength(VariableLength).Complete(),
+				},
+			},
+		}
+
+		b, err := yaml.Marshal(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := &Template{}
+		err = yaml.Unmarshal(b, restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !restored.CreationTimestamp.Equal(template.CreationTimestamp) || restored.ObservationDomainId != 1 {
+			t.Errorf("expected metadata %v, found %v", template.TemplateMetadata, restored.TemplateMetadata)
+		}
+		tr, ok := restored.Record.(*TemplateRecord)
+		if !ok {
+			t.Fatalf("expected %T, found %T", &TemplateRecord{}, restored.Record)
+		}
+		if tr.TemplateId != 300 || tr.FieldCount != 3 {
+			t.Errorf("expected template 300 with 3 fields, found %d with %d fields", tr.TemplateId, tr.FieldCount)
+		}
+		for i, f := range tr.Fields {
+			expected := template.Record.(*TemplateRecord).Fields[i]
+			if f.Id() != expected.Id() || f.Name() != expected.Name() || f.Type() != expected.Type() || f.Length() != expected.Length() {
+				t.Errorf("expected field %v, found %v", expected, f)
+			}
+		}
+		if _, ok := tr.Fields[2].(*VariableLengthField); !ok {
+			t.Errorf("expected %T, found %T", &VariableLengthField{}, tr.Fields[2])
+		}
+	})
+
+	t.Run("options template round trip", func(t *testing.T) {
+		template := &Template{
+			Record: &OptionsTemplateRecord{
+				TemplateId: 1591,
+				Scopes: []Field{
+					NewFieldBuilder(ies[346]).SetLength(4).Complete().SetScoped(),
+				},
+				Options: []Field{
+					NewFieldBuilder(ies[339]).SetLength(1).Complete(),
+					NewFieldBuilder(ies[341]).SetLength(VariableLength).Complete(),
+				},
+			},
+		}
+
+		b, err := yaml.Marshal(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := &Template{}
+		err = yaml.Unmarshal(b, restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		otr, ok := restored.Record.(*OptionsTemplateRecord)
+		if !ok {
+			t.Fatalf("expected %T, found %T", &OptionsTemplateRecord{}, restored.Record)
+		}
+		if otr.ScopeFieldCount != 1 || otr.FieldCount != 3 {
+			t.Errorf("expected 1 scope and 3 fields, found %d and %d", otr.ScopeFieldCount, otr.FieldCount)
+		}
+		if !otr.Scopes[0].IsScope() || otr.Scopes[0].Id() != 346 {
+			t.Errorf("expected scope field 346, found %v", otr.Scopes[0])
+		}
+		if otr.Options[0].Id() != 339 || otr.Options[1].Id() != 341 {
+			t.Errorf("expected option fields 339 and 341, found %v", otr.Options)
+		}
+	})
+
+	t.Run("data record round trip", func(t *testing.T) {
+		start := time.Date(2023, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+		record := &DataRecord{
+			TemplateId: 300,
+			FieldCount: 5,
+			Fields: []Field{
+				NewFieldBuilder(ies[8]).SetLength(4).Complete().SetValue("192.0.2.1"),
+				NewFieldBuilder(ies[2]).SetLength(8).Complete().SetValue(1<<53 + 1),
+				NewFieldBuilder(ies[96]).SetLength(VariableLength).Complete().SetValue("https"),
+				NewFieldBuilder(ies[152]).SetLength(8).Complete().SetValue(start),
+				NewFieldBuilder(ies[210]).SetLength(3).Complete().SetValue([]byte{0x00, 0x01, 0x02}),
+			},
+		}
+
+		b, err := yaml.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := &DataRecord{}
+		err = yaml.Unmarshal(b, restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if restored.TemplateId != 300 || len(restored.Fields) != len(record.Fields) {
+			t.Fatalf("expected record %v, found %v", record, restored)
+		}
+		for i, f := range restored.Fields {
+			expected := record.Fields[i]
+			if f.Type() != expected.Type() || f.Value().String() != expected.Value().String() {
+				t.Errorf("expected field %v, found %v", expected, f)
+			}
+		}
+		if v, _ := restored.Uint64("packetDeltaCount"); v != 1<<53+1 {
+			t.Errorf("expected value %d, found %d", 1<<53+1, v)
+		}
+	})
+}
+
+func TestTemplateKey(t *testing.T) {
+	t.Run("from metadata and record", func(t *testing.T) {
+		tr := &Template{
+			TemplateMetadata: &TemplateMetadata{TemplateId: 300, ObservationDomainId: 7},
+			Record:           &TemplateRecord{TemplateId: 300},
+		}
+		if key := tr.Key(); key != NewKey(7, 300) {
+			t.Errorf("expected key of template 300 in observation domain 7, got %v", key)
+		}
+	})
+
+	t.Run("without metadata", func(t *testing.T) {
+		tr := &Template{Record: &OptionsTemplateRecord{TemplateId: 301}}
+		if key := tr.Key(); key != NewKey(0, 301) {
+			t.Errorf("expected key of template 301 in observation domain 0, got %v", key)
+		}
+	})
+}
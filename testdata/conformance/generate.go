@@ -0,0 +1,352 @@
+//go:build ignore
+
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// generate writes the fixtures of the conformance tests in IPFIX File Format, i.e., as
+// concatenated messages. Run it from the repository's root with
+//
+//	go run testdata/conformance/generate.go
+//
+// and regenerate the golden files afterwards with
+//
+//	go test -run TestConformance . -ipfixtest.update
+//
+// The messages are assembled byte by byte rather than with this module's encoder, such that the
+// fixtures do not inherit its mistakes. Each family mirrors the template layouts and wire format
+// quirks of an exporter:
+//
+//   - softflowd: plain IANA templates for IPv4 and IPv6 flows with reduced-size counters, and an
+//     options record announcing the exporter's init time
+//   - yaf: CERT (PEN 6871) and RFC 5103 reverse elements, and DPI data in a subTemplateMultiList
+//     nesting basicLists and a subTemplateList
+//   - nprobe: templates and data in the same message, enterprise elements of ntop (PEN 35632)
+//     that are unknown to the field cache, and variable-length fields in the three-byte
+//     length format
+//   - router: sets padded to multiples of 4 bytes as done by hardware routers, options records
+//     describing sampling and interfaces. The padding is lost when decoding, so this family is
+//     not canonical
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ntopPEN    uint32 = 35632
+	certPEN    uint32 = 6871
+	reversePEN uint32 = 29305
+
+	// 2023-10-01T12:00:00Z
+	exportTime uint32 = 1696161600
+)
+
+type field struct {
+	pen    uint32
+	id     uint16
+	length uint16
+}
+
+func ie(id, length uint16) field { return field{id: id, length: length} }
+
+func enterprise(pen uint32, id, length uint16) field {
+	return field{pen: pen, id: id, length: length}
+}
+
+func (f field) bytes() []byte {
+	if f.pen == 0 {
+		return cat(u16(f.id), u16(f.length))
+	}
+	return cat(u16(0x8000|f.id), u16(f.length), u32(f.pen))
+}
+
+func cat(parts ...[]byte) []byte {
+	b := make([]byte, 0)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func u8(v uint8) []byte   { return []byte{v} }
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+func u24(v uint32) []byte { return u32(v)[1:] }
+
+func ip(s string) []byte { return netip.MustParseAddr(s).AsSlice() }
+
+// varlen prefixes b with its length in the one-byte format if possible
+func varlen(b []byte) []byte {
+	if len(b) < 255 {
+		return cat(u8(uint8(len(b))), b)
+	}
+	return cat(u8(255), u16(uint16(len(b))), b)
+}
+
+func templateRecord(id uint16, fields ...field) []byte {
+	b := cat(u16(id), u16(uint16(len(fields))))
+	for _, f := range fields {
+		b = append(b, f.bytes()...)
+	}
+	return b
+}
+
+func optionsTemplateRecord(id uint16, scopes int, fields ...field) []byte {
+	b := cat(u16(id), u16(uint16(len(fields))), u16(uint16(scopes)))
+	for _, f := range fields {
+		b = append(b, f.bytes()...)
+	}
+	return b
+}
+
+// set creates a set of the given records, padded with zeros to a multiple of padTo bytes
+func set(id uint16, padTo int, records ...[]byte) []byte {
+	body := cat(records...)
+	if padTo > 0 {
+		for (4+len(body))%padTo != 0 {
+			body = append(body, 0)
+		}
+	}
+	return cat(u16(id), u16(uint16(4+len(body))), body)
+}
+
+func message(sequenceNumber, observationDomainId uint32, sets ...[]byte) []byte {
+	body := cat(sets...)
+	return cat(u16(10), u16(uint16(16+len(body))), u32(exportTime), u32(sequenceNumber), u32(observationDomainId), body)
+}
+
+// basicList creates the content of a basicList of variable-length elements
+func basicList(pen uint32, id uint16, elements ...string) []byte {
+	b := cat(u8(0xFF), field{pen: pen, id: id, length: 0xFFFF}.bytes())
+	for _, el := range elements {
+		b = append(b, varlen([]byte(el))...)
+	}
+	return b
+}
+
+// subTemplateList creates the content of a subTemplateList of records of templateId
+func subTemplateList(semantic uint8, templateId uint16, records ...[]byte) []byte {
+	return cat(u8(semantic), u16(templateId), cat(records...))
+}
+
+type subTemplateMultiListEntry struct {
+	templateId uint16
+	records    [][]byte
+}
+
+// subTemplateMultiList creates the content of a subTemplateMultiList
+func subTemplateMultiList(semantic uint8, entries ...subTemplateMultiListEntry) []byte {
+	b := u8(semantic)
+	for _, e := range entries {
+		records := cat(e.records...)
+		b = append(b, cat(u16(e.templateId), u16(uint16(4+len(records))), records)...)
+	}
+	return b
+}
+
+func softflowd() [][]byte {
+	const odid = 1
+	v4 := templateRecord(1024,
+		ie(8, 4), ie(12, 4), ie(7, 2), ie(11, 2), ie(4, 1), ie(6, 1), ie(5, 1), ie(60, 1),
+		ie(1, 4), ie(2, 4), ie(22, 4), ie(21, 4),
+	)
+	v6 := templateRecord(2048,
+		ie(27, 16), ie(28, 16), ie(7, 2), ie(11, 2), ie(4, 1), ie(6, 1), ie(5, 1), ie(60, 1),
+		ie(1, 4), ie(2, 4), ie(22, 4), ie(21, 4),
+	)
+	// scope observationDomainId, systemInitTimeMilliseconds, exportProtocolVersion,
+	// exportTransportProtocol
+	options := optionsTemplateRecord(256, 1, ie(149, 4), ie(160, 8), ie(214, 1), ie(215, 1))
+
+	flow4 := func(src, dst string, sport, dport uint16, proto, flags uint8, octets, packets, start, end uint32) []byte {
+		return cat(ip(src), ip(dst), u16(sport), u16(dport), u8(proto), u8(flags), u8(0), u8(4),
+			u32(octets), u32(packets), u32(start), u32(end))
+	}
+	flow6 := func(src, dst string, sport, dport uint16, proto, flags uint8, octets, packets, start, end uint32) []byte {
+		return cat(ip(src), ip(dst), u16(sport), u16(dport), u8(proto), u8(flags), u8(0), u8(6),
+			u32(octets), u32(packets), u32(start), u32(end))
+	}
+
+	return [][]byte{
+		message(0, odid,
+			set(2, 0, v4, v6),
+			set(3, 0, options),
+			set(256, 0, cat(u32(odid), u64(1696161000000), u8(10), u8(17))),
+		),
+		message(1, odid,
+			set(1024, 0,
+				flow4("192.0.2.10", "198.51.100.20", 51514, 443, 6, 0x1b, 5821, 14, 600120, 601980),
+				flow4("198.51.100.20", "192.0.2.10", 443, 51514, 6, 0x1b, 48213, 38, 600125, 601979),
+				flow4("192.0.2.10", "203.0.113.53", 40213, 53, 17, 0, 71, 1, 602001, 602001),
+			),
+		),
+		message(4, odid,
+			set(2048, 0,
+				flow6("2001:db8::10", "2001:db8:1::443", 51600, 443, 6, 0x1a, 1412, 6, 603100, 603300),
+				flow6("2001:db8:1::443", "2001:db8::10", 443, 51600, 6, 0x1a, 9634, 9, 603101, 603299),
+			),
+			set(1024, 0,
+				flow4("192.0.2.11", "198.51.100.80", 33001, 80, 6, 0x02, 60, 1, 604000, 604000),
+			),
+		),
+	}
+}
+
+func yaf() [][]byte {
+	const odid = 0
+	flow := templateRecord(1000,
+		ie(152, 8), ie(153, 8), ie(8, 4), ie(12, 4), ie(7, 2), ie(11, 2), ie(4, 1), ie(136, 1),
+		enterprise(certPEN, 33, 2),
+		ie(85, 8), enterprise(reversePEN, 85, 8), ie(86, 8), enterprise(reversePEN, 86, 8),
+		enterprise(certPEN, 14, 1), enterprise(certPEN, 15, 1),
+		ie(293, 0xFFFF),
+	)
+	// httpServerStringList, httpUserAgentList
+	http := templateRecord(1001, enterprise(certPEN, 338, 0xFFFF), enterprise(certPEN, 339, 0xFFFF))
+	// dnsName, dnsTTL, dnsQueryResponse
+	dnsRR := templateRecord(1002, enterprise(certPEN, 179, 0xFFFF), enterprise(certPEN, 199, 4), enterprise(certPEN, 174, 1))
+	dns := templateRecord(1003, ie(292, 0xFFFF))
+	// scope observationDomainId, exportedMessageTotalCount, exportedFlowRecordTotalCount,
+	// systemInitTimeMilliseconds
+	stats := optionsTemplateRecord(1100, 1, ie(149, 4), ie(41, 8), ie(42, 8), ie(160, 8))
+
+	httpFlow := cat(
+		u64(1696161540123), u64(1696161541456), ip("192.0.2.20"), ip("198.51.100.80"), u16(49152), u16(80), u8(6), u8(3),
+		u16(80),
+		u64(712), u64(18311), u64(7), u64(15),
+		u8(0x02), u8(0x1b),
+		varlen(subTemplateMultiList(0xFF,
+			subTemplateMultiListEntry{1001, [][]byte{cat(
+				varlen(basicList(certPEN, 110, "nginx/1.24.0")),
+				varlen(basicList(certPEN, 111, "curl/8.4.0", "Mozilla/5.0 (X11; Linux x86_64)")),
+			)}},
+		)),
+	)
+	dnsFlow := cat(
+		u64(1696161542000), u64(1696161542031), ip("192.0.2.20"), ip("203.0.113.53"), u16(53124), u16(53), u8(17), u8(1),
+		u16(53),
+		u64(62), u64(94), u64(1), u64(1),
+		u8(0), u8(0),
+		varlen(subTemplateMultiList(0xFF,
+			subTemplateMultiListEntry{1003, [][]byte{
+				varlen(subTemplateList(3, 1002,
+					cat(varlen([]byte("example.org.")), u32(0), u8(0)),
+					cat(varlen([]byte("example.org.")), u32(3600), u8(1)),
+				)),
+			}},
+		)),
+	)
+
+	return [][]byte{
+		message(0, odid,
+			set(2, 0, flow, http, dnsRR, dns),
+			set(3, 0, stats),
+		),
+		message(0, odid, set(1000, 0, httpFlow, dnsFlow)),
+		message(2, odid, set(1100, 0, cat(u32(odid), u64(3), u64(2), u64(1696161500000)))),
+	}
+}
+
+func nprobe() [][]byte {
+	const odid = 2
+	flow := templateRecord(257,
+		ie(8, 4), ie(12, 4), ie(7, 2), ie(11, 2), ie(4, 1), ie(1, 8), ie(2, 8), ie(150, 4), ie(151, 4),
+		enterprise(ntopPEN, 118, 2),
+		enterprise(ntopPEN, 180, 0xFFFF),
+		enterprise(ntopPEN, 187, 0xFFFF),
+	)
+	long := "/search?q="
+	for len(long) < 300 {
+		long += "conformance+"
+	}
+	record := func(src, dst string, sport, dport uint16, octets, packets uint64, start, end uint32, app uint16, url, host string) []byte {
+		return cat(ip(src), ip(dst), u16(sport), u16(dport), u8(6), u64(octets), u64(packets), u32(start), u32(end),
+			u16(app), varlen([]byte(url)), varlen([]byte(host)))
+	}
+
+	return [][]byte{
+		message(0, odid,
+			set(2, 0, flow),
+			set(257, 0,
+				record("192.0.2.30", "198.51.100.80", 50100, 80, 1320, 9, 1696161590, 1696161592, 7, "/index.html", "www.example.com"),
+				record("192.0.2.30", "198.51.100.80", 50102, 80, 2210, 11, 1696161593, 1696161595, 7, long, "www.example.com"),
+			),
+		),
+		message(2, odid,
+			set(257, 0,
+				record("192.0.2.31", "198.51.100.43", 50200, 443, 4410, 12, 1696161596, 1696161599, 91, "", ""),
+			),
+		),
+	}
+}
+
+func router() [][]byte {
+	const odid = 0x00080000
+	flow := templateRecord(320,
+		ie(8, 4), ie(12, 4), ie(7, 2), ie(11, 2), ie(4, 1), ie(5, 1), ie(6, 1),
+		ie(10, 4), ie(14, 4), ie(15, 4), ie(16, 4), ie(17, 4), ie(9, 1), ie(13, 1), ie(61, 1), ie(70, 3),
+		ie(1, 8), ie(2, 8), ie(152, 8), ie(153, 8),
+	)
+	// scope observationDomainId, samplingPacketInterval, selectorAlgorithm
+	sampling := optionsTemplateRecord(512, 1, ie(149, 4), ie(305, 4), ie(304, 2))
+	// scope ingressInterface, interfaceName, interfaceDescription
+	interfaces := optionsTemplateRecord(513, 1, ie(10, 4), ie(82, 0xFFFF), ie(83, 0xFFFF))
+
+	record := func(src, dst string, sport, dport uint16, proto, tos, flags uint8, in, out uint32, nextHop string, srcAs, dstAs uint32, srcMask, dstMask, direction uint8, label uint32, octets, packets, start, end uint64) []byte {
+		return cat(ip(src), ip(dst), u16(sport), u16(dport), u8(proto), u8(tos), u8(flags),
+			u32(in), u32(out), ip(nextHop), u32(srcAs), u32(dstAs), u8(srcMask), u8(dstMask), u8(direction), u24(label),
+			u64(octets), u64(packets), u64(start), u64(end))
+	}
+
+	return [][]byte{
+		message(0, odid,
+			set(2, 4, flow),
+			set(3, 4, sampling),
+			set(3, 4, interfaces),
+		),
+		message(0, odid,
+			set(512, 4, cat(u32(odid), u32(1000), u16(3))),
+			set(513, 4,
+				cat(u32(544), varlen([]byte("ge-0/0/0")), varlen([]byte("uplink transit-a"))),
+				cat(u32(545), varlen([]byte("ge-0/0/1")), varlen([]byte("customer-b"))),
+			),
+		),
+		message(3, odid,
+			set(320, 4,
+				record("192.0.2.40", "198.51.100.90", 62000, 443, 6, 0, 0x18, 544, 545, "203.0.113.1", 64496, 64511, 24, 24, 0, 0x3e81, 1500000, 1000, 1696161500000, 1696161560000),
+				record("198.51.100.90", "192.0.2.40", 443, 62000, 6, 0, 0x18, 545, 544, "203.0.113.2", 64511, 64496, 24, 24, 1, 0x3e80, 90000, 600, 1696161500010, 1696161559990),
+			),
+		),
+	}
+}
+
+func main() {
+	for name, messages := range map[string][][]byte{
+		"softflowd": softflowd(),
+		"yaf":       yaf(),
+		"nprobe":    nprobe(),
+		"router":    router(),
+	} {
+		if err := os.WriteFile(filepath.Join("testdata", "conformance", name+".ipfix"), cat(messages...), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// tlsHandshakeTimeout limits the duration of TLS handshakes of accepted connections, such that
+// clients not completing the handshake do not occupy a connection slot indefinitely
+var tlsHandshakeTimeout time.Duration = 10 * time.Second
+
+// ErrExporterNotAuthorized is returned by the verification function of VerifyExporterSAN for
+// exporters whose certificate does not contain any of the authorized names
+var ErrExporterNotAuthorized error = errors.New("exporter not authorized")
+
+// WithTCPTLSConfig makes the listener wrap accepted connections in a TLS server connection using
+// cfg, as per RFC 7011, Section 11. The handshake is done in the connection's goroutine, such that
+// slow or failing clients do not block accepting further connections. Failed handshakes are
+// counted as TCP errors.
+//
+// Exporters are authenticated with client certificates by setting cfg.ClientAuth to
+// tls.RequireAndVerifyClientCert and cfg.ClientCAs. Exporters can additionally be authorized by
+// their certificate's subject alternative names with cfg.VerifyConnection, see VerifyExporterSAN.
+func WithTCPTLSConfig(cfg *tls.Config) TCPListenerOption {
+	return func(l *TCPListener) {
+		l.tlsConfig = cfg
+	}
+}
+
+// NewTLSListener creates a TCPListener accepting TLS connections with the given configuration,
+// which must contain at least one certificate. It is equivalent to NewTCPListener with
+// WithTCPTLSConfig.
+func NewTLSListener(bindAddr string, cfg *tls.Config, opts ...TCPListenerOption) *TCPListener {
+	return NewTCPListener(bindAddr, append(opts, WithTCPTLSConfig(cfg))...)
+}
+
+// handshake wraps conn in a TLS server connection and completes the handshake. conn is closed
+// if the handshake fails.
+func (l *TCPListener) handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Server(conn, l.tlsConfig)
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	err := tlsConn.HandshakeContext(handshakeCtx)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to complete TLS handshake, %w", err)
+	}
+	return tlsConn, nil
+}
+
+// VerifyExporterSAN returns a function for tls.Config.VerifyConnection of a TLS listener that
+// accepts exporters only if their certificate contains one of the given DNS names, IP addresses,
+// or URIs as subject alternative name. The function does not verify the certificate chain itself,
+// which requires cfg.ClientAuth to be tls.RequireAndVerifyClientCert.
+func VerifyExporterSAN(names ...string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("%w, no client certificate", ErrExporterNotAuthorized)
+		}
+		cert := cs.PeerCertificates[0]
+
+		sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.URIs))
+		sans = append(sans, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		for _, san := range sans {
+			if slices.Contains(names, san) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w, certificate of %q contains none of the authorized names", ErrExporterNotAuthorized, cert.Subject.CommonName)
+	}
+}
+
+// DialTLS connects to the TLS listener of a collector at addr and completes the handshake. IPFIX
+// messages written to the returned connection are framed by their length field, such that they
+// can be written as is, e.g., by ExportSession.Flush.
+func DialTLS(addr string, cfg *tls.Config) (io.WriteCloser, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s, %w", addr, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,259 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func freeTCPAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// testCA issues self-signed certificates for testing TLS listeners
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-ipfix test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue creates a certificate for the given DNS names and IP addresses signed by the CA
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames []string, ips []net.IP) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSListener(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "collector", nil, []net.IP{net.IPv4(127, 0, 0, 1)})
+
+	exporterCert := ca.issue(t, "exporter", []string{"exporter.example.com"}, nil)
+
+	clientConfig := func(cert tls.Certificate) *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      ca.pool,
+		}
+	}
+
+	// startTLSListener runs a TLS listener authorizing exporters named exporter.example.com until
+	// the test ends
+	startTLSListener := func(t *testing.T) (*TCPListener, *Metrics, string) {
+		metrics := NewMetrics()
+		if err := metrics.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		addr := freeTCPAddr(t)
+		l := NewTLSListener(addr, &tls.Config{
+			Certificates:     []tls.Certificate{serverCert},
+			ClientAuth:       tls.RequireAndVerifyClientCert,
+			ClientCAs:        ca.pool,
+			VerifyConnection: VerifyExporterSAN("exporter.example.com"),
+		}).WithMetrics(metrics)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = l.Listen(ctx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+
+		// wait for the listener to accept connections of authorized exporters
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			conn, err := DialTLS(addr, clientConfig(exporterCert))
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for listener")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return l, metrics, addr
+	}
+
+	// waitErrors waits for the listener to count exactly n errors
+	waitErrors := func(t *testing.T, metrics *Metrics, addr string, n float64) {
+		deadline := time.Now().Add(2 * time.Second)
+		for testutil.ToFloat64(metrics.TCPErrorsTotal.WithLabelValues(addr)) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %v errors", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if c := testutil.ToFloat64(metrics.TCPErrorsTotal.WithLabelValues(addr)); c != n {
+			t.Errorf("expected %v errors, got %v", n, c)
+		}
+	}
+
+	exchange := func(t *testing.T, l *TCPListener, addr string) {
+		conn, err := DialTLS(addr, clientConfig(exporterCert))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		msg := newTestDataMessage(256, 2)
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case received := <-l.Messages():
+			if !bytes.Equal(received, msg) {
+				t.Errorf("expected message %x, got %x", msg, received)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	t.Run("exchanges a message end to end", func(t *testing.T) {
+		l, metrics, addr := startTLSListener(t)
+		exchange(t, l, addr)
+		if c := testutil.ToFloat64(metrics.TCPErrorsTotal.WithLabelValues(addr)); c != 0 {
+			t.Errorf("expected no errors, got %v", c)
+		}
+	})
+
+	t.Run("handshake failures do not stop accepting", func(t *testing.T) {
+		l, metrics, addr := startTLSListener(t)
+
+		// a plaintext exporter fails the handshake
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(newTestDataMessage(256, 1)); err != nil {
+			t.Fatal(err)
+		}
+		waitErrors(t, metrics, addr, 1)
+		conn.Close()
+
+		// an exporter without client certificate fails the handshake
+		tlsConn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		if err == nil {
+			// with TLS 1.3, the client completes its side of the handshake before the server
+			// rejects the missing certificate
+			tlsConn.Close()
+		}
+		waitErrors(t, metrics, addr, 2)
+
+		exchange(t, l, addr)
+		waitErrors(t, metrics, addr, 2)
+	})
+
+	t.Run("exporter not authorized by SAN", func(t *testing.T) {
+		l, metrics, addr := startTLSListener(t)
+
+		conn, err := DialTLS(addr, clientConfig(ca.issue(t, "intruder", []string{"intruder.example.com"}, nil)))
+		if err == nil {
+			_, _ = conn.Write(newTestDataMessage(256, 1))
+			defer conn.Close()
+		}
+		waitErrors(t, metrics, addr, 1)
+		select {
+		case <-l.Messages():
+			t.Error("expected messages of unauthorized exporter to be dropped")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("VerifyExporterSAN", func(t *testing.T) {
+		verify := VerifyExporterSAN("exporter.example.com", "192.0.2.1")
+		for name, tc := range map[string]struct {
+			cert       tls.Certificate
+			authorized bool
+		}{
+			"DNS name":   {ca.issue(t, "a", []string{"exporter.example.com"}, nil), true},
+			"IP address": {ca.issue(t, "b", nil, []net.IP{net.IPv4(192, 0, 2, 1)}), true},
+			"other name": {ca.issue(t, "c", []string{"exporter.example.org"}, nil), false},
+		} {
+			cert, err := x509.ParseCertificate(tc.cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+			if tc.authorized && err != nil {
+				t.Errorf("%s: expected exporter to be authorized, got %v", name, err)
+			}
+			if !tc.authorized && !errors.Is(err, ErrExporterNotAuthorized) {
+				t.Errorf("%s: expected ErrExporterNotAuthorized, got %v", name, err)
+			}
+		}
+		if err := verify(tls.ConnectionState{}); !errors.Is(err, ErrExporterNotAuthorized) {
+			t.Errorf("expected ErrExporterNotAuthorized without certificate, got %v", err)
+		}
+	})
+}
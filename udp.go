@@ -21,11 +21,12 @@ import (
 	"errors"
 	"net"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-var (
+const (
 	// UDP packet size is globally limited by the packet header length field of 2^16-1.
 	// However, additionally, IP data path MTU can cause UDP packets larger than the MTU
 	// to be fragmented. If fragments are lost due to packet loss, the UDP packet cannot be
@@ -35,38 +36,140 @@ var (
 	// These days, MTU is most of the times in the ball park of 1500 Bytes. Subtracting IP and UDP
 	// packet header lengths, as well as headers of various encapsulation formats, this then yields
 	// a maximum packet size for UDP packets of 1420 (At least that is what yaf assumes).
-	//
-	// We patched yaf to support larger UDP packets, and can therefore use more bytes here
-	// udpPacketBufferSize int = 0xFFFF, which in turn allows us to use more verbose DPI
+	DefaultUDPPacketBufferSize int = 1500
+
+	// JumboUDPPacketBufferSize is the read buffer size used with WithUDPAllowJumboDatagrams.
+	// We patched yaf to support larger UDP packets, which in turn allows us to use more verbose DPI
 	// with UDP transport (this previously caused a lot of unrecoverable crashes)
-	UDPPacketBufferSize int = 1500
+	JumboUDPPacketBufferSize int = 0xFFFF
 
 	// Number of packets being buffered in the channel. This effectively moves
 	// packet buffering from UDP socket to the user space, which alleviates most
 	// packet loss issues, but also drastically increases memory usage, in face of
 	// 64kbytes allocated per packet.
-	udpChannelBufferSize int = 50
+	DefaultUDPChannelBufferSize int = 50
+)
+
+var (
+	// UDPPacketBufferSize is the read buffer size of listeners created without WithUDPPacketBufferSize.
+	//
+	// Deprecated: mutating UDPPacketBufferSize affects all listeners created afterwards, use
+	// WithUDPPacketBufferSize or WithUDPAllowJumboDatagrams instead.
+	UDPPacketBufferSize int = DefaultUDPPacketBufferSize
 )
 
 type UDPListener struct {
 	bindAddr string
 	packetCh chan []byte
 
+	// packets is used instead of packetCh if not nil, such that consumers such as the Collector
+	// receive the address of the exporter next to each message
+	packets chan packet
+
 	addr     *net.UDPAddr
 	listener net.PacketConn
+
+	metrics *Metrics
+
+	// packetBufferSize is the size of the buffer to read datagrams into, larger datagrams are truncated
+	packetBufferSize int
+
+	// endpoints tracks the statistics of remote endpoints, if not nil
+	endpoints *endpointRegistry
+
+	// receivePool enables reading datagrams into buffers of pool, which are sent on received
+	// instead of packetCh
+	receivePool bool
+	pool        *packetPool
+	received    chan *ReceivedPacket
+}
+
+// UDPListenerOption configures a UDPListener created with NewUDPListener
+type UDPListenerOption func(*UDPListener)
+
+// WithUDPPacketBufferSize sets the size of the buffer datagrams are read into. Larger datagrams
+// are truncated. Defaults to DefaultUDPPacketBufferSize.
+func WithUDPPacketBufferSize(size int) UDPListenerOption {
+	return func(l *UDPListener) {
+		if size > 0 {
+			l.packetBufferSize = size
+		}
+	}
+}
+
+// WithUDPAllowJumboDatagrams sets the read buffer to the maximum size of IPFIX messages, such that
+// datagrams exceeding the common MTU, e.g., from exporters patched to send larger messages, are
+// received entirely.
+func WithUDPAllowJumboDatagrams() UDPListenerOption {
+	return WithUDPPacketBufferSize(JumboUDPPacketBufferSize)
+}
+
+// WithUDPChannelBufferSize sets the number of packets buffered in the channel returned by Messages.
+// If the channel is full, subsequent packets are dropped and counted in UDPDroppedPackets.
+// Defaults to DefaultUDPChannelBufferSize.
+func WithUDPChannelBufferSize(size int) UDPListenerOption {
+	return func(l *UDPListener) {
+		if size >= 0 {
+			l.packetCh = make(chan []byte, size)
+		}
+	}
 }
 
-func NewUDPListener(bindAddr string) *UDPListener {
-	return &UDPListener{
-		bindAddr: bindAddr,
-		packetCh: make(chan []byte, udpChannelBufferSize),
+// WithUDPEndpointStats tracks the statistics of each remote endpoint, which are returned by
+// Endpoints and reported in the endpoint metrics of the listener's Metrics. Endpoints from which
+// no datagram was received for idleTimeout are evicted, an idleTimeout of zero disables eviction.
+// At most maxEndpoints endpoints are tracked, further endpoints are combined into a single
+// endpoint "other". A maxEndpoints of zero disables the limit.
+func WithUDPEndpointStats(maxEndpoints int, idleTimeout time.Duration) UDPListenerOption {
+	return func(l *UDPListener) {
+		l.endpoints = newEndpointRegistry(l.bindAddr, maxEndpoints, idleTimeout)
+		l.endpoints.metrics = l.metrics
+	}
+}
+
+func NewUDPListener(bindAddr string, opts ...UDPListenerOption) *UDPListener {
+	l := &UDPListener{
+		bindAddr:         bindAddr,
+		packetCh:         make(chan []byte, DefaultUDPChannelBufferSize),
+		packetBufferSize: UDPPacketBufferSize,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.receivePool {
+		// the packet buffer size and the channel buffer size are only known after all options
+		l.pool = newPacketPool(l.packetBufferSize)
+		l.received = make(chan *ReceivedPacket, cap(l.packetCh))
 	}
+	return l
+}
+
+// WithMetrics makes the listener report to the given metrics labeled with its bind address
+// instead of the deprecated package-level collectors.
+func (l *UDPListener) WithMetrics(m *Metrics) *UDPListener {
+	l.metrics = m
+	if l.endpoints != nil {
+		l.endpoints.metrics = m
+	}
+	return l
+}
+
+// Endpoints returns the statistics of the remote endpoints of the listener ordered by endpoint.
+// It returns nil for listeners created without WithUDPEndpointStats.
+func (l *UDPListener) Endpoints() []EndpointStats {
+	return l.endpoints.snapshot()
 }
 
 func (l *UDPListener) Listen(ctx context.Context) (err error) {
 	logger := FromContext(ctx)
 	// do this last such that the goroutine reading packets exits before closing the channel
 	defer close(l.packetCh)
+	if l.packets != nil {
+		defer close(l.packets)
+	}
+	if l.received != nil {
+		defer close(l.received)
+	}
 	l.addr, err = net.ResolveUDPAddr("udp", l.bindAddr)
 	if err != nil {
 		logger.Error(err, "failed to resolve UDP address", "addr", l.bindAddr)
@@ -91,35 +194,20 @@ func (l *UDPListener) Listen(ctx context.Context) (err error) {
 	l.listener, err = listenConfig.ListenPacket(ctx, "udp", l.bindAddr)
 	if err != nil {
 		logger.Error(err, "failed to bind udp listener", "addr", l.addr)
+		return err
 	}
 	defer l.listener.Close()
 
 	var rerr error
+	// done is closed when the reader goroutine returned, such that packetCh is not closed while sending
+	done := make(chan struct{})
 	go func() {
-		// allocate this buffer once and re-use it for each packet to read from the socket
-		buffer := make([]byte, UDPPacketBufferSize)
-		for {
-			n, _, err := l.listener.ReadFrom(buffer)
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
-				ErrorsTotal.Inc()
-				rerr = err
-				logger.Error(err, "failed to read from UDP socket")
-				return
-			}
-			PacketsTotal.Inc()
-			UDPPacketBytes.Add(float64(n))
-
-			// allocate a smaller, trimmed to the actual packet size buffer to
-			// dispose the large 2^16 byte buffer to not claim this memory forever,
-			// as just handing "buffer[:n]" will NOT actually shrink the original object
-			packet := make([]byte, n)
-			copy(packet, buffer[:n])
-
-			l.packetCh <- packet
+		defer close(done)
+		if l.pool != nil {
+			rerr = l.readPooled(ctx, l.listener)
+			return
 		}
+		rerr = l.read(ctx, l.listener)
 	}()
 
 	logger.Info("Started UDP listener", "addr", l.bindAddr)
@@ -127,6 +215,10 @@ func (l *UDPListener) Listen(ctx context.Context) (err error) {
 	<-ctx.Done()
 	logger.Info("Shutting down UDP listener", "addr", l.bindAddr)
 
+	// unblock the reader goroutine and wait for it to exit
+	l.listener.Close()
+	<-done
+
 	// use error from reader goroutine if set
 	err = rerr
 	return
@@ -135,3 +227,48 @@ func (l *UDPListener) Listen(ctx context.Context) (err error) {
 func (l *UDPListener) Messages() <-chan []byte {
 	return l.packetCh
 }
+
+// read reads datagrams from conn into a copy each until conn is closed
+func (l *UDPListener) read(ctx context.Context, conn net.PacketConn) error {
+	logger := FromContext(ctx)
+	// allocate this buffer once and re-use it for each packet to read from the socket
+	buffer := make([]byte, l.packetBufferSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			l.metrics.udpErrorsTotal(l.bindAddr).Inc()
+			logger.Error(err, "failed to read from UDP socket")
+			return err
+		}
+		l.metrics.udpPacketsTotal(l.bindAddr).Inc()
+		l.metrics.udpPacketBytes(l.bindAddr).Add(float64(n))
+		if l.endpoints != nil {
+			l.endpoints.observe(endpointOf(addr), n)
+		}
+
+		// allocate a smaller, trimmed to the actual packet size buffer to
+		// dispose the large 2^16 byte buffer to not claim this memory forever,
+		// as just handing "buffer[:n]" will NOT actually shrink the original object
+		packet := make([]byte, n)
+		copy(packet, buffer[:n])
+
+		// never block the read loop on slow consumers, otherwise packets are dropped invisibly
+		// in the socket buffer of the kernel
+		if l.packets != nil {
+			select {
+			case l.packets <- newPacket(addr, packet):
+			default:
+				l.metrics.udpDroppedPackets(l.bindAddr).Inc()
+			}
+			continue
+		}
+		select {
+		case l.packetCh <- packet:
+		default:
+			l.metrics.udpDroppedPackets(l.bindAddr).Inc()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ReceivedPacket is a datagram received by a UDPListener created with WithUDPReceivePool. Its
+// payload is backed by a buffer of the listener's pool, which is reused for subsequent datagrams
+// once the consumer releases the packet.
+type ReceivedPacket struct {
+	// Payload is the datagram. It must not be used after calling Release.
+	Payload []byte
+	// Exporter is the address and port the datagram was received from
+	Exporter netip.AddrPort
+	// ReceivedAt is the time the datagram was received at
+	ReceivedAt time.Time
+
+	buffer []byte
+	// pool is the pool the packet is returned to in Release, it is nil once the packet is released
+	pool *packetPool
+}
+
+// Release returns the packet and its buffer to the pool of the listener it was received by. Neither
+// the packet nor its payload may be used after calling Release, as both are handed out again for
+// subsequent datagrams, which is why Release must be called at most once per packet.
+func (p *ReceivedPacket) Release() {
+	pool := p.pool
+	if pool == nil {
+		return
+	}
+	p.pool = nil
+	p.Payload = nil
+	pool.put(p)
+}
+
+// packetPool recycles packets and their buffers of the size of the listener's packet buffer
+type packetPool struct {
+	pool sync.Pool
+}
+
+func newPacketPool(size int) *packetPool {
+	p := &packetPool{}
+	p.pool.New = func() any {
+		return &ReceivedPacket{buffer: make([]byte, size)}
+	}
+	return p
+}
+
+func (p *packetPool) get() *ReceivedPacket {
+	packet := p.pool.Get().(*ReceivedPacket)
+	packet.pool = p
+	return packet
+}
+
+func (p *packetPool) put(packet *ReceivedPacket) {
+	p.pool.Put(packet)
+}
+
+// udpAddrPortReader is implemented by *net.UDPConn, which reads the remote address of datagrams
+// without allocating
+type udpAddrPortReader interface {
+	ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error)
+}
+
+// WithUDPReceivePool reads datagrams into buffers of a pool instead of allocating a copy of each
+// datagram. Packets are received from ReceivedPackets instead of Messages, and consumers return
+// them to the pool with ReceivedPacket.Release once they are done with the payload, e.g., after
+// decoding it. Decoders using WithLazyFieldValues retain the payload in the decoded fields, such
+// that packets must only be released once the decoded message is no longer used.
+//
+// Each packet holds a buffer of the listener's packet buffer size, such that memory is bounded by
+// the number of unreleased packets rather than allocated anew under bursts. Packets that are
+// never released are garbage collected regardless.
+func WithUDPReceivePool() UDPListenerOption {
+	return func(l *UDPListener) {
+		l.receivePool = true
+	}
+}
+
+// ReceivedPackets returns the channel of datagrams of listeners created with WithUDPReceivePool.
+// It is nil for other listeners.
+func (l *UDPListener) ReceivedPackets() <-chan *ReceivedPacket {
+	return l.received
+}
+
+// readPooled reads datagrams from conn into buffers of the listener's pool until conn is closed
+func (l *UDPListener) readPooled(ctx context.Context, conn net.PacketConn) error {
+	logger := FromContext(ctx)
+
+	reader, ok := conn.(udpAddrPortReader)
+	if !ok {
+		reader = packetConnAddrPortReader{conn}
+	}
+	for {
+		packet := l.pool.get()
+		n, exporter, err := reader.ReadFromUDPAddrPort(packet.buffer)
+		if err != nil {
+			packet.Release()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			l.metrics.udpErrorsTotal(l.bindAddr).Inc()
+			logger.Error(err, "failed to read from UDP socket")
+			return err
+		}
+		exporter = netip.AddrPortFrom(exporter.Addr().Unmap(), exporter.Port())
+		l.metrics.udpPacketsTotal(l.bindAddr).Inc()
+		l.metrics.udpPacketBytes(l.bindAddr).Add(float64(n))
+		if l.endpoints != nil {
+			l.endpoints.observe(exporter.String(), n)
+		}
+
+		packet.Payload = packet.buffer[:n]
+		packet.Exporter = exporter
+		packet.ReceivedAt = time.Now()
+
+		// never block the read loop on slow consumers, dropped packets are returned to the pool
+		select {
+		case l.received <- packet:
+		default:
+			packet.Release()
+			l.metrics.udpDroppedPackets(l.bindAddr).Inc()
+		}
+	}
+}
+
+// packetConnAddrPortReader reads the remote addresses of packet connections other than
+// *net.UDPConn as netip.AddrPort
+type packetConnAddrPortReader struct {
+	net.PacketConn
+}
+
+func (c packetConnAddrPortReader) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	n, addr, err := c.ReadFrom(b)
+	return n, exporterAddrPort(addr), err
+}
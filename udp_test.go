@@ -0,0 +1,319 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUDPListener(t *testing.T) {
+	t.Run("drops packets when consumer stalls", func(t *testing.T) {
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+
+		addr := freeUDPAddr(t)
+		l := NewUDPListener(addr, WithUDPChannelBufferSize(1)).WithMetrics(m)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() { errCh <- l.Listen(ctx) }()
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// do not consume from the listener while sending, the channel holds exactly one packet
+		dropped := m.UDPDroppedPackets.WithLabelValues(addr)
+		deadline := time.Now().Add(2 * time.Second)
+		for testutil.ToFloat64(dropped) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("expected packets to be dropped")
+			}
+			_, _ = conn.Write([]byte{0x00, 0x0a})
+			time.Sleep(time.Millisecond)
+		}
+
+		// drain the buffered packet, the listener must still deliver new packets afterwards
+		<-l.Messages()
+		received := false
+		for i := 0; i < 50 && !received; i++ {
+			_, _ = conn.Write([]byte{0x00, 0x0a})
+			select {
+			case <-l.Messages():
+				received = true
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		if !received {
+			t.Fatal("expected listener to remain responsive after dropping packets")
+		}
+
+		cancel()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("listener did not shut down")
+		}
+	})
+
+	t.Run("jumbo datagrams are not truncated", func(t *testing.T) {
+		addr := freeUDPAddr(t)
+		l := NewUDPListener(addr, WithUDPAllowJumboDatagrams())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go l.Listen(ctx)
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		payload := make([]byte, 9000)
+		for i := 0; i < 50; i++ {
+			_, _ = conn.Write(payload)
+			select {
+			case packet := <-l.Messages():
+				if len(packet) != len(payload) {
+					t.Fatalf("expected packet of %d bytes, got %d", len(payload), len(packet))
+				}
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		t.Fatal("did not receive packet")
+	})
+
+	t.Run("receive pool", func(t *testing.T) {
+		addr := freeUDPAddr(t)
+		l := NewUDPListener(addr, WithUDPReceivePool())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() { errCh <- l.Listen(ctx) }()
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		payloads := [][]byte{newTestDataMessage(256, 4), newTestDataMessage(256, 1)}
+		for _, payload := range payloads {
+			var packet *ReceivedPacket
+			for i := 0; i < 50 && packet == nil; i++ {
+				_, _ = conn.Write(payload)
+				select {
+				case packet = <-l.ReceivedPackets():
+				case <-time.After(20 * time.Millisecond):
+				}
+			}
+			if packet == nil {
+				t.Fatal("did not receive packet")
+			}
+			if !bytes.Equal(packet.Payload, payload) {
+				t.Errorf("expected payload %v, got %v", payload, packet.Payload)
+			}
+			if packet.Exporter.String() != conn.LocalAddr().String() {
+				t.Errorf("expected exporter %s, got %s", conn.LocalAddr().String(), packet.Exporter)
+			}
+			packet.Release()
+			// drain retransmissions, such that the next payload is not compared to this one
+			for drained := false; !drained; {
+				select {
+				case p := <-l.ReceivedPackets():
+					p.Release()
+				case <-time.After(20 * time.Millisecond):
+					drained = true
+				}
+			}
+		}
+		select {
+		case msg := <-l.Messages():
+			t.Errorf("expected no messages without pool, got %v", msg)
+		default:
+		}
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := <-l.ReceivedPackets(); ok {
+			t.Error("expected channel of received packets to be closed")
+		}
+	})
+
+	t.Run("receive pool returns dropped packets", func(t *testing.T) {
+		l := NewUDPListener("test", WithUDPReceivePool(), WithUDPChannelBufferSize(1))
+		conn := newTestPacketConn(newTestDataMessage(256, 1), 3)
+		if err := l.readPooled(context.Background(), conn); err != nil {
+			t.Fatal(err)
+		}
+		if len(l.received) != 1 {
+			t.Fatalf("expected a single buffered packet, got %d", len(l.received))
+		}
+		packet := <-l.received
+		if packet.Exporter != conn.addr.AddrPort() || !bytes.Equal(packet.Payload, conn.payload) {
+			t.Errorf("unexpected packet %+v", packet)
+		}
+	})
+
+	t.Run("endpoint stats", func(t *testing.T) {
+		m := NewMetrics()
+		if err := m.Register(prometheus.NewRegistry()); err != nil {
+			t.Fatal(err)
+		}
+		addr := freeUDPAddr(t)
+		l := NewUDPListener(addr, WithUDPEndpointStats(16, time.Minute)).WithMetrics(m)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go l.Listen(ctx)
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		payload := newTestDataMessage(256, 1)
+		received := 0
+		for i := 0; i < 50 && received < 2; i++ {
+			_, _ = conn.Write(payload)
+			select {
+			case <-l.Messages():
+				received++
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		if received < 2 {
+			t.Fatal("did not receive packets")
+		}
+
+		stats := l.Endpoints()
+		if len(stats) != 1 {
+			t.Fatalf("expected a single endpoint, got %+v", stats)
+		}
+		s := stats[0]
+		if s.Endpoint != conn.LocalAddr().String() {
+			t.Errorf("expected endpoint %s, got %s", conn.LocalAddr().String(), s.Endpoint)
+		}
+		if s.Messages < 2 || s.Bytes != s.Messages*uint64(len(payload)) || s.Session != nil {
+			t.Errorf("unexpected statistics %+v", s)
+		}
+		if c := testutil.ToFloat64(m.ListenerEndpointMessages.WithLabelValues(addr, s.Endpoint)); c != float64(s.Messages) {
+			t.Errorf("expected %d messages in metrics, got %v", s.Messages, c)
+		}
+	})
+}
+
+// testPacketConn serves the same datagram n times and is closed afterwards
+type testPacketConn struct {
+	net.PacketConn
+
+	payload []byte
+	addr    *net.UDPAddr
+	n       int
+}
+
+func newTestPacketConn(payload []byte, n int) *testPacketConn {
+	return &testPacketConn{
+		payload: payload,
+		addr:    &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 4739},
+		n:       n,
+	}
+}
+
+// ReadFrom returns a new address for every datagram, as *net.UDPConn does
+func (c *testPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.n == 0 {
+		return 0, nil, net.ErrClosed
+	}
+	c.n--
+	addr := *c.addr
+	return copy(b, c.payload), &addr, nil
+}
+
+func (c *testPacketConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	if c.n == 0 {
+		return 0, netip.AddrPort{}, net.ErrClosed
+	}
+	c.n--
+	return copy(b, c.payload), c.addr.AddrPort(), nil
+}
+
+// BenchmarkUDPListenerRead compares allocations of copying each datagram with reading datagrams
+// into buffers of the receive pool under sustained load, i.e., with a consumer draining the
+// listener's channel
+func BenchmarkUDPListenerRead(b *testing.B) {
+	payload := newTestDataMessage(256, 8)
+
+	b.Run("copy", func(b *testing.B) {
+		l := NewUDPListener("bench")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range l.packetCh {
+			}
+		}()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		if err := l.read(context.Background(), newTestPacketConn(payload, b.N)); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		close(l.packetCh)
+		<-done
+	})
+
+	b.Run("pool", func(b *testing.B) {
+		l := NewUDPListener("bench", WithUDPReceivePool())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for packet := range l.received {
+				packet.Release()
+			}
+		}()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		if err := l.readPooled(context.Background(), newTestPacketConn(payload, b.N)); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		close(l.received)
+		<-done
+	})
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package ipfix
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/zoomoid/go-ipfix/iana/semantics"
 	"github.com/zoomoid/go-ipfix/iana/status"
 )
@@ -35,3 +38,74 @@ func NewUnassignedFieldBuilder(id uint16) *FieldBuilder {
 		Status:       status.Undefined,
 	})
 }
+
+// NewUnknownFieldBuilder creates a FieldBuilder for an information element that is not known to a FieldCache,
+// e.g., an enterprise-specific IE of an unregistered PEN. Fields created by the builder are named "unknown(pen/id)"
+// and decode into an opaque octetArray, such that the remainder of a record can still be decoded.
+func NewUnknownFieldBuilder(enterpriseId uint32, id uint16) *FieldBuilder {
+	b := NewFieldBuilder(&InformationElement{
+		Name:         unknownFieldName(enterpriseId, id),
+		Id:           id,
+		EnterpriseId: enterpriseId,
+		Constructor:  NewOctetArray,
+		Semantics:    semantics.Undefined,
+		Status:       status.Undefined,
+	})
+	b.unknown = true
+	return b
+}
+
+func unknownFieldName(enterpriseId uint32, id uint16) string {
+	return fmt.Sprintf("unknown(%d/%d)", enterpriseId, id)
+}
+
+// isUnknownField returns true if f was created by a builder of NewUnknownFieldBuilder
+func isUnknownField(f Field) bool {
+	switch ff := f.(type) {
+	case *FixedLengthField:
+		return ff.unknown
+	case *VariableLengthField:
+		return ff.unknown
+	}
+	return false
+}
+
+// unknownFieldCache decorates a FieldCache used by a Decoder. If strict, it fails lookups of unknown
+// fields with ErrUnknownField instead of returning a builder for an opaque field.
+type unknownFieldCache struct {
+	FieldCache
+
+	strict bool
+}
+
+var _ FieldCache = &unknownFieldCache{}
+
+func newUnknownFieldCache(fieldCache FieldCache, strict bool) FieldCache {
+	if fieldCache == nil {
+		return nil
+	}
+	if c, ok := fieldCache.(*unknownFieldCache); ok {
+		fieldCache = c.FieldCache
+	}
+	return &unknownFieldCache{
+		FieldCache: fieldCache,
+		strict:     strict,
+	}
+}
+
+func (c *unknownFieldCache) GetBuilder(ctx context.Context, key FieldKey) (*FieldBuilder, error) {
+	b, err := c.FieldCache.GetBuilder(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil && !b.IsUnknown() {
+		return b, nil
+	}
+	if c.strict {
+		return nil, unknownField(key.EnterpriseId, key.Id)
+	}
+	if b == nil {
+		b = NewUnknownFieldBuilder(key.EnterpriseId, key.Id)
+	}
+	return b, nil
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// unregisteredPEN is an enterprise number not known to the IANA field cache
+const unregisteredPEN uint32 = 99999
+
+// newUnknownFieldTemplate creates a template record of sourceIPv4Address, an enterprise-specific
+// field of unregisteredPEN, and sourceTransportPort
+func newUnknownFieldTemplate(templateId uint16) []byte {
+	b := make([]byte, 0)
+	b = binary.BigEndian.AppendUint16(b, templateId)
+	b = binary.BigEndian.AppendUint16(b, 3)
+	b = binary.BigEndian.AppendUint16(b, 8)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = binary.BigEndian.AppendUint16(b, 0x8000|18)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = binary.BigEndian.AppendUint32(b, unregisteredPEN)
+	b = binary.BigEndian.AppendUint16(b, 7)
+	b = binary.BigEndian.AppendUint16(b, 2)
+	return b
+}
+
+func TestUnknownFields(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("template and data record with unknown field", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		metrics := NewMetrics()
+		err := metrics.Register(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache)).WithMetrics(metrics, "test")
+
+		tr := TemplateRecord{
+			fieldCache:    decoder.fieldCache,
+			templateCache: templateCache,
+		}
+		_, err = tr.Decode(bytes.NewBuffer(newUnknownFieldTemplate(256)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := tr.Fields[1].Name(); name != "unknown(99999/18)" {
+			t.Errorf("expected field name %s, found %s", "unknown(99999/18)", name)
+		}
+		if c := testutil.CollectAndCount(metrics.UnknownFields); c != 0 {
+			t.Errorf("expected unknown fields of templates not to be counted, found %d series", c)
+		}
+
+		err = templateCache.Add(ctx, NewKey(0, 256), &Template{
+			TemplateMetadata: &TemplateMetadata{
+				TemplateId:        256,
+				CreationTimestamp: time.Now(),
+			},
+			Record: &tr,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set := []byte{192, 0, 2, 1, 0xde, 0xad, 0xbe, 0xef, 0x01, 0xbb}
+		msg := make([]byte, 0)
+		msg = binary.BigEndian.AppendUint16(msg, 10)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(16+4+len(set)))
+		msg = binary.BigEndian.AppendUint32(msg, uint32(time.Now().Unix()))
+		msg = binary.BigEndian.AppendUint32(msg, 0)
+		msg = binary.BigEndian.AppendUint32(msg, 0)
+		msg = binary.BigEndian.AppendUint16(msg, 256)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(4+len(set)))
+		msg = append(msg, set...)
+
+		m, err := decoder.Decode(ctx, bytes.NewBuffer(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := m.Sets[0].Set.(*DataSet).Records
+		if len(records) != 1 {
+			t.Fatalf("expected 1 record, found %d", len(records))
+		}
+		r := records[0]
+		if ip, ok := r.IP("sourceIPv4Address"); !ok || ip.String() != "192.0.2.1" {
+			t.Errorf("expected sourceIPv4Address 192.0.2.1, found %v", ip)
+		}
+		if port, ok := r.Uint64("sourceTransportPort"); !ok || port != 443 {
+			t.Errorf("expected sourceTransportPort 443, found %d", port)
+		}
+		unknown, ok := r.FieldById(unregisteredPEN, 18)
+		if !ok {
+			t.Fatalf("expected unknown field in record %v", r)
+		}
+		if v, ok := unknown.Value().Value().([]byte); !ok || !bytes.Equal(v, []byte{0xde, 0xad, 0xbe, 0xef}) {
+			t.Errorf("expected opaque value deadbeef, found %v", unknown.Value())
+		}
+		if c := testutil.ToFloat64(metrics.UnknownFields.WithLabelValues("test", "99999", "18")); c != 1 {
+			t.Errorf("expected 1 unknown field, found %v", c)
+		}
+	})
+
+	t.Run("basicList of unknown fields", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache))
+
+		b := []byte{byte(SemanticAllOf)}
+		b = binary.BigEndian.AppendUint16(b, 0x8000|18)
+		b = binary.BigEndian.AppendUint16(b, 2)
+		b = binary.BigEndian.AppendUint32(b, unregisteredPEN)
+		b = append(b, 0x00, 0x01, 0x00, 0x02)
+
+		bl := &BasicList{
+			length:       uint16(len(b)),
+			fieldManager: decoder.fieldCache,
+		}
+		_, err := bl.Decode(bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bl.Elements()) != 2 {
+			t.Fatalf("expected 2 elements, found %d", len(bl.Elements()))
+		}
+		for i, el := range bl.Elements() {
+			if el.Name() != "unknown(99999/18)" {
+				t.Errorf("expected element name %s, found %s", "unknown(99999/18)", el.Name())
+			}
+			if v := el.Value().Value().([]byte); !bytes.Equal(v, []byte{0x00, byte(i + 1)}) {
+				t.Errorf("expected element value %v, found %v", []byte{0x00, byte(i + 1)}, v)
+			}
+		}
+	})
+
+	t.Run("message with basicList of unknown fields", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		metrics := NewMetrics()
+		err := metrics.Register(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache)).WithMetrics(metrics, "test")
+
+		// template 256 of a basicList and sourceTransportPort
+		template := binary.BigEndian.AppendUint16(nil, 256)
+		template = binary.BigEndian.AppendUint16(template, 2)
+		template = binary.BigEndian.AppendUint16(template, 291)
+		template = binary.BigEndian.AppendUint16(template, VariableLength)
+		template = binary.BigEndian.AppendUint16(template, 7)
+		template = binary.BigEndian.AppendUint16(template, 2)
+		_, err = decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(1700000000, 0, IPFIX, template)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a basicList of two elements of the unregistered enterprise-specific field 99999/18
+		list := []byte{byte(SemanticAllOf)}
+		list = binary.BigEndian.AppendUint16(list, 0x8000|18)
+		list = binary.BigEndian.AppendUint16(list, 2)
+		list = binary.BigEndian.AppendUint32(list, unregisteredPEN)
+		list = append(list, 0x00, 0x01, 0x00, 0x02)
+		record := append([]byte{byte(len(list))}, list...)
+		record = append(record, 0x01, 0xbb)
+
+		m, err := decoder.Decode(ctx, bytes.NewBuffer(newTestCollectorMessage(1700000001, 0, 256, record)))
+		if err != nil {
+			t.Fatalf("expected unknown fields in basicList not to fail the message, got %v", err)
+		}
+		records := m.Sets[0].Set.(*DataSet).Records
+		if len(records) != 1 {
+			t.Fatalf("expected 1 record, found %d", len(records))
+		}
+		if port, ok := records[0].Uint64("sourceTransportPort"); !ok || port != 443 {
+			t.Errorf("expected sourceTransportPort 443 following the list, found %d", port)
+		}
+		bl, ok := records[0].Fields[0].Value().(*BasicList)
+		if !ok || len(bl.Elements()) != 2 || bl.Elements()[0].Type() != "octetArray" {
+			t.Errorf("expected basicList of 2 opaque elements, found %v", records[0].Fields[0])
+		}
+		if c := testutil.ToFloat64(metrics.UnknownFields.WithLabelValues("test", "99999", "18")); c != 2 {
+			t.Errorf("expected an unknown field per list element, found %v", c)
+		}
+	})
+
+	t.Run("unknown fields exceeding the cap are labeled other", func(t *testing.T) {
+		metrics := NewMetrics(WithUnknownFieldLabels(2))
+		err := metrics.Register(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []uint16{18, 19, 20, 21, 18} {
+			metrics.unknownFields("test", NewFieldKey(unregisteredPEN, id)).Inc()
+		}
+		for _, c := range []struct {
+			pen, id  string
+			expected float64
+		}{
+			{"99999", "18", 2},
+			{"99999", "19", 1},
+			{"other", "other", 2},
+		} {
+			if v := testutil.ToFloat64(metrics.UnknownFields.WithLabelValues("test", c.pen, c.id)); v != c.expected {
+				t.Errorf("expected %v occurrences of %s/%s, found %v", c.expected, c.pen, c.id, v)
+			}
+		}
+		if c := testutil.CollectAndCount(metrics.UnknownFields); c != 3 {
+			t.Errorf("expected 3 series, found %d", c)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		templateCache := NewDefaultEphemeralCache()
+		decoder := NewDecoder(templateCache, NewIANAFieldManager(templateCache), DecoderOptions{
+			StrictUnknownFields: true,
+		})
+
+		tr := TemplateRecord{
+			fieldCache:    decoder.fieldCache,
+			templateCache: templateCache,
+		}
+		_, err := tr.Decode(bytes.NewBuffer(newUnknownFieldTemplate(256)))
+		if !errors.Is(err, ErrUnknownField) {
+			t.Errorf("expected %v, found %v", ErrUnknownField, err)
+		}
+	})
+}
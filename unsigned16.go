@@ -46,14 +46,22 @@ func (t *Unsigned16) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers of any width and whole floating-point numbers as value, given they
+// are in the range of uint16.
+func (t *Unsigned16) SetValueE(v any) (DataType, error) {
+	u, err := unsignedFromAny(v, 16)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = uint16(u)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Unsigned16) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = uint16(ty)
-	case int:
-		t.value = uint16(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -71,7 +79,9 @@ func (t *Unsigned16) DefaultLength() uint16 {
 
 func (t *Unsigned16) Clone() DataType {
 	return &Unsigned16{
-		value: t.value,
+		value:         t.value,
+		reducedLength: t.reducedLength,
+		length:        t.length,
 	}
 }
 
@@ -103,8 +113,10 @@ func (t *Unsigned16) IsReducedLength() bool {
 }
 
 func (t *Unsigned16) Decode(in io.Reader) (n int, err error) {
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -114,12 +126,12 @@ func (t *Unsigned16) Decode(in io.Reader) (n int, err error) {
 		return
 	}
 	offset := t.DefaultLength() - t.Length()
-	c := make([]byte, t.DefaultLength())
+	var c [2]byte
 	// abusing golangs initialization of values with 0 here
 	for i := uint16(0); i < t.length; i++ {
 		c[i+offset] = b[i]
 	}
-	t.value = binary.BigEndian.Uint16(c)
+	t.value = binary.BigEndian.Uint16(c[:])
 	return
 }
 
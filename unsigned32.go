@@ -48,14 +48,22 @@ func (t *Unsigned32) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers of any width and whole floating-point numbers as value, given they
+// are in the range of uint32.
+func (t *Unsigned32) SetValueE(v any) (DataType, error) {
+	u, err := unsignedFromAny(v, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = uint32(u)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Unsigned32) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = uint32(ty)
-	case int:
-		t.value = uint32(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -73,7 +81,9 @@ func (*Unsigned32) DefaultLength() uint16 {
 
 func (t *Unsigned32) Clone() DataType {
 	return &Unsigned32{
-		value: t.value,
+		value:         t.value,
+		reducedLength: t.reducedLength,
+		length:        t.length,
 	}
 }
 
@@ -105,8 +115,10 @@ func (t *Unsigned32) IsReducedLength() bool {
 }
 
 func (t *Unsigned32) Decode(in io.Reader) (n int, err error) {
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -118,12 +130,12 @@ func (t *Unsigned32) Decode(in io.Reader) (n int, err error) {
 	// because reduced-length encoding still preserves BigEndian, we pad the
 	// internal uint32
 	offset := t.DefaultLength() - t.Length()
-	c := make([]byte, t.DefaultLength())
+	var c [4]byte
 	// abusing golangs initialization of values with 0 here
 	for i := uint16(0); i < t.length; i++ {
 		c[i+offset] = b[i]
 	}
-	t.value = binary.BigEndian.Uint32(c)
+	t.value = binary.BigEndian.Uint32(c[:])
 	return
 }
 
@@ -46,14 +46,22 @@ func (t *Unsigned64) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers of any width and whole floating-point numbers as value, given they
+// are in the range of uint64.
+func (t *Unsigned64) SetValueE(v any) (DataType, error) {
+	u, err := unsignedFromAny(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = uint64(u)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Unsigned64) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = uint64(ty)
-	case int:
-		t.value = uint64(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -71,7 +79,9 @@ func (*Unsigned64) DefaultLength() uint16 {
 
 func (t *Unsigned64) Clone() DataType {
 	return &Unsigned64{
-		value: t.value,
+		value:         t.value,
+		reducedLength: t.reducedLength,
+		length:        t.length,
 	}
 }
 
@@ -104,8 +114,10 @@ func (t *Unsigned64) IsReducedLength() bool {
 
 func (t *Unsigned64) Decode(in io.Reader) (n int, err error) {
 	// allocate a buffer of the (possibly reduced) length of the data type
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
@@ -117,12 +129,12 @@ func (t *Unsigned64) Decode(in io.Reader) (n int, err error) {
 	// because reduced-length encoding still preserves BigEndian, we pad the
 	// internal uint64
 	offset := t.DefaultLength() - t.Length()
-	c := make([]byte, t.DefaultLength())
+	var c [8]byte
 	// abusing golangs initialization of values with 0 here
 	for i := uint16(0); i < t.length; i++ {
 		c[i+offset] = b[i]
 	}
-	t.value = binary.BigEndian.Uint64(c)
+	t.value = binary.BigEndian.Uint64(c[:])
 	return
 }
 
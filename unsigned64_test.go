@@ -70,5 +70,18 @@ func TestUnsigned64(t *testing.T) {
 		t.Run("1-byte", func(t *testing.T) {
 
 		})
+		t.Run("clone", func(t *testing.T) {
+			c := NewUnsigned64().WithLength(4)().Clone()
+			if !c.IsReducedLength() || c.Length() != 4 {
+				t.Fatalf("expected clone to keep reduced length 4, got %d", c.Length())
+			}
+			_, err := c.Decode(bytes.NewBuffer([]byte{0x00, 0x00, 0x05, 0xdc, 0xff}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Value().(uint64) != 1500 {
+				t.Errorf("expected value to be 1500, found %d", c.Value().(uint64))
+			}
+		})
 	})
 }
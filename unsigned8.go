@@ -42,14 +42,22 @@ func (t *Unsigned8) Value() interface{} {
 	return t.value
 }
 
+// SetValueE sets Go integers of any width and whole floating-point numbers as value, given they
+// are in the range of uint8.
+func (t *Unsigned8) SetValueE(v any) (DataType, error) {
+	u, err := unsignedFromAny(v, 8)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set value in %T, %w", t, err)
+	}
+	t.value = uint8(u)
+	return t, nil
+}
+
+// SetValue sets v as value, see SetValueE. SetValue panics for values that SetValueE returns an
+// error for.
 func (t *Unsigned8) SetValue(v any) DataType {
-	switch ty := v.(type) {
-	case float64:
-		t.value = uint8(ty)
-	case int:
-		t.value = uint8(ty)
-	default:
-		panic(fmt.Errorf("%T cannot be asserted to %T", v, t.value))
+	if _, err := t.SetValueE(v); err != nil {
+		panic(err)
 	}
 	return t
 }
@@ -82,8 +90,10 @@ func (*Unsigned8) IsReducedLength() bool {
 }
 
 func (t *Unsigned8) Decode(in io.Reader) (n int, err error) {
-	b := make([]byte, t.Length())
-	n, err = in.Read(b)
+	p := getScratch(int(t.Length()))
+	defer putScratch(p)
+	b := *p
+	n, err = io.ReadFull(in, b)
 	if err != nil {
 		return n, fmt.Errorf("failed to read data in %T, %w", t, err)
 	}
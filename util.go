@@ -15,3 +15,43 @@ limitations under the License.
 */
 
 package ipfix
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// marshalSortedJSON marshals m as a JSON object with its entries ordered by less rather than by
+// the string form of their keys, such that the output of caches is stable and follows the
+// numerical order of keys, e.g., for golden files and content-addressed storage.
+func marshalSortedJSON[K comparable, V any](m map[K]V, less func(a, b K) bool, key func(K) string) ([]byte, error) {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return less(keys[i], keys[j])
+	})
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key(k))
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Alexander Bartolomey (github@alexanderbartolomey.de)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfix
+
+import (
+	"fmt"
+	"math"
+)
+
+// ValueSetter is implemented by all DataTypes of this package. SetValueE is the non-panicking
+// variant of DataType.SetValue, returning an error wrapping ErrInvalidValue for values of
+// unsupported types or out of range of the data type. Custom DataTypes registered with
+// RegisterDataType may implement it as well, see SetValueE.
+type ValueSetter interface {
+	SetValueE(v any) (DataType, error)
+}
+
+// SetValueE sets v on dt. If dt implements ValueSetter, SetValueE is used, otherwise the panic of
+// dt's SetValue is returned as error.
+func SetValueE(dt DataType, v any) (_ DataType, err error) {
+	if vs, ok := dt.(ValueSetter); ok {
+		return vs.SetValueE(v)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w, %v", ErrInvalidValue, r)
+		}
+	}()
+	return dt.SetValue(v), nil
+}
+
+// unsignedFromAny converts Go integers and whole floating-point numbers to an unsigned integer of
+// the given number of bits. Values out of range return an error wrapping ErrInvalidValue.
+func unsignedFromAny(v any, bits int) (uint64, error) {
+	max := uint64(math.MaxUint64) >> (64 - bits)
+	var u uint64
+	switch n := v.(type) {
+	case int, int8, int16, int32, int64:
+		i, _ := signedFromAny(n, 64)
+		if i < 0 {
+			return 0, fmt.Errorf("%w, %d is negative", ErrInvalidValue, i)
+		}
+		u = uint64(i)
+	case uint:
+		u = uint64(n)
+	case uint8:
+		u = uint64(n)
+	case uint16:
+		u = uint64(n)
+	case uint32:
+		u = uint64(n)
+	case uint64:
+		u = n
+	case float32:
+		return unsignedFromAny(float64(n), bits)
+	case float64:
+		if n != math.Trunc(n) || n < 0 || n >= math.Ldexp(1, bits) {
+			return 0, fmt.Errorf("%w, %v is not in the range of %d-bit unsigned integers", ErrInvalidValue, n, bits)
+		}
+		return uint64(n), nil
+	default:
+		return 0, fmt.Errorf("%w, %T is not an integer", ErrInvalidValue, v)
+	}
+	if u > max {
+		return 0, fmt.Errorf("%w, %d is not in the range of %d-bit unsigned integers", ErrInvalidValue, u, bits)
+	}
+	return u, nil
+}
+
+// signedFromAny converts Go integers and whole floating-point numbers to a signed integer of the
+// given number of bits. Values out of range return an error wrapping ErrInvalidValue.
+func signedFromAny(v any, bits int) (int64, error) {
+	min, max := int64(math.MinInt64)>>(64-bits), int64(math.MaxInt64)>>(64-bits)
+	var i int64
+	switch n := v.(type) {
+	case int:
+		i = int64(n)
+	case int8:
+		i = int64(n)
+	case int16:
+		i = int64(n)
+	case int32:
+		i = int64(n)
+	case int64:
+		i = n
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := unsignedFromAny(n, 64)
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("%w, %d is not in the range of %d-bit signed integers", ErrInvalidValue, u, bits)
+		}
+		i = int64(u)
+	case float32:
+		return signedFromAny(float64(n), bits)
+	case float64:
+		if n != math.Trunc(n) || n < -math.Ldexp(1, bits-1) || n >= math.Ldexp(1, bits-1) {
+			return 0, fmt.Errorf("%w, %v is not in the range of %d-bit signed integers", ErrInvalidValue, n, bits)
+		}
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("%w, %T is not an integer", ErrInvalidValue, v)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("%w, %d is not in the range of %d-bit signed integers", ErrInvalidValue, i, bits)
+	}
+	return i, nil
+}
+
+// floatFromAny converts Go integers and floating-point numbers to float64
+func floatFromAny(v any) (float64, error) {
+	switch n := v.(type) {
+	case float32:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case int, int8, int16, int32, int64:
+		i, _ := signedFromAny(n, 64)
+		return float64(i), nil
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := unsignedFromAny(n, 64)
+		return float64(u), nil
+	default:
+		return 0, fmt.Errorf("%w, %T is not a number", ErrInvalidValue, v)
+	}
+}
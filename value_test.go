@@ -0,0 +1,195 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetValueE(t *testing.T) {
+	ts := time.Date(2023, 5, 23, 16, 19, 11, 989742790, time.UTC)
+
+	tests := []struct {
+		constructor DataTypeConstructor
+		value       any
+		// expected is compared to the data type's value by its string representation, nil
+		// expects an error
+		expected any
+	}{
+		{NewUnsigned8, uint8(1), uint8(1)},
+		{NewUnsigned8, 255, uint8(255)},
+		{NewUnsigned8, int64(17), uint8(17)},
+		{NewUnsigned8, uint64(17), uint8(17)},
+		{NewUnsigned8, float64(6), uint8(6)},
+		{NewUnsigned8, 256, nil},
+		{NewUnsigned8, -1, nil},
+		{NewUnsigned8, 1.5, nil},
+		{NewUnsigned8, "1", nil},
+		{NewUnsigned16, uint16(443), uint16(443)},
+		{NewUnsigned16, uint8(80), uint16(80)},
+		{NewUnsigned16, int32(65535), uint16(65535)},
+		{NewUnsigned16, 65536, nil},
+		{NewUnsigned32, uint32(math.MaxUint32), uint32(math.MaxUint32)},
+		{NewUnsigned32, uint(1), uint32(1)},
+		{NewUnsigned32, int64(math.MaxUint32) + 1, nil},
+		{NewUnsigned64, uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{NewUnsigned64, int8(1), uint64(1)},
+		{NewUnsigned64, float32(1024), uint64(1024)},
+		{NewUnsigned64, math.Ldexp(1, 64), nil},
+		{NewUnsigned64, int8(-1), nil},
+		{NewSigned8, int8(-128), int8(-128)},
+		{NewSigned8, uint8(127), int8(127)},
+		{NewSigned8, -129, nil},
+		{NewSigned8, uint8(128), nil},
+		{NewSigned16, -32768, int16(-32768)},
+		{NewSigned16, float64(-2), int16(-2)},
+		{NewSigned16, 32768, nil},
+		{NewSigned32, int16(-1), int32(-1)},
+		{NewSigned32, int64(math.MinInt32) - 1, nil},
+		{NewSigned64, int64(math.MinInt64), int64(math.MinInt64)},
+		{NewSigned64, uint32(1), int64(1)},
+		{NewSigned64, uint64(math.MaxInt64) + 1, nil},
+		{NewSigned64, true, nil},
+		{NewFloat32, float32(1.5), float32(1.5)},
+		{NewFloat32, 2, float32(2)},
+		{NewFloat32, math.Inf(1), float32(math.Inf(1))},
+		{NewFloat32, math.MaxFloat64, nil},
+		{NewFloat32, "1.5", nil},
+		{NewFloat64, float32(0.25), float64(0.25)},
+		{NewFloat64, uint64(3), float64(3)},
+		{NewFloat64, net.IP{}, nil},
+		{NewBoolean, true, true},
+		{NewBoolean, 2, false},
+		{NewBoolean, uint8(1), true},
+		{NewBoolean, float64(1), true},
+		{NewBoolean, 0, nil},
+		{NewBoolean, "true", nil},
+		{NewIPv4Address, "192.0.2.1", "192.0.2.1"},
+		{NewIPv4Address, net.ParseIP("192.0.2.1"), "192.0.2.1"},
+		{NewIPv4Address, netip.MustParseAddr("::ffff:192.0.2.1"), "192.0.2.1"},
+		{NewIPv4Address, []byte{192, 0, 2, 1}, "192.0.2.1"},
+		{NewIPv4Address, "2001:db8::1", nil},
+		{NewIPv4Address, netip.MustParseAddr("2001:db8::1"), nil},
+		{NewIPv4Address, "not an address", nil},
+		{NewIPv4Address, []byte{192, 0, 2}, nil},
+		{NewIPv4Address, 3221225985, nil},
+		{NewIPv6Address, "2001:db8::1", "2001:db8::1"},
+		{NewIPv6Address, net.ParseIP("2001:db8::1"), "2001:db8::1"},
+		{NewIPv6Address, netip.MustParseAddr("2001:db8::1"), "2001:db8::1"},
+		{NewIPv6Address, net.IPv4(192, 0, 2, 1).To4(), "192.0.2.1"},
+		{NewIPv6Address, netip.Addr{}, nil},
+		{NewIPv6Address, []byte{0x20, 0x01}, nil},
+		{NewMacAddress, "00:00:5e:00:53:01", "00:00:5e:00:53:01"},
+		{NewMacAddress, net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}, "00:00:5e:00:53:01"},
+		{NewMacAddress, []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}, "00:00:5e:00:53:01"},
+		{NewMacAddress, "02:00:5e:10:00:00:00:01", nil},
+		{NewMacAddress, []byte{0x00}, nil},
+		{NewMacAddress, 1, nil},
+		{NewOctetArray, []byte{0xca, 0xfe}, []byte{0xca, 0xfe}},
+		{NewOctetArray, "yv4=", []byte{0xca, 0xfe}},
+		{NewOctetArray, "not base64!", nil},
+		{NewOctetArray, make([]byte, math.MaxUint16+1), nil},
+		{NewOctetArray, 1, nil},
+		{NewString, "example.com", "example.com"},
+		{NewString, []byte("example.com"), "example.com"},
+		{NewString, strings.Repeat("a", math.MaxUint16+1), nil},
+		{NewString, 1, nil},
+		{NewDateTimeSeconds, ts, ts.Truncate(time.Second)},
+		{NewDateTimeSeconds, ts.Format(time.RFC3339Nano), ts.Truncate(time.Second)},
+		{NewDateTimeSeconds, "yesterday", nil},
+		{NewDateTimeSeconds, ts.Unix(), nil},
+		{NewDateTimeMilliseconds, ts, ts.Truncate(time.Millisecond)},
+		{NewDateTimeMicroseconds, ts.In(time.FixedZone("CEST", 7200)), ts},
+		{NewDateTimeNanoseconds, ts, ts},
+		{NewDateTimeNanoseconds, 1, nil},
+		{NewBasicList, []string{"a"}, nil},
+		{NewDefaultSubTemplateList, []DataRecord{}, []DataRecord{}},
+		{NewDefaultSubTemplateList, []Field{}, nil},
+		{NewDefaultSubTemplateMultiList, []DataRecord{}, nil},
+	}
+
+	for _, tc := range tests {
+		dt := tc.constructor()
+		t.Run(fmt.Sprintf("%s from %T %v", dt.Type(), tc.value, tc.value), func(t *testing.T) {
+			_, err := dt.(ValueSetter).SetValueE(tc.value)
+			if tc.expected == nil {
+				if !errors.Is(err, ErrInvalidValue) {
+					t.Errorf("expected ErrInvalidValue, got %v", err)
+				}
+				// SetValue keeps panicking for compatibility
+				defer func() {
+					if r := recover(); r == nil {
+						t.Error("expected SetValue to panic")
+					}
+				}()
+				tc.constructor().SetValue(tc.value)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual, expected := fmt.Sprint(dt.Value()), fmt.Sprint(tc.expected); actual != expected {
+				t.Errorf("expected %s, got %s", expected, actual)
+			}
+		})
+	}
+
+	t.Run("basicList", func(t *testing.T) {
+		bl := NewBasicList().(*BasicList)
+		_, err := bl.SetValueE([]DataType{NewUnsigned16().SetValue(80), NewUnsigned16().SetValue(443)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bl.Elements()) != 2 {
+			t.Errorf("expected 2 elements, got %d", len(bl.Elements()))
+		}
+		_, err = bl.SetValueE([]DataType{NewUnsigned16().SetValue(80), NewString().SetValue("http")})
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("expected ErrInvalidValue, got %v", err)
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		ie := iana()[7]
+		f := NewFieldBuilder(ie).SetLength(2).Complete()
+		if _, err := f.SetValueE(uint16(443)); err != nil {
+			t.Fatal(err)
+		}
+		if v := f.Value().Value(); v != uint16(443) {
+			t.Errorf("expected 443, got %v", v)
+		}
+		_, err := f.SetValueE(70000)
+		if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), ie.Name) {
+			t.Errorf("expected ErrInvalidValue naming the field, got %v", err)
+		}
+
+		vf := NewFieldBuilder(iana()[82]).SetLength(VariableLength).Complete()
+		if _, err := vf.SetValueE("eth0"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vf.SetValueE(0); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("expected ErrInvalidValue, got %v", err)
+		}
+	})
+
+	t.Run("custom data types without SetValueE", func(t *testing.T) {
+		_, err := SetValueE(&panickingDataType{DataType: NewUnsigned8()}, 1)
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("expected panic of SetValue to be returned as ErrInvalidValue, got %v", err)
+		}
+	})
+}
+
+// panickingDataType resembles a custom data type implementing only SetValue
+type panickingDataType struct {
+	DataType
+}
+
+func (*panickingDataType) SetValue(v any) DataType {
+	panic("unsupported value")
+}
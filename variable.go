@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+
+	"gopkg.in/yaml.v3"
 )
 
 var _ json.Marshaler = &VariableLengthField{}
@@ -52,6 +54,12 @@ type VariableLengthField struct {
 	decoded bool
 
 	prototype *InformationElement
+
+	// unknown is set for fields created by a builder of NewUnknownFieldBuilder
+	unknown bool
+
+	// raw is set for fields decoded with DecoderOptions.LazyFieldValues
+	raw rawValue
 }
 
 // Variable-length fields are already encoded as such, just return the field
@@ -91,6 +99,9 @@ func (f *VariableLengthField) Prototype() *InformationElement {
 	return f.prototype
 }
 
+// Decode decodes the field's length prefix and value from r. When decoding with
+// DecoderOptions.LazyFieldValues, the bytes of the value are retained, and decoding octetArray and
+// string values is deferred until their value is accessed.
 func (f *VariableLengthField) Decode(r io.Reader) (int, error) {
 	f.initializeValue()
 	defer func() {
@@ -101,8 +112,12 @@ func (f *VariableLengthField) Decode(r io.Reader) (int, error) {
 	var shortLength uint8
 	var longLength uint16
 
-	b := make([]byte, 1)
-	n, err := r.Read(b)
+	// length prefixes and values are read into pooled scratch buffers, the DataType decoder
+	// copies the bytes it retains
+	p := getScratch(2)
+	defer putScratch(p)
+	b := (*p)[:1]
+	n, err := io.ReadFull(r, b)
 	if err != nil {
 		return n, err
 	}
@@ -111,8 +126,8 @@ func (f *VariableLengthField) Decode(r io.Reader) (int, error) {
 	if shortLength == 0xFF {
 		f.longLengthFormat = true
 		// read two more bytes denoting a length up to 2^16 bytes
-		b := make([]byte, 2)
-		m, err := r.Read(b)
+		b := *p
+		m, err := io.ReadFull(r, b)
 		n += m
 		if err != nil {
 			return n, err
@@ -124,52 +139,83 @@ func (f *VariableLengthField) Decode(r io.Reader) (int, error) {
 	}
 	f.length = length
 
-	buf := make([]byte, length)
-	m, err := r.Read(buf)
+	q := getScratch(int(length))
+	defer putScratch(q)
+	// a short read would otherwise leave stale bytes of the pooled buffer in the value
+	m, err := io.ReadFull(r, *q)
 	n += m
 	if err != nil {
 		return n, err
 	}
 
-	// already "read" the number of bytes passed down to the DataType decoder so no need to add it again
-	_, err = f.value.
-		SetLength(length).           // set the decoded length here, such that the subsequent DataType level decoder consumes the right amount of bytes
-		Decode(bytes.NewBuffer(buf)) // hand down a new buffer such that the parsing cannot overflow the original buffer
-	return n, err
-}
-
-func (f *VariableLengthField) Encode(w io.Writer) (int, error) {
-	var b []byte
-	// do our own length calculation to not run into edge cases of adding static offset, exceeding the
-	// long/short-form threshold and then doing the wrong decision *here*
-	var length uint16
-	if f.value == nil {
-		// we don't know yet, so return "variable-length"
-		length = 0xFFFF
-	}
-	ll := f.value.Length() // length of the things "in" the variable-length field
-	if ll == 0 && !f.decoded {
-		length = 0xFFFF
-	} else {
-		length = ll
+	state, depth := decodeStateOf(r)
+	if state.lazy() {
+		// the scratch buffer is reused, so the retained bytes are copied
+		f.raw = rawValue{bytes: append(make([]byte, 0, length), *q...), pending: deferrable(f.value)}
+		if f.raw.pending {
+			f.value.SetLength(length)
+			return n, nil
+		}
 	}
-	if ll >= 255 || f.longLengthFormat {
-		b = []byte{0xFF}
-		b = binary.BigEndian.AppendUint16(b, length)
-	} else {
-		b = []byte{byte(uint8(length))}
+
+	// hand down a reader of the value only, such that the parsing cannot overflow into the
+	// subsequent fields. The scratch buffer outlives the decoding, so it is not copied again.
+	var vr io.Reader = bytes.NewReader(*q)
+	// structured data types need the decodeState of the message carried by r for enforcing limits
+	if isStructured(f.value) {
+		vr = state.nest(vr, depth)
 	}
 
-	n, err := w.Write(b)
+	// already "read" the number of bytes passed down to the DataType decoder so no need to add it again
+	m, err = f.value.
+		SetLength(length). // set the decoded length here, such that the subsequent DataType level decoder consumes the right amount of bytes
+		Decode(vr)
 	if err != nil {
 		return n, err
 	}
+	if m != int(length) {
+		return n, malformedMessage(n, "%T of field %s consumed %d of %d bytes", f.value, f.Name(), m, length)
+	}
+	return n, nil
+}
+
+// decodeRaw decodes the retained bytes of a lazily decoded field into its value. Only data types
+// that cannot fail decoding are deferred, so there is no error to return.
+func (f *VariableLengthField) decodeRaw() {
+	if !f.raw.pending {
+		return
+	}
+	f.raw.pending = false
+	_, _ = f.value.Decode(bytes.NewReader(f.raw.bytes))
+}
+
+// RawBytes returns the bytes of the field's value as received, without the length prefix, if the
+// field was decoded with DecoderOptions.LazyFieldValues, and nil otherwise. The bytes are not updated
+// by SetValue and must not be modified.
+func (f *VariableLengthField) RawBytes() []byte {
+	return f.raw.bytes
+}
+
+// Encode writes the field's value to w, prefixed by its length. The length prefix is derived from the
+// encoded value rather than from the DataType's Length, such that it always matches the written bytes.
+// If the field has no value, Encode writes a length of zero. Fields decoded with
+// DecoderOptions.LazyFieldValues whose value was neither accessed nor set write the bytes they were
+// decoded from, in the length format they were received in.
+func (f *VariableLengthField) Encode(w io.Writer) (int, error) {
+	if f.raw.retained() {
+		return writeVariableLength(w, f.raw.bytes, f.longLengthFormat)
+	}
+	value := &bytes.Buffer{}
 	if f.value != nil {
-		nn, err := f.value.Encode(w)
-		return n + nn, err
-	} else {
-		return n, err
+		_, err := f.value.Encode(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if value.Len() > 0xFFFF {
+		return 0, fmt.Errorf("failed to encode %s, value length %d exceeds maximum of variable-length fields", f.Name(), value.Len())
 	}
+	return writeVariableLength(w, value.Bytes(), f.longLengthFormat)
 }
 
 func (f *VariableLengthField) initializeValue() {
@@ -190,32 +236,49 @@ func (f *VariableLengthField) initializeValue() {
 
 func (f *VariableLengthField) Value() DataType {
 	f.initializeValue()
-
+	f.decodeRaw()
+	// the returned value can be modified, so lazily decoded fields are encoded from it afterwards
+	f.raw.touched = true
 	return f.value
 }
 
 func (f *VariableLengthField) Reversible() bool {
-	_, nonReversible := NonReversibleFields[f.id]
-	return !nonReversible
+	return reversibleIE(f.pen, f.id)
 }
 
 func (f *VariableLengthField) Reversed() bool {
 	return f.reversed
 }
 
+// SetValue sets v on the field's data type, see SetValueE. SetValue panics for values that
+// SetValueE returns an error for.
 func (f *VariableLengthField) SetValue(v any) Field {
+	if _, err := f.SetValueE(v); err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// SetValueE sets v on the field's data type, or replaces the data type if v is a DataType itself.
+// Values of unsupported types or out of range of the data type return an error wrapping
+// ErrInvalidValue.
+func (f *VariableLengthField) SetValueE(v any) (Field, error) {
 	// if the value implements DataType, set the field's value directly and return
 	dt, ok := v.(DataType)
 	if ok {
 		f.value = dt
-		return f
+		f.raw.pending, f.raw.touched = false, true
+		return f, nil
 	}
 
-	// otherwise, call setValue on the field's data type (and constructing it first, if not done yet)
+	// otherwise, set the value on the field's data type (and constructing it first, if not done yet)
 	f.initializeValue()
-
-	f.value.SetValue(v)
-	return f
+	f.raw.pending, f.raw.touched = false, true
+	_, err := SetValueE(f.value, v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set value of field %s, %w", f.Name(), err)
+	}
+	return f, nil
 }
 
 func (f *VariableLengthField) Length() uint16 {
@@ -256,12 +319,11 @@ func (f *VariableLengthField) IsScope() bool {
 }
 
 func (f *VariableLengthField) consolidate() consolidatedField {
-	pen := f.pen
-	if f.reversed {
-		pen = ReversePEN
-	}
+	// reversed fields are consolidated with the key of their reverse IE
+	key := wireKey(f)
+	pen := key.EnterpriseId
 	cf := consolidatedField{
-		Id:                  f.Id(),
+		Id:                  key.Id,
 		Name:                f.Name(), // this *can* include "reversed", which is then (partially) used by Restore to fully restore the semantics
 		IsVariableLength:    true,
 		Length:              0xFFFF,
@@ -270,6 +332,7 @@ func (f *VariableLengthField) consolidate() consolidatedField {
 		Type:                f.Type(),
 		IsScope:             f.IsScope(),
 	}
+	f.decodeRaw()
 	if f.value != nil {
 		encValue, _ := json.Marshal(f.value)
 		bb := json.RawMessage(encValue)
@@ -289,7 +352,11 @@ func (f *VariableLengthField) UnmarshalJSON(in []byte) error {
 	if err != nil {
 		return err
 	}
-	tvlf, ok := cf.restore(f.fieldManager, f.templateManager).(*VariableLengthField)
+	restored, err := cf.restore(f.fieldManager, f.templateManager)
+	if err != nil {
+		return err
+	}
+	tvlf, ok := restored.(*VariableLengthField)
 	if !ok {
 		return fmt.Errorf("could not unmarshal field to variable length field")
 	}
@@ -297,6 +364,33 @@ func (f *VariableLengthField) UnmarshalJSON(in []byte) error {
 	return nil
 }
 
+func (f *VariableLengthField) MarshalYAML() (interface{}, error) {
+	cf := f.consolidate()
+	return cf.yaml()
+}
+
+func (f *VariableLengthField) UnmarshalYAML(node *yaml.Node) error {
+	yf := &yamlField{}
+	err := node.Decode(yf)
+	if err != nil {
+		return err
+	}
+	cf, err := yf.consolidated()
+	if err != nil {
+		return err
+	}
+	restored, err := cf.restore(f.fieldManager, f.templateManager)
+	if err != nil {
+		return err
+	}
+	t, ok := restored.(*VariableLengthField)
+	if !ok {
+		return fmt.Errorf("could not unmarshal field to variable length field")
+	}
+	*f = *t
+	return nil
+}
+
 func (f *VariableLengthField) Clone() Field {
 	var ndt DataType
 	if dt := f.value; dt != nil {
@@ -317,14 +411,17 @@ func (f *VariableLengthField) Clone() Field {
 		value:           ndt,
 		constructor:     f.constructor,
 		prototype:       f.prototype,
+		unknown:         f.unknown,
 		fieldManager:    f.fieldManager,
 		templateManager: f.templateManager,
+		raw:             f.raw,
 	}
 }
 
 func (f *VariableLengthField) String() string {
 	val := "nil"
 
+	f.decodeRaw()
 	if f.value != nil {
 		val = fmt.Sprintf("<%s>\"%s\"", f.value.Type(), f.value.String())
 	} else if f.constructor != nil {
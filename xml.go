@@ -18,6 +18,7 @@ package ipfix
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -35,6 +36,14 @@ func MustReadXML(r io.Reader) map[uint16]InformationElement {
 }
 
 func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
+	m, _, err := readXML(r)
+	return m, err
+}
+
+// readXML parses an XML information element registry as used by CERT for yaf's IEs.
+// Next to the information elements, it returns the set of ids marked as reversible by the
+// registry's cert:reversible node.
+func readXML(r io.Reader) (map[uint16]InformationElement, map[uint16]struct{}, error) {
 	type yafIERecord struct {
 		Name string `xml:"name"`
 		// colons are XML namespaces, which are denoted as spaces in struct tags
@@ -46,7 +55,7 @@ func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
 		Group        *string            `xml:"group"`
 		Revision     *int               `xml:"revision"`
 		Status       status.Status      `xml:"status"`
-		Semantic     semantics.Semantic `xml:"semantic"`
+		Semantic     semantics.Semantic `xml:"dataTypeSemantics"`
 		Date         *string            `xml:"date"`
 		Range        *string            `xml:"range"`
 		Units        *string            `xml:"units"`
@@ -62,16 +71,17 @@ func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
 
 	o, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	re := yafIERegistry{}
 	err = xml.Unmarshal(o, &re)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	m := make(map[uint16]InformationElement)
+	reversible := make(map[uint16]struct{})
 
 	for _, r := range re.Records {
 		field := InformationElement{
@@ -93,6 +103,11 @@ func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
 			field.Description = &d
 		}
 
+		if r.Units != nil {
+			u := normalizeUnits(*r.Units)
+			field.Units = &u
+		}
+
 		if r.Range != nil {
 			if fr := strings.Split(*r.Range, "-"); len(fr) == 2 {
 				lows, highs := fr[0], fr[1]
@@ -107,7 +122,16 @@ func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
 		}
 
 		if typ := r.DataType; typ != nil {
-			field.Constructor = LookupConstructor(*typ)
+			c, err := LookupConstructor(*typ)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read information element %s, %w", r.Id, err)
+			}
+			field.Constructor = c
+			// list types are only decoded correctly with list semantics, which the registry
+			// does not set for all of them
+			if _, isListSemantic := dataTypesWithListSemantics[*typ]; isListSemantic {
+				field.Semantics = semantics.List
+			}
 		}
 
 		if id, err := strconv.Atoi(r.Id); err != nil {
@@ -117,8 +141,11 @@ func ReadXML(r io.Reader) (map[uint16]InformationElement, error) {
 		} else {
 			field.Id = uint16(id)
 			m[uint16(id)] = field
+			if r.Reversible {
+				reversible[uint16(id)] = struct{}{}
+			}
 		}
 	}
 
-	return m, nil
+	return m, reversible, nil
 }
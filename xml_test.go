@@ -24,7 +24,7 @@ import (
 
 func TestReadXML(t *testing.T) {
 	t.Run("with file", func(t *testing.T) {
-		srcFile, _ := os.Open("./cert_ipfix.xml")
+		srcFile, _ := os.Open("./hack/cert_ipfix.xml")
 		defer srcFile.Close()
 
 		m, err := ReadXML(srcFile)
@@ -22,7 +22,7 @@ import (
 )
 
 func TestWriteYAML(t *testing.T) {
-	srcFile, _ := os.Open("./ipfix-information-elements.csv")
+	srcFile, _ := os.Open("./hack/ipfix-information-elements.csv")
 	defer srcFile.Close()
 	m, err := ReadCSV(srcFile)
 	if err != nil {
@@ -42,7 +42,7 @@ func TestWriteYAML(t *testing.T) {
 }
 
 func TestReadYAML(t *testing.T) {
-	srcFile, _ := os.Open("./ipfix-information-elements.csv")
+	srcFile, _ := os.Open("./hack/ipfix-information-elements.csv")
 	defer srcFile.Close()
 	m, err := ReadCSV(srcFile)
 	if err != nil {